@@ -80,6 +80,8 @@ the cloud specific control loops shipped with Kubernetes.`,
 		},
 	}
 	s.AddFlags(command.Flags())
+	command.Flags().StringVar(&vsphere.CloudConfigFormat, "cloud-config-format", "",
+		"The format of the cloud provider configuration file, ini or yaml. Detected by the extension of the file when empty.")
 
 	// TODO: once we switch everything over to Cobra commands, we can go back to calling
 	// utilflag.InitFlags() (by removing its pflag.Parse() call). For now, we have to set the
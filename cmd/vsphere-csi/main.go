@@ -18,6 +18,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
 
 	"github.com/rexray/gocsi"
 
@@ -27,6 +29,15 @@ import (
 
 // main is ignored when this package is built as a go plug-in.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--check-permissions" {
+		checkPermissions()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--verify" {
+		verify()
+		return
+	}
+
 	gocsi.Run(
 		context.Background(),
 		service.Name,
@@ -35,6 +46,25 @@ func main() {
 		provider.New())
 }
 
+// checkPermissions verifies the vSphere privileges of the vCenter users of
+// the cloud config and exits.
+func checkPermissions() {
+	if err := service.CheckPermissions(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("All of the required vSphere privileges are granted")
+}
+
+// verify checks the cloud config and the vCenters it configures, prints a
+// report of each vCenter and exits.
+func verify() {
+	if err := service.Verify(context.Background(), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 const usage = `    X_CSI_VSPHERE_APINAME
         Specifies the name of the API to use when talking to vCenter
 
@@ -47,7 +77,18 @@ const usage = `    X_CSI_VSPHERE_APINAME
 
     X_CSI_DISABLE_K8S_CLIENT
         Boolean flag that disables the Kubernetes API client to retrieve
-        secrets.
+        secrets. It overrides disable-k8s-client of the cloud config when
+        set.
 
         The default value is "false"
+
+    Run with --check-permissions to verify that the vCenter users of the
+    cloud config have the vSphere privileges required by the controller
+    and exit.
+
+    Run with --verify to check the cloud config without starting the
+    plugin: the credentials are resolved as the controller does, then each
+    vCenter is connected to and checked for FCD support, the privileges of
+    its user and the zones of its hosts. A report of each vCenter is
+    printed, and the exit code is 1 when any check failed.
 `
@@ -46,7 +46,7 @@ func ParseConfig(configFile string) (*config.Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Can not open config file %s, %v", configFile, err)
 	}
-	cfg, err := config.ReadConfig(f)
+	cfg, err := config.ReadConfigFormat(f, "")
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"net"
+
+	"github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+)
+
+// addressSelector selects the guest addresses of the node VMs that are
+// reported as the internal and external addresses of the nodes. The zero
+// value reports all of the addresses of the default IP family as both.
+type addressSelector struct {
+	internalNetworkName string
+	externalNetworkName string
+	internalSubnets     []*net.IPNet
+	externalSubnets     []*net.IPNet
+	// ipFamilies are the IP families of the reported addresses, in order of
+	// priority. The default IP family when empty.
+	ipFamilies []string
+}
+
+func newAddressSelector(cfg *vcfg.Config) (addressSelector, error) {
+	internalSubnets, err := vcfg.ParseCIDRs(cfg.Nodes.InternalNetworkSubnetCIDR)
+	if err != nil {
+		return addressSelector{}, err
+	}
+	externalSubnets, err := vcfg.ParseCIDRs(cfg.Nodes.ExternalNetworkSubnetCIDR)
+	if err != nil {
+		return addressSelector{}, err
+	}
+	ipFamilies, err := vcfg.ParseIPFamilies(cfg.Nodes.IPFamily)
+	if err != nil {
+		return addressSelector{}, err
+	}
+	return addressSelector{
+		internalNetworkName: cfg.Nodes.InternalNetworkName,
+		externalNetworkName: cfg.Nodes.ExternalNetworkName,
+		internalSubnets:     internalSubnets,
+		externalSubnets:     externalSubnets,
+		ipFamilies:          ipFamilies,
+	}, nil
+}
+
+// subnetsContain returns true if any of the subnets contains the IP.
+func subnetsContain(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInternal returns true if the address of a NIC on the network is an
+// internal address.
+func (s *addressSelector) isInternal(network string, ip net.IP) bool {
+	if s.internalNetworkName == "" && len(s.internalSubnets) == 0 {
+		return true
+	}
+	return (s.internalNetworkName != "" && network == s.internalNetworkName) ||
+		subnetsContain(s.internalSubnets, ip)
+}
+
+// isExternal returns true if the address of a NIC on the network is an
+// external address. Without an external network or subnet, the internal
+// addresses are the external ones.
+func (s *addressSelector) isExternal(network string, ip net.IP) bool {
+	if s.externalNetworkName == "" && len(s.externalSubnets) == 0 {
+		return s.isInternal(network, ip)
+	}
+	return (s.externalNetworkName != "" && network == s.externalNetworkName) ||
+		subnetsContain(s.externalSubnets, ip)
+}
+
+// nodeAddresses returns the addresses of a node from the guest NICs of its
+// VM, without duplicates: its internal IPs first, then its external IPs and
+// its hostname. The IPs of each type are ordered by IP family, and only the
+// IPs selected by vcfg.SelectNodeIPs are reported. The NICs that are not
+// vNICs are skipped.
+func (s *addressSelector) nodeAddresses(nics []types.GuestNicInfo, hostname string) []v1.NodeAddress {
+	var internal, external []net.IP
+	for _, nic := range nics {
+		if nic.DeviceConfigId == -1 {
+			klog.V(4).Info("Skipping device because not a vNIC")
+			continue
+		}
+		for _, addr := range nic.IpAddress {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				klog.V(4).Infof("Skipping address %s of vNIC on network %q", addr, nic.Network)
+				continue
+			}
+			if s.isInternal(nic.Network, ip) {
+				internal = append(internal, ip)
+			}
+			if s.isExternal(nic.Network, ip) {
+				external = append(external, ip)
+			}
+		}
+	}
+
+	families := s.ipFamilies
+	if len(families) == 0 {
+		families = []string{vcfg.DefaultIPFamily}
+	}
+	subnets := append(append([]*net.IPNet{}, s.internalSubnets...), s.externalSubnets...)
+	addrs := []v1.NodeAddress{}
+	for _, ip := range vcfg.SelectNodeIPs(internal, families, subnets) {
+		addrs = append(addrs, v1.NodeAddress{
+			Type:    v1.NodeInternalIP,
+			Address: ip.String(),
+		})
+	}
+	for _, ip := range vcfg.SelectNodeIPs(external, families, subnets) {
+		addrs = append(addrs, v1.NodeAddress{
+			Type:    v1.NodeExternalIP,
+			Address: ip.String(),
+		})
+	}
+	if len(addrs) > 0 && hostname != "" {
+		addrs = append(addrs, v1.NodeAddress{
+			Type:    v1.NodeHostName,
+			Address: hostname,
+		})
+	}
+	return addrs
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/api/core/v1"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+)
+
+func TestNodeAddresses(t *testing.T) {
+	internal := func(ip string) v1.NodeAddress {
+		return v1.NodeAddress{Type: v1.NodeInternalIP, Address: ip}
+	}
+	external := func(ip string) v1.NodeAddress {
+		return v1.NodeAddress{Type: v1.NodeExternalIP, Address: ip}
+	}
+	hostname := v1.NodeAddress{Type: v1.NodeHostName, Address: "node1"}
+
+	// a management, a storage and an overlay vNIC, and the docker bridge
+	nics := []types.GuestNicInfo{
+		{Network: "mgmt", DeviceConfigId: 4000, IpAddress: []string{"10.0.0.10", "fe80::250:56ff:fe9b:1", "10.0.0.10"}},
+		{Network: "storage", DeviceConfigId: 4001, IpAddress: []string{"192.168.10.10"}},
+		{Network: "overlay", DeviceConfigId: 4002, IpAddress: []string{"203.0.113.10", "169.254.0.10"}},
+		{DeviceConfigId: -1, IpAddress: []string{"172.17.0.1", "127.0.0.1"}},
+		{Network: "bridge", DeviceConfigId: 4003, IpAddress: []string{"172.17.0.2"}},
+	}
+
+	// a dual-stack management and overlay vNIC
+	dualStack := []types.GuestNicInfo{
+		{Network: "mgmt", DeviceConfigId: 4000, IpAddress: []string{"fd00::10", "fe80::250:56ff:fe9b:1", "10.0.0.10"}},
+		{Network: "overlay", DeviceConfigId: 4001, IpAddress: []string{"203.0.113.10", "2001:db8::10"}},
+	}
+
+	tests := []struct {
+		name     string
+		nodes    string
+		nics     []types.GuestNicInfo
+		expected []v1.NodeAddress
+	}{
+		{
+			name: "all the addresses by default",
+			nics: nics,
+			expected: []v1.NodeAddress{
+				internal("10.0.0.10"), internal("192.168.10.10"), internal("203.0.113.10"),
+				external("10.0.0.10"), external("192.168.10.10"), external("203.0.113.10"),
+				hostname,
+			},
+		},
+		{
+			name:  "internal network",
+			nodes: `internal-network-name = "mgmt"`,
+			nics:  nics,
+			expected: []v1.NodeAddress{
+				internal("10.0.0.10"),
+				external("10.0.0.10"),
+				hostname,
+			},
+		},
+		{
+			name: "internal and external networks",
+			nodes: `internal-network-name = "mgmt"
+external-network-name = "overlay"`,
+			nics: nics,
+			expected: []v1.NodeAddress{
+				internal("10.0.0.10"),
+				external("203.0.113.10"),
+				hostname,
+			},
+		},
+		{
+			name: "internal and external CIDRs",
+			nodes: `internal-network-subnet-cidr = "10.0.0.0/8, 192.168.0.0/16"
+external-network-subnet-cidr = "203.0.113.0/24"`,
+			nics: nics,
+			expected: []v1.NodeAddress{
+				internal("10.0.0.10"), internal("192.168.10.10"),
+				external("203.0.113.10"),
+				hostname,
+			},
+		},
+		{
+			name:  "docker bridge allowed by a CIDR",
+			nodes: `internal-network-subnet-cidr = "172.17.0.0/16"`,
+			nics:  nics,
+			expected: []v1.NodeAddress{
+				internal("172.17.0.2"),
+				external("172.17.0.2"),
+				hostname,
+			},
+		},
+		{
+			name:  "no matching network",
+			nodes: `internal-network-name = "enoent"`,
+			nics:  nics,
+		},
+		{
+			name: "IPv6 only",
+			nics: []types.GuestNicInfo{
+				{Network: "mgmt", DeviceConfigId: 4000, IpAddress: []string{"fd00::10", "fe80::10"}},
+			},
+		},
+		{
+			name:  "IPv6 family",
+			nodes: `ip-family = "ipv6"`,
+			nics:  dualStack,
+			expected: []v1.NodeAddress{
+				internal("fd00::10"), internal("2001:db8::10"),
+				external("fd00::10"), external("2001:db8::10"),
+				hostname,
+			},
+		},
+		{
+			name:  "dual-stack IPv4 first",
+			nodes: `ip-family = "[ipv4,ipv6]"`,
+			nics:  dualStack,
+			expected: []v1.NodeAddress{
+				internal("10.0.0.10"), internal("203.0.113.10"), internal("fd00::10"), internal("2001:db8::10"),
+				external("10.0.0.10"), external("203.0.113.10"), external("fd00::10"), external("2001:db8::10"),
+				hostname,
+			},
+		},
+		{
+			name: "dual-stack IPv6 first with networks",
+			nodes: `ip-family = "ipv6,ipv4"
+internal-network-name = "mgmt"
+external-network-subnet-cidr = "203.0.113.0/24, 2001:db8::/32"`,
+			nics: dualStack,
+			expected: []v1.NodeAddress{
+				internal("fd00::10"), internal("10.0.0.10"),
+				external("2001:db8::10"), external("203.0.113.10"),
+				hostname,
+			},
+		},
+		{
+			name:  "dual-stack on an IPv4 only VM",
+			nodes: `ip-family = "ipv6,ipv4"`,
+			nics:  nics[:1],
+			expected: []v1.NodeAddress{
+				internal("10.0.0.10"),
+				external("10.0.0.10"),
+				hostname,
+			},
+		},
+		{
+			name: "no guest info",
+		},
+	}
+
+	for _, test := range tests {
+		cfg, err := vcfg.ReadConfig(strings.NewReader(`
+[Global]
+server = 0.0.0.0
+user = user
+password = password
+
+[Nodes]
+` + test.nodes))
+		if err != nil {
+			t.Fatalf("%s: ReadConfig failed: %v", test.name, err)
+		}
+		selector, err := newAddressSelector(cfg)
+		if err != nil {
+			t.Fatalf("%s: newAddressSelector failed: %v", test.name, err)
+		}
+
+		addrs := selector.nodeAddresses(test.nics, "node1")
+		if len(test.expected) == 0 && len(addrs) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(addrs, test.expected) {
+			t.Errorf("%s: addresses should be %v, not %v", test.name, test.expected, addrs)
+		}
+	}
+}
@@ -38,9 +38,13 @@ const (
 	ProviderName string = "vsphere"
 )
 
+// CloudConfigFormat is the format of the cloud config, ini or yaml. It is
+// detected by the extension of the config file when empty.
+var CloudConfigFormat string
+
 func init() {
 	cloudprovider.RegisterCloudProvider(ProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
-		cfg, err := vcfg.ReadConfig(config)
+		cfg, err := vcfg.ReadConfigFormat(config, CloudConfigFormat)
 		if err != nil {
 			return nil, err
 		}
@@ -73,10 +77,20 @@ func (vs *VSphere) Initialize(clientBuilder controller.ControllerClientBuilder)
 		vs.connectionManager = connMgr
 		vs.nodeManager.connectionManager = connMgr
 
+		registerVMCacheMetrics(vs.nodeManager.vmCache)
+		vs.nodeManager.vmCache.start(connMgr)
+
 		vs.informMgr.AddNodeListener(vs.nodeAdded, vs.nodeDeleted, nil)
+		vs.informMgr.AddSecretListener(connMgr.SecretAdded, nil, connMgr.SecretUpdated)
 
 		vs.informMgr.Listen()
 
+		if vs.informMgr.WaitForSecretsSynced() {
+			if err := connMgr.VerifyCredentialSecrets(); err != nil {
+				klog.Errorf("Invalid credentials secrets. Err: %v", err)
+			}
+		}
+
 		if !vs.cfg.Global.APIDisable {
 			klog.V(1).Info("Starting the API Server")
 			vs.server.Start()
@@ -102,6 +116,13 @@ func (vs *VSphere) Instances() (cloudprovider.Instances, bool) {
 	return vs.instances, true
 }
 
+// InstancesV2 returns an InstancesV2 interface. Also returns true if the
+// interface is supported, false otherwise.
+func (vs *VSphere) InstancesV2() (InstancesV2, bool) {
+	klog.V(1).Info("Enabling InstancesV2 interface on vSphere cloud provider")
+	return vs.instancesV2, true
+}
+
 // Zones returns a zones interface. Also returns true if the interface
 // is supported, false otherwise.
 func (vs *VSphere) Zones() (cloudprovider.Zones, bool) {
@@ -141,11 +162,18 @@ func (vs *VSphere) HasClusterID() bool {
 
 // Initializes vSphere from vSphere CloudProvider Configuration
 func buildVSphereFromConfig(cfg *vcfg.Config) (*VSphere, error) {
+	addressSelector, err := newAddressSelector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	nm := NodeManager{
-		nodeNameMap:    make(map[string]*NodeInfo),
-		nodeUUIDMap:    make(map[string]*NodeInfo),
-		nodeRegUUIDMap: make(map[string]*v1.Node),
-		vcList:         make(map[string]*VCenterInfo),
+		nodeNameMap:     make(map[string]*NodeInfo),
+		nodeUUIDMap:     make(map[string]*NodeInfo),
+		nodeRegUUIDMap:  make(map[string]*v1.Node),
+		vcList:          make(map[string]*VCenterInfo),
+		addressSelector: addressSelector,
+		vmCache:         newVMCache(),
 	}
 
 	var nodeMgr server.NodeManagerInterface
@@ -154,6 +182,7 @@ func buildVSphereFromConfig(cfg *vcfg.Config) (*VSphere, error) {
 		cfg:         cfg,
 		nodeManager: &nm,
 		instances:   newInstances(&nm),
+		instancesV2: newInstancesV2(&nm, cfg.Labels.Zone, cfg.Labels.Region),
 		zones:       newZones(&nm, cfg.Labels.Zone, cfg.Labels.Region),
 		server:      server.NewServer(cfg.Global.APIBinding, nodeMgr),
 	}
@@ -161,6 +190,7 @@ func buildVSphereFromConfig(cfg *vcfg.Config) (*VSphere, error) {
 }
 
 func logout(vs *VSphere) {
+	vs.nodeManager.vmCache.stop()
 	vs.connectionManager.Logout()
 }
 
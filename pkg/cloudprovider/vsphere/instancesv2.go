@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func newInstancesV2(nodeManager *NodeManager, zone string, region string) InstancesV2 {
+	return &instancesV2{
+		nodeManager: nodeManager,
+		zones: &zones{
+			nodeManager: nodeManager,
+			zone:        zone,
+			region:      region,
+		},
+	}
+}
+
+// nodeUUID returns the UUID of the VM of a node, from its provider ID or,
+// before the node has one, from its system UUID.
+func nodeUUID(node *v1.Node) string {
+	if node.Spec.ProviderID != "" {
+		return GetUUIDFromProviderID(node.Spec.ProviderID)
+	}
+	return ConvertK8sUUIDtoNormal(node.Status.NodeInfo.SystemUUID)
+}
+
+// nodeInfo returns the info of the VM of a node, discovering the VM across
+// all of the vCenters when it is not cached yet.
+func (i *instancesV2) nodeInfo(node *v1.Node) (*NodeInfo, error) {
+	uid := nodeUUID(node)
+
+	i.nodeManager.nodeInfoLock.RLock()
+	nodeInfo, ok := i.nodeManager.nodeUUIDMap[uid]
+	i.nodeManager.nodeInfoLock.RUnlock()
+	if ok {
+		klog.V(2).Info("instancesV2.nodeInfo() CACHED with ", uid)
+		return nodeInfo, nil
+	}
+
+	if err := i.nodeManager.DiscoverNode(uid, cm.FindVMByUUID); err != nil {
+		return nil, err
+	}
+
+	i.nodeManager.nodeInfoLock.RLock()
+	nodeInfo, ok = i.nodeManager.nodeUUIDMap[uid]
+	i.nodeManager.nodeInfoLock.RUnlock()
+	if !ok {
+		klog.Errorf("DiscoverNode succeeded, but CACHE missed for node=%s with UUID %s", node.Name, uid)
+		return nil, ErrNodeNotFound
+	}
+	klog.V(2).Info("instancesV2.nodeInfo() FOUND with ", uid)
+	return nodeInfo, nil
+}
+
+// InstanceExists returns true if the VM of the node exists in any of the
+// vCenters. The VM is always discovered again rather than taken from the
+// node info, which is kept for the deleted VMs, while the VM cache drops
+// them.
+func (i *instancesV2) InstanceExists(ctx context.Context, node *v1.Node) (bool, error) {
+	klog.V(4).Info("instancesV2.InstanceExists() called with ", node.Name)
+
+	err := i.nodeManager.DiscoverNode(nodeUUID(node), cm.FindVMByUUID)
+	if err == vclib.ErrNoVMFound {
+		klog.V(2).Info("instancesV2.InstanceExists() NOT FOUND with ", node.Name)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// InstanceShutdown returns true if the VM of the node is powered off, so
+// that the node lifecycle controller can taint the node. The power state of
+// a VM in the VM cache is up to date.
+func (i *instancesV2) InstanceShutdown(ctx context.Context, node *v1.Node) (bool, error) {
+	klog.V(4).Info("instancesV2.InstanceShutdown() called with ", node.Name)
+
+	if vm, ok := i.nodeManager.vmCache.lookup(nodeUUID(node), cm.FindVMByUUID); ok {
+		klog.V(4).Infof("Node %s is %s in the VM cache", node.Name, vm.obj.Runtime.PowerState)
+		return vm.obj.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff, nil
+	}
+
+	nodeInfo, err := i.nodeInfo(node)
+	if err != nil {
+		return false, err
+	}
+
+	var oVM mo.VirtualMachine
+	err = i.nodeManager.withNodeVM(nodeInfo, func(n *NodeInfo) error {
+		nodeInfo = n
+		return nodeInfo.vm.Properties(ctx, nodeInfo.vm.Reference(), []string{"runtime.powerState"}, &oVM)
+	})
+	if err != nil {
+		klog.Errorf("Error collecting the power state of vm=%+v in vc=%s: %v", nodeInfo.vm, nodeInfo.vcServer, err)
+		return false, err
+	}
+
+	klog.V(4).Infof("Node %s is %s", node.Name, oVM.Runtime.PowerState)
+	return oVM.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff, nil
+}
+
+// InstanceMetadata returns the provider ID, the instance type and the
+// addresses of the VM of the node, and its zone and region when the zone
+// and region tag categories are configured.
+func (i *instancesV2) InstanceMetadata(ctx context.Context, node *v1.Node) (*InstanceMetadata, error) {
+	klog.V(4).Info("instancesV2.InstanceMetadata() called with ", node.Name)
+
+	nodeInfo, err := i.nodeInfo(node)
+	if err != nil {
+		return nil, err
+	}
+
+	providerID := ProviderPrefix + nodeInfo.UUID
+	metadata := &InstanceMetadata{
+		ProviderID:    providerID,
+		InstanceType:  nodeInfo.NodeType,
+		NodeAddresses: nodeInfo.NodeAddresses,
+	}
+
+	if i.zones.zone != "" && i.zones.region != "" {
+		zone, err := i.zones.GetZoneByProviderID(ctx, providerID)
+		if err != nil {
+			return nil, err
+		}
+		metadata.Zone = zone.FailureDomain
+		metadata.Region = zone.Region
+	}
+
+	return metadata, nil
+}
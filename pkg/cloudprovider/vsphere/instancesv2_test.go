@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+)
+
+func TestInstancesV2(t *testing.T) {
+	cfg, ok := configFromEnvOrSim(false)
+	defer ok()
+
+	ctx := context.Background()
+
+	connMgr := cm.NewConnectionManager(cfg, nil)
+	defer connMgr.Logout()
+
+	nm := newNodeManager(connMgr, nil)
+	nm.addressSelector.internalNetworkName = "VM Network"
+	instances := newInstancesV2(nm, "", "")
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vm.Guest.HostName = vm.Name
+	vm.Guest.Net = []types.GuestNicInfo{
+		{Network: "VM Network", DeviceConfigId: 4000, IpAddress: []string{"10.0.0.10"}},
+		{Network: "Storage Network", DeviceConfigId: 4001, IpAddress: []string{"10.1.0.10"}},
+	}
+	UUID := vm.Config.Uuid
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: vm.Name,
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: ProviderPrefix + UUID,
+		},
+	}
+
+	exists, err := instances.InstanceExists(ctx, node)
+	if err != nil {
+		t.Fatalf("InstanceExists failed err=%v", err)
+	}
+	if !exists {
+		t.Error("InstanceExists not found")
+	}
+
+	metadata, err := instances.InstanceMetadata(ctx, node)
+	if err != nil {
+		t.Fatalf("InstanceMetadata failed err=%v", err)
+	}
+	if !strings.EqualFold(metadata.ProviderID, ProviderPrefix+UUID) {
+		t.Errorf("InstanceMetadata ProviderID mismatch %s != %s", metadata.ProviderID, ProviderPrefix+UUID)
+	}
+	if !strings.HasPrefix(metadata.InstanceType, "vsphere-vm.cpu-") {
+		t.Errorf("InstanceMetadata InstanceType mismatch %s", metadata.InstanceType)
+	}
+	for _, addr := range metadata.NodeAddresses {
+		if addr.Address == "10.1.0.10" {
+			t.Errorf("InstanceMetadata should not return the storage network address: %v", metadata.NodeAddresses)
+		}
+	}
+	if len(metadata.NodeAddresses) != 3 {
+		t.Errorf("InstanceMetadata mismatch should be 3 addrs count=%d", len(metadata.NodeAddresses))
+	}
+	if metadata.Zone != "" || metadata.Region != "" {
+		t.Errorf("InstanceMetadata should have no zone without labels: %s/%s", metadata.Zone, metadata.Region)
+	}
+
+	shutdown, err := instances.InstanceShutdown(ctx, node)
+	if err != nil {
+		t.Fatalf("InstanceShutdown failed err=%v", err)
+	}
+	if shutdown {
+		t.Error("InstanceShutdown of a powered on VM")
+	}
+
+	vm.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOff
+	shutdown, err = instances.InstanceShutdown(ctx, node)
+	if err != nil {
+		t.Fatalf("InstanceShutdown failed err=%v", err)
+	}
+	if !shutdown {
+		t.Error("InstanceShutdown of a powered off VM")
+	}
+
+	// a node without a provider ID is looked up by its system UUID
+	missing := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "missing",
+		},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{
+				SystemUUID: "00000000-0000-0000-0000-000000000000",
+			},
+		},
+	}
+	exists, err = instances.InstanceExists(ctx, missing)
+	if err != nil {
+		t.Fatalf("InstanceExists failed err=%v", err)
+	}
+	if exists {
+		t.Error("InstanceExists found a missing node")
+	}
+}
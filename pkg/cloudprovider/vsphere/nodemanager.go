@@ -20,17 +20,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
 
 	v1 "k8s.io/api/core/v1"
 	clientv1 "k8s.io/client-go/listers/core/v1"
 	pb "k8s.io/cloud-provider-vsphere/pkg/cloudprovider/vsphere/proto"
 	"k8s.io/klog"
-	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
 
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
 
 	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
 )
 
 // Errors
@@ -47,6 +47,15 @@ var (
 	ErrVMNotFound = errors.New("VM not found")
 )
 
+// nodeVMProperties are the properties of a VM the info of its node is made
+// of. The full config and guest of a VM are not retrieved, as they hold its
+// devices, layout and disks.
+var nodeVMProperties = []string{
+	vclib.VirtualMachineSummaryConfigProperty,
+	vclib.VirtualMachineHostNameProperty,
+	vclib.VirtualMachineGuestNetProperty,
+}
+
 func newNodeManager(cm *cm.ConnectionManager, lister clientv1.NodeLister) *NodeManager {
 	return &NodeManager{
 		nodeNameMap:       make(map[string]*NodeInfo),
@@ -55,6 +64,7 @@ func newNodeManager(cm *cm.ConnectionManager, lister clientv1.NodeLister) *NodeM
 		vcList:            make(map[string]*VCenterInfo),
 		connectionManager: cm,
 		nodeLister:        lister,
+		vmCache:           newVMCache(),
 	}
 }
 
@@ -72,6 +82,7 @@ func (nm *NodeManager) UnregisterNode(node *v1.Node) {
 	klog.V(4).Info("UnregisterNode ENTER: ", node.Name)
 	uuid := ConvertK8sUUIDtoNormal(node.Status.NodeInfo.SystemUUID)
 	nm.removeNode(uuid, node)
+	nm.vmCache.invalidate(uuid, node.Name)
 	klog.V(4).Info("UnregisterNode LEAVE: ", node.Name)
 }
 
@@ -99,8 +110,8 @@ func (nm *NodeManager) removeNode(uuid string, node *v1.Node) {
 }
 
 func (nm *NodeManager) shakeOutNodeIDLookup(ctx context.Context, nodeID string, searchBy cm.FindVM) (*cm.VMDiscoveryInfo, error) {
-	// Search by NodeName
-	if searchBy == cm.FindVMByName {
+	// Search by NodeName or IP
+	if searchBy != cm.FindVMByUUID {
 		return nm.connectionManager.WhichVCandDCByNodeID(ctx, nodeID, cm.FindVM(searchBy))
 	}
 
@@ -126,10 +137,18 @@ func (nm *NodeManager) shakeOutNodeIDLookup(ctx context.Context, nodeID string,
 }
 
 // DiscoverNode finds a node's VM using the specified search value and search
-// type.
+// type. The VM is looked up in the VM cache first, and searched for in all
+// of the datacenters when it is not cached.
 func (nm *NodeManager) DiscoverNode(nodeID string, searchBy cm.FindVM) error {
 	ctx := context.Background()
 
+	if vm, ok := nm.vmCache.lookup(nodeID, searchBy); ok {
+		klog.V(2).Infof("Found node %s as vm=%+v in vc=%s and datacenter=%s in the VM cache",
+			nodeID, vm.vm, vm.vcServer, vm.datacenter.Name())
+		nm.addNodeInfo(nm.newNodeInfo(vm.vcServer, vm.datacenter, vm.vm, &vm.obj))
+		return nil
+	}
+
 	vmDI, err := nm.shakeOutNodeIDLookup(ctx, nodeID, searchBy)
 	if err != nil {
 		klog.Errorf("shakeOutNodeIDLookup failed. Err=%v", err)
@@ -137,40 +156,61 @@ func (nm *NodeManager) DiscoverNode(nodeID string, searchBy cm.FindVM) error {
 	}
 
 	var oVM mo.VirtualMachine
-	err = vmDI.VM.Properties(ctx, vmDI.VM.Reference(), []string{"guest", "summary"}, &oVM)
+	err = vmDI.VM.Properties(ctx, vmDI.VM.Reference(), nodeVMProperties, &oVM)
 	if err != nil {
 		klog.Errorf("Error collecting properties for vm=%+v in vc=%s and datacenter=%s: %v",
 			vmDI.VM, vmDI.VcServer, vmDI.DataCenter.Name(), err)
 		return err
 	}
 
-	addrs := []v1.NodeAddress{}
-	for _, v := range oVM.Guest.Net {
-		if v.DeviceConfigId == -1 {
-			klog.V(4).Info("Skipping device because not a vNIC")
-			continue
-		}
-		for _, ip := range v.IpAddress {
-			if net.ParseIP(ip).To4() != nil {
-				v1helper.AddToNodeAddresses(&addrs,
-					v1.NodeAddress{
-						Type:    v1.NodeExternalIP,
-						Address: ip,
-					}, v1.NodeAddress{
-						Type:    v1.NodeInternalIP,
-						Address: ip,
-					}, v1.NodeAddress{
-						Type:    v1.NodeHostName,
-						Address: oVM.Guest.HostName,
-					},
-				)
-			}
-		}
-	}
-
 	klog.V(2).Infof("Found node %s as vm=%+v in vc=%s and datacenter=%s",
 		nodeID, vmDI.VM, vmDI.VcServer, vmDI.DataCenter.Name())
-	klog.V(2).Info("Hostname: ", oVM.Guest.HostName, " UUID: ", oVM.Summary.Config.Uuid)
+
+	nm.addNodeInfo(nm.newNodeInfo(vmDI.VcServer, vmDI.DataCenter, vmDI.VM, &oVM))
+
+	return nil
+}
+
+// withNodeVM calls fn with the info of a node. A VM removed from the
+// inventory and registered again, or recovered by vCenter HA, keeps its UUID
+// but gets a new managed object reference, on which the VM of the node info
+// fails with ManagedObjectNotFound. The node is then discovered again by its
+// UUID and fn is retried once with the new node info.
+func (nm *NodeManager) withNodeVM(node *NodeInfo, fn func(node *NodeInfo) error) error {
+	err := fn(node)
+	if !vclib.IsManagedObjectNotFoundError(err) {
+		return err
+	}
+	klog.Warningf("VM %s of node %s no longer exists, discovering it again with UUID %s. Err: %v",
+		node.vm.Reference().Value, node.NodeName, node.UUID, err)
+
+	// the VM cache may not have followed the new registration yet
+	nm.vmCache.invalidate(node.UUID, node.NodeName)
+	if err := nm.DiscoverNode(node.UUID, cm.FindVMByUUID); err != nil {
+		return err
+	}
+
+	nm.nodeInfoLock.RLock()
+	rediscovered, ok := nm.nodeUUIDMap[node.UUID]
+	nm.nodeInfoLock.RUnlock()
+	if !ok {
+		return ErrVMNotFound
+	}
+	return fn(rediscovered)
+}
+
+// newNodeInfo returns the info of a node from the guest and summary
+// properties of its VM.
+func (nm *NodeManager) newNodeInfo(vcServer string, datacenter *vclib.Datacenter, vm *vclib.VirtualMachine, oVM *mo.VirtualMachine) *NodeInfo {
+	var hostname string
+	var nics []types.GuestNicInfo
+	if oVM.Guest != nil {
+		hostname = oVM.Guest.HostName
+		nics = oVM.Guest.Net
+	}
+	klog.V(2).Info("Hostname: ", hostname, " UUID: ", oVM.Summary.Config.Uuid)
+
+	addrs := nm.addressSelector.nodeAddresses(nics, hostname)
 
 	os := "unknown"
 	if g, ok := GuestOSLookup[oVM.Summary.Config.GuestId]; ok {
@@ -184,11 +224,8 @@ func (nm *NodeManager) DiscoverNode(nodeID string, searchBy cm.FindVM) error {
 		os,
 	)
 
-	nodeInfo := &NodeInfo{dataCenter: vmDI.DataCenter, vm: vmDI.VM, vcServer: vmDI.VcServer,
-		UUID: vmDI.UUID, NodeName: vmDI.NodeName, NodeType: instanceType, NodeAddresses: addrs}
-	nm.addNodeInfo(nodeInfo)
-
-	return nil
+	return &NodeInfo{dataCenter: datacenter, vm: vm, vcServer: vcServer,
+		UUID: oVM.Summary.Config.Uuid, NodeName: hostname, NodeType: instanceType, NodeAddresses: addrs}
 }
 
 // ExportNodes transforms the NodeInfoList to []*pb.Node
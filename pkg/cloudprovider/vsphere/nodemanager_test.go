@@ -21,7 +21,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/types"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -154,3 +157,97 @@ func TestExport(t *testing.T) {
 
 	nm.UnregisterNode(node)
 }
+
+// reregisterVM removes a VM from the inventory and registers it again, which
+// changes its managed object reference but keeps its UUIDs.
+func reregisterVM(ctx context.Context, t *testing.T, client *vim25.Client, vm *simulator.VirtualMachine) *simulator.VirtualMachine {
+	obj := object.NewVirtualMachine(client, vm.Reference())
+	if vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		task, err := obj.PowerOff(ctx)
+		if err == nil {
+			err = task.Wait(ctx)
+		}
+		if err != nil {
+			t.Fatalf("PowerOff failed: %v", err)
+		}
+	}
+	if err := obj.Unregister(ctx); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+
+	folder := object.NewFolder(client, *vm.Parent)
+	pool := object.NewResourcePool(client, *vm.ResourcePool)
+	host := object.NewHostSystem(client, *vm.Runtime.Host)
+	task, err := folder.RegisterVM(ctx, vm.Config.Files.VmPathName, vm.Name, false, pool, host)
+	if err != nil {
+		t.Fatalf("RegisterVM failed: %v", err)
+	}
+	info, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		t.Fatalf("RegisterVM failed: %v", err)
+	}
+
+	registered := simulator.Map.Get(info.Result.(types.ManagedObjectReference)).(*simulator.VirtualMachine)
+	registered.Config.Uuid = vm.Config.Uuid
+	registered.Config.InstanceUuid = vm.Config.InstanceUuid
+	registered.Summary.Config.Uuid = vm.Config.Uuid
+	registered.Summary.Config.InstanceUuid = vm.Config.InstanceUuid
+	registered.Guest.HostName = vm.Guest.HostName
+	return registered
+}
+
+func TestReregisteredNodeVM(t *testing.T) {
+	cfg, ok := configFromEnvOrSim(false)
+	defer ok()
+
+	ctx := context.Background()
+
+	connMgr := cm.NewConnectionManager(cfg, nil)
+	defer connMgr.Logout()
+
+	nm := newNodeManager(connMgr, nil)
+	instances := newInstancesV2(nm, "", "")
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	UUID := vm.Config.Uuid
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: vm.Name,
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: ProviderPrefix + UUID,
+		},
+	}
+
+	// the node info keeps the VM of the node
+	if _, err := instances.InstanceMetadata(ctx, node); err != nil {
+		t.Fatalf("InstanceMetadata failed: %v", err)
+	}
+	if nodeInfo := nm.nodeUUIDMap[UUID]; nodeInfo == nil || nodeInfo.vm.Reference() != vm.Reference() {
+		t.Fatalf("The node info of VM %s should be discovered: %+v", UUID, nodeInfo)
+	}
+
+	registered := reregisterVM(ctx, t, connMgr.VsphereInstanceMap[cfg.Global.VCenterIP].Conn.Client, vm)
+	if registered.Reference() == vm.Reference() {
+		t.Fatalf("The re-registered VM should have a new reference")
+	}
+
+	// the VM of the node info no longer exists, so the node is discovered
+	// again by its UUID
+	shutdown, err := instances.InstanceShutdown(ctx, node)
+	if err != nil {
+		t.Fatalf("InstanceShutdown failed: %v", err)
+	}
+	if !shutdown {
+		t.Error("The re-registered VM should be powered off")
+	}
+	if nodeInfo := nm.nodeUUIDMap[UUID]; nodeInfo.vm.Reference() != registered.Reference() {
+		t.Errorf("The node info should have VM %v, not %v", registered.Reference(), nodeInfo.vm.Reference())
+	}
+
+	// a node whose VM is gone for good is not found
+	simulator.Map.Remove(registered.Reference())
+	if _, err := instances.InstanceShutdown(ctx, node); err == nil {
+		t.Error("InstanceShutdown of a deleted VM should fail")
+	}
+}
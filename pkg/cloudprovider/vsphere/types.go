@@ -17,7 +17,9 @@ limitations under the License.
 package vsphere
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	clientv1 "k8s.io/client-go/listers/core/v1"
@@ -41,10 +43,40 @@ type VSphere struct {
 	nodeManager       *NodeManager
 	informMgr         *k8s.InformerManager
 	instances         cloudprovider.Instances
+	instancesV2       InstancesV2
 	zones             cloudprovider.Zones
 	server            GRPCServer
 }
 
+// InstancesV2 is the instances interface of the cloud providers of
+// Kubernetes 1.19 and later, which the vendored cloudprovider package
+// predates. Its instances are looked up by their node object rather than by
+// their node name or provider ID.
+type InstancesV2 interface {
+	// InstanceExists returns true if the instance of the node exists.
+	InstanceExists(ctx context.Context, node *v1.Node) (bool, error)
+	// InstanceShutdown returns true if the instance of the node is shut
+	// down.
+	InstanceShutdown(ctx context.Context, node *v1.Node) (bool, error)
+	// InstanceMetadata returns the metadata of the instance of the node.
+	InstanceMetadata(ctx context.Context, node *v1.Node) (*InstanceMetadata, error)
+}
+
+// InstanceMetadata is the metadata of the instance of a node.
+type InstanceMetadata struct {
+	// ProviderID is the provider ID of the instance, vsphere://UUID.
+	ProviderID string
+	// InstanceType is the type of the instance, from its CPUs, memory and
+	// guest OS.
+	InstanceType string
+	// NodeAddresses are the addresses of the instance.
+	NodeAddresses []v1.NodeAddress
+	// Zone is the zone of the instance, empty without zone labels.
+	Zone string
+	// Region is the region of the instance, empty without zone labels.
+	Region string
+}
+
 // NodeInfo is information about a Kubernetes node.
 type NodeInfo struct {
 	dataCenter    *vclib.Datacenter
@@ -82,6 +114,11 @@ type NodeManager struct {
 	connectionManager *cm.ConnectionManager
 	// NodeLister to track Node properties
 	nodeLister clientv1.NodeLister
+	// Selects the guest addresses reported as the node addresses
+	addressSelector addressSelector
+	// Caches the VMs of all of the datacenters, which nodes are discovered
+	// from before searching for them
+	vmCache *vmCache
 
 	// Mutexes
 	nodeInfoLock    sync.RWMutex
@@ -92,10 +129,25 @@ type instances struct {
 	nodeManager *NodeManager
 }
 
+type instancesV2 struct {
+	nodeManager *NodeManager
+	zones       *zones
+}
+
 type zones struct {
 	nodeManager *NodeManager
 	zone        string
 	region      string
+
+	// the zones of the nodes by UUID, cached for the TTL of the tags
+	zoneCacheLock sync.Mutex
+	zoneCache     map[string]cachedZone
+}
+
+// cachedZone is the zone of a node and the time it expires.
+type cachedZone struct {
+	zone    cloudprovider.Zone
+	expires time.Time
 }
 
 // GuestOSLookup is a table for quick lookup between guestOsIdentifier and a shorthand name
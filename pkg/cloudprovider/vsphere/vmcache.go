@@ -0,0 +1,428 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+var (
+	// VMCacheMaxWaitSecs is the longest a wait for the changes of the VMs
+	// of a datacenter lasts. The cache age is reset at the end of each wait.
+	VMCacheMaxWaitSecs int32 = 60
+
+	// VMCacheRetryInterval is how long the VM cache waits before watching
+	// the VMs again after a watch failed.
+	VMCacheRetryInterval = 30 * time.Second
+)
+
+// vmCacheProperties are the properties of the VMs kept in the VM cache,
+// which are the ones a node is discovered with, its name and power state.
+var vmCacheProperties = append([]string{
+	"name",
+	vclib.VirtualMachinePowerStateProperty,
+}, nodeVMProperties...)
+
+// cachedVM is a VM of the VM cache.
+type cachedVM struct {
+	vcServer   string
+	datacenter *vclib.Datacenter
+	vm         *vclib.VirtualMachine
+	// the vmCacheProperties of the VM, replaced rather than modified when
+	// the VM changes
+	obj mo.VirtualMachine
+}
+
+// vmCache caches the VMs of all of the datacenters so that discovering a
+// node does not search the inventory of every datacenter. The VMs of each
+// datacenter are retrieved at once and then kept up to date with the
+// changes reported by WaitForUpdatesEx, so that the deleted VMs are dropped
+// from the cache.
+type vmCache struct {
+	sync.RWMutex
+
+	// the VMs keyed by VC and managed object ID
+	byRef map[string]*cachedVM
+	// the VMs keyed by their UUID, lowercased
+	byUUID map[string]*cachedVM
+	// the VMs keyed by their name and by their guest hostname, lowercased
+	byName map[string]*cachedVM
+	// when the VMs of each VC/DC pair were last known to be up to date
+	synced map[string]time.Time
+
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+}
+
+func newVMCache() *vmCache {
+	return &vmCache{
+		byRef:  make(map[string]*cachedVM),
+		byUUID: make(map[string]*cachedVM),
+		byName: make(map[string]*cachedVM),
+		synced: make(map[string]time.Time),
+	}
+}
+
+var registerVMCacheMetricsOnce sync.Once
+
+// registerVMCacheMetrics registers the size and the age of the VM cache
+// as metrics.
+func registerVMCacheMetrics(c *vmCache) {
+	registerVMCacheMetricsOnce.Do(func() {
+		prometheus.MustRegister(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "cloudprovider_vsphere_vm_cache_size",
+				Help: "VMs in the node VM cache",
+			},
+			func() float64 { return float64(c.size()) },
+		))
+		prometheus.MustRegister(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "cloudprovider_vsphere_vm_cache_age_seconds",
+				Help: "Seconds since the least recently synced datacenter of the node VM cache was synced",
+			},
+			func() float64 { return c.age().Seconds() },
+		))
+	})
+}
+
+// start watches the VMs of all of the datacenters of the connection
+// manager in the background until stop is called.
+func (c *vmCache) start(connMgr *cm.ConnectionManager) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Lock()
+	c.cancel = cancel
+	c.Unlock()
+
+	go c.run(ctx, connMgr)
+}
+
+// stop stops watching the VMs.
+func (c *vmCache) stop() {
+	c.stopOnce.Do(func() {
+		c.Lock()
+		cancel := c.cancel
+		c.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+	})
+}
+
+// run watches the VMs of all of the datacenters until the context is
+// cancelled. When any of the watches fails, the cache is emptied and all of
+// the datacenters are watched again after VMCacheRetryInterval, with the
+// connections the connection manager has reestablished in the meantime.
+func (c *vmCache) run(ctx context.Context, connMgr *cm.ConnectionManager) {
+	for {
+		pairs, _ := connMgr.ListAllVCandDCPairs(ctx)
+		if len(pairs) == 0 {
+			klog.Error("The VM cache found no datacenter to watch")
+		} else {
+			watchCtx, cancel := context.WithCancel(ctx)
+			var wg sync.WaitGroup
+			for _, pair := range pairs {
+				wg.Add(1)
+				go func(pair *cm.ListDiscoveryInfo) {
+					defer wg.Done()
+					defer cancel()
+					err := c.watch(watchCtx, pair.VcServer, pair.DataCenter)
+					if err != nil && watchCtx.Err() == nil {
+						klog.Errorf("Failed to watch the VMs of vc=%s and datacenter=%s: %v",
+							pair.VcServer, pair.DataCenter.Name(), err)
+					}
+				}(pair)
+			}
+			wg.Wait()
+			cancel()
+		}
+
+		c.reset()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(VMCacheRetryInterval):
+		}
+	}
+}
+
+// watch keeps the VMs of a datacenter up to date in the cache until the
+// context is cancelled or the watch fails. The first WaitForUpdatesEx call
+// retrieves all of the VMs, and the later ones return their changes.
+func (c *vmCache) watch(ctx context.Context, vcServer string, datacenter *vclib.Datacenter) error {
+	client := datacenter.Client()
+
+	v, err := view.NewManager(client).CreateContainerView(ctx, datacenter.Reference(), []string{"VirtualMachine"}, true)
+	if err != nil {
+		return err
+	}
+	defer v.Destroy(context.Background())
+
+	pc, err := property.DefaultCollector(client).Create(ctx)
+	if err != nil {
+		return err
+	}
+	defer pc.Destroy(context.Background())
+
+	filter := new(property.WaitFilter).Add(v.Reference(), "VirtualMachine", vmCacheProperties,
+		&types.TraversalSpec{
+			Type: "ContainerView",
+			Path: "view",
+		})
+	filter.Spec.ObjectSet[0].Skip = types.NewBool(true)
+	if err = pc.CreateFilter(ctx, filter.CreateFilter); err != nil {
+		return err
+	}
+
+	req := types.WaitForUpdatesEx{
+		This:    pc.Reference(),
+		Options: &types.WaitOptions{MaxWaitSeconds: &VMCacheMaxWaitSecs},
+	}
+	for {
+		res, err := methods.WaitForUpdatesEx(ctx, client, &req)
+		if err != nil {
+			if ctx.Err() != nil {
+				pc.CancelWaitForUpdates(context.Background())
+			}
+			return err
+		}
+
+		set := res.Returnval
+		if set == nil {
+			// MaxWaitSeconds elapsed without any change
+			c.touch(vcServer, datacenter)
+			continue
+		}
+
+		c.apply(vcServer, datacenter, set.FilterSet, req.Version == "")
+		req.Version = set.Version
+	}
+}
+
+func syncKey(vcServer string, datacenter *vclib.Datacenter) string {
+	return vcServer + "/" + datacenter.Name()
+}
+
+// touch records that the VMs of a datacenter are up to date.
+func (c *vmCache) touch(vcServer string, datacenter *vclib.Datacenter) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.synced[syncKey(vcServer, datacenter)] = time.Now()
+}
+
+// apply applies the updates of the VMs of a datacenter to the cache. When
+// the updates are the full retrieval of the VMs, the VMs of the datacenter
+// that are no longer found are dropped.
+func (c *vmCache) apply(vcServer string, datacenter *vclib.Datacenter, updates []types.PropertyFilterUpdate, full bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if full {
+		for key, vm := range c.byRef {
+			if vm.vcServer == vcServer && vm.datacenter.Name() == datacenter.Name() {
+				c.remove(key, vm)
+			}
+		}
+	}
+
+	for _, fu := range updates {
+		for _, update := range fu.ObjectSet {
+			key := vcServer + "/" + update.Obj.Value
+			old := c.byRef[key]
+
+			switch update.Kind {
+			case types.ObjectUpdateKindEnter, types.ObjectUpdateKindModify:
+				var vm cachedVM
+				if old != nil {
+					vm = *old
+					if vm.obj.Guest != nil {
+						guest := *vm.obj.Guest
+						vm.obj.Guest = &guest
+					}
+					c.remove(key, old)
+				} else if update.Kind == types.ObjectUpdateKindEnter {
+					vm = cachedVM{
+						vcServer:   vcServer,
+						datacenter: datacenter,
+						vm: &vclib.VirtualMachine{
+							VirtualMachine: object.NewVirtualMachine(datacenter.Client(), update.Obj),
+							Datacenter:     datacenter,
+						},
+					}
+					vm.obj.Self = update.Obj
+				} else {
+					// the changes of an invalidated VM are not enough to
+					// cache it again
+					continue
+				}
+				mo.ApplyPropertyChange(&vm.obj, setChanges(update.ChangeSet))
+				c.add(key, &vm)
+			case types.ObjectUpdateKindLeave:
+				if old != nil {
+					klog.V(4).Infof("VM %s left the VM cache of vc=%s and datacenter=%s",
+						old.obj.Name, vcServer, datacenter.Name())
+					c.remove(key, old)
+				}
+			}
+		}
+	}
+
+	c.synced[syncKey(vcServer, datacenter)] = time.Now()
+}
+
+// setChanges returns the changes that set a property. The properties that
+// are removed, such as the guest info of a VM that is powered off, are set
+// to their zero value instead.
+func setChanges(changes []types.PropertyChange) []types.PropertyChange {
+	set := make([]types.PropertyChange, 0, len(changes))
+	for _, change := range changes {
+		if change.Val != nil {
+			set = append(set, change)
+		}
+	}
+	return set
+}
+
+// add indexes a VM. The caller holds the lock.
+func (c *vmCache) add(key string, vm *cachedVM) {
+	c.byRef[key] = vm
+	if uuid := vm.obj.Summary.Config.Uuid; uuid != "" {
+		c.byUUID[strings.ToLower(uuid)] = vm
+	}
+	for _, name := range vm.names() {
+		c.byName[name] = vm
+	}
+}
+
+// remove unindexes a VM. The caller holds the lock.
+func (c *vmCache) remove(key string, vm *cachedVM) {
+	delete(c.byRef, key)
+	if uuid := strings.ToLower(vm.obj.Summary.Config.Uuid); c.byUUID[uuid] == vm {
+		delete(c.byUUID, uuid)
+	}
+	for _, name := range vm.names() {
+		if c.byName[name] == vm {
+			delete(c.byName, name)
+		}
+	}
+}
+
+// names returns the lowercased name and guest hostname of a VM.
+func (vm *cachedVM) names() []string {
+	var names []string
+	if vm.obj.Name != "" {
+		names = append(names, strings.ToLower(vm.obj.Name))
+	}
+	if vm.obj.Guest != nil && vm.obj.Guest.HostName != "" {
+		names = append(names, strings.ToLower(vm.obj.Guest.HostName))
+	}
+	return names
+}
+
+// lookup returns the VM of a node from the cache, by its UUID or by its
+// name. A UUID is also looked up in the byte order of the older guests,
+// as shakeOutNodeIDLookup does.
+func (c *vmCache) lookup(nodeID string, searchBy cm.FindVM) (cachedVM, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	var vm *cachedVM
+	switch searchBy {
+	case cm.FindVMByUUID:
+		vm = c.byUUID[strings.ToLower(nodeID)]
+		if vm == nil {
+			vm = c.byUUID[strings.ToLower(ConvertK8sUUIDtoNormal(nodeID))]
+		}
+	case cm.FindVMByName:
+		vm = c.byName[strings.ToLower(nodeID)]
+	}
+	if vm == nil {
+		return cachedVM{}, false
+	}
+	return *vm, true
+}
+
+// invalidate drops the VM of a deleted node from the cache, so that the
+// node is searched for when it is discovered again.
+func (c *vmCache) invalidate(uuid string, name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, vm := range []*cachedVM{
+		c.byUUID[strings.ToLower(uuid)],
+		c.byName[strings.ToLower(name)],
+	} {
+		if vm != nil {
+			klog.V(4).Infof("Invalidating VM %s in the VM cache", vm.obj.Name)
+			c.remove(vm.vcServer+"/"+vm.obj.Self.Value, vm)
+		}
+	}
+}
+
+// reset empties the cache.
+func (c *vmCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.byRef = make(map[string]*cachedVM)
+	c.byUUID = make(map[string]*cachedVM)
+	c.byName = make(map[string]*cachedVM)
+	c.synced = make(map[string]time.Time)
+}
+
+// size returns the number of VMs in the cache.
+func (c *vmCache) size() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	return len(c.byRef)
+}
+
+// age returns the time since the least recently synced datacenter was
+// synced, or zero when no datacenter is watched.
+func (c *vmCache) age() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+
+	var oldest time.Time
+	for _, synced := range c.synced {
+		if oldest.IsZero() || synced.Before(oldest) {
+			oldest = synced
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+)
+
+// waitFor polls the condition until it is true or a few seconds elapsed.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestVMCache(t *testing.T) {
+	cfg, ok := configFromEnvOrSim(true)
+	defer ok()
+
+	connMgr := cm.NewConnectionManager(cfg, nil)
+	defer connMgr.Logout()
+
+	nm := newNodeManager(connMgr, nil)
+	cache := nm.vmCache
+	cache.start(connMgr)
+	defer cache.stop()
+
+	// all of the VMs of all of the datacenters are retrieved at once
+	vms := simulator.Map.All("VirtualMachine")
+	waitFor(t, "the VMs to be cached", func() bool { return cache.size() == len(vms) })
+	if cache.age() <= 0 {
+		t.Error("The age of the synced VM cache should be set")
+	}
+
+	vm := vms[0].(*simulator.VirtualMachine)
+	UUID := vm.Config.Uuid
+	if _, found := cache.lookup(UUID, cm.FindVMByUUID); !found {
+		t.Errorf("VM %s should be cached by UUID", UUID)
+	}
+	if _, found := cache.lookup(ConvertK8sUUIDtoNormal(UUID), cm.FindVMByUUID); !found {
+		t.Errorf("VM %s should be cached by reverse UUID", UUID)
+	}
+	if _, found := cache.lookup(vm.Name, cm.FindVMByName); !found {
+		t.Errorf("VM %s should be cached by name", vm.Name)
+	}
+
+	// a node is discovered from the cache without searching any vCenter
+	cached := newNodeManager(cm.NewConnectionManager(&vcfg.Config{}, nil), nil)
+	cached.vmCache = cache
+	if err := cached.DiscoverNode(UUID, cm.FindVMByUUID); err != nil {
+		t.Fatalf("DiscoverNode failed: %v", err)
+	}
+	if nodeInfo := cached.nodeUUIDMap[UUID]; nodeInfo == nil || nodeInfo.vcServer != cfg.Global.VCenterIP {
+		t.Errorf("The node info of VM %s should be discovered: %+v", UUID, nodeInfo)
+	}
+
+	// the changes of the VMs are applied
+	simulator.Map.Update(vm, []types.PropertyChange{
+		{Name: "runtime.powerState", Val: types.VirtualMachinePowerStatePoweredOff},
+	})
+	waitFor(t, "the power state to change", func() bool {
+		cachedVM, _ := cache.lookup(UUID, cm.FindVMByUUID)
+		return cachedVM.obj.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff
+	})
+
+	// the deleted VMs are dropped
+	simulator.Map.Remove(vm.Reference())
+	waitFor(t, "the deleted VM to be dropped", func() bool {
+		_, found := cache.lookup(UUID, cm.FindVMByUUID)
+		return !found
+	})
+	if cache.size() != len(vms)-1 {
+		t.Errorf("VM cache should have %d VMs, not %d", len(vms)-1, cache.size())
+	}
+
+	// the VMs of the deleted nodes are invalidated
+	other := vms[1].(*simulator.VirtualMachine)
+	nm.UnregisterNode(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: other.Name,
+		},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{
+				SystemUUID: ConvertK8sUUIDtoNormal(other.Config.Uuid),
+			},
+		},
+	})
+	if _, found := cache.lookup(other.Config.Uuid, cm.FindVMByUUID); found {
+		t.Errorf("VM %s of a deleted node should be invalidated", other.Name)
+	}
+	if _, found := cache.lookup(other.Name, cm.FindVMByName); found {
+		t.Errorf("VM %s of a deleted node should be invalidated by name", other.Name)
+	}
+
+	cache.stop()
+	waitFor(t, "the stopped VM cache to be emptied", func() bool { return cache.size() == 0 })
+	if cache.age() != 0 {
+		t.Errorf("The age of an empty VM cache should be 0, not %v", cache.age())
+	}
+}
@@ -18,8 +18,11 @@ package vsphere
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/mo"
 	"k8s.io/klog"
 
@@ -27,6 +30,7 @@ import (
 	"k8s.io/kubernetes/pkg/cloudprovider"
 
 	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
 )
 
 func newZones(nodeManager *NodeManager, zone string, region string) cloudprovider.Zones {
@@ -34,6 +38,7 @@ func newZones(nodeManager *NodeManager, zone string, region string) cloudprovide
 		nodeManager: nodeManager,
 		zone:        zone,
 		region:      region,
+		zoneCache:   make(map[string]cachedZone),
 	}
 }
 
@@ -41,124 +46,130 @@ func newZones(nodeManager *NodeManager, zone string, region string) cloudprovide
 func (z *zones) GetZone(ctx context.Context) (cloudprovider.Zone, error) {
 	klog.V(4).Info("zones.GetZone() called")
 
-	zone := cloudprovider.Zone{}
-
 	nodeName, err := os.Hostname()
 	if err != nil {
 		klog.V(2).Info("Failed to get hostname. Err: ", err)
-		return zone, err
-	}
-
-	node, ok := z.nodeManager.nodeNameMap[nodeName]
-	if !ok {
-		klog.V(2).Info("zones.GetZone() NOT FOUND with ", nodeName)
-		return zone, ErrVMNotFound
+		return cloudprovider.Zone{}, err
 	}
 
-	vmHost, err := node.vm.HostSystem(ctx)
+	node, err := z.nodeInfo(nodeName, cm.FindVMByName)
 	if err != nil {
-		klog.Errorf("Failed to get host system for VM: %q. err: %+v", node.vm.InventoryPath, err)
-		return zone, err
-	}
-
-	var oHost mo.HostSystem
-	err = vmHost.Properties(ctx, vmHost.Reference(), []string{"summary"}, &oHost)
-	if err != nil {
-		klog.Errorf("Failed to get host system properties. err: %+v", err)
-		return zone, err
-	}
-	klog.V(4).Infof("Host owning VM is %s", oHost.Summary.Config.Name)
-
-	zoneResult, err := z.nodeManager.connectionManager.LookupZoneByMoref(
-		ctx, node.dataCenter, vmHost.Reference(), z.zone, z.region, true)
-	if err != nil {
-		klog.Errorf("Failed to get host system properties. err: %+v", err)
-		return zone, err
+		klog.V(2).Info("zones.GetZone() NOT FOUND with ", nodeName)
+		return cloudprovider.Zone{}, err
 	}
 
-	zone.FailureDomain = zoneResult[cm.ZoneLabel]
-	zone.Region = zoneResult[cm.RegionLabel]
-
-	return zone, nil
+	return z.zoneOfNode(ctx, node)
 }
 
-// GetZone implements Zones.GetZone for In-Tree providers
-
 // GetZoneByNodeName implements Zones.GetZone for Out-Tree providers
 func (z *zones) GetZoneByNodeName(ctx context.Context, nodeName k8stypes.NodeName) (cloudprovider.Zone, error) {
 	klog.V(4).Info("zones.GetZoneByNodeName() called with ", string(nodeName))
 
-	zone := cloudprovider.Zone{}
-
-	node, ok := z.nodeManager.nodeNameMap[string(nodeName)]
-	if !ok {
+	node, err := z.nodeInfo(string(nodeName), cm.FindVMByName)
+	if err != nil {
 		klog.V(2).Info("zones.GetZoneByNodeName() NOT FOUND with ", string(nodeName))
-		return zone, ErrVMNotFound
+		return cloudprovider.Zone{}, err
 	}
 
-	vmHost, err := node.vm.HostSystem(ctx)
+	return z.zoneOfNode(ctx, node)
+}
+
+// GetZoneByProviderID implements Zones.GetZone for Out-Tree providers
+func (z *zones) GetZoneByProviderID(ctx context.Context, providerID string) (cloudprovider.Zone, error) {
+	klog.V(4).Info("zones.GetZoneByProviderID() called with ", providerID)
+
+	uid := GetUUIDFromProviderID(providerID)
+
+	node, err := z.nodeInfo(uid, cm.FindVMByUUID)
 	if err != nil {
-		klog.Errorf("Failed to get host system for VM: %q. err: %+v", node.vm.InventoryPath, err)
-		return zone, err
+		klog.V(2).Info("zones.GetZoneByProviderID() NOT FOUND with ", uid)
+		return cloudprovider.Zone{}, err
 	}
 
-	var oHost mo.HostSystem
-	err = vmHost.Properties(ctx, vmHost.Reference(), []string{"summary"}, &oHost)
-	if err != nil {
-		klog.Errorf("Failed to get host system properties. err: %+v", err)
-		return zone, err
+	return z.zoneOfNode(ctx, node)
+}
+
+// nodeInfo returns the info of a node by name or UUID, discovering its VM
+// across all of the vCenters when it is not cached yet.
+func (z *zones) nodeInfo(nodeID string, searchBy cm.FindVM) (*NodeInfo, error) {
+	nodeMap := z.nodeManager.nodeNameMap
+	if searchBy == cm.FindVMByUUID {
+		nodeMap = z.nodeManager.nodeUUIDMap
 	}
-	klog.V(4).Infof("Host owning VM is %s", oHost.Summary.Config.Name)
 
-	zoneResult, err := z.nodeManager.connectionManager.LookupZoneByMoref(
-		ctx, node.dataCenter, vmHost.Reference(), z.zone, z.region, true)
-	if err != nil {
-		klog.Errorf("Failed to get host system properties. err: %+v", err)
-		return zone, err
+	z.nodeManager.nodeInfoLock.RLock()
+	node, ok := nodeMap[nodeID]
+	z.nodeManager.nodeInfoLock.RUnlock()
+	if ok {
+		return node, nil
 	}
 
-	zone.FailureDomain = zoneResult[cm.ZoneLabel]
-	zone.Region = zoneResult[cm.RegionLabel]
+	if err := z.nodeManager.DiscoverNode(nodeID, searchBy); err == vclib.ErrNoVMFound {
+		return nil, ErrVMNotFound
+	} else if err != nil {
+		return nil, err
+	}
 
-	return zone, nil
+	z.nodeManager.nodeInfoLock.RLock()
+	node, ok = nodeMap[nodeID]
+	z.nodeManager.nodeInfoLock.RUnlock()
+	if !ok {
+		klog.Errorf("DiscoverNode succeeded, but CACHE missed for node %s", nodeID)
+		return nil, ErrVMNotFound
+	}
+	return node, nil
 }
 
-// GetZoneByProviderID implements Zones.GetZone for Out-Tree providers
-func (z *zones) GetZoneByProviderID(ctx context.Context, providerID string) (cloudprovider.Zone, error) {
-	klog.V(4).Info("zones.GetZoneByProviderID() called with ", providerID)
+// zoneOfNode returns the zone and region of a node from the tags attached to
+// the host of its VM or to the ancestors of the host, such as its cluster,
+// datacenter and folders, with the same tag walk as the connection manager
+// uses to place volumes in zones. The zones are cached for cm.TagCacheTTL.
+func (z *zones) zoneOfNode(ctx context.Context, node *NodeInfo) (cloudprovider.Zone, error) {
+	z.zoneCacheLock.Lock()
+	cached, ok := z.zoneCache[node.UUID]
+	z.zoneCacheLock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		klog.V(4).Infof("Zone of node %s is cached: %+v", node.NodeName, cached.zone)
+		return cached.zone, nil
+	}
 
 	zone := cloudprovider.Zone{}
-	uid := GetUUIDFromProviderID(providerID)
 
-	node, ok := z.nodeManager.nodeUUIDMap[uid]
-	if !ok {
-		klog.V(2).Info("zones.GetZoneByProviderID() NOT FOUND with ", uid)
-		return zone, ErrVMNotFound
-	}
-
-	vmHost, err := node.vm.HostSystem(ctx)
+	var vmHost *object.HostSystem
+	err := z.nodeManager.withNodeVM(node, func(n *NodeInfo) (err error) {
+		node = n
+		vmHost, err = node.vm.HostSystem(ctx)
+		return err
+	})
 	if err != nil {
 		klog.Errorf("Failed to get host system for VM: %q. err: %+v", node.vm.InventoryPath, err)
 		return zone, err
 	}
 
 	var oHost mo.HostSystem
-	err = vmHost.Properties(ctx, vmHost.Reference(), []string{"summary"}, &oHost)
+	err = vmHost.Properties(ctx, vmHost.Reference(), []string{"name"}, &oHost)
 	if err != nil {
 		klog.Errorf("Failed to get host system properties. err: %+v", err)
 		return zone, err
 	}
-	klog.V(4).Infof("Host owning VM is %s", oHost.Summary.Config.Name)
+	klog.V(4).Infof("Host owning VM is %s", oHost.Name)
 
 	zoneResult, err := z.nodeManager.connectionManager.LookupZoneByMoref(
 		ctx, node.dataCenter, vmHost.Reference(), z.zone, z.region, true)
 	if err != nil {
-		klog.Errorf("Failed to get host system properties. err: %+v", err)
+		err = fmt.Errorf("Failed to find the zone of node %q with UUID %s: %v", node.NodeName, node.UUID, err)
+		klog.Error(err)
 		return zone, err
 	}
 
 	zone.FailureDomain = zoneResult[cm.ZoneLabel]
 	zone.Region = zoneResult[cm.RegionLabel]
 
+	if cm.TagCacheTTL > 0 {
+		z.zoneCacheLock.Lock()
+		z.zoneCache[node.UUID] = cachedZone{zone: zone, expires: time.Now().Add(cm.TagCacheTTL)}
+		z.zoneCacheLock.Unlock()
+	}
+
 	return zone, nil
 }
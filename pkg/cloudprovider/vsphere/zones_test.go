@@ -16,10 +16,13 @@ package vsphere
 import (
 	"context"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/simulator"
@@ -35,6 +38,11 @@ func TestZones(t *testing.T) {
 	// Any context will do
 	ctx := context.Background()
 
+	// The tags change between the lookups, so they must not be cached
+	ttl := cm.TagCacheTTL
+	cm.TagCacheTTL = 0
+	defer func() { cm.TagCacheTTL = ttl }()
+
 	// Create a vcsim instance
 	cfg, close := configFromEnvOrSim(false)
 	defer close()
@@ -218,3 +226,93 @@ func TestZones(t *testing.T) {
 		}
 	}
 }
+
+func TestZonesDiscoveryAndCache(t *testing.T) {
+	ctx := context.Background()
+
+	ttl := cm.TagCacheTTL
+	cm.TagCacheTTL = time.Hour
+	defer func() { cm.TagCacheTTL = ttl }()
+
+	cfg, close := configFromEnvOrSim(false)
+	defer close()
+
+	connMgr := cm.NewConnectionManager(cfg, nil)
+	defer connMgr.Logout()
+
+	nm := newNodeManager(connMgr, nil)
+	zones := newZones(nm, cfg.Labels.Zone, cfg.Labels.Region)
+
+	err := connMgr.Connect(ctx, cfg.Global.VCenterIP)
+	if err != nil {
+		t.Errorf("Failed to connect to vSphere: %s", err)
+	}
+	vsi := connMgr.VsphereInstanceMap[cfg.Global.VCenterIP]
+
+	myvm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	myvm.Guest.HostName = strings.ToLower(myvm.Name)
+	host := simulator.Map.Get(*myvm.Runtime.Host).(*simulator.HostSystem)
+
+	c := rest.NewClient(vsi.Conn.Client)
+	user := url.UserPassword(vsi.Conn.Username, vsi.Conn.Password)
+	if err := c.Login(ctx, user); err != nil {
+		t.Fatalf("Rest login failed. err=%v", err)
+	}
+	m := tags.NewManager(c)
+
+	var tagIDs []string
+	for category, tag := range map[string]string{cfg.Labels.Region: "k8s-region-US", cfg.Labels.Zone: "k8s-zone-US-CA1"} {
+		categoryID, err := m.CreateCategory(ctx, &tags.Category{Name: category})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tagID, err := m.CreateTag(ctx, &tags.Tag{CategoryID: categoryID, Name: tag})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = m.AttachTag(ctx, tagID, host); err != nil {
+			t.Fatal(err)
+		}
+		tagIDs = append(tagIDs, tagID)
+	}
+
+	// a node that is not registered yet is discovered by name
+	zone, err := zones.GetZoneByNodeName(ctx, k8stypes.NodeName(myvm.Guest.HostName))
+	if err != nil {
+		t.Fatalf("GetZoneByNodeName failed: %v", err)
+	}
+	if zone.FailureDomain != "k8s-zone-US-CA1" || zone.Region != "k8s-region-US" {
+		t.Errorf("Zone mismatch: %+v", zone)
+	}
+
+	// the zone of the node is cached
+	for _, tagID := range tagIDs {
+		if err = m.DetachTag(ctx, tagID, host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cached, err := zones.GetZoneByProviderID(ctx, ProviderPrefix+myvm.Config.Uuid)
+	if err != nil {
+		t.Fatalf("GetZoneByProviderID failed: %v", err)
+	}
+	if cached != zone {
+		t.Errorf("The zone of the node should be cached: %+v != %+v", cached, zone)
+	}
+
+	// a node without tags of the categories fails with an error naming the
+	// node and its host
+	_, err = newZones(nm, "other-zone", "other-region").GetZoneByProviderID(ctx, ProviderPrefix+myvm.Config.Uuid)
+	if err == nil {
+		t.Fatal("GetZoneByProviderID of an untagged node should fail")
+	}
+	for _, s := range []string{myvm.Guest.HostName, host.Name, "other-region"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("The error should mention %s: %v", s, err)
+		}
+	}
+
+	// an unknown node is not found
+	if _, err = zones.GetZoneByNodeName(ctx, "unknown"); err != ErrVMNotFound {
+		t.Errorf("GetZoneByNodeName of an unknown node should fail with %v: %v", ErrVMNotFound, err)
+	}
+}
@@ -20,13 +20,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog"
 
 	"gopkg.in/gcfg.v1"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -34,10 +40,35 @@ const (
 	// before an error is returned.
 	DefaultRoundTripperCount uint = 3
 
+	// DefaultConnectTimeoutSecs is the default number of seconds to wait
+	// for a connection to a vCenter.
+	DefaultConnectTimeoutSecs uint = 30
+
+	// DefaultRequestTimeoutSecs is the default number of seconds a single
+	// vSphere API call may take.
+	DefaultRequestTimeoutSecs uint = 120
+
+	// DefaultOperationTimeoutSecs is the default number of seconds a CSI
+	// controller operation may take.
+	DefaultOperationTimeoutSecs uint = 300
+
 	// DefaultAPIBinding is the default ADDRESS:PORT binding used for
 	// exposing the API service.
 	DefaultAPIBinding string = ":43001"
 
+	// DefaultMetricsBinding is the default ADDRESS:PORT binding used for
+	// exposing the CSI controller metrics.
+	DefaultMetricsBinding string = ":43002"
+
+	// DefaultHealthBinding is the default ADDRESS:PORT binding used for
+	// exposing the health endpoints of the CSI controller.
+	DefaultHealthBinding string = ":43003"
+
+	// DefaultDebugBinding is the default ADDRESS:PORT binding used for
+	// exposing the debug endpoint of the CSI controller, which is only
+	// reachable from the host by default.
+	DefaultDebugBinding string = "127.0.0.1:43004"
+
 	// DefaultK8sServiceAccount is the default name of the Kubernetes
 	// service account.
 	DefaultK8sServiceAccount string = "cloud-controller-manager"
@@ -47,6 +78,78 @@ const (
 
 	// DefaultSecretDirectory is the default path to the secrets directory.
 	DefaultSecretDirectory string = "/etc/cloud/secrets"
+
+	// DefaultFCDCacheRefreshSecs is the default number of seconds between
+	// refreshes of the FCD inventory cache.
+	DefaultFCDCacheRefreshSecs uint = 300
+
+	// DefaultListVolumesConcurrency is the default number of datastores
+	// whose FCDs are listed at a time.
+	DefaultListVolumesConcurrency uint = 8
+
+	// DefaultVolumeSizeGB is the default size, in GiB, of the volumes
+	// whose request and StorageClass do not set one.
+	DefaultVolumeSizeGB uint = 10
+
+	// DefaultOrphanedVolumeGCMinAgeSecs is the default number of seconds
+	// an orphaned FCD must exist for before it is deleted.
+	DefaultOrphanedVolumeGCMinAgeSecs uint = 3600
+
+	// DefaultShutdownDrainTimeoutSecs is the default number of seconds the
+	// CSI controller waits for the operations in flight when shutting down.
+	DefaultShutdownDrainTimeoutSecs uint = 30
+
+	// DefaultLeaderElectNamespace is the default namespace of the ConfigMap
+	// holding the lease of the leader of the CSI controllers.
+	DefaultLeaderElectNamespace string = "kube-system"
+
+	// DefaultNamespaceQuotaConfigMapNamespace is the default namespace of
+	// the ConfigMap of the capacity limits of the namespaces.
+	DefaultNamespaceQuotaConfigMapNamespace string = "kube-system"
+
+	// DefaultLeaderElectLeaseDurationSecs is the default number of seconds
+	// a lease of the leader lasts without being renewed.
+	DefaultLeaderElectLeaseDurationSecs uint = 15
+
+	// DefaultLeaderElectRenewDeadlineSecs is the default number of seconds
+	// the leader tries to renew its lease for.
+	DefaultLeaderElectRenewDeadlineSecs uint = 10
+
+	// DefaultVCSoapDebugMaxFiles is the default number of SOAP debug files
+	// kept for each vCenter.
+	DefaultVCSoapDebugMaxFiles uint = 1000
+
+	// DefaultSCSIControllerType is the default type of the SCSI controllers
+	// volumes are attached to.
+	DefaultSCSIControllerType string = "pvscsi"
+
+	// IPFamilyIPv4 is the IP family of the IPv4 addresses.
+	IPFamilyIPv4 string = "ipv4"
+
+	// IPFamilyIPv6 is the IP family of the IPv6 addresses.
+	IPFamilyIPv6 string = "ipv6"
+
+	// DefaultIPFamily is the default IP family of the reported node
+	// addresses.
+	DefaultIPFamily string = IPFamilyIPv4
+
+	// TopologyLabelsBeta is the family of the
+	// failure-domain.beta.kubernetes.io zone and region labels.
+	TopologyLabelsBeta string = "beta"
+
+	// TopologyLabelsGA is the family of the topology.kubernetes.io zone and
+	// region labels.
+	TopologyLabelsGA string = "ga"
+
+	// TopologyLabelsBoth reports the zone and region under both families.
+	TopologyLabelsBoth string = "both"
+
+	// DefaultTopologyLabels is the default family of the zone and region
+	// labels of the reported topologies.
+	DefaultTopologyLabels string = TopologyLabelsBeta
+
+	// redactedValue replaces the passwords in the logged config.
+	redactedValue string = "redacted"
 )
 
 // Errors
@@ -64,6 +167,79 @@ var (
 	// ErrMissingVCenter is returned when the provided configuration does not
 	// define any vCenters.
 	ErrMissingVCenter = errors.New("No Virtual Center hosts defined")
+
+	// ErrSecretNamespaceMissing is returned when a vCenter names a secret
+	// but neither it nor the global configuration names its namespace.
+	ErrSecretNamespaceMissing = errors.New("Secret namespace is missing")
+
+	// ErrInsecureWithThumbprint is returned when a vCenter both skips the
+	// verification of its certificate and has a thumbprint to verify it.
+	ErrInsecureWithThumbprint = errors.New("insecure-flag and thumbprint cannot both be set")
+
+	// ErrInvalidProxyURL is returned when a proxy URL is not a valid http,
+	// https or socks5 URL.
+	ErrInvalidProxyURL = errors.New("Proxy URL is not a valid http, https or socks5 URL")
+
+	// ErrInvalidVCenterPort is returned when a vCenter port is not a number
+	// in the range 1-65535.
+	ErrInvalidVCenterPort = errors.New("Port is not a number in the range 1-65535")
+
+	// ErrInvalidStorageEndpoint is returned when the storage endpoint of a
+	// vCenter is not a host name or IP.
+	ErrInvalidStorageEndpoint = errors.New("Storage endpoint is not a host name or IP")
+
+	// ErrIncompleteLabels is returned when only one of the zone and region
+	// labels is set.
+	ErrIncompleteLabels = errors.New("Zone and region labels must be set together")
+
+	// ErrIncompleteListVolumesTopology is returned when only one of
+	// list-volumes-zone and list-volumes-region is set.
+	ErrIncompleteListVolumesTopology = errors.New("list-volumes-zone and list-volumes-region must be set together")
+
+	// ErrInvalidVolumeSizeLimits is returned when the minimum volume size
+	// is above the maximum one.
+	ErrInvalidVolumeSizeLimits = errors.New("min-volume-size-gb must not exceed max-volume-size-gb")
+
+	// ErrOrphanedVolumeGCWithoutClusterID is returned when the orphaned
+	// volume scans are enabled without a cluster ID to tell the FCDs owned
+	// by the cluster by.
+	ErrOrphanedVolumeGCWithoutClusterID = errors.New("orphaned-volume-gc-interval-secs requires cluster-id")
+
+	// ErrCnsMetadataSyncWithoutClusterID is returned when the CNS metadata
+	// sync is enabled without the ID of the cluster the volumes belong to.
+	ErrCnsMetadataSyncWithoutClusterID = errors.New("cns-metadata-sync-interval-secs requires cluster-id")
+
+	// ErrInvalidLeaderElectTimeouts is returned when the leader would stop
+	// renewing its lease only after the other replicas took over.
+	ErrInvalidLeaderElectTimeouts = errors.New("leader-elect-renew-deadline-secs must be shorter than leader-elect-lease-duration-secs")
+
+	// ErrSoapDebugWithoutDir is returned when the SOAP round trips with a
+	// vCenter are traced without a directory to write them to.
+	ErrSoapDebugWithoutDir = errors.New("soap-debug requires vc-soap-debug-dir")
+
+	// ErrInvalidCIDR is returned when a subnet CIDR of the nodes is not a
+	// valid CIDR.
+	ErrInvalidCIDR = errors.New("Not a valid CIDR")
+
+	// ErrInvalidIPFamily is returned when the IP family of the nodes is not
+	// ipv4, ipv6 or a list of both.
+	ErrInvalidIPFamily = errors.New("IP family must be ipv4, ipv6, ipv4,ipv6 or ipv6,ipv4")
+
+	// ErrInvalidTopologyLabels is returned when the family of the topology
+	// labels is not beta, ga or both.
+	ErrInvalidTopologyLabels = errors.New("topology labels must be beta, ga or both")
+
+	// ErrInvalidSCSIControllerType is returned when the type of the SCSI
+	// controllers is not pvscsi, lsiLogic or lsiLogic-sas.
+	ErrInvalidSCSIControllerType = errors.New("SCSI controller type must be pvscsi, lsiLogic or lsiLogic-sas")
+
+	// ErrInvalidDatastorePattern is returned when a pattern of the datastore
+	// allow or deny list is not a valid glob pattern.
+	ErrInvalidDatastorePattern = errors.New("Not a valid datastore pattern")
+
+	// ErrUnsupportedConfigFormat is returned when the format of a config is
+	// neither INI nor YAML.
+	ErrUnsupportedConfigFormat = errors.New("Config format is not ini or yaml")
 )
 
 func getEnvKeyValue(match string, partial bool) (string, string, error) {
@@ -97,7 +273,97 @@ func getEnvKeyValue(match string, partial bool) (string, string, error) {
 // obtained from environment variables. If an environment variable is set
 // for a property that's already initialized, the environment variable's value
 // takes precedence.
+//
+// The credentials of a vCenter are resolved in the following order, the
+// first one set wins:
+//  1. the VSPHERE_VC_<NAME>_USER and VSPHERE_VC_<NAME>_PASSWORD environment
+//     variables, where NAME is the name of the vCenter in upper case with
+//     the characters other than letters and digits replaced by underscores,
+//     such as VSPHERE_VC_10_0_0_1_PASSWORD for vCenter 10.0.0.1
+//  2. the user and password of the VirtualCenter section
+//  3. the VSPHERE_USER and VSPHERE_PASSWORD environment variables
+//  4. the user and password of the Global section
+//
+// The effective configuration is logged, with the passwords redacted. All
+// of the problems of an invalid configuration are logged, and the first one
+// is returned as the sentinel error it was found with.
 func FromEnv(cfg *Config) error {
+	return firstProblem(validateFromEnv(cfg))
+}
+
+// validateFromEnv overrides the configuration from the environment and
+// validates it, returning all of its problems.
+func validateFromEnv(cfg *Config) error {
+	if err := overrideFromEnv(cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		klog.Errorf("Invalid config: %v", err)
+		return err
+	}
+	logEffectiveConfig(cfg)
+	return nil
+}
+
+// vcEnvKey returns the name of the environment variable that overrides a
+// property of a vCenter.
+func vcEnvKey(vcServer, property string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, vcServer)
+	return "VSPHERE_VC_" + strings.ToUpper(name) + "_" + property
+}
+
+// Redacted returns a copy of the configuration with the passwords and the
+// credentials of the proxy URLs redacted.
+func (cfg *Config) Redacted() *Config {
+	redacted := *cfg
+	if redacted.Global.Password != "" {
+		redacted.Global.Password = redactedValue
+	}
+	redacted.Global.ProxyURL = redactProxyURL(redacted.Global.ProxyURL)
+
+	redacted.VirtualCenter = make(map[string]*VirtualCenterConfig, len(cfg.VirtualCenter))
+	for vcServer, vcConfig := range cfg.VirtualCenter {
+		vc := *vcConfig
+		if vc.Password != "" {
+			vc.Password = redactedValue
+		}
+		vc.ProxyURL = redactProxyURL(vc.ProxyURL)
+		redacted.VirtualCenter[vcServer] = &vc
+	}
+	return &redacted
+}
+
+// redactProxyURL returns the proxy URL with its password redacted.
+func redactProxyURL(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.User == nil {
+		return proxyURL
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+	return u.String()
+}
+
+// logEffectiveConfig logs the configuration once the environment variables
+// are applied, so that operators can verify which values won.
+func logEffectiveConfig(cfg *Config) {
+	data, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		klog.Errorf("Failed to marshal the effective config. Err: %v", err)
+		return
+	}
+	klog.Infof("Effective config:\n%s", data)
+}
+
+// overrideFromEnv sets the properties of the configuration object that have
+// an environment variable set, without validating it.
+func overrideFromEnv(cfg *Config) error {
 
 	if cfg == nil {
 		return fmt.Errorf("Config object cannot be nil")
@@ -112,6 +378,9 @@ func FromEnv(cfg *Config) error {
 	if v := os.Getenv("VSPHERE_VCENTER"); v != "" {
 		cfg.Global.VCenterIP = v
 	}
+	if v := os.Getenv("VSPHERE_SERVER"); v != "" {
+		cfg.Global.VCenterIP = v
+	}
 	if v := os.Getenv("VSPHERE_VCENTER_PORT"); v != "" {
 		cfg.Global.VCenterPort = v
 	}
@@ -143,6 +412,286 @@ func FromEnv(cfg *Config) error {
 		}
 	}
 
+	if v := os.Getenv("VSPHERE_FCD_CACHE_REFRESH_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_FCD_CACHE_REFRESH_SECS: %s", err)
+		} else {
+			cfg.Global.FCDCacheRefreshSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_DATASTORE_MIN_FREE_SPACE_MB"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_DATASTORE_MIN_FREE_SPACE_MB: %s", err)
+		} else {
+			cfg.Global.DatastoreMinFreeSpaceMB = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_DEFAULT_VOLUME_SIZE_GB"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_DEFAULT_VOLUME_SIZE_GB: %s", err)
+		} else {
+			cfg.Global.DefaultVolumeSizeGB = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_MIN_VOLUME_SIZE_GB"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_MIN_VOLUME_SIZE_GB: %s", err)
+		} else {
+			cfg.Global.MinVolumeSizeGB = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_MAX_VOLUME_SIZE_GB"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_MAX_VOLUME_SIZE_GB: %s", err)
+		} else {
+			cfg.Global.MaxVolumeSizeGB = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_MAX_VOLUMES_PER_NODE"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_MAX_VOLUMES_PER_NODE: %s", err)
+		} else {
+			cfg.Global.MaxVolumesPerNode = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_FORCE_DELETE"); v != "" {
+		forceDelete, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_FORCE_DELETE: %s", err)
+		} else {
+			cfg.Global.ForceDelete = forceDelete
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_NAMESPACE_QUOTA_CONFIGMAP"); v != "" {
+		cfg.Global.NamespaceQuotaConfigMap = v
+	}
+	if v := os.Getenv("VSPHERE_NAMESPACE_QUOTA_CONFIGMAP_NAMESPACE"); v != "" {
+		cfg.Global.NamespaceQuotaConfigMapNamespace = v
+	}
+
+	if v := os.Getenv("VSPHERE_DATASTORE_ALLOWLIST"); v != "" {
+		cfg.Global.DatastoreAllowlist = v
+	}
+	if v := os.Getenv("VSPHERE_DATASTORE_DENYLIST"); v != "" {
+		cfg.Global.DatastoreDenylist = v
+	}
+
+	if v := os.Getenv("VSPHERE_LIST_VOLUMES_ZONE"); v != "" {
+		cfg.Global.ListVolumesZone = v
+	}
+	if v := os.Getenv("VSPHERE_LIST_VOLUMES_REGION"); v != "" {
+		cfg.Global.ListVolumesRegion = v
+	}
+	if v := os.Getenv("VSPHERE_LIST_VOLUMES_DATASTORES"); v != "" {
+		cfg.Global.ListVolumesDatastores = v
+	}
+	if v := os.Getenv("VSPHERE_LIST_VOLUMES_CONCURRENCY"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_LIST_VOLUMES_CONCURRENCY: %s", err)
+		} else {
+			cfg.Global.ListVolumesConcurrency = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_CLUSTER_ID"); v != "" {
+		cfg.Global.ClusterID = v
+	}
+
+	if v := os.Getenv("VSPHERE_ORPHANED_VOLUME_GC_INTERVAL_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_ORPHANED_VOLUME_GC_INTERVAL_SECS: %s", err)
+		} else {
+			cfg.Global.OrphanedVolumeGCIntervalSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_ORPHANED_VOLUME_GC_MIN_AGE_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_ORPHANED_VOLUME_GC_MIN_AGE_SECS: %s", err)
+		} else {
+			cfg.Global.OrphanedVolumeGCMinAgeSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_ORPHANED_VOLUME_GC_DRY_RUN"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_ORPHANED_VOLUME_GC_DRY_RUN: %s", err)
+		} else {
+			cfg.Global.OrphanedVolumeGCDryRun = dryRun
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_ATTACHMENT_RECONCILE_INTERVAL_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_ATTACHMENT_RECONCILE_INTERVAL_SECS: %s", err)
+		} else {
+			cfg.Global.AttachmentReconcileIntervalSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_ATTACHMENT_RECONCILE_DRY_RUN"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_ATTACHMENT_RECONCILE_DRY_RUN: %s", err)
+		} else {
+			cfg.Global.AttachmentReconcileDryRun = dryRun
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_CNS_METADATA_SYNC_INTERVAL_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_CNS_METADATA_SYNC_INTERVAL_SECS: %s", err)
+		} else {
+			cfg.Global.CnsMetadataSyncIntervalSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_SHUTDOWN_DRAIN_TIMEOUT_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_SHUTDOWN_DRAIN_TIMEOUT_SECS: %s", err)
+		} else {
+			cfg.Global.ShutdownDrainTimeoutSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_LEADER_ELECT"); v != "" {
+		leaderElect, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_LEADER_ELECT: %s", err)
+		} else {
+			cfg.Global.LeaderElect = leaderElect
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_LEADER_ELECT_NAMESPACE"); v != "" {
+		cfg.Global.LeaderElectNamespace = v
+	}
+
+	if v := os.Getenv("VSPHERE_LEADER_ELECT_LEASE_DURATION_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_LEADER_ELECT_LEASE_DURATION_SECS: %s", err)
+		} else {
+			cfg.Global.LeaderElectLeaseDurationSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_LEADER_ELECT_RENEW_DEADLINE_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_LEADER_ELECT_RENEW_DEADLINE_SECS: %s", err)
+		} else {
+			cfg.Global.LeaderElectRenewDeadlineSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_VC_SOAP_DEBUG"); v != "" {
+		soapDebug, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_VC_SOAP_DEBUG: %s", err)
+		} else {
+			cfg.Global.VCSoapDebug = soapDebug
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_VC_SOAP_DEBUG_DIR"); v != "" {
+		cfg.Global.VCSoapDebugDir = v
+	}
+
+	if v := os.Getenv("VSPHERE_VC_SOAP_DEBUG_MAX_FILES"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_VC_SOAP_DEBUG_MAX_FILES: %s", err)
+		} else {
+			cfg.Global.VCSoapDebugMaxFiles = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_CHECK_PERMISSIONS"); v != "" {
+		checkPermissions, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_CHECK_PERMISSIONS: %s", err)
+		} else {
+			cfg.Global.CheckPermissions = checkPermissions
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_SKIP_API_VERSION_GATES"); v != "" {
+		skipAPIVersionGates, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_SKIP_API_VERSION_GATES: %s", err)
+		} else {
+			cfg.Global.SkipAPIVersionGates = skipAPIVersionGates
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_CONNECT_TIMEOUT_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_CONNECT_TIMEOUT_SECS: %s", err)
+		} else {
+			cfg.Global.ConnectTimeoutSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_REQUEST_TIMEOUT_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_REQUEST_TIMEOUT_SECS: %s", err)
+		} else {
+			cfg.Global.RequestTimeoutSecs = uint(tmp)
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_OPERATION_TIMEOUT_SECS"); v != "" {
+		tmp, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_OPERATION_TIMEOUT_SECS: %s", err)
+		} else {
+			cfg.Global.OperationTimeoutSecs = uint(tmp)
+		}
+	}
+
+	for env, value := range map[string]*uint{
+		"VSPHERE_API_READ_QPS":    &cfg.Global.APIReadQPS,
+		"VSPHERE_API_READ_BURST":  &cfg.Global.APIReadBurst,
+		"VSPHERE_API_WRITE_QPS":   &cfg.Global.APIWriteQPS,
+		"VSPHERE_API_WRITE_BURST": &cfg.Global.APIWriteBurst,
+	} {
+		if v := os.Getenv(env); v != "" {
+			tmp, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				klog.Errorf("Failed to parse %s: %s", env, err)
+			} else {
+				*value = uint(tmp)
+			}
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_SCSI_CONTROLLER_TYPE"); v != "" {
+		cfg.Global.SCSIControllerType = v
+	}
+
 	if v := os.Getenv("VSPHERE_INSECURE"); v != "" {
 		InsecureFlag, err := strconv.ParseBool(v)
 		if err != nil {
@@ -165,6 +714,27 @@ func FromEnv(cfg *Config) error {
 		cfg.Global.APIBinding = v
 	}
 
+	if v := os.Getenv("VSPHERE_METRICS_BINDING"); v != "" {
+		cfg.Global.MetricsBinding = v
+	}
+
+	if v := os.Getenv("VSPHERE_HEALTH_BINDING"); v != "" {
+		cfg.Global.HealthBinding = v
+	}
+
+	if v := os.Getenv("VSPHERE_DEBUG_ENABLED"); v != "" {
+		debugEnabled, err := strconv.ParseBool(v)
+		if err != nil {
+			klog.Errorf("Failed to parse VSPHERE_DEBUG_ENABLED: %s", err)
+		} else {
+			cfg.Global.DebugEnabled = debugEnabled
+		}
+	}
+
+	if v := os.Getenv("VSPHERE_DEBUG_BINDING"); v != "" {
+		cfg.Global.DebugBinding = v
+	}
+
 	if v := os.Getenv("VSPHERE_SECRETS_DIRECTORY"); v != "" {
 		cfg.Global.SecretsDirectory = v
 	}
@@ -178,6 +748,15 @@ func FromEnv(cfg *Config) error {
 	if v := os.Getenv("VSPHERE_CAFILE"); v != "" {
 		cfg.Global.CAFile = v
 	}
+	if v := os.Getenv("VSPHERE_CADATA"); v != "" {
+		cfg.Global.CAData = v
+	}
+	if v := os.Getenv("VSPHERE_PROXY_URL"); v != "" {
+		cfg.Global.ProxyURL = v
+	}
+	if v := os.Getenv("VSPHERE_NO_PROXY"); v != "" {
+		cfg.Global.NoProxy = v
+	}
 	if v := os.Getenv("VSPHERE_THUMBPRINT"); v != "" {
 		cfg.Global.Thumbprint = v
 	}
@@ -187,6 +766,24 @@ func FromEnv(cfg *Config) error {
 	if v := os.Getenv("VSPHERE_LABEL_ZONE"); v != "" {
 		cfg.Labels.Zone = v
 	}
+	if v := os.Getenv("VSPHERE_LABEL_TOPOLOGY_LABELS"); v != "" {
+		cfg.Labels.TopologyLabels = v
+	}
+	if v := os.Getenv("VSPHERE_NODES_INTERNAL_NETWORK_NAME"); v != "" {
+		cfg.Nodes.InternalNetworkName = v
+	}
+	if v := os.Getenv("VSPHERE_NODES_EXTERNAL_NETWORK_NAME"); v != "" {
+		cfg.Nodes.ExternalNetworkName = v
+	}
+	if v := os.Getenv("VSPHERE_NODES_INTERNAL_NETWORK_SUBNET_CIDR"); v != "" {
+		cfg.Nodes.InternalNetworkSubnetCIDR = v
+	}
+	if v := os.Getenv("VSPHERE_NODES_EXTERNAL_NETWORK_SUBNET_CIDR"); v != "" {
+		cfg.Nodes.ExternalNetworkSubnetCIDR = v
+	}
+	if v := os.Getenv("VSPHERE_NODES_IP_FAMILY"); v != "" {
+		cfg.Nodes.IPFamily = v
+	}
 
 	//Build VirtualCenter from ENVs
 	for _, e := range os.Environ() {
@@ -235,54 +832,132 @@ func FromEnv(cfg *Config) error {
 					roundtrip = uint(roundtripFlagTmp)
 				}
 			}
+			connectTimeout := cfg.Global.ConnectTimeoutSecs
+			if _, v, err := getEnvKeyValue("VCENTER_"+id+"_CONNECT_TIMEOUT_SECS", false); err == nil {
+				tmp, err := strconv.ParseUint(v, 10, 32)
+				if err != nil {
+					klog.Errorf("Failed to parse VCENTER_%s_CONNECT_TIMEOUT_SECS: %s", id, err)
+				} else {
+					connectTimeout = uint(tmp)
+				}
+			}
+			requestTimeout := cfg.Global.RequestTimeoutSecs
+			if _, v, err := getEnvKeyValue("VCENTER_"+id+"_REQUEST_TIMEOUT_SECS", false); err == nil {
+				tmp, err := strconv.ParseUint(v, 10, 32)
+				if err != nil {
+					klog.Errorf("Failed to parse VCENTER_%s_REQUEST_TIMEOUT_SECS: %s", id, err)
+				} else {
+					requestTimeout = uint(tmp)
+				}
+			}
 			_, caFile, errCaFile := getEnvKeyValue("VCENTER_"+id+"_CAFILE", false)
 			if errCaFile != nil {
 				caFile = cfg.Global.CAFile
 			}
+			_, caData, errCaData := getEnvKeyValue("VCENTER_"+id+"_CADATA", false)
+			if errCaData != nil {
+				caData = cfg.Global.CAData
+			}
 			_, thumbprint, errThumbprint := getEnvKeyValue("VCENTER_"+id+"_THUMBPRINT", false)
 			if errThumbprint != nil {
 				thumbprint = cfg.Global.Thumbprint
 			}
+			_, proxyURL, errProxyURL := getEnvKeyValue("VCENTER_"+id+"_PROXY_URL", false)
+			if errProxyURL != nil {
+				proxyURL = cfg.Global.ProxyURL
+			}
+			_, noProxy, errNoProxy := getEnvKeyValue("VCENTER_"+id+"_NO_PROXY", false)
+			if errNoProxy != nil {
+				noProxy = cfg.Global.NoProxy
+			}
+			_, secretName, _ := getEnvKeyValue("VCENTER_"+id+"_SECRET_NAME", false)
+			_, secretNamespace, _ := getEnvKeyValue("VCENTER_"+id+"_SECRET_NAMESPACE", false)
 
 			cfg.VirtualCenter[vcenter] = &VirtualCenterConfig{
-				User:              username,
-				Password:          password,
-				VCenterPort:       port,
-				InsecureFlag:      insecureFlag,
-				Datacenters:       datacenters,
-				RoundTripperCount: roundtrip,
-				CAFile:            caFile,
-				Thumbprint:        thumbprint,
+				User:               username,
+				Password:           password,
+				VCenterPort:        port,
+				InsecureFlag:       insecureFlag,
+				Datacenters:        datacenters,
+				RoundTripperCount:  roundtrip,
+				ConnectTimeoutSecs: connectTimeout,
+				RequestTimeoutSecs: requestTimeout,
+				APIReadQPS:         cfg.Global.APIReadQPS,
+				APIReadBurst:       cfg.Global.APIReadBurst,
+				APIWriteQPS:        cfg.Global.APIWriteQPS,
+				APIWriteBurst:      cfg.Global.APIWriteBurst,
+				CAFile:             caFile,
+				CAData:             caData,
+				Thumbprint:         thumbprint,
+				ProxyURL:           proxyURL,
+				NoProxy:            noProxy,
+				SecretName:         secretName,
+				SecretNamespace:    secretNamespace,
 			}
 		}
 	}
 
 	if cfg.Global.VCenterIP != "" && cfg.VirtualCenter[cfg.Global.VCenterIP] == nil {
 		cfg.VirtualCenter[cfg.Global.VCenterIP] = &VirtualCenterConfig{
-			User:              cfg.Global.User,
-			Password:          cfg.Global.Password,
-			VCenterPort:       cfg.Global.VCenterPort,
-			InsecureFlag:      cfg.Global.InsecureFlag,
-			Datacenters:       cfg.Global.Datacenters,
-			RoundTripperCount: cfg.Global.RoundTripperCount,
-			CAFile:            cfg.Global.CAFile,
-			Thumbprint:        cfg.Global.Thumbprint,
+			User:               cfg.Global.User,
+			Password:           cfg.Global.Password,
+			VCenterPort:        cfg.Global.VCenterPort,
+			InsecureFlag:       cfg.Global.InsecureFlag,
+			Datacenters:        cfg.Global.Datacenters,
+			RoundTripperCount:  cfg.Global.RoundTripperCount,
+			ConnectTimeoutSecs: cfg.Global.ConnectTimeoutSecs,
+			RequestTimeoutSecs: cfg.Global.RequestTimeoutSecs,
+			APIReadQPS:         cfg.Global.APIReadQPS,
+			APIReadBurst:       cfg.Global.APIReadBurst,
+			APIWriteQPS:        cfg.Global.APIWriteQPS,
+			APIWriteBurst:      cfg.Global.APIWriteBurst,
+			CAFile:             cfg.Global.CAFile,
+			CAData:             cfg.Global.CAData,
+			Thumbprint:         cfg.Global.Thumbprint,
+			ProxyURL:           cfg.Global.ProxyURL,
+			NoProxy:            cfg.Global.NoProxy,
 		}
 	}
 
-	err := validateConfig(cfg)
-	if err != nil {
-		return err
+	// The credentials of each vCenter, keyed by its name
+	for vcServer, vcConfig := range cfg.VirtualCenter {
+		if v := os.Getenv(vcEnvKey(vcServer, "USER")); v != "" {
+			vcConfig.User = v
+		}
+		if v := os.Getenv(vcEnvKey(vcServer, "PASSWORD")); v != "" {
+			vcConfig.Password = v
+		}
 	}
 
 	return nil
 }
 
-func validateConfig(cfg *Config) error {
+// validProxyURL returns true when the proxy URL is an http, https or
+// socks5 URL.
+func validProxyURL(proxyURL string) bool {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "socks5"
+}
+
+// setDefaults fills in the defaults of the Global section, and creates the
+// vCenter of the Global section if it is not defined in its own section.
+func (cfg *Config) setDefaults() {
 	//Fix default global values
 	if cfg.Global.RoundTripperCount == 0 {
 		cfg.Global.RoundTripperCount = DefaultRoundTripperCount
 	}
+	if cfg.Global.ConnectTimeoutSecs == 0 {
+		cfg.Global.ConnectTimeoutSecs = DefaultConnectTimeoutSecs
+	}
+	if cfg.Global.RequestTimeoutSecs == 0 {
+		cfg.Global.RequestTimeoutSecs = DefaultRequestTimeoutSecs
+	}
+	if cfg.Global.OperationTimeoutSecs == 0 {
+		cfg.Global.OperationTimeoutSecs = DefaultOperationTimeoutSecs
+	}
 	if cfg.Global.ServiceAccount == "" {
 		cfg.Global.ServiceAccount = DefaultK8sServiceAccount
 	}
@@ -292,56 +967,113 @@ func validateConfig(cfg *Config) error {
 	if cfg.Global.APIBinding == "" {
 		cfg.Global.APIBinding = DefaultAPIBinding
 	}
+	if cfg.Global.MetricsBinding == "" {
+		cfg.Global.MetricsBinding = DefaultMetricsBinding
+	}
+	if cfg.Global.HealthBinding == "" {
+		cfg.Global.HealthBinding = DefaultHealthBinding
+	}
+	if cfg.Global.DebugBinding == "" {
+		cfg.Global.DebugBinding = DefaultDebugBinding
+	}
+	if cfg.Global.FCDCacheRefreshSecs == 0 {
+		cfg.Global.FCDCacheRefreshSecs = DefaultFCDCacheRefreshSecs
+	}
+	if cfg.Global.ListVolumesConcurrency == 0 {
+		cfg.Global.ListVolumesConcurrency = DefaultListVolumesConcurrency
+	}
+	if cfg.Global.SCSIControllerType == "" {
+		cfg.Global.SCSIControllerType = DefaultSCSIControllerType
+	}
+	if cfg.Global.DefaultVolumeSizeGB == 0 {
+		cfg.Global.DefaultVolumeSizeGB = DefaultVolumeSizeGB
+	}
+	if cfg.Global.OrphanedVolumeGCMinAgeSecs == 0 {
+		cfg.Global.OrphanedVolumeGCMinAgeSecs = DefaultOrphanedVolumeGCMinAgeSecs
+	}
+	if cfg.Global.ShutdownDrainTimeoutSecs == 0 {
+		cfg.Global.ShutdownDrainTimeoutSecs = DefaultShutdownDrainTimeoutSecs
+	}
+	if cfg.Global.LeaderElectNamespace == "" {
+		cfg.Global.LeaderElectNamespace = DefaultLeaderElectNamespace
+	}
+	if cfg.Global.NamespaceQuotaConfigMapNamespace == "" {
+		cfg.Global.NamespaceQuotaConfigMapNamespace = DefaultNamespaceQuotaConfigMapNamespace
+	}
+	if cfg.Global.LeaderElectLeaseDurationSecs == 0 {
+		cfg.Global.LeaderElectLeaseDurationSecs = DefaultLeaderElectLeaseDurationSecs
+	}
+	if cfg.Global.LeaderElectRenewDeadlineSecs == 0 {
+		cfg.Global.LeaderElectRenewDeadlineSecs = DefaultLeaderElectRenewDeadlineSecs
+	}
+	if cfg.Global.VCSoapDebugMaxFiles == 0 {
+		cfg.Global.VCSoapDebugMaxFiles = DefaultVCSoapDebugMaxFiles
+	}
+	if cfg.Nodes.IPFamily == "" {
+		cfg.Nodes.IPFamily = DefaultIPFamily
+	}
+	if cfg.Labels.TopologyLabels == "" {
+		cfg.Labels.TopologyLabels = DefaultTopologyLabels
+	}
 
 	isSecretInfoProvided := true
 	if (cfg.Global.SecretName == "" || cfg.Global.SecretNamespace == "") && cfg.Global.SecretsDirectory == "" {
 		isSecretInfoProvided = false
 	}
 
+	if cfg.VirtualCenter == nil {
+		cfg.VirtualCenter = make(map[string]*VirtualCenterConfig)
+	}
+	// A vCenter without any property is decoded as nil
+	for vcServer, vcConfig := range cfg.VirtualCenter {
+		if vcConfig == nil {
+			cfg.VirtualCenter[vcServer] = &VirtualCenterConfig{}
+		}
+	}
+
 	// Create a single instance of VSphereInstance for the Global VCenterIP if the
 	// VirtualCenter does not already exist in the map
 	if !isSecretInfoProvided && cfg.Global.VCenterIP != "" && cfg.VirtualCenter[cfg.Global.VCenterIP] == nil {
 		vcConfig := &VirtualCenterConfig{
-			User:              cfg.Global.User,
-			Password:          cfg.Global.Password,
-			VCenterPort:       cfg.Global.VCenterPort,
-			InsecureFlag:      cfg.Global.InsecureFlag,
-			Datacenters:       cfg.Global.Datacenters,
-			RoundTripperCount: cfg.Global.RoundTripperCount,
-			CAFile:            cfg.Global.CAFile,
-			Thumbprint:        cfg.Global.Thumbprint,
+			User:               cfg.Global.User,
+			Password:           cfg.Global.Password,
+			VCenterPort:        cfg.Global.VCenterPort,
+			InsecureFlag:       cfg.Global.InsecureFlag,
+			Datacenters:        cfg.Global.Datacenters,
+			RoundTripperCount:  cfg.Global.RoundTripperCount,
+			ConnectTimeoutSecs: cfg.Global.ConnectTimeoutSecs,
+			RequestTimeoutSecs: cfg.Global.RequestTimeoutSecs,
+			APIReadQPS:         cfg.Global.APIReadQPS,
+			APIReadBurst:       cfg.Global.APIReadBurst,
+			APIWriteQPS:        cfg.Global.APIWriteQPS,
+			APIWriteBurst:      cfg.Global.APIWriteBurst,
+			CAFile:             cfg.Global.CAFile,
+			CAData:             cfg.Global.CAData,
+			Thumbprint:         cfg.Global.Thumbprint,
+			ProxyURL:           cfg.Global.ProxyURL,
+			NoProxy:            cfg.Global.NoProxy,
 		}
 		cfg.VirtualCenter[cfg.Global.VCenterIP] = vcConfig
 	}
+}
 
-	// Must have at least one vCenter defined
-	if len(cfg.VirtualCenter) == 0 {
-		klog.Error(ErrMissingVCenter)
-		return ErrMissingVCenter
-	}
+// inheritGlobal fills in the properties of each vCenter that are not set in
+// its own section from the Global section.
+func (cfg *Config) inheritGlobal() {
+	isSecretInfoProvided := (cfg.Global.SecretName != "" && cfg.Global.SecretNamespace != "") || cfg.Global.SecretsDirectory != ""
 
-	// vsphere.conf is no longer supported in the old format.
 	for vcServer, vcConfig := range cfg.VirtualCenter {
 		klog.V(4).Infof("Initializing vc server %s", vcServer)
-		if vcServer == "" {
-			klog.Error(ErrInvalidVCenterIP)
-			return ErrInvalidVCenterIP
-		}
 
-		if !isSecretInfoProvided {
+		if vcConfig.SecretName != "" && vcConfig.SecretNamespace == "" {
+			vcConfig.SecretNamespace = cfg.Global.SecretNamespace
+		}
+		if !isSecretInfoProvided && vcConfig.SecretName == "" {
 			if vcConfig.User == "" {
 				vcConfig.User = cfg.Global.User
-				if vcConfig.User == "" {
-					klog.Errorf("vcConfig.User is empty for vc %s!", vcServer)
-					return ErrUsernameMissing
-				}
 			}
 			if vcConfig.Password == "" {
 				vcConfig.Password = cfg.Global.Password
-				if vcConfig.Password == "" {
-					klog.Errorf("vcConfig.Password is empty for vc %s!", vcServer)
-					return ErrPasswordMissing
-				}
 			}
 		}
 
@@ -357,21 +1089,292 @@ func validateConfig(cfg *Config) error {
 		if vcConfig.RoundTripperCount == 0 {
 			vcConfig.RoundTripperCount = cfg.Global.RoundTripperCount
 		}
+		if vcConfig.ConnectTimeoutSecs == 0 {
+			vcConfig.ConnectTimeoutSecs = cfg.Global.ConnectTimeoutSecs
+		}
+		if vcConfig.RequestTimeoutSecs == 0 {
+			vcConfig.RequestTimeoutSecs = cfg.Global.RequestTimeoutSecs
+		}
+		if vcConfig.APIReadQPS == 0 {
+			vcConfig.APIReadQPS = cfg.Global.APIReadQPS
+		}
+		if vcConfig.APIReadBurst == 0 {
+			vcConfig.APIReadBurst = cfg.Global.APIReadBurst
+		}
+		if vcConfig.APIWriteQPS == 0 {
+			vcConfig.APIWriteQPS = cfg.Global.APIWriteQPS
+		}
+		if vcConfig.APIWriteBurst == 0 {
+			vcConfig.APIWriteBurst = cfg.Global.APIWriteBurst
+		}
 		if vcConfig.CAFile == "" {
 			vcConfig.CAFile = cfg.Global.CAFile
 		}
+		if vcConfig.CAData == "" {
+			vcConfig.CAData = cfg.Global.CAData
+		}
 		if vcConfig.Thumbprint == "" {
 			vcConfig.Thumbprint = cfg.Global.Thumbprint
 		}
+		if vcConfig.ProxyURL == "" {
+			vcConfig.ProxyURL = cfg.Global.ProxyURL
+		}
+		if vcConfig.NoProxy == "" {
+			vcConfig.NoProxy = cfg.Global.NoProxy
+		}
 
-		insecure := vcConfig.InsecureFlag
-		if !insecure {
-			insecure = cfg.Global.InsecureFlag
+		if !vcConfig.InsecureFlag {
 			vcConfig.InsecureFlag = cfg.Global.InsecureFlag
 		}
+
+		if vcConfig.StorageEndpoint != "" {
+			if vcConfig.StorageEndpointPort == "" {
+				vcConfig.StorageEndpointPort = vcConfig.VCenterPort
+			}
+			storageInsecure := vcConfig.InsecureFlag
+			if vcConfig.StorageEndpointInsecureFlag != nil {
+				storageInsecure = *vcConfig.StorageEndpointInsecureFlag
+			}
+			vcConfig.StorageEndpointInsecureFlag = &storageInsecure
+			if vcConfig.StorageEndpointCAFile == "" {
+				vcConfig.StorageEndpointCAFile = vcConfig.CAFile
+			}
+			if vcConfig.StorageEndpointCAData == "" {
+				vcConfig.StorageEndpointCAData = vcConfig.CAData
+			}
+			if vcConfig.StorageEndpointThumbprint == "" && !storageInsecure {
+				vcConfig.StorageEndpointThumbprint = vcConfig.Thumbprint
+			}
+		}
 	}
+}
 
-	return nil
+// validPort returns true when the port is a number in the range 1-65535.
+func validPort(port string) bool {
+	p, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && p > 0
+}
+
+// validStorageEndpoint returns true when a storage endpoint is a host name
+// or IP, without a scheme, port or path.
+func validStorageEndpoint(endpoint string) bool {
+	return !strings.ContainsAny(endpoint, ":/") || net.ParseIP(endpoint) != nil
+}
+
+// validTopologyLabels returns true when a family of topology labels is
+// known, or empty for the default one.
+func validTopologyLabels(labels string) bool {
+	switch labels {
+	case "", TopologyLabelsBeta, TopologyLabelsGA, TopologyLabelsBoth:
+		return true
+	}
+	return false
+}
+
+// validSCSIControllerType returns true when a type of SCSI controllers is
+// supported, or empty for the default one.
+func validSCSIControllerType(controllerType string) bool {
+	switch controllerType {
+	case "", "pvscsi", "lsiLogic", "lsiLogic-sas":
+		return true
+	}
+	return false
+}
+
+// ParseCIDRs parses a comma-separated list of CIDRs. Blank entries are
+// ignored.
+func ParseCIDRs(cidrs string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", cidr, ErrInvalidCIDR)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// SoapDebugDir returns the directory the SOAP round trips with the vCenter
+// are written to, or "" when they are not.
+func (cfg *Config) SoapDebugDir(vcServer string) string {
+	enabled := cfg.Global.VCSoapDebug
+	if vcConfig := cfg.VirtualCenter[vcServer]; vcConfig != nil && vcConfig.SoapDebug != nil {
+		enabled = *vcConfig.SoapDebug
+	}
+	if !enabled || cfg.Global.VCSoapDebugDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.Global.VCSoapDebugDir, vcServer)
+}
+
+// Validate fills in the defaults of the configuration, then checks that it
+// defines at least one vCenter, that each of them has a source of
+// credentials and a valid port, and that the zone and region are set
+// together. All of the problems are returned at once, and a single problem
+// is returned as the sentinel error it was found with. Once valid, each
+// vCenter inherits the properties it does not set from the Global section.
+func (cfg *Config) Validate() error {
+	cfg.setDefaults()
+
+	var errs []error
+
+	if len(cfg.VirtualCenter) == 0 {
+		errs = append(errs, ErrMissingVCenter)
+	}
+	if cfg.Global.VCenterPort != "" && !validPort(cfg.Global.VCenterPort) {
+		errs = append(errs, &validationError{fmt.Sprintf("Global port %q", cfg.Global.VCenterPort), ErrInvalidVCenterPort})
+	}
+
+	isSecretInfoProvided := (cfg.Global.SecretName != "" && cfg.Global.SecretNamespace != "") || cfg.Global.SecretsDirectory != ""
+
+	vcServers := make([]string, 0, len(cfg.VirtualCenter))
+	for vcServer := range cfg.VirtualCenter {
+		vcServers = append(vcServers, vcServer)
+	}
+	sort.Strings(vcServers)
+
+	for _, vcServer := range vcServers {
+		vcConfig := cfg.VirtualCenter[vcServer]
+		if vcServer == "" {
+			errs = append(errs, ErrInvalidVCenterIP)
+			continue
+		}
+
+		if vcConfig.SecretName != "" {
+			if vcConfig.SecretNamespace == "" && cfg.Global.SecretNamespace == "" {
+				errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s", vcServer), ErrSecretNamespaceMissing})
+			}
+		} else if !isSecretInfoProvided {
+			if vcConfig.User == "" && cfg.Global.User == "" {
+				errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s", vcServer), ErrUsernameMissing})
+			}
+			if vcConfig.Password == "" && cfg.Global.Password == "" {
+				errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s", vcServer), ErrPasswordMissing})
+			}
+		}
+
+		if vcConfig.VCenterPort != "" && !validPort(vcConfig.VCenterPort) {
+			errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s port %q", vcServer, vcConfig.VCenterPort), ErrInvalidVCenterPort})
+		}
+		if vcConfig.StorageEndpoint != "" && !validStorageEndpoint(vcConfig.StorageEndpoint) {
+			errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s storage-endpoint %q", vcServer, vcConfig.StorageEndpoint), ErrInvalidStorageEndpoint})
+		}
+		if vcConfig.StorageEndpointPort != "" && !validPort(vcConfig.StorageEndpointPort) {
+			errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s storage-endpoint-port %q", vcServer, vcConfig.StorageEndpointPort), ErrInvalidVCenterPort})
+		}
+
+		proxyURL := vcConfig.ProxyURL
+		if proxyURL == "" {
+			proxyURL = cfg.Global.ProxyURL
+		}
+		if proxyURL != "" && !validProxyURL(proxyURL) {
+			// The URL is not reported as it may hold credentials
+			errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s", vcServer), ErrInvalidProxyURL})
+		}
+
+		insecure := vcConfig.InsecureFlag || cfg.Global.InsecureFlag
+		if insecure && (vcConfig.Thumbprint != "" || cfg.Global.Thumbprint != "") {
+			errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s", vcServer), ErrInsecureWithThumbprint})
+		}
+		if vcConfig.StorageEndpointInsecureFlag != nil {
+			insecure = *vcConfig.StorageEndpointInsecureFlag
+		}
+		if vcConfig.StorageEndpoint != "" && insecure && vcConfig.StorageEndpointThumbprint != "" {
+			errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s storage-endpoint", vcServer), ErrInsecureWithThumbprint})
+		}
+		if vcConfig.SoapDebug != nil && *vcConfig.SoapDebug && cfg.Global.VCSoapDebugDir == "" {
+			errs = append(errs, &validationError{fmt.Sprintf("VirtualCenter %s", vcServer), ErrSoapDebugWithoutDir})
+		}
+	}
+
+	if (cfg.Labels.Zone == "") != (cfg.Labels.Region == "") {
+		errs = append(errs, ErrIncompleteLabels)
+	}
+	if !validTopologyLabels(cfg.Labels.TopologyLabels) {
+		errs = append(errs, &validationError{fmt.Sprintf("Labels topology-labels %q", cfg.Labels.TopologyLabels), ErrInvalidTopologyLabels})
+	}
+	if !validSCSIControllerType(cfg.Global.SCSIControllerType) {
+		errs = append(errs, &validationError{fmt.Sprintf("Global scsi-controller-type %q", cfg.Global.SCSIControllerType), ErrInvalidSCSIControllerType})
+	}
+	if (cfg.Global.ListVolumesZone == "") != (cfg.Global.ListVolumesRegion == "") {
+		errs = append(errs, ErrIncompleteListVolumesTopology)
+	}
+	if cfg.Global.MaxVolumeSizeGB > 0 && cfg.Global.MinVolumeSizeGB > cfg.Global.MaxVolumeSizeGB {
+		errs = append(errs, ErrInvalidVolumeSizeLimits)
+	}
+	if cfg.Global.OrphanedVolumeGCIntervalSecs > 0 && cfg.Global.ClusterID == "" {
+		errs = append(errs, ErrOrphanedVolumeGCWithoutClusterID)
+	}
+	if cfg.Global.CnsMetadataSyncIntervalSecs > 0 && cfg.Global.ClusterID == "" {
+		errs = append(errs, ErrCnsMetadataSyncWithoutClusterID)
+	}
+	if cfg.Global.LeaderElect && cfg.Global.LeaderElectRenewDeadlineSecs >= cfg.Global.LeaderElectLeaseDurationSecs {
+		errs = append(errs, ErrInvalidLeaderElectTimeouts)
+	}
+	if cfg.Global.VCSoapDebug && cfg.Global.VCSoapDebugDir == "" {
+		errs = append(errs, ErrSoapDebugWithoutDir)
+	}
+	if _, err := ParseDatastorePatterns(cfg.Global.DatastoreAllowlist); err != nil {
+		errs = append(errs, &validationError{"Global datastore-allowlist", err})
+	}
+	if _, err := ParseDatastorePatterns(cfg.Global.DatastoreDenylist); err != nil {
+		errs = append(errs, &validationError{"Global datastore-denylist", err})
+	}
+	if _, err := ParseDatastorePatterns(cfg.Global.ListVolumesDatastores); err != nil {
+		errs = append(errs, &validationError{"Global list-volumes-datastores", err})
+	}
+	if _, err := ParseCIDRs(cfg.Nodes.InternalNetworkSubnetCIDR); err != nil {
+		errs = append(errs, &validationError{"Nodes internal-network-subnet-cidr", err})
+	}
+	if _, err := ParseCIDRs(cfg.Nodes.ExternalNetworkSubnetCIDR); err != nil {
+		errs = append(errs, &validationError{"Nodes external-network-subnet-cidr", err})
+	}
+	if _, err := ParseIPFamilies(cfg.Nodes.IPFamily); err != nil {
+		errs = append(errs, &validationError{"Nodes ip-family", err})
+	}
+
+	switch len(errs) {
+	case 0:
+		cfg.inheritGlobal()
+		return nil
+	case 1:
+		return firstProblem(errs[0])
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validationError is a problem of the configuration along with the setting
+// it was found in.
+type validationError struct {
+	setting string
+	err     error
+}
+
+func (e *validationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.setting, e.err)
+}
+
+// Unwrap returns the error of the problem, so that errors.Is matches it
+// with the sentinel errors.
+func (e *validationError) Unwrap() error {
+	return e.err
+}
+
+// firstProblem returns the error of the first of the problems returned by
+// Validate, which the callers compare with the sentinel errors.
+func firstProblem(err error) error {
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		err = agg.Errors()[0]
+	}
+	if verr, ok := err.(*validationError); ok {
+		return verr.err
+	}
+	return err
 }
 
 // ReadConfig parses vSphere cloud config file and stores it into VSphereConfig.
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DatastoreFilter restricts the datastores, and datastore clusters, the
+// volumes are created on to the ones whose name matches a pattern of the
+// allow list, when it is not empty, and no pattern of the deny list. A nil
+// filter allows every datastore.
+type DatastoreFilter struct {
+	Allowlist []string
+	Denylist  []string
+}
+
+// ParseDatastorePatterns parses a comma-separated list of glob patterns, as
+// matched by path.Match. Blank entries are ignored.
+func ParseDatastorePatterns(patterns string) ([]string, error) {
+	var parsed []string
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%q: %v", pattern, ErrInvalidDatastorePattern)
+		}
+		parsed = append(parsed, pattern)
+	}
+	return parsed, nil
+}
+
+// NewDatastoreFilter returns the filter of the comma-separated patterns of
+// the datastore allow and deny lists.
+func NewDatastoreFilter(allowlist string, denylist string) (*DatastoreFilter, error) {
+	allowed, err := ParseDatastorePatterns(allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("datastore-allowlist: %v", err)
+	}
+	denied, err := ParseDatastorePatterns(denylist)
+	if err != nil {
+		return nil, fmt.Errorf("datastore-denylist: %v", err)
+	}
+	return &DatastoreFilter{Allowlist: allowed, Denylist: denied}, nil
+}
+
+// Check returns an error naming the rule that a datastore, or datastore
+// cluster, violates, or nil when volumes may be created on it.
+func (f *DatastoreFilter) Check(name string) error {
+	if f == nil {
+		return nil
+	}
+	for _, pattern := range f.Denylist {
+		if matched, _ := path.Match(pattern, name); matched {
+			return fmt.Errorf("%s matches the pattern %q of datastore-denylist", name, pattern)
+		}
+	}
+	if len(f.Allowlist) == 0 {
+		return nil
+	}
+	for _, pattern := range f.Allowlist {
+		if matched, _ := path.Match(pattern, name); matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s matches none of the patterns %q of datastore-allowlist", name, f.Allowlist)
+}
+
+// Allows returns true when volumes may be created on the datastore, or
+// datastore cluster.
+func (f *DatastoreFilter) Allows(name string) bool {
+	return f.Check(name) == nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// dockerBridgeSubnet is the default subnet of the docker bridge, whose
+// addresses are only selected when a subnet CIDR of the nodes includes them.
+var dockerBridgeSubnet = &net.IPNet{
+	IP:   net.IPv4(172, 17, 0, 0).To4(),
+	Mask: net.CIDRMask(16, 32),
+}
+
+// ParseIPFamilies parses a comma-separated list of IP families, optionally
+// enclosed in brackets, in order of priority. An empty list is the default
+// IP family.
+func ParseIPFamilies(families string) ([]string, error) {
+	families = strings.TrimSpace(families)
+	families = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(families, "["), "]"))
+	if families == "" {
+		return []string{DefaultIPFamily}, nil
+	}
+
+	var parsed []string
+	for _, family := range strings.Split(families, ",") {
+		family = strings.ToLower(strings.TrimSpace(family))
+		if family != IPFamilyIPv4 && family != IPFamilyIPv6 {
+			return nil, fmt.Errorf("%q: %v", family, ErrInvalidIPFamily)
+		}
+		for _, f := range parsed {
+			if f == family {
+				return nil, fmt.Errorf("%q listed twice: %v", family, ErrInvalidIPFamily)
+			}
+		}
+		parsed = append(parsed, family)
+	}
+	return parsed, nil
+}
+
+// IPFamilyOf returns the IP family of an IP.
+func IPFamilyOf(ip net.IP) string {
+	if ip.To4() != nil {
+		return IPFamilyIPv4
+	}
+	return IPFamilyIPv6
+}
+
+// SelectNodeIPs returns the IPs that can be reported as the addresses of a
+// node, grouped by IP family in the order of the families and without
+// duplicates. The IPs of the other families are dropped, as are the
+// loopback, link-local and unspecified IPs, and the IPs of the docker
+// bridge that none of the subnets contains. A node whose IPs all belong to
+// the other families has no IPs.
+func SelectNodeIPs(ips []net.IP, families []string, subnets []*net.IPNet) []net.IP {
+	selected := []net.IP{}
+	for _, family := range families {
+		for _, ip := range ips {
+			if ip == nil || IPFamilyOf(ip) != family {
+				continue
+			}
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				continue
+			}
+			if dockerBridgeSubnet.Contains(ip) && !subnetsContain(subnets, ip) {
+				continue
+			}
+			if containsIP(selected, ip) {
+				continue
+			}
+			selected = append(selected, ip)
+		}
+	}
+	return selected
+}
+
+// subnetsContain returns true if any of the subnets contains the IP.
+func subnetsContain(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsIP returns true if the IPs include the IP.
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -16,77 +16,399 @@ limitations under the License.
 
 package config
 
-// Config is used to read and store information from the cloud configuration file
+// Config is used to read and store information from the cloud configuration
+// file, in either the INI or the YAML format. The keys of the YAML format are
+// the ones of the INI format, under the global, virtualCenter and labels
+// sections.
 type Config struct {
 	Global struct {
 		// vCenter username.
-		User string `gcfg:"user"`
+		User string `gcfg:"user" yaml:"user,omitempty"`
 		// vCenter password in clear text.
-		Password string `gcfg:"password"`
+		Password string `gcfg:"password" yaml:"password,omitempty"`
 		// Deprecated. Use VirtualCenter to specify multiple vCenter Servers.
 		// vCenter IP.
-		VCenterIP string `gcfg:"server"`
+		VCenterIP string `gcfg:"server" yaml:"server,omitempty"`
 		// vCenter port.
-		VCenterPort string `gcfg:"port"`
+		VCenterPort string `gcfg:"port" yaml:"port,omitempty"`
 		// True if vCenter uses self-signed cert.
-		InsecureFlag bool `gcfg:"insecure-flag"`
-		// Datacenter in which VMs are located.
-		Datacenters string `gcfg:"datacenters"`
+		InsecureFlag bool `gcfg:"insecure-flag" yaml:"insecure-flag,omitempty"`
+		// Comma-separated list of the datacenters in which VMs and volumes
+		// are located. All of the datacenters of a vCenter are used when
+		// empty. A volume requested without a zone is created in the first
+		// of them that can hold it.
+		Datacenters string `gcfg:"datacenters" yaml:"datacenters,omitempty"`
 		// Soap round tripper count (retries = RoundTripper - 1)
-		RoundTripperCount uint `gcfg:"soap-roundtrip-count"`
+		RoundTripperCount uint `gcfg:"soap-roundtrip-count" yaml:"soap-roundtrip-count,omitempty"`
+		// Number of seconds to wait for a connection to a vCenter.
+		// Default: 30
+		ConnectTimeoutSecs uint `gcfg:"connect-timeout-secs" yaml:"connect-timeout-secs,omitempty"`
+		// Number of seconds a single vSphere API call may take before it
+		// is cancelled.
+		// Default: 120
+		RequestTimeoutSecs uint `gcfg:"request-timeout-secs" yaml:"request-timeout-secs,omitempty"`
+		// Number of seconds a CSI controller operation, including the
+		// vSphere tasks it waits for, may take before it is cancelled.
+		// Must allow for the longest tasks, such as the creation of an
+		// eager-zeroed disk.
+		// Default: 300
+		OperationTimeoutSecs uint `gcfg:"operation-timeout-secs" yaml:"operation-timeout-secs,omitempty"`
+		// Number of vSphere API calls per second that read the inventory,
+		// such as the property collection, made to each vCenter. The calls
+		// past the rate wait for their turn.
+		// Default: 0, unlimited
+		APIReadQPS uint `gcfg:"api-read-qps" yaml:"api-read-qps,omitempty"`
+		// Number of read calls made to each vCenter in a burst above
+		// api-read-qps.
+		// Default: api-read-qps
+		APIReadBurst uint `gcfg:"api-read-burst" yaml:"api-read-burst,omitempty"`
+		// Number of other vSphere API calls per second, such as the
+		// reconfigurations and the tasks, made to each vCenter.
+		// Default: 0, unlimited
+		APIWriteQPS uint `gcfg:"api-write-qps" yaml:"api-write-qps,omitempty"`
+		// Number of write calls made to each vCenter in a burst above
+		// api-write-qps.
+		// Default: api-write-qps
+		APIWriteBurst uint `gcfg:"api-write-burst" yaml:"api-write-burst,omitempty"`
 		// Specifies the path to a CA certificate in PEM format. Optional; if not
 		// configured, the system's CA certificates will be used.
-		CAFile string `gcfg:"ca-file"`
-		// Thumbprint of the VCenter's certificate thumbprint
-		Thumbprint string `gcfg:"thumbprint"`
+		CAFile string `gcfg:"ca-file" yaml:"ca-file,omitempty"`
+		// CA certificates in PEM format, inline. Optional; trusted along with
+		// the ones from ca-file, or with the system's CA certificates when
+		// ca-file is not configured.
+		CAData string `gcfg:"ca-data" yaml:"ca-data,omitempty"`
+		// Thumbprint of the VCenter's certificate thumbprint, SHA-1 or
+		// SHA-256. When set, the certificate is verified against it instead
+		// of the CA certificates.
+		Thumbprint string `gcfg:"thumbprint" yaml:"thumbprint,omitempty"`
+		// URL of the HTTP, HTTPS or SOCKS5 proxy the vCenters are reached
+		// through. Optional; if not configured, the proxy environment
+		// variables are used.
+		ProxyURL string `gcfg:"proxy-url" yaml:"proxy-url,omitempty"`
+		// Comma separated hosts, domains and CIDRs that are reached without
+		// the proxy, in the format of the NO_PROXY environment variable.
+		NoProxy string `gcfg:"no-proxy" yaml:"no-proxy,omitempty"`
 		// Name of the secret were vCenter credentials are present.
-		SecretName string `gcfg:"secret-name"`
+		SecretName string `gcfg:"secret-name" yaml:"secret-name,omitempty"`
 		// Secret Namespace where secret will be present that has vCenter credentials.
-		SecretNamespace string `gcfg:"secret-namespace"`
+		SecretNamespace string `gcfg:"secret-namespace" yaml:"secret-namespace,omitempty"`
 		// The kubernetes service account used to launch the cloud controller manager.
 		// Default: cloud-controller-manager
-		ServiceAccount string `gcfg:"service-account"`
+		ServiceAccount string `gcfg:"service-account" yaml:"service-account,omitempty"`
+		// When true, the CSI controller runs without the Kubernetes client,
+		// such as outside of a cluster. The vCenter credentials must then be
+		// in the config or in environment variables, and the features that
+		// need the Kubernetes API are disabled. The client is also skipped
+		// when neither a kubeconfig nor a service account token is found.
+		// X_CSI_DISABLE_K8S_CLIENT overrides it when set.
+		// Default: false
+		DisableK8sClient bool `gcfg:"disable-k8s-client" yaml:"disable-k8s-client,omitempty"`
 		// Secret directory in the event that:
 		// 1) we don't want to use the k8s API to listen for changes to secrets
 		// 2) we are not in a k8s env, namely DC/OS, since CSI is CO agnostic
 		// Default: /etc/cloud/credentials
-		SecretsDirectory string `gcfg:"secrets-directory"`
+		SecretsDirectory string `gcfg:"secrets-directory" yaml:"secrets-directory,omitempty"`
 		// Disable the vSphere CCM API
 		// Default: true
-		APIDisable bool `gcfg:"api-disable"`
+		APIDisable bool `gcfg:"api-disable" yaml:"api-disable,omitempty"`
 		// Configurable vSphere CCM API port
 		// Default: 43001
-		APIBinding string `gcfg:"api-binding"`
-	}
+		APIBinding string `gcfg:"api-binding" yaml:"api-binding,omitempty"`
+		// ADDRESS:PORT the CSI controller serves its Prometheus metrics on
+		// Default: :43002
+		MetricsBinding string `gcfg:"metrics-binding" yaml:"metrics-binding,omitempty"`
+		// ADDRESS:PORT the CSI controller serves its /healthz liveness and
+		// /readyz readiness endpoints on
+		// Default: :43003
+		HealthBinding string `gcfg:"health-binding" yaml:"health-binding,omitempty"`
+		// When true, the CSI controller serves on debug-binding the
+		// /debug/state endpoint, which dumps in JSON its effective config,
+		// with the secrets redacted, its vCenters and their API versions,
+		// the zones of their hosts and the size of its FCD index.
+		// Default: false
+		DebugEnabled bool `gcfg:"debug-enabled" yaml:"debug-enabled,omitempty"`
+		// ADDRESS:PORT the CSI controller serves its debug endpoint on
+		// Default: 127.0.0.1:43004
+		DebugBinding string `gcfg:"debug-binding" yaml:"debug-binding,omitempty"`
+		// Number of seconds between refreshes of the FCD inventory cache
+		// used by the CSI controller.
+		// Default: 300
+		FCDCacheRefreshSecs uint `gcfg:"fcd-cache-refresh-secs" yaml:"fcd-cache-refresh-secs,omitempty"`
+		// Minimum free space, in MB, a datastore must have to be picked
+		// for a volume whose StorageClass does not name a datastore.
+		// Default: 0
+		DatastoreMinFreeSpaceMB uint `gcfg:"datastore-min-free-space-mb" yaml:"datastore-min-free-space-mb,omitempty"`
+		// Type of the SCSI controllers volumes are attached to: pvscsi,
+		// lsiLogic or lsiLogic-sas.
+		// Default: pvscsi
+		SCSIControllerType string `gcfg:"scsi-controller-type" yaml:"scsi-controller-type,omitempty"`
+		// Size, in GiB, of the volumes whose request and StorageClass do not
+		// set one.
+		// Default: 10
+		DefaultVolumeSizeGB uint `gcfg:"default-volume-size-gb" yaml:"default-volume-size-gb,omitempty"`
+		// Minimum size, in GiB, of the volumes the CSI controller creates.
+		// No minimum when zero.
+		// Default: 0
+		MinVolumeSizeGB uint `gcfg:"min-volume-size-gb" yaml:"min-volume-size-gb,omitempty"`
+		// Maximum size, in GiB, of the volumes the CSI controller creates or
+		// expands. No maximum when zero.
+		// Default: 0
+		MaxVolumeSizeGB uint `gcfg:"max-volume-size-gb" yaml:"max-volume-size-gb,omitempty"`
+		// Maximum number of volumes that may be attached to a node. When
+		// zero, the limit is computed from the SCSI slots of the node's VM.
+		// Default: 0
+		MaxVolumesPerNode uint `gcfg:"max-volumes-per-node" yaml:"max-volumes-per-node,omitempty"`
+		// When true, the CSI controller deletes the volumes whose disk is
+		// still attached to a VM, once it has detached the disk from the
+		// VM, instead of failing until the disk is detached. For emergency
+		// cleanups only, as the data of the VM's workload is lost.
+		// Default: false
+		ForceDelete bool `gcfg:"force-delete" yaml:"force-delete,omitempty"`
+		// Name of the ConfigMap of the capacity limits of the namespaces,
+		// keyed by namespace, as quantities such as "500Gi". The "*" key
+		// is the limit of the other namespaces. The volumes of a namespace
+		// may not be created past its limit. No limit when empty. Requires
+		// the Kubernetes client. Optional.
+		NamespaceQuotaConfigMap string `gcfg:"namespace-quota-configmap" yaml:"namespace-quota-configmap,omitempty"`
+		// Namespace of the ConfigMap of the capacity limits.
+		// Default: kube-system
+		NamespaceQuotaConfigMapNamespace string `gcfg:"namespace-quota-configmap-namespace" yaml:"namespace-quota-configmap-namespace,omitempty"`
+		// Comma-separated glob patterns, as in "k8s-*", of the datastores
+		// and datastore clusters the CSI controller creates volumes on.
+		// The volumes may be created on any of them when empty. Optional.
+		DatastoreAllowlist string `gcfg:"datastore-allowlist" yaml:"datastore-allowlist,omitempty"`
+		// Comma-separated glob patterns of the datastores and datastore
+		// clusters the CSI controller never creates volumes on, even when
+		// a StorageClass names them. Optional.
+		DatastoreDenylist string `gcfg:"datastore-denylist" yaml:"datastore-denylist,omitempty"`
+		// Zone of the CSI controller. When set, ListVolumes only returns
+		// the FCDs of the datacenter of this zone and region, which avoids
+		// scanning every vCenter. Optional.
+		ListVolumesZone string `gcfg:"list-volumes-zone" yaml:"list-volumes-zone,omitempty"`
+		// Region of the CSI controller, used along with list-volumes-zone.
+		ListVolumesRegion string `gcfg:"list-volumes-region" yaml:"list-volumes-region,omitempty"`
+		// Comma-separated glob patterns of the datastores and datastore
+		// clusters whose FCDs ListVolumes returns. The FCDs of all of them
+		// are returned when empty. Optional.
+		ListVolumesDatastores string `gcfg:"list-volumes-datastores" yaml:"list-volumes-datastores,omitempty"`
+		// Number of datastores whose FCDs are listed at a time when the
+		// CSI controller scans the FCDs.
+		// Default: 8
+		ListVolumesConcurrency uint `gcfg:"list-volumes-concurrency" yaml:"list-volumes-concurrency,omitempty"`
+		// ID of the Kubernetes cluster. When set, the CSI controller tags
+		// the FCDs it creates as owned by the cluster. Optional.
+		ClusterID string `gcfg:"cluster-id" yaml:"cluster-id,omitempty"`
+		// Number of seconds between the scans for the orphaned FCDs: the
+		// FCDs owned by the cluster that no PV refers to. Requires
+		// cluster-id. The scans are disabled when zero.
+		// Default: 0
+		OrphanedVolumeGCIntervalSecs uint `gcfg:"orphaned-volume-gc-interval-secs" yaml:"orphaned-volume-gc-interval-secs,omitempty"`
+		// Minimum age, in seconds, of an orphaned FCD before it is deleted,
+		// so that the volumes still being provisioned are left alone.
+		// Default: 3600
+		OrphanedVolumeGCMinAgeSecs uint `gcfg:"orphaned-volume-gc-min-age-secs" yaml:"orphaned-volume-gc-min-age-secs,omitempty"`
+		// When true, the orphaned FCDs are only logged and counted in the
+		// csi_orphaned_volumes metric, never deleted.
+		// Default: false
+		OrphanedVolumeGCDryRun bool `gcfg:"orphaned-volume-gc-dry-run" yaml:"orphaned-volume-gc-dry-run,omitempty"`
+		// Number of seconds between the checks of the volume attachments
+		// against the disks attached to the node VMs. A disk detached out of
+		// band, such as in the vSphere Client, is attached again. The checks
+		// are disabled when zero.
+		// Default: 0
+		AttachmentReconcileIntervalSecs uint `gcfg:"attachment-reconcile-interval-secs" yaml:"attachment-reconcile-interval-secs,omitempty"`
+		// When true, the disks detached out of band are only logged,
+		// reported in events on their volume attachments and counted in the
+		// csi_detached_volumes metric, never attached again.
+		// Default: false
+		AttachmentReconcileDryRun bool `gcfg:"attachment-reconcile-dry-run" yaml:"attachment-reconcile-dry-run,omitempty"`
+		// Number of seconds between the pushes of the PV, PVC and pod
+		// metadata of the volumes to the Cloud Native Storage (CNS) of the
+		// vCenters running vSphere 6.7U3 or later, which shows them in the
+		// vSphere Client. Requires cluster-id. The metadata is not pushed
+		// when zero.
+		// Default: 0
+		CnsMetadataSyncIntervalSecs uint `gcfg:"cns-metadata-sync-interval-secs" yaml:"cns-metadata-sync-interval-secs,omitempty"`
+		// When true, the CSI controller fails to start when the vCenter
+		// users lack any of the vSphere privileges it needs on the vCenters,
+		// their datacenters or their datastores.
+		// Default: false
+		CheckPermissions bool `gcfg:"check-permissions" yaml:"check-permissions,omitempty"`
+		// Number of seconds the CSI controller waits for the operations in
+		// flight on the volumes when shutting down, after which their
+		// contexts are cancelled.
+		// Default: 30
+		ShutdownDrainTimeoutSecs uint `gcfg:"shutdown-drain-timeout-secs" yaml:"shutdown-drain-timeout-secs,omitempty"`
+		// When true, the replicas of the CSI controller elect a leader with
+		// a lease held in a ConfigMap, and only the leader refreshes the
+		// FCD cache and index, scans for the orphaned FCDs and pushes the
+		// CNS metadata. Requires the Kubernetes client.
+		// Default: false
+		LeaderElect bool `gcfg:"leader-elect" yaml:"leader-elect,omitempty"`
+		// Namespace of the ConfigMap holding the lease of the leader.
+		// Default: kube-system
+		LeaderElectNamespace string `gcfg:"leader-elect-namespace" yaml:"leader-elect-namespace,omitempty"`
+		// Number of seconds the other replicas wait after the last renewal
+		// of the lease before taking over from the leader.
+		// Default: 15
+		LeaderElectLeaseDurationSecs uint `gcfg:"leader-elect-lease-duration-secs" yaml:"leader-elect-lease-duration-secs,omitempty"`
+		// Number of seconds the leader keeps trying to renew its lease
+		// before it stops leading. Must be shorter than the lease duration.
+		// Default: 10
+		LeaderElectRenewDeadlineSecs uint `gcfg:"leader-elect-renew-deadline-secs" yaml:"leader-elect-renew-deadline-secs,omitempty"`
+		// When true, the SOAP requests and responses exchanged with the
+		// vCenters are written to vc-soap-debug-dir, in a directory named
+		// after each vCenter, with the login requests and the session
+		// cookies redacted. Overridden by the soap-debug of each vCenter.
+		// Default: false
+		VCSoapDebug bool `gcfg:"vc-soap-debug" yaml:"vc-soap-debug,omitempty"`
+		// Directory the SOAP requests and responses are written to.
+		VCSoapDebugDir string `gcfg:"vc-soap-debug-dir" yaml:"vc-soap-debug-dir,omitempty"`
+		// Number of files kept in the directory of each vCenter, past which
+		// the oldest ones are deleted.
+		// Default: 1000
+		VCSoapDebugMaxFiles uint `gcfg:"vc-soap-debug-max-files" yaml:"vc-soap-debug-max-files,omitempty"`
+		// When true, the vCenter API versions do not gate the features of
+		// the CSI controller, and every vCenter is assumed to support all
+		// of them, such as the pre-release builds reporting an older API
+		// version than their features.
+		// Default: false
+		SkipAPIVersionGates bool `gcfg:"skip-api-version-gates" yaml:"skip-api-version-gates,omitempty"`
+	} `yaml:"global"`
 
 	// Virtual Center configurations
-	VirtualCenter map[string]*VirtualCenterConfig
+	VirtualCenter map[string]*VirtualCenterConfig `yaml:"virtualCenter"`
 
 	// Tag categories and tags which correspond to "built-in node labels: zones and region"
 	Labels struct {
-		Zone   string `gcfg:"zone"`
-		Region string `gcfg:"region"`
-	}
+		Zone   string `gcfg:"zone" yaml:"zone,omitempty"`
+		Region string `gcfg:"region" yaml:"region,omitempty"`
+		// Family of the Kubernetes labels the zone and region are reported
+		// under in the topology of the volumes and nodes: beta for the
+		// failure-domain.beta.kubernetes.io labels, ga for the
+		// topology.kubernetes.io labels of Kubernetes 1.17+ or both for
+		// both of them, while the nodes are relabeled. The zone and region
+		// are read from either family regardless.
+		// Default: beta
+		TopologyLabels string `gcfg:"topology-labels" yaml:"topology-labels,omitempty"`
+	} `yaml:"labels,omitempty"`
+
+	// Networks of the node VMs whose addresses are reported
+	Nodes struct {
+		// Name of the management network of the node VMs. Only the guest
+		// addresses of their NICs on this network are reported as the
+		// addresses of the nodes. All of the addresses are reported when
+		// empty.
+		InternalNetworkName string `gcfg:"internal-network-name" yaml:"internal-network-name,omitempty"`
+		// Name of the network whose guest addresses are reported as the
+		// external addresses of the nodes. The internal addresses are also
+		// reported as the external ones when neither it nor
+		// external-network-subnet-cidr is set.
+		ExternalNetworkName string `gcfg:"external-network-name" yaml:"external-network-name,omitempty"`
+		// Comma-separated list of the CIDRs of the guest addresses reported
+		// as the internal addresses of the nodes, along with the ones of
+		// internal-network-name.
+		InternalNetworkSubnetCIDR string `gcfg:"internal-network-subnet-cidr" yaml:"internal-network-subnet-cidr,omitempty"`
+		// Comma-separated list of the CIDRs of the guest addresses reported
+		// as the external addresses of the nodes, along with the ones of
+		// external-network-name.
+		ExternalNetworkSubnetCIDR string `gcfg:"external-network-subnet-cidr" yaml:"external-network-subnet-cidr,omitempty"`
+		// Comma-separated list of the IP families of the guest addresses
+		// reported as the addresses of the nodes, in order of priority:
+		// ipv4, ipv6, ipv4,ipv6 or ipv6,ipv4. The list may be enclosed in
+		// brackets, in which case it must be quoted in YAML.
+		// Default: ipv4
+		IPFamily string `gcfg:"ip-family" yaml:"ip-family,omitempty"`
+	} `yaml:"nodes,omitempty"`
 }
 
 // VirtualCenterConfig contains information used to access a remote vCenter
 // endpoint.
 type VirtualCenterConfig struct {
 	// vCenter username.
-	User string `gcfg:"user"`
+	User string `gcfg:"user" yaml:"user,omitempty"`
 	// vCenter password in clear text.
-	Password string `gcfg:"password"`
+	Password string `gcfg:"password" yaml:"password,omitempty"`
 	// vCenter port.
-	VCenterPort string `gcfg:"port"`
+	VCenterPort string `gcfg:"port" yaml:"port,omitempty"`
 	// True if vCenter uses self-signed cert.
-	InsecureFlag bool `gcfg:"insecure-flag"`
-	// Datacenter in which VMs are located.
-	Datacenters string `gcfg:"datacenters"`
+	InsecureFlag bool `gcfg:"insecure-flag" yaml:"insecure-flag,omitempty"`
+	// Comma-separated list of the datacenters of this vCenter in which VMs
+	// and volumes are located.
+	// Default: the global datacenters
+	Datacenters string `gcfg:"datacenters" yaml:"datacenters,omitempty"`
 	// Soap round tripper count (retries = RoundTripper - 1)
-	RoundTripperCount uint `gcfg:"soap-roundtrip-count"`
+	RoundTripperCount uint `gcfg:"soap-roundtrip-count" yaml:"soap-roundtrip-count,omitempty"`
+	// Number of seconds to wait for a connection to this vCenter.
+	// Default: the global connect-timeout-secs
+	ConnectTimeoutSecs uint `gcfg:"connect-timeout-secs" yaml:"connect-timeout-secs,omitempty"`
+	// Number of seconds a single vSphere API call to this vCenter may take
+	// before it is cancelled.
+	// Default: the global request-timeout-secs
+	RequestTimeoutSecs uint `gcfg:"request-timeout-secs" yaml:"request-timeout-secs,omitempty"`
+	// Number of read calls per second made to this vCenter.
+	// Default: the global api-read-qps
+	APIReadQPS uint `gcfg:"api-read-qps" yaml:"api-read-qps,omitempty"`
+	// Number of read calls made to this vCenter in a burst.
+	// Default: the global api-read-burst
+	APIReadBurst uint `gcfg:"api-read-burst" yaml:"api-read-burst,omitempty"`
+	// Number of write calls per second made to this vCenter.
+	// Default: the global api-write-qps
+	APIWriteQPS uint `gcfg:"api-write-qps" yaml:"api-write-qps,omitempty"`
+	// Number of write calls made to this vCenter in a burst.
+	// Default: the global api-write-burst
+	APIWriteBurst uint `gcfg:"api-write-burst" yaml:"api-write-burst,omitempty"`
 	// Specifies the path to a CA certificate in PEM format. Optional; if not
 	// configured, the system's CA certificates will be used.
-	CAFile string `gcfg:"ca-file"`
-	// Thumbprint of the VCenter's certificate thumbprint
-	Thumbprint string `gcfg:"thumbprint"`
+	CAFile string `gcfg:"ca-file" yaml:"ca-file,omitempty"`
+	// CA certificates in PEM format, inline. Optional; trusted along with
+	// the ones from ca-file, or with the system's CA certificates when
+	// ca-file is not configured.
+	CAData string `gcfg:"ca-data" yaml:"ca-data,omitempty"`
+	// Thumbprint of the VCenter's certificate thumbprint, SHA-1 or SHA-256.
+	// When set, the certificate is verified against it instead of the CA
+	// certificates.
+	Thumbprint string `gcfg:"thumbprint" yaml:"thumbprint,omitempty"`
+	// URL of the HTTP, HTTPS or SOCKS5 proxy this vCenter is reached
+	// through.
+	// Default: the global proxy-url
+	ProxyURL string `gcfg:"proxy-url" yaml:"proxy-url,omitempty"`
+	// Comma separated hosts, domains and CIDRs that are reached without the
+	// proxy.
+	// Default: the global no-proxy
+	NoProxy string `gcfg:"no-proxy" yaml:"no-proxy,omitempty"`
+	// Name of the secret where the credentials of this vCenter are present.
+	// Overrides the global secret for this vCenter.
+	SecretName string `gcfg:"secret-name" yaml:"secret-name,omitempty"`
+	// Namespace of the secret where the credentials of this vCenter are
+	// present.
+	// Default: the global secret-namespace
+	SecretNamespace string `gcfg:"secret-namespace" yaml:"secret-namespace,omitempty"`
+	// When set, enables or disables writing the SOAP requests and responses
+	// exchanged with this vCenter to the global vc-soap-debug-dir.
+	// Default: the global vc-soap-debug
+	SoapDebug *bool `gcfg:"soap-debug" yaml:"soap-debug,omitempty"`
+	// FQDN or IP of the endpoint that serves the storage services of this
+	// vCenter, vslm, PBM and CNS, when it is not the vCenter itself, as on
+	// VMware Cloud on AWS. The storage services share the session of the
+	// vCenter.
+	// Default: the vCenter
+	StorageEndpoint string `gcfg:"storage-endpoint" yaml:"storage-endpoint,omitempty"`
+	// Port of the storage endpoint.
+	// Default: the port of the vCenter
+	StorageEndpointPort string `gcfg:"storage-endpoint-port" yaml:"storage-endpoint-port,omitempty"`
+	// True if the storage endpoint uses a self-signed cert.
+	// Default: the insecure-flag of the vCenter
+	StorageEndpointInsecureFlag *bool `gcfg:"storage-endpoint-insecure-flag" yaml:"storage-endpoint-insecure-flag,omitempty"`
+	// Path to a CA certificate of the storage endpoint in PEM format.
+	// Default: the ca-file of the vCenter
+	StorageEndpointCAFile string `gcfg:"storage-endpoint-ca-file" yaml:"storage-endpoint-ca-file,omitempty"`
+	// CA certificates of the storage endpoint in PEM format, inline.
+	// Default: the ca-data of the vCenter
+	StorageEndpointCAData string `gcfg:"storage-endpoint-ca-data" yaml:"storage-endpoint-ca-data,omitempty"`
+	// Thumbprint of the certificate of the storage endpoint, SHA-1 or
+	// SHA-256.
+	// Default: the thumbprint of the vCenter, unless the storage endpoint
+	// is insecure
+	StorageEndpointThumbprint string `gcfg:"storage-endpoint-thumbprint" yaml:"storage-endpoint-thumbprint,omitempty"`
 }
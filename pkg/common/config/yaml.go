@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatINI is the gcfg INI format of the cloud config.
+	FormatINI string = "ini"
+
+	// FormatYAML is the YAML format of the cloud config.
+	FormatYAML string = "yaml"
+)
+
+// FormatFromPath returns the format of a cloud config file by its
+// extension: FormatYAML for .yaml and .yml files, FormatINI otherwise.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return FormatINI
+}
+
+// ReadConfigFormat parses a vSphere cloud config in the format, FormatINI or
+// FormatYAML. When the format is empty and the config is a file, the format
+// is detected by the extension of the file, otherwise INI is assumed.
+func ReadConfigFormat(config io.Reader, format string) (*Config, error) {
+	if format == "" {
+		format = FormatINI
+		if f, ok := config.(*os.File); ok {
+			format = FormatFromPath(f.Name())
+		}
+	}
+
+	switch strings.ToLower(format) {
+	case FormatINI:
+		return ReadConfig(config)
+	case FormatYAML:
+		return ReadConfigYAML(config)
+	}
+	return nil, ErrUnsupportedConfigFormat
+}
+
+// ReadConfigYAML parses a vSphere cloud config in the YAML format. Unknown
+// keys are rejected, so that a typo does not silently leave a property
+// empty. Environment variables override the config file entries, then the
+// config is validated and all of its problems are returned at once.
+func ReadConfigYAML(config io.Reader) (*Config, error) {
+	if config == nil {
+		return nil, fmt.Errorf("no vSphere cloud provider config file given")
+	}
+
+	data, err := ioutil.ReadAll(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, err
+	}
+
+	// A vCenter without any property is decoded as nil
+	for vcServer, vcConfig := range cfg.VirtualCenter {
+		if vcConfig == nil {
+			cfg.VirtualCenter[vcServer] = &VirtualCenterConfig{}
+		}
+	}
+
+	// Env Vars should override config file entries if present
+	if err := validateFromEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/klog"
+
+	vclib "k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// capabilityMatrix records the capabilities of each VC server, computed
+// from its API version. The zero value is ready to use.
+type capabilityMatrix struct {
+	sync.RWMutex
+
+	byVC map[string]vclib.Capabilities
+}
+
+// get returns the capabilities of the VC server, and false for known when
+// they were not recorded yet.
+func (m *capabilityMatrix) get(vc string) (capabilities vclib.Capabilities, known bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	capabilities, known = m.byVC[vc]
+	return capabilities, known
+}
+
+func (m *capabilityMatrix) set(vc string, capabilities vclib.Capabilities) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.byVC == nil {
+		m.byVC = make(map[string]vclib.Capabilities)
+	}
+	m.byVC[vc] = capabilities
+}
+
+// RecordCapabilities records the capabilities of a vCenter of an API
+// version, and returns them. Every vCenter has all of the capabilities when
+// the API version gates are skipped.
+func (cm *ConnectionManager) RecordCapabilities(vc string, apiVersion string) vclib.Capabilities {
+	capabilities := vclib.CapabilitiesOf(apiVersion)
+	if cm.skipAPIVersionGates {
+		capabilities = vclib.AllCapabilities()
+	}
+	klog.V(2).Infof("vc=%s with API version %s has capabilities %s", vc, apiVersion, capabilities)
+	cm.capabilities.set(vc, capabilities)
+	return capabilities
+}
+
+// DetectCapabilities connects to a vCenter and records the capabilities of
+// its API version, which it returns.
+func (cm *ConnectionManager) DetectCapabilities(ctx context.Context, vc string) (string, error) {
+	apiVersion, err := cm.APIVersionWithContext(ctx, vc)
+	if err != nil {
+		return "", err
+	}
+	cm.RecordCapabilities(vc, apiVersion)
+	return apiVersion, nil
+}
+
+// Capabilities returns the recorded capabilities of a vCenter, which has
+// none until they are detected.
+func (cm *ConnectionManager) Capabilities(vc string) vclib.Capabilities {
+	capabilities, _ := cm.capabilities.get(vc)
+	return capabilities
+}
+
+// HasCapability returns true when a vCenter has a capability. The
+// capabilities are detected on first use when they were not detected
+// before, and a vCenter that cannot be reached has none.
+func (cm *ConnectionManager) HasCapability(ctx context.Context, vc string, capability vclib.Capability) bool {
+	capabilities, known := cm.capabilities.get(vc)
+	if !known {
+		if _, err := cm.DetectCapabilities(ctx, vc); err != nil {
+			klog.Warningf("Failed to detect the capabilities of vc=%s. Err: %v", vc, err)
+			return false
+		}
+		capabilities, _ = cm.capabilities.get(vc)
+	}
+	return capabilities.Has(capability)
+}
+
+// SkipsAPIVersionGates returns true when every vCenter is assumed to have
+// all of the capabilities, whatever its API version.
+func (cm *ConnectionManager) SkipsAPIVersionGates() bool {
+	return cm.skipAPIVersionGates
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestCapabilities(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	vc := config.Global.VCenterIP
+	if connMgr.Capabilities(vc).Has(vclib.CapabilityFCD) {
+		t.Fatalf("vc=%s should have no capability until it is detected", vc)
+	}
+
+	// the capabilities of the simulator, reporting 6.5, are detected on
+	// first use
+	if !connMgr.HasCapability(ctx, vc, vclib.CapabilityFCD) {
+		t.Errorf("vc=%s should manage FCDs", vc)
+	}
+	if connMgr.HasCapability(ctx, vc, vclib.CapabilityFCDSnapshots) {
+		t.Errorf("vc=%s should not support FCD snapshots", vc)
+	}
+	if !connMgr.Capabilities(vc).Has(vclib.CapabilityFCD) {
+		t.Errorf("The capabilities of vc=%s should be recorded", vc)
+	}
+
+	if !connMgr.RecordCapabilities(vc, "6.7.3").Has(vclib.CapabilityFCDSnapshots) ||
+		!connMgr.HasCapability(ctx, vc, vclib.CapabilityFCDSnapshots) {
+		t.Errorf("vc=%s should support FCD snapshots once 6.7.3 is recorded", vc)
+	}
+
+	// an unreachable vCenter has no capability
+	if connMgr.HasCapability(ctx, "enoent", vclib.CapabilityFCD) {
+		t.Error("An unknown vCenter should have no capability")
+	}
+
+	// the gates are skipped for the pre-release builds
+	config.Global.SkipAPIVersionGates = true
+	connMgr = NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+	if _, err := connMgr.DetectCapabilities(ctx, vc); err != nil {
+		t.Fatalf("DetectCapabilities err=%v", err)
+	}
+	if !connMgr.Capabilities(vc).Has(vclib.CapabilityOnlineExtend) {
+		t.Errorf("vc=%s should have all of the capabilities when the gates are skipped", vc)
+	}
+}
@@ -18,7 +18,7 @@ package connectionmanager
 
 import (
 	"context"
-	"sync"
+	"time"
 
 	"k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog"
@@ -39,6 +39,9 @@ const (
 	// FindVMByName finds VMs with the provided name.
 	FindVMByName // 1
 
+	// FindVMByIP finds VMs with the provided guest IP address.
+	FindVMByIP // 2
+
 	// PoolSize is the number of goroutines used in parallel to find a VM.
 	PoolSize int = 8
 
@@ -64,6 +67,8 @@ func NewConnectionManager(config *vcfg.Config, secretLister v1.SecretLister) *Co
 				VirtualCenter: make(map[string]*cm.Credential),
 			},
 		},
+		credentialManagers:  make(map[string]*cm.SecretCredentialManager),
+		skipAPIVersionGates: config.Global.SkipAPIVersionGates,
 	}
 
 	if secretLister != nil {
@@ -71,6 +76,21 @@ func NewConnectionManager(config *vcfg.Config, secretLister v1.SecretLister) *Co
 		connM.credentialManager.SecretName = config.Global.SecretName
 		connM.credentialManager.SecretNamespace = config.Global.SecretNamespace
 		connM.credentialManager.SecretLister = secretLister
+
+		for vcServer, vcConfig := range config.VirtualCenter {
+			if vcConfig.SecretName == "" {
+				continue
+			}
+			klog.V(2).Infof("vc=%s uses secret %s/%s", vcServer, vcConfig.SecretNamespace, vcConfig.SecretName)
+			connM.credentialManagers[vcServer] = &cm.SecretCredentialManager{
+				SecretName:      vcConfig.SecretName,
+				SecretNamespace: vcConfig.SecretNamespace,
+				SecretLister:    secretLister,
+				Cache: &cm.SecretCache{
+					VirtualCenter: make(map[string]*cm.Credential),
+				},
+			}
+		}
 		return connM
 	}
 
@@ -97,9 +117,35 @@ func generateInstanceMap(cfg *vcfg.Config) map[string]*VSphereInstance {
 			Hostname:          vcServer,
 			Insecure:          vcConfig.InsecureFlag,
 			RoundTripperCount: vcConfig.RoundTripperCount,
+			ConnectTimeout:    time.Duration(vcConfig.ConnectTimeoutSecs) * time.Second,
+			RequestTimeout:    time.Duration(vcConfig.RequestTimeoutSecs) * time.Second,
 			Port:              vcConfig.VCenterPort,
 			CACert:            vcConfig.CAFile,
+			CAData:            vcConfig.CAData,
+			ProxyURL:          vcConfig.ProxyURL,
+			NoProxy:           vcConfig.NoProxy,
 			Thumbprint:        vcConfig.Thumbprint,
+			SoapDebugDir:      cfg.SoapDebugDir(vcServer),
+			RateLimiter: vclib.RateLimiterFor(vcServer, vclib.RateLimits{
+				ReadQPS:    vcConfig.APIReadQPS,
+				ReadBurst:  vcConfig.APIReadBurst,
+				WriteQPS:   vcConfig.APIWriteQPS,
+				WriteBurst: vcConfig.APIWriteBurst,
+			}),
+		}
+		if vcConfig.StorageEndpoint != "" {
+			vSphereConn.StorageEndpoint = &vclib.StorageEndpoint{
+				Hostname:   vcConfig.StorageEndpoint,
+				Port:       vcConfig.StorageEndpointPort,
+				CACert:     vcConfig.StorageEndpointCAFile,
+				CAData:     vcConfig.StorageEndpointCAData,
+				Thumbprint: vcConfig.StorageEndpointThumbprint,
+				Insecure:   vcConfig.StorageEndpointInsecureFlag != nil && *vcConfig.StorageEndpointInsecureFlag,
+			}
+		}
+		if vSphereConn.SoapDebugDir != "" {
+			klog.Warningf("Writing the SOAP round trips with vc=%s to %s", vcServer, vSphereConn.SoapDebugDir)
+			vclib.EnableSoapDebug(int(cfg.Global.VCSoapDebugMaxFiles))
 		}
 		vsphereIns := VSphereInstance{
 			Conn: &vSphereConn,
@@ -111,44 +157,84 @@ func generateInstanceMap(cfg *vcfg.Config) map[string]*VSphereInstance {
 	return vsphereInstanceMap
 }
 
-var (
-	clientLock sync.Mutex
-)
-
-// Connect establishes a connection to the supplied vCenter.
+// Connect establishes a connection to the supplied vCenter. Only one
+// connection attempt is made at a time: the callers arriving while one is in
+// flight wait for it and share its result, so that a burst of calls logs in
+// once. A caller whose context is still live connects again when the
+// attempt it waited for was cancelled by the context of its caller.
 func (cm *ConnectionManager) Connect(ctx context.Context, vcenter string) error {
-	clientLock.Lock()
-	defer clientLock.Unlock()
-
 	vc := cm.VsphereInstanceMap[vcenter]
 	if vc == nil {
 		return ErrConnectionNotFound
 	}
 
-	return cm.ConnectByInstance(ctx, vc)
+	for {
+		vc.connectLock.Lock()
+		call := vc.connecting
+		if call == nil {
+			break
+		}
+		vc.connectLock.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if call.err != context.Canceled && call.err != context.DeadlineExceeded {
+			return call.err
+		}
+	}
+	call := &connectCall{done: make(chan struct{})}
+	vc.connecting = call
+	vc.connectLock.Unlock()
+
+	vc.clientLock.Lock()
+	call.err = cm.ConnectByInstance(ctx, vc)
+	vc.clientLock.Unlock()
+
+	vc.connectLock.Lock()
+	vc.connecting = nil
+	vc.connectLock.Unlock()
+	close(call.done)
+
+	return call.err
 }
 
-// ConnectByInstance connects to vCenter with existing credentials
+// ConnectByInstance connects to vCenter with existing credentials, or with
+// the credentials from the vCenter's own secret when it has one.
 // If credentials are invalid:
 // 		1. It will fetch credentials from credentialManager
 //      2. Update the credentials
 //		3. Connects again to vCenter with fetched credentials
 func (cm *ConnectionManager) ConnectByInstance(ctx context.Context, vsphereInstance *VSphereInstance) error {
+	hostname := vsphereInstance.Conn.Hostname
+	if credentialManager, ok := cm.credentialManagers[hostname]; ok {
+		credentials, err := credentialManager.GetCredential(hostname)
+		if err != nil {
+			klog.Errorf("Failed to get credentials of vc=%s from secret %s/%s with err: %v",
+				hostname, credentialManager.SecretNamespace, credentialManager.SecretName, err)
+			return err
+		}
+		vsphereInstance.Conn.RotateCredentials(credentials.User, credentials.Password)
+	}
+
 	err := vsphereInstance.Conn.Connect(ctx)
 	if err == nil {
 		return nil
 	}
 
-	if !vclib.IsInvalidCredentialsError(err) || cm.credentialManager == nil {
+	credentialManager := cm.credentialManagerFor(hostname)
+	if !vclib.IsInvalidCredentialsError(err) || credentialManager == nil {
 		klog.Errorf("Cannot connect to vCenter with err: %v", err)
 		return err
 	}
 
 	klog.V(2).Infof("Invalid credentials. Cannot connect to server %q. "+
-		"Fetching credentials from secrets.", vsphereInstance.Conn.Hostname)
+		"Fetching credentials from secrets.", hostname)
 
 	// Get latest credentials from SecretCredentialManager
-	credentials, err := cm.credentialManager.GetCredential(vsphereInstance.Conn.Hostname)
+	credentials, err := credentialManager.GetCredential(hostname)
 	if err != nil {
 		klog.Error("Failed to get credentials from Secret Credential Manager with err:", err)
 		return err
@@ -157,18 +243,44 @@ func (cm *ConnectionManager) ConnectByInstance(ctx context.Context, vsphereInsta
 	return vsphereInstance.Conn.Connect(ctx)
 }
 
+// VCHealth returns nil when a session is established with the vCenter and
+// passed its last check, which the keep-alive of the session repeats while
+// it is idle.
+func (cm *ConnectionManager) VCHealth(vcenter string) error {
+	vc := cm.VsphereInstanceMap[vcenter]
+	if vc == nil {
+		return ErrConnectionNotFound
+	}
+	return vc.Conn.Health()
+}
+
+// VsphereInstances returns the vSphere instances of the configured vCenters,
+// keyed by VC server.
+func (cm *ConnectionManager) VsphereInstances() map[string]*VSphereInstance {
+	return cm.VsphereInstanceMap
+}
+
 // Logout closes existing connections to remote vCenter endpoints.
 func (cm *ConnectionManager) Logout() {
 	for _, vsphereIns := range cm.VsphereInstanceMap {
-		clientLock.Lock()
+		vsphereIns.clientLock.Lock()
 		c := vsphereIns.Conn.Client
-		clientLock.Unlock()
+		vsphereIns.clientLock.Unlock()
 		if c != nil {
 			vsphereIns.Conn.Logout(context.TODO())
 		}
 	}
 }
 
+// Close logs out of the vCenters for good, which also stops the keep-alive
+// of their sessions. It is called when the connection manager is no longer
+// used, as the sessions would otherwise leak until they time out.
+func (cm *ConnectionManager) Close() {
+	for _, vsphereIns := range cm.VsphereInstanceMap {
+		vsphereIns.Conn.Close(context.TODO())
+	}
+}
+
 // Verify validates the configuration by attempting to connect to the
 // configured, remote vCenter endpoints.
 func (cm *ConnectionManager) Verify() error {
@@ -201,7 +313,13 @@ func (cm *ConnectionManager) VerifyWithContext(ctx context.Context) error {
 
 // APIVersion returns the version of the vCenter API
 func (cm *ConnectionManager) APIVersion(vcenter string) (string, error) {
-	if err := cm.Connect(context.Background(), vcenter); err != nil {
+	return cm.APIVersionWithContext(context.Background(), vcenter)
+}
+
+// APIVersionWithContext is the same as APIVersion but allows a Go Context
+// to control the lifecycle of the connection event.
+func (cm *ConnectionManager) APIVersionWithContext(ctx context.Context, vcenter string) (string, error) {
+	if err := cm.Connect(ctx, vcenter); err != nil {
 		return "", err
 	}
 
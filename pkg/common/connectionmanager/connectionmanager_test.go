@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+func TestConcurrentConnect(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	ctx := context.Background()
+	vcenter := config.Global.VCenterIP
+
+	// the concurrent callers share a single login
+	const callers = 100
+	errs := make(chan error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- connMgr.Connect(ctx, vcenter)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Connect err=%v", err)
+		}
+	}
+
+	client := connMgr.VsphereInstanceMap[vcenter].Conn.Client
+	var sm mo.SessionManager
+	pc := property.DefaultCollector(client)
+	if err := pc.RetrieveOne(ctx, *client.ServiceContent.SessionManager, []string{"sessionList"}, &sm); err != nil {
+		t.Fatalf("RetrieveOne err=%v", err)
+	}
+	if len(sm.SessionList) != 1 {
+		t.Errorf("vcsim should have 1 session but count=%d", len(sm.SessionList))
+	}
+
+	// a caller whose context is done does not wait for the connection
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	vc := connMgr.VsphereInstanceMap[vcenter]
+	vc.connectLock.Lock()
+	vc.connecting = &connectCall{done: make(chan struct{})}
+	vc.connectLock.Unlock()
+	if err := connMgr.Connect(cancelled, vcenter); err != context.Canceled {
+		t.Errorf("Connect should fail with %v: %v", context.Canceled, err)
+	}
+}
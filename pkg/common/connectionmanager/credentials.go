@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/credentialmanager"
+)
+
+// SecretAdded reloads the vCenter credentials when the credentials secret
+// is created.
+func (cm *ConnectionManager) SecretAdded(obj interface{}) {
+	cm.secretChanged(obj)
+}
+
+// SecretUpdated reloads the vCenter credentials when the credentials
+// secret changes.
+func (cm *ConnectionManager) SecretUpdated(oldObj, newObj interface{}) {
+	oldSecret, ok := oldObj.(*v1.Secret)
+	if ok && oldSecret != nil {
+		if newSecret, ok := newObj.(*v1.Secret); ok && newSecret != nil &&
+			oldSecret.ResourceVersion == newSecret.ResourceVersion {
+			// a resync, nothing changed
+			return
+		}
+	}
+	cm.secretChanged(newObj)
+}
+
+func (cm *ConnectionManager) secretChanged(obj interface{}) {
+	secret, ok := obj.(*v1.Secret)
+	if secret == nil || !ok {
+		klog.Warningf("secretChanged: unrecognized object %+v", obj)
+		return
+	}
+
+	for vcServer, vsi := range cm.VsphereInstanceMap {
+		credentialManager := cm.credentialManagerFor(vcServer)
+		if credentialManager == nil || credentialManager.SecretLister == nil ||
+			secret.Name != credentialManager.SecretName ||
+			secret.Namespace != credentialManager.SecretNamespace {
+			continue
+		}
+
+		credentials, err := credentialManager.GetCredential(vcServer)
+		if err != nil {
+			if err != credentialmanager.ErrCredentialsNotFound {
+				klog.Errorf("Failed to get credentials of vc=%s from secret %s/%s: %v",
+					vcServer, secret.Namespace, secret.Name, err)
+			}
+			continue
+		}
+
+		// Calls in flight complete on the old session, new ones log in
+		// with the new credentials
+		if vsi.Conn.RotateCredentials(credentials.User, credentials.Password) {
+			klog.Infof("Credentials of vc=%s were rotated by secret %s/%s",
+				vcServer, secret.Namespace, secret.Name)
+		}
+	}
+}
+
+// credentialManagerFor returns the manager of the vCenter's own credentials
+// secret, or the global one when the vCenter does not have its own.
+func (cm *ConnectionManager) credentialManagerFor(vcServer string) *credentialmanager.SecretCredentialManager {
+	if credentialManager, ok := cm.credentialManagers[vcServer]; ok {
+		return credentialManager
+	}
+	return cm.credentialManager
+}
+
+// VerifyCredentialSecrets checks that the secrets the vCenters name in
+// their configuration exist and hold their credentials. The secret lister
+// must have synced.
+func (cm *ConnectionManager) VerifyCredentialSecrets() error {
+	var errs []error
+	for vcServer, credentialManager := range cm.credentialManagers {
+		_, err := credentialManager.SecretLister.Secrets(credentialManager.SecretNamespace).Get(credentialManager.SecretName)
+		if err == nil {
+			_, err = credentialManager.GetCredential(vcServer)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("vc=%s: secret %s/%s: %v",
+				vcServer, credentialManager.SecretNamespace, credentialManager.SecretName, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretRotation(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	config.Global.SecretName = "vsphere-creds"
+	config.Global.SecretNamespace = "kube-system"
+
+	informerFactory := informers.NewSharedInformerFactory(&fake.Clientset{}, 0)
+	secretInformer := informerFactory.Core().V1().Secrets()
+
+	connMgr := NewConnectionManager(config, secretInformer.Lister())
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	vc := config.Global.VCenterIP
+	if err := connMgr.Connect(ctx, vc); err != nil {
+		t.Fatal(err)
+	}
+	oldClient := connMgr.VsphereInstanceMap[vc].Conn.Client
+
+	userName := func(client *vim25.Client) string {
+		userSession, err := session.NewManager(client).UserSession(ctx)
+		if err != nil || userSession == nil {
+			t.Fatalf("UserSession failed: %v", err)
+		}
+		return userSession.UserName
+	}
+	if name := userName(oldClient); name != config.Global.User {
+		t.Fatalf("user mismatch %s=%s", config.Global.User, name)
+	}
+
+	oldSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            config.Global.SecretName,
+			Namespace:       config.Global.SecretNamespace,
+			ResourceVersion: "1",
+		},
+		Data: map[string][]byte{
+			vc + ".username": []byte(config.Global.User),
+			vc + ".password": []byte(config.Global.Password),
+		},
+	}
+	newSecret := oldSecret.DeepCopy()
+	newSecret.ResourceVersion = "2"
+	newSecret.Data = map[string][]byte{
+		vc + ".username": []byte("rotated-user"),
+		vc + ".password": []byte("rotated-password"),
+	}
+	if err := secretInformer.Informer().GetIndexer().Add(newSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	// a secret that is not the credentials secret is ignored
+	otherSecret := newSecret.DeepCopy()
+	otherSecret.Name = "other"
+	connMgr.SecretAdded(otherSecret)
+	if err := connMgr.Connect(ctx, vc); err != nil {
+		t.Fatal(err)
+	}
+	if connMgr.VsphereInstanceMap[vc].Conn.Client != oldClient {
+		t.Error("an unrelated secret should not replace the client")
+	}
+
+	connMgr.SecretUpdated(oldSecret, newSecret)
+
+	// the old session is still usable by the calls holding it
+	if name := userName(oldClient); name != config.Global.User {
+		t.Errorf("user of the old session mismatch %s=%s", config.Global.User, name)
+	}
+
+	// new logins use the rotated credentials
+	if err := connMgr.Connect(ctx, vc); err != nil {
+		t.Fatal(err)
+	}
+	newClient := connMgr.VsphereInstanceMap[vc].Conn.Client
+	if newClient == oldClient {
+		t.Fatal("the client should be replaced after the credentials are rotated")
+	}
+	if name := userName(newClient); name != "rotated-user" {
+		t.Errorf("user mismatch rotated-user=%s", name)
+	}
+}
+
+func TestPerVCSecret(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	vc := config.Global.VCenterIP
+	config.Global.SecretName = "vsphere-creds"
+	config.Global.SecretNamespace = "kube-system"
+	vcConfig := config.VirtualCenter[vc]
+	vcConfig.User = ""
+	vcConfig.Password = ""
+	vcConfig.SecretName = "vc-creds"
+	vcConfig.SecretNamespace = "vc-ns"
+
+	informerFactory := informers.NewSharedInformerFactory(&fake.Clientset{}, 0)
+	secretInformer := informerFactory.Core().V1().Secrets()
+
+	connMgr := NewConnectionManager(config, secretInformer.Lister())
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	// the missing secret is reported with the VC name
+	err := connMgr.VerifyCredentialSecrets()
+	if err == nil {
+		t.Fatal("VerifyCredentialSecrets should fail when the secret is missing")
+	}
+	if !strings.Contains(err.Error(), "vc="+vc) {
+		t.Errorf("VerifyCredentialSecrets error should name the vCenter: %v", err)
+	}
+
+	// the global secret does not hold the credentials of the VC
+	globalSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.Global.SecretName,
+			Namespace: config.Global.SecretNamespace,
+		},
+		Data: map[string][]byte{
+			vc + ".username": []byte("global-user"),
+			vc + ".password": []byte("global-password"),
+		},
+	}
+	vcSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vcConfig.SecretName,
+			Namespace: vcConfig.SecretNamespace,
+		},
+		Data: map[string][]byte{
+			vc + ".username": []byte("vc-user"),
+			vc + ".password": []byte("vc-password"),
+		},
+	}
+	for _, secret := range []*corev1.Secret{globalSecret, vcSecret} {
+		if err = secretInformer.Informer().GetIndexer().Add(secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	connMgr.SecretAdded(globalSecret)
+
+	if err = connMgr.VerifyCredentialSecrets(); err != nil {
+		t.Fatalf("VerifyCredentialSecrets failed: %v", err)
+	}
+
+	// the credentials are read from the VC's secret when dialing
+	if err = connMgr.Connect(ctx, vc); err != nil {
+		t.Fatal(err)
+	}
+	userSession, err := session.NewManager(connMgr.VsphereInstanceMap[vc].Conn.Client).UserSession(ctx)
+	if err != nil || userSession == nil {
+		t.Fatalf("UserSession failed: %v", err)
+	}
+	if userSession.UserName != "vc-user" {
+		t.Errorf("user mismatch vc-user=%s", userSession.UserName)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog"
+
+	vclib "k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// ForEachVC calls fn for all of the vCenters in parallel and waits for
+// all of them to return. The errors returned by fn are aggregated into
+// the returned error, which is nil when fn succeeded for every vCenter.
+// Cancelling the context cancels the calls that are still in flight.
+func (cm *ConnectionManager) ForEachVC(ctx context.Context, fn func(ctx context.Context, vc string) error) error {
+	var g errgroup.Group
+	var mutex sync.Mutex
+	var errs []error
+
+	for vc := range cm.VsphereInstanceMap {
+		vc := vc
+		g.Go(func() error {
+			if err := fn(ctx, vc); err != nil {
+				mutex.Lock()
+				errs = append(errs, fmt.Errorf("vc=%s: %v", vc, err))
+				mutex.Unlock()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// forEachDatacenter connects to all of the vCenters in parallel and calls
+// fn for each of their datacenters as soon as they are discovered, so a
+// slow or unreachable vCenter does not hold up the others. fn may be
+// called concurrently. The errors connecting to the vCenters or
+// discovering their datacenters are aggregated into the returned error.
+func (cm *ConnectionManager) forEachDatacenter(ctx context.Context, fn func(vc string, datacenter *vclib.Datacenter)) error {
+	return cm.searchDatacenters(ctx, func(vc string) bool { return false }, fn)
+}
+
+// searchDatacenters is forEachDatacenter for the vCenters that skip returns
+// false for, which are the only ones connected to.
+func (cm *ConnectionManager) searchDatacenters(ctx context.Context, skip func(vc string) bool,
+	fn func(vc string, datacenter *vclib.Datacenter)) error {
+
+	return cm.ForEachVC(ctx, func(ctx context.Context, vc string) error {
+		if skip(vc) {
+			return nil
+		}
+		datacenterObjs, err := cm.getDatacenters(ctx, vc)
+		for _, datacenterObj := range datacenterObjs {
+			if ctx.Err() != nil {
+				break
+			}
+			fn(vc, datacenterObj)
+		}
+		return err
+	})
+}
+
+// degradedVCs records the VC servers that are degraded. The zero value is
+// ready to use.
+type degradedVCs struct {
+	sync.RWMutex
+
+	vcs map[string]bool
+}
+
+// SetDegraded records whether a vCenter is degraded, as it failed its
+// checks. The searches of the VMs and FCDs skip the degraded vCenters rather
+// than waiting for their connection attempts to fail.
+func (cm *ConnectionManager) SetDegraded(vc string, degraded bool) {
+	cm.degraded.Lock()
+	defer cm.degraded.Unlock()
+
+	if cm.degraded.vcs == nil {
+		cm.degraded.vcs = make(map[string]bool)
+	}
+	if degraded {
+		cm.degraded.vcs[vc] = true
+	} else {
+		delete(cm.degraded.vcs, vc)
+	}
+}
+
+// IsDegraded returns true when a vCenter was set degraded.
+func (cm *ConnectionManager) IsDegraded(vc string) bool {
+	cm.degraded.RLock()
+	defer cm.degraded.RUnlock()
+
+	return cm.degraded.vcs[vc]
+}
+
+// connectWithRetries connects to a vCenter, retrying up to
+// NumConnectionAttempts times.
+func (cm *ConnectionManager) connectWithRetries(ctx context.Context, vc string) error {
+	var err error
+	for i := 0; i < NumConnectionAttempts; i++ {
+		err = cm.Connect(ctx, vc)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(RetryAttemptDelaySecs) * time.Second):
+		}
+	}
+	klog.Errorf("Failed to connect to vc=%s: %v", vc, err)
+	return err
+}
+
+// getDatacenters connects to a vCenter and returns its configured
+// datacenters, or all of its datacenters when none are configured. The
+// datacenters that could be found are returned along with the error for
+// the ones that could not.
+func (cm *ConnectionManager) getDatacenters(ctx context.Context, vc string) ([]*vclib.Datacenter, error) {
+	if err := cm.connectWithRetries(ctx, vc); err != nil {
+		return nil, err
+	}
+	return cm.listDatacenters(ctx, vc)
+}
+
+// listDatacenters is getDatacenters for a vCenter that is connected.
+func (cm *ConnectionManager) listDatacenters(ctx context.Context, vc string) ([]*vclib.Datacenter, error) {
+	vsi := cm.VsphereInstanceMap[vc]
+	if vsi == nil {
+		return nil, ErrConnectionNotFound
+	}
+
+	if vsi.Cfg.Datacenters == "" {
+		datacenterObjs, err := vclib.GetAllDatacenter(ctx, vsi.Conn)
+		if err != nil {
+			klog.Errorf("GetAllDatacenter failed in vc=%s: %v", vc, err)
+			return nil, err
+		}
+		return datacenterObjs, nil
+	}
+
+	var datacenterObjs []*vclib.Datacenter
+	var errs []error
+	for _, dc := range strings.Split(vsi.Cfg.Datacenters, ",") {
+		dc = strings.TrimSpace(dc)
+		if dc == "" {
+			continue
+		}
+		datacenterObj, err := vclib.GetDatacenter(ctx, vsi.Conn, dc)
+		if err != nil {
+			klog.Errorf("GetDatacenter %s failed in vc=%s: %v", dc, vc, err)
+			errs = append(errs, err)
+			continue
+		}
+		datacenterObjs = append(datacenterObjs, datacenterObj)
+	}
+
+	return datacenterObjs, utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+)
+
+func TestForEachVC(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(true)
+	defer cleanup()
+
+	// a vCenter that cannot be reached
+	config.VirtualCenter["localhost"] = &vcfg.VirtualCenterConfig{
+		User:        "user",
+		Password:    "password",
+		VCenterPort: "1",
+	}
+
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	err := connMgr.ForEachVC(ctx, func(ctx context.Context, vc string) error {
+		_, err := connMgr.APIVersionWithContext(ctx, vc)
+		return err
+	})
+	if err == nil {
+		t.Fatal("ForEachVC should fail for the unreachable vCenter")
+	}
+	if !strings.Contains(err.Error(), "vc=localhost") {
+		t.Errorf("ForEachVC error should name the unreachable vCenter: %v", err)
+	}
+	if strings.Contains(err.Error(), "vc="+config.Global.VCenterIP) {
+		t.Errorf("ForEachVC error should not name the reachable vCenter: %v", err)
+	}
+
+	// the reachable vCenter is still searched
+	items, err := connMgr.ListAllVCandDCPairs(ctx)
+	if err != nil {
+		t.Fatalf("ListAllVCandDCPairs err=%v", err)
+	}
+	if len(items) != 2 {
+		t.Errorf("ListAllVCandDCPairs items should be 2 but count=%d", len(items))
+	}
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	info, err := connMgr.WhichVCandDCByNodeID(ctx, vm.Config.Uuid, FindVMByUUID)
+	if err != nil {
+		t.Fatalf("WhichVCandDCByNodeID err=%v", err)
+	}
+	if info.VcServer != config.Global.VCenterIP {
+		t.Errorf("VC mismatch %s=%s", config.Global.VCenterIP, info.VcServer)
+	}
+
+	// a cancelled search fails with the context error
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = connMgr.WhichVCandDCByNodeID(cancelled, vm.Config.Uuid, FindVMByUUID); err != context.Canceled {
+		t.Errorf("WhichVCandDCByNodeID should fail with %v: %v", context.Canceled, err)
+	}
+}
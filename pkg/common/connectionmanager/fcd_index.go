@@ -0,0 +1,300 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+
+	vclib "k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// fcdIndexLookupMetric counts the FCD lookups served by the FCD index and
+// the ones that fell back to searching every datastore.
+var fcdIndexLookupMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cloudprovider_vsphere_fcd_index_lookups",
+		Help: "FCD index lookups by result",
+	},
+	[]string{"result"},
+)
+
+var registerMetricsOnce sync.Once
+
+// RegisterMetrics registers the connection manager metrics, along with
+// the metrics of the vSphere API calls made by its connections
+func RegisterMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(fcdIndexLookupMetric)
+	})
+	vclib.RegisterMetrics()
+}
+
+// fcdLocation is the VC, DC and datastore an FCD was last found in, along
+// with the datastore cluster of the datastore when the FCD was placed in it.
+type fcdLocation struct {
+	vcServer   string
+	datacenter string
+	datastore  string
+	parentType vclib.ParentDatastoreType
+	storagePod string
+}
+
+// fcdIndex maps FCD IDs to their location so that a lookup does not need
+// to search every datastore. The VC and DC an FCD was pinned to are kept
+// apart, as they still hold the FCD once it is no longer at its indexed
+// location. The zero value is ready to use.
+type fcdIndex struct {
+	sync.RWMutex
+
+	locations   map[string]fcdLocation
+	datacenters map[string]fcdLocation
+}
+
+func (i *fcdIndex) get(fcdID string) (fcdLocation, bool) {
+	i.RLock()
+	defer i.RUnlock()
+
+	loc, ok := i.locations[fcdID]
+	return loc, ok
+}
+
+func (i *fcdIndex) set(fcdID string, loc fcdLocation) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.locations == nil {
+		i.locations = make(map[string]fcdLocation)
+	}
+	i.locations[fcdID] = loc
+}
+
+func (i *fcdIndex) remove(fcdID string) {
+	i.Lock()
+	defer i.Unlock()
+
+	delete(i.locations, fcdID)
+}
+
+func (i *fcdIndex) getDatacenter(fcdID string) (fcdLocation, bool) {
+	i.RLock()
+	defer i.RUnlock()
+
+	loc, ok := i.datacenters[fcdID]
+	return loc, ok
+}
+
+func (i *fcdIndex) setDatacenter(fcdID string, loc fcdLocation) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.datacenters == nil {
+		i.datacenters = make(map[string]fcdLocation)
+	}
+	i.datacenters[fcdID] = loc
+}
+
+func (i *fcdIndex) removeDatacenter(fcdID string) {
+	i.Lock()
+	defer i.Unlock()
+
+	delete(i.datacenters, fcdID)
+}
+
+func (i *fcdIndex) reset() {
+	i.Lock()
+	defer i.Unlock()
+
+	i.locations = nil
+}
+
+func (i *fcdIndex) size() int {
+	i.RLock()
+	defer i.RUnlock()
+
+	return len(i.locations)
+}
+
+// IndexFirstClassDisk records the location of an FCD in the FCD index.
+func (cm *ConnectionManager) IndexFirstClassDisk(vcServer string, fcd *vclib.FirstClassDiskInfo) {
+	if fcd == nil || fcd.DatastoreInfo == nil || fcd.Datacenter == nil {
+		return
+	}
+
+	datacenter := fcd.Datacenter.InventoryPath
+	if datacenter == "" {
+		datacenter = fcd.Datacenter.Name()
+	}
+
+	loc := fcdLocation{
+		vcServer:   vcServer,
+		datacenter: datacenter,
+		datastore:  fcd.DatastoreInfo.Info.Name,
+		parentType: vclib.TypeDatastore,
+	}
+	if fcd.ParentType == vclib.TypeDatastoreCluster && fcd.StoragePodInfo != nil && fcd.StoragePodInfo.Summary != nil {
+		loc.parentType = vclib.TypeDatastoreCluster
+		loc.storagePod = fcd.StoragePodInfo.Summary.Name
+	}
+	cm.fcdIndex.set(fcd.Config.Id.Id, loc)
+}
+
+// PinFirstClassDiskDatacenter records the VC and DC an FCD was provisioned
+// in, which are searched before all of the others when the FCD is not at
+// its indexed location.
+func (cm *ConnectionManager) PinFirstClassDiskDatacenter(fcdID string, vcServer string, datacenter string) {
+	if fcdID == "" || vcServer == "" || datacenter == "" {
+		return
+	}
+	cm.fcdIndex.setDatacenter(fcdID, fcdLocation{vcServer: vcServer, datacenter: datacenter})
+}
+
+// UnindexFirstClassDisk removes an FCD from the FCD index, along with the
+// datacenter it was pinned to.
+func (cm *ConnectionManager) UnindexFirstClassDisk(fcdID string) {
+	cm.fcdIndex.remove(fcdID)
+	cm.fcdIndex.removeDatacenter(fcdID)
+}
+
+// ResetFirstClassDiskIndex empties the FCD index, so that the lookups
+// search the datastores until the index is built again.
+func (cm *ConnectionManager) ResetFirstClassDiskIndex() {
+	cm.fcdIndex.reset()
+}
+
+// FirstClassDiskIndexSize returns the number of FCDs in the FCD index.
+func (cm *ConnectionManager) FirstClassDiskIndexSize() int {
+	return cm.fcdIndex.size()
+}
+
+// BuildFirstClassDiskIndex populates the FCD index with all of the FCDs
+// in all of the VC/DC pairs.
+func (cm *ConnectionManager) BuildFirstClassDiskIndex(ctx context.Context) error {
+	listOfVCAndDCPairs, err := cm.ListAllVCandDCPairs(ctx)
+	if err != nil {
+		klog.Errorf("ListAllVCandDCPairs failed. Err: %v", err)
+		return err
+	}
+
+	count := 0
+	for _, pair := range listOfVCAndDCPairs {
+		firstClassDisks, err := pair.DataCenter.GetAllFirstClassDisks(ctx)
+		if err != nil {
+			klog.Errorf("GetAllFirstClassDisks failed vc=%s dc=%s. Err: %v", pair.VcServer, pair.DataCenter.Name(), err)
+			continue
+		}
+		for _, fcd := range firstClassDisks {
+			cm.IndexFirstClassDisk(pair.VcServer, fcd)
+		}
+		count += len(firstClassDisks)
+	}
+
+	klog.V(2).Infof("BuildFirstClassDiskIndex indexed %d FCDs", count)
+	return nil
+}
+
+// lookupFirstClassDiskIndex returns the FCD at the location recorded in
+// the FCD index. Nil is returned when the FCD is not indexed or is no
+// longer at the indexed location, in which case it is removed from the
+// index.
+func (cm *ConnectionManager) lookupFirstClassDiskIndex(ctx context.Context, fcdID string) *FcdDiscoveryInfo {
+	loc, ok := cm.fcdIndex.get(fcdID)
+	if !ok {
+		fcdIndexLookupMetric.With(prometheus.Labels{"result": "miss"}).Inc()
+		return nil
+	}
+
+	fcdInfo, err := cm.getFirstClassDiskAt(ctx, fcdID, loc)
+	if err != nil {
+		klog.V(2).Infof("FCD %s is no longer in vc=%s dc=%s ds=%s: %v",
+			fcdID, loc.vcServer, loc.datacenter, loc.datastore, err)
+		cm.fcdIndex.remove(fcdID)
+		fcdIndexLookupMetric.With(prometheus.Labels{"result": "miss"}).Inc()
+		return nil
+	}
+
+	fcdIndexLookupMetric.With(prometheus.Labels{"result": "hit"}).Inc()
+	return fcdInfo
+}
+
+// lookupPinnedDatacenter returns the FCD when it is found in the datacenter
+// it was pinned to, and indexes its location. Nil is returned when it was
+// not pinned or is not found there.
+func (cm *ConnectionManager) lookupPinnedDatacenter(ctx context.Context, fcdID string) *FcdDiscoveryInfo {
+	loc, ok := cm.fcdIndex.getDatacenter(fcdID)
+	if !ok {
+		return nil
+	}
+
+	if err := cm.Connect(ctx, loc.vcServer); err != nil {
+		klog.V(2).Infof("Failed to connect to vc=%s of FCD %s: %v", loc.vcServer, fcdID, err)
+		return nil
+	}
+
+	datacenter, err := vclib.GetDatacenter(ctx, cm.VsphereInstanceMap[loc.vcServer].Conn, loc.datacenter)
+	if err != nil {
+		klog.V(2).Infof("Failed to find dc=%s of FCD %s in vc=%s: %v", loc.datacenter, fcdID, loc.vcServer, err)
+		return nil
+	}
+
+	fcd, err := datacenter.DoesFirstClassDiskExist(ctx, fcdID)
+	if err != nil {
+		klog.V(2).Infof("FCD %s is not in vc=%s dc=%s: %v", fcdID, loc.vcServer, loc.datacenter, err)
+		return nil
+	}
+
+	fcdInfo := &FcdDiscoveryInfo{DataCenter: datacenter, FCDInfo: fcd, VcServer: loc.vcServer}
+	cm.IndexFirstClassDisk(fcdInfo.VcServer, fcdInfo.FCDInfo)
+	return fcdInfo
+}
+
+func (cm *ConnectionManager) getFirstClassDiskAt(ctx context.Context, fcdID string, loc fcdLocation) (*FcdDiscoveryInfo, error) {
+	if err := cm.Connect(ctx, loc.vcServer); err != nil {
+		return nil, err
+	}
+
+	datacenter, err := vclib.GetDatacenter(ctx, cm.VsphereInstanceMap[loc.vcServer].Conn, loc.datacenter)
+	if err != nil {
+		return nil, err
+	}
+
+	datastore, err := datacenter.GetDatastoreByName(ctx, loc.datastore)
+	if err != nil {
+		return nil, err
+	}
+
+	fcd, err := datastore.GetFirstClassDiskInfoByID(ctx, fcdID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The datastore does not know the datastore cluster it is part of
+	if loc.parentType == vclib.TypeDatastoreCluster {
+		storagePod, err := datacenter.GetDatastoreClusterByName(ctx, loc.storagePod)
+		if err != nil {
+			return nil, err
+		}
+		fcd.ParentType = vclib.TypeDatastoreCluster
+		fcd.FirstClassDisk.StoragePod = storagePod.StoragePod
+		fcd.StoragePodInfo = storagePod
+	}
+
+	return &FcdDiscoveryInfo{DataCenter: datacenter, FCDInfo: fcd, VcServer: loc.vcServer}, nil
+}
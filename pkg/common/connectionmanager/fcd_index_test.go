@@ -0,0 +1,159 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestFirstClassDiskIndex(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(true)
+	defer cleanup()
+
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	/*
+	 * Setup
+	 */
+	// Get a simulator DS
+	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+
+	items, err := connMgr.ListAllVCandDCPairs(ctx)
+	if err != nil {
+		t.Fatalf("ListAllVCandDCPairs err=%v", err)
+	}
+	dc := items[0].DataCenter
+
+	datastoreName := myds.Name
+	datastoreType := vclib.TypeDatastore
+
+	for _, volName := range []string{"indexed", "unindexed"} {
+		err = dc.CreateFirstClassDisk(ctx, datastoreName, datastoreType, volName, 1024)
+		if err != nil {
+			t.Fatalf("CreateFirstClassDisk err=%v", err)
+		}
+	}
+
+	indexed, err := dc.GetFirstClassDisk(ctx, datastoreName, datastoreType, "indexed", vclib.FindFCDByName)
+	if err != nil {
+		t.Fatalf("GetFirstClassDisk err=%v", err)
+	}
+	indexedID := indexed.Config.Id.Id
+
+	if err = connMgr.BuildFirstClassDiskIndex(ctx); err != nil {
+		t.Fatalf("BuildFirstClassDiskIndex err=%v", err)
+	}
+	if _, ok := connMgr.fcdIndex.get(indexedID); !ok {
+		t.Fatalf("FCD %s was not indexed", indexedID)
+	}
+	if size := connMgr.FirstClassDiskIndexSize(); size != 2 {
+		t.Errorf("Expected 2 FCDs in the index, got %d", size)
+	}
+	/*
+	 * Setup
+	 */
+
+	// an indexed FCD is found at its indexed location
+	fcdObj := connMgr.lookupFirstClassDiskIndex(ctx, indexedID)
+	if fcdObj == nil {
+		t.Fatalf("FCD %s was not found in the index", indexedID)
+	}
+	if indexedID != fcdObj.FCDInfo.Config.Id.Id {
+		t.Errorf("FCD ID mismatch %s=%s", indexedID, fcdObj.FCDInfo.Config.Id.Id)
+	}
+	if datastoreName != fcdObj.FCDInfo.DatastoreInfo.Info.Name {
+		t.Errorf("FCD Datastore mismatch %s=%s", datastoreName, fcdObj.FCDInfo.DatastoreInfo.Info.Name)
+	}
+
+	// the datastore cluster of an FCD placed in one is indexed along with
+	// its datastore
+	pod := *indexed
+	pod.ParentType = vclib.TypeDatastoreCluster
+	pod.StoragePodInfo = &vclib.StoragePodInfo{Summary: &types.StoragePodSummary{Name: "pod"}}
+	connMgr.IndexFirstClassDisk(items[0].VcServer, &pod)
+	if loc, _ := connMgr.fcdIndex.get(indexedID); loc.parentType != vclib.TypeDatastoreCluster || loc.storagePod != "pod" {
+		t.Errorf("FCD %s should be indexed in datastore cluster pod: %+v", indexedID, loc)
+	}
+	connMgr.IndexFirstClassDisk(items[0].VcServer, indexed)
+	if loc, _ := connMgr.fcdIndex.get(indexedID); loc.parentType != vclib.TypeDatastore || loc.storagePod != "" {
+		t.Errorf("FCD %s should be indexed in its datastore: %+v", indexedID, loc)
+	}
+
+	// an FCD missing from the index is found by searching and then indexed
+	unindexed, err := dc.GetFirstClassDisk(ctx, datastoreName, datastoreType, "unindexed", vclib.FindFCDByName)
+	if err != nil {
+		t.Fatalf("GetFirstClassDisk err=%v", err)
+	}
+	unindexedID := unindexed.Config.Id.Id
+	connMgr.UnindexFirstClassDisk(unindexedID)
+
+	if _, err = connMgr.WhichVCandDCByFCDId(ctx, unindexedID); err != nil {
+		t.Fatalf("WhichVCandDCByFCDId err=%v", err)
+	}
+	if _, ok := connMgr.fcdIndex.get(unindexedID); !ok {
+		t.Errorf("FCD %s was not indexed after a search", unindexedID)
+	}
+
+	// an FCD no longer at its indexed location is found in the datacenter
+	// it was pinned to, and indexed again
+	connMgr.PinFirstClassDiskDatacenter(unindexedID, items[0].VcServer, dc.Name())
+	connMgr.fcdIndex.remove(unindexedID)
+	if fcdObj = connMgr.lookupPinnedDatacenter(ctx, unindexedID); fcdObj == nil {
+		t.Fatalf("FCD %s was not found in its pinned datacenter", unindexedID)
+	}
+	if _, ok := connMgr.fcdIndex.get(unindexedID); !ok {
+		t.Errorf("FCD %s was not indexed after being found in its pinned datacenter", unindexedID)
+	}
+	connMgr.PinFirstClassDiskDatacenter(unindexedID, items[0].VcServer, "enoent")
+	if fcdObj = connMgr.lookupPinnedDatacenter(ctx, unindexedID); fcdObj != nil {
+		t.Errorf("FCD %s was found in a missing datacenter", unindexedID)
+	}
+	connMgr.UnindexFirstClassDisk(unindexedID)
+	if _, ok := connMgr.fcdIndex.getDatacenter(unindexedID); ok {
+		t.Errorf("FCD %s is still pinned after being unindexed", unindexedID)
+	}
+
+	// a stale index entry is dropped
+	err = dc.DeleteFirstClassDisk(ctx, datastoreName, datastoreType, indexedID)
+	if err != nil {
+		t.Fatalf("DeleteFirstClassDisk err=%v", err)
+	}
+	if _, err = connMgr.WhichVCandDCByFCDId(ctx, indexedID); err != vclib.ErrNoDiskIDFound {
+		t.Errorf("WhichVCandDCByFCDId of a deleted FCD should fail with %v: %v", vclib.ErrNoDiskIDFound, err)
+	}
+	if _, ok := connMgr.fcdIndex.get(indexedID); ok {
+		t.Errorf("Deleted FCD %s is still indexed", indexedID)
+	}
+
+	// a reset empties the index
+	connMgr.ResetFirstClassDiskIndex()
+	if _, ok := connMgr.fcdIndex.get(unindexedID); ok {
+		t.Errorf("FCD %s is still indexed after a reset", unindexedID)
+	}
+	if size := connMgr.FirstClassDiskIndexSize(); size != 0 {
+		t.Errorf("The index should be empty after a reset, got %d FCDs", size)
+	}
+}
@@ -20,7 +20,7 @@ import (
 	"context"
 	"sort"
 	"strings"
-	"time"
+	"sync"
 
 	"k8s.io/klog"
 
@@ -31,53 +31,19 @@ import (
 func (cm *ConnectionManager) ListAllVCandDCPairs(ctx context.Context) ([]*ListDiscoveryInfo, error) {
 	klog.V(4).Infof("ListAllVCandDCPairs called")
 
+	var mutex sync.Mutex
 	listOfVCAndDCPairs := make([]*ListDiscoveryInfo, 0)
 
-	for vc, vsi := range cm.VsphereInstanceMap {
-		var datacenterObjs []*vclib.Datacenter
-
-		var err error
-		for i := 0; i < NumConnectionAttempts; i++ {
-			err = cm.Connect(ctx, vc)
-			if err == nil {
-				break
-			}
-			time.Sleep(time.Duration(RetryAttemptDelaySecs) * time.Second)
-		}
-
-		if err != nil {
-			klog.Error("Connect error vc:", err)
-			continue
-		}
-
-		if vsi.Cfg.Datacenters == "" {
-			datacenterObjs, err = vclib.GetAllDatacenter(ctx, vsi.Conn)
-			if err != nil {
-				klog.Error("GetAllDatacenter error dc:", err)
-				continue
-			}
-		} else {
-			datacenters := strings.Split(vsi.Cfg.Datacenters, ",")
-			for _, dc := range datacenters {
-				dc = strings.TrimSpace(dc)
-				if dc == "" {
-					continue
-				}
-				datacenterObj, err := vclib.GetDatacenter(ctx, vsi.Conn, dc)
-				if err != nil {
-					klog.Error("GetDatacenter error dc:", err)
-					continue
-				}
-				datacenterObjs = append(datacenterObjs, datacenterObj)
-			}
-		}
-
-		for _, datacenterObj := range datacenterObjs {
-			listOfVCAndDCPairs = append(listOfVCAndDCPairs, &ListDiscoveryInfo{
-				VcServer:   vc,
-				DataCenter: datacenterObj,
-			})
-		}
+	err := cm.forEachDatacenter(ctx, func(vc string, datacenterObj *vclib.Datacenter) {
+		mutex.Lock()
+		listOfVCAndDCPairs = append(listOfVCAndDCPairs, &ListDiscoveryInfo{
+			VcServer:   vc,
+			DataCenter: datacenterObj,
+		})
+		mutex.Unlock()
+	})
+	if err != nil {
+		klog.Error("ListAllVCandDCPairs error:", err)
 	}
 
 	sort.Slice(listOfVCAndDCPairs, func(i, j int) bool {
@@ -87,3 +53,10 @@ func (cm *ConnectionManager) ListAllVCandDCPairs(ctx context.Context) ([]*ListDi
 
 	return listOfVCAndDCPairs, nil
 }
+
+// ListDatacenters returns the datacenters of a vCenter in the order they are
+// configured in, or all of its datacenters when none are configured.
+func (cm *ConnectionManager) ListDatacenters(ctx context.Context, vc string) ([]*vclib.Datacenter, error) {
+	klog.V(4).Infof("ListDatacenters called with vc=%s", vc)
+	return cm.getDatacenters(ctx, vc)
+}
@@ -20,7 +20,6 @@ import (
 	"context"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/vmware/govmomi/vim25/mo"
 	"k8s.io/klog"
@@ -35,12 +34,15 @@ func (f FindVM) String() string {
 		return "byUUID"
 	case FindVMByName:
 		return "byName"
+	case FindVMByIP:
+		return "byIP"
 	default:
 		return "byUnknown"
 	}
 }
 
-// WhichVCandDCByNodeID finds the VC/DC combo that owns a particular VM
+// WhichVCandDCByNodeID finds the VC/DC combo that owns a particular VM. The
+// degraded vCenters are not searched.
 func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID string, searchBy FindVM) (*VMDiscoveryInfo, error) {
 	if nodeID == "" {
 		klog.V(3).Info("WhichVCandDCByNodeID called but nodeID is empty")
@@ -59,11 +61,18 @@ func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID st
 
 	queueChannel = make(chan *vmSearch, QueueSize)
 
+	// cancel the searches still in flight once one succeeds
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	myNodeID := nodeID
-	if searchBy == FindVMByUUID {
+	switch searchBy {
+	case FindVMByUUID:
 		klog.V(3).Info("WhichVCandDCByNodeID by UUID")
 		myNodeID = strings.ToLower(nodeID)
-	} else {
+	case FindVMByIP:
+		klog.V(3).Info("WhichVCandDCByNodeID by IP")
+	default:
 		klog.V(3).Info("WhichVCandDCByNodeID by Name")
 	}
 	klog.V(2).Info("WhichVCandDCByNodeID nodeID: ", myNodeID)
@@ -91,65 +100,19 @@ func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID st
 	}
 
 	go func() {
-		for vc, vsi := range cm.VsphereInstanceMap {
-			var datacenterObjs []*vclib.Datacenter
-
-			found := getVMFound()
-			if found == true {
-				break
-			}
-
-			var err error
-			for i := 0; i < NumConnectionAttempts; i++ {
-				err = cm.Connect(ctx, vc)
-				if err == nil {
-					break
-				}
-				time.Sleep(time.Duration(RetryAttemptDelaySecs) * time.Second)
-			}
-
-			if err != nil {
-				klog.Error("WhichVCandDCByNodeID error vc:", err)
-				setGlobalErr(err)
-				continue
-			}
-
-			if vsi.Cfg.Datacenters == "" {
-				datacenterObjs, err = vclib.GetAllDatacenter(ctx, vsi.Conn)
-				if err != nil {
-					klog.Error("WhichVCandDCByNodeID error dc:", err)
-					setGlobalErr(err)
-					continue
-				}
-			} else {
-				datacenters := strings.Split(vsi.Cfg.Datacenters, ",")
-				for _, dc := range datacenters {
-					dc = strings.TrimSpace(dc)
-					if dc == "" {
-						continue
-					}
-					datacenterObj, err := vclib.GetDatacenter(ctx, vsi.Conn, dc)
-					if err != nil {
-						klog.Error("WhichVCandDCByNodeID error dc:", err)
-						setGlobalErr(err)
-						continue
-					}
-					datacenterObjs = append(datacenterObjs, datacenterObj)
-				}
-			}
-
-			for _, datacenterObj := range datacenterObjs {
-				found := getVMFound()
-				if found == true {
-					break
-				}
-
-				klog.V(4).Infof("Finding node %s in vc=%s and datacenter=%s", myNodeID, vc, datacenterObj.Name())
-				queueChannel <- &vmSearch{
-					vc:         vc,
-					datacenter: datacenterObj,
-				}
+		err := cm.searchDatacenters(ctx, cm.IsDegraded, func(vc string, datacenterObj *vclib.Datacenter) {
+			klog.V(4).Infof("Finding node %s in vc=%s and datacenter=%s", myNodeID, vc, datacenterObj.Name())
+			select {
+			case queueChannel <- &vmSearch{
+				vc:         vc,
+				datacenter: datacenterObj,
+			}:
+			case <-ctx.Done():
 			}
+		})
+		if err != nil {
+			klog.Error("WhichVCandDCByNodeID error:", err)
+			setGlobalErr(err)
 		}
 		close(queueChannel)
 	}()
@@ -159,11 +122,17 @@ func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID st
 		wg.Add(1)
 		go func() {
 			for res := range queueChannel {
+				if getVMFound() {
+					continue
+				}
 				var vm *vclib.VirtualMachine
 				var err error
-				if searchBy == FindVMByUUID {
+				switch searchBy {
+				case FindVMByUUID:
 					vm, err = res.datacenter.GetVMByUUID(ctx, myNodeID)
-				} else {
+				case FindVMByIP:
+					vm, err = res.datacenter.GetVMByIP(ctx, myNodeID)
+				default:
 					vm, err = res.datacenter.GetVMByDNSName(ctx, myNodeID)
 				}
 
@@ -180,7 +149,7 @@ func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID st
 				}
 
 				var oVM mo.VirtualMachine
-				err = vm.Properties(ctx, vm.Reference(), []string{"config", "summary", "guest"}, &oVM)
+				err = vm.Properties(ctx, vm.Reference(), []string{vclib.VirtualMachineUUIDProperty, vclib.VirtualMachineHostNameProperty}, &oVM)
 				if err != nil {
 					klog.Errorf("Error collecting properties for vm=%+v in vc=%s and datacenter=%s: %v",
 						vm, res.vc, res.datacenter.Name(), err)
@@ -189,11 +158,17 @@ func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID st
 
 				klog.V(2).Infof("Found node %s as vm=%+v in vc=%s and datacenter=%s",
 					nodeID, vm, res.vc, res.datacenter.Name())
-				klog.V(2).Info("Hostname: ", oVM.Guest.HostName, " UUID: ", oVM.Summary.Config.Uuid)
+				// the guest is not retrieved without a host name
+				var hostname string
+				if oVM.Guest != nil {
+					hostname = oVM.Guest.HostName
+				}
+				klog.V(2).Info("Hostname: ", hostname, " UUID: ", oVM.Summary.Config.Uuid)
 
 				vmInfo = &VMDiscoveryInfo{DataCenter: res.datacenter, VM: vm, VcServer: res.vc,
-					UUID: oVM.Summary.Config.Uuid, NodeName: oVM.Guest.HostName}
+					UUID: oVM.Summary.Config.Uuid, NodeName: hostname}
 				setVMFound(true)
+				cancel()
 				break
 			}
 			wg.Done()
@@ -203,6 +178,9 @@ func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID st
 	if vmFound {
 		return vmInfo, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if globalErr != nil {
 		return nil, *globalErr
 	}
@@ -211,7 +189,10 @@ func (cm *ConnectionManager) WhichVCandDCByNodeID(ctx context.Context, nodeID st
 	return nil, vclib.ErrNoVMFound
 }
 
-// WhichVCandDCByFCDId searches for an FCD using the provided ID.
+// WhichVCandDCByFCDId searches for an FCD using the provided ID. Its
+// indexed location and the datacenter it was pinned to are searched before
+// the vslm catalogs and the datastores of every datacenter. The degraded
+// vCenters are not searched.
 func (cm *ConnectionManager) WhichVCandDCByFCDId(ctx context.Context, fcdID string) (*FcdDiscoveryInfo, error) {
 	if fcdID == "" {
 		klog.V(3).Info("WhichVCandDCByFCDId called but fcdID is empty")
@@ -219,6 +200,32 @@ func (cm *ConnectionManager) WhichVCandDCByFCDId(ctx context.Context, fcdID stri
 	}
 	klog.V(2).Info("WhichVCandDCByFCDId fcdID: ", fcdID)
 
+	if fcdInfo := cm.lookupFirstClassDiskIndex(ctx, fcdID); fcdInfo != nil {
+		klog.V(2).Infof("Found FCD %s in the FCD index", fcdID)
+		return fcdInfo, nil
+	}
+	if fcdInfo := cm.lookupPinnedDatacenter(ctx, fcdID); fcdInfo != nil {
+		klog.V(2).Infof("Found FCD %s in its pinned datacenter", fcdID)
+		return fcdInfo, nil
+	}
+
+	// Only the vCenters without a vslm catalog are scanned
+	catalogInfo, searched := cm.findFirstClassDiskInCatalogs(ctx, fcdID)
+	if catalogInfo != nil {
+		cm.IndexFirstClassDisk(catalogInfo.VcServer, catalogInfo.FCDInfo)
+		return catalogInfo, nil
+	}
+	unsearched := 0
+	for vc := range cm.VsphereInstanceMap {
+		if !searched[vc] && !cm.IsDegraded(vc) {
+			unsearched++
+		}
+	}
+	if unsearched == 0 {
+		klog.V(4).Infof("WhichVCandDCByFCDId: %q FCD not found", fcdID)
+		return nil, vclib.ErrNoDiskIDFound
+	}
+
 	type fcdSearch struct {
 		vc         string
 		datacenter *vclib.Datacenter
@@ -232,6 +239,10 @@ func (cm *ConnectionManager) WhichVCandDCByFCDId(ctx context.Context, fcdID stri
 
 	queueChannel = make(chan *fcdSearch, QueueSize)
 
+	// cancel the searches still in flight once one succeeds
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	fcdFound := false
 	globalErr = nil
 
@@ -255,65 +266,22 @@ func (cm *ConnectionManager) WhichVCandDCByFCDId(ctx context.Context, fcdID stri
 	}
 
 	go func() {
-		for vc, vsi := range cm.VsphereInstanceMap {
-			var datacenterObjs []*vclib.Datacenter
-
-			found := getFCDFound()
-			if found == true {
-				break
-			}
-
-			var err error
-			for i := 0; i < NumConnectionAttempts; i++ {
-				err = cm.Connect(ctx, vc)
-				if err == nil {
-					break
-				}
-				time.Sleep(time.Duration(RetryAttemptDelaySecs) * time.Second)
-			}
-
-			if err != nil {
-				klog.Error("WhichVCandDCByFCDId error vc:", err)
-				setGlobalErr(err)
-				continue
-			}
-
-			if vsi.Cfg.Datacenters == "" {
-				datacenterObjs, err = vclib.GetAllDatacenter(ctx, vsi.Conn)
-				if err != nil {
-					klog.Error("WhichVCandDCByFCDId error dc:", err)
-					setGlobalErr(err)
-					continue
-				}
-			} else {
-				datacenters := strings.Split(vsi.Cfg.Datacenters, ",")
-				for _, dc := range datacenters {
-					dc = strings.TrimSpace(dc)
-					if dc == "" {
-						continue
-					}
-					datacenterObj, err := vclib.GetDatacenter(ctx, vsi.Conn, dc)
-					if err != nil {
-						klog.Error("WhichVCandDCByFCDId error dc:", err)
-						setGlobalErr(err)
-						continue
-					}
-					datacenterObjs = append(datacenterObjs, datacenterObj)
-				}
-			}
-
-			for _, datacenterObj := range datacenterObjs {
-				found := getFCDFound()
-				if found == true {
-					break
-				}
-
-				klog.V(4).Infof("Finding FCD %s in vc=%s and datacenter=%s", fcdID, vc, datacenterObj.Name())
-				queueChannel <- &fcdSearch{
-					vc:         vc,
-					datacenter: datacenterObj,
-				}
+		skip := func(vc string) bool {
+			return searched[vc] || cm.IsDegraded(vc)
+		}
+		err := cm.searchDatacenters(ctx, skip, func(vc string, datacenterObj *vclib.Datacenter) {
+			klog.V(4).Infof("Finding FCD %s in vc=%s and datacenter=%s", fcdID, vc, datacenterObj.Name())
+			select {
+			case queueChannel <- &fcdSearch{
+				vc:         vc,
+				datacenter: datacenterObj,
+			}:
+			case <-ctx.Done():
 			}
+		})
+		if err != nil {
+			klog.Error("WhichVCandDCByFCDId error:", err)
+			setGlobalErr(err)
 		}
 		close(queueChannel)
 	}()
@@ -323,6 +291,9 @@ func (cm *ConnectionManager) WhichVCandDCByFCDId(ctx context.Context, fcdID stri
 		wg.Add(1)
 		go func() {
 			for res := range queueChannel {
+				if getFCDFound() {
+					continue
+				}
 
 				fcd, err := res.datacenter.DoesFirstClassDiskExist(ctx, fcdID)
 				if err != nil {
@@ -342,6 +313,7 @@ func (cm *ConnectionManager) WhichVCandDCByFCDId(ctx context.Context, fcdID stri
 
 				fcdInfo = &FcdDiscoveryInfo{DataCenter: res.datacenter, FCDInfo: fcd, VcServer: res.vc}
 				setFCDFound(true)
+				cancel()
 				break
 			}
 			wg.Done()
@@ -349,8 +321,12 @@ func (cm *ConnectionManager) WhichVCandDCByFCDId(ctx context.Context, fcdID stri
 	}
 	wg.Wait()
 	if fcdFound {
+		cm.IndexFirstClassDisk(fcdInfo.VcServer, fcdInfo.FCDInfo)
 		return fcdInfo, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if globalErr != nil {
 		return nil, *globalErr
 	}
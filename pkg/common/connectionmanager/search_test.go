@@ -159,3 +159,39 @@ func TestWhichVCandDCByFCDId(t *testing.T) {
 		t.Errorf("FCD Size mismatch %d=%d", volSizeMB, fcdObj.FCDInfo.Config.CapacityInMB)
 	}
 }
+
+func TestSearchSkipsDegradedVCs(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(false)
+	defer cleanup()
+
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	UUID := vm.Config.Uuid
+
+	// a degraded vCenter is neither connected to nor searched
+	connMgr.SetDegraded(config.Global.VCenterIP, true)
+	if !connMgr.IsDegraded(config.Global.VCenterIP) {
+		t.Fatalf("vc=%s should be degraded", config.Global.VCenterIP)
+	}
+
+	if _, err := connMgr.WhichVCandDCByNodeID(ctx, UUID, FindVMByUUID); err != vclib.ErrNoVMFound {
+		t.Errorf("WhichVCandDCByNodeID should not search a degraded vCenter: %v", err)
+	}
+	if _, err := connMgr.WhichVCandDCByFCDId(ctx, "enoent"); err != vclib.ErrNoDiskIDFound {
+		t.Errorf("WhichVCandDCByFCDId should not search a degraded vCenter: %v", err)
+	}
+	if connMgr.VsphereInstanceMap[config.Global.VCenterIP].Conn.Client != nil {
+		t.Errorf("vc=%s should not be connected to while degraded", config.Global.VCenterIP)
+	}
+
+	// it is searched again once healthy
+	connMgr.SetDegraded(config.Global.VCenterIP, false)
+	if _, err := connMgr.WhichVCandDCByNodeID(ctx, UUID, FindVMByUUID); err != nil {
+		t.Errorf("WhichVCandDCByNodeID err=%v", err)
+	}
+}
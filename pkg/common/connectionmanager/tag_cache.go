@@ -0,0 +1,216 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+
+	vclib "k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// TagCacheTTL is how long the tags attached to a vSphere object, and the
+// names of the tags and of their categories, are cached.
+var TagCacheTTL = 5 * time.Minute
+
+// tagInfo is the name of a tag and the name of its category.
+type tagInfo struct {
+	name     string
+	category string
+}
+
+type cachedTagInfo struct {
+	info    tagInfo
+	expires time.Time
+}
+
+type cachedAttachedTags struct {
+	tags    []tagInfo
+	expires time.Time
+}
+
+// tagCache caches the tagging lookups made to resolve zones, as every
+// lookup is a vAPI call and the tags rarely change. The entries are keyed
+// by vCenter. The zero value is ready to use.
+type tagCache struct {
+	sync.RWMutex
+
+	// the tags attached to each object
+	attached map[string]cachedAttachedTags
+	// the names of each tag and of its category
+	tags map[string]cachedTagInfo
+	// the expiry of each tag known to exist, keyed by category and name
+	existing map[string]time.Time
+}
+
+func (c *tagCache) getAttached(vcServer string, ref types.ManagedObjectReference) ([]tagInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.attached[vcServer+"/"+ref.String()]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.tags, true
+}
+
+func (c *tagCache) setAttached(vcServer string, ref types.ManagedObjectReference, tags []tagInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.attached == nil {
+		c.attached = make(map[string]cachedAttachedTags)
+	}
+	c.attached[vcServer+"/"+ref.String()] = cachedAttachedTags{
+		tags:    tags,
+		expires: time.Now().Add(TagCacheTTL),
+	}
+}
+
+func (c *tagCache) getTag(vcServer string, tagID string) (tagInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.tags[vcServer+"/"+tagID]
+	if !ok || time.Now().After(entry.expires) {
+		return tagInfo{}, false
+	}
+	return entry.info, true
+}
+
+func (c *tagCache) setTag(vcServer string, tagID string, info tagInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.tags == nil {
+		c.tags = make(map[string]cachedTagInfo)
+	}
+	c.tags[vcServer+"/"+tagID] = cachedTagInfo{
+		info:    info,
+		expires: time.Now().Add(TagCacheTTL),
+	}
+}
+
+func (c *tagCache) exists(vcServer string, info tagInfo) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	expires, ok := c.existing[vcServer+"/"+info.category+"/"+info.name]
+	return ok && time.Now().Before(expires)
+}
+
+func (c *tagCache) setExists(vcServer string, info tagInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.existing == nil {
+		c.existing = make(map[string]time.Time)
+	}
+	c.existing[vcServer+"/"+info.category+"/"+info.name] = time.Now().Add(TagCacheTTL)
+}
+
+// tagResolver returns the tags attached to the objects of a vCenter through
+// the tag cache. It only logs in to the vAPI endpoint on a cache miss, and
+// must be closed once done.
+type tagResolver struct {
+	cache    *tagCache
+	vcServer string
+	conn     *vclib.VSphereConnection
+
+	client  *rest.Client
+	manager *tags.Manager
+}
+
+func (cm *ConnectionManager) newTagResolver(vcServer string, conn *vclib.VSphereConnection) *tagResolver {
+	return &tagResolver{
+		cache:    &cm.tagCache,
+		vcServer: vcServer,
+		conn:     conn,
+	}
+}
+
+// getManager returns the tag manager, logging in on the first call.
+func (r *tagResolver) getManager(ctx context.Context) (*tags.Manager, error) {
+	if r.manager != nil {
+		return r.manager, nil
+	}
+
+	c := rest.NewClient(r.conn.Client)
+	vclib.InheritProxy(r.conn.Client.Client, c.Client)
+	user := url.UserPassword(r.conn.Username, r.conn.Password)
+	if err := c.Login(ctx, user); err != nil {
+		return nil, err
+	}
+	r.client = c
+	r.manager = tags.NewManager(c)
+	return r.manager, nil
+}
+
+// attachedTags returns the tags attached to the object.
+func (r *tagResolver) attachedTags(ctx context.Context, ref types.ManagedObjectReference) ([]tagInfo, error) {
+	if attached, ok := r.cache.getAttached(r.vcServer, ref); ok {
+		return attached, nil
+	}
+
+	m, err := r.getManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tagIDs, err := m.ListAttachedTags(ctx, ref)
+	if err != nil {
+		klog.Errorf("Cannot list attached tags. Err: %v", err)
+		return nil, err
+	}
+
+	attached := make([]tagInfo, 0, len(tagIDs))
+	for _, tagID := range tagIDs {
+		info, ok := r.cache.getTag(r.vcServer, tagID)
+		if !ok {
+			tag, err := m.GetTag(ctx, tagID)
+			if err != nil {
+				klog.Errorf("Zones Get tag %s: %s", tagID, err)
+				return nil, err
+			}
+			category, err := m.GetCategory(ctx, tag.CategoryID)
+			if err != nil {
+				klog.Errorf("Zones Get category %s error", tag.CategoryID)
+				return nil, err
+			}
+			info = tagInfo{name: tag.Name, category: category.Name}
+			r.cache.setTag(r.vcServer, tagID, info)
+		}
+		attached = append(attached, info)
+	}
+
+	r.cache.setAttached(r.vcServer, ref, attached)
+	return attached, nil
+}
+
+// close logs out of the vAPI endpoint if the resolver logged in.
+func (r *tagResolver) close(ctx context.Context) {
+	if r.client != nil {
+		r.client.Logout(ctx)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/vapi/tags"
+	"k8s.io/klog"
+)
+
+// EnsureTag creates the tag of the category in the vCenter, along with the
+// category, unless they already exist. The tags found to exist are cached
+// for TagCacheTTL.
+func (cm *ConnectionManager) EnsureTag(ctx context.Context, vcServer string, categoryName string, tagName string) error {
+	info := tagInfo{name: tagName, category: categoryName}
+	if cm.tagCache.exists(vcServer, info) {
+		return nil
+	}
+
+	vsi := cm.VsphereInstanceMap[vcServer]
+	if vsi == nil {
+		klog.Errorf("Unable to find Connection for %s", vcServer)
+		return ErrConnectionNotFound
+	}
+	if err := cm.ConnectByInstance(ctx, vsi); err != nil {
+		return err
+	}
+
+	resolver := cm.newTagResolver(vcServer, vsi.Conn)
+	defer resolver.close(ctx)
+
+	m, err := resolver.getManager(ctx)
+	if err != nil {
+		klog.Errorf("Cannot login to the vAPI endpoint of %s. Err: %v", vcServer, err)
+		return err
+	}
+
+	categoryID, err := ensureCategory(ctx, m, categoryName)
+	if err != nil {
+		return err
+	}
+
+	existing, err := m.GetTagsForCategory(ctx, categoryID)
+	if err != nil {
+		klog.Errorf("Cannot list the tags of category %s. Err: %v", categoryName, err)
+		return err
+	}
+	found := false
+	for _, tag := range existing {
+		if tag.Name == tagName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		if _, err := m.CreateTag(ctx, &tags.Tag{Name: tagName, CategoryID: categoryID}); err != nil {
+			klog.Errorf("Cannot create tag %s in category %s. Err: %v", tagName, categoryName, err)
+			return err
+		}
+		klog.V(2).Infof("Created tag %s in category %s of %s", tagName, categoryName, vcServer)
+	}
+
+	cm.tagCache.setExists(vcServer, info)
+	return nil
+}
+
+// ensureCategory returns the ID of the category, which is created with a
+// single cardinality when it does not exist.
+func ensureCategory(ctx context.Context, m *tags.Manager, categoryName string) (string, error) {
+	categories, err := m.GetCategories(ctx)
+	if err != nil {
+		klog.Errorf("Cannot list the tag categories. Err: %v", err)
+		return "", err
+	}
+	for _, category := range categories {
+		if category.Name == categoryName {
+			return category.ID, nil
+		}
+	}
+
+	categoryID, err := m.CreateCategory(ctx, &tags.Category{
+		Name:        categoryName,
+		Cardinality: "SINGLE",
+	})
+	if err != nil {
+		klog.Errorf("Cannot create tag category %s. Err: %v", categoryName, err)
+		return "", err
+	}
+	return categoryID, nil
+}
@@ -17,6 +17,10 @@ limitations under the License.
 package connectionmanager
 
 import (
+	"sync"
+
+	"github.com/vmware/govmomi/vim25/types"
+
 	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
 	cm "k8s.io/cloud-provider-vsphere/pkg/common/credentialmanager"
 	vclib "k8s.io/cloud-provider-vsphere/pkg/common/vclib"
@@ -28,12 +32,43 @@ type ConnectionManager struct {
 	VsphereInstanceMap map[string]*VSphereInstance
 	// CredentialsManager
 	credentialManager *cm.SecretCredentialManager
+	// Maps the VC server to the manager of its own credentials secret, for
+	// the VCs that do not use the global one
+	credentialManagers map[string]*cm.SecretCredentialManager
+	// Maps FCD IDs to the VC/DC/datastore they were last found in
+	fcdIndex fcdIndex
+	// Caches the tags attached to the objects used to resolve zones
+	tagCache tagCache
+	// Records the VC servers whose FCDs are found in their vslm catalog
+	vslmCatalogs vslmCatalogs
+	// Records the capabilities of each VC server
+	capabilities capabilityMatrix
+	// Records the VC servers that are degraded, which the searches skip
+	degraded degradedVCs
+	// When true, every VC server has all of the capabilities whatever its
+	// API version
+	skipAPIVersionGates bool
 }
 
 // VSphereInstance represents a vSphere instance where one or more kubernetes nodes are running.
 type VSphereInstance struct {
 	Conn *vclib.VSphereConnection
 	Cfg  *vcfg.VirtualCenterConfig
+
+	// serializes the connection attempts to this vSphere instance
+	clientLock sync.Mutex
+
+	// connecting is the connection attempt in flight, whose result the
+	// concurrent callers share rather than connecting in turn
+	connectLock sync.Mutex
+	connecting  *connectCall
+}
+
+// connectCall is a connection attempt to a vSphere instance. Its error is
+// set once done is closed.
+type connectCall struct {
+	done chan struct{}
+	err  error
 }
 
 // VMDiscoveryInfo contains VM info about a discovered VM
@@ -58,6 +93,13 @@ type ListDiscoveryInfo struct {
 	DataCenter *vclib.Datacenter
 }
 
+// HostZone is the zone and region of a host
+type HostZone struct {
+	Host   types.ManagedObjectReference
+	Zone   string
+	Region string
+}
+
 // ZoneDiscoveryInfo contains VC+DC info based on a given zone
 type ZoneDiscoveryInfo struct {
 	DataCenter *vclib.Datacenter
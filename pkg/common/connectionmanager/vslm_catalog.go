@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/klog"
+
+	vclib "k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// vslmCatalogs records whether the FCDs of each VC server are found in its
+// vslm catalog. The zero value is ready to use.
+type vslmCatalogs struct {
+	sync.RWMutex
+
+	detected map[string]bool
+}
+
+// get returns whether the VC server has a catalog, and false for known
+// when it was not detected yet.
+func (c *vslmCatalogs) get(vc string) (catalog bool, known bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	catalog, known = c.detected[vc]
+	return catalog, known
+}
+
+func (c *vslmCatalogs) set(vc string, catalog bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.detected == nil {
+		c.detected = make(map[string]bool)
+	}
+	c.detected[vc] = catalog
+}
+
+// DetectVslmCatalog returns true when a vCenter serves the vslm catalog of
+// its FCDs, which is then queried to find the FCDs of the vCenter rather
+// than listing the FCDs of every datastore. The vCenters without the
+// capability, older than 7.0, and the ones whose catalog fails to be
+// reached, have their datastores scanned.
+func (cm *ConnectionManager) DetectVslmCatalog(ctx context.Context, vc string) (bool, error) {
+	if err := cm.Connect(ctx, vc); err != nil {
+		return false, err
+	}
+
+	client := cm.VsphereInstanceMap[vc].Conn.Client
+	apiVersion := client.ServiceContent.About.ApiVersion
+	if !cm.HasCapability(ctx, vc, vclib.CapabilityVslmCatalog) {
+		klog.V(2).Infof("vc=%s with API version %s has no vslm catalog, its datastores are scanned for FCDs",
+			vc, apiVersion)
+		cm.vslmCatalogs.set(vc, false)
+		return false, nil
+	}
+
+	vslmClient, err := vclib.NewVslmClient(ctx, client)
+	if err != nil {
+		klog.Warningf("Failed to reach the vslm catalog of vc=%s, its datastores are scanned for FCDs. Err: %v", vc, err)
+		cm.vslmCatalogs.set(vc, false)
+		return false, err
+	}
+
+	klog.V(2).Infof("FCDs of vc=%s are found in its vslm catalog with API version %s", vc, vslmClient.About.APIVersion)
+	cm.vslmCatalogs.set(vc, true)
+	return true, nil
+}
+
+// VslmCatalogDetected returns true when a vCenter was detected to serve
+// the vslm catalog of its FCDs.
+func (cm *ConnectionManager) VslmCatalogDetected(vc string) bool {
+	catalog, _ := cm.vslmCatalogs.get(vc)
+	return catalog
+}
+
+// hasVslmCatalog returns true when the FCDs of a vCenter are found in its
+// vslm catalog. The catalog is detected on first use when it was not
+// detected before.
+func (cm *ConnectionManager) hasVslmCatalog(ctx context.Context, vc string) bool {
+	catalog, known := cm.vslmCatalogs.get(vc)
+	if !known {
+		catalog, _ = cm.DetectVslmCatalog(ctx, vc)
+	}
+	return catalog
+}
+
+// vslmClient returns the client of the vslm catalog of a vCenter, or nil
+// when the vCenter has none.
+func (cm *ConnectionManager) vslmClient(ctx context.Context, vc string) (*vclib.VslmClient, error) {
+	if !cm.hasVslmCatalog(ctx, vc) {
+		return nil, nil
+	}
+	if err := cm.Connect(ctx, vc); err != nil {
+		return nil, err
+	}
+	return vclib.NewVslmClient(ctx, cm.VsphereInstanceMap[vc].Conn.Client)
+}
+
+// CatalogFirstClassDisks returns the FCDs of the datacenters of a vCenter
+// found in its vslm catalog with the query, or all of them without a
+// query. When allowed is not nil, only the FCDs whose parent datastore, or
+// datastore cluster, it returns true for are returned. ok is false when
+// the vCenter has no catalog, in which case its datastores must be scanned
+// instead.
+func (cm *ConnectionManager) CatalogFirstClassDisks(ctx context.Context, vc string, datacenters []*vclib.Datacenter,
+	allowed func(name string) bool, query ...vclib.VslmQuerySpec) ([]*vclib.FirstClassDiskInfo, bool, error) {
+
+	vslmClient, err := cm.vslmClient(ctx, vc)
+	if err != nil || vslmClient == nil {
+		return nil, false, err
+	}
+
+	results, err := vslmClient.Query(ctx, query...)
+	if err != nil {
+		return nil, true, err
+	}
+	klog.V(4).Infof("Found %d FCDs in the vslm catalog of vc=%s", len(results), vc)
+
+	firstClassDisks := make([]*vclib.FirstClassDiskInfo, 0)
+	if len(results) == 0 {
+		return firstClassDisks, true, nil
+	}
+	for _, datacenter := range datacenters {
+		fcds, err := datacenter.CatalogFirstClassDisks(ctx, results, allowed)
+		if err != nil {
+			return nil, true, err
+		}
+		firstClassDisks = append(firstClassDisks, fcds...)
+	}
+	return firstClassDisks, true, nil
+}
+
+// findFirstClassDiskInCatalogs searches for an FCD in the vslm catalogs of
+// the vCenters that have one. The vCenters whose catalog was searched are
+// returned along with the FCD, so that only the others are scanned for it.
+func (cm *ConnectionManager) findFirstClassDiskInCatalogs(ctx context.Context, fcdID string) (*FcdDiscoveryInfo, map[string]bool) {
+	searched := make(map[string]bool)
+	for vc := range cm.VsphereInstanceMap {
+		if cm.IsDegraded(vc) || !cm.hasVslmCatalog(ctx, vc) {
+			continue
+		}
+		datacenters, err := cm.getDatacenters(ctx, vc)
+		if err != nil {
+			continue
+		}
+
+		fcds, ok, err := cm.CatalogFirstClassDisks(ctx, vc, datacenters, nil, vclib.VslmQueryByID(fcdID))
+		if err != nil {
+			klog.Warningf("Failed to find FCD %s in the vslm catalog of vc=%s, scanning its datastores. Err: %v",
+				fcdID, vc, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		searched[vc] = true
+		if len(fcds) > 0 {
+			klog.V(2).Infof("Found FCD %s in the vslm catalog of vc=%s", fcdID, vc)
+			return &FcdDiscoveryInfo{DataCenter: fcds[0].Datacenter, FCDInfo: fcds[0], VcServer: vc}, searched
+		}
+	}
+	return nil, searched
+}
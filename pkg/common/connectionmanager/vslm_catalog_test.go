@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connectionmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestVslmCatalogFallback(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(true)
+	defer cleanup()
+
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	// the simulator is older than 7.0, so its datastores are scanned
+	vc := config.Global.VCenterIP
+	catalog, err := connMgr.DetectVslmCatalog(ctx, vc)
+	if err != nil {
+		t.Fatalf("DetectVslmCatalog err=%v", err)
+	}
+	if catalog || connMgr.VslmCatalogDetected(vc) {
+		t.Fatalf("vc=%s should have no vslm catalog", vc)
+	}
+
+	items, err := connMgr.ListAllVCandDCPairs(ctx)
+	if err != nil {
+		t.Fatalf("ListAllVCandDCPairs err=%v", err)
+	}
+	dc := items[0].DataCenter
+	fcds, ok, err := connMgr.CatalogFirstClassDisks(ctx, vc, []*vclib.Datacenter{dc}, nil)
+	if err != nil || ok || fcds != nil {
+		t.Errorf("CatalogFirstClassDisks should not be served without a catalog: %v %v %v", fcds, ok, err)
+	}
+
+	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+	err = dc.CreateFirstClassDisk(ctx, myds.Name, vclib.TypeDatastore, "scanned", 1024)
+	if err != nil {
+		t.Fatalf("CreateFirstClassDisk err=%v", err)
+	}
+	fcd, err := dc.GetFirstClassDisk(ctx, myds.Name, vclib.TypeDatastore, "scanned", vclib.FindFCDByName)
+	if err != nil {
+		t.Fatalf("GetFirstClassDisk err=%v", err)
+	}
+	if _, err = connMgr.WhichVCandDCByFCDId(ctx, fcd.Config.Id.Id); err != nil {
+		t.Errorf("WhichVCandDCByFCDId should find the FCD by scanning: %v", err)
+	}
+}
@@ -19,18 +19,14 @@ package connectionmanager
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"strings"
 	"sync"
-	"time"
 
 	"k8s.io/klog"
 
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
-	"github.com/vmware/govmomi/vapi/rest"
-	"github.com/vmware/govmomi/vapi/tags"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 
@@ -82,41 +78,40 @@ func (cm *ConnectionManager) getDIFromSingleVC(ctx context.Context,
 	var vc string
 
 	// Get first vSphere Instance
-	var tmpVsi *VSphereInstance
-	for vc, tmpVsi = range cm.VsphereInstanceMap {
+	for vc = range cm.VsphereInstanceMap {
 		break //Grab the first one because there is only one
 	}
 
-	var err error
-	for i := 0; i < NumConnectionAttempts; i++ {
-		err = cm.Connect(ctx, vc)
-		if err == nil {
-			break
-		}
-		time.Sleep(time.Duration(RetryAttemptDelaySecs) * time.Second)
+	if err := cm.connectWithRetries(ctx, vc); err != nil {
+		return nil, err
 	}
 
-	numOfDc, err := vclib.GetNumberOfDatacenters(ctx, tmpVsi.Conn)
+	// Only the configured datacenters are considered
+	datacenterObjs, err := cm.listDatacenters(ctx, vc)
 	if err != nil {
+		klog.Errorf("listDatacenters failed. Err: %v", err)
+		return nil, err
+	}
+	if len(datacenterObjs) == 0 {
+		err = ErrMustHaveAtLeastOneVCDC
 		klog.Errorf("%v", err)
 		return nil, err
 	}
 
 	// More than 1 DC in this VC
-	if numOfDc > 1 {
+	if len(datacenterObjs) > 1 {
 		klog.Info("Multi Datacenter configuration detected")
+		if len(zoneLabel) == 0 || len(regionLabel) == 0 || len(zoneLooking) == 0 || len(regionLooking) == 0 {
+			err = ErrMultiDCRequiresZones
+			klog.Errorf("%v", err)
+			return nil, err
+		}
 		return cm.getDIFromMultiVCorDC(ctx, zoneLabel, regionLabel, zoneLooking, regionLooking)
 	}
 
 	// We are sure this is single VC and DC
 	klog.Info("Single vCenter/Datacenter configuration detected")
 
-	datacenterObjs, err := vclib.GetAllDatacenter(ctx, tmpVsi.Conn)
-	if err != nil {
-		klog.Error("GetAllDatacenter failed. Err:", err)
-		return nil, err
-	}
-
 	discoveryInfo := &ZoneDiscoveryInfo{
 		VcServer:   vc,
 		DataCenter: datacenterObjs[0],
@@ -175,6 +170,10 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCNonVM(ctx context.Context,
 
 	queueChannel = make(chan *zoneSearch, QueueSize)
 
+	// cancel the searches still in flight once one succeeds
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	zoneFound := false
 	globalErr = nil
 
@@ -198,80 +197,35 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCNonVM(ctx context.Context,
 	}
 
 	go func() {
-		for vc, vsi := range cm.VsphereInstanceMap {
-			var datacenterObjs []*vclib.Datacenter
-
-			found := getZoneFound()
-			if found == true {
-				break
-			}
-
-			var err error
-			for i := 0; i < NumConnectionAttempts; i++ {
-				err = cm.Connect(ctx, vc)
-				if err == nil {
-					break
-				}
-				time.Sleep(time.Duration(RetryAttemptDelaySecs) * time.Second)
-			}
+		err := cm.forEachDatacenter(ctx, func(vc string, datacenterObj *vclib.Datacenter) {
+			finder := find.NewFinder(datacenterObj.Client(), false)
+			finder.SetDatacenter(datacenterObj.Datacenter)
 
+			clusterList, err := finder.ClusterComputeResourceList(ctx, "*")
 			if err != nil {
-				klog.Error("getDIFromMultiVCorDCNonVM error vc:", err)
+				klog.Errorf("ClusterComputeResourceList failed in vc=%s and datacenter=%s: %v",
+					vc, datacenterObj.Name(), err)
 				setGlobalErr(err)
-				continue
-			}
-
-			if vsi.Cfg.Datacenters == "" {
-				datacenterObjs, err = vclib.GetAllDatacenter(ctx, vsi.Conn)
-				if err != nil {
-					klog.Error("getDIFromMultiVCorDCNonVM error dc:", err)
-					setGlobalErr(err)
-					continue
-				}
-			} else {
-				datacenters := strings.Split(vsi.Cfg.Datacenters, ",")
-				for _, dc := range datacenters {
-					dc = strings.TrimSpace(dc)
-					if dc == "" {
-						continue
-					}
-					datacenterObj, err := vclib.GetDatacenter(ctx, vsi.Conn, dc)
-					if err != nil {
-						klog.Error("getDIFromMultiVCorDCNonVM error dc:", err)
-						setGlobalErr(err)
-						continue
-					}
-					datacenterObjs = append(datacenterObjs, datacenterObj)
-				}
+				return
 			}
 
-			for _, datacenterObj := range datacenterObjs {
-				found := getZoneFound()
-				if found == true {
-					break
-				}
-
-				finder := find.NewFinder(datacenterObj.Client(), false)
-				finder.SetDatacenter(datacenterObj.Datacenter)
-
-				clusterList, err := finder.ClusterComputeResourceList(ctx, "*")
-				if err != nil {
-					klog.Errorf("ClusterComputeResourceList failed in vc=%s and datacenter=%s: %v",
-						vc, datacenterObj.Name(), err)
-					setGlobalErr(err)
-					continue
-				}
-
-				for _, cluster := range clusterList {
-					klog.V(3).Infof("Finding zone in vc=%s and datacenter=%s and cluster=%s",
-						vc, datacenterObj.Name(), cluster.Name())
-					queueChannel <- &zoneSearch{
-						vc:         vc,
-						datacenter: datacenterObj,
-						cluster:    cluster,
-					}
+			for _, cluster := range clusterList {
+				klog.V(3).Infof("Finding zone in vc=%s and datacenter=%s and cluster=%s",
+					vc, datacenterObj.Name(), cluster.Name())
+				select {
+				case queueChannel <- &zoneSearch{
+					vc:         vc,
+					datacenter: datacenterObj,
+					cluster:    cluster,
+				}:
+				case <-ctx.Done():
+					return
 				}
 			}
+		})
+		if err != nil {
+			klog.Error("getDIFromMultiVCorDCNonVM error:", err)
+			setGlobalErr(err)
 		}
 		close(queueChannel)
 	}()
@@ -281,11 +235,14 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCNonVM(ctx context.Context,
 		wg.Add(1)
 		go func() {
 			for res := range queueChannel {
+				if getZoneFound() {
+					continue
+				}
 
 				klog.V(3).Infof("Checking zones for cluster: %s", res.cluster.Name())
 				result, err := cm.LookupZoneByMoref(ctx, res.datacenter, res.cluster.Reference(), zoneLabel, regionLabel, true)
 				if err != nil {
-					klog.Errorf("Failed to find zone: %s and region: %s for cluster %s", zoneLabel, regionLabel, res.cluster.Name())
+					klog.Errorf("Failed to find zone: %s and region: %s for cluster %s: %v", zoneLabel, regionLabel, res.cluster.Name(), err)
 					continue
 				}
 
@@ -302,6 +259,7 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCNonVM(ctx context.Context,
 				}
 
 				setZoneFound(true)
+				cancel()
 				break
 			}
 			wg.Done()
@@ -311,6 +269,9 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCNonVM(ctx context.Context,
 	if zoneFound {
 		return zoneInfo, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if globalErr != nil {
 		return nil, *globalErr
 	}
@@ -343,6 +304,10 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCVM(ctx context.Context,
 
 	queueChannel = make(chan *zoneSearch, QueueSize)
 
+	// cancel the searches still in flight once one succeeds
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	zoneFound := false
 	globalErr = nil
 
@@ -366,77 +331,32 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCVM(ctx context.Context,
 	}
 
 	go func() {
-		for vc, vsi := range cm.VsphereInstanceMap {
-			var datacenterObjs []*vclib.Datacenter
-
-			found := getZoneFound()
-			if found == true {
-				break
-			}
-
-			var err error
-			for i := 0; i < NumConnectionAttempts; i++ {
-				err = cm.Connect(ctx, vc)
-				if err == nil {
-					break
-				}
-				time.Sleep(time.Duration(RetryAttemptDelaySecs) * time.Second)
-			}
+		err := cm.forEachDatacenter(ctx, func(vc string, datacenterObj *vclib.Datacenter) {
+			finder := find.NewFinder(datacenterObj.Client(), false)
+			finder.SetDatacenter(datacenterObj.Datacenter)
 
+			hostList, err := finder.HostSystemList(ctx, "*/*")
 			if err != nil {
-				klog.Error("getDIFromMultiVCorDCVM error vc:", err)
-				setGlobalErr(err)
-				continue
+				klog.Errorf("HostSystemList failed: %v", err)
+				return
 			}
 
-			if vsi.Cfg.Datacenters == "" {
-				datacenterObjs, err = vclib.GetAllDatacenter(ctx, vsi.Conn)
-				if err != nil {
-					klog.Error("getDIFromMultiVCorDCVM error dc:", err)
-					setGlobalErr(err)
-					continue
-				}
-			} else {
-				datacenters := strings.Split(vsi.Cfg.Datacenters, ",")
-				for _, dc := range datacenters {
-					dc = strings.TrimSpace(dc)
-					if dc == "" {
-						continue
-					}
-					datacenterObj, err := vclib.GetDatacenter(ctx, vsi.Conn, dc)
-					if err != nil {
-						klog.Error("getDIFromMultiVCorDCVM error dc:", err)
-						setGlobalErr(err)
-						continue
-					}
-					datacenterObjs = append(datacenterObjs, datacenterObj)
-				}
-			}
-
-			for _, datacenterObj := range datacenterObjs {
-				found := getZoneFound()
-				if found == true {
-					break
-				}
-
-				finder := find.NewFinder(datacenterObj.Client(), false)
-				finder.SetDatacenter(datacenterObj.Datacenter)
-
-				hostList, err := finder.HostSystemList(ctx, "*/*")
-				if err != nil {
-					klog.Errorf("HostSystemList failed: %v", err)
-					continue
-				}
-
-				for _, host := range hostList {
-					klog.V(3).Infof("Finding zone in vc=%s and datacenter=%s for host: %s", vc, datacenterObj.Name(), host.Name())
-					queueChannel <- &zoneSearch{
-						vc:         vc,
-						datacenter: datacenterObj,
-						host:       host,
-					}
+			for _, host := range hostList {
+				klog.V(3).Infof("Finding zone in vc=%s and datacenter=%s for host: %s", vc, datacenterObj.Name(), host.Name())
+				select {
+				case queueChannel <- &zoneSearch{
+					vc:         vc,
+					datacenter: datacenterObj,
+					host:       host,
+				}:
+				case <-ctx.Done():
+					return
 				}
 			}
+		})
+		if err != nil {
+			klog.Error("getDIFromMultiVCorDCVM error:", err)
+			setGlobalErr(err)
 		}
 		close(queueChannel)
 	}()
@@ -446,11 +366,14 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCVM(ctx context.Context,
 		wg.Add(1)
 		go func() {
 			for res := range queueChannel {
+				if getZoneFound() {
+					continue
+				}
 
 				klog.V(3).Infof("Checking zones for host: %s", res.host.Name())
-				result, err := cm.LookupZoneByMoref(ctx, res.datacenter, res.host.Reference(), zoneLabel, regionLabel, false)
-				if err == nil {
-					klog.Errorf("Failed to find zone: %s and region: %s for host %s", zoneLabel, regionLabel, res.host.Name())
+				result, err := cm.LookupZoneByMoref(ctx, res.datacenter, res.host.Reference(), zoneLabel, regionLabel, true)
+				if err != nil {
+					klog.Errorf("Failed to find zone: %s and region: %s for host %s: %v", zoneLabel, regionLabel, res.host.Name(), err)
 					continue
 				}
 
@@ -467,6 +390,7 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCVM(ctx context.Context,
 				}
 
 				setZoneFound(true)
+				cancel()
 				break
 			}
 			wg.Done()
@@ -476,6 +400,9 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCVM(ctx context.Context,
 	if zoneFound {
 		return zoneInfo, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if globalErr != nil {
 		return nil, *globalErr
 	}
@@ -484,16 +411,6 @@ func (cm *ConnectionManager) getDIFromMultiVCorDCVM(ctx context.Context,
 	return nil, vclib.ErrNoZoneRegionFound
 }
 
-func withTagsClient(ctx context.Context, connection *vclib.VSphereConnection, f func(c *rest.Client) error) error {
-	c := rest.NewClient(connection.Client)
-	user := url.UserPassword(connection.Username, connection.Password)
-	if err := c.Login(ctx, user); err != nil {
-		return err
-	}
-	defer c.Logout(ctx)
-	return f(c)
-}
-
 func removePortFromHost(host string) string {
 	result := host
 	index := strings.IndexAny(host, ":")
@@ -504,11 +421,13 @@ func removePortFromHost(host string) string {
 }
 
 // LookupZoneByMoref searches for a zone using the provided managed object reference.
+// The tags attached to the object are searched first, then the ones attached to
+// its ancestors, such as its cluster, datacenter and folders, when checkAncestors
+// is true. The tag lookups are cached for TagCacheTTL.
 func (cm *ConnectionManager) LookupZoneByMoref(ctx context.Context, dataCenter *vclib.Datacenter,
 	moRef types.ManagedObjectReference, zoneLabel string, regionLabel string, checkAncestors bool) (map[string]string, error) {
 
 	vcServer := removePortFromHost(dataCenter.Client().URL().Host)
-	result := make(map[string]string, 0)
 
 	vsi := cm.VsphereInstanceMap[vcServer]
 	if vsi == nil {
@@ -517,86 +436,145 @@ func (cm *ConnectionManager) LookupZoneByMoref(ctx context.Context, dataCenter *
 		return nil, err
 	}
 
-	err := withTagsClient(ctx, vsi.Conn, func(c *rest.Client) error {
-		client := tags.NewManager(c)
+	resolver := cm.newTagResolver(vcServer, vsi.Conn)
+	defer resolver.close(ctx)
 
-		var err error
-		var objects []mo.ManagedEntity
-		if checkAncestors {
-			pc := dataCenter.Client().ServiceContent.PropertyCollector
-			// example result: ["Folder", "Datacenter", "Cluster", "Host"]
-			objects, err = mo.Ancestors(ctx, dataCenter.Client(), pc, moRef)
-			if err != nil {
-				klog.Errorf("Ancestors failed for %s with err %v", moRef, err)
-				return err
-			}
-		} else {
-			var moHost mo.HostSystem
-			pc := property.DefaultCollector(dataCenter.Client())
-			err = pc.RetrieveOne(ctx, moRef, []string{"summary"}, &moHost)
-			if err != nil {
-				klog.Errorf("RetrieveOne failed for %s with err %v", moRef, err)
-				return err
-			}
-			objects = make([]mo.ManagedEntity, 0)
-			objects = append(objects, moHost.ManagedEntity)
-		}
+	result, err := lookupZone(ctx, resolver, dataCenter, moRef, zoneLabel, regionLabel, checkAncestors)
+	if err != nil {
+		klog.Errorf("Get zone for mo: %s: %s", moRef, err)
+		return nil, err
+	}
+	return result, nil
+}
 
-		// search the hierarchy, example order: ["Host", "Cluster", "Datacenter", "Folder"]
-		for i := range objects {
-			obj := objects[len(objects)-1-i]
-			klog.V(4).Infof("Name: %s, Type: %s", obj.Self.Value, obj.Self.Type)
-			tags, err := client.ListAttachedTags(ctx, obj)
-			if err != nil {
-				klog.Errorf("Cannot list attached tags. Err: %v", err)
-				return err
-			}
-			for _, value := range tags {
-				tag, err := client.GetTag(ctx, value)
-				if err != nil {
-					klog.Errorf("Zones Get tag %s: %s", value, err)
-					return err
-				}
-				category, err := client.GetCategory(ctx, tag.CategoryID)
-				if err != nil {
-					klog.Errorf("Zones Get category %s error", value)
-					return err
-				}
+// lookupZone searches for the zone and region of an object with the tag
+// resolver. The closest tag of each category wins.
+func lookupZone(ctx context.Context, resolver *tagResolver, dataCenter *vclib.Datacenter,
+	moRef types.ManagedObjectReference, zoneLabel string, regionLabel string, checkAncestors bool) (map[string]string, error) {
 
-				found := func() {
-					klog.V(2).Infof("Found %s tag (%s) attached to %s", category.Name, tag.Name, moRef)
-				}
-				switch {
-				case category.Name == zoneLabel:
-					result[ZoneLabel] = tag.Name
-					found()
-				case category.Name == regionLabel:
-					result[RegionLabel] = tag.Name
-					found()
-				}
+	var err error
+	var objects []mo.ManagedEntity
+	if checkAncestors {
+		pc := dataCenter.Client().ServiceContent.PropertyCollector
+		// example result: ["Folder", "Datacenter", "Cluster", "Host"]
+		objects, err = mo.Ancestors(ctx, dataCenter.Client(), pc, moRef)
+		if err != nil {
+			klog.Errorf("Ancestors failed for %s with err %v", moRef, err)
+			return nil, err
+		}
+	} else {
+		var entity mo.ManagedEntity
+		pc := property.DefaultCollector(dataCenter.Client())
+		err = pc.RetrieveOne(ctx, moRef, []string{"name"}, &entity)
+		if err != nil {
+			klog.Errorf("RetrieveOne failed for %s with err %v", moRef, err)
+			return nil, err
+		}
+		objects = []mo.ManagedEntity{entity}
+	}
 
-				if result[ZoneLabel] != "" && result[RegionLabel] != "" {
-					return nil
-				}
+	result := make(map[string]string)
+
+	// search the hierarchy, example order: ["Host", "Cluster", "Datacenter", "Folder"]
+	for i := range objects {
+		obj := objects[len(objects)-1-i]
+		klog.V(4).Infof("Name: %s, Type: %s", obj.Self.Value, obj.Self.Type)
+		attached, err := resolver.attachedTags(ctx, obj.Self)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range attached {
+			var label string
+			switch tag.category {
+			case zoneLabel:
+				label = ZoneLabel
+			case regionLabel:
+				label = RegionLabel
+			default:
+				continue
+			}
+			if result[label] == "" {
+				klog.V(2).Infof("Found %s tag (%s) attached to %s", tag.category, tag.name, obj.Self)
+				result[label] = tag.name
 			}
 		}
 
-		if result[RegionLabel] == "" {
-			if regionLabel != "" {
-				return fmt.Errorf("vSphere region category %s does not match any tags for mo: %v", regionLabel, moRef)
-			}
+		if result[ZoneLabel] != "" && result[RegionLabel] != "" {
+			return result, nil
 		}
-		if result[ZoneLabel] == "" {
-			if zoneLabel != "" {
-				return fmt.Errorf("vSphere zone category %s does not match any tags for mo: %v", zoneLabel, moRef)
-			}
+	}
+
+	// name the object and its ancestors that are missing the tags
+	object := moRef.String()
+	if len(objects) > 0 {
+		object = describeEntity(objects[len(objects)-1])
+	}
+	if checkAncestors && len(objects) > 1 {
+		ancestors := make([]string, 0, len(objects)-1)
+		for i := len(objects) - 2; i >= 0; i-- {
+			ancestors = append(ancestors, describeEntity(objects[i]))
 		}
+		object += " or to any of its ancestors " + strings.Join(ancestors, ", ")
+	} else if checkAncestors {
+		object += " or to any of its ancestors"
+	}
+	if result[RegionLabel] == "" && regionLabel != "" {
+		return nil, fmt.Errorf("No tag of the vSphere region category %s is attached to %s", regionLabel, object)
+	}
+	if result[ZoneLabel] == "" && zoneLabel != "" {
+		return nil, fmt.Errorf("No tag of the vSphere zone category %s is attached to %s", zoneLabel, object)
+	}
+
+	return result, nil
+}
+
+// describeEntity returns the type, name and managed object ID of an entity
+// for error messages.
+func describeEntity(entity mo.ManagedEntity) string {
+	return fmt.Sprintf("%s %q (%s)", entity.Self.Type, entity.Name, entity.Self.Value)
+}
+
+// LookupHostZones returns the zone and region of each host of the datacenter,
+// resolved from the tags attached to the host or to its ancestors, such as its
+// cluster and the datacenter. The hosts that are in no zone are skipped.
+func (cm *ConnectionManager) LookupHostZones(ctx context.Context, dataCenter *vclib.Datacenter,
+	zoneLabel string, regionLabel string) ([]HostZone, error) {
+	klog.V(4).Infof("LookupHostZones called with datacenter: %s", dataCenter.Name())
+
+	vcServer := removePortFromHost(dataCenter.Client().URL().Host)
 
-		return nil
-	})
+	vsi := cm.VsphereInstanceMap[vcServer]
+	if vsi == nil {
+		err := ErrConnectionNotFound
+		klog.Errorf("Unable to find Connection for %s", vcServer)
+		return nil, err
+	}
+
+	finder := find.NewFinder(dataCenter.Client(), false)
+	finder.SetDatacenter(dataCenter.Datacenter)
+
+	hostList, err := finder.HostSystemList(ctx, "*/*")
 	if err != nil {
-		klog.Errorf("Get zone for mo: %s: %s", moRef, err)
+		klog.Errorf("HostSystemList failed in datacenter=%s: %v", dataCenter.Name(), err)
 		return nil, err
 	}
-	return result, nil
+
+	resolver := cm.newTagResolver(vcServer, vsi.Conn)
+	defer resolver.close(ctx)
+
+	hostZones := make([]HostZone, 0, len(hostList))
+	for _, host := range hostList {
+		result, err := lookupZone(ctx, resolver, dataCenter, host.Reference(), zoneLabel, regionLabel, true)
+		if err != nil {
+			klog.Warningf("Host %s is in no zone: %v", host.Name(), err)
+			continue
+		}
+		hostZones = append(hostZones, HostZone{
+			Host:   host.Reference(),
+			Zone:   result[ZoneLabel],
+			Region: result[RegionLabel],
+		})
+	}
+
+	return hostZones, nil
 }
@@ -18,6 +18,7 @@ package connectionmanager
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"strings"
 	"testing"
@@ -173,6 +174,45 @@ func TestWhichVCandDCByZoneMultiDC(t *testing.T) {
 	}
 }
 
+func TestWhichVCandDCByZoneConfiguredDC(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(true)
+	defer cleanup()
+
+	// context
+	ctx := context.Background()
+
+	// Only DC1 of the multi-DC vCenter is configured
+	config.VirtualCenter[config.Global.VCenterIP].Datacenters = "DC1"
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	zoneInfo, err := connMgr.WhichVCandDCByZone(ctx, config.Labels.Zone, config.Labels.Region, "", "")
+	if err != nil {
+		t.Fatalf("WhichVCandDCByZone failed err=%v", err)
+	}
+	if !strings.EqualFold("DC1", zoneInfo.DataCenter.Name()) {
+		t.Errorf("Datacenter mismatch DC1 != %s", zoneInfo.DataCenter.Name())
+	}
+
+	// Both datacenters are configured, a zone is required to pick one
+	config.VirtualCenter[config.Global.VCenterIP].Datacenters = "DC1,DC0"
+	connMgr = NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	_, err = connMgr.WhichVCandDCByZone(ctx, config.Labels.Zone, config.Labels.Region, "", "")
+	if err != ErrMultiDCRequiresZones {
+		t.Errorf("WhichVCandDCByZone should fail with %v: %v", ErrMultiDCRequiresZones, err)
+	}
+
+	datacenters, err := connMgr.ListDatacenters(ctx, config.Global.VCenterIP)
+	if err != nil {
+		t.Fatalf("ListDatacenters failed err=%v", err)
+	}
+	if len(datacenters) != 2 || datacenters[0].Name() != "DC1" || datacenters[1].Name() != "DC0" {
+		t.Errorf("ListDatacenters should return DC1 and DC0 in order: %v", datacenters)
+	}
+}
+
 func TestLookupZoneByMoref(t *testing.T) {
 	config, cleanup := configFromEnvOrSim(false)
 	defer cleanup()
@@ -308,3 +348,110 @@ func TestLookupZoneByMoref(t *testing.T) {
 		t.Errorf("Region value mismatch k8s-zone-US-east != %s", zone)
 	}
 }
+
+func TestLookupZoneMissingTag(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(false)
+	defer cleanup()
+
+	connMgr := NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	// context
+	ctx := context.Background()
+
+	err := connMgr.Connect(ctx, config.Global.VCenterIP)
+	if err != nil {
+		t.Errorf("Failed to Connect to vSphere: %s", err)
+	}
+
+	// Get the vSphere Instance
+	vsi := connMgr.VsphereInstanceMap[config.Global.VCenterIP]
+
+	// Tag manager instance
+	restClient := rest.NewClient(vsi.Conn.Client)
+	user := url.UserPassword(vsi.Conn.Username, vsi.Conn.Password)
+	if err := restClient.Login(ctx, user); err != nil {
+		t.Fatalf("Rest login failed. err=%v", err)
+	}
+
+	m := tags.NewManager(restClient)
+
+	/*
+	 * START SETUP
+	 */
+	// Get a simulator Host
+	myHost := simulator.Map.Any("HostSystem").(*simulator.HostSystem)
+
+	regionID, err := m.CreateCategory(ctx, &tags.Category{Name: config.Labels.Region})
+	if err != nil {
+		t.Fatal(err)
+	}
+	regionID, err = m.CreateTag(ctx, &tags.Tag{CategoryID: regionID, Name: "k8s-region-US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneID, err := m.CreateCategory(ctx, &tags.Category{Name: config.Labels.Zone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneID, err = m.CreateTag(ctx, &tags.Tag{CategoryID: zoneID, Name: "k8s-zone-US-east"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc0, err := vclib.GetDatacenter(ctx, vsi.Conn, "DC0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the region is attached to DC0
+	if err = m.AttachTag(ctx, regionID, dc0); err != nil {
+		t.Fatal(err)
+	}
+	/*
+	 * END SETUP
+	 */
+
+	// The error names the host, its untagged ancestors and the missing category
+	_, err = connMgr.LookupZoneByMoref(ctx, dc0, myHost.Reference(), config.Labels.Zone, config.Labels.Region, true)
+	if err == nil {
+		t.Fatal("[MISSING] LookupZoneByMoref should fail without a zone tag")
+	}
+	for _, s := range []string{config.Labels.Zone, "HostSystem", myHost.Name, myHost.Reference().Value,
+		myHost.Parent.Type, fmt.Sprintf("Datacenter %q (%s)", dc0.Name(), dc0.Reference().Value)} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("[MISSING] The error should mention %s: %v", s, err)
+		}
+	}
+
+	// The tags attached to the host are cached
+	if err = m.AttachTag(ctx, zoneID, myHost); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = connMgr.LookupZoneByMoref(ctx, dc0, myHost.Reference(), config.Labels.Zone, config.Labels.Region, true); err == nil {
+		t.Error("[CACHED] LookupZoneByMoref should use the cached tags")
+	}
+
+	ttl := TagCacheTTL
+	TagCacheTTL = 0
+	defer func() { TagCacheTTL = ttl }()
+	connMgr.tagCache = tagCache{}
+
+	kv, err := connMgr.LookupZoneByMoref(ctx, dc0, myHost.Reference(), config.Labels.Zone, config.Labels.Region, true)
+	if err != nil {
+		t.Fatalf("[EXPIRED] LookupZoneByMoref failed err=%v", err)
+	}
+	if kv[ZoneLabel] != "k8s-zone-US-east" || kv[RegionLabel] != "k8s-region-US" {
+		t.Errorf("[EXPIRED] Zone mismatch: %v", kv)
+	}
+
+	// Only the tagged host is in a zone
+	hostZones, err := connMgr.LookupHostZones(ctx, dc0, config.Labels.Zone, config.Labels.Region)
+	if err != nil {
+		t.Fatalf("LookupHostZones failed err=%v", err)
+	}
+	if len(hostZones) != 1 || hostZones[0].Host != myHost.Reference() ||
+		hostZones[0].Zone != "k8s-zone-US-east" || hostZones[0].Region != "k8s-region-US" {
+		t.Errorf("LookupHostZones should only return host %s: %v", myHost.Name, hostZones)
+	}
+}
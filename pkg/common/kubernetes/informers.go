@@ -22,6 +22,7 @@ import (
 	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
 	listerv1 "k8s.io/client-go/listers/core/v1"
+	storagelisterv1beta1 "k8s.io/client-go/listers/storage/v1beta1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/sample-controller/pkg/signals"
 )
@@ -48,6 +49,106 @@ func (im *InformerManager) GetSecretListener() listerv1.SecretLister {
 	return im.secretInformer.Lister()
 }
 
+// GetPersistentVolumeLister creates a lister of the persistent volumes. It
+// must be called before Listen.
+func (im *InformerManager) GetPersistentVolumeLister() listerv1.PersistentVolumeLister {
+	if im.pvInformer == nil {
+		im.pvInformer = im.informerFactory.Core().V1().PersistentVolumes()
+	}
+
+	return im.pvInformer.Lister()
+}
+
+// PersistentVolumesSynced returns whether the persistent volume informer
+// has synced, so that the persistent volume lister is complete.
+func (im *InformerManager) PersistentVolumesSynced() bool {
+	if im.pvInformer == nil {
+		return false
+	}
+	return im.pvInformer.Informer().HasSynced()
+}
+
+// GetPersistentVolumeClaimLister creates a lister of the persistent volume
+// claims. It must be called before Listen.
+func (im *InformerManager) GetPersistentVolumeClaimLister() listerv1.PersistentVolumeClaimLister {
+	if im.pvcInformer == nil {
+		im.pvcInformer = im.informerFactory.Core().V1().PersistentVolumeClaims()
+	}
+
+	return im.pvcInformer.Lister()
+}
+
+// GetPodLister creates a lister of the pods. It must be called before
+// Listen.
+func (im *InformerManager) GetPodLister() listerv1.PodLister {
+	if im.podInformer == nil {
+		im.podInformer = im.informerFactory.Core().V1().Pods()
+	}
+
+	return im.podInformer.Lister()
+}
+
+// PodsAndClaimsSynced returns whether the persistent volume, persistent
+// volume claim and pod informers have synced, so that their listers are
+// complete.
+func (im *InformerManager) PodsAndClaimsSynced() bool {
+	if im.pvcInformer == nil || im.podInformer == nil {
+		return false
+	}
+	return im.PersistentVolumesSynced() && im.pvcInformer.Informer().HasSynced() &&
+		im.podInformer.Informer().HasSynced()
+}
+
+// GetVolumeAttachmentLister creates a lister of the volume attachments. It
+// must be called before Listen.
+func (im *InformerManager) GetVolumeAttachmentLister() storagelisterv1beta1.VolumeAttachmentLister {
+	if im.vaInformer == nil {
+		im.vaInformer = im.informerFactory.Storage().V1beta1().VolumeAttachments()
+	}
+
+	return im.vaInformer.Lister()
+}
+
+// VolumeAttachmentsSynced returns whether the persistent volume and volume
+// attachment informers have synced, so that their listers are complete.
+func (im *InformerManager) VolumeAttachmentsSynced() bool {
+	if im.vaInformer == nil {
+		return false
+	}
+	return im.PersistentVolumesSynced() && im.vaInformer.Informer().HasSynced()
+}
+
+// GetConfigMapLister creates a lister of the config maps of a namespace,
+// which are the only ones watched. It must be called before Listen, and
+// only for one namespace.
+func (im *InformerManager) GetConfigMapLister(namespace string) listerv1.ConfigMapNamespaceLister {
+	if im.configMapInformer == nil {
+		im.configMapFactory = informers.NewFilteredSharedInformerFactory(im.client, noResyncPeriodFunc(), namespace, nil)
+		im.configMapInformer = im.configMapFactory.Core().V1().ConfigMaps()
+	}
+
+	return im.configMapInformer.Lister().ConfigMaps(namespace)
+}
+
+// ConfigMapsSynced returns whether the config map informer has synced, so
+// that the config map lister is complete.
+func (im *InformerManager) ConfigMapsSynced() bool {
+	if im.configMapInformer == nil {
+		return false
+	}
+	return im.configMapInformer.Informer().HasSynced()
+}
+
+// GetNodeLister creates a lister of the nodes. It must be called before
+// Listen.
+func (im *InformerManager) GetNodeLister() listerv1.NodeLister {
+	if im.nodeInformer == nil {
+		im.nodeInformer = im.informerFactory.Core().V1().Nodes().Informer()
+	}
+
+	return im.informerFactory.Core().V1().Nodes().Lister()
+}
+
 // AddNodeListener hooks up add, update, delete callbacks
 func (im *InformerManager) AddNodeListener(add, remove func(obj interface{}), update func(oldObj, newObj interface{})) {
 	if im.nodeInformer == nil {
@@ -61,7 +162,32 @@ func (im *InformerManager) AddNodeListener(add, remove func(obj interface{}), up
 	})
 }
 
+// AddSecretListener hooks up add, update, delete callbacks
+func (im *InformerManager) AddSecretListener(add, remove func(obj interface{}), update func(oldObj, newObj interface{})) {
+	if im.secretInformer == nil {
+		im.secretInformer = im.informerFactory.Core().V1().Secrets()
+	}
+
+	im.secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    add,
+		UpdateFunc: update,
+		DeleteFunc: remove,
+	})
+}
+
+// WaitForSecretsSynced waits until the secret informer has synced, so that
+// the secret lister can be used. It returns false when stopped first.
+func (im *InformerManager) WaitForSecretsSynced() bool {
+	if im.secretInformer == nil {
+		return true
+	}
+	return cache.WaitForCacheSync(im.stopCh, im.secretInformer.Informer().HasSynced)
+}
+
 // Listen starts the Informers
 func (im *InformerManager) Listen() {
 	go im.informerFactory.Start(im.stopCh)
+	if im.configMapFactory != nil {
+		go im.configMapFactory.Start(im.stopCh)
+	}
 }
@@ -26,6 +26,24 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// serviceAccountTokenPath is where the token of the service account of a
+// pod is mounted.
+var serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// HasClientConfig returns true when NewClient has a configuration to create
+// a client from: a kubeconfig set with EnvKubeConfig, or the in-cluster
+// configuration of a pod with a service account token.
+func HasClientConfig() bool {
+	if os.Getenv(EnvKubeConfig) != "" {
+		return true
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" || os.Getenv("KUBERNETES_SERVICE_PORT") == "" {
+		return false
+	}
+	_, err := os.Stat(serviceAccountTokenPath)
+	return err == nil
+}
+
 // NewClient creates a newk8s client based on a service account
 func NewClient(name string) (clientset.Interface, error) {
 	kubecfgPath := os.Getenv(EnvKubeConfig)
@@ -19,6 +19,7 @@ package kubernetes
 import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/informers/core/v1"
+	storagev1beta1 "k8s.io/client-go/informers/storage/v1beta1"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
@@ -38,4 +39,21 @@ type InformerManager struct {
 
 	// node informer
 	nodeInformer cache.SharedInformer
+
+	// persistent volume informer
+	pvInformer v1.PersistentVolumeInformer
+
+	// persistent volume claim informer
+	pvcInformer v1.PersistentVolumeClaimInformer
+
+	// pod informer
+	podInformer v1.PodInformer
+
+	// volume attachment informer
+	vaInformer storagev1beta1.VolumeAttachmentInformer
+
+	// informer factory of the config maps of a single namespace
+	configMapFactory informers.SharedInformerFactory
+	// config map informer
+	configMapInformer v1.ConfigMapInformer
 }
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Capability is a feature of vCenter that is only available from an API
+// version on.
+type Capability string
+
+const (
+	// CapabilityFCD is the management of the FCDs.
+	CapabilityFCD Capability = "fcd"
+	// CapabilityFCDSnapshots is the snapshots of the FCDs.
+	CapabilityFCDSnapshots Capability = "fcd-snapshots"
+	// CapabilityCns is the CNS metadata of the FCDs.
+	CapabilityCns Capability = "cns"
+	// CapabilityVslmCatalog is the global catalog of the FCDs.
+	CapabilityVslmCatalog Capability = "vslm-catalog"
+	// CapabilityOnlineExtend is the extension of the FCDs attached to a VM.
+	CapabilityOnlineExtend Capability = "online-extend"
+)
+
+// capabilityMinAPIVersions is the first vCenter API version of each
+// capability.
+var capabilityMinAPIVersions = map[Capability]string{
+	CapabilityFCD:          FCDMinAPIVersion,
+	CapabilityFCDSnapshots: FCDSnapshotsMinAPIVersion,
+	CapabilityCns:          CnsMinAPIVersion,
+	CapabilityVslmCatalog:  VslmCatalogMinAPIVersion,
+	CapabilityOnlineExtend: OnlineExtendMinAPIVersion,
+}
+
+// MinAPIVersion returns the first vCenter API version of the capability.
+func (c Capability) MinAPIVersion() string {
+	return capabilityMinAPIVersions[c]
+}
+
+// Requirement returns the vSphere release the capability requires, such as
+// "vSphere 6.7U3", for error messages.
+func (c Capability) Requirement() string {
+	return "vSphere " + ReleaseName(c.MinAPIVersion())
+}
+
+// ReleaseName returns the name of the vSphere release of an API version,
+// such as "6.7U3" for "6.7.3" or "7.0" for "7.0.0.0".
+func ReleaseName(apiVersion string) string {
+	items := strings.Split(apiVersion, ".")
+	if len(items) < 2 {
+		return apiVersion
+	}
+	name := items[0] + "." + items[1]
+	if len(items) > 2 && items[2] != "0" {
+		name += "U" + items[2]
+	}
+	return name
+}
+
+// Capabilities is the set of the capabilities of a vCenter. The zero
+// value has none.
+type Capabilities map[Capability]bool
+
+// CapabilitiesOf returns the capabilities of a vCenter API version.
+func CapabilitiesOf(apiVersion string) Capabilities {
+	capabilities := make(Capabilities)
+	for capability, minVersion := range capabilityMinAPIVersions {
+		if apiVersionAtLeast(apiVersion, minVersion) {
+			capabilities[capability] = true
+		}
+	}
+	return capabilities
+}
+
+// AllCapabilities returns all of the capabilities, whatever the API version.
+func AllCapabilities() Capabilities {
+	capabilities := make(Capabilities)
+	for capability := range capabilityMinAPIVersions {
+		capabilities[capability] = true
+	}
+	return capabilities
+}
+
+// Has returns true when the capability is in the set.
+func (c Capabilities) Has(capability Capability) bool {
+	return c[capability]
+}
+
+// Names returns the sorted names of the capabilities of the set.
+func (c Capabilities) Names() []string {
+	names := make([]string, 0, len(c))
+	for capability, ok := range c {
+		if ok {
+			names = append(names, string(capability))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the names of the capabilities of the set.
+func (c Capabilities) String() string {
+	return fmt.Sprintf("[%s]", strings.Join(c.Names(), " "))
+}
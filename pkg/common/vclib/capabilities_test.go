@@ -0,0 +1,59 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCapabilitiesOf(t *testing.T) {
+	for version, expected := range map[string][]string{
+		"6.0":     {},
+		"6.5":     {"fcd"},
+		"6.7.2":   {"fcd"},
+		"6.7.3":   {"cns", "fcd", "fcd-snapshots"},
+		"7.0.0.0": {"cns", "fcd", "fcd-snapshots", "vslm-catalog"},
+		"7.0.2.0": {"cns", "fcd", "fcd-snapshots", "online-extend", "vslm-catalog"},
+		"invalid": {},
+	} {
+		if names := CapabilitiesOf(version).Names(); !reflect.DeepEqual(names, expected) {
+			t.Errorf("CapabilitiesOf(%q) should return %v, got %v", version, expected, names)
+		}
+	}
+
+	if !AllCapabilities().Has(CapabilityOnlineExtend) {
+		t.Error("AllCapabilities() should have every capability")
+	}
+	var none Capabilities
+	if none.Has(CapabilityFCD) {
+		t.Error("The zero Capabilities should have no capability")
+	}
+}
+
+func TestCapabilityRequirement(t *testing.T) {
+	for capability, requirement := range map[Capability]string{
+		CapabilityFCD:          "vSphere 6.5",
+		CapabilityFCDSnapshots: "vSphere 6.7U3",
+		CapabilityVslmCatalog:  "vSphere 7.0",
+		CapabilityOnlineExtend: "vSphere 7.0U2",
+	} {
+		if capability.Requirement() != requirement {
+			t.Errorf("%s should require %s, got %s", capability, requirement, capability.Requirement())
+		}
+	}
+}
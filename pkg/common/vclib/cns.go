@@ -0,0 +1,286 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// The Cloud Native Storage (CNS) of vSphere 6.7U3 and later is served by
+// the vSAN management endpoint of vCenter, which govmomi has no bindings
+// for. The types and methods below are the subset of the CNS API needed to
+// describe the FCDs to the vSphere Client.
+const (
+	// CnsMinAPIVersion is the first vCenter API version that serves CNS.
+	CnsMinAPIVersion = "6.7.3"
+
+	// CnsClusterTypeKubernetes is the type of the Kubernetes clusters.
+	CnsClusterTypeKubernetes = "KUBERNETES"
+	// CnsVolumeTypeBlock is the type of the volumes backed by an FCD.
+	CnsVolumeTypeBlock = "BLOCK"
+
+	// CnsEntityTypePV is the type of the metadata of a persistent volume.
+	CnsEntityTypePV = "PERSISTENT_VOLUME"
+	// CnsEntityTypePVC is the type of the metadata of a persistent volume
+	// claim.
+	CnsEntityTypePVC = "PERSISTENT_VOLUME_CLAIM"
+	// CnsEntityTypePod is the type of the metadata of a pod.
+	CnsEntityTypePod = "POD"
+)
+
+var cnsVolumeManager = types.ManagedObjectReference{
+	Type:  "CnsVolumeManager",
+	Value: "cns-volume-manager",
+}
+
+func init() {
+	// the tasks of CNS return their results in a type vim25 does not know,
+	// which vCenter qualifies with the vsan namespace
+	result := reflect.TypeOf((*CnsVolumeOperationBatchResult)(nil)).Elem()
+	types.Add("CnsVolumeOperationBatchResult", result)
+	types.Add(vsanNamespace+":CnsVolumeOperationBatchResult", result)
+}
+
+// IsCnsSupported returns true when a vCenter API version serves CNS.
+func IsCnsSupported(apiVersion string) bool {
+	return apiVersionAtLeast(apiVersion, CnsMinAPIVersion)
+}
+
+// CnsVolumeID is the ID of a CNS volume, the ID of its FCD.
+type CnsVolumeID struct {
+	ID string `xml:"id"`
+}
+
+// CnsContainerCluster is the cluster the metadata of a volume belongs to.
+type CnsContainerCluster struct {
+	ClusterType string `xml:"clusterType"`
+	ClusterID   string `xml:"clusterId"`
+	VSphereUser string `xml:"vSphereUser"`
+}
+
+// CnsKubernetesEntityReference refers to the Kubernetes object an entity
+// uses, e.g. to the PVC of a pod.
+type CnsKubernetesEntityReference struct {
+	EntityType string `xml:"entityType"`
+	EntityName string `xml:"entityName"`
+	Namespace  string `xml:"namespace,omitempty"`
+}
+
+// CnsKubernetesEntityMetadata describes a Kubernetes object using a
+// volume. The entity is removed from the volume when Delete is true.
+type CnsKubernetesEntityMetadata struct {
+	EntityName     string                         `xml:"entityName"`
+	Labels         []types.KeyValue               `xml:"labels,omitempty"`
+	Delete         bool                           `xml:"delete,omitempty"`
+	EntityType     string                         `xml:"entityType"`
+	Namespace      string                         `xml:"namespace,omitempty"`
+	ReferredEntity []CnsKubernetesEntityReference `xml:"referredEntity,omitempty"`
+}
+
+// CnsVolumeMetadata is the metadata of a volume.
+type CnsVolumeMetadata struct {
+	ContainerCluster CnsContainerCluster           `xml:"containerCluster"`
+	EntityMetadata   []CnsKubernetesEntityMetadata `xml:"entityMetadata,omitempty,typeattr"`
+}
+
+// CnsBlockBackingDetails is the FCD backing a block volume.
+type CnsBlockBackingDetails struct {
+	BackingDiskID string `xml:"backingDiskId"`
+}
+
+type cnsVolumeCreateSpec struct {
+	Name                 string                         `xml:"name"`
+	VolumeType           string                         `xml:"volumeType"`
+	Datastores           []types.ManagedObjectReference `xml:"datastores,omitempty"`
+	Metadata             CnsVolumeMetadata              `xml:"metadata"`
+	BackingObjectDetails *CnsBlockBackingDetails        `xml:"backingObjectDetails,typeattr"`
+}
+
+type cnsVolumeMetadataUpdateSpec struct {
+	VolumeID CnsVolumeID       `xml:"volumeId"`
+	Metadata CnsVolumeMetadata `xml:"metadata"`
+}
+
+// CnsVolumeOperationResult is the result of an operation on a volume.
+type CnsVolumeOperationResult struct {
+	VolumeID *CnsVolumeID                `xml:"volumeId,omitempty"`
+	Fault    *types.LocalizedMethodFault `xml:"fault,omitempty"`
+}
+
+// CnsVolumeOperationBatchResult is the result of a task of CNS, with one
+// result per volume.
+type CnsVolumeOperationBatchResult struct {
+	VolumeResults []CnsVolumeOperationResult `xml:"volumeResults,omitempty"`
+}
+
+type cnsCreateVolume struct {
+	This        types.ManagedObjectReference `xml:"_this"`
+	CreateSpecs []cnsVolumeCreateSpec        `xml:"createSpecs"`
+}
+
+type cnsCreateVolumeResponse struct {
+	Returnval types.ManagedObjectReference `xml:"returnval"`
+}
+
+type cnsCreateVolumeBody struct {
+	Req    *cnsCreateVolume         `xml:"urn:vsan CnsCreateVolume,omitempty"`
+	Res    *cnsCreateVolumeResponse `xml:"urn:vsan CnsCreateVolumeResponse,omitempty"`
+	Fault_ *soap.Fault              `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *cnsCreateVolumeBody) Fault() *soap.Fault { return b.Fault_ }
+
+type cnsUpdateVolumeMetadata struct {
+	This        types.ManagedObjectReference  `xml:"_this"`
+	UpdateSpecs []cnsVolumeMetadataUpdateSpec `xml:"updateSpecs"`
+}
+
+type cnsUpdateVolumeMetadataResponse struct {
+	Returnval types.ManagedObjectReference `xml:"returnval"`
+}
+
+type cnsUpdateVolumeMetadataBody struct {
+	Req    *cnsUpdateVolumeMetadata         `xml:"urn:vsan CnsUpdateVolumeMetadata,omitempty"`
+	Res    *cnsUpdateVolumeMetadataResponse `xml:"urn:vsan CnsUpdateVolumeMetadataResponse,omitempty"`
+	Fault_ *soap.Fault                      `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *cnsUpdateVolumeMetadataBody) Fault() *soap.Fault { return b.Fault_ }
+
+type cnsDeleteVolume struct {
+	This       types.ManagedObjectReference `xml:"_this"`
+	VolumeIds  []CnsVolumeID                `xml:"volumeIds"`
+	DeleteDisk bool                         `xml:"deleteDisk"`
+}
+
+type cnsDeleteVolumeResponse struct {
+	Returnval types.ManagedObjectReference `xml:"returnval"`
+}
+
+type cnsDeleteVolumeBody struct {
+	Req    *cnsDeleteVolume         `xml:"urn:vsan CnsDeleteVolume,omitempty"`
+	Res    *cnsDeleteVolumeResponse `xml:"urn:vsan CnsDeleteVolumeResponse,omitempty"`
+	Fault_ *soap.Fault              `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *cnsDeleteVolumeBody) Fault() *soap.Fault { return b.Fault_ }
+
+// CnsClient registers the FCDs with the CNS of a vCenter and updates their
+// metadata.
+type CnsClient struct {
+	*soap.Client
+	vim *vim25.Client
+}
+
+// NewCnsClient returns a client of the CNS of the vCenter of a client,
+// sharing its session, reached at its storage endpoint when one is
+// configured.
+func NewCnsClient(client *vim25.Client) *CnsClient {
+	sc := newStorageServiceClient(client, vsanPath, vsanNamespace)
+	sc.Version = client.ServiceContent.About.ApiVersion
+	return &CnsClient{Client: sc, vim: client}
+}
+
+// RegisterVolume registers an FCD with CNS as a block volume, along with
+// its metadata. A volume that is already registered is left as is.
+func (c *CnsClient) RegisterVolume(ctx context.Context, fcdID string, name string,
+	datastore types.ManagedObjectReference, metadata CnsVolumeMetadata) error {
+
+	var reqBody, resBody cnsCreateVolumeBody
+	reqBody.Req = &cnsCreateVolume{
+		This: cnsVolumeManager,
+		CreateSpecs: []cnsVolumeCreateSpec{{
+			Name:                 name,
+			VolumeType:           CnsVolumeTypeBlock,
+			Datastores:           []types.ManagedObjectReference{datastore},
+			Metadata:             metadata,
+			BackingObjectDetails: &CnsBlockBackingDetails{BackingDiskID: fcdID},
+		}},
+	}
+	if err := c.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+		klog.Errorf("Failed to register volume %s with CNS. err: %v", fcdID, err)
+		return err
+	}
+	err := c.wait(ctx, resBody.Res.Returnval)
+	if _, ok := methodFault(err).(*types.AlreadyExists); ok {
+		return nil
+	}
+	return err
+}
+
+// UpdateVolumeMetadata adds the entities of the metadata to a volume
+// registered with CNS, or removes the ones marked for deletion.
+func (c *CnsClient) UpdateVolumeMetadata(ctx context.Context, fcdID string, metadata CnsVolumeMetadata) error {
+	var reqBody, resBody cnsUpdateVolumeMetadataBody
+	reqBody.Req = &cnsUpdateVolumeMetadata{
+		This: cnsVolumeManager,
+		UpdateSpecs: []cnsVolumeMetadataUpdateSpec{{
+			VolumeID: CnsVolumeID{ID: fcdID},
+			Metadata: metadata,
+		}},
+	}
+	if err := c.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+		klog.Errorf("Failed to update the CNS metadata of volume %s. err: %v", fcdID, err)
+		return err
+	}
+	return c.wait(ctx, resBody.Res.Returnval)
+}
+
+// UnregisterVolume removes a volume and its metadata from CNS, keeping its
+// FCD.
+func (c *CnsClient) UnregisterVolume(ctx context.Context, fcdID string) error {
+	var reqBody, resBody cnsDeleteVolumeBody
+	reqBody.Req = &cnsDeleteVolume{
+		This:       cnsVolumeManager,
+		VolumeIds:  []CnsVolumeID{{ID: fcdID}},
+		DeleteDisk: false,
+	}
+	if err := c.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+		klog.Errorf("Failed to unregister volume %s from CNS. err: %v", fcdID, err)
+		return err
+	}
+	return c.wait(ctx, resBody.Res.Returnval)
+}
+
+// wait waits for a task of CNS, and returns the fault of its volume, as
+// the task itself succeeds when the operation fails for the volume.
+func (c *CnsClient) wait(ctx context.Context, ref types.ManagedObjectReference) error {
+	info, err := waitForTask(ctx, c.vim, ref)
+	if err != nil {
+		return err
+	}
+	var result CnsVolumeOperationBatchResult
+	switch r := info.Result.(type) {
+	case CnsVolumeOperationBatchResult:
+		result = r
+	case *CnsVolumeOperationBatchResult:
+		result = *r
+	}
+	for _, volumeResult := range result.VolumeResults {
+		if volumeResult.Fault != nil {
+			return &TaskError{Task: ref, Err: task.Error{LocalizedMethodFault: volumeResult.Fault}}
+		}
+	}
+	return nil
+}
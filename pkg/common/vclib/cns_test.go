@@ -0,0 +1,225 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func init() {
+	for name, req := range map[string]interface{}{
+		"CnsCreateVolume":         cnsCreateVolume{},
+		"CnsUpdateVolumeMetadata": cnsUpdateVolumeMetadata{},
+		"CnsDeleteVolume":         cnsDeleteVolume{},
+	} {
+		types.Add(vsanNamespace+":"+name, reflect.TypeOf(req))
+	}
+}
+
+// cnsVolumeManagerSim simulates the CNS of a vCenter, keeping the entities of
+// the metadata of the registered volumes.
+type cnsVolumeManagerSim struct {
+	volumes map[string][]CnsKubernetesEntityMetadata
+}
+
+func (m *cnsVolumeManagerSim) Reference() types.ManagedObjectReference {
+	return cnsVolumeManager
+}
+
+// run returns a task running op for the volume, and reporting its fault in
+// the result of the task.
+func (m *cnsVolumeManagerSim) run(id string, op func() types.BaseMethodFault) types.ManagedObjectReference {
+	task := simulator.CreateTask(cnsVolumeManager, "cnsVolumeOperation", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		result := CnsVolumeOperationResult{VolumeID: &CnsVolumeID{ID: id}}
+		if fault := op(); fault != nil {
+			result.Fault = &types.LocalizedMethodFault{Fault: fault}
+		}
+		return CnsVolumeOperationBatchResult{VolumeResults: []CnsVolumeOperationResult{result}}, nil
+	})
+	return task.Run()
+}
+
+func (m *cnsVolumeManagerSim) CnsCreateVolume(req *cnsCreateVolume) soap.HasFault {
+	spec := req.CreateSpecs[0]
+	id := spec.BackingObjectDetails.BackingDiskID
+	return &cnsCreateVolumeBody{
+		Res: &cnsCreateVolumeResponse{Returnval: m.run(id, func() types.BaseMethodFault {
+			if _, ok := m.volumes[id]; ok {
+				return &types.AlreadyExists{Name: id}
+			}
+			m.volumes[id] = spec.Metadata.EntityMetadata
+			return nil
+		})},
+	}
+}
+
+func (m *cnsVolumeManagerSim) CnsUpdateVolumeMetadata(req *cnsUpdateVolumeMetadata) soap.HasFault {
+	spec := req.UpdateSpecs[0]
+	id := spec.VolumeID.ID
+	return &cnsUpdateVolumeMetadataBody{
+		Res: &cnsUpdateVolumeMetadataResponse{Returnval: m.run(id, func() types.BaseMethodFault {
+			entities, ok := m.volumes[id]
+			if !ok {
+				return &types.NotFound{}
+			}
+			for _, update := range spec.Metadata.EntityMetadata {
+				var kept []CnsKubernetesEntityMetadata
+				for _, entity := range entities {
+					if entity.EntityType != update.EntityType || entity.EntityName != update.EntityName ||
+						entity.Namespace != update.Namespace {
+						kept = append(kept, entity)
+					}
+				}
+				if !update.Delete {
+					kept = append(kept, update)
+				}
+				entities = kept
+			}
+			m.volumes[id] = entities
+			return nil
+		})},
+	}
+}
+
+func (m *cnsVolumeManagerSim) CnsDeleteVolume(req *cnsDeleteVolume) soap.HasFault {
+	id := req.VolumeIds[0].ID
+	return &cnsDeleteVolumeBody{
+		Res: &cnsDeleteVolumeResponse{Returnval: m.run(id, func() types.BaseMethodFault {
+			if _, ok := m.volumes[id]; !ok {
+				return &types.NotFound{}
+			}
+			delete(m.volumes, id)
+			return nil
+		})},
+	}
+}
+
+func TestIsCnsSupported(t *testing.T) {
+	for version, supported := range map[string]bool{
+		"6.5":     false,
+		"6.7":     false,
+		"6.7.2":   false,
+		"6.7.3":   true,
+		"6.7.3.1": true,
+		"7.0":     true,
+		"7.0.1.0": true,
+		"":        false,
+		"invalid": false,
+	} {
+		if IsCnsSupported(version) != supported {
+			t.Errorf("IsCnsSupported(%q) should return %t", version, supported)
+		}
+	}
+}
+
+func TestCnsClient(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	defer model.Remove()
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim := &cnsVolumeManagerSim{volumes: make(map[string][]CnsKubernetesEntityMetadata)}
+	vsan := simulator.NewRegistry()
+	vsan.Namespace = vsanNamespace
+	vsan.Path = vsanPath
+	vsan.Put(sim)
+	model.Service.RegisterSDK(vsan)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewCnsClient(c.Client)
+	ds := simulator.Map.Any("Datastore").Reference()
+
+	cluster := CnsContainerCluster{ClusterType: CnsClusterTypeKubernetes, ClusterID: "cluster-1", VSphereUser: "user"}
+	pv := CnsKubernetesEntityMetadata{
+		EntityName: "pvc-1",
+		EntityType: CnsEntityTypePV,
+		Labels:     []types.KeyValue{{Key: "app", Value: "db"}},
+	}
+
+	if err = client.UpdateVolumeMetadata(ctx, "fcd-1", CnsVolumeMetadata{ContainerCluster: cluster}); !IsNotFound(err) {
+		t.Errorf("UpdateVolumeMetadata of an unregistered volume should fail with NotFound: %v", err)
+	}
+
+	metadata := CnsVolumeMetadata{ContainerCluster: cluster, EntityMetadata: []CnsKubernetesEntityMetadata{pv}}
+	if err = client.RegisterVolume(ctx, "fcd-1", "pvc-1", ds, metadata); err != nil {
+		t.Fatalf("RegisterVolume failed: %v", err)
+	}
+	if err = client.RegisterVolume(ctx, "fcd-1", "pvc-1", ds, metadata); err != nil {
+		t.Errorf("RegisterVolume of a registered volume should succeed: %v", err)
+	}
+	if !reflect.DeepEqual(sim.volumes["fcd-1"], metadata.EntityMetadata) {
+		t.Errorf("The metadata should round trip: %+v", sim.volumes["fcd-1"])
+	}
+
+	pod := CnsKubernetesEntityMetadata{
+		EntityName: "db-0",
+		EntityType: CnsEntityTypePod,
+		Namespace:  "default",
+		ReferredEntity: []CnsKubernetesEntityReference{
+			{EntityType: CnsEntityTypePVC, EntityName: "data-db-0", Namespace: "default"},
+		},
+	}
+	err = client.UpdateVolumeMetadata(ctx, "fcd-1", CnsVolumeMetadata{
+		ContainerCluster: cluster,
+		EntityMetadata:   []CnsKubernetesEntityMetadata{pod},
+	})
+	if err != nil {
+		t.Fatalf("UpdateVolumeMetadata failed: %v", err)
+	}
+	if len(sim.volumes["fcd-1"]) != 2 {
+		t.Errorf("The pod should be added to the metadata: %+v", sim.volumes["fcd-1"])
+	}
+
+	pod.Delete = true
+	err = client.UpdateVolumeMetadata(ctx, "fcd-1", CnsVolumeMetadata{
+		ContainerCluster: cluster,
+		EntityMetadata:   []CnsKubernetesEntityMetadata{pod},
+	})
+	if err != nil {
+		t.Fatalf("UpdateVolumeMetadata failed: %v", err)
+	}
+	if !reflect.DeepEqual(sim.volumes["fcd-1"], metadata.EntityMetadata) {
+		t.Errorf("The pod should be removed from the metadata: %+v", sim.volumes["fcd-1"])
+	}
+
+	if err = client.UnregisterVolume(ctx, "fcd-1"); err != nil {
+		t.Fatalf("UnregisterVolume failed: %v", err)
+	}
+	if _, ok := sim.volumes["fcd-1"]; ok {
+		t.Error("The volume should be unregistered")
+	}
+	if err = client.UnregisterVolume(ctx, "fcd-1"); !IsNotFound(err) {
+		t.Errorf("UnregisterVolume of an unregistered volume should fail with NotFound: %v", err)
+	}
+}
@@ -20,9 +20,13 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/pem"
+	"fmt"
 	"net"
+	"net/http"
 	neturl "net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/vmware/govmomi/session"
 	"github.com/vmware/govmomi/sts"
@@ -31,6 +35,16 @@ import (
 	"k8s.io/klog"
 )
 
+var (
+	// loginAttempts is the number of times a login is attempted while the
+	// vCenter limits the rate of the logins.
+	loginAttempts = 5
+
+	// loginRetryInitialDelay is the delay before a login limited by the
+	// vCenter is attempted again. It doubles with each attempt.
+	loginRetryInitialDelay = time.Second
+)
+
 // VSphereConnection contains information for connecting to vCenter
 type VSphereConnection struct {
 	Client            *vim25.Client
@@ -39,23 +53,42 @@ type VSphereConnection struct {
 	Hostname          string
 	Port              string
 	CACert            string
+	CAData            string
+	ProxyURL          string
+	NoProxy           string
 	Thumbprint        string
 	Insecure          bool
 	RoundTripperCount uint
+	ConnectTimeout    time.Duration
+	RequestTimeout    time.Duration
+	SoapDebugDir      string
+	RateLimiter       *RateLimiter
+	StorageEndpoint   *StorageEndpoint
 	credentialsLock   sync.Mutex
-}
+	clientLock        sync.Mutex
 
-var (
-	clientLock sync.Mutex
-)
+	// invalid is set when the credentials changed, so that the next Connect
+	// logs in with them on a new client
+	invalid bool
+
+	// connected is set once a client has logged in, until the connection is
+	// closed, and sessionErr is the error of the last check of its session,
+	// by Connect or by the keep-alive
+	healthLock sync.RWMutex
+	connected  bool
+	sessionErr error
+}
 
 // Connect makes connection to vCenter and sets VSphereConnection.Client.
 // If connection.Client is already set, it obtains the existing user session.
-// if user session is not valid, connection.Client will be set to the new client.
+// if user session is not valid, connection.Client is logged in again, or set
+// to a new client if it was not created by NewClient.
 func (connection *VSphereConnection) Connect(ctx context.Context) error {
 	var err error
-	clientLock.Lock()
-	defer clientLock.Unlock()
+	connection.clientLock.Lock()
+	defer connection.clientLock.Unlock()
+
+	defer func() { connection.recordSession(err) }()
 
 	if connection.Client == nil {
 		connection.Client, err = connection.NewClient(ctx)
@@ -65,6 +98,18 @@ func (connection *VSphereConnection) Connect(ctx context.Context) error {
 		}
 		return nil
 	}
+	if connection.invalid {
+		var client *vim25.Client
+		client, err = connection.NewClient(ctx)
+		if err != nil {
+			klog.Errorf("Failed to create govmomi client. err: %+v", err)
+			return err
+		}
+		retireClient(connection.Client)
+		connection.Client = client
+		connection.invalid = false
+		return nil
+	}
 	m := session.NewManager(connection.Client)
 	userSession, err := m.UserSession(ctx)
 	if err != nil {
@@ -74,6 +119,10 @@ func (connection *VSphereConnection) Connect(ctx context.Context) error {
 	if userSession != nil {
 		return nil
 	}
+	if s, ok := connection.Client.RoundTripper.(*sessionRoundTripper); ok {
+		err = s.relogin(ctx)
+		return err
+	}
 	klog.Warning("Creating new client session since the existing session is not valid or not authenticated")
 
 	connection.Client, err = connection.NewClient(ctx)
@@ -84,8 +133,37 @@ func (connection *VSphereConnection) Connect(ctx context.Context) error {
 	return nil
 }
 
+// recordSession records the result of a check of the session.
+func (connection *VSphereConnection) recordSession(err error) {
+	connection.healthLock.Lock()
+	defer connection.healthLock.Unlock()
+
+	connection.sessionErr = err
+	if err == nil {
+		connection.connected = true
+	}
+}
+
+// Health returns nil when a session is established and passed its last
+// check, by Connect or by the keep-alive. It returns the error of the last
+// check otherwise, or ErrNotConnected when no client has logged in yet.
+func (connection *VSphereConnection) Health() error {
+	connection.healthLock.RLock()
+	defer connection.healthLock.RUnlock()
+
+	if connection.sessionErr != nil {
+		return connection.sessionErr
+	}
+	if !connection.connected {
+		return ErrNotConnected
+	}
+	return nil
+}
+
 // login calls SessionManager.LoginByToken if certificate and private key are configured,
-// otherwise calls SessionManager.Login with user and password.
+// otherwise calls SessionManager.Login with user and password. A login the
+// vCenter rejects for exceeding its rate limit is attempted again with a
+// jittered backoff.
 func (connection *VSphereConnection) login(ctx context.Context, client *vim25.Client) error {
 	m := session.NewManager(client)
 	connection.credentialsLock.Lock()
@@ -97,7 +175,9 @@ func (connection *VSphereConnection) login(ctx context.Context, client *vim25.Cl
 	b, _ := pem.Decode([]byte(connection.Username))
 	if b == nil {
 		klog.V(3).Infof("SessionManager.Login with username '%s'", connection.Username)
-		return m.Login(ctx, neturl.UserPassword(connection.Username, connection.Password))
+		return retryWhile(ctx, loginAttempts, loginRetryInitialDelay, IsRateLimited, func() error {
+			return m.Login(ctx, neturl.UserPassword(connection.Username, connection.Password))
+		})
 	}
 
 	klog.V(3).Infof("SessionManager.LoginByToken with certificate '%s'", connection.Username)
@@ -113,6 +193,7 @@ func (connection *VSphereConnection) login(ctx context.Context, client *vim25.Cl
 		klog.Errorf("Failed to create STS client. err: %+v", err)
 		return err
 	}
+	InheritProxy(client.Client, tokens.Client)
 
 	req := sts.TokenRequest{
 		Certificate: &cert,
@@ -126,7 +207,9 @@ func (connection *VSphereConnection) login(ctx context.Context, client *vim25.Cl
 
 	header := soap.Header{Security: signer}
 
-	return m.LoginByToken(client.WithHeader(ctx, header))
+	return retryWhile(ctx, loginAttempts, loginRetryInitialDelay, IsRateLimited, func() error {
+		return m.LoginByToken(client.WithHeader(ctx, header))
+	})
 }
 
 // Logout calls SessionManager.Logout for the given connection.
@@ -137,6 +220,31 @@ func (connection *VSphereConnection) Logout(ctx context.Context) {
 	}
 }
 
+// Close logs the session of the connection out for good: the client is no
+// longer logged in again, and its keep-alive stops with the logout. A later
+// Connect logs in on a new client.
+func (connection *VSphereConnection) Close(ctx context.Context) {
+	connection.clientLock.Lock()
+	client := connection.Client
+	connection.invalid = true
+	connection.clientLock.Unlock()
+
+	connection.healthLock.Lock()
+	connection.connected = false
+	connection.sessionErr = nil
+	connection.healthLock.Unlock()
+
+	if client == nil {
+		return
+	}
+	if s, ok := client.RoundTripper.(*sessionRoundTripper); ok {
+		atomic.StoreInt32(&s.retired, 1)
+	}
+	if err := session.NewManager(client).Logout(ctx); err != nil {
+		klog.Errorf("Logout failed: %s", err)
+	}
+}
+
 // NewClient creates a new govmomi client for the VSphereConnection obj
 func (connection *VSphereConnection) NewClient(ctx context.Context) (*vim25.Client, error) {
 	url, err := soap.ParseURL(net.JoinHostPort(connection.Hostname, connection.Port))
@@ -145,22 +253,53 @@ func (connection *VSphereConnection) NewClient(ctx context.Context) (*vim25.Clie
 		return nil, err
 	}
 
-	sc := soap.NewClient(url, connection.Insecure)
+	sc := newSoapClient(url, connection.Insecure, connection.SoapDebugDir)
+	if err := connection.configureProxy(sc); err != nil {
+		return nil, err
+	}
+
+	tpHost := connection.Hostname + ":" + connection.Port
+	if err := connection.configureTLS(sc, tpHost); err != nil {
+		return nil, err
+	}
+	if err := connection.configureConnectTimeout(sc); err != nil {
+		return nil, err
+	}
 
-	if ca := connection.CACert; ca != "" {
-		if err := sc.SetRootCAs(ca); err != nil {
+	var storage *soap.Client
+	if connection.StorageEndpoint != nil {
+		storage, err = connection.StorageEndpoint.newClient()
+		if err != nil {
 			return nil, err
 		}
 	}
 
-	tpHost := connection.Hostname + ":" + connection.Port
-	sc.SetThumbprint(tpHost, connection.Thumbprint)
-
-	client, err := vim25.NewClient(ctx, sc)
+	client, err := connection.newVimClient(ctx, sc)
 	if err != nil {
 		klog.Errorf("Failed to create new client. err: %+v", err)
 		return nil, err
 	}
+
+	if connection.RoundTripperCount == 0 {
+		connection.RoundTripperCount = RoundTripperDefaultCount
+	}
+
+	// The keep-alive starts once logged in and logs in again if the session
+	// expires anyway, as do the calls that fail with NotAuthenticated
+	var s *sessionRoundTripper
+	client.RoundTripper = session.KeepAliveHandler(client.RoundTripper, keepAliveIdleTime, func(rt soap.RoundTripper) error {
+		return s.keepAlive(rt)
+	})
+	if connection.RateLimiter != nil {
+		// Every attempt of a call waits for the rate limiter
+		client.RoundTripper = &rateLimitRoundTripper{roundTripper: client.RoundTripper, limiter: connection.RateLimiter}
+	}
+	client.RoundTripper = newRetryRoundTripper(client.RoundTripper, int(connection.RoundTripperCount), connection.RequestTimeout)
+	client.RoundTripper = &metricsRoundTripper{roundTripper: client.RoundTripper, vc: connection.Hostname}
+	s = newSessionRoundTripper(connection, client)
+	s.storage = storage
+	client.RoundTripper = s
+
 	err = connection.login(ctx, client)
 	if err != nil {
 		return nil, err
@@ -172,13 +311,48 @@ func (connection *VSphereConnection) NewClient(ctx context.Context) (*vim25.Clie
 		}
 	}
 
-	if connection.RoundTripperCount == 0 {
-		connection.RoundTripperCount = RoundTripperDefaultCount
-	}
-	client.RoundTripper = vim25.Retry(client.RoundTripper, vim25.TemporaryNetworkError(int(connection.RoundTripperCount)))
 	return client, nil
 }
 
+// newVimClient creates the vim25 client, bounding its first call with the
+// request timeout.
+func (connection *VSphereConnection) newVimClient(ctx context.Context, sc *soap.Client) (*vim25.Client, error) {
+	if connection.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, connection.RequestTimeout)
+		defer cancel()
+	}
+	return vim25.NewClient(ctx, sc)
+}
+
+// configureConnectTimeout bounds the dial and the TLS handshake of the SOAP
+// client with the connect timeout.
+func (connection *VSphereConnection) configureConnectTimeout(sc *soap.Client) error {
+	if connection.ConnectTimeout <= 0 {
+		return nil
+	}
+
+	transport, ok := sc.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unexpected SOAP transport %T", sc.Transport)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   connection.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = connection.ConnectTimeout
+	if transport.DialTLS != nil {
+		// The certificate is verified by the TLS configuration, see
+		// configureTLS
+		transport.DialTLS = func(network, addr string) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, network, addr, transport.TLSClientConfig)
+		}
+	}
+	return nil
+}
+
 // UpdateCredentials updates username and password.
 // Note: Updated username and password will be used when there is no session active
 func (connection *VSphereConnection) UpdateCredentials(username string, password string) {
@@ -187,3 +361,29 @@ func (connection *VSphereConnection) UpdateCredentials(username string, password
 	connection.Username = username
 	connection.Password = password
 }
+
+// User returns the username the connection logs in with.
+func (connection *VSphereConnection) User() string {
+	connection.credentialsLock.Lock()
+	defer connection.credentialsLock.Unlock()
+	return connection.Username
+}
+
+// RotateCredentials updates username and password like UpdateCredentials,
+// and when they changed makes the next Connect log in with them on a new
+// client. Calls that already hold the old client complete on the old
+// session. It returns true when the credentials changed.
+func (connection *VSphereConnection) RotateCredentials(username string, password string) bool {
+	connection.credentialsLock.Lock()
+	changed := connection.Username != username || connection.Password != password
+	connection.Username = username
+	connection.Password = password
+	connection.credentialsLock.Unlock()
+
+	if changed {
+		connection.clientLock.Lock()
+		connection.invalid = connection.Client != nil
+		connection.clientLock.Unlock()
+	}
+	return changed
+}
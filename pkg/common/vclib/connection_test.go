@@ -19,16 +19,19 @@ package vclib_test
 import (
 	"context"
 	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
 	"k8s.io/cloud-provider-vsphere/pkg/common/vclib/fixtures"
@@ -114,7 +117,7 @@ func TestWithVerificationWithWrongThumbprint(t *testing.T) {
 
 	_, err := connection.NewClient(context.Background())
 
-	if msg := err.Error(); !strings.Contains(msg, "thumbprint does not match") {
+	if msg := err.Error(); !strings.Contains(msg, "thumbprint mismatch, expected obviously wrong got ") {
 		t.Fatalf("Expected wrong thumbprint error, got '%s'", msg)
 	}
 }
@@ -156,6 +159,120 @@ func TestWithValidThumbprint(t *testing.T) {
 	verifyConnectionWasMade()
 }
 
+func TestWithValidSHA256Thumbprint(t *testing.T) {
+	handler, verifyConnectionWasMade := getRequestVerifier(t)
+
+	server, _ := createTestServer(t, fixtures.CaCertPath, fixtures.ServerCertPath, fixtures.ServerKeyPath, handler)
+	server.StartTLS()
+	u := mustParseUrl(t, server.URL)
+
+	var thumbprint []string
+	for _, b := range sha256.Sum256(server.TLS.Certificates[0].Certificate[0]) {
+		thumbprint = append(thumbprint, fmt.Sprintf("%02x", b))
+	}
+
+	connection := &vclib.VSphereConnection{
+		Hostname:   u.Hostname(),
+		Port:       u.Port(),
+		Thumbprint: strings.Join(thumbprint, ":"),
+	}
+
+	// Ignoring error here, because we only care about the TLS connection
+	connection.NewClient(context.Background())
+
+	verifyConnectionWasMade()
+}
+
+func TestWithConnectTimeout(t *testing.T) {
+	handler, verifyConnectionWasMade := getRequestVerifier(t)
+
+	server, thumbprint :=
+		createTestServer(t, fixtures.CaCertPath, fixtures.ServerCertPath, fixtures.ServerKeyPath, handler)
+	server.StartTLS()
+	u := mustParseUrl(t, server.URL)
+
+	connection := &vclib.VSphereConnection{
+		Hostname:       u.Hostname(),
+		Port:           u.Port(),
+		Thumbprint:     thumbprint,
+		ConnectTimeout: 10 * time.Second,
+	}
+
+	// Ignoring error here, because we only care about the TLS connection
+	connection.NewClient(context.Background())
+
+	verifyConnectionWasMade()
+}
+
+func TestConnectTimeoutExpires(t *testing.T) {
+	// a vCenter that accepts the connection but never completes the TLS
+	// handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			if _, err := listener.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	u := mustParseUrl(t, "https://"+listener.Addr().String())
+
+	connection := &vclib.VSphereConnection{
+		Hostname:          u.Hostname(),
+		Port:              u.Port(),
+		RoundTripperCount: 1,
+		ConnectTimeout:    100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	if _, err = connection.NewClient(context.Background()); err == nil {
+		t.Fatal("NewClient should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("NewClient should fail after the connect timeout, took %s", elapsed)
+	}
+}
+
+func TestWithValidCaData(t *testing.T) {
+	handler, verifyConnectionWasMade := getRequestVerifier(t)
+
+	server, _ := createTestServer(t, fixtures.CaCertPath, fixtures.ServerCertPath, fixtures.ServerKeyPath, handler)
+	server.StartTLS()
+	u := mustParseUrl(t, server.URL)
+
+	caData, err := ioutil.ReadFile(fixtures.CaCertPath)
+	if err != nil {
+		t.Fatalf("Could not read ca cert from file")
+	}
+
+	connection := &vclib.VSphereConnection{
+		Hostname: u.Hostname(),
+		Port:     u.Port(),
+		CAData:   string(caData),
+	}
+
+	// Ignoring error here, because we only care about the TLS connection
+	connection.NewClient(context.Background())
+
+	verifyConnectionWasMade()
+}
+
+func TestWithInvalidCaData(t *testing.T) {
+	connection := &vclib.VSphereConnection{
+		Hostname: "should-not-matter",
+		Port:     "443",
+		CAData:   "not a certificate",
+	}
+
+	if _, err := connection.NewClient(context.Background()); err != vclib.ErrInvalidCAData {
+		t.Fatalf("Expected %v, got: %v", vclib.ErrInvalidCAData, err)
+	}
+}
+
 func TestWithInvalidCaCertPath(t *testing.T) {
 	connection := &vclib.VSphereConnection{
 		Hostname: "should-not-matter",
@@ -96,6 +96,28 @@ const (
 	// StoragePodProperty is a good constant, yes it is!
 	// TODO(?) Provide better documentation.
 	StoragePodProperty = "summary"
+	// DatastoreSummaryProperty is the property that holds the capacity and
+	// accessibility of a datastore.
+	DatastoreSummaryProperty = "summary"
+	// DatastoreHostProperty is the property that lists the hosts which
+	// mount a datastore.
+	DatastoreHostProperty = "host"
+	// StoragePodDrsConfigProperty is the Storage DRS config of a datastore
+	// cluster, without the recommendations and faults of its DRS entry.
+	StoragePodDrsConfigProperty = "podStorageDrsEntry.storageDrsConfig"
+	// VirtualMachineSummaryConfigProperty is the UUID, guest ID and sizing
+	// of a VM, without the devices and layout of its full config.
+	VirtualMachineSummaryConfigProperty = "summary.config"
+	// VirtualMachineUUIDProperty is the BIOS UUID of a VM.
+	VirtualMachineUUIDProperty = "summary.config.uuid"
+	// VirtualMachineHostNameProperty is the host name reported by the guest
+	// of a VM.
+	VirtualMachineHostNameProperty = "guest.hostName"
+	// VirtualMachineGuestNetProperty lists the NICs and IP addresses
+	// reported by the guest of a VM.
+	VirtualMachineGuestNetProperty = "guest.net"
+	// VirtualMachinePowerStateProperty is the power state of a VM.
+	VirtualMachinePowerStateProperty = "runtime.powerState"
 	// VirtualMachineType is a good constant, yes it is!
 	// TODO(?) Provide better documentation.
 	VirtualMachineType = "VirtualMachine"
@@ -111,6 +133,9 @@ const (
 	// ActivePowerState is a good constant, yes it is!
 	// TODO(?) Provide better documentation.
 	ActivePowerState = "poweredOn"
+	// GuestSyncCommand is the program run in the guest of a VM by VMware
+	// Tools to flush its file systems to its disks.
+	GuestSyncCommand = "/bin/sync"
 )
 
 // Test Constants
@@ -16,7 +16,12 @@ limitations under the License.
 
 package vclib
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
 
 // Error Messages
 const (
@@ -29,6 +34,13 @@ const (
 	NoDatastoreFoundErrMsg         = "Datastore not found"
 	NoDatacenterFoundErrMsg        = "Datacenter not found"
 	NoDataStoreClustersFoundErrMsg = "No DatastoreClusters Found"
+	NoSnapshotFoundErrMsg          = "No vSphere snapshot ID/Name found"
+	InvalidCADataErrMsg            = "No valid CA certificate found in the CA data"
+	InvalidProxyURLErrMsg          = "Proxy URL is not a valid http, https or socks5 URL"
+	NoFileShareFoundErrMsg         = "No vSAN file share found"
+	NotVsanDatastoreErrMsg         = "Datastore is not a vSAN datastore"
+	NotConnectedErrMsg             = "No session established with the vCenter"
+	NoComputeResourceFoundErrMsg   = "No compute resource found"
 )
 
 // Error constants
@@ -42,4 +54,37 @@ var (
 	ErrNoDatastoreFound         = errors.New(NoDatastoreFoundErrMsg)
 	ErrNoDatacenterFound        = errors.New(NoDatacenterFoundErrMsg)
 	ErrNoDataStoreClustersFound = errors.New(NoDataStoreClustersFoundErrMsg)
+	ErrNoSnapshotFound          = errors.New(NoSnapshotFoundErrMsg)
+	ErrInvalidCAData            = errors.New(InvalidCADataErrMsg)
+	ErrInvalidProxyURL          = errors.New(InvalidProxyURLErrMsg)
+	ErrNoFileShareFound         = errors.New(NoFileShareFoundErrMsg)
+	ErrNotVsanDatastore         = errors.New(NotVsanDatastoreErrMsg)
+	ErrNotConnected             = errors.New(NotConnectedErrMsg)
+	ErrNoComputeResourceFound   = errors.New(NoComputeResourceFoundErrMsg)
 )
+
+// TaskInProgressError is returned when the context is done before a vSphere
+// task completes. The task keeps running in vSphere.
+type TaskInProgressError struct {
+	// Task is the task that is still running
+	Task types.ManagedObjectReference
+	// Err is the error of the context
+	Err error
+}
+
+func (e *TaskInProgressError) Error() string {
+	return fmt.Sprintf("Task %s is still in progress: %v", e.Task.Value, e.Err)
+}
+
+// TaskError is the error of a vSphere task that failed, which names the task
+// so that the failure can be traced to it in the vSphere Client.
+type TaskError struct {
+	// Task is the task that failed
+	Task types.ManagedObjectReference
+	// Err is the error of the task
+	Err error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("%v (task %s)", e.Err, e.Task.Value)
+}
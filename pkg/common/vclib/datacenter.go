@@ -22,11 +22,14 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
 	"github.com/vmware/govmomi/vslm"
 	"k8s.io/klog"
@@ -111,6 +114,41 @@ func (dc *Datacenter) GetVMByUUID(ctx context.Context, vmUUID string) (*VirtualM
 	return &virtualMachine, nil
 }
 
+// GetVMByIP gets the VM object from the given guest IP address
+func (dc *Datacenter) GetVMByIP(ctx context.Context, ip string) (*VirtualMachine, error) {
+	s := object.NewSearchIndex(dc.Client())
+	ip = strings.ToLower(strings.TrimSpace(ip))
+	svm, err := s.FindByIp(ctx, dc.Datacenter, ip, true)
+	if err != nil {
+		klog.Errorf("Failed to find VM by IP. VM IP: %s, err: %+v", ip, err)
+		return nil, err
+	}
+	if svm == nil {
+		klog.Errorf("Unable to find VM by IP. VM IP: %s", ip)
+		return nil, ErrNoVMFound
+	}
+	virtualMachine := VirtualMachine{object.NewVirtualMachine(dc.Client(), svm.Reference()), dc}
+	return &virtualMachine, nil
+}
+
+// GetVMByInstanceUUID gets the VM object from the given vCenter instance UUID
+func (dc *Datacenter) GetVMByInstanceUUID(ctx context.Context, instanceUUID string) (*VirtualMachine, error) {
+	s := object.NewSearchIndex(dc.Client())
+	instanceUUID = strings.ToLower(strings.TrimSpace(instanceUUID))
+	useInstanceUUID := true
+	svm, err := s.FindByUuid(ctx, dc.Datacenter, instanceUUID, true, &useInstanceUUID)
+	if err != nil {
+		klog.Errorf("Failed to find VM by instance UUID. VM instance UUID: %s, err: %+v", instanceUUID, err)
+		return nil, err
+	}
+	if svm == nil {
+		klog.Errorf("Unable to find VM by instance UUID. VM instance UUID: %s", instanceUUID)
+		return nil, ErrNoVMFound
+	}
+	virtualMachine := VirtualMachine{object.NewVirtualMachine(dc.Client(), svm.Reference()), dc}
+	return &virtualMachine, nil
+}
+
 // GetVMByPath gets the VM object from the given vmPath
 // vmPath should be the full path to VM and not just the name
 func (dc *Datacenter) GetVMByPath(ctx context.Context, vmPath string) (*VirtualMachine, error) {
@@ -376,7 +414,7 @@ func (dc *Datacenter) GetAllDatastoreClusters(ctx context.Context, child bool) (
 
 	var spMoList []mo.StoragePod
 	pc := property.DefaultCollector(dc.Client())
-	properties := []string{StoragePodDrsEntryProperty, StoragePodProperty}
+	properties := []string{StoragePodDrsConfigProperty, StoragePodProperty}
 	err = pc.Retrieve(ctx, spList, properties, &spMoList)
 	if err != nil {
 		klog.Errorf("Failed to get Datastore managed objects from datastore objects."+
@@ -420,7 +458,7 @@ func (dc *Datacenter) GetDatastoreClusterByName(ctx context.Context, name string
 
 	var spMo mo.StoragePod
 	pc := property.DefaultCollector(dc.Client())
-	properties := []string{StoragePodDrsEntryProperty, StoragePodProperty}
+	properties := []string{StoragePodDrsConfigProperty, StoragePodProperty}
 	err = pc.RetrieveOne(ctx, ds.Reference(), properties, &spMo)
 	if err != nil {
 		klog.Errorf("Failed to get Datastore managed objects from datastore objects."+
@@ -440,12 +478,211 @@ func (dc *Datacenter) GetDatastoreClusterByName(ctx context.Context, name string
 	}, nil
 }
 
-// CreateFirstClassDisk creates a new first class disk.
-func (dc *Datacenter) CreateFirstClassDisk(ctx context.Context,
-	datastoreName string, datastoreType ParentDatastoreType,
-	diskName string, diskSize int64) error {
+// GetDatastoreFreeSpace returns the free space in bytes of a datastore or,
+// for a datastore cluster, the sum of the free space of its datastores.
+func (dc *Datacenter) GetDatastoreFreeSpace(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType) (int64, error) {
 
-	m := vslm.NewObjectManager(dc.Client())
+	if datastoreType == TypeDatastore {
+		datastore, err := dc.GetDatastoreByName(ctx, datastoreName)
+		if err != nil {
+			klog.Errorf("GetDatastoreByName failed. Err: %v", err)
+			return 0, err
+		}
+		return datastore.Info.FreeSpace, nil
+	}
+
+	storagePod, err := dc.GetDatastoreClusterByName(ctx, datastoreName)
+	if err != nil {
+		klog.Errorf("GetDatastoreClusterByName failed. Err: %v", err)
+		return 0, err
+	}
+
+	err = storagePod.PopulateChildDatastoreInfos(ctx, true)
+	if err != nil {
+		klog.Errorf("PopulateChildDatastoreInfos failed. Err: %v", err)
+		return 0, err
+	}
+
+	var freeSpace int64
+	for _, datastore := range storagePod.DatastoreInfos {
+		freeSpace += datastore.Info.FreeSpace
+	}
+
+	return freeSpace, nil
+}
+
+// GetSharedDatastoreWithMostFreeSpace returns the accessible datastore that
+// is mounted by more than one host and has the most free space. Datastores
+// with less than minFreeSpace bytes free are ignored. When hosts is not
+// empty, so are the datastores that none of the hosts mount, and when
+// allowed is not nil, the datastores whose name it does not allow.
+func (dc *Datacenter) GetSharedDatastoreWithMostFreeSpace(ctx context.Context,
+	minFreeSpace int64, hosts []types.ManagedObjectReference, allowed func(name string) bool) (*DatastoreInfo, error) {
+	finder := getFinder(dc)
+	datastores, err := finder.DatastoreList(ctx, "*")
+	if err != nil {
+		klog.Errorf("Failed to get all the datastores. err: %+v", err)
+		return nil, err
+	}
+	var dsList []types.ManagedObjectReference
+	for _, ds := range datastores {
+		dsList = append(dsList, ds.Reference())
+	}
+
+	var dsMoList []mo.Datastore
+	pc := property.DefaultCollector(dc.Client())
+	properties := []string{DatastoreInfoProperty, DatastoreSummaryProperty, DatastoreHostProperty}
+	err = pc.Retrieve(ctx, dsList, properties, &dsMoList)
+	if err != nil {
+		klog.Errorf("Failed to get Datastore managed objects from datastore objects."+
+			" dsObjList: %+v, properties: %+v, err: %v", dsList, properties, err)
+		return nil, err
+	}
+
+	var best *mo.Datastore
+	for i := range dsMoList {
+		dsMo := &dsMoList[i]
+		shared := len(dsMo.Host) > 1 ||
+			(dsMo.Summary.MultipleHostAccess != nil && *dsMo.Summary.MultipleHostAccess)
+		if !dsMo.Summary.Accessible || !shared || dsMo.Summary.FreeSpace < minFreeSpace {
+			klog.V(LogLevel).Infof("Skipping datastore %s accessible=%t shared=%t freeSpace=%d",
+				dsMo.Summary.Name, dsMo.Summary.Accessible, shared, dsMo.Summary.FreeSpace)
+			continue
+		}
+		if len(hosts) > 0 && !isMountedByAnyHost(dsMo.Host, hosts) {
+			klog.V(LogLevel).Infof("Skipping datastore %s not mounted by the hosts", dsMo.Summary.Name)
+			continue
+		}
+		if allowed != nil && !allowed(dsMo.Summary.Name) {
+			klog.V(LogLevel).Infof("Skipping datastore %s not allowed", dsMo.Summary.Name)
+			continue
+		}
+		if best == nil || dsMo.Summary.FreeSpace > best.Summary.FreeSpace {
+			best = dsMo
+		}
+	}
+
+	if best == nil {
+		klog.Errorf("No shared datastore with %d bytes free found", minFreeSpace)
+		return nil, ErrNoDatastoreFound
+	}
+
+	return &DatastoreInfo{
+		&Datastore{object.NewDatastore(dc.Client(), best.Reference()),
+			dc},
+		best.Info.GetDatastoreInfo()}, nil
+}
+
+// isMountedByAnyHost returns whether one of the hosts is among the mounts of
+// a datastore.
+func isMountedByAnyHost(mounts []types.DatastoreHostMount, hosts []types.ManagedObjectReference) bool {
+	for _, mount := range mounts {
+		for _, host := range hosts {
+			if mount.Key == host {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetDatastoreClusterWithMostFreeSpace returns the datastore cluster with
+// the most free space. Datastore clusters with less than minFreeSpace bytes
+// free are ignored. When hosts is not empty, so are the datastore clusters
+// whose datastores none of the hosts mount, and when allowed is not nil,
+// the datastore clusters whose name it does not allow.
+func (dc *Datacenter) GetDatastoreClusterWithMostFreeSpace(ctx context.Context,
+	minFreeSpace int64, hosts []types.ManagedObjectReference, allowed func(name string) bool) (*StoragePodInfo, error) {
+	storagePods, err := dc.GetAllDatastoreClusters(ctx, len(hosts) > 0)
+	if err != nil {
+		klog.Errorf("GetAllDatastoreClusters failed. Err: %v", err)
+		return nil, err
+	}
+
+	var best *StoragePodInfo
+	for _, storagePod := range storagePods {
+		if storagePod.Summary.FreeSpace < minFreeSpace {
+			klog.V(LogLevel).Infof("Skipping datastore cluster %s freeSpace=%d",
+				storagePod.Summary.Name, storagePod.Summary.FreeSpace)
+			continue
+		}
+		if allowed != nil && !allowed(storagePod.Summary.Name) {
+			klog.V(LogLevel).Infof("Skipping datastore cluster %s not allowed", storagePod.Summary.Name)
+			continue
+		}
+		if len(hosts) > 0 {
+			mounted, err := storagePod.isMountedByAny(ctx, hosts)
+			if err != nil {
+				return nil, err
+			}
+			if !mounted {
+				klog.V(LogLevel).Infof("Skipping datastore cluster %s not mounted by the hosts", storagePod.Summary.Name)
+				continue
+			}
+		}
+		if best == nil || storagePod.Summary.FreeSpace > best.Summary.FreeSpace {
+			best = storagePod
+		}
+	}
+
+	if best == nil {
+		klog.Errorf("No datastore cluster with %d bytes free found", minFreeSpace)
+		return nil, ErrNoDataStoreClustersFound
+	}
+
+	return best, nil
+}
+
+// getPlacementResourcePool returns the resource pool SDRS places a disk
+// for: the pool of the first compute resource of the datacenter with a host
+// that mounts a datastore of the StoragePod, as a disk does not belong to a
+// VM of a specific compute resource.
+func (dc *Datacenter) getPlacementResourcePool(ctx context.Context, storagePod *StoragePodInfo) (*object.ResourcePool, error) {
+	err := storagePod.PopulateChildDatastoreInfos(ctx, false)
+	if err != nil {
+		klog.Errorf("PopulateChildDatastoreInfos failed. Err: %v", err)
+		return nil, err
+	}
+
+	finder := getFinder(dc)
+	computeResources, err := finder.ComputeResourceList(ctx, "*")
+	if err != nil {
+		klog.Errorf("Failed to get the compute resources. err: %+v", err)
+		return nil, err
+	}
+	for _, computeResource := range computeResources {
+		hosts, err := computeResource.Hosts(ctx)
+		if err != nil {
+			klog.Errorf("Failed to get the hosts of compute resource %s. err: %+v", computeResource.Name(), err)
+			return nil, err
+		}
+		var refs []types.ManagedObjectReference
+		for _, host := range hosts {
+			refs = append(refs, host.Reference())
+		}
+		mounted, err := storagePod.isMountedByAny(ctx, refs)
+		if err != nil {
+			return nil, err
+		}
+		if mounted {
+			return computeResource.ResourcePool(ctx)
+		}
+		klog.V(LogLevel).Infof("Skipping compute resource %s not mounting datastore cluster %s",
+			computeResource.Name(), storagePod.Summary.Name)
+	}
+
+	klog.Errorf("No compute resource mounting datastore cluster %s found", storagePod.Summary.Name)
+	return nil, ErrNoComputeResourceFound
+}
+
+// placeFirstClassDisk sets the backing datastore and provisioning type of an
+// FCD create spec. When the parent is a datastore cluster, SDRS is asked to recommend one of
+// its datastores for the disk. If SDRS is disabled on the datastore cluster,
+// the datastore of the cluster with the most free space is used instead.
+func (dc *Datacenter) placeFirstClassDisk(ctx context.Context, m *vslm.ObjectManager,
+	datastoreName string, datastoreType ParentDatastoreType, spec *types.VslmCreateSpec,
+	provisioningType string) error {
 
 	var pool *object.ResourcePool
 	var ds types.ManagedObjectReference
@@ -455,12 +692,24 @@ func (dc *Datacenter) CreateFirstClassDisk(ctx context.Context,
 			klog.Errorf("GetDatastoreClusterByName failed. Err: %v", err)
 			return err
 		}
-		ds = storagePod.Reference()
 
-		pool, err = dc.GetResourcePool(ctx, "")
-		if err != nil {
-			klog.Errorf("GetResourcePool failed. Err: %v", err)
-			return err
+		if !storagePod.IsStorageDrsEnabled() {
+			klog.Warningf("Storage DRS is disabled on %s, placing %s on the datastore with the most free space",
+				datastoreName, spec.Name)
+			datastore, err := storagePod.GetDatastoreWithMostFreeSpace(ctx, spec.CapacityInMB*1024*1024)
+			if err != nil {
+				klog.Errorf("GetDatastoreWithMostFreeSpace failed. Err: %v", err)
+				return err
+			}
+			ds = datastore.Reference()
+		} else {
+			ds = storagePod.Reference()
+
+			pool, err = dc.getPlacementResourcePool(ctx, storagePod)
+			if err != nil {
+				klog.Errorf("getPlacementResourcePool failed. Err: %v", err)
+				return err
+			}
 		}
 	} else {
 		datastore, err := dc.GetDatastoreByName(ctx, datastoreName)
@@ -471,23 +720,67 @@ func (dc *Datacenter) CreateFirstClassDisk(ctx context.Context,
 		ds = datastore.Reference()
 	}
 
-	spec := types.VslmCreateSpec{
-		Name:         diskName,
-		CapacityInMB: diskSize,
-		BackingSpec: &types.VslmCreateSpecDiskFileBackingSpec{
-			VslmCreateSpecBackingSpec: types.VslmCreateSpecBackingSpec{
-				Datastore: ds,
-			},
-			ProvisioningType: string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeThin),
+	spec.BackingSpec = &types.VslmCreateSpecDiskFileBackingSpec{
+		VslmCreateSpecBackingSpec: types.VslmCreateSpecBackingSpec{
+			Datastore: ds,
 		},
+		ProvisioningType: provisioningType,
 	}
 
-	if datastoreType == TypeDatastoreCluster {
-		err := m.PlaceDisk(ctx, &spec, pool.Reference())
+	// Only set when SDRS picks the datastore
+	if pool != nil {
+		err := m.PlaceDisk(ctx, spec, pool.Reference())
 		if err != nil {
-			klog.Errorf("PlaceDisk(%s) failed. Err: %v", diskName, err)
+			klog.Errorf("PlaceDisk(%s) failed. Err: %v", spec.Name, err)
 			return err
 		}
+		klog.V(LogLevel).Infof("Storage DRS recommended datastore %s for %s in %s",
+			spec.BackingSpec.GetVslmCreateSpecBackingSpec().Datastore.Value, spec.Name, datastoreName)
+	}
+
+	return nil
+}
+
+// CreateFirstClassDisk creates a new first class disk.
+func (dc *Datacenter) CreateFirstClassDisk(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskName string, diskSize int64) error {
+	return dc.CreateFirstClassDiskWithOptions(ctx, datastoreName, datastoreType, diskName, diskSize, &VolumeOptions{})
+}
+
+// CreateFirstClassDiskWithOptions creates a new first class disk with the
+// storage policy of the StoragePolicyID and the format of the DiskFormat of
+// the volume options. The default policy of the datastore is used when the
+// StoragePolicyID is empty, and the disk is thin when the DiskFormat is.
+//
+// Creating a thick disk can take longer than the context allows. When the
+// context is done first, the task is left running and a
+// *TaskInProgressError is returned, so that the caller can wait for the
+// task again with WaitForTask.
+func (dc *Datacenter) CreateFirstClassDiskWithOptions(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskName string, diskSize int64, volumeOptions *VolumeOptions) error {
+
+	if volumeOptions.DiskFormat != "" && !CheckDiskFormatSupported(volumeOptions.DiskFormat) {
+		return fmt.Errorf("Not a valid disk format. Valid options are %q", DiskformatValidOptions())
+	}
+
+	m := vslm.NewObjectManager(dc.Client())
+
+	spec := types.VslmCreateSpec{
+		Name:         diskName,
+		CapacityInMB: diskSize,
+	}
+	if len(volumeOptions.StoragePolicyID) > 0 {
+		spec.Profile = []types.BaseVirtualMachineProfileSpec{
+			&types.VirtualMachineDefinedProfileSpec{ProfileId: volumeOptions.StoragePolicyID},
+		}
+	}
+
+	err := dc.placeFirstClassDisk(ctx, m, datastoreName, datastoreType, &spec,
+		FirstClassDiskProvisioningType(volumeOptions.DiskFormat))
+	if err != nil {
+		return err
 	}
 
 	task, err := m.CreateDisk(ctx, spec)
@@ -496,7 +789,7 @@ func (dc *Datacenter) CreateFirstClassDisk(ctx context.Context,
 		return err
 	}
 
-	err = task.Wait(ctx)
+	err = dc.WaitForTask(ctx, task.Reference())
 	if err != nil {
 		klog.Errorf("Wait(%s) failed. Err: %v", diskName, err)
 		return err
@@ -505,6 +798,106 @@ func (dc *Datacenter) CreateFirstClassDisk(ctx context.Context,
 	return nil
 }
 
+// WaitForTask waits for a task of the vCenter of the datacenter to
+// complete. The error of a failed task is a *TaskError, which names it.
+// When the context is done first, the task is left running and a
+// *TaskInProgressError is returned.
+func (dc *Datacenter) WaitForTask(ctx context.Context, ref types.ManagedObjectReference) error {
+	_, err := waitForTask(ctx, dc.Client(), ref)
+	return err
+}
+
+// FirstClassDiskProvisioningType returns the provisioning type of the FCD
+// backing of a disk format of DiskFormatValidType. The disk is thin when
+// the format is empty.
+func FirstClassDiskProvisioningType(diskFormat string) string {
+	switch DiskFormatValidType[diskFormat] {
+	case EagerZeroedThickDiskType:
+		return string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeEagerZeroedThick)
+	case PreallocatedDiskType:
+		return string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeLazyZeroedThick)
+	}
+	return string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeThin)
+}
+
+// AttachFirstClassDiskTag attaches the tag of the category to an FCD. The
+// tag and the category must exist.
+func (dc *Datacenter) AttachFirstClassDiskTag(ctx context.Context, diskID string, category string, tag string) error {
+	m := vslm.NewObjectManager(dc.Client())
+
+	err := m.AttachTag(ctx, diskID, types.VslmTagEntry{
+		TagName:            tag,
+		ParentCategoryName: category,
+	})
+	if err != nil {
+		klog.Errorf("AttachTag(%s) failed. Err: %v", diskID, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetFirstClassDiskIDsByTag returns the IDs of the FCDs of the vCenter of
+// the datacenter the tag of the category is attached to. No ID is returned
+// when the tag does not exist.
+func (dc *Datacenter) GetFirstClassDiskIDsByTag(ctx context.Context, category string, tag string) ([]string, error) {
+	m := vslm.NewObjectManager(dc.Client())
+
+	ids, err := m.ListAttachedObjects(ctx, category, tag)
+	if err != nil {
+		if soap.IsSoapFault(err) {
+			if _, ok := soap.ToSoapFault(err).VimFault().(types.NotFound); ok {
+				return nil, nil
+			}
+		}
+		klog.Errorf("ListAttachedObjects(%s/%s) failed. Err: %v", category, tag, err)
+		return nil, err
+	}
+
+	diskIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		diskIDs = append(diskIDs, id.Id)
+	}
+	return diskIDs, nil
+}
+
+// GetFirstClassDiskTags returns the names of the tags of the category
+// attached to an FCD.
+func (dc *Datacenter) GetFirstClassDiskTags(ctx context.Context, diskID string, category string) ([]string, error) {
+	m := vslm.NewObjectManager(dc.Client())
+
+	entries, err := m.ListAttachedTags(ctx, diskID)
+	if err != nil {
+		klog.Errorf("ListAttachedTags(%s) failed. Err: %v", diskID, err)
+		return nil, err
+	}
+
+	var tags []string
+	for _, entry := range entries {
+		if entry.ParentCategoryName == category {
+			tags = append(tags, entry.TagName)
+		}
+	}
+	return tags, nil
+}
+
+// FirstClassDiskFormat returns the disk format of DiskFormatValidType of an
+// FCD by the provisioning type of its backing, or an empty string when the
+// backing is not a disk file.
+func FirstClassDiskFormat(fcd *FirstClassDiskInfo) string {
+	backing, ok := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo)
+	if !ok {
+		return ""
+	}
+	switch types.BaseConfigInfoDiskFileBackingInfoProvisioningType(backing.ProvisioningType) {
+	case types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeEagerZeroedThick:
+		return strings.ToLower(EagerZeroedThickDiskType)
+	case types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeLazyZeroedThick:
+		return strings.ToLower(ZeroedThickDiskType)
+	}
+	return ThinDiskType
+}
+
 // GetFirstClassDisk searches for an existing FCD.
 func (dc *Datacenter) GetFirstClassDisk(ctx context.Context,
 	datastoreName string, datastoreType ParentDatastoreType,
@@ -543,7 +936,7 @@ func (dc *Datacenter) GetFirstClassDisk(ctx context.Context,
 // GetAllFirstClassDisks returns all known FCDs.
 func (dc *Datacenter) GetAllFirstClassDisks(ctx context.Context) ([]*FirstClassDiskInfo, error) {
 	storagePods, errDsClusters := dc.GetAllDatastoreClusters(ctx, true)
-	if errDsClusters != ErrNoDataStoreClustersFound {
+	if errDsClusters != nil && errDsClusters != ErrNoDataStoreClustersFound {
 		klog.Warningf("GetAllDatastoreClusters failed. Err: %v", errDsClusters)
 		return nil, errDsClusters
 	}
@@ -596,6 +989,118 @@ func (dc *Datacenter) GetAllFirstClassDisks(ctx context.Context) ([]*FirstClassD
 	return firstClassDisks, nil
 }
 
+// FirstClassDiskScan is the result of a scan of the FCDs of the datastores
+// and datastore clusters of a datacenter.
+type FirstClassDiskScan struct {
+	FirstClassDisks []*FirstClassDiskInfo
+	// Scanned is the number of datastores and datastore clusters whose FCDs
+	// were listed
+	Scanned int
+	// Skipped is the number of the ones excluded by the filter of the scan
+	Skipped int
+	// Failed are the errors of the ones whose FCDs could not be listed, by
+	// name
+	Failed map[string]error
+}
+
+// ScanFirstClassDisks lists the FCDs of the datastore clusters and the
+// datastores of the datacenter that allowed returns true for, or of all of
+// them when allowed is nil, running up to workers listings at a time. The
+// datastores of an allowed datastore cluster are listed along with it. A
+// datastore, or datastore cluster, whose FCDs cannot be listed, such as a
+// disconnected one, is recorded in the Failed of the scan rather than
+// failing it. An error is only returned when the datastores of the
+// datacenter cannot be retrieved.
+func (dc *Datacenter) ScanFirstClassDisks(ctx context.Context,
+	allowed func(name string) bool, workers int) (*FirstClassDiskScan, error) {
+
+	storagePods, err := dc.GetAllDatastoreClusters(ctx, true)
+	if err != nil && err != ErrNoDataStoreClustersFound {
+		klog.Errorf("GetAllDatastoreClusters failed. Err: %v", err)
+		return nil, err
+	}
+
+	datastores, err := dc.GetAllDatastores(ctx)
+	if err != nil {
+		klog.Errorf("GetAllDatastores failed. Err: %v", err)
+		return nil, err
+	}
+
+	scan := &FirstClassDiskScan{Failed: make(map[string]error)}
+	type listing struct {
+		name string
+		list func() ([]*FirstClassDiskInfo, error)
+	}
+	var listings []listing
+
+	visited := make(map[string]bool)
+	for _, storagePod := range storagePods {
+		storagePod := storagePod
+		name := storagePod.Summary.Name
+		if allowed != nil && !allowed(name) {
+			scan.Skipped++
+			continue
+		}
+		if err := storagePod.PopulateChildDatastoreInfos(ctx, false); err != nil {
+			// its datastores are listed on their own
+			scan.Failed[name] = err
+			continue
+		}
+		for _, datastore := range storagePod.DatastoreInfos {
+			visited[datastore.Info.Name] = true
+		}
+		listings = append(listings, listing{name, func() ([]*FirstClassDiskInfo, error) {
+			return storagePod.ListFirstClassDisksInfo(ctx)
+		}})
+	}
+	for _, datastore := range datastores {
+		datastore := datastore
+		name := datastore.Info.Name
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		if allowed != nil && !allowed(name) {
+			scan.Skipped++
+			continue
+		}
+		listings = append(listings, listing{name, func() ([]*FirstClassDiskInfo, error) {
+			return datastore.ListFirstClassDiskInfos(ctx)
+		}})
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([][]*FirstClassDiskInfo, len(listings))
+	errs := make([]error, len(listings))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range listings {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = listings[i].list()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, listing := range listings {
+		if errs[i] != nil {
+			scan.Failed[listing.name] = errs[i]
+			continue
+		}
+		scan.Scanned++
+		scan.FirstClassDisks = append(scan.FirstClassDisks, results[i]...)
+	}
+
+	return scan, nil
+}
+
 // DoesFirstClassDiskExist returns information about an FCD if it exists.
 func (dc *Datacenter) DoesFirstClassDiskExist(ctx context.Context, fcdID string) (*FirstClassDiskInfo, error) {
 	datastores, err := dc.GetAllDatastores(ctx)
@@ -616,31 +1121,68 @@ func (dc *Datacenter) DoesFirstClassDiskExist(ctx context.Context, fcdID string)
 	return nil, ErrNoDiskIDFound
 }
 
-// DeleteFirstClassDisk deletes an FCD.
-func (dc *Datacenter) DeleteFirstClassDisk(ctx context.Context,
-	datastoreName string, datastoreType ParentDatastoreType, diskID string) error {
+// GetBackingDatastore returns the datastore that owns the backing of an
+// FCD, as reported by the FCD itself. It is not the datastore the FCD was
+// found on once the FCD was migrated to another datastore, such as by
+// Storage DRS when the datastore entered maintenance mode.
+func (dc *Datacenter) GetBackingDatastore(ctx context.Context, fcd *FirstClassDiskInfo) (*DatastoreInfo, error) {
+	backing, ok := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo)
+	if !ok || len(backing.Datastore.Value) == 0 {
+		return fcd.DatastoreInfo, nil
+	}
+	if fcd.DatastoreInfo != nil && fcd.DatastoreInfo.Reference() == backing.Datastore {
+		return fcd.DatastoreInfo, nil
+	}
+
+	var dsMo mo.Datastore
+	pc := property.DefaultCollector(dc.Client())
+	err := pc.RetrieveOne(ctx, backing.Datastore, []string{DatastoreInfoProperty}, &dsMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve the info of datastore %s. err: %v", backing.Datastore.Value, err)
+		return nil, err
+	}
+	return &DatastoreInfo{
+		&Datastore{object.NewDatastore(dc.Client(), backing.Datastore), dc},
+		dsMo.Info.GetDatastoreInfo(),
+	}, nil
+}
+
+// getFirstClassDiskDatastore returns the reference of the datastore that
+// owns an FCD. When the parent is a datastore cluster, the child datastore
+// that owns the disk is returned.
+func (dc *Datacenter) getFirstClassDiskDatastore(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType, diskID string) (types.ManagedObjectReference, error) {
 
-	var ds types.ManagedObjectReference
 	if datastoreType == TypeDatastoreCluster {
 		storagePod, err := dc.GetDatastoreClusterByName(ctx, datastoreName)
 		if err != nil {
 			klog.Errorf("GetDatastoreClusterByName failed. Err: %v", err)
-			return err
+			return types.ManagedObjectReference{}, err
 		}
 
 		datastore, err := storagePod.GetDatastoreThatOwnsFCD(ctx, diskID)
 		if err != nil {
 			klog.Errorf("GetDatastoreThatOwnsFCD failed. Err: %v", err)
-			return err
+			return types.ManagedObjectReference{}, err
 		}
-		ds = datastore.Reference()
-	} else {
-		datastore, err := dc.GetDatastoreByName(ctx, datastoreName)
-		if err != nil {
-			klog.Errorf("GetDatastoreByName failed. Err: %v", err)
-			return err
-		}
-		ds = datastore.Reference()
+		return datastore.Reference(), nil
+	}
+
+	datastore, err := dc.GetDatastoreByName(ctx, datastoreName)
+	if err != nil {
+		klog.Errorf("GetDatastoreByName failed. Err: %v", err)
+		return types.ManagedObjectReference{}, err
+	}
+	return datastore.Reference(), nil
+}
+
+// DeleteFirstClassDisk deletes an FCD.
+func (dc *Datacenter) DeleteFirstClassDisk(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType, diskID string) error {
+
+	ds, err := dc.getFirstClassDiskDatastore(ctx, datastoreName, datastoreType, diskID)
+	if err != nil {
+		return err
 	}
 
 	m := vslm.NewObjectManager(dc.Client())
@@ -651,7 +1193,177 @@ func (dc *Datacenter) DeleteFirstClassDisk(ctx context.Context,
 		return err
 	}
 
-	err = task.Wait(ctx)
+	_, err = waitForTask(ctx, dc.Client(), task.Reference())
+	if err != nil {
+		klog.Errorf("Wait(%s) failed. Err: %v", diskID, err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateFirstClassDiskSnapshot creates a snapshot of an FCD and returns the
+// newly created snapshot.
+func (dc *Datacenter) CreateFirstClassDiskSnapshot(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskID string, description string) (*types.VStorageObjectSnapshotInfoVStorageObjectSnapshot, error) {
+
+	ds, err := dc.getFirstClassDiskDatastore(ctx, datastoreName, datastoreType, diskID)
+	if err != nil {
+		return nil, err
+	}
+
+	m := vslm.NewObjectManager(dc.Client())
+
+	task, err := m.CreateSnapshot(ctx, ds, diskID, description)
+	if err != nil {
+		klog.Errorf("CreateSnapshot(%s) failed. Err: %v", diskID, err)
+		return nil, err
+	}
+
+	taskInfo, err := waitForTask(ctx, dc.Client(), task.Reference())
+	if err != nil {
+		klog.Errorf("WaitForResult(%s) failed. Err: %v", diskID, err)
+		return nil, err
+	}
+
+	var snapshotID string
+	switch id := taskInfo.Result.(type) {
+	case types.ID:
+		snapshotID = id.Id
+	case *types.ID:
+		snapshotID = id.Id
+	default:
+		klog.Errorf("CreateSnapshot(%s) returned unexpected result %T", diskID, taskInfo.Result)
+		return nil, ErrNoSnapshotFound
+	}
+
+	snapshotInfo, err := m.RetrieveSnapshotInfo(ctx, ds, diskID)
+	if err != nil {
+		klog.Errorf("RetrieveSnapshotInfo(%s) failed. Err: %v", diskID, err)
+		return nil, err
+	}
+
+	for i := range snapshotInfo.Snapshots {
+		snapshot := &snapshotInfo.Snapshots[i]
+		if snapshot.Id != nil && snapshot.Id.Id == snapshotID {
+			return snapshot, nil
+		}
+	}
+
+	klog.Errorf("Snapshot %s of FCD %s not found after creation", snapshotID, diskID)
+	return nil, ErrNoSnapshotFound
+}
+
+// ListFirstClassDiskSnapshots returns all of the snapshots of an FCD.
+func (dc *Datacenter) ListFirstClassDiskSnapshots(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskID string) ([]types.VStorageObjectSnapshotInfoVStorageObjectSnapshot, error) {
+
+	ds, err := dc.getFirstClassDiskDatastore(ctx, datastoreName, datastoreType, diskID)
+	if err != nil {
+		return nil, err
+	}
+
+	m := vslm.NewObjectManager(dc.Client())
+
+	snapshotInfo, err := m.RetrieveSnapshotInfo(ctx, ds, diskID)
+	if err != nil {
+		klog.Errorf("RetrieveSnapshotInfo(%s) failed. Err: %v", diskID, err)
+		return nil, err
+	}
+
+	return snapshotInfo.Snapshots, nil
+}
+
+// DeleteFirstClassDiskSnapshot deletes a snapshot of an FCD.
+func (dc *Datacenter) DeleteFirstClassDiskSnapshot(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskID string, snapshotID string) error {
+
+	ds, err := dc.getFirstClassDiskDatastore(ctx, datastoreName, datastoreType, diskID)
+	if err != nil {
+		return err
+	}
+
+	m := vslm.NewObjectManager(dc.Client())
+
+	task, err := m.DeleteSnapshot(ctx, ds, diskID, snapshotID)
+	if err != nil {
+		klog.Errorf("DeleteSnapshot(%s) failed. Err: %v", snapshotID, err)
+		return err
+	}
+
+	_, err = waitForTask(ctx, dc.Client(), task.Reference())
+	if err != nil {
+		klog.Errorf("Wait(%s) failed. Err: %v", snapshotID, err)
+		return err
+	}
+
+	return nil
+}
+
+// ExtendFirstClassDisk grows an FCD to the provided capacity. When the
+// context is done first, the task is left running and a
+// *TaskInProgressError is returned.
+func (dc *Datacenter) ExtendFirstClassDisk(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskID string, capacityInMB int64) error {
+
+	ds, err := dc.getFirstClassDiskDatastore(ctx, datastoreName, datastoreType, diskID)
+	if err != nil {
+		return err
+	}
+
+	req := types.ExtendDisk_Task{
+		This:            *dc.Client().ServiceContent.VStorageObjectManager,
+		Id:              types.ID{Id: diskID},
+		Datastore:       ds,
+		NewCapacityInMB: capacityInMB,
+	}
+
+	res, err := methods.ExtendDisk_Task(ctx, dc.Client(), &req)
+	if err != nil {
+		klog.Errorf("ExtendDisk(%s) failed. Err: %v", diskID, err)
+		return err
+	}
+
+	err = dc.WaitForTask(ctx, res.Returnval)
+	if err != nil {
+		klog.Errorf("Wait(%s) failed. Err: %v", diskID, err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateFirstClassDiskPolicy assigns the storage policy with the provided
+// profile ID to an FCD and waits until the FCD is reconfigured.
+func (dc *Datacenter) UpdateFirstClassDiskPolicy(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskID string, profileID string) error {
+
+	ds, err := dc.getFirstClassDiskDatastore(ctx, datastoreName, datastoreType, diskID)
+	if err != nil {
+		return err
+	}
+
+	req := types.UpdateVStorageObjectPolicy_Task{
+		This:      *dc.Client().ServiceContent.VStorageObjectManager,
+		Id:        types.ID{Id: diskID},
+		Datastore: ds,
+		Profile: []types.BaseVirtualMachineProfileSpec{
+			&types.VirtualMachineDefinedProfileSpec{ProfileId: profileID},
+		},
+	}
+
+	res, err := methods.UpdateVStorageObjectPolicy_Task(ctx, dc.Client(), &req)
+	if err != nil {
+		klog.Errorf("UpdateVStorageObjectPolicy(%s) failed. Err: %v", diskID, err)
+		return err
+	}
+
+	_, err = waitForTask(ctx, dc.Client(), res.Returnval)
 	if err != nil {
 		klog.Errorf("Wait(%s) failed. Err: %v", diskID, err)
 		return err
@@ -659,3 +1371,87 @@ func (dc *Datacenter) DeleteFirstClassDisk(ctx context.Context,
 
 	return nil
 }
+
+// CloneFirstClassDisk creates a new FCD from the contents of an existing
+// FCD in the same vCenter. When the context is done first, the task is left
+// running and a *TaskInProgressError is returned.
+func (dc *Datacenter) CloneFirstClassDisk(ctx context.Context,
+	srcDatastoreName string, srcDatastoreType ParentDatastoreType, srcDiskID string,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskName string, diskSize int64) error {
+
+	srcDs, err := dc.getFirstClassDiskDatastore(ctx, srcDatastoreName, srcDatastoreType, srcDiskID)
+	if err != nil {
+		return err
+	}
+
+	m := vslm.NewObjectManager(dc.Client())
+
+	spec := types.VslmCreateSpec{
+		Name:         diskName,
+		CapacityInMB: diskSize,
+	}
+
+	err = dc.placeFirstClassDisk(ctx, m, datastoreName, datastoreType, &spec,
+		string(types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeThin))
+	if err != nil {
+		return err
+	}
+
+	cloneSpec := types.VslmCloneSpec{
+		Name: diskName,
+		VslmMigrateSpec: types.VslmMigrateSpec{
+			BackingSpec: spec.BackingSpec,
+		},
+	}
+
+	task, err := m.Clone(ctx, srcDs, srcDiskID, cloneSpec)
+	if err != nil {
+		klog.Errorf("Clone(%s) failed. Err: %v", srcDiskID, err)
+		return err
+	}
+
+	err = dc.WaitForTask(ctx, task.Reference())
+	if err != nil {
+		klog.Errorf("Wait(%s) failed. Err: %v", diskName, err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateFirstClassDiskFromSnapshot creates a new FCD from a snapshot of an
+// existing FCD. The new FCD is created on the datastore of the source FCD.
+// When the context is done first, the task is left running and a
+// *TaskInProgressError is returned.
+func (dc *Datacenter) CreateFirstClassDiskFromSnapshot(ctx context.Context,
+	datastoreName string, datastoreType ParentDatastoreType,
+	diskID string, snapshotID string, diskName string) error {
+
+	ds, err := dc.getFirstClassDiskDatastore(ctx, datastoreName, datastoreType, diskID)
+	if err != nil {
+		return err
+	}
+
+	req := types.CreateDiskFromSnapshot_Task{
+		This:       *dc.Client().ServiceContent.VStorageObjectManager,
+		Id:         types.ID{Id: diskID},
+		Datastore:  ds,
+		SnapshotId: types.ID{Id: snapshotID},
+		Name:       diskName,
+	}
+
+	res, err := methods.CreateDiskFromSnapshot_Task(ctx, dc.Client(), &req)
+	if err != nil {
+		klog.Errorf("CreateDiskFromSnapshot(%s) failed. Err: %v", snapshotID, err)
+		return err
+	}
+
+	err = dc.WaitForTask(ctx, res.Returnval)
+	if err != nil {
+		klog.Errorf("Wait(%s) failed. Err: %v", diskName, err)
+		return err
+	}
+
+	return nil
+}
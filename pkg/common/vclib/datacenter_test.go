@@ -69,6 +69,17 @@ func TestDatacenter(t *testing.T) {
 		t.Error(err)
 	}
 
+	_, err = dc.GetVMByIP(ctx, "192.0.2.1")
+	if err != ErrNoVMFound {
+		t.Errorf("expected %v: %v", ErrNoVMFound, err)
+	}
+
+	avm.Guest.IpAddress = "10.0.0.10"
+	_, err = dc.GetVMByIP(ctx, avm.Guest.IpAddress)
+	if err != nil {
+		t.Error(err)
+	}
+
 	_, err = dc.GetVMByPath(ctx, testNameNotFound)
 	if err == nil {
 		t.Error("expected error")
@@ -177,3 +188,70 @@ func TestDatacenter(t *testing.T) {
 		t.Errorf("%s should be attached", diskPath)
 	}
 }
+
+func TestScanFirstClassDisks(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	model.Datastore = 3
+
+	defer model.Remove()
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc := &VSphereConnection{Client: c.Client}
+
+	dc, err := GetDatacenter(ctx, vc, TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	datastores, err := dc.GetAllDatastores(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datastores) != 3 {
+		t.Fatalf("3 datastores expected: %d", len(datastores))
+	}
+	var skipped string
+	for _, ds := range datastores {
+		if err = dc.CreateFirstClassDisk(ctx, ds.Info.Name, TypeDatastore, "disk-"+ds.Info.Name, 1); err != nil {
+			t.Fatal(err)
+		}
+		skipped = ds.Info.Name
+	}
+
+	for _, workers := range []int{0, 1, 8} {
+		scan, err := dc.ScanFirstClassDisks(ctx, nil, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scan.Scanned != 3 || scan.Skipped != 0 || len(scan.Failed) != 0 || len(scan.FirstClassDisks) != 3 {
+			t.Errorf("workers=%d: all of the FCDs of the 3 datastores should be scanned: %+v", workers, scan)
+		}
+	}
+
+	// the datastores the filter excludes are skipped
+	scan, err := dc.ScanFirstClassDisks(ctx, func(name string) bool { return name != skipped }, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.Scanned != 2 || scan.Skipped != 1 || len(scan.FirstClassDisks) != 2 {
+		t.Errorf("datastore %s should be skipped: %+v", skipped, scan)
+	}
+	for _, fcd := range scan.FirstClassDisks {
+		if fcd.DatastoreInfo.Info.Name == skipped {
+			t.Errorf("FCD %s of datastore %s should be skipped", fcd.Config.Name, skipped)
+		}
+	}
+}
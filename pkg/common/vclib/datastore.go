@@ -45,6 +45,23 @@ func (di DatastoreInfo) String() string {
 	return fmt.Sprintf("Datastore: %+v, datastore URL: %s", di.Datastore, di.Info.Url)
 }
 
+// GetHosts returns the hosts that mount the datastore.
+func (ds *Datastore) GetHosts(ctx context.Context) ([]types.ManagedObjectReference, error) {
+	var dsMo mo.Datastore
+	pc := property.DefaultCollector(ds.Client())
+	err := pc.RetrieveOne(ctx, ds.Datastore.Reference(), []string{DatastoreHostProperty}, &dsMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve the hosts of datastore %s. Err: %v", ds.Reference(), err)
+		return nil, err
+	}
+
+	hosts := make([]types.ManagedObjectReference, 0, len(dsMo.Host))
+	for _, mount := range dsMo.Host {
+		hosts = append(hosts, mount.Key)
+	}
+	return hosts, nil
+}
+
 // CreateDirectory creates the directory at location specified by directoryPath.
 // If the intermediate level folders do not exist, and the parameter createParents is true, all the non-existent folders are created.
 // directoryPath must be in the format "[vsanDatastore] kubevols"
@@ -88,6 +105,19 @@ func (ds *Datastore) GetName(ctx context.Context) (string, error) {
 	return dsMo.Info.GetDatastoreInfo().Name, nil
 }
 
+// GetSummary returns the summary of the datastore, which tells whether it
+// is accessible and whether it is in maintenance mode.
+func (ds *Datastore) GetSummary(ctx context.Context) (*types.DatastoreSummary, error) {
+	var dsMo mo.Datastore
+	pc := property.DefaultCollector(ds.Client())
+	err := pc.RetrieveOne(ctx, ds.Datastore.Reference(), []string{"summary"}, &dsMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve datastore summary property. err: %v", err)
+		return nil, err
+	}
+	return &dsMo.Summary, nil
+}
+
 // IsCompatibleWithStoragePolicy returns true if datastore is compatible with given storage policy else return false
 // for not compatible datastore, fault message is also returned
 func (ds *Datastore) IsCompatibleWithStoragePolicy(ctx context.Context, storagePolicyID string) (bool, string, error) {
@@ -226,3 +256,113 @@ func (di *DatastoreInfo) GetFirstClassDiskInfo(ctx context.Context, diskID strin
 
 	return nil, ErrNoDiskIDFound
 }
+
+// RegisterFirstClassDisk registers an existing virtual disk of this
+// datastore, such as a disk created by the in-tree vSphere volume plugin, as
+// an FCD named name. A disk that is already an FCD is returned as is, and
+// ErrNoDiskIDFound is returned when the disk does not exist.
+func (di *DatastoreInfo) RegisterFirstClassDisk(ctx context.Context, diskPath string, name string) (*FirstClassDiskInfo, error) {
+	filePath := (&object.DatastorePath{Datastore: di.Info.Name, Path: diskPath}).String()
+
+	disks, err := di.ListFirstClassDiskInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, disk := range disks {
+		backing, ok := disk.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo)
+		if ok && backing.FilePath == filePath {
+			return disk, nil
+		}
+	}
+
+	if _, err := di.Stat(ctx, diskPath); err != nil {
+		switch err.(type) {
+		case object.DatastoreNoSuchFileError, object.DatastoreNoSuchDirectoryError:
+			return nil, ErrNoDiskIDFound
+		}
+		klog.Errorf("Failed to stat disk %s. Err: %v", filePath, err)
+		return nil, err
+	}
+
+	m := vslm.NewObjectManager(di.Datacenter.Client())
+
+	o, err := m.RegisterDisk(ctx, di.NewURL(diskPath).String(), name)
+	if err != nil {
+		klog.Errorf("RegisterDisk(%s) failed. Err: %v", filePath, err)
+		return nil, err
+	}
+
+	return &FirstClassDiskInfo{
+		&FirstClassDisk{
+			di.Datacenter,
+			o,
+			TypeDatastore,
+			di.Datastore,
+			nil,
+		},
+		di,
+		nil,
+	}, nil
+}
+
+// GetFirstClassDiskInfoByID gets a specific first class disk (FCD) on this
+// datastore by its ID without listing all of the disks on the datastore
+func (di *DatastoreInfo) GetFirstClassDiskInfoByID(ctx context.Context, diskID string) (*FirstClassDiskInfo, error) {
+	m := vslm.NewObjectManager(di.Datacenter.Client())
+
+	o, err := m.Retrieve(ctx, di.Reference(), diskID)
+	if err != nil {
+		klog.Errorf("Failed to retrieve disk %s. Err: %v", diskID, err)
+		return nil, err
+	}
+
+	return &FirstClassDiskInfo{
+		&FirstClassDisk{
+			di.Datacenter,
+			o,
+			TypeDatastore,
+			di.Datastore,
+			nil,
+		},
+		di,
+		nil,
+	}, nil
+}
+
+// GetVMsWithDisk returns the VMs that have the disk specified by diskPath,
+// a file on this datastore, attached.
+func (ds *Datastore) GetVMsWithDisk(ctx context.Context, diskPath string) ([]*VirtualMachine, error) {
+	var dsMo mo.Datastore
+	pc := property.DefaultCollector(ds.Client())
+	err := pc.RetrieveOne(ctx, ds.Datastore.Reference(), []string{"vm"}, &dsMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve datastore vm property. err: %v", err)
+		return nil, err
+	}
+	if len(dsMo.Vm) == 0 {
+		return nil, nil
+	}
+
+	var vmMoList []mo.VirtualMachine
+	err = pc.Retrieve(ctx, dsMo.Vm, []string{"config.hardware.device"}, &vmMoList)
+	if err != nil {
+		klog.Errorf("Failed to retrieve the devices of the VMs on datastore. err: %v", err)
+		return nil, err
+	}
+
+	diskPath = RemoveStorageClusterORFolderNameFromVDiskPath(diskPath)
+	var vms []*VirtualMachine
+	for _, vmMo := range vmMoList {
+		if vmMo.Config == nil {
+			continue
+		}
+		for _, device := range object.VirtualDeviceList(vmMo.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil)) {
+			backing, ok := device.GetVirtualDevice().Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+			if ok && matchVirtualDiskAndVolPath(backing.FileName, diskPath) {
+				vms = append(vms, &VirtualMachine{object.NewVirtualMachine(ds.Client(), vmMo.Reference()), ds.Datacenter})
+				break
+			}
+		}
+	}
+	return vms, nil
+}
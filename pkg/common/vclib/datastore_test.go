@@ -57,6 +57,27 @@ func TestDatastore(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// the disk of a VM is found on its datastore
+	vm, err := dc.GetVMByPath(ctx, TestDefaultDatacenter+"/vm/DC0_H0_VM0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskPath, err := vm.GetVirtualDiskPath(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsInfo, err := dc.GetDatastoreByPath(ctx, diskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vms, err := dsInfo.GetVMsWithDisk(ctx, diskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 1 || vms[0].Reference() != vm.Reference() {
+		t.Errorf("GetVMsWithDisk(%s) should return %s: %v", diskPath, vm.Reference(), vms)
+	}
+
 	for _, info := range all {
 		ds := info.Datastore
 		kind, cerr := ds.GetType(ctx)
@@ -74,6 +95,14 @@ func TestDatastore(t *testing.T) {
 
 		// TODO: test Datastore.IsCompatibleWithStoragePolicy (vcsim needs PBM support)
 
+		vms, cerr := ds.GetVMsWithDisk(ctx, "["+info.Info.Name+"] enoent/enoent.vmdk")
+		if cerr != nil {
+			t.Error(cerr)
+		}
+		if len(vms) != 0 {
+			t.Errorf("no VM should have a missing disk attached: %v", vms)
+		}
+
 		for _, fail := range []bool{false, true} {
 			cerr = ds.CreateDirectory(ctx, dir.String(), false)
 			if fail {
@@ -0,0 +1,153 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// methodFault returns a pointer to the vSphere fault of err, whether err is
+// a SOAP fault, a vim fault or the error of a task, or nil when err has no
+// vSphere fault. The error of a *TaskError is looked into.
+func methodFault(err error) interface{} {
+	if terr, ok := err.(*TaskError); ok {
+		err = terr.Err
+	}
+
+	var fault interface{}
+	switch {
+	case err == nil:
+		return nil
+	case soap.IsSoapFault(err):
+		fault = soap.ToSoapFault(err).VimFault()
+	case soap.IsVimFault(err):
+		fault = soap.ToVimFault(err)
+	default:
+		if terr, ok := err.(task.Error); ok && terr.LocalizedMethodFault != nil {
+			fault = terr.Fault()
+		}
+	}
+	if fault == nil {
+		return nil
+	}
+
+	// the faults of the SOAP responses are decoded as values
+	if v := reflect.ValueOf(fault); v.Kind() != reflect.Ptr {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		fault = p.Interface()
+	}
+	return fault
+}
+
+// httpStatus returns true when err reports the HTTP status code of a failed
+// call to the SOAP endpoint, "503 Service Unavailable", or to the vAPI
+// endpoint of a vCenter, "POST <url>: 503 Service Unavailable".
+func httpStatus(err error, code string) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, code+" ") || strings.Contains(msg, ": "+code+" ")
+}
+
+// IsTransient returns true when err is likely to go away when the operation
+// is retried: a TaskInProgress fault, an InvalidState fault while a host
+// fails over, a temporary network error, a connection reset by the vCenter,
+// or a 503 while the vCenter or its vAPI endpoint is busy or restarting.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if methodFault(err) != nil {
+		return IsTransientFault(err)
+	}
+
+	if terr, ok := err.(*TaskError); ok {
+		err = terr.Err
+	}
+
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	switch err {
+	case context.Canceled, context.DeadlineExceeded:
+		return false
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+		return true
+	}
+
+	msg := err.Error()
+	return httpStatus(err, "503") ||
+		strings.Contains(msg, "connection reset by peer") ||
+		strings.Contains(msg, "broken pipe")
+}
+
+// IsTransientFault returns true when err is one of the transient vSphere
+// faults, TaskInProgress or InvalidState, that vSphere raises instead of
+// carrying out an operation. Unlike the other transient errors, such as a
+// connection reset, they tell that the operation was not carried out.
+func IsTransientFault(err error) bool {
+	switch methodFault(err).(type) {
+	case *types.TaskInProgress, *types.VAppTaskInProgress, *types.InvalidState:
+		return true
+	}
+	return false
+}
+
+// IsRateLimited returns true when err is a 429 of a vCenter that limits the
+// rate of its logins.
+func IsRateLimited(err error) bool {
+	return err != nil && httpStatus(err, "429")
+}
+
+// IsNotFound returns true when err is a NotFoundError or a DefaultNotFoundError
+// of the finder, or a NotFound, ManagedObjectNotFound or FileNotFound fault.
+func IsNotFound(err error) bool {
+	switch err.(type) {
+	case *find.NotFoundError, *find.DefaultNotFoundError:
+		return true
+	}
+	switch methodFault(err).(type) {
+	case *types.NotFound, *types.ManagedObjectNotFound, *types.FileNotFound:
+		return true
+	}
+	return false
+}
+
+// IsPermission returns true when err is a NoPermission, NotAuthenticated or
+// InvalidLogin fault, or a 401 or 403 of the vAPI endpoint.
+func IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch methodFault(err).(type) {
+	case *types.NoPermission, *types.NotAuthenticated, *types.InvalidLogin:
+		return true
+	}
+	return httpStatus(err, "401") || httpStatus(err, "403")
+}
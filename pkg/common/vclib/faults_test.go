@@ -0,0 +1,162 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// soapFault returns the SOAP fault of a failed call with the vim fault.
+func soapFault(fault types.AnyType) error {
+	f := &soap.Fault{}
+	f.Detail.Fault = fault
+	return soap.WrapSoapFault(f)
+}
+
+// taskError returns the error of a failed task with the vim fault.
+func taskError(fault types.BaseMethodFault) error {
+	return task.Error{LocalizedMethodFault: &types.LocalizedMethodFault{Fault: fault}}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("503 Service Unavailable"), true},
+		{errors.New("POST https://vc/rest/com/vmware/cis/tagging/tag: 503 Service Unavailable"), true},
+		{errors.New("500 Internal Server Error"), false},
+		{&url.Error{Op: "Post", URL: "https://vc/sdk", Err: io.EOF}, true},
+		{&url.Error{Op: "Post", URL: "https://vc/sdk", Err: syscall.ECONNRESET}, true},
+		{&url.Error{Op: "Post", URL: "https://vc/sdk", Err: context.DeadlineExceeded}, false},
+		{soap.WrapVimFault(&types.TaskInProgress{}), true},
+		{soap.WrapVimFault(&types.NotFound{}), false},
+		{soapFault(types.TaskInProgress{}), true},
+		{soapFault(types.InvalidState{}), true},
+		{taskError(&types.InvalidState{}), true},
+		{taskError(&types.TaskInProgress{}), true},
+		{taskError(&types.InvalidPowerState{}), false},
+		{taskError(&types.NoPermission{}), false},
+		{&TaskError{Task: types.ManagedObjectReference{Type: "Task", Value: "task-1"}, Err: taskError(&types.InvalidState{})}, true},
+		{&TaskError{Task: types.ManagedObjectReference{Type: "Task", Value: "task-1"}, Err: io.EOF}, true},
+	}
+
+	for _, test := range tests {
+		if transient := IsTransient(test.err); transient != test.transient {
+			t.Errorf("IsTransient(%v) should be %v", test.err, test.transient)
+		}
+	}
+}
+
+func TestIsTransientFault(t *testing.T) {
+	tests := []struct {
+		err   error
+		fault bool
+	}{
+		{nil, false},
+		{errors.New("503 Service Unavailable"), false},
+		{&url.Error{Op: "Post", URL: "https://vc/sdk", Err: io.EOF}, false},
+		{&url.Error{Op: "Post", URL: "https://vc/sdk", Err: syscall.ECONNRESET}, false},
+		{soap.WrapVimFault(&types.TaskInProgress{}), true},
+		{soapFault(types.InvalidState{}), true},
+		{taskError(&types.InvalidState{}), true},
+		{taskError(&types.InvalidPowerState{}), false},
+		{&TaskError{Task: types.ManagedObjectReference{Type: "Task", Value: "task-1"}, Err: io.EOF}, false},
+	}
+
+	for _, test := range tests {
+		if fault := IsTransientFault(test.err); fault != test.fault {
+			t.Errorf("IsTransientFault(%v) should be %v", test.err, test.fault)
+		}
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	tests := []struct {
+		err     error
+		limited bool
+	}{
+		{nil, false},
+		{errors.New("429 Too Many Requests"), true},
+		{errors.New("POST https://vc/sdk: 429 Too Many Requests"), true},
+		{errors.New("503 Service Unavailable"), false},
+		{soap.WrapVimFault(&types.InvalidLogin{}), false},
+	}
+
+	for _, test := range tests {
+		if limited := IsRateLimited(test.err); limited != test.limited {
+			t.Errorf("IsRateLimited(%v) should be %v", test.err, test.limited)
+		}
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		err      error
+		notFound bool
+	}{
+		{nil, false},
+		{errors.New("not found"), false},
+		{&find.NotFoundError{}, true},
+		{&find.DefaultNotFoundError{}, true},
+		{soap.WrapVimFault(&types.ManagedObjectNotFound{}), true},
+		{soapFault(types.NotFound{}), true},
+		{taskError(&types.FileNotFound{}), true},
+		{taskError(&types.TaskInProgress{}), false},
+	}
+
+	for _, test := range tests {
+		if notFound := IsNotFound(test.err); notFound != test.notFound {
+			t.Errorf("IsNotFound(%v) should be %v", test.err, test.notFound)
+		}
+	}
+}
+
+func TestIsPermission(t *testing.T) {
+	tests := []struct {
+		err        error
+		permission bool
+	}{
+		{nil, false},
+		{errors.New("500 Internal Server Error"), false},
+		{errors.New("POST https://vc/rest/com/vmware/cis/session: 401 Unauthorized"), true},
+		{errors.New("GET https://vc/rest/com/vmware/cis/tagging/tag: 403 Forbidden"), true},
+		{soapFault(types.NoPermission{}), true},
+		{soap.WrapVimFault(&types.NotAuthenticated{}), true},
+		{soap.WrapVimFault(&types.InvalidLogin{}), true},
+		{taskError(&types.NoPermission{}), true},
+		{taskError(&types.InvalidState{}), false},
+		{&TaskError{Task: types.ManagedObjectReference{Type: "Task", Value: "task-1"}, Err: taskError(&types.NoPermission{})}, true},
+	}
+
+	for _, test := range tests {
+		if permission := IsPermission(test.err); permission != test.permission {
+			t.Errorf("IsPermission(%v) should be %v", test.err, test.permission)
+		}
+	}
+}
@@ -20,6 +20,24 @@ import (
 	"github.com/vmware/govmomi/vim25/types"
 )
 
+// FCDMinAPIVersion is the first vCenter API version that manages FCDs.
+const FCDMinAPIVersion = "6.5"
+
+// FCDSnapshotsMinAPIVersion is the first vCenter API version whose FCD
+// snapshots are supported.
+const FCDSnapshotsMinAPIVersion = "6.7.3"
+
+// OnlineExtendMinAPIVersion is the first vCenter API version that extends
+// the FCDs attached to a VM. The FCDs of the older vCenters must be detached
+// before they are extended.
+const OnlineExtendMinAPIVersion = "7.0.2"
+
+// IsOnlineExtendSupported returns true when a vCenter API version extends
+// the FCDs attached to a VM.
+func IsOnlineExtendSupported(apiVersion string) bool {
+	return apiVersionAtLeast(apiVersion, OnlineExtendMinAPIVersion)
+}
+
 // ParentDatastoreType represents the possible parent types of a datastore.
 type ParentDatastoreType string
 
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// HostDatastores are the name of a host and the datastores it can access.
+type HostDatastores struct {
+	Host       types.ManagedObjectReference
+	Name       string
+	Datastores []types.ManagedObjectReference
+}
+
+// Accessible returns true when the host can access the datastore.
+func (h *HostDatastores) Accessible(ds types.ManagedObjectReference) bool {
+	for _, ref := range h.Datastores {
+		if ref == ds {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHostDatastores retrieves the name of the host and the datastores
+// mounted on it with the property collector.
+func GetHostDatastores(ctx context.Context, host *object.HostSystem) (*HostDatastores, error) {
+	var hostMo mo.HostSystem
+	pc := property.DefaultCollector(host.Client())
+	err := pc.RetrieveOne(ctx, host.Reference(), []string{"name", DatastoreProperty}, &hostMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve the datastores of host %s. err: %+v", host.Reference().Value, err)
+		return nil, err
+	}
+	return &HostDatastores{
+		Host:       host.Reference(),
+		Name:       hostMo.Name,
+		Datastores: hostMo.Datastore,
+	}, nil
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// DefaultEncryptionPolicyName is the name of the storage policy vCenter
+// ships to encrypt the VMs and the disks.
+const DefaultEncryptionPolicyName = "VM Encryption Policy"
+
+// HasKmsCluster returns whether a KMS cluster with at least one KMS is
+// configured in vCenter, which encrypting the disks requires.
+func HasKmsCluster(ctx context.Context, client *vim25.Client) (bool, error) {
+	// vCenter has no crypto manager before 6.5
+	if client.ServiceContent.CryptoManager == nil {
+		return false, nil
+	}
+
+	req := types.ListKmipServers{
+		This: *client.ServiceContent.CryptoManager,
+	}
+	res, err := methods.ListKmipServers(ctx, client, &req)
+	if err != nil {
+		klog.Errorf("Failed to list the KMS clusters. err: %v", err)
+		return false, err
+	}
+	for _, cluster := range res.Returnval {
+		if len(cluster.Servers) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// kmsCryptoManager lists fixed KMS clusters, as vcsim has no crypto
+// manager.
+type kmsCryptoManager struct {
+	mo.CryptoManagerKmip
+
+	clusters []types.KmipClusterInfo
+}
+
+func (m *kmsCryptoManager) ListKmipServers(req *types.ListKmipServers) soap.HasFault {
+	return &methods.ListKmipServersBody{
+		Res: &types.ListKmipServersResponse{Returnval: m.clusters},
+	}
+}
+
+func TestHasKmsCluster(t *testing.T) {
+	ctx := context.Background()
+
+	connection, _, cleanup := newSimConnection(t)
+	defer cleanup()
+	client := connection.Client
+
+	// vcsim advertises a crypto manager it does not simulate
+	client.ServiceContent.CryptoManager = nil
+	if ok, err := HasKmsCluster(ctx, client); err != nil || ok {
+		t.Errorf("HasKmsCluster without a crypto manager should be false: %t %v", ok, err)
+	}
+
+	m := &kmsCryptoManager{}
+	m.Self = types.ManagedObjectReference{Type: "CryptoManagerKmip", Value: "CryptoManager"}
+	simulator.Map.Put(m)
+	client.ServiceContent.CryptoManager = &m.Self
+
+	kms := types.KmipClusterInfo{ClusterId: types.KeyProviderId{Id: "kms"}}
+	tests := []struct {
+		clusters []types.KmipClusterInfo
+		expected bool
+	}{
+		{nil, false},
+		// a cluster without servers cannot provide keys
+		{[]types.KmipClusterInfo{kms}, false},
+		{[]types.KmipClusterInfo{kms, {
+			ClusterId: types.KeyProviderId{Id: "kms2"},
+			Servers:   []types.KmipServerInfo{{Name: "kms2-1", Address: "10.0.0.2", Port: 5696}},
+		}}, true},
+	}
+	for _, test := range tests {
+		m.clusters = test.clusters
+		ok, err := HasKmsCluster(ctx, client)
+		if err != nil {
+			t.Fatalf("HasKmsCluster failed: %v", err)
+		}
+		if ok != test.expected {
+			t.Errorf("HasKmsCluster of %v should be %t", test.clusters, test.expected)
+		}
+	}
+}
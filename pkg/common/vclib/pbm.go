@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"github.com/vmware/govmomi/pbm"
+	"github.com/vmware/govmomi/pbm/methods"
 	"k8s.io/klog"
 
 	pbmtypes "github.com/vmware/govmomi/pbm/types"
@@ -32,14 +33,19 @@ type PbmClient struct {
 	*pbm.Client
 }
 
-// NewPbmClient returns a new PBM Client object
+// NewPbmClient returns a new PBM Client object, reached at the storage
+// endpoint of the vCenter when one is configured.
 func NewPbmClient(ctx context.Context, client *vim25.Client) (*PbmClient, error) {
-	pbmClient, err := pbm.NewClient(ctx, client)
+	sc := newStorageServiceClient(client, pbm.Path, pbm.Namespace)
+	req := pbmtypes.PbmRetrieveServiceContent{
+		This: pbm.ServiceInstance,
+	}
+	res, err := methods.PbmRetrieveServiceContent(ctx, sc, &req)
 	if err != nil {
 		klog.Errorf("Failed to create new Pbm Client. err: %+v", err)
 		return nil, err
 	}
-	return &PbmClient{pbmClient}, nil
+	return &PbmClient{&pbm.Client{Client: sc, ServiceContent: res.Returnval}}, nil
 }
 
 // IsDatastoreCompatible check if the datastores is compatible for given storage policy id
@@ -83,6 +89,27 @@ func (pbmClient *PbmClient) IsDatastoreCompatible(ctx context.Context, storagePo
 	return false, "", fmt.Errorf("compatibilityResult is nil or empty")
 }
 
+// GetFirstClassDiskProfileID returns the ID of the storage policy associated
+// with an FCD. It is empty when the FCD has no storage policy.
+func (pbmClient *PbmClient) GetFirstClassDiskProfileID(ctx context.Context, diskID string) (string, error) {
+	req := pbmtypes.PbmQueryAssociatedProfile{
+		This: pbmClient.ServiceContent.ProfileManager,
+		Entity: pbmtypes.PbmServerObjectRef{
+			ObjectType: string(pbmtypes.PbmObjectTypeVirtualDiskUUID),
+			Key:        diskID,
+		},
+	}
+	res, err := methods.PbmQueryAssociatedProfile(ctx, pbmClient, &req)
+	if err != nil {
+		klog.Errorf("Error occurred for PbmQueryAssociatedProfile call. err: %+v", err)
+		return "", err
+	}
+	if len(res.Returnval) == 0 {
+		return "", nil
+	}
+	return res.Returnval[0].UniqueId, nil
+}
+
 // GetCompatibleDatastores filters and returns compatible list of datastores for given storage policy id
 // For Non Compatible Datastores, fault message with the Datastore Name is also returned
 func (pbmClient *PbmClient) GetCompatibleDatastores(ctx context.Context, dc *Datacenter, storagePolicyID string, datastores []*DatastoreInfo) ([]*DatastoreInfo, string, error) {
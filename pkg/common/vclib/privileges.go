@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// MissingPrivileges returns the privileges that the user of the session
+// lacks on each of the entities, out of the privileges required on them.
+// The entities that have all of their required privileges are left out.
+func MissingPrivileges(ctx context.Context, client *vim25.Client,
+	required map[types.ManagedObjectReference][]string) (map[types.ManagedObjectReference][]string, error) {
+
+	userSession, err := session.NewManager(client).UserSession(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get the user session. err: %v", err)
+		return nil, err
+	}
+	if userSession == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+
+	entities := make([]types.ManagedObjectReference, 0, len(required))
+	for entity := range required {
+		entities = append(entities, entity)
+	}
+
+	req := types.FetchUserPrivilegeOnEntities{
+		This:     *client.ServiceContent.AuthorizationManager,
+		Entities: entities,
+		UserName: userSession.UserName,
+	}
+	res, err := methods.FetchUserPrivilegeOnEntities(ctx, client, &req)
+	if err != nil {
+		klog.Errorf("Failed to fetch the privileges of %s. err: %v", userSession.UserName, err)
+		return nil, err
+	}
+
+	granted := make(map[types.ManagedObjectReference]map[string]bool, len(res.Returnval))
+	for _, result := range res.Returnval {
+		privileges := make(map[string]bool, len(result.Privileges))
+		for _, privilege := range result.Privileges {
+			privileges[privilege] = true
+		}
+		granted[result.Entity] = privileges
+	}
+
+	missing := make(map[types.ManagedObjectReference][]string)
+	for entity, privileges := range required {
+		for _, privilege := range privileges {
+			if !granted[entity][privilege] {
+				missing[entity] = append(missing[entity], privilege)
+			}
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// privilegesAuthorizationManager grants fixed privileges on the entities,
+// as vcsim does not implement FetchUserPrivilegeOnEntities.
+type privilegesAuthorizationManager struct {
+	*simulator.AuthorizationManager
+
+	granted map[types.ManagedObjectReference][]string
+}
+
+func (m *privilegesAuthorizationManager) FetchUserPrivilegeOnEntities(req *types.FetchUserPrivilegeOnEntities) soap.HasFault {
+	res := &types.FetchUserPrivilegeOnEntitiesResponse{}
+	for _, entity := range req.Entities {
+		res.Returnval = append(res.Returnval, types.UserPrivilegeResult{
+			Entity:     entity,
+			Privileges: m.granted[entity],
+		})
+	}
+	return &methods.FetchUserPrivilegeOnEntitiesBody{Res: res}
+}
+
+func TestMissingPrivileges(t *testing.T) {
+	ctx := context.Background()
+
+	connection, _, cleanup := newSimConnection(t)
+	defer cleanup()
+
+	root := connection.Client.ServiceContent.RootFolder
+	dc := simulator.Map.Any("Datacenter").Reference()
+	ref := *connection.Client.ServiceContent.AuthorizationManager
+	simulator.Map.Put(&privilegesAuthorizationManager{
+		AuthorizationManager: simulator.Map.Get(ref).(*simulator.AuthorizationManager),
+		granted: map[types.ManagedObjectReference][]string{
+			root: {"System.Read", "StorageProfile.View"},
+			dc:   {"System.Read"},
+		},
+	})
+
+	missing, err := MissingPrivileges(ctx, connection.Client, map[types.ManagedObjectReference][]string{
+		root: {"StorageProfile.View"},
+		dc:   {"System.Read", "VirtualMachine.Config.AddExistingDisk", "VirtualMachine.Config.RemoveDisk"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[types.ManagedObjectReference][]string{
+		dc: {"VirtualMachine.Config.AddExistingDisk", "VirtualMachine.Config.RemoveDisk"},
+	}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("missing privileges should be %v: %v", expected, missing)
+	}
+}
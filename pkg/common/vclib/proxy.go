@@ -0,0 +1,116 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/klog"
+)
+
+// parseProxyURL parses an HTTP, HTTPS or SOCKS5 proxy URL. The error does not
+// include the URL, which may hold credentials.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return nil, ErrInvalidProxyURL
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return u, nil
+	}
+	return nil, ErrInvalidProxyURL
+}
+
+// redactURL returns the URL with its password, if any, redacted so that it
+// can be logged.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	if u.User == nil {
+		return u.String()
+	}
+	if _, ok := u.User.Password(); !ok {
+		return u.String()
+	}
+	redacted := *u
+	redacted.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return redacted.String()
+}
+
+// proxyFunc returns the function that returns the proxy of a request to
+// the connection's vCenter, or nil when it has no proxy.
+func (connection *VSphereConnection) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if connection.ProxyURL == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := parseProxyURL(connection.ProxyURL)
+	if err != nil {
+		klog.Errorf("Invalid proxy URL for vCenter %s", connection.Hostname)
+		return nil, err
+	}
+
+	proxyConfig := &httpproxy.Config{
+		HTTPProxy:  connection.ProxyURL,
+		HTTPSProxy: connection.ProxyURL,
+		NoProxy:    connection.NoProxy,
+	}
+	proxy := proxyConfig.ProxyFunc()
+
+	klog.V(2).Infof("Connecting to vCenter %s through proxy %s (no proxy: %q)",
+		connection.Hostname, redactURL(proxyURL), connection.NoProxy)
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
+
+// configureProxy routes the requests of the SOAP client of the connection's
+// vCenter through its proxy. The client keeps the proxy of the environment
+// when the vCenter has none.
+func (connection *VSphereConnection) configureProxy(sc *soap.Client) error {
+	proxy, err := connection.proxyFunc()
+	if err != nil || proxy == nil {
+		return err
+	}
+
+	transport, ok := sc.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unexpected SOAP transport %T", sc.Transport)
+	}
+	transport.Proxy = proxy
+	return nil
+}
+
+// InheritProxy sets the proxy of a service client, such as the clients of
+// vslm, PBM, STS or the vAPI, to the one of the SOAP client it was created
+// from. govmomi creates the service clients with a transport of their own,
+// which has the proxy of the environment.
+func InheritProxy(from *soap.Client, to *soap.Client) {
+	src, ok := from.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	if dst, ok := to.Transport.(*http.Transport); ok {
+		dst.Proxy = src.Proxy
+	}
+}
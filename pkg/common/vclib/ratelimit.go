@@ -0,0 +1,184 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/soap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RateLimitRead is the kind of the calls that read the inventory, such
+	// as the property collection.
+	RateLimitRead = "read"
+	// RateLimitWrite is the kind of the other calls, such as the
+	// reconfigurations and the tasks.
+	RateLimitWrite = "write"
+)
+
+// readMethodPrefixes are the prefixes of the names of the vSphere API
+// methods that only read the inventory.
+var readMethodPrefixes = []string{
+	"Retrieve",
+	"ContinueRetrieve",
+	"WaitFor",
+	"CheckForUpdates",
+	"CreateFilter",
+	"DestroyPropertyFilter",
+	"CreatePropertyCollector",
+	"DestroyPropertyCollector",
+	"CreateContainerView",
+	"CreateListView",
+	"DestroyView",
+	"Find",
+	"List",
+	"Query",
+	"SessionIsActive",
+	"CurrentTime",
+}
+
+// vsphereAPIThrottledMetric counts the calls that waited for the rate
+// limiter of their vCenter.
+var vsphereAPIThrottledMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cloudprovider_vsphere_api_throttled_calls",
+		Help: "vsphere api calls delayed by the client-side rate limiter by vCenter and kind",
+	},
+	[]string{"vc", "kind"},
+)
+
+// RateLimits are the rates, in calls per second, and the bursts of the read
+// and the write calls to a vCenter. A zero rate is unlimited, and a zero
+// burst is the rate.
+type RateLimits struct {
+	ReadQPS    uint
+	ReadBurst  uint
+	WriteQPS   uint
+	WriteBurst uint
+}
+
+// RateLimiter limits the rate of the calls to a vCenter with a token bucket
+// for the read calls and another one for the write calls.
+type RateLimiter struct {
+	vc     string
+	limits RateLimits
+	read   *rate.Limiter
+	write  *rate.Limiter
+}
+
+var (
+	rateLimitersLock sync.Mutex
+	// rateLimiters are the rate limiters of the vCenters, by hostname
+	rateLimiters = make(map[string]*RateLimiter)
+)
+
+// RateLimiterFor returns the rate limiter of a vCenter, which is shared by
+// all of its connections, whichever credentials they use. Nil is returned
+// when the rates are unlimited.
+func RateLimiterFor(vc string, limits RateLimits) *RateLimiter {
+	if limits.ReadQPS == 0 && limits.WriteQPS == 0 {
+		return nil
+	}
+
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+
+	if l, ok := rateLimiters[vc]; ok && l.limits == limits {
+		return l
+	}
+	l := &RateLimiter{
+		vc:     vc,
+		limits: limits,
+		read:   newLimiter(limits.ReadQPS, limits.ReadBurst),
+		write:  newLimiter(limits.WriteQPS, limits.WriteBurst),
+	}
+	rateLimiters[vc] = l
+	return l
+}
+
+// newLimiter returns a token bucket of the rate and burst, or nil when the
+// rate is unlimited.
+func newLimiter(qps uint, burst uint) *rate.Limiter {
+	if qps == 0 {
+		return nil
+	}
+	if burst == 0 {
+		burst = qps
+	}
+	return rate.NewLimiter(rate.Limit(qps), int(burst))
+}
+
+// rateLimitKind returns the kind of a vSphere API method.
+func rateLimitKind(method string) string {
+	for _, prefix := range readMethodPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return RateLimitRead
+		}
+	}
+	return RateLimitWrite
+}
+
+// wait waits for a token of the bucket of the kind of the method, or until
+// the context is done.
+func (l *RateLimiter) wait(ctx context.Context, method string) error {
+	kind := rateLimitKind(method)
+	limiter := l.write
+	if kind == RateLimitRead {
+		limiter = l.read
+	}
+	if limiter == nil {
+		return nil
+	}
+
+	r := limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	vsphereAPIThrottledMetric.With(prometheus.Labels{"vc": l.vc, "kind": kind}).Inc()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// rateLimitRoundTripper waits for the rate limiter of its vCenter before
+// each call.
+type rateLimitRoundTripper struct {
+	roundTripper soap.RoundTripper
+	limiter      *RateLimiter
+}
+
+// RoundTrip implements soap.RoundTripper.
+func (r *rateLimitRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	if err := r.limiter.wait(ctx, methodName(req)); err != nil {
+		return err
+	}
+	return r.roundTripper.RoundTrip(ctx, req, res)
+}
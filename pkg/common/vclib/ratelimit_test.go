@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+)
+
+func TestRateLimitKind(t *testing.T) {
+	for method, kind := range map[string]string{
+		"RetrievePropertiesEx":             RateLimitRead,
+		"ContinueRetrievePropertiesEx":     RateLimitRead,
+		"WaitForUpdatesEx":                 RateLimitRead,
+		"FindByUuid":                       RateLimitRead,
+		"ListVStorageObject":               RateLimitRead,
+		"QueryVirtualDiskUuid":             RateLimitRead,
+		"ReconfigVM_Task":                  RateLimitWrite,
+		"AttachDisk_Task":                  RateLimitWrite,
+		"CreateDisk_Task":                  RateLimitWrite,
+		"Login":                            RateLimitWrite,
+		"RetrieveVStorageObject":           RateLimitRead,
+		"DeleteVStorageObject_Task":        RateLimitWrite,
+		"ExtendDisk_Task":                  RateLimitWrite,
+		"AttachTagToVStorageObject":        RateLimitWrite,
+		"ListTagsAttachedToVStorageObject": RateLimitRead,
+	} {
+		if k := rateLimitKind(method); k != kind {
+			t.Errorf("%s should be a %s call, not %s", method, kind, k)
+		}
+	}
+}
+
+func TestRateLimiterFor(t *testing.T) {
+	if l := RateLimiterFor("vc-unlimited", RateLimits{}); l != nil {
+		t.Error("The rate limiter should be nil when the rates are unlimited")
+	}
+
+	limits := RateLimits{ReadQPS: 10, WriteQPS: 2, WriteBurst: 4}
+	l := RateLimiterFor("vc-shared", limits)
+	if l == nil || l.read.Burst() != 10 || l.write.Burst() != 4 {
+		t.Fatalf("Unexpected rate limiter: %+v", l)
+	}
+	if RateLimiterFor("vc-shared", limits) != l {
+		t.Error("The connections of a vCenter should share its rate limiter")
+	}
+	if RateLimiterFor("vc-shared", RateLimits{ReadQPS: 5}) == l {
+		t.Error("The rate limiter should be replaced when the rates change")
+	}
+
+	// the writes alone are limited
+	writes := RateLimiterFor("vc-writes", RateLimits{WriteQPS: 1})
+	if writes.read != nil {
+		t.Error("The reads should not be limited")
+	}
+	for i := 0; i < 3; i++ {
+		if err := writes.wait(context.Background(), "RetrievePropertiesEx"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	ctx := context.Background()
+	l := RateLimiterFor("vc-wait", RateLimits{WriteQPS: 20, WriteBurst: 1})
+
+	// the second call waits for the bucket to refill
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := l.wait(ctx, "ReconfigVM_Task"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("The second call should be throttled, took %s", elapsed)
+	}
+
+	// a call stops waiting once its context is done
+	l = RateLimiterFor("vc-wait", RateLimits{WriteQPS: 1, WriteBurst: 1})
+	if err := l.wait(ctx, "ReconfigVM_Task"); err != nil {
+		t.Fatal(err)
+	}
+	cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := l.wait(cancelCtx, "ReconfigVM_Task"); err != context.DeadlineExceeded {
+		t.Errorf("The throttled call should fail with its context: %v", err)
+	}
+}
+
+func TestRateLimitedConnection(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	password, _ := s.URL.User.Password()
+	connection := &VSphereConnection{
+		Username:    s.URL.User.Username(),
+		Password:    password,
+		Hostname:    s.URL.Hostname(),
+		Port:        s.URL.Port(),
+		Insecure:    true,
+		RateLimiter: RateLimiterFor(s.URL.Host, RateLimits{ReadQPS: 1, ReadBurst: 1}),
+	}
+	if err := connection.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Logout(ctx)
+
+	// at most one of the reads gets a token in time
+	failed := 0
+	for i := 0; i < 2; i++ {
+		callCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		if _, err := GetDatacenter(callCtx, connection, TestDefaultDatacenter); err != nil {
+			failed++
+		}
+		cancel()
+	}
+	if failed == 0 {
+		t.Error("The reads past the rate should wait for their turn")
+	}
+}
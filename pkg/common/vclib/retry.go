@@ -0,0 +1,175 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+var (
+	// retryInitialDelay is the delay before the first retry of a call that
+	// failed with a transient error. It doubles with each retry.
+	retryInitialDelay = 500 * time.Millisecond
+
+	// retryMaxDelay is the longest delay between the retries of a call.
+	retryMaxDelay = 10 * time.Second
+
+	// retryJitter is the factor of the delay between the retries of a call
+	// that is randomly added to it.
+	retryJitter = 0.5
+)
+
+// WithRetry calls fn up to attempts times while it fails with a transient
+// error, see IsTransient, waiting with a jittered exponential backoff between
+// the calls, so that the callers failing together do not retry together. It
+// gives up early when the context is done.
+func WithRetry(ctx context.Context, attempts int, fn func() error) error {
+	return retryWhile(ctx, attempts, retryInitialDelay, IsTransient, fn)
+}
+
+// retryWhile calls fn up to attempts times while retryable returns true for
+// its error, waiting with a jittered exponential backoff from delay between
+// the calls. It gives up early when the context is done.
+func retryWhile(ctx context.Context, attempts int, delay time.Duration,
+	retryable func(err error) bool, fn func() error) error {
+
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || i >= attempts || !retryable(err) {
+			return err
+		}
+
+		jittered := wait.Jitter(delay, retryJitter)
+		klog.V(3).Infof("Retrying call in %s (attempt %d of %d). err: %v",
+			jittered, i+1, attempts, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(jittered):
+		}
+		if delay *= 2; delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// readOnlyMethods are the prefixes of the names of the vSphere API methods
+// that only read the inventory, once the Pbm, Vslm or Cns prefix of their
+// endpoint is trimmed.
+var readOnlyMethods = []string{"Retrieve", "ContinueRetrieve", "Find", "Query", "List", "CurrentTime", "WaitForUpdates"}
+
+// longPollMethods are the vSphere API methods that wait for updates, such as
+// the progress of a task, for longer than a request should take.
+var longPollMethods = map[string]bool{"WaitForUpdates": true, "WaitForUpdatesEx": true}
+
+// methodName returns the name of the vSphere API method of a request, e.g.
+// RetrieveProperties for a *methods.RetrievePropertiesBody.
+func methodName(req soap.HasFault) string {
+	t := reflect.TypeOf(req)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimSuffix(t.Name(), "Body")
+}
+
+// isReadOnly returns true when the vSphere API method only reads the
+// inventory, and is therefore safe to send again after a failure.
+func isReadOnly(method string) bool {
+	for _, endpoint := range []string{"Pbm", "Vslm", "Cns"} {
+		method = strings.TrimPrefix(method, endpoint)
+	}
+	for _, prefix := range readOnlyMethods {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryRoundTripper bounds each vSphere API call with the request timeout
+// of its connection and retries the read-only calls that fail with a
+// transient error. The other calls, such as the ones starting tasks, may
+// have been carried out by vCenter before the connection failed, so they
+// are left to their callers, which look up their result before retrying
+// them. A call that timed out is not retried, so that a vCenter that hangs
+// fails the call after the request timeout. The long polls of the updates
+// of the tasks are not bounded, as a task may run for longer without any.
+type retryRoundTripper struct {
+	roundTripper soap.RoundTripper
+	attempts     int
+	timeout      time.Duration
+}
+
+// newRetryRoundTripper wraps the round tripper. A read-only call is
+// attempted up to attempts times, each bounded by timeout unless it is zero.
+func newRetryRoundTripper(roundTripper soap.RoundTripper, attempts int, timeout time.Duration) *retryRoundTripper {
+	return &retryRoundTripper{
+		roundTripper: roundTripper,
+		attempts:     attempts,
+		timeout:      timeout,
+	}
+}
+
+// RoundTrip implements soap.RoundTripper.
+func (r *retryRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	method := methodName(req)
+	attempts := r.attempts
+	if !isReadOnly(method) {
+		attempts = 1
+	}
+	timeout := r.timeout
+	if longPollMethods[method] {
+		timeout = 0
+	}
+
+	first := true
+	return WithRetry(ctx, attempts, func() error {
+		if !first {
+			resetResponse(res)
+		}
+		first = false
+
+		callCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		err := r.roundTripper.RoundTrip(callCtx, req, res)
+		if err != nil && callCtx.Err() != nil {
+			// A call that timed out or was cancelled is not retried
+			return callCtx.Err()
+		}
+		return err
+	})
+}
+
+// resetResponse zeroes the body of a response, which still holds the fault
+// of a failed call when the call is made again.
+func resetResponse(res soap.HasFault) {
+	body := reflect.ValueOf(res).Elem()
+	body.Set(reflect.Zero(body.Type()))
+}
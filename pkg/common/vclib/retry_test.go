@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// roundTripperFunc is a soap.RoundTripper that calls itself.
+type roundTripperFunc func(ctx context.Context, req, res soap.HasFault) error
+
+func (f roundTripperFunc) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	return f(ctx, req, res)
+}
+
+func TestRetryRoundTripper(t *testing.T) {
+	defer func(delay time.Duration) { retryInitialDelay = delay }(retryInitialDelay)
+	retryInitialDelay = time.Millisecond
+
+	ctx := context.Background()
+
+	// a transient error is retried until the call succeeds
+	calls := 0
+	rt := newRetryRoundTripper(roundTripperFunc(func(ctx context.Context, req, res soap.HasFault) error {
+		if calls++; calls < 3 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	}), 3, 0)
+	if err := rt.RoundTrip(ctx, &methods.CurrentTimeBody{}, &methods.CurrentTimeBody{}); err != nil {
+		t.Errorf("RoundTrip should succeed: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("RoundTrip should be attempted 3 times: %d", calls)
+	}
+
+	// the attempts are limited
+	calls = 0
+	rt.attempts = 2
+	if err := rt.RoundTrip(ctx, &methods.CurrentTimeBody{}, &methods.CurrentTimeBody{}); err == nil {
+		t.Error("RoundTrip should fail")
+	}
+	if calls != 2 {
+		t.Errorf("RoundTrip should be attempted 2 times: %d", calls)
+	}
+
+	// a call that hangs times out and is not retried
+	calls = 0
+	rt = newRetryRoundTripper(roundTripperFunc(func(ctx context.Context, req, res soap.HasFault) error {
+		calls++
+		<-ctx.Done()
+		return &url.Error{Op: "Post", URL: "https://vc/sdk", Err: ctx.Err()}
+	}), 3, 10*time.Millisecond)
+	if err := rt.RoundTrip(ctx, &methods.CurrentTimeBody{}, &methods.CurrentTimeBody{}); err != context.DeadlineExceeded {
+		t.Errorf("RoundTrip should fail with %v: %v", context.DeadlineExceeded, err)
+	}
+	if calls != 1 {
+		t.Errorf("RoundTrip should be attempted once: %d", calls)
+	}
+
+	// a call that is not read-only is not retried
+	calls = 0
+	rt = newRetryRoundTripper(roundTripperFunc(func(ctx context.Context, req, res soap.HasFault) error {
+		calls++
+		return errors.New("503 Service Unavailable")
+	}), 3, 0)
+	if err := rt.RoundTrip(ctx, &methods.CreateDisk_TaskBody{}, &methods.CreateDisk_TaskBody{}); err == nil {
+		t.Error("RoundTrip should fail")
+	}
+	if calls != 1 {
+		t.Errorf("RoundTrip should be attempted once: %d", calls)
+	}
+
+	// the long poll of the updates is not bounded by the request timeout
+	rt = newRetryRoundTripper(roundTripperFunc(func(ctx context.Context, req, res soap.HasFault) error {
+		if _, ok := ctx.Deadline(); ok {
+			return errors.New("the long poll should have no deadline")
+		}
+		return nil
+	}), 3, 10*time.Millisecond)
+	if err := rt.RoundTrip(ctx, &methods.WaitForUpdatesExBody{}, &methods.WaitForUpdatesExBody{}); err != nil {
+		t.Errorf("RoundTrip should succeed: %v", err)
+	}
+}
+
+func TestIsReadOnly(t *testing.T) {
+	tests := []struct {
+		method   string
+		readOnly bool
+	}{
+		{methodName(&methods.RetrievePropertiesExBody{}), true},
+		{methodName(&methods.CurrentTimeBody{}), true},
+		{methodName(&methods.RetrieveVStorageObjectBody{}), true},
+		{"PbmQueryProfile", true},
+		{"CnsQueryVolume", true},
+		{methodName(&methods.CreateDisk_TaskBody{}), false},
+		{methodName(&methods.ReconfigVM_TaskBody{}), false},
+		{methodName(&methods.RegisterDiskBody{}), false},
+		{"CnsCreateVolume", false},
+	}
+
+	for _, test := range tests {
+		if readOnly := isReadOnly(test.method); readOnly != test.readOnly {
+			t.Errorf("isReadOnly(%s) should be %v", test.method, test.readOnly)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+var (
+	// keepAliveIdleTime is how long a session may be idle before the
+	// keep-alive handler checks it. It is well under the default vCenter
+	// session timeout of 30 minutes.
+	keepAliveIdleTime = 5 * time.Minute
+
+	// retiredSessionTimeout is how long the calls still holding a replaced
+	// client have to complete before its session is logged out.
+	retiredSessionTimeout = 30 * time.Minute
+)
+
+// isNotAuthenticated returns true when err is a NotAuthenticated fault.
+func isNotAuthenticated(err error) bool {
+	switch {
+	case soap.IsSoapFault(err):
+		_, ok := soap.ToSoapFault(err).VimFault().(types.NotAuthenticated)
+		return ok
+	case soap.IsVimFault(err):
+		_, ok := soap.ToVimFault(err).(*types.NotAuthenticated)
+		return ok
+	}
+	return false
+}
+
+// sessionRoundTripper logs a client in again with the credentials of its
+// connection when a call fails with a NotAuthenticated fault, and retries
+// the call once.
+type sessionRoundTripper struct {
+	connection   *VSphereConnection
+	roundTripper soap.RoundTripper
+
+	// client is a copy of the wrapped client that does not log in again,
+	// so that logging in again cannot recurse
+	client *vim25.Client
+
+	// storage is the client of the storage endpoint of the connection, nil
+	// when the storage services are served at the vCenter, see
+	// newStorageServiceClient
+	storage *soap.Client
+
+	reloginLock sync.Mutex
+
+	// retired is set once the client has been replaced, after which its
+	// session is no longer logged in again
+	retired int32
+}
+
+// newSessionRoundTripper wraps the round tripper of the client.
+func newSessionRoundTripper(connection *VSphereConnection, client *vim25.Client) *sessionRoundTripper {
+	inner := *client
+	return &sessionRoundTripper{
+		connection:   connection,
+		roundTripper: client.RoundTripper,
+		client:       &inner,
+	}
+}
+
+// RoundTrip implements soap.RoundTripper.
+func (s *sessionRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	err := s.roundTripper.RoundTrip(ctx, req, res)
+	if err == nil || !isNotAuthenticated(err) {
+		s.recordRoundTrip(ctx, err)
+		return err
+	}
+	switch req.(type) {
+	case *methods.LoginBody, *methods.LoginByTokenBody, *methods.LogoutBody:
+		return err
+	}
+	if atomic.LoadInt32(&s.retired) != 0 {
+		return err
+	}
+
+	reloginErr := s.relogin(ctx)
+	s.connection.recordSession(reloginErr)
+	if reloginErr != nil {
+		klog.Errorf("Failed to log in to %s again. err: %+v", s.connection.Hostname, reloginErr)
+		return err
+	}
+
+	resetResponse(res)
+
+	return s.roundTripper.RoundTrip(ctx, req, res)
+}
+
+// recordRoundTrip records the health of the session after a call that
+// succeeded, or that failed to reach the vCenter. The faults returned by
+// the vCenter and the calls cancelled by their caller say nothing of the
+// session.
+func (s *sessionRoundTripper) recordRoundTrip(ctx context.Context, err error) {
+	if atomic.LoadInt32(&s.retired) != 0 || ctx.Err() != nil {
+		return
+	}
+	if err == nil || methodFault(err) == nil {
+		s.connection.recordSession(err)
+	}
+}
+
+// relogin logs the client in again, unless a concurrent call already did.
+func (s *sessionRoundTripper) relogin(ctx context.Context) error {
+	s.reloginLock.Lock()
+	defer s.reloginLock.Unlock()
+
+	userSession, err := session.NewManager(s.client).UserSession(ctx)
+	if err == nil && userSession != nil {
+		return nil
+	}
+
+	klog.Warningf("Session for %s is not authenticated, logging in again", s.connection.Hostname)
+	return s.connection.login(ctx, s.client)
+}
+
+// keepAlive is the session.KeepAliveHandler handler. It logs the client in
+// again when the session has expired, and records the health of the session
+// in its connection. It always returns nil, as an error would stop the
+// keep-alive from within its own goroutine.
+func (s *sessionRoundTripper) keepAlive(roundTripper soap.RoundTripper) error {
+	ctx := context.Background()
+	if s.connection.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.connection.RequestTimeout)
+		defer cancel()
+	}
+
+	_, err := methods.GetCurrentTime(ctx, roundTripper)
+	if atomic.LoadInt32(&s.retired) != 0 {
+		return nil
+	}
+	if err != nil && isNotAuthenticated(err) {
+		if err = s.relogin(ctx); err != nil {
+			klog.Errorf("Failed to log in to %s again. err: %+v", s.connection.Hostname, err)
+		}
+	} else if err != nil {
+		klog.Warningf("Keep-alive of the session of %s failed. err: %+v", s.connection.Hostname, err)
+	}
+	s.connection.recordSession(err)
+	return nil
+}
+
+// retireClient stops logging a replaced client in again, and logs its
+// session out once the calls still holding it had time to complete.
+func retireClient(client *vim25.Client) {
+	if client == nil {
+		return
+	}
+	if s, ok := client.RoundTripper.(*sessionRoundTripper); ok {
+		atomic.StoreInt32(&s.retired, 1)
+	}
+
+	time.AfterFunc(retiredSessionTimeout, func() {
+		if err := session.NewManager(client).Logout(context.Background()); err != nil {
+			klog.V(2).Infof("Logout of a replaced session failed: %s", err)
+		}
+	})
+}
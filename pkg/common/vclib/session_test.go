@@ -0,0 +1,283 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newSimConnection returns a connection to a new simulator, and a client
+// of its own that can terminate the sessions of the connection.
+func newSimConnection(t *testing.T) (*VSphereConnection, *govmomi.Client, func()) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	s := model.Service.NewServer()
+
+	admin, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password, _ := s.URL.User.Password()
+	connection := &VSphereConnection{
+		Username: s.URL.User.Username(),
+		Password: password,
+		Hostname: s.URL.Hostname(),
+		Port:     s.URL.Port(),
+		Insecure: true,
+	}
+	if err = connection.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return connection, admin, func() {
+		s.Close()
+		model.Remove()
+	}
+}
+
+// terminateSession kills the session of the connection and returns its key.
+func terminateSession(t *testing.T, connection *VSphereConnection, admin *govmomi.Client) string {
+	ctx := context.Background()
+
+	userSession, err := session.NewManager(connection.Client).UserSession(ctx)
+	if err != nil || userSession == nil {
+		t.Fatalf("UserSession failed: %v", err)
+	}
+	if err = admin.SessionManager.TerminateSession(ctx, []string{userSession.Key}); err != nil {
+		t.Fatal(err)
+	}
+	return userSession.Key
+}
+
+func TestSessionRelogin(t *testing.T) {
+	ctx := context.Background()
+
+	connection, admin, cleanup := newSimConnection(t)
+	defer cleanup()
+
+	dc, err := GetDatacenter(ctx, connection, TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	avm := simulator.Map.Any(VirtualMachineType).(*simulator.VirtualMachine)
+
+	key := terminateSession(t, connection, admin)
+
+	// the call fails with NotAuthenticated, logs in again and is retried
+	if _, err = dc.GetVMByUUID(ctx, avm.Summary.Config.Uuid); err != nil {
+		t.Fatalf("GetVMByUUID should succeed after logging in again: %v", err)
+	}
+
+	userSession, err := session.NewManager(connection.Client).UserSession(ctx)
+	if err != nil || userSession == nil {
+		t.Fatalf("UserSession failed: %v", err)
+	}
+	if userSession.Key == key {
+		t.Error("the terminated session should have been replaced")
+	}
+
+	// a terminated session is logged in again by Connect on the same client
+	client := connection.Client
+	terminateSession(t, connection, admin)
+	if err = connection.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if connection.Client != client {
+		t.Error("Connect should log the existing client in again")
+	}
+}
+
+func TestSessionKeepAlive(t *testing.T) {
+	defer func(idle time.Duration) { keepAliveIdleTime = idle }(keepAliveIdleTime)
+	keepAliveIdleTime = 50 * time.Millisecond
+
+	ctx := context.Background()
+
+	connection, admin, cleanup := newSimConnection(t)
+	defer cleanup()
+	defer connection.Logout(ctx)
+
+	terminateSession(t, connection, admin)
+
+	// the keep-alive logs in again without any other call
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		userSession, err := session.NewManager(connection.Client).UserSession(ctx)
+		if err == nil && userSession != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the keep-alive should have logged in again")
+		}
+	}
+}
+
+func TestSessionHealth(t *testing.T) {
+	defer func(idle time.Duration) { keepAliveIdleTime = idle }(keepAliveIdleTime)
+	keepAliveIdleTime = 50 * time.Millisecond
+
+	if err := (&VSphereConnection{}).Health(); err != ErrNotConnected {
+		t.Errorf("Health should fail with %v before connecting: %v", ErrNotConnected, err)
+	}
+
+	connection, _, cleanup := newSimConnection(t)
+	if err := connection.Health(); err != nil {
+		t.Errorf("Health failed: %v", err)
+	}
+
+	// the keep-alive records that the vCenter cannot be reached
+	cleanup()
+	for deadline := time.Now().Add(5 * time.Second); connection.Health() == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the keep-alive should have failed")
+		}
+	}
+
+	connection.Close(context.Background())
+	if err := connection.Health(); err != ErrNotConnected {
+		t.Errorf("Health should fail with %v once closed: %v", ErrNotConnected, err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	ctx := context.Background()
+
+	connection, _, cleanup := newSimConnection(t)
+	defer cleanup()
+
+	client := connection.Client
+	connection.Close(ctx)
+
+	// the closed session is not logged in again
+	userSession, err := session.NewManager(client).UserSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSession != nil {
+		t.Error("the session should be logged out")
+	}
+
+	// Connect logs in on a new client
+	if err = connection.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if connection.Client == client {
+		t.Error("Connect should create a new client")
+	}
+	userSession, err = session.NewManager(connection.Client).UserSession(ctx)
+	if err != nil || userSession == nil {
+		t.Errorf("the new client should be logged in: %v", err)
+	}
+}
+
+func TestLoginRateLimited(t *testing.T) {
+	defer func(delay time.Duration) { loginRetryInitialDelay = delay }(loginRetryInitialDelay)
+	loginRetryInitialDelay = time.Millisecond
+
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	// the proxy in front of the simulator rejects the first logins with a 429
+	var logins, limited int32 = 0, 2
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: s.URL.Scheme, Host: s.URL.Host})
+	proxy.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if soapDebugLogin.Match(body) && atomic.AddInt32(&logins, 1) <= limited {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		proxy.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := s.URL.User.Password()
+	connection := &VSphereConnection{
+		Username: s.URL.User.Username(),
+		Password: password,
+		Hostname: u.Hostname(),
+		Port:     u.Port(),
+		Insecure: true,
+	}
+
+	if err := connection.Connect(ctx); err != nil {
+		t.Fatalf("Connect should succeed once the logins are no longer limited: %v", err)
+	}
+	if logins != limited+1 {
+		t.Errorf("Login should be attempted %d times: %d", limited+1, logins)
+	}
+
+	// the attempts are limited
+	logins, limited = 0, int32(loginAttempts)
+	connection.Client = nil
+	if err := connection.Connect(ctx); !IsRateLimited(err) {
+		t.Errorf("Connect should fail with a 429: %v", err)
+	}
+	if int(logins) != loginAttempts {
+		t.Errorf("Login should be attempted %d times: %d", loginAttempts, logins)
+	}
+}
+
+func TestIsNotAuthenticated(t *testing.T) {
+	if !isNotAuthenticated(soap.WrapVimFault(&types.NotAuthenticated{})) {
+		t.Error("NotAuthenticated fault not detected")
+	}
+	fault := &soap.Fault{}
+	fault.Detail.Fault = types.NotAuthenticated{}
+	if !isNotAuthenticated(soap.WrapSoapFault(fault)) {
+		t.Error("NotAuthenticated SOAP fault not detected")
+	}
+	if isNotAuthenticated(soap.WrapVimFault(&types.InvalidLogin{})) {
+		t.Error("InvalidLogin is not a NotAuthenticated fault")
+	}
+	if isNotAuthenticated(context.Canceled) {
+		t.Error("context.Canceled is not a NotAuthenticated fault")
+	}
+}
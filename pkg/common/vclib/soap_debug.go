@@ -0,0 +1,242 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/debug"
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/klog"
+)
+
+// soapDebugRedacted replaces the login requests and the session cookies in
+// the debug files.
+const soapDebugRedacted = "<redacted>"
+
+var (
+	// soapDebugMaxLogSize is the size past which the log of the round trips
+	// of a client is truncated.
+	soapDebugMaxLogSize int64 = 10 * 1024 * 1024
+
+	// soapDebugLogin matches the body of the requests that log in.
+	soapDebugLogin = regexp.MustCompile(`<(\w+:)?Login(ByToken|Extension\w*)?[\s>]`)
+
+	// soapDebugCookie matches the headers that carry the session cookie.
+	soapDebugCookie = regexp.MustCompile(`(?mi)^((Set-)?Cookie|vmware-api-session-id):.*$`)
+)
+
+// soapDebugProvider is the debug.Provider of govmomi that writes the SOAP
+// round trips of the clients created with a debug directory to that
+// directory. The round trips of the other clients are discarded. The login
+// requests and the session cookies are redacted, and the oldest files of a
+// directory are deleted past its maximum number of files.
+type soapDebugProvider struct {
+	sync.Mutex
+
+	// maxFiles is the number of files kept in each directory
+	maxFiles int
+	// prefix names the files of this process apart from the files of the
+	// previous ones
+	prefix string
+
+	// pending is the directory of the client being created
+	pending string
+	// clientDirs is the directory of each traced client, by client number
+	clientDirs map[string]string
+	// files are the files of each directory, oldest first
+	files map[string][]string
+}
+
+var (
+	soapDebug     *soapDebugProvider
+	soapDebugOnce sync.Once
+
+	// soapClientLock serializes the creation of the clients, so that the
+	// debug files of a new client are attributed to its directory
+	soapClientLock sync.Mutex
+)
+
+// EnableSoapDebug installs the provider that traces the SOAP round trips of
+// the clients of the connections with a SoapDebugDir, keeping up to
+// maxFiles files in each directory. It must be called before any client is
+// created, as govmomi only traces the clients created once it is installed.
+func EnableSoapDebug(maxFiles int) {
+	soapDebugOnce.Do(func() {
+		soapDebug = &soapDebugProvider{
+			maxFiles:   maxFiles,
+			prefix:     time.Now().UTC().Format("20060102T150405"),
+			clientDirs: make(map[string]string),
+			files:      make(map[string][]string),
+		}
+		debug.SetProvider(soapDebug)
+	})
+}
+
+// newSoapClient returns a new SOAP client, whose round trips are written
+// to dir when it is set and EnableSoapDebug was called.
+func newSoapClient(u *url.URL, insecure bool, dir string) *soap.Client {
+	if soapDebug == nil {
+		return soap.NewClient(u, insecure)
+	}
+
+	soapClientLock.Lock()
+	defer soapClientLock.Unlock()
+
+	soapDebug.setPending(dir)
+	defer soapDebug.setPending("")
+	return soap.NewClient(u, insecure)
+}
+
+// setPending sets the directory of the client being created.
+func (p *soapDebugProvider) setPending(dir string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.pending = dir
+}
+
+// NewFile implements debug.Provider. The files are named after the number
+// of their client, "<client>-client.log" for the log of its round trips and
+// "<client>-<request>.<suffix>" for each round trip.
+func (p *soapDebugProvider) NewFile(name string) io.WriteCloser {
+	p.Lock()
+	defer p.Unlock()
+
+	client := strings.SplitN(name, "-", 2)[0]
+	if strings.HasSuffix(name, "-client.log") {
+		// a new client is being created
+		p.clientDirs[client] = p.pending
+		if p.pending != "" {
+			if err := os.MkdirAll(p.pending, 0700); err != nil {
+				klog.Errorf("Failed to create the SOAP debug directory %s. Err: %v", p.pending, err)
+				p.clientDirs[client] = ""
+			}
+		}
+	}
+	dir := p.clientDirs[client]
+	if dir == "" {
+		return nopWriteCloser{ioutil.Discard}
+	}
+
+	path := filepath.Join(dir, p.prefix+"-"+name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		klog.Errorf("Failed to create the SOAP debug file %s. Err: %v", path, err)
+		return nopWriteCloser{ioutil.Discard}
+	}
+	if strings.HasSuffix(name, "-client.log") {
+		return &soapDebugLog{file: f}
+	}
+
+	p.rotate(dir, path)
+	return &soapDebugFile{name: name, file: f}
+}
+
+// rotate records a new file of the directory, and deletes its oldest files
+// past the maximum number of files.
+func (p *soapDebugProvider) rotate(dir string, path string) {
+	files := append(p.files[dir], path)
+	for p.maxFiles > 0 && len(files) > p.maxFiles {
+		if err := os.Remove(files[0]); err != nil && !os.IsNotExist(err) {
+			klog.Warningf("Failed to delete the SOAP debug file %s. Err: %v", files[0], err)
+		}
+		files = files[1:]
+	}
+	p.files[dir] = files
+}
+
+// Flush implements debug.Provider. The files are closed by the clients.
+func (p *soapDebugProvider) Flush() {}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// soapDebugFile buffers a file of a round trip, and redacts it once it is
+// complete: the body of a login request is dropped, as are the values of
+// the session cookies.
+type soapDebugFile struct {
+	name string
+	file *os.File
+	buf  bytes.Buffer
+}
+
+func (f *soapDebugFile) Write(b []byte) (int, error) {
+	return f.buf.Write(b)
+}
+
+func (f *soapDebugFile) Close() error {
+	data := f.buf.Bytes()
+	switch {
+	case strings.HasSuffix(f.name, ".headers"):
+		data = soapDebugCookie.ReplaceAllFunc(data, func(header []byte) []byte {
+			name := header[:bytes.IndexByte(header, ':')]
+			return append(append([]byte{}, name...), ": "+soapDebugRedacted...)
+		})
+	case strings.Contains(f.name, ".req.") && soapDebugLogin.Match(data):
+		data = []byte("<!-- login request " + soapDebugRedacted + " -->\n")
+	}
+
+	_, err := f.file.Write(data)
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// soapDebugLog is the log of the round trips of a client, which is kept
+// open for the life of the client. It is truncated once it grows past
+// soapDebugMaxLogSize.
+type soapDebugLog struct {
+	sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (l *soapDebugLog) Write(b []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.size+int64(len(b)) > soapDebugMaxLogSize {
+		if err := l.file.Truncate(0); err != nil {
+			return 0, err
+		}
+		if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		l.size = 0
+	}
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *soapDebugLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/simulator"
+)
+
+func TestSoapDebug(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "soap-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	EnableSoapDebug(0)
+	defer func(maxFiles int) { soapDebug.maxFiles = maxFiles }(soapDebug.maxFiles)
+	soapDebug.maxFiles = 10
+
+	password, _ := s.URL.User.Password()
+	connections := make(map[string]*VSphereConnection)
+	for _, name := range []string{"traced", "untraced"} {
+		connection := &VSphereConnection{
+			Username: s.URL.User.Username(),
+			Password: password,
+			Hostname: s.URL.Hostname(),
+			Port:     s.URL.Port(),
+			Insecure: true,
+		}
+		if name == "traced" {
+			connection.SoapDebugDir = filepath.Join(dir, name)
+		}
+		if err = connection.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer connection.Logout(ctx)
+		connections[name] = connection
+	}
+
+	// the login is traced before the files of the later calls rotate it out
+	files, err := filepath.Glob(filepath.Join(dir, "traced", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var login bool
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), password) || strings.Contains(string(data), "vmware_soap_session=") {
+			t.Errorf("%s should be redacted: %s", file, data)
+		}
+		if strings.HasSuffix(file, ".req.xml") && strings.Contains(string(data), "login request "+soapDebugRedacted) {
+			login = true
+		}
+	}
+	if !login {
+		t.Errorf("the redacted login request should be traced: %v", files)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err = session.NewManager(connections["traced"].Client).UserSession(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the oldest round trips are deleted, the log of the client is kept
+	files, err = filepath.Glob(filepath.Join(dir, "traced", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 11 {
+		t.Errorf("10 round trip files and the client log should be kept: %v", files)
+	}
+	if logs, _ := filepath.Glob(filepath.Join(dir, "traced", "*-client.log")); len(logs) != 1 {
+		t.Errorf("the client log should be kept: %v", files)
+	}
+
+	// only the traced vCenter has a directory
+	if dirs, _ := ioutil.ReadDir(dir); len(dirs) != 1 {
+		t.Errorf("only the traced connection should be traced: %v", dirs)
+	}
+}
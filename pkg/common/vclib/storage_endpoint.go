@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/klog"
+)
+
+// StorageEndpoint is where the storage services of a vCenter, vslm, PBM,
+// CNS and the vSAN file service, are reached when they are not served at
+// the host of the vCenter, as on VMware Cloud on AWS. The clients of the
+// services share the session of the vCenter.
+type StorageEndpoint struct {
+	Hostname   string
+	Port       string
+	CACert     string
+	CAData     string
+	Thumbprint string
+	Insecure   bool
+}
+
+// newClient returns a SOAP client of the storage endpoint that verifies its
+// certificate. It is never logged in, the clients of the storage services
+// are created from it with the session cookie of the vCenter.
+func (e *StorageEndpoint) newClient() (*soap.Client, error) {
+	host := net.JoinHostPort(e.Hostname, e.Port)
+	u, err := soap.ParseURL(host)
+	if err != nil {
+		klog.Errorf("Failed to parse the URL of the storage endpoint %s. err: %+v", host, err)
+		return nil, err
+	}
+
+	sc := soap.NewClient(u, e.Insecure)
+	if err := configureTLS(sc, host, e.Insecure, e.CACert, e.CAData, e.Thumbprint); err != nil {
+		klog.Errorf("Failed to configure the TLS of the storage endpoint %s. err: %+v", host, err)
+		return nil, err
+	}
+	return sc, nil
+}
+
+// newStorageServiceClient returns a client of a storage service of the
+// vCenter of a client, sharing its session. The service is reached at the
+// storage endpoint of the connection the client was created by when one is
+// configured, and at the vCenter otherwise.
+func newStorageServiceClient(client *vim25.Client, path string, namespace string) *soap.Client {
+	s, ok := client.RoundTripper.(*sessionRoundTripper)
+	if !ok || s.storage == nil {
+		sc := client.Client.NewServiceClient(path, namespace)
+		InheritProxy(client.Client, sc)
+		return sc
+	}
+
+	// The service client copies the cookies of the storage endpoint, and
+	// its session cookie from them
+	endpoint := s.storage
+	u := endpoint.URL()
+	endpoint.Jar.SetCookies(u, client.Client.Jar.Cookies(client.Client.URL()))
+	sc := endpoint.NewServiceClient(path, namespace)
+
+	// The service client is created with a transport of its own, that
+	// verifies the certificate of the storage endpoint as the endpoint does
+	if from, ok := endpoint.Transport.(*http.Transport); ok {
+		if to, ok := sc.Transport.(*http.Transport); ok {
+			to.TLSClientConfig = from.TLSClientConfig
+		}
+	}
+	return sc
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	pbmsim "github.com/vmware/govmomi/pbm/simulator"
+	pbmtypes "github.com/vmware/govmomi/pbm/types"
+	"github.com/vmware/govmomi/simulator"
+)
+
+func TestStorageEndpoint(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+
+	vslm := simulator.NewRegistry()
+	vslm.Namespace = vslmNamespace
+	vslm.Path = vslmPath
+	vslm.Put(&vslmServiceInstanceSim{})
+	vslm.Put(&vslmManagerSim{})
+	model.Service.RegisterSDK(vslm)
+	model.Service.RegisterSDK(pbmsim.New())
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	// the storage endpoint is a proxy of the vCenter at another address,
+	// counting the calls it serves
+	target := &url.URL{Scheme: s.URL.Scheme, Host: s.URL.Host}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	var calls int32
+	storage := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		proxy.ServeHTTP(w, r)
+	}))
+	defer storage.Close()
+	storageURL, err := url.Parse(storage.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint := certificateThumbprint(storage.Certificate().Raw, strings.Repeat("0", 64))
+
+	password, _ := s.URL.User.Password()
+	connect := func(endpoint *StorageEndpoint) *VSphereConnection {
+		connection := &VSphereConnection{
+			Username:        s.URL.User.Username(),
+			Password:        password,
+			Hostname:        s.URL.Hostname(),
+			Port:            s.URL.Port(),
+			Insecure:        true,
+			StorageEndpoint: endpoint,
+		}
+		if err := connection.Connect(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return connection
+	}
+
+	connection := connect(&StorageEndpoint{
+		Hostname:   storageURL.Hostname(),
+		Port:       storageURL.Port(),
+		Thumbprint: thumbprint,
+	})
+	defer connection.Logout(ctx)
+
+	// the storage services are reached at the storage endpoint with the
+	// session of the vCenter
+	vslmClient, err := NewVslmClient(ctx, connection.Client)
+	if err != nil {
+		t.Fatalf("NewVslmClient failed: %v", err)
+	}
+	if vslmClient.About.APIVersion != "7.0" {
+		t.Errorf("Unexpected about info: %+v", vslmClient.About)
+	}
+	pbmClient, err := NewPbmClient(ctx, connection.Client)
+	if err != nil {
+		t.Fatalf("NewPbmClient failed: %v", err)
+	}
+	if _, err := pbmClient.QueryProfile(ctx, pbmtypes.PbmProfileResourceType{ResourceType: "STORAGE"}, ""); err != nil {
+		t.Errorf("QueryProfile failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Expected the 3 calls of the storage services at the storage endpoint, got %d", n)
+	}
+
+	// the vCenter is not reached at the storage endpoint
+	if _, err := GetDatacenter(ctx, connection, TestDefaultDatacenter); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("The vCenter was called at the storage endpoint: %d calls", n)
+	}
+
+	// the certificate of the storage endpoint is verified
+	untrusted := connect(&StorageEndpoint{
+		Hostname:   storageURL.Hostname(),
+		Port:       storageURL.Port(),
+		Thumbprint: "obviously wrong",
+	})
+	defer untrusted.Logout(ctx)
+	if _, err := NewVslmClient(ctx, untrusted.Client); err == nil || !strings.Contains(err.Error(), "thumbprint mismatch") {
+		t.Errorf("NewVslmClient should fail with the wrong thumbprint: %v", err)
+	}
+
+	// without a storage endpoint the storage services are reached at the
+	// vCenter
+	direct := connect(nil)
+	defer direct.Logout(ctx)
+	atomic.StoreInt32(&calls, 0)
+	if _, err := NewVslmClient(ctx, direct.Client); err != nil {
+		t.Fatalf("NewVslmClient failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("The storage endpoint was called without being configured: %d calls", n)
+	}
+}
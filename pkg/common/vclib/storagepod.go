@@ -87,6 +87,60 @@ func (spi *StoragePodInfo) PopulateChildDatastoreInfos(ctx context.Context, refr
 	return nil
 }
 
+// IsStorageDrsEnabled returns whether Storage DRS is enabled on the StoragePod
+func (spi *StoragePodInfo) IsStorageDrsEnabled() bool {
+	return spi.Config != nil && spi.Config.PodConfig.Enabled
+}
+
+// GetDatastoreWithMostFreeSpace returns the child datastore of the StoragePod
+// with the most free space. Datastores with less than minFreeSpace bytes free
+// are ignored.
+func (spi *StoragePodInfo) GetDatastoreWithMostFreeSpace(ctx context.Context, minFreeSpace int64) (*DatastoreInfo, error) {
+	err := spi.PopulateChildDatastoreInfos(ctx, true)
+	if err != nil {
+		klog.Errorf("PopulateChildDatastoreInfos failed. Err: %v", err)
+		return nil, err
+	}
+
+	var best *DatastoreInfo
+	for _, child := range spi.DatastoreInfos {
+		if child.Info.FreeSpace < minFreeSpace {
+			klog.V(LogLevel).Infof("Skipping datastore %s freeSpace=%d", child.Info.Name, child.Info.FreeSpace)
+			continue
+		}
+		if best == nil || child.Info.FreeSpace > best.Info.FreeSpace {
+			best = child
+		}
+	}
+
+	if best == nil {
+		klog.Errorf("No datastore in %s with %d bytes free found", spi.Summary.Name, minFreeSpace)
+		return nil, ErrNoDatastoreFound
+	}
+
+	return best, nil
+}
+
+// isMountedByAny returns whether one of the hosts mounts a child datastore
+// of the StoragePod.
+func (spi *StoragePodInfo) isMountedByAny(ctx context.Context, hosts []types.ManagedObjectReference) (bool, error) {
+	for _, child := range spi.DatastoreInfos {
+		dsHosts, err := child.GetHosts(ctx)
+		if err != nil {
+			klog.Errorf("GetHosts failed for datastore %s. Err: %v", child.Info.Name, err)
+			return false, err
+		}
+		for _, dsHost := range dsHosts {
+			for _, host := range hosts {
+				if dsHost == host {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
 // ListFirstClassDisksInfo gets a list of first class disks (FCD) on this datastore backed by this StoragePodInfo
 func (spi *StoragePodInfo) ListFirstClassDisksInfo(ctx context.Context) ([]*FirstClassDiskInfo, error) {
 	err := spi.PopulateChildDatastoreInfos(ctx, false)
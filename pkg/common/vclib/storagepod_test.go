@@ -24,6 +24,7 @@ import (
 
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/simulator"
 	"github.com/vmware/govmomi/vim25/types"
 	"github.com/vmware/govmomi/vslm"
@@ -144,3 +145,218 @@ func TestDatastoreAndClusters(t *testing.T) {
 		}
 	}
 }
+
+func TestPlaceFirstClassDisk(t *testing.T) {
+	ctx := context.Background()
+	model := simulator.VPX()
+	defer model.Remove()
+
+	model.Pod = 1
+	model.Datastore = 4
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc, err := GetDatacenter(ctx, &VSphereConnection{Client: c.Client}, TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finder := find.NewFinder(c.Client, false)
+	finder.SetDatacenter(dc.Datacenter)
+
+	stores, err := finder.DatastoreList(ctx, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := finder.DatastoreCluster(ctx, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move half the datastores into the datastore cluster
+	members := make(map[string]bool)
+	var objs []types.ManagedObjectReference
+	for i := 0; i < len(stores)/2; i++ {
+		objs = append(objs, stores[i].Reference())
+		members[stores[i].Name()] = true
+	}
+
+	_, err = pod.MoveInto(ctx, objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SDRS recommends the datastore, then the datastore with the most free
+	// space is used once SDRS is disabled
+	for _, diskName := range []string{"sdrs-disk", "no-sdrs-disk"} {
+		err = dc.CreateFirstClassDisk(ctx, pod.Name(), TypeDatastoreCluster, diskName, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fcd, err := dc.GetFirstClassDisk(ctx, pod.Name(), TypeDatastoreCluster, diskName, FindFCDByName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !members[fcd.DatastoreInfo.Info.Name] {
+			t.Errorf("%s should be placed on a datastore of %s: %s", diskName, pod.Name(), fcd.DatastoreInfo.Info.Name)
+		}
+
+		task, err := object.NewStorageResourceManager(c.Client).ConfigureStorageDrsForPod(ctx, pod,
+			types.StorageDrsConfigSpec{PodConfigSpec: &types.StorageDrsPodConfigSpec{Enabled: types.NewBool(false)}}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storagePod, err := dc.GetDatastoreClusterByName(ctx, pod.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storagePod.IsStorageDrsEnabled() {
+		t.Errorf("Storage DRS should be disabled on %s", pod.Name())
+	}
+
+	// A disk is created with the provisioning type of its format
+	ds := stores[len(stores)-1].Name()
+	for _, diskFormat := range []string{"thin", "zeroedthick", "eagerzeroedthick"} {
+		diskName := diskFormat + "-disk"
+		err = dc.CreateFirstClassDiskWithOptions(ctx, ds, TypeDatastore, diskName, 10,
+			&VolumeOptions{DiskFormat: diskFormat})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fcd, err := dc.GetFirstClassDisk(ctx, ds, TypeDatastore, diskName, FindFCDByName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format := FirstClassDiskFormat(fcd); format != diskFormat {
+			t.Errorf("%s should be %s: %s", diskName, diskFormat, format)
+		}
+	}
+
+	err = dc.CreateFirstClassDiskWithOptions(ctx, ds, TypeDatastore, "invalid-disk", 10,
+		&VolumeOptions{DiskFormat: "thick"})
+	if err == nil {
+		t.Error("A disk with an invalid format should not be created")
+	}
+
+	// The task is left running when the context is done first
+	task, err := object.NewStorageResourceManager(c.Client).ConfigureStorageDrsForPod(ctx, pod,
+		types.StorageDrsConfigSpec{PodConfigSpec: &types.StorageDrsPodConfigSpec{Enabled: types.NewBool(true)}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = dc.WaitForTask(doneCtx, task.Reference())
+	if inProgress, ok := err.(*TaskInProgressError); !ok || inProgress.Task != task.Reference() {
+		t.Errorf("WaitForTask should return a TaskInProgressError for %s: %v", task.Reference(), err)
+	}
+	if err = dc.WaitForTask(ctx, task.Reference()); err != nil {
+		t.Errorf("WaitForTask should resume waiting for %s: %v", task.Reference(), err)
+	}
+}
+
+func TestGetPlacementResourcePool(t *testing.T) {
+	ctx := context.Background()
+	model := simulator.VPX()
+	defer model.Remove()
+
+	model.Pod = 1
+
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc, err := GetDatacenter(ctx, &VSphereConnection{Client: c.Client}, TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finder := find.NewFinder(c.Client, false)
+	finder.SetDatacenter(dc.Datacenter)
+
+	pod, err := finder.DatastoreCluster(ctx, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No host mounts a datastore of an empty datastore cluster
+	storagePod, err := dc.GetDatastoreClusterByName(ctx, pod.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dc.getPlacementResourcePool(ctx, storagePod); err != ErrNoComputeResourceFound {
+		t.Errorf("getPlacementResourcePool should fail with ErrNoComputeResourceFound: %v", err)
+	}
+
+	ds, err := finder.Datastore(ctx, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pod.MoveInto(ctx, []types.ManagedObjectReference{ds.Reference()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storagePod, err = dc.GetDatastoreClusterByName(ctx, pod.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := dc.getPlacementResourcePool(ctx, storagePod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	computeResources, err := finder.ComputeResourceList(ctx, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, computeResource := range computeResources {
+		crPool, err := computeResource.ResourcePool(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crPool.Reference() != pool.Reference() {
+			continue
+		}
+		hosts, err := computeResource.Hosts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var refs []types.ManagedObjectReference
+		for _, host := range hosts {
+			refs = append(refs, host.Reference())
+		}
+		if mounted, err := storagePod.isMountedByAny(ctx, refs); err != nil || !mounted {
+			t.Errorf("The hosts of %s should mount %s: %v", computeResource.Name(), ds.Name(), err)
+		}
+		return
+	}
+	t.Errorf("%s is not the pool of a compute resource", pool.Reference())
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// OperationID returns the operation ID the vSphere API calls made with the
+// context are sent with, which vCenter logs along with the calls and the
+// tasks they start, or an empty string when there is none. The CSI
+// controller sets it to the ID of its requests.
+func OperationID(ctx context.Context) string {
+	id, _ := ctx.Value(types.ID{}).(string)
+	return id
+}
+
+// waitForTask waits for a task to complete. The task is logged with the
+// operation ID of the context, so that it can be traced to the request that
+// started it. The error of a failed task is a *TaskError. When the context
+// is done first, the task is left running and a *TaskInProgressError is
+// returned.
+func waitForTask(ctx context.Context, client *vim25.Client, ref types.ManagedObjectReference) (*types.TaskInfo, error) {
+	klog.V(LogLevel).Infof("Waiting for task %s of operation %q", ref.Value, OperationID(ctx))
+	info, err := object.NewTask(client, ref).WaitForResult(ctx, nil)
+	if err == nil {
+		return info, nil
+	}
+	if ctx.Err() != nil {
+		return nil, &TaskInProgressError{Task: ref, Err: ctx.Err()}
+	}
+	klog.Errorf("Task %s of operation %q failed. err: %v", ref.Value, OperationID(ctx), err)
+	return nil, &TaskError{Task: ref, Err: err}
+}
+
+// GetTaskInfo returns the info of a task, such as its state and progress.
+func GetTaskInfo(ctx context.Context, client *vim25.Client, ref types.ManagedObjectReference) (*types.TaskInfo, error) {
+	var task mo.Task
+	err := property.DefaultCollector(client).RetrieveOne(ctx, ref, []string{"info"}, &task)
+	if err != nil {
+		klog.Errorf("Failed to retrieve the info of task %s. err: %v", ref.Value, err)
+		return nil, err
+	}
+	return &task.Info, nil
+}
+
+// CancelTask cancels a task that is still running, if vCenter allows it to
+// be cancelled. It returns true when the task was cancelled.
+func CancelTask(ctx context.Context, client *vim25.Client, ref types.ManagedObjectReference) (bool, error) {
+	info, err := GetTaskInfo(ctx, client, ref)
+	if err != nil {
+		return false, err
+	}
+	if !info.Cancelable || (info.State != types.TaskInfoStateQueued && info.State != types.TaskInfoStateRunning) {
+		return false, nil
+	}
+
+	if err = object.NewTask(client, ref).Cancel(ctx); err != nil {
+		klog.Errorf("Failed to cancel task %s. err: %v", ref.Value, err)
+		return false, err
+	}
+	return true, nil
+}
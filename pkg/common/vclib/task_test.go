@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// cancelableTask is a running task that can be cancelled, as the tasks of
+// vcsim complete synchronously and cannot be.
+type cancelableTask struct {
+	mo.Task
+
+	cancelled bool
+}
+
+func (t *cancelableTask) CancelTask(req *types.CancelTask) soap.HasFault {
+	t.cancelled = true
+	t.Info.State = types.TaskInfoStateError
+	return &methods.CancelTaskBody{Res: &types.CancelTaskResponse{}}
+}
+
+func TestCancelTask(t *testing.T) {
+	ctx := context.Background()
+
+	connection, _, cleanup := newSimConnection(t)
+	defer cleanup()
+
+	entity := simulator.Map.Any("Datacenter")
+	task := &cancelableTask{Task: simulator.CreateTask(entity, "createDisk", nil).Task}
+	task.Info.State = types.TaskInfoStateRunning
+	task.Info.Progress = 42
+	simulator.Map.Put(task)
+
+	info, err := GetTaskInfo(ctx, connection.Client, task.Reference())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State != types.TaskInfoStateRunning || info.Progress != 42 {
+		t.Errorf("the task should be running at 42%%: %s %d%%", info.State, info.Progress)
+	}
+
+	// a task that cannot be cancelled is left alone
+	cancelled, err := CancelTask(ctx, connection.Client, task.Reference())
+	if err != nil || cancelled || task.cancelled {
+		t.Errorf("CancelTask should not cancel a task that is not cancelable: %t %v", cancelled, err)
+	}
+
+	task.Info.Cancelable = true
+	cancelled, err = CancelTask(ctx, connection.Client, task.Reference())
+	if err != nil || !cancelled || !task.cancelled {
+		t.Errorf("CancelTask should cancel the task: %t %v", cancelled, err)
+	}
+
+	// a task that completed is left alone
+	task.cancelled = false
+	cancelled, err = CancelTask(ctx, connection.Client, task.Reference())
+	if err != nil || cancelled || task.cancelled {
+		t.Errorf("CancelTask should not cancel a task that completed: %t %v", cancelled, err)
+	}
+}
+
+func TestWaitForTask(t *testing.T) {
+	connection, _, cleanup := newSimConnection(t)
+	defer cleanup()
+
+	ctx := context.WithValue(context.Background(), types.ID{}, "csi-42")
+	if id := OperationID(ctx); id != "csi-42" {
+		t.Errorf("the operation ID should be csi-42: %q", id)
+	}
+
+	entity := simulator.Map.Any("Datacenter")
+	newTask := func(state types.TaskInfoState) *simulator.Task {
+		task := simulator.CreateTask(entity, "reconfigure", nil)
+		task.Info.State = state
+		simulator.Map.Put(task)
+		return task
+	}
+
+	task := newTask(types.TaskInfoStateSuccess)
+	info, err := waitForTask(ctx, connection.Client, task.Reference())
+	if err != nil || info == nil || info.State != types.TaskInfoStateSuccess {
+		t.Errorf("waitForTask should return the info of the task: %+v %v", info, err)
+	}
+
+	// the error of a failed task names the task
+	task = newTask(types.TaskInfoStateError)
+	task.Info.Error = &types.LocalizedMethodFault{Fault: &types.NoPermission{}, LocalizedMessage: "no permission"}
+	_, err = waitForTask(ctx, connection.Client, task.Reference())
+	terr, ok := err.(*TaskError)
+	if !ok || terr.Task != task.Reference() {
+		t.Fatalf("waitForTask should fail with a *TaskError of task %s: %v", task.Reference().Value, err)
+	}
+	if !strings.Contains(err.Error(), task.Reference().Value) || !IsPermission(err) {
+		t.Errorf("the error should name the task and keep its fault: %v", err)
+	}
+
+	// a task still running when the context is done is left running
+	task = newTask(types.TaskInfoStateRunning)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = waitForTask(cancelled, connection.Client, task.Reference())
+	if inProgress, ok := err.(*TaskInProgressError); !ok || inProgress.Task != task.Reference() {
+		t.Errorf("waitForTask should fail with a *TaskInProgressError: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/klog"
+)
+
+// normalizeThumbprint returns the thumbprint in upper case without the
+// separators, so that thumbprints can be compared.
+func normalizeThumbprint(thumbprint string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", " ", "").Replace(thumbprint))
+}
+
+// certificateThumbprint returns the thumbprint of the certificate with the
+// digest of the same size as the expected thumbprint, in the format used by
+// soap.ThumbprintSHA1.
+func certificateThumbprint(raw []byte, expected string) string {
+	var sum []byte
+	if len(normalizeThumbprint(expected)) == 2*sha256.Size {
+		s := sha256.Sum256(raw)
+		sum = s[:]
+	} else {
+		s := sha1.Sum(raw)
+		sum = s[:]
+	}
+
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// configureTLS makes the SOAP client verify the certificate of the vCenter
+// against the thumbprint when one is set, or against the CA certificates
+// otherwise.
+func (connection *VSphereConnection) configureTLS(sc *soap.Client, host string) error {
+	return configureTLS(sc, host, connection.Insecure, connection.CACert, connection.CAData, connection.Thumbprint)
+}
+
+// configureTLS makes the SOAP client verify the certificate of the endpoint
+// at host against the thumbprint when one is set, or against the CA
+// certificates otherwise.
+func configureTLS(sc *soap.Client, host string, insecure bool, caCert string, caData string, thumbprint string) error {
+	if insecure {
+		return nil
+	}
+
+	transport, ok := sc.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unexpected SOAP transport %T", sc.Transport)
+	}
+
+	if caCert != "" {
+		if err := sc.SetRootCAs(caCert); err != nil {
+			return err
+		}
+	}
+	if caData != "" {
+		// The CA data is trusted along with the CAs of the system, unless
+		// they cannot be loaded
+		pool := transport.TLSClientConfig.RootCAs
+		if pool == nil {
+			var err error
+			if pool, err = x509.SystemCertPool(); err != nil {
+				klog.Warningf("Failed to load the system CAs, only trusting the CA data of %s. Err: %v", host, err)
+				pool = x509.NewCertPool()
+			}
+		}
+		if !pool.AppendCertsFromPEM([]byte(caData)) {
+			return ErrInvalidCAData
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if thumbprint != "" {
+		// The thumbprint replaces the verification of the chain
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("%s presented no certificate", host)
+		}
+		if thumbprint != "" {
+			got := certificateThumbprint(rawCerts[0], thumbprint)
+			if normalizeThumbprint(got) != normalizeThumbprint(thumbprint) {
+				return fmt.Errorf("thumbprint mismatch, expected %s got %s", thumbprint, got)
+			}
+		}
+
+		// The service clients, such as PBM's, are created with a transport of
+		// their own that only knows the SHA-1 thumbprints of the SOAP client
+		sc.SetThumbprint(host, certificateThumbprint(rawCerts[0], ""))
+		return nil
+	}
+	return nil
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/vmware/govmomi/find"
@@ -30,21 +31,6 @@ import (
 	"k8s.io/klog"
 )
 
-// IsNotFound return true if err is NotFoundError or DefaultNotFoundError
-func IsNotFound(err error) bool {
-	_, ok := err.(*find.NotFoundError)
-	if ok {
-		return true
-	}
-
-	_, ok = err.(*find.DefaultNotFoundError)
-	if ok {
-		return true
-	}
-
-	return false
-}
-
 func getFinder(dc *Datacenter) *find.Finder {
 	finder := find.NewFinder(dc.Client(), false)
 	finder.SetDatacenter(dc.Datacenter)
@@ -74,11 +60,11 @@ func getSCSIControllersOfType(vmDevices object.VirtualDeviceList, scsiType strin
 	return scsiControllers
 }
 
-// getAvailableSCSIController gets available SCSI Controller from list of given controllers, which has less than 15 disk devices.
-func getAvailableSCSIController(scsiControllers []*types.VirtualController) *types.VirtualController {
+// getAvailableSCSIController gets the first SCSI Controller from list of given controllers, which has a free unit number.
+func getAvailableSCSIController(vmDevices object.VirtualDeviceList, scsiControllers []*types.VirtualController) *types.VirtualController {
 	// get SCSI controller which has space for adding more devices
 	for _, controller := range scsiControllers {
-		if len(controller.Device) < SCSIControllerDeviceLimit {
+		if _, err := getNextUnitNumber(vmDevices, controller); err == nil {
 			return controller
 		}
 	}
@@ -222,3 +208,26 @@ func ExistsInList(needle string, haystack []string, caseSensitive bool) bool {
 	}
 	return false
 }
+
+// apiVersionAtLeast returns true when a vCenter API version, such as
+// "6.7.3", is the same as or later than the minimum version. The versions
+// are compared component-wise, the missing components counting as 0, and an
+// invalid version is never at least the minimum.
+func apiVersionAtLeast(apiVersion string, minVersion string) bool {
+	min := strings.Split(minVersion, ".")
+	items := strings.Split(apiVersion, ".")
+	for i := range min {
+		want, _ := strconv.Atoi(min[i])
+		got := 0
+		if i < len(items) {
+			var err error
+			if got, err = strconv.Atoi(items[i]); err != nil {
+				return false
+			}
+		}
+		if got != want {
+			return got > want
+		}
+	}
+	return true
+}
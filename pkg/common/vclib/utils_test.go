@@ -69,3 +69,22 @@ func TestUtils(t *testing.T) {
 		t.Errorf("unexpected error: %s", err)
 	}
 }
+
+func TestIsOnlineExtendSupported(t *testing.T) {
+	for version, supported := range map[string]bool{
+		"6.5":     false,
+		"6.7.3":   false,
+		"7.0":     false,
+		"7.0.1.0": false,
+		"7.0.2":   true,
+		"7.0.2.0": true,
+		"7.0.3.1": true,
+		"8.0":     true,
+		"":        false,
+		"invalid": false,
+	} {
+		if IsOnlineExtendSupported(version) != supported {
+			t.Errorf("IsOnlineExtendSupported(%q) should return %t", version, supported)
+		}
+	}
+}
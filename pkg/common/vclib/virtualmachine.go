@@ -22,6 +22,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/vmware/govmomi/guest"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25"
@@ -55,60 +56,147 @@ func (vm *VirtualMachine) DeleteVM(ctx context.Context) error {
 		klog.Errorf("Failed to delete the VM: %q. err: %+v", vm.InventoryPath, err)
 		return err
 	}
-	return destroyTask.Wait(ctx)
+	_, err = waitForTask(ctx, vm.Client(), destroyTask.Reference())
+	return err
+}
+
+// DiskAttachment is a disk to attach to a Virtual Machine with AttachDisks.
+type DiskAttachment struct {
+	VMDiskPath    string
+	VolumeOptions *VolumeOptions
 }
 
 // AttachDisk attaches the disk at location - vmDiskPath from Datastore - dsObj to the Virtual Machine
 // Additionally the disk can be configured with SPBM policy if volumeOptions.StoragePolicyID is non-empty.
 func (vm *VirtualMachine) AttachDisk(ctx context.Context, vmDiskPath string, volumeOptions *VolumeOptions) (string, error) {
+	diskUUIDs, errs := vm.AttachDisks(ctx, []DiskAttachment{{VMDiskPath: vmDiskPath, VolumeOptions: volumeOptions}})
+	return diskUUIDs[0], errs[0]
+}
+
+// AttachDisks attaches the disks to the Virtual Machine with a single reconfigure task.
+// It returns the UUID of each disk, or the error that prevented its attachment, in the
+// order of the disks. The disks that are already attached are left as they are.
+func (vm *VirtualMachine) AttachDisks(ctx context.Context, disks []DiskAttachment) ([]string, []error) {
+	diskUUIDs := make([]string, len(disks))
+	errs := make([]error, len(disks))
+
+	// the disks added by the reconfigure, and the index of each in disks
+	var added object.VirtualDeviceList
+	var indexes []int
+	var newSCSIControllers []types.BaseVirtualDevice
+	virtualMachineConfigSpec := types.VirtualMachineConfigSpec{}
+	for i, disk := range disks {
+		deviceConfigSpec, newSCSIController, diskUUID, err := vm.createAttachSpec(ctx, disk.VMDiskPath, disk.VolumeOptions, added)
+		if newSCSIController != nil {
+			newSCSIControllers = append(newSCSIControllers, newSCSIController)
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if deviceConfigSpec == nil {
+			diskUUIDs[i] = diskUUID
+			continue
+		}
+		added = append(added, deviceConfigSpec.Device)
+		indexes = append(indexes, i)
+		virtualMachineConfigSpec.DeviceChange = append(virtualMachineConfigSpec.DeviceChange, deviceConfigSpec)
+	}
+	if len(indexes) == 0 {
+		return diskUUIDs, errs
+	}
+
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to retrieve VM devices for VM: %q. err: %+v", vm.InventoryPath, err)
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return diskUUIDs, errs
+	}
+	requestTime := time.Now()
+	task, err := vm.Reconfigure(ctx, virtualMachineConfigSpec)
+	if err == nil {
+		_, err = waitForTask(ctx, vm.Client(), task.Reference())
+	}
+	RecordvSphereMetric(APIAttachVolume, requestTime, err)
+	if err != nil {
+		klog.Errorf("Failed to attach %d disks on VM: %q. err - %+v", len(indexes), vm.InventoryPath, err)
+		for _, newSCSIController := range newSCSIControllers {
+			vm.deleteController(ctx, newSCSIController, vmDevices)
+		}
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return diskUUIDs, errs
+	}
+
+	// Once the disks are attached, get their UUIDs.
+	for _, i := range indexes {
+		vmDiskPath := RemoveStorageClusterORFolderNameFromVDiskPath(disks[i].VMDiskPath)
+		diskUUIDs[i], errs[i] = vm.Datacenter.GetVirtualDiskPage83Data(ctx, vmDiskPath)
+		if errs[i] != nil {
+			klog.Errorf("Error occurred while getting Disk Info from VM: %q. err: %v", vm.InventoryPath, errs[i])
+			vm.DetachDisk(ctx, vmDiskPath)
+		}
+	}
+	return diskUUIDs, errs
+}
+
+// createAttachSpec creates the device change that attaches the disk at location - vmDiskPath
+// to the Virtual Machine, next to the disks added by the same reconfigure. It returns the
+// UUID of the disk instead when the disk is already attached, and the SCSI controller it
+// created for the disk, if any.
+func (vm *VirtualMachine) createAttachSpec(ctx context.Context, vmDiskPath string, volumeOptions *VolumeOptions,
+	added object.VirtualDeviceList) (*types.VirtualDeviceConfigSpec, types.BaseVirtualDevice, string, error) {
 	// Check if the diskControllerType is valid
 	if !CheckControllerSupported(volumeOptions.SCSIControllerType) {
-		return "", fmt.Errorf("Not a valid SCSI Controller Type. Valid options are %q", SCSIControllerTypeValidOptions())
+		return nil, nil, "", fmt.Errorf("Not a valid SCSI Controller Type. Valid options are %q", SCSIControllerTypeValidOptions())
+	}
+	if volumeOptions.DiskMode != "" && !CheckDiskModeSupported(volumeOptions.DiskMode) {
+		return nil, nil, "", fmt.Errorf("Not a valid disk mode. Valid options are %q", DiskModeValidType)
+	}
+	if volumeOptions.DiskSharing != "" && !CheckDiskSharingSupported(volumeOptions.DiskSharing) {
+		return nil, nil, "", fmt.Errorf("Not a valid disk sharing mode. Valid options are %q", DiskSharingValidType)
 	}
 	vmDiskPathCopy := vmDiskPath
 	vmDiskPath = RemoveStorageClusterORFolderNameFromVDiskPath(vmDiskPath)
 	attached, err := vm.IsDiskAttached(ctx, vmDiskPath)
 	if err != nil {
 		klog.Errorf("Error occurred while checking if disk is attached on VM: %q. vmDiskPath: %q, err: %+v", vm.InventoryPath, vmDiskPath, err)
-		return "", err
+		return nil, nil, "", err
 	}
 	// If disk is already attached, return the disk UUID
 	if attached {
 		diskUUID, _ := vm.Datacenter.GetVirtualDiskPage83Data(ctx, vmDiskPath)
-		return diskUUID, nil
+		return nil, nil, diskUUID, nil
 	}
 
 	if volumeOptions.StoragePolicyName != "" {
 		pbmClient, err := NewPbmClient(ctx, vm.Client())
 		if err != nil {
 			klog.Errorf("Error occurred while creating new pbmClient. err: %+v", err)
-			return "", err
+			return nil, nil, "", err
 		}
 
 		volumeOptions.StoragePolicyID, err = pbmClient.ProfileIDByName(ctx, volumeOptions.StoragePolicyName)
 		if err != nil {
 			klog.Errorf("Failed to get Profile ID by name: %s. err: %+v", volumeOptions.StoragePolicyName, err)
-			return "", err
+			return nil, nil, "", err
 		}
 	}
 
 	dsObj, err := vm.Datacenter.GetDatastoreByPath(ctx, vmDiskPathCopy)
 	if err != nil {
 		klog.Errorf("Failed to get datastore from vmDiskPath: %q. err: %+v", vmDiskPath, err)
-		return "", err
+		return nil, nil, "", err
 	}
 	// If disk is not attached, create a disk spec for disk to be attached to the VM.
-	disk, newSCSIController, err := vm.CreateDiskSpec(ctx, vmDiskPath, dsObj.Datastore, volumeOptions)
+	disk, newSCSIController, err := vm.createDiskSpec(ctx, vmDiskPath, dsObj.Datastore, volumeOptions, added)
 	if err != nil {
 		klog.Errorf("Error occurred while creating disk spec. err: %+v", err)
-		return "", err
+		return nil, newSCSIController, "", err
 	}
-	vmDevices, err := vm.Device(ctx)
-	if err != nil {
-		klog.Errorf("Failed to retrieve VM devices for VM: %q. err: %+v", vm.InventoryPath, err)
-		return "", err
-	}
-	virtualMachineConfigSpec := types.VirtualMachineConfigSpec{}
 	deviceConfigSpec := &types.VirtualDeviceConfigSpec{
 		Device:    disk,
 		Operation: types.VirtualDeviceConfigSpecOperationAdd,
@@ -120,38 +208,7 @@ func (vm *VirtualMachine) AttachDisk(ctx context.Context, vmDiskPath string, vol
 		}
 		deviceConfigSpec.Profile = append(deviceConfigSpec.Profile, profileSpec)
 	}
-	virtualMachineConfigSpec.DeviceChange = append(virtualMachineConfigSpec.DeviceChange, deviceConfigSpec)
-	requestTime := time.Now()
-	task, err := vm.Reconfigure(ctx, virtualMachineConfigSpec)
-	if err != nil {
-		RecordvSphereMetric(APIAttachVolume, requestTime, err)
-		klog.Errorf("Failed to attach the disk with storagePolicy: %q on VM: %q. err - %+v", volumeOptions.StoragePolicyID, vm.InventoryPath, err)
-		if newSCSIController != nil {
-			vm.deleteController(ctx, newSCSIController, vmDevices)
-		}
-		return "", err
-	}
-	err = task.Wait(ctx)
-	RecordvSphereMetric(APIAttachVolume, requestTime, err)
-	if err != nil {
-		klog.Errorf("Failed to attach the disk with storagePolicy: %+q on VM: %q. err - %+v", volumeOptions.StoragePolicyID, vm.InventoryPath, err)
-		if newSCSIController != nil {
-			vm.deleteController(ctx, newSCSIController, vmDevices)
-		}
-		return "", err
-	}
-
-	// Once disk is attached, get the disk UUID.
-	diskUUID, err := vm.Datacenter.GetVirtualDiskPage83Data(ctx, vmDiskPath)
-	if err != nil {
-		klog.Errorf("Error occurred while getting Disk Info from VM: %q. err: %v", vm.InventoryPath, err)
-		vm.DetachDisk(ctx, vmDiskPath)
-		if newSCSIController != nil {
-			vm.deleteController(ctx, newSCSIController, vmDevices)
-		}
-		return "", err
-	}
-	return diskUUID, nil
+	return deviceConfigSpec, newSCSIController, "", nil
 }
 
 // DetachDisk detaches the disk specified by vmDiskPath
@@ -168,7 +225,7 @@ func (vm *VirtualMachine) DetachDisk(ctx context.Context, vmDiskPath string) err
 	}
 	// Detach disk from VM
 	requestTime := time.Now()
-	err = vm.RemoveDevice(ctx, true, device)
+	err = vm.removeDevice(ctx, device)
 	RecordvSphereMetric(APIDetachVolume, requestTime, err)
 	if err != nil {
 		klog.Errorf("Error occurred while removing disk device for VM: %q. err: %v", vm.InventoryPath, err)
@@ -177,6 +234,84 @@ func (vm *VirtualMachine) DetachDisk(ctx context.Context, vmDiskPath string) err
 	return nil
 }
 
+// GetVirtualDiskPlacement returns the bus number of the SCSI controller the
+// disk specified by vmDiskPath is attached to, and the unit number of the
+// disk on that controller.
+func (vm *VirtualMachine) GetVirtualDiskPlacement(ctx context.Context, vmDiskPath string) (int32, int32, error) {
+	vmDiskPath = RemoveStorageClusterORFolderNameFromVDiskPath(vmDiskPath)
+	device, err := vm.getVirtualDeviceByPath(ctx, vmDiskPath)
+	if err != nil {
+		klog.Errorf("Disk ID not found for VM: %q with diskPath: %q", vm.InventoryPath, vmDiskPath)
+		return -1, -1, err
+	}
+	if device == nil {
+		return -1, -1, ErrNoDiskIDFound
+	}
+
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to retrieve VM devices for VM: %q. err: %+v", vm.InventoryPath, err)
+		return -1, -1, err
+	}
+
+	disk := device.GetVirtualDevice()
+	controller, ok := vmDevices.FindByKey(disk.ControllerKey).(types.BaseVirtualSCSIController)
+	if !ok || disk.UnitNumber == nil {
+		return -1, -1, fmt.Errorf("disk %q is not attached to a SCSI controller", vmDiskPath)
+	}
+
+	return controller.GetVirtualSCSIController().BusNumber, *disk.UnitNumber, nil
+}
+
+// GetVirtualDiskMode returns the mode and the sharing mode of the disk
+// specified by vmDiskPath.
+func (vm *VirtualMachine) GetVirtualDiskMode(ctx context.Context, vmDiskPath string) (string, string, error) {
+	vmDiskPath = RemoveStorageClusterORFolderNameFromVDiskPath(vmDiskPath)
+	device, err := vm.getVirtualDeviceByPath(ctx, vmDiskPath)
+	if err != nil {
+		klog.Errorf("Disk ID not found for VM: %q with diskPath: %q", vm.InventoryPath, vmDiskPath)
+		return "", "", err
+	}
+	if device == nil {
+		return "", "", ErrNoDiskIDFound
+	}
+
+	backing, ok := device.GetVirtualDevice().Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	if !ok {
+		return "", "", fmt.Errorf("disk %q does not have a flat backing", vmDiskPath)
+	}
+	return backing.DiskMode, backing.Sharing, nil
+}
+
+// GetSCSIDiskCounts returns the number of First Class Disks and the number of
+// other disks attached to the SCSI controllers of the VM.
+func (vm *VirtualMachine) GetSCSIDiskCounts(ctx context.Context) (int, int, error) {
+	vmDevices, err := vm.Device(ctx)
+	if err != nil {
+		klog.Errorf("Failed to retrieve VM devices for VM: %q. err: %+v", vm.InventoryPath, err)
+		return 0, 0, err
+	}
+
+	scsiControllerKeys := make(map[int32]bool)
+	for _, controller := range getSCSIControllers(vmDevices) {
+		scsiControllerKeys[controller.Key] = true
+	}
+
+	fcds, others := 0, 0
+	for _, device := range vmDevices.SelectByType((*types.VirtualDisk)(nil)) {
+		disk := device.(*types.VirtualDisk)
+		if !scsiControllerKeys[disk.ControllerKey] {
+			continue
+		}
+		if disk.VDiskId != nil {
+			fcds++
+		} else {
+			others++
+		}
+	}
+	return fcds, others, nil
+}
+
 // GetResourcePool gets the resource pool for VM.
 func (vm *VirtualMachine) GetResourcePool(ctx context.Context) (*object.ResourcePool, error) {
 	vmMoList, err := vm.Datacenter.GetVMMoList(ctx, []*VirtualMachine{vm}, []string{"resourcePool"})
@@ -190,18 +325,63 @@ func (vm *VirtualMachine) GetResourcePool(ctx context.Context) (*object.Resource
 // IsActive checks if the VM is active.
 // Returns true if VM is in poweredOn state.
 func (vm *VirtualMachine) IsActive(ctx context.Context) (bool, error) {
-	vmMoList, err := vm.Datacenter.GetVMMoList(ctx, []*VirtualMachine{vm}, []string{"summary"})
+	vmMoList, err := vm.Datacenter.GetVMMoList(ctx, []*VirtualMachine{vm}, []string{VirtualMachinePowerStateProperty})
 	if err != nil {
-		klog.Errorf("Failed to get VM Managed object with property summary. err: +%v", err)
+		klog.Errorf("Failed to get VM Managed object with property %s. err: +%v", VirtualMachinePowerStateProperty, err)
 		return false, err
 	}
-	if vmMoList[0].Summary.Runtime.PowerState == ActivePowerState {
+	if vmMoList[0].Runtime.PowerState == ActivePowerState {
 		return true, nil
 	}
 
 	return false, nil
 }
 
+// guestProcessPollInterval is how often the end of a program run in the
+// guest of a VM is polled for.
+var guestProcessPollInterval = 500 * time.Millisecond
+
+// SyncGuestFileSystems flushes the file systems of the guest of the VM to
+// its disks, by running the GuestSyncCommand with VMware Tools as the guest
+// user, and waits for it to exit until the context is done.
+func (vm *VirtualMachine) SyncGuestFileSystems(ctx context.Context, username string, password string) error {
+	opsManager := guest.NewOperationsManager(vm.Client(), vm.Reference())
+	processManager, err := opsManager.ProcessManager(ctx)
+	if err != nil {
+		klog.Errorf("Failed to get the guest process manager of VM %q. err: %+v", vm.InventoryPath, err)
+		return err
+	}
+
+	auth := &types.NamePasswordAuthentication{Username: username, Password: password}
+	pid, err := processManager.StartProgram(ctx, auth, &types.GuestProgramSpec{ProgramPath: GuestSyncCommand})
+	if err != nil {
+		klog.Errorf("Failed to run %s in the guest of VM %q. err: %+v", GuestSyncCommand, vm.InventoryPath, err)
+		return err
+	}
+
+	ticker := time.NewTicker(guestProcessPollInterval)
+	defer ticker.Stop()
+	for {
+		processes, err := processManager.ListProcesses(ctx, auth, []int64{pid})
+		if err != nil {
+			return err
+		}
+		if len(processes) == 1 && processes[0].EndTime != nil {
+			if processes[0].ExitCode != 0 {
+				return fmt.Errorf("%s exited with code %d in the guest of VM %q",
+					GuestSyncCommand, processes[0].ExitCode, vm.InventoryPath)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 // GetAllAccessibleDatastores gets the list of accessible Datastores for the given Virtual Machine
 func (vm *VirtualMachine) GetAllAccessibleDatastores(ctx context.Context) ([]*DatastoreInfo, error) {
 	host, err := vm.HostSystem(ctx)
@@ -244,15 +424,22 @@ func (vm *VirtualMachine) GetAllAccessibleDatastores(ctx context.Context) ([]*Da
 
 // CreateDiskSpec creates a disk spec for disk
 func (vm *VirtualMachine) CreateDiskSpec(ctx context.Context, diskPath string, dsObj *Datastore, volumeOptions *VolumeOptions) (*types.VirtualDisk, types.BaseVirtualDevice, error) {
+	return vm.createDiskSpec(ctx, diskPath, dsObj, volumeOptions, nil)
+}
+
+// createDiskSpec creates a disk spec for disk, next to the disks added by the same reconfigure.
+func (vm *VirtualMachine) createDiskSpec(ctx context.Context, diskPath string, dsObj *Datastore, volumeOptions *VolumeOptions,
+	added object.VirtualDeviceList) (*types.VirtualDisk, types.BaseVirtualDevice, error) {
 	var newSCSIController types.BaseVirtualDevice
 	vmDevices, err := vm.Device(ctx)
 	if err != nil {
 		klog.Errorf("Failed to retrieve VM devices. err: %+v", err)
 		return nil, nil, err
 	}
+	vmDevices = append(vmDevices, added...)
 	// find SCSI controller of particular type from VM devices
 	scsiControllersOfRequiredType := getSCSIControllersOfType(vmDevices, volumeOptions.SCSIControllerType)
-	scsiController := getAvailableSCSIController(scsiControllersOfRequiredType)
+	scsiController := getAvailableSCSIController(vmDevices, scsiControllersOfRequiredType)
 	if scsiController == nil {
 		newSCSIController, err = vm.createAndAttachSCSIController(ctx, volumeOptions.SCSIControllerType)
 		if err != nil {
@@ -265,9 +452,10 @@ func (vm *VirtualMachine) CreateDiskSpec(ctx context.Context, diskPath string, d
 			klog.Errorf("Failed to retrieve VM devices. err: %v", err)
 			return nil, nil, err
 		}
+		vmDevices = append(vmDevices, added...)
 		// verify scsi controller in virtual machine
 		scsiControllersOfRequiredType := getSCSIControllersOfType(vmDevices, volumeOptions.SCSIControllerType)
-		scsiController = getAvailableSCSIController(scsiControllersOfRequiredType)
+		scsiController = getAvailableSCSIController(vmDevices, scsiControllersOfRequiredType)
 		if scsiController == nil {
 			klog.Errorf("Cannot find SCSI controller of type: %q in VM", volumeOptions.SCSIControllerType)
 			// attempt clean up of scsi controller
@@ -282,8 +470,18 @@ func (vm *VirtualMachine) CreateDiskSpec(ctx context.Context, diskPath string, d
 		return nil, nil, err
 	}
 	*disk.UnitNumber = unitNumber
+	if len(added) > 0 {
+		// the disks added by the same reconfigure need distinct temporary keys
+		disk.Key = vmDevices.NewKey()
+	}
 	backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
 	backing.DiskMode = string(types.VirtualDiskModeIndependent_persistent)
+	if volumeOptions.DiskMode != "" {
+		backing.DiskMode = volumeOptions.DiskMode
+	}
+	if volumeOptions.DiskSharing != "" {
+		backing.Sharing = volumeOptions.DiskSharing
+	}
 
 	if volumeOptions.CapacityKB != 0 {
 		disk.CapacityInKB = int64(volumeOptions.CapacityKB)
@@ -418,7 +616,7 @@ func (vm *VirtualMachine) deleteController(ctx context.Context, controllerDevice
 		return ErrNoDevicesFound
 	}
 	device := controllerDeviceList[len(controllerDeviceList)-1]
-	err := vm.RemoveDevice(ctx, true, device)
+	err := vm.removeDevice(ctx, device)
 	if err != nil {
 		klog.Errorf("Error occurred while removing device on VM: %q. err: %+v", vm.InventoryPath, err)
 		return err
@@ -426,6 +624,26 @@ func (vm *VirtualMachine) deleteController(ctx context.Context, controllerDevice
 	return nil
 }
 
+// removeDevice removes a device from the VM, keeping its files, such as the
+// ones of a disk. Unlike RemoveDevice, the error of the reconfigure task
+// names the task.
+func (vm *VirtualMachine) removeDevice(ctx context.Context, device types.BaseVirtualDevice) error {
+	spec := types.VirtualMachineConfigSpec{
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Device:    device,
+				Operation: types.VirtualDeviceConfigSpecOperationRemove,
+			},
+		},
+	}
+	task, err := vm.Reconfigure(ctx, spec)
+	if err != nil {
+		return err
+	}
+	_, err = waitForTask(ctx, vm.Client(), task.Reference())
+	return err
+}
+
 // RenewVM renews this virtual machine with new client connection.
 func (vm *VirtualMachine) RenewVM(client *vim25.Client) VirtualMachine {
 	dc := Datacenter{Datacenter: object.NewDatacenter(client, vm.Datacenter.Reference())}
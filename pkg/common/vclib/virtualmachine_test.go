@@ -18,10 +18,16 @@ package vclib
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
 	"testing"
 
 	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 func TestVirtualMachine(t *testing.T) {
@@ -78,6 +84,26 @@ func TestVirtualMachine(t *testing.T) {
 			t.Error("no accessible datastores")
 		}
 
+		host, err := vm.HostSystem(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hostDatastores, err := GetHostDatastores(ctx, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hostDatastores.Name == "" {
+			t.Error("missing host name")
+		}
+		for _, ds := range all {
+			if !hostDatastores.Accessible(ds.Reference()) {
+				t.Errorf("datastore %s is not accessible from host %s", ds.Info.Name, hostDatastores.Name)
+			}
+		}
+		if hostDatastores.Accessible(types.ManagedObjectReference{Type: "Datastore", Value: "enoent"}) {
+			t.Error("unknown datastore is accessible")
+		}
+
 		_, err = vm.GetResourcePool(ctx)
 		if err != nil {
 			t.Error(err)
@@ -108,6 +134,21 @@ func TestVirtualMachine(t *testing.T) {
 				t.Error("missing uuid")
 			}
 
+			fcds, others, err := vm.GetSCSIDiskCounts(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			// vcsim keys the disk it creates with a VM by the temporary key
+			// of its controller, so the disk is only counted once it was
+			// attached again to the PVSCSI controller
+			expectOthers := 1
+			if expect {
+				expectOthers = 0
+			}
+			if fcds != 0 || others != expectOthers {
+				t.Errorf("fcds=%d, others=%d, expected %d non-FCD disks", fcds, others, expectOthers)
+			}
+
 			err = vm.DetachDisk(ctx, diskPath)
 			if err != nil {
 				t.Error(err)
@@ -142,3 +183,197 @@ func TestVirtualMachine(t *testing.T) {
 		}
 	}
 }
+
+func TestAttachDisks(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+
+	defer model.Remove()
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc := &VSphereConnection{Client: c.Client}
+
+	dc, err := GetDatacenter(ctx, vc, TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folders, err := dc.Folders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folder, err := dc.GetFolderByPath(ctx, folders.VmFolder.InventoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vms, err := folder.GetVirtualMachines(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vms) < 3 {
+		t.Fatal("not enough VMs")
+	}
+
+	// the disks of the other VMs are attached to the first one, next to
+	// its own disk which is already attached
+	var disks []DiskAttachment
+	for _, vm := range vms[:3] {
+		diskPath, err := vm.GetVirtualDiskPath(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		disks = append(disks, DiskAttachment{
+			VMDiskPath:    diskPath,
+			VolumeOptions: &VolumeOptions{SCSIControllerType: PVSCSIControllerType},
+		})
+	}
+	disks = append(disks, DiskAttachment{
+		VMDiskPath:    disks[1].VMDiskPath,
+		VolumeOptions: &VolumeOptions{SCSIControllerType: "ide"},
+	})
+
+	vm := vms[0]
+	uuids, errs := vm.AttachDisks(ctx, disks)
+	for i := range disks[:3] {
+		if errs[i] != nil {
+			t.Errorf("disk %d: %v", i, errs[i])
+		}
+		if uuids[i] == "" {
+			t.Errorf("disk %d: missing uuid", i)
+		}
+	}
+	if errs[3] == nil {
+		t.Error("expected error for an invalid controller type")
+	}
+
+	// vcsim keys the disks of its VMs by the temporary key of their
+	// controller, so only the placement of the added disks can be checked
+	units := make(map[[2]int32]bool)
+	for _, disk := range disks[1:3] {
+		bus, unit, err := vm.GetVirtualDiskPlacement(ctx, disk.VMDiskPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if units[[2]int32{bus, unit}] {
+			t.Errorf("disk %s shares bus %d unit %d", disk.VMDiskPath, bus, unit)
+		}
+		units[[2]int32{bus, unit}] = true
+	}
+}
+
+// wireCounter counts the bytes of the requests and responses of a client.
+type wireCounter struct {
+	http.RoundTripper
+	bytes int64
+}
+
+type wireCountingBody struct {
+	io.ReadCloser
+	bytes *int64
+}
+
+func (b *wireCountingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.bytes, int64(n))
+	return n, err
+}
+
+func (w *wireCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.ContentLength > 0 {
+		atomic.AddInt64(&w.bytes, req.ContentLength)
+	}
+	res, err := w.RoundTripper.RoundTrip(req)
+	if err == nil {
+		res.Body = &wireCountingBody{ReadCloser: res.Body, bytes: &w.bytes}
+	}
+	return res, err
+}
+
+// BenchmarkVirtualMachineProperties compares the bytes on the wire of the
+// retrievals of the properties of the VMs with the properties they were
+// retrieved with before, the full config, summary and guest, and with the
+// explicit property paths they are now retrieved with.
+func BenchmarkVirtualMachineProperties(b *testing.B) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	defer model.Remove()
+	err := model.Create()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	counter := &wireCounter{RoundTripper: c.Client.Client.Transport}
+	c.Client.Client.Transport = counter
+
+	var refs []types.ManagedObjectReference
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		refs = append(refs, obj.Reference())
+	}
+	pc := property.DefaultCollector(c.Client)
+
+	for _, retrieval := range []struct {
+		name             string
+		before, explicit []string
+	}{
+		{
+			name:     "node",
+			before:   []string{"guest", "summary"},
+			explicit: []string{VirtualMachineSummaryConfigProperty, VirtualMachineHostNameProperty, VirtualMachineGuestNetProperty},
+		},
+		{
+			name:     "search",
+			before:   []string{"config", "summary", "guest"},
+			explicit: []string{VirtualMachineUUIDProperty, VirtualMachineHostNameProperty},
+		},
+		{
+			name:     "power",
+			before:   []string{"summary"},
+			explicit: []string{VirtualMachinePowerStateProperty},
+		},
+	} {
+		for i, properties := range [][]string{retrieval.before, retrieval.explicit} {
+			name := retrieval.name + "/before"
+			if i > 0 {
+				name = retrieval.name + "/explicit"
+			}
+			properties := properties
+			b.Run(name, func(b *testing.B) {
+				atomic.StoreInt64(&counter.bytes, 0)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					var vms []mo.VirtualMachine
+					if err := pc.Retrieve(ctx, refs, properties, &vms); err != nil {
+						b.Fatal(err)
+					}
+					if len(vms) != len(refs) {
+						b.Fatalf("Expected the properties of %d VMs, got %d", len(refs), len(vms))
+					}
+				}
+				b.ReportMetric(float64(atomic.LoadInt64(&counter.bytes))/float64(b.N), "wire-bytes/op")
+			})
+		}
+	}
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package vclib
 
 import (
+	"sort"
 	"strings"
 
+	"github.com/vmware/govmomi/vim25/types"
 	"k8s.io/klog"
 )
 
@@ -33,6 +35,12 @@ type VolumeOptions struct {
 	StoragePolicyName      string
 	StoragePolicyID        string
 	SCSIControllerType     string
+	// DiskMode is the mode a disk is attached in, independent_persistent
+	// when empty, so that VM snapshots do not capture the disk.
+	DiskMode string
+	// DiskSharing is the sharing mode of a disk attached to several VMs.
+	// It is left to the vSphere default when empty.
+	DiskSharing string
 }
 
 var (
@@ -44,16 +52,50 @@ var (
 	}
 	// SCSIControllerValidType specifies the supported SCSI controllers
 	SCSIControllerValidType = []string{LSILogicControllerType, LSILogicSASControllerType, PVSCSIControllerType}
+	// DiskModeValidType specifies the supported disk modes
+	DiskModeValidType = []string{
+		string(types.VirtualDiskModePersistent),
+		string(types.VirtualDiskModeIndependent_persistent),
+		string(types.VirtualDiskModeIndependent_nonpersistent),
+	}
+	// DiskSharingValidType specifies the supported disk sharing modes
+	DiskSharingValidType = []string{
+		string(types.VirtualDiskSharingSharingNone),
+		string(types.VirtualDiskSharingSharingMultiWriter),
+	}
 )
 
-// DiskformatValidOptions generates Valid Options for Diskformat
+// CheckDiskModeSupported checks if the disk mode is valid
+func CheckDiskModeSupported(diskMode string) bool {
+	for _, m := range DiskModeValidType {
+		if diskMode == m {
+			return true
+		}
+	}
+	klog.Errorf("Not a valid disk mode. Valid options are %q", DiskModeValidType)
+	return false
+}
+
+// CheckDiskSharingSupported checks if the disk sharing mode is valid
+func CheckDiskSharingSupported(diskSharing string) bool {
+	for _, s := range DiskSharingValidType {
+		if diskSharing == s {
+			return true
+		}
+	}
+	klog.Errorf("Not a valid disk sharing mode. Valid options are %q", DiskSharingValidType)
+	return false
+}
+
+// DiskformatValidOptions generates Valid Options for Diskformat, sorted so
+// that the messages they appear in are stable
 func DiskformatValidOptions() string {
-	validopts := ""
+	diskformats := make([]string, 0, len(DiskFormatValidType))
 	for diskformat := range DiskFormatValidType {
-		validopts += diskformat + ", "
+		diskformats = append(diskformats, diskformat)
 	}
-	validopts = strings.TrimSuffix(validopts, ", ")
-	return validopts
+	sort.Strings(diskformats)
+	return strings.Join(diskformats, ", ")
 }
 
 // CheckDiskFormatSupported checks if the diskFormat is valid
@@ -96,6 +138,12 @@ func (volumeOptions VolumeOptions) VerifyVolumeOptions() bool {
 			return false
 		}
 	}
+	if volumeOptions.DiskMode != "" && !CheckDiskModeSupported(volumeOptions.DiskMode) {
+		return false
+	}
+	if volumeOptions.DiskSharing != "" && !CheckDiskSharingSupported(volumeOptions.DiskSharing) {
+		return false
+	}
 	// ThinDiskType is the default, so skip the validation.
 	if volumeOptions.DiskFormat != ThinDiskType {
 		isValid := CheckDiskFormatSupported(volumeOptions.DiskFormat)
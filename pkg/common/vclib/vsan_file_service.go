@@ -0,0 +1,298 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// The vSAN file service is served by the vSAN management endpoint of
+// vCenter, which govmomi has no bindings for. The types and methods below
+// are the subset of the vSAN management API the file shares need.
+const (
+	vsanPath      = "/vsanHealth"
+	vsanNamespace = "vsan"
+	vsanVersion   = "7.0"
+
+	// VsanDatastoreType is the summary type of the vSAN datastores.
+	VsanDatastoreType = "vsan"
+
+	// VsanFileShareProtocolNFSv4 is the protocol of the file shares and
+	// the key of their NFSv4.1 access points.
+	VsanFileShareProtocolNFSv4 = "NFSv4"
+	// VsanFileShareAccessPointNFSv4 is the key of the NFSv4.1 access point
+	// of a file share.
+	VsanFileShareAccessPointNFSv4 = "NFSv4.1"
+
+	// VsanFileShareReadWrite is the permission of the clients that may
+	// read and write a file share.
+	VsanFileShareReadWrite = "READ_WRITE"
+)
+
+var vsanFileServiceSystem = types.ManagedObjectReference{
+	Type:  "VsanFileServiceSystem",
+	Value: "vsan-cluster-file-service-system",
+}
+
+// VsanFileShareNetPermission grants the clients of a network access to a
+// file share.
+type VsanFileShareNetPermission struct {
+	Ips         string `xml:"ips"`
+	Permissions string `xml:"permissions,omitempty"`
+	AllowRoot   *bool  `xml:"allowRoot"`
+}
+
+// VsanFileShareConfig is the configuration of a file share. The quota is a
+// number of mebibytes with an M suffix.
+type VsanFileShareConfig struct {
+	Name          string                                  `xml:"name"`
+	DomainName    string                                  `xml:"domainName,omitempty"`
+	Quota         string                                  `xml:"quota,omitempty"`
+	Labels        []types.KeyValue                        `xml:"labels,omitempty"`
+	StoragePolicy *types.VirtualMachineDefinedProfileSpec `xml:"storagePolicy,omitempty,typeattr"`
+	Permission    []VsanFileShareNetPermission            `xml:"permission,omitempty"`
+	Protocols     []string                                `xml:"protocols,omitempty"`
+}
+
+// VsanFileShareRuntimeInfo is the runtime state of a file share.
+type VsanFileShareRuntimeInfo struct {
+	UsedCapacity int64            `xml:"usedCapacity,omitempty"`
+	Hostname     string           `xml:"hostname,omitempty"`
+	Address      string           `xml:"address,omitempty"`
+	AccessPoints []types.KeyValue `xml:"accessPoints,omitempty"`
+}
+
+// VsanFileShare is a file share of the vSAN file service of a cluster.
+type VsanFileShare struct {
+	UUID    string                    `xml:"uuid"`
+	Config  *VsanFileShareConfig      `xml:"config,omitempty"`
+	Runtime *VsanFileShareRuntimeInfo `xml:"runtime,omitempty"`
+}
+
+// AccessPoint returns the access point of the file share for a protocol,
+// such as VsanFileShareAccessPointNFSv4, in the host:/path form.
+func (share *VsanFileShare) AccessPoint(protocol string) string {
+	if share.Runtime == nil {
+		return ""
+	}
+	for _, kv := range share.Runtime.AccessPoints {
+		if kv.Key == protocol {
+			return kv.Value
+		}
+	}
+	return ""
+}
+
+// VsanFileShareQuerySpec selects the file shares to query.
+type VsanFileShareQuerySpec struct {
+	DomainName string   `xml:"domainName,omitempty"`
+	Uuids      []string `xml:"uuids,omitempty"`
+	Names      []string `xml:"names,omitempty"`
+}
+
+type vsanClusterCreateFileShare struct {
+	This    types.ManagedObjectReference `xml:"_this"`
+	Config  VsanFileShareConfig          `xml:"config"`
+	Cluster types.ManagedObjectReference `xml:"cluster"`
+}
+
+type vsanClusterCreateFileShareResponse struct {
+	Returnval types.ManagedObjectReference `xml:"returnval"`
+}
+
+type vsanClusterCreateFileShareBody struct {
+	Req    *vsanClusterCreateFileShare         `xml:"urn:vsan VsanClusterCreateFileShare,omitempty"`
+	Res    *vsanClusterCreateFileShareResponse `xml:"urn:vsan VsanClusterCreateFileShareResponse,omitempty"`
+	Fault_ *soap.Fault                         `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *vsanClusterCreateFileShareBody) Fault() *soap.Fault { return b.Fault_ }
+
+type vsanClusterRemoveFileShare struct {
+	This      types.ManagedObjectReference `xml:"_this"`
+	ShareUUID string                       `xml:"shareUuid"`
+	Cluster   types.ManagedObjectReference `xml:"cluster"`
+	Force     bool                         `xml:"force"`
+}
+
+type vsanClusterRemoveFileShareResponse struct {
+	Returnval types.ManagedObjectReference `xml:"returnval"`
+}
+
+type vsanClusterRemoveFileShareBody struct {
+	Req    *vsanClusterRemoveFileShare         `xml:"urn:vsan VsanClusterRemoveFileShare,omitempty"`
+	Res    *vsanClusterRemoveFileShareResponse `xml:"urn:vsan VsanClusterRemoveFileShareResponse,omitempty"`
+	Fault_ *soap.Fault                         `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *vsanClusterRemoveFileShareBody) Fault() *soap.Fault { return b.Fault_ }
+
+type vsanClusterQueryFileShares struct {
+	This      types.ManagedObjectReference `xml:"_this"`
+	QuerySpec VsanFileShareQuerySpec       `xml:"querySpec"`
+	Cluster   types.ManagedObjectReference `xml:"cluster"`
+}
+
+type vsanClusterQueryFileSharesResponse struct {
+	Returnval struct {
+		FileShares []VsanFileShare `xml:"fileShares,omitempty"`
+	} `xml:"returnval"`
+}
+
+type vsanClusterQueryFileSharesBody struct {
+	Req    *vsanClusterQueryFileShares         `xml:"urn:vsan VsanClusterQueryFileShares,omitempty"`
+	Res    *vsanClusterQueryFileSharesResponse `xml:"urn:vsan VsanClusterQueryFileSharesResponse,omitempty"`
+	Fault_ *soap.Fault                         `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *vsanClusterQueryFileSharesBody) Fault() *soap.Fault { return b.Fault_ }
+
+// VsanFileServiceClient manages the file shares of the vSAN file service
+// of the clusters of a vCenter.
+type VsanFileServiceClient struct {
+	*soap.Client
+	vim *vim25.Client
+}
+
+// NewVsanFileServiceClient returns a client of the vSAN management endpoint
+// of the vCenter of a client, sharing its session, reached at its storage
+// endpoint when one is configured.
+func NewVsanFileServiceClient(client *vim25.Client) *VsanFileServiceClient {
+	sc := newStorageServiceClient(client, vsanPath, vsanNamespace)
+	sc.Version = vsanVersion
+	return &VsanFileServiceClient{Client: sc, vim: client}
+}
+
+// CreateFileShare creates a file share in the vSAN file service of a
+// cluster and waits for it to be created.
+func (c *VsanFileServiceClient) CreateFileShare(ctx context.Context,
+	cluster types.ManagedObjectReference, config VsanFileShareConfig) error {
+
+	var reqBody, resBody vsanClusterCreateFileShareBody
+	reqBody.Req = &vsanClusterCreateFileShare{
+		This:    vsanFileServiceSystem,
+		Config:  config,
+		Cluster: cluster,
+	}
+	if err := c.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+		klog.Errorf("Failed to create file share %s in cluster %s. err: %v", config.Name, cluster.Value, err)
+		return err
+	}
+	return c.wait(ctx, resBody.Res.Returnval)
+}
+
+// RemoveFileShare removes a file share from the vSAN file service of a
+// cluster and waits for it to be removed.
+func (c *VsanFileServiceClient) RemoveFileShare(ctx context.Context,
+	cluster types.ManagedObjectReference, uuid string) error {
+
+	var reqBody, resBody vsanClusterRemoveFileShareBody
+	reqBody.Req = &vsanClusterRemoveFileShare{
+		This:      vsanFileServiceSystem,
+		ShareUUID: uuid,
+		Cluster:   cluster,
+	}
+	if err := c.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+		klog.Errorf("Failed to remove file share %s from cluster %s. err: %v", uuid, cluster.Value, err)
+		return err
+	}
+	return c.wait(ctx, resBody.Res.Returnval)
+}
+
+// QueryFileShares returns the file shares of the vSAN file service of a
+// cluster that match the query.
+func (c *VsanFileServiceClient) QueryFileShares(ctx context.Context,
+	cluster types.ManagedObjectReference, spec VsanFileShareQuerySpec) ([]VsanFileShare, error) {
+
+	var reqBody, resBody vsanClusterQueryFileSharesBody
+	reqBody.Req = &vsanClusterQueryFileShares{
+		This:      vsanFileServiceSystem,
+		QuerySpec: spec,
+		Cluster:   cluster,
+	}
+	if err := c.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+		klog.Errorf("Failed to query the file shares of cluster %s. err: %v", cluster.Value, err)
+		return nil, err
+	}
+	return resBody.Res.Returnval.FileShares, nil
+}
+
+// GetFileShare returns a file share of a cluster by UUID or, when the UUID
+// is empty, by name. ErrNoFileShareFound is returned when no file share
+// matches.
+func (c *VsanFileServiceClient) GetFileShare(ctx context.Context,
+	cluster types.ManagedObjectReference, uuid string, name string) (*VsanFileShare, error) {
+
+	spec := VsanFileShareQuerySpec{}
+	if len(uuid) > 0 {
+		spec.Uuids = []string{uuid}
+	} else {
+		spec.Names = []string{name}
+	}
+	shares, err := c.QueryFileShares(ctx, cluster, spec)
+	if err != nil {
+		return nil, err
+	}
+	for i := range shares {
+		if (len(uuid) > 0 && shares[i].UUID == uuid) || (len(uuid) == 0 && shares[i].Config != nil && shares[i].Config.Name == name) {
+			return &shares[i], nil
+		}
+	}
+	return nil, ErrNoFileShareFound
+}
+
+// wait waits for a task of the vSAN file service, which runs in vCenter.
+func (c *VsanFileServiceClient) wait(ctx context.Context, ref types.ManagedObjectReference) error {
+	_, err := waitForTask(ctx, c.vim, ref)
+	return err
+}
+
+// GetVsanCluster returns the cluster of a vSAN datastore, whose file service
+// serves the file shares stored on the datastore. ErrNotVsanDatastore is
+// returned for the other datastores.
+func (ds *Datastore) GetVsanCluster(ctx context.Context) (types.ManagedObjectReference, error) {
+	var dsMo mo.Datastore
+	pc := property.DefaultCollector(ds.Client())
+	err := pc.RetrieveOne(ctx, ds.Datastore.Reference(), []string{DatastoreSummaryProperty, DatastoreHostProperty}, &dsMo)
+	if err != nil {
+		klog.Errorf("Failed to retrieve the summary and hosts of datastore %s. err: %v", ds.Reference(), err)
+		return types.ManagedObjectReference{}, err
+	}
+	if dsMo.Summary.Type != VsanDatastoreType || len(dsMo.Host) == 0 {
+		return types.ManagedObjectReference{}, ErrNotVsanDatastore
+	}
+
+	var host mo.HostSystem
+	err = pc.RetrieveOne(ctx, dsMo.Host[0].Key, []string{"parent"}, &host)
+	if err != nil {
+		klog.Errorf("Failed to retrieve the parent of host %s. err: %v", dsMo.Host[0].Key, err)
+		return types.ManagedObjectReference{}, err
+	}
+	if host.Parent == nil || host.Parent.Type != "ClusterComputeResource" {
+		return types.ManagedObjectReference{}, fmt.Errorf("host %s of vSAN datastore %s is not in a cluster",
+			dsMo.Host[0].Key.Value, ds.Reference().Value)
+	}
+	return *host.Parent, nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func init() {
+	for name, req := range map[string]interface{}{
+		"VsanClusterCreateFileShare": vsanClusterCreateFileShare{},
+		"VsanClusterRemoveFileShare": vsanClusterRemoveFileShare{},
+		"VsanClusterQueryFileShares": vsanClusterQueryFileShares{},
+	} {
+		types.Add(vsanNamespace+":"+name, reflect.TypeOf(req))
+	}
+}
+
+// fileServiceSystem simulates the vSAN file service of the clusters.
+type fileServiceSystem struct {
+	shares map[string]VsanFileShare
+}
+
+func (s *fileServiceSystem) Reference() types.ManagedObjectReference {
+	return vsanFileServiceSystem
+}
+
+func (s *fileServiceSystem) VsanClusterCreateFileShare(req *vsanClusterCreateFileShare) soap.HasFault {
+	task := simulator.CreateTask(req.Cluster, "createFileShare", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		for _, share := range s.shares {
+			if share.Config.Name == req.Config.Name {
+				return nil, &types.AlreadyExists{Name: req.Config.Name}
+			}
+		}
+		uuid := fmt.Sprintf("share-%d", len(s.shares)+1)
+		s.shares[uuid] = VsanFileShare{
+			UUID:   uuid,
+			Config: &req.Config,
+			Runtime: &VsanFileShareRuntimeInfo{
+				AccessPoints: []types.KeyValue{
+					{Key: VsanFileShareAccessPointNFSv4, Value: "10.0.0.1:/vsanfs/" + req.Config.Name},
+				},
+			},
+		}
+		return nil, nil
+	})
+	return &vsanClusterCreateFileShareBody{
+		Res: &vsanClusterCreateFileShareResponse{Returnval: task.Run()},
+	}
+}
+
+func (s *fileServiceSystem) VsanClusterRemoveFileShare(req *vsanClusterRemoveFileShare) soap.HasFault {
+	task := simulator.CreateTask(req.Cluster, "removeFileShare", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		if _, ok := s.shares[req.ShareUUID]; !ok {
+			return nil, &types.NotFound{}
+		}
+		delete(s.shares, req.ShareUUID)
+		return nil, nil
+	})
+	return &vsanClusterRemoveFileShareBody{
+		Res: &vsanClusterRemoveFileShareResponse{Returnval: task.Run()},
+	}
+}
+
+func (s *fileServiceSystem) VsanClusterQueryFileShares(req *vsanClusterQueryFileShares) soap.HasFault {
+	res := &vsanClusterQueryFileSharesResponse{}
+	for _, share := range s.shares {
+		for _, uuid := range req.QuerySpec.Uuids {
+			if share.UUID == uuid {
+				res.Returnval.FileShares = append(res.Returnval.FileShares, share)
+			}
+		}
+		for _, name := range req.QuerySpec.Names {
+			if share.Config.Name == name {
+				res.Returnval.FileShares = append(res.Returnval.FileShares, share)
+			}
+		}
+	}
+	return &vsanClusterQueryFileSharesBody{Res: res}
+}
+
+func TestVsanFileService(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	defer model.Remove()
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vsan := simulator.NewRegistry()
+	vsan.Namespace = vsanNamespace
+	vsan.Path = vsanPath
+	vsan.Put(&fileServiceSystem{shares: make(map[string]VsanFileShare)})
+	model.Service.RegisterSDK(vsan)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc := &VSphereConnection{Client: c.Client}
+	dc, err := GetDatacenter(ctx, vc, TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the datastore of a cluster is a vSAN datastore
+	simCluster := simulator.Map.Any("ClusterComputeResource").(*simulator.ClusterComputeResource)
+	simDS := simulator.Map.Any("Datastore").(*simulator.Datastore)
+	dsInfo, err := dc.GetDatastoreByName(ctx, simDS.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dsInfo.GetVsanCluster(ctx); err != ErrNotVsanDatastore {
+		t.Errorf("GetVsanCluster of a VMFS datastore should fail with %v: %v", ErrNotVsanDatastore, err)
+	}
+	simDS.Summary.Type = VsanDatastoreType
+	simDS.Host = []types.DatastoreHostMount{{Key: simCluster.Host[0]}}
+	cluster, err := dsInfo.GetVsanCluster(ctx)
+	if err != nil {
+		t.Fatalf("GetVsanCluster failed: %v", err)
+	}
+	if cluster != simCluster.Reference() {
+		t.Errorf("Expected cluster %v, got %v", simCluster.Reference(), cluster)
+	}
+
+	client := NewVsanFileServiceClient(c.Client)
+
+	if _, err = client.GetFileShare(ctx, cluster, "", "pvc-1"); err != ErrNoFileShareFound {
+		t.Errorf("GetFileShare of a missing share should fail with %v: %v", ErrNoFileShareFound, err)
+	}
+
+	allowRoot := true
+	config := VsanFileShareConfig{
+		Name:  "pvc-1",
+		Quota: "1024M",
+		Permission: []VsanFileShareNetPermission{
+			{Ips: "*", Permissions: VsanFileShareReadWrite, AllowRoot: &allowRoot},
+		},
+		Protocols: []string{VsanFileShareProtocolNFSv4},
+	}
+	if err = client.CreateFileShare(ctx, cluster, config); err != nil {
+		t.Fatalf("CreateFileShare failed: %v", err)
+	}
+	if err = client.CreateFileShare(ctx, cluster, config); err == nil {
+		t.Error("CreateFileShare of an existing share should fail")
+	}
+
+	share, err := client.GetFileShare(ctx, cluster, "", "pvc-1")
+	if err != nil {
+		t.Fatalf("GetFileShare by name failed: %v", err)
+	}
+	if share.Config.Quota != "1024M" || len(share.Config.Permission) != 1 || !*share.Config.Permission[0].AllowRoot {
+		t.Errorf("The config of the share should round trip: %+v", share.Config)
+	}
+	if ap := share.AccessPoint(VsanFileShareAccessPointNFSv4); ap != "10.0.0.1:/vsanfs/pvc-1" {
+		t.Errorf("Unexpected NFSv4.1 access point %q", ap)
+	}
+
+	if _, err = client.GetFileShare(ctx, cluster, share.UUID, ""); err != nil {
+		t.Errorf("GetFileShare by UUID failed: %v", err)
+	}
+
+	if err = client.RemoveFileShare(ctx, cluster, share.UUID); err != nil {
+		t.Fatalf("RemoveFileShare failed: %v", err)
+	}
+	if _, err = client.GetFileShare(ctx, cluster, share.UUID, ""); err != ErrNoFileShareFound {
+		t.Errorf("GetFileShare of a removed share should fail with %v: %v", ErrNoFileShareFound, err)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"time"
+
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// The vslm endpoint of vCenter 7.0 and later keeps a global catalog of the
+// FCDs of all of the datastores, which can be queried in a single call
+// rather than listing the FCDs of every datastore. govmomi has no bindings
+// for it, the types and methods below are the subset of the vslm API needed
+// to find FCDs.
+const (
+	// VslmCatalogMinAPIVersion is the first vCenter API version that serves
+	// the global catalog of the FCDs.
+	VslmCatalogMinAPIVersion = "7.0"
+
+	// VslmQueryFieldID queries the FCDs by ID.
+	VslmQueryFieldID = "id"
+	// VslmQueryFieldName queries the FCDs by name.
+	VslmQueryFieldName = "name"
+
+	// VslmQueryOperatorEquals matches the FCDs whose field equals one of
+	// the values.
+	VslmQueryOperatorEquals = "equals"
+	// VslmQueryOperatorGreaterThan matches the FCDs whose field is greater
+	// than the value.
+	VslmQueryOperatorGreaterThan = "greaterThan"
+
+	vslmPath      = "/vslm/sdk"
+	vslmNamespace = "vslm"
+
+	// vslmPageSize is the maximum number of FCDs returned by a query
+	vslmPageSize = 1000
+)
+
+var vslmServiceInstance = types.ManagedObjectReference{
+	Type:  "VslmServiceInstance",
+	Value: "ServiceInstance",
+}
+
+// IsVslmCatalogSupported returns true when a vCenter API version serves the
+// global catalog of the FCDs.
+func IsVslmCatalogSupported(apiVersion string) bool {
+	return apiVersionAtLeast(apiVersion, VslmCatalogMinAPIVersion)
+}
+
+// VslmQuerySpec is a condition of a query of the catalog.
+type VslmQuerySpec struct {
+	QueryField    string   `xml:"queryField"`
+	QueryOperator string   `xml:"queryOperator"`
+	QueryValue    []string `xml:"queryValue,omitempty"`
+}
+
+// VslmObjectResult is an FCD found in the catalog.
+type VslmObjectResult struct {
+	ID           types.ID   `xml:"id"`
+	Name         string     `xml:"name,omitempty"`
+	CapacityInMB int64      `xml:"capacityInMB"`
+	CreateTime   *time.Time `xml:"createTime"`
+	DatastoreURL string     `xml:"datastoreUrl,omitempty"`
+	DiskPath     string     `xml:"diskPath,omitempty"`
+}
+
+// VslmAboutInfo describes the vslm endpoint.
+type VslmAboutInfo struct {
+	Name       string `xml:"name"`
+	APIVersion string `xml:"apiVersion"`
+}
+
+type vslmServiceInstanceContent struct {
+	AboutInfo             VslmAboutInfo                `xml:"aboutInfo"`
+	VStorageObjectManager types.ManagedObjectReference `xml:"vStorageObjectManager"`
+}
+
+type vslmQueryResult struct {
+	AllRecordsReturned bool               `xml:"allRecordsReturned"`
+	ID                 []types.ID         `xml:"id,omitempty"`
+	QueryResults       []VslmObjectResult `xml:"queryResults,omitempty"`
+}
+
+type vslmRetrieveContent struct {
+	This types.ManagedObjectReference `xml:"_this"`
+}
+
+type vslmRetrieveContentResponse struct {
+	Returnval vslmServiceInstanceContent `xml:"returnval"`
+}
+
+type vslmRetrieveContentBody struct {
+	Req    *vslmRetrieveContent         `xml:"urn:vslm RetrieveContent,omitempty"`
+	Res    *vslmRetrieveContentResponse `xml:"urn:vslm RetrieveContentResponse,omitempty"`
+	Fault_ *soap.Fault                  `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *vslmRetrieveContentBody) Fault() *soap.Fault { return b.Fault_ }
+
+type vslmListVStorageObjectForSpec struct {
+	This      types.ManagedObjectReference `xml:"_this"`
+	Query     []VslmQuerySpec              `xml:"query,omitempty"`
+	MaxResult int32                        `xml:"maxResult"`
+}
+
+type vslmListVStorageObjectForSpecResponse struct {
+	Returnval *vslmQueryResult `xml:"returnval,omitempty"`
+}
+
+type vslmListVStorageObjectForSpecBody struct {
+	Req    *vslmListVStorageObjectForSpec         `xml:"urn:vslm VslmListVStorageObjectForSpec,omitempty"`
+	Res    *vslmListVStorageObjectForSpecResponse `xml:"urn:vslm VslmListVStorageObjectForSpecResponse,omitempty"`
+	Fault_ *soap.Fault                            `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *vslmListVStorageObjectForSpecBody) Fault() *soap.Fault { return b.Fault_ }
+
+// VslmClient queries the global catalog of the FCDs of a vCenter.
+type VslmClient struct {
+	*soap.Client
+
+	// About describes the vslm endpoint
+	About   VslmAboutInfo
+	manager types.ManagedObjectReference
+	// pageSize is the maximum number of FCDs returned by a query, the
+	// queries returning more are paginated
+	pageSize int32
+}
+
+// NewVslmClient returns a client of the vslm endpoint of the vCenter of a
+// client, sharing its session, reached at its storage endpoint when one is
+// configured. It fails when the vCenter does not serve the catalog.
+func NewVslmClient(ctx context.Context, client *vim25.Client) (*VslmClient, error) {
+	sc := newStorageServiceClient(client, vslmPath, vslmNamespace)
+	sc.Version = client.ServiceContent.About.ApiVersion
+
+	var reqBody, resBody vslmRetrieveContentBody
+	reqBody.Req = &vslmRetrieveContent{This: vslmServiceInstance}
+	if err := sc.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+		klog.Errorf("Failed to retrieve the content of the vslm endpoint. err: %v", err)
+		return nil, err
+	}
+
+	content := resBody.Res.Returnval
+	return &VslmClient{
+		Client:   sc,
+		About:    content.AboutInfo,
+		manager:  content.VStorageObjectManager,
+		pageSize: vslmPageSize,
+	}, nil
+}
+
+// Query returns the FCDs of the catalog that match all of the conditions,
+// or all of the FCDs without conditions, sorted by ID. The results are
+// retrieved a page at a time, each page starting after the last ID of the
+// previous one.
+func (c *VslmClient) Query(ctx context.Context, query ...VslmQuerySpec) ([]VslmObjectResult, error) {
+	var results []VslmObjectResult
+	var lastID string
+	for {
+		page := query
+		if len(lastID) > 0 {
+			page = append(append([]VslmQuerySpec{}, query...), VslmQuerySpec{
+				QueryField:    VslmQueryFieldID,
+				QueryOperator: VslmQueryOperatorGreaterThan,
+				QueryValue:    []string{lastID},
+			})
+		}
+
+		var reqBody, resBody vslmListVStorageObjectForSpecBody
+		reqBody.Req = &vslmListVStorageObjectForSpec{
+			This:      c.manager,
+			Query:     page,
+			MaxResult: c.pageSize,
+		}
+		if err := c.RoundTrip(ctx, &reqBody, &resBody); err != nil {
+			klog.Errorf("Failed to query the vslm catalog. err: %v", err)
+			return nil, err
+		}
+
+		result := resBody.Res.Returnval
+		if result == nil {
+			return results, nil
+		}
+		results = append(results, result.QueryResults...)
+		if result.AllRecordsReturned || len(result.QueryResults) == 0 {
+			return results, nil
+		}
+		lastID = result.QueryResults[len(result.QueryResults)-1].ID.Id
+		klog.V(LogLevel).Infof("Querying the next page of the vslm catalog after FCD %s", lastID)
+	}
+}
+
+// VslmQueryByID returns the condition of a query of the FCD with the ID.
+func VslmQueryByID(fcdID string) VslmQuerySpec {
+	return VslmQuerySpec{
+		QueryField:    VslmQueryFieldID,
+		QueryOperator: VslmQueryOperatorEquals,
+		QueryValue:    []string{fcdID},
+	}
+}
+
+// VslmQueryByName returns the condition of a query of the FCDs with the
+// name.
+func VslmQueryByName(name string) VslmQuerySpec {
+	return VslmQuerySpec{
+		QueryField:    VslmQueryFieldName,
+		QueryOperator: VslmQueryOperatorEquals,
+		QueryValue:    []string{name},
+	}
+}
+
+// CatalogFirstClassDisks returns the FCDs found in the catalog that are on
+// the datastores of the datacenter, each of them retrieved from its
+// datastore. Like in a scan, the parent of an FCD on a datastore of a
+// datastore cluster is the datastore cluster. When allowed is not nil, only
+// the FCDs whose parent it returns true for are returned. The FCDs deleted
+// since they were found are left out.
+func (dc *Datacenter) CatalogFirstClassDisks(ctx context.Context,
+	results []VslmObjectResult, allowed func(name string) bool) ([]*FirstClassDiskInfo, error) {
+
+	datastores, err := dc.GetAllDatastores(ctx)
+	if err != nil {
+		klog.Errorf("GetAllDatastores failed. Err: %v", err)
+		return nil, err
+	}
+
+	storagePods, err := dc.GetAllDatastoreClusters(ctx, true)
+	if err != nil && err != ErrNoDataStoreClustersFound {
+		klog.Errorf("GetAllDatastoreClusters failed. Err: %v", err)
+		return nil, err
+	}
+	parents := make(map[string]*StoragePodInfo)
+	for _, storagePod := range storagePods {
+		if err := storagePod.PopulateChildDatastoreInfos(ctx, false); err != nil {
+			klog.Errorf("PopulateChildDatastoreInfos failed. Err: %v", err)
+			return nil, err
+		}
+		for _, child := range storagePod.DatastoreInfos {
+			parents[child.Info.Url] = storagePod
+		}
+	}
+
+	firstClassDisks := make([]*FirstClassDiskInfo, 0)
+	for _, result := range results {
+		datastore, ok := datastores[result.DatastoreURL]
+		if !ok {
+			continue
+		}
+		storagePod := parents[result.DatastoreURL]
+		name := datastore.Info.Name
+		if storagePod != nil {
+			name = storagePod.Summary.Name
+		}
+		if allowed != nil && !allowed(name) {
+			continue
+		}
+
+		fcd, err := datastore.GetFirstClassDiskInfoByID(ctx, result.ID.Id)
+		if IsNotFound(err) {
+			klog.V(LogLevel).Infof("FCD %s was deleted since it was found in the vslm catalog", result.ID.Id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if storagePod != nil {
+			fcd.ParentType = TypeDatastoreCluster
+			fcd.StoragePod = storagePod.StoragePod
+			fcd.StoragePodInfo = storagePod
+		}
+		firstClassDisks = append(firstClassDisks, fcd)
+	}
+	return firstClassDisks, nil
+}
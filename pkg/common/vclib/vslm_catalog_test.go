@@ -0,0 +1,239 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vclib
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func init() {
+	for name, req := range map[string]interface{}{
+		"RetrieveContent":               vslmRetrieveContent{},
+		"VslmListVStorageObjectForSpec": vslmListVStorageObjectForSpec{},
+	} {
+		types.Add(vslmNamespace+":"+name, reflect.TypeOf(req))
+	}
+}
+
+var vslmManagerSimRef = types.ManagedObjectReference{
+	Type:  "VslmVStorageObjectManager",
+	Value: "VStorageObjectManager",
+}
+
+// vslmServiceInstanceSim simulates the service instance of the vslm
+// endpoint of a vCenter.
+type vslmServiceInstanceSim struct{}
+
+func (s *vslmServiceInstanceSim) Reference() types.ManagedObjectReference {
+	return vslmServiceInstance
+}
+
+func (s *vslmServiceInstanceSim) RetrieveContent(req *vslmRetrieveContent) soap.HasFault {
+	return &vslmRetrieveContentBody{
+		Res: &vslmRetrieveContentResponse{Returnval: vslmServiceInstanceContent{
+			AboutInfo:             VslmAboutInfo{Name: "VMware Virtual Storage Lifecycle Manager", APIVersion: "7.0"},
+			VStorageObjectManager: vslmManagerSimRef,
+		}},
+	}
+}
+
+// vslmManagerSim simulates the catalog of the FCDs of a vCenter, counting
+// the queries it serves.
+type vslmManagerSim struct {
+	objects []VslmObjectResult
+	queries int
+}
+
+func (m *vslmManagerSim) Reference() types.ManagedObjectReference {
+	return vslmManagerSimRef
+}
+
+func (m *vslmManagerSim) VslmListVStorageObjectForSpec(req *vslmListVStorageObjectForSpec) soap.HasFault {
+	m.queries++
+
+	matches := func(object VslmObjectResult, spec VslmQuerySpec) bool {
+		value := object.ID.Id
+		if spec.QueryField == VslmQueryFieldName {
+			value = object.Name
+		}
+		if spec.QueryOperator == VslmQueryOperatorGreaterThan {
+			return value > spec.QueryValue[0]
+		}
+		return ExistsInList(value, spec.QueryValue, true)
+	}
+
+	result := &vslmQueryResult{AllRecordsReturned: true}
+	for _, object := range m.objects {
+		matched := true
+		for _, spec := range req.Query {
+			matched = matched && matches(object, spec)
+		}
+		if !matched {
+			continue
+		}
+		if len(result.QueryResults) == int(req.MaxResult) {
+			result.AllRecordsReturned = false
+			break
+		}
+		result.ID = append(result.ID, object.ID)
+		result.QueryResults = append(result.QueryResults, object)
+	}
+	return &vslmListVStorageObjectForSpecBody{
+		Res: &vslmListVStorageObjectForSpecResponse{Returnval: result},
+	}
+}
+
+func TestIsVslmCatalogSupported(t *testing.T) {
+	for version, supported := range map[string]bool{
+		"6.5":     false,
+		"6.7.3":   false,
+		"7.0":     true,
+		"7.0.1.0": true,
+		"":        false,
+		"invalid": false,
+	} {
+		if IsVslmCatalogSupported(version) != supported {
+			t.Errorf("IsVslmCatalogSupported(%q) should return %t", version, supported)
+		}
+	}
+}
+
+func TestVslmClient(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	model.Datastore = 2
+	defer model.Remove()
+	err := model.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim := &vslmManagerSim{}
+	vslm := simulator.NewRegistry()
+	vslm.Namespace = vslmNamespace
+	vslm.Path = vslmPath
+	vslm.Put(&vslmServiceInstanceSim{})
+	vslm.Put(sim)
+	model.Service.RegisterSDK(vslm)
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	c, err := govmomi.NewClient(ctx, s.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vc := &VSphereConnection{Client: c.Client}
+
+	dc, err := GetDatacenter(ctx, vc, TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datastores, err := dc.GetAllDatastores(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the catalog holds the FCDs of both datastores and one on a datastore
+	// of another datacenter
+	var excluded string
+	for url, ds := range datastores {
+		name := "disk-" + ds.Info.Name
+		if err = dc.CreateFirstClassDisk(ctx, ds.Info.Name, TypeDatastore, name, 1); err != nil {
+			t.Fatal(err)
+		}
+		fcd, err := dc.GetFirstClassDisk(ctx, ds.Info.Name, TypeDatastore, name, FindFCDByName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sim.objects = append(sim.objects,
+			VslmObjectResult{ID: fcd.Config.Id, Name: name, CapacityInMB: 1, DatastoreURL: url})
+		excluded = ds.Info.Name
+	}
+	sim.objects = append(sim.objects,
+		VslmObjectResult{ID: types.ID{Id: "remote"}, Name: "remote", DatastoreURL: "ds:///vmfs/volumes/remote/"})
+	sort.Slice(sim.objects, func(i, j int) bool {
+		return sim.objects[i].ID.Id < sim.objects[j].ID.Id
+	})
+
+	client, err := NewVslmClient(ctx, c.Client)
+	if err != nil {
+		t.Fatalf("NewVslmClient failed: %v", err)
+	}
+	if client.About.APIVersion != "7.0" {
+		t.Errorf("Unexpected about info: %+v", client.About)
+	}
+
+	// the FCDs are queried a page at a time
+	client.pageSize = 2
+	results, err := client.Query(ctx)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if !reflect.DeepEqual(results, sim.objects) {
+		t.Errorf("Query should return all of the FCDs in order: %+v", results)
+	}
+	if sim.queries != 2 {
+		t.Errorf("Expected 2 pages of FCDs, got %d", sim.queries)
+	}
+
+	results, err = client.Query(ctx, VslmQueryByName("disk-"+excluded))
+	if err != nil {
+		t.Fatalf("Query by name failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Name != "disk-"+excluded {
+		t.Errorf("Expected the FCD named disk-%s, got %+v", excluded, results)
+	}
+	results, err = client.Query(ctx, VslmQueryByID("remote"))
+	if err != nil {
+		t.Fatalf("Query by ID failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Name != "remote" {
+		t.Errorf("Expected the FCD with ID remote, got %+v", results)
+	}
+
+	// only the FCDs on the datastores of the datacenter are retrieved
+	fcds, err := dc.CatalogFirstClassDisks(ctx, sim.objects, nil)
+	if err != nil {
+		t.Fatalf("CatalogFirstClassDisks failed: %v", err)
+	}
+	if len(fcds) != 2 {
+		t.Fatalf("Expected the 2 FCDs of the datacenter, got %d", len(fcds))
+	}
+	for _, fcd := range fcds {
+		if fcd.Config.Name != "disk-"+fcd.DatastoreInfo.Info.Name || fcd.ParentType != TypeDatastore {
+			t.Errorf("Unexpected FCD %s on datastore %s", fcd.Config.Name, fcd.DatastoreInfo.Info.Name)
+		}
+	}
+
+	fcds, err = dc.CatalogFirstClassDisks(ctx, sim.objects, func(name string) bool { return name != excluded })
+	if err != nil {
+		t.Fatalf("CatalogFirstClassDisks failed: %v", err)
+	}
+	if len(fcds) != 1 || fcds[0].DatastoreInfo.Info.Name == excluded {
+		t.Errorf("The FCD of datastore %s should be filtered out: %+v", excluded, fcds)
+	}
+}
@@ -17,9 +17,14 @@ limitations under the License.
 package vclib
 
 import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/govmomi/vim25/soap"
 )
 
 // Cloud Provider API constants
@@ -76,12 +81,66 @@ var vsphereOperationErrorMetric = prometheus.NewCounterVec(
 	[]string{"operation"},
 )
 
+// vsphereAPICallMetric is for recording latency of each call to a vCenter.
+var vsphereAPICallMetric = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "cloudprovider_vsphere_api_call_duration_seconds",
+		Help: "Latency of vsphere api calls by vCenter and method",
+	},
+	[]string{"vc", "method"},
+)
+
+var vsphereAPICallErrorMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cloudprovider_vsphere_api_call_errors",
+		Help: "vsphere api call errors by vCenter and method",
+	},
+	[]string{"vc", "method"},
+)
+
+var registerMetricsOnce sync.Once
+
 // RegisterMetrics registers all the API and Operation metrics
 func RegisterMetrics() {
-	prometheus.MustRegister(vsphereAPIMetric)
-	prometheus.MustRegister(vsphereAPIErrorMetric)
-	prometheus.MustRegister(vsphereOperationMetric)
-	prometheus.MustRegister(vsphereOperationErrorMetric)
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(vsphereAPIMetric)
+		prometheus.MustRegister(vsphereAPIErrorMetric)
+		prometheus.MustRegister(vsphereOperationMetric)
+		prometheus.MustRegister(vsphereOperationErrorMetric)
+		prometheus.MustRegister(vsphereAPICallMetric)
+		prometheus.MustRegister(vsphereAPICallErrorMetric)
+		prometheus.MustRegister(vsphereAPIThrottledMetric)
+	})
+}
+
+// metricsRoundTripper records the latency and the errors of the calls to a
+// vCenter.
+type metricsRoundTripper struct {
+	roundTripper soap.RoundTripper
+	vc           string
+}
+
+// RoundTrip implements soap.RoundTripper.
+func (m *metricsRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	start := time.Now()
+	err := m.roundTripper.RoundTrip(ctx, req, res)
+
+	labels := prometheus.Labels{"vc": m.vc, "method": methodName(req)}
+	vsphereAPICallMetric.With(labels).Observe(calculateTimeTaken(start))
+	if err != nil {
+		vsphereAPICallErrorMetric.With(labels).Inc()
+	}
+	return err
+}
+
+// methodName returns the name of the vSphere API method of a request body,
+// such as RetrieveProperties for a *methods.RetrievePropertiesBody.
+func methodName(req soap.HasFault) string {
+	t := reflect.TypeOf(req)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimSuffix(t.Name(), "Body")
 }
 
 // RecordvSphereMetric records the vSphere API and Operation metrics
@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"k8s.io/klog"
+)
+
+// Server serves the debug endpoint over HTTP.
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewServer listens on the binding, in the ADDRESS:PORT format, and serves
+// until Shutdown is called /debug/state, which returns the state in JSON.
+// The state must not hold any secret, as the endpoint is not authenticated.
+func NewServer(binding string, state func(ctx context.Context) interface{}) (*Server, error) {
+	listener, err := net.Listen("tcp", binding)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.MarshalIndent(state(r.Context()), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+	s := &Server{
+		server:   &http.Server{Handler: mux},
+		listener: listener,
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("debug server failed. Err: %v", err)
+		}
+	}()
+
+	klog.Infof("serving the debug endpoint on %s", listener.Addr())
+	return s, nil
+}
+
+// Addr returns the address the debug endpoint is served on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops the server once the requests in progress are complete or
+// the context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDebugServer(t *testing.T) {
+	type state struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	s, err := NewServer("127.0.0.1:0", func(ctx context.Context) interface{} {
+		return &state{APIVersion: "6.7"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	res, err := http.Get("http://" + s.Addr().String() + "/debug/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("/debug/state should return JSON: %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	var got state
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.APIVersion != "6.7" {
+		t.Errorf("Unexpected state: %+v", got)
+	}
+}
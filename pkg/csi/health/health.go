@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"k8s.io/klog"
+)
+
+// Server serves the liveness and readiness endpoints over HTTP.
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewServer listens on the binding, in the ADDRESS:PORT format, and serves
+// until Shutdown is called /healthz, which succeeds as long as the process
+// serves it, and /readyz, which fails with the error of ready while it
+// returns one.
+func NewServer(binding string, ready func() error) (*Server, error) {
+	listener, err := net.Listen("tcp", binding)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+	s := &Server{
+		server:   &http.Server{Handler: mux},
+		listener: listener,
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("health server failed. Err: %v", err)
+		}
+	}()
+
+	klog.Infof("serving health endpoints on %s", listener.Addr())
+	return s, nil
+}
+
+// Addr returns the address the health endpoints are served on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops the server once the probes in progress are complete or
+// the context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
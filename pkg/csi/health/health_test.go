@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHealthServer(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		readyErr error
+	)
+	s, err := NewServer("127.0.0.1:0", func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return readyErr
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	get := func(path string) (int, string) {
+		res, err := http.Get("http://" + s.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz should succeed: %d", code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz should succeed when ready: %d", code)
+	}
+
+	// the process is still alive while it is not ready
+	mu.Lock()
+	readyErr = errors.New("no healthy vCenter")
+	mu.Unlock()
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, readyErr.Error()) {
+		t.Errorf("/readyz should fail with %v: %d %s", readyErr, code, body)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz should succeed when not ready: %d", code)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	csictx "github.com/rexray/gocsi/context"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// OperationIDPrefix prefixes the request IDs in the operation IDs of the
+// vSphere API calls.
+const OperationIDPrefix = "csi-"
+
+// strippedValue replaces the values of the parameters that may hold
+// secrets.
+const strippedValue = "***stripped***"
+
+// lastRequestID is the ID of the last request that was not assigned one
+// by the CO.
+var lastRequestID uint64
+
+// Fields are the key/value pairs logged after a message.
+type Fields map[string]interface{}
+
+// Entry logs messages with klog, each followed by the fields of the entry
+// in the key=value format. The zero value logs the messages alone.
+type Entry struct {
+	fields Fields
+}
+
+// WithField returns an entry with the field.
+func WithField(key string, value interface{}) *Entry {
+	return (&Entry{}).WithField(key, value)
+}
+
+// WithFields returns an entry with the fields.
+func WithFields(fields Fields) *Entry {
+	return (&Entry{}).WithFields(fields)
+}
+
+// WithError returns an entry with the error as its error field.
+func WithError(err error) *Entry {
+	return (&Entry{}).WithError(err)
+}
+
+// WithField returns a copy of the entry with the field added.
+func (e *Entry) WithField(key string, value interface{}) *Entry {
+	return e.WithFields(Fields{key: value})
+}
+
+// WithFields returns a copy of the entry with the fields added.
+func (e *Entry) WithFields(fields Fields) *Entry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Entry{fields: merged}
+}
+
+// WithError returns a copy of the entry with the error as its error field.
+func (e *Entry) WithError(err error) *Entry {
+	return e.WithField("error", err)
+}
+
+// format returns the message followed by the fields, sorted by key.
+func (e *Entry) format(msg string) string {
+	if len(e.fields) == 0 {
+		return msg
+	}
+
+	keys := make([]string, 0, len(e.fields))
+	for k := range e.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, k := range keys {
+		v := fmt.Sprint(e.fields[k])
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
+
+// Info logs to the INFO log.
+func (e *Entry) Info(args ...interface{}) {
+	klog.InfoDepth(1, e.format(fmt.Sprint(args...)))
+}
+
+// Infof logs to the INFO log.
+func (e *Entry) Infof(format string, args ...interface{}) {
+	klog.InfoDepth(1, e.format(fmt.Sprintf(format, args...)))
+}
+
+// Infoln logs to the INFO log.
+func (e *Entry) Infoln(args ...interface{}) {
+	klog.InfoDepth(1, e.format(sprintln(args...)))
+}
+
+// Warning logs to the WARNING and INFO logs.
+func (e *Entry) Warning(args ...interface{}) {
+	klog.WarningDepth(1, e.format(fmt.Sprint(args...)))
+}
+
+// Warningf logs to the WARNING and INFO logs.
+func (e *Entry) Warningf(format string, args ...interface{}) {
+	klog.WarningDepth(1, e.format(fmt.Sprintf(format, args...)))
+}
+
+// Error logs to the ERROR, WARNING, and INFO logs.
+func (e *Entry) Error(args ...interface{}) {
+	klog.ErrorDepth(1, e.format(fmt.Sprint(args...)))
+}
+
+// Errorf logs to the ERROR, WARNING, and INFO logs.
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	klog.ErrorDepth(1, e.format(fmt.Sprintf(format, args...)))
+}
+
+// V returns a Verbose that logs the entry to the INFO log when the klog
+// verbosity is at least the level.
+func (e *Entry) V(level klog.Level) Verbose {
+	return Verbose{entry: e, enabled: bool(klog.V(level))}
+}
+
+// Verbose logs an entry when the klog verbosity is high enough. See V.
+type Verbose struct {
+	entry   *Entry
+	enabled bool
+}
+
+// Info logs to the INFO log when enabled.
+func (v Verbose) Info(args ...interface{}) {
+	if v.enabled {
+		klog.InfoDepth(1, v.entry.format(fmt.Sprint(args...)))
+	}
+}
+
+// Infof logs to the INFO log when enabled.
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		klog.InfoDepth(1, v.entry.format(fmt.Sprintf(format, args...)))
+	}
+}
+
+// Infoln logs to the INFO log when enabled.
+func (v Verbose) Infoln(args ...interface{}) {
+	if v.enabled {
+		klog.InfoDepth(1, v.entry.format(sprintln(args...)))
+	}
+}
+
+// sprintln formats the arguments like fmt.Sprintln, without the trailing
+// newline.
+func sprintln(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context that holds the request ID. The vSphere
+// API calls made with the context are sent with the operation ID csi-<ID>,
+// which vCenter logs along with the calls and the tasks they start, so that
+// they can be traced to the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, types.ID{}, OperationIDPrefix+id)
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID held by the context.
+func RequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// Logger returns an entry with the request ID held by the context.
+func Logger(ctx context.Context) *Entry {
+	if id, ok := RequestID(ctx); ok {
+		return WithField("request_id", id)
+	}
+	return &Entry{}
+}
+
+// UnaryServerInterceptor assigns a request ID to each CSI RPC, unless the
+// request already carries one injected by GoCSI, and logs the RPC with its
+// duration and resulting code. Only the IDs, name and parameters of the
+// request are logged, never its secrets.
+func UnaryServerInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	var id string
+	if reqID, ok := csictx.GetRequestID(ctx); ok {
+		id = strconv.FormatUint(reqID, 10)
+	} else {
+		id = strconv.FormatUint(atomic.AddUint64(&lastRequestID, 1), 10)
+	}
+	ctx = WithRequestID(ctx, id)
+
+	start := time.Now()
+	res, err := handler(ctx, req)
+
+	code := status.Code(err)
+	fields := requestFields(req)
+	fields["method"] = path.Base(info.FullMethod)
+	fields["duration"] = time.Since(start).String()
+	fields["code"] = code.String()
+
+	entry := Logger(ctx).WithFields(fields)
+	switch {
+	case code != codes.OK:
+		entry.WithError(err).Warning("request failed")
+	case strings.HasPrefix(info.FullMethod, "/csi.v1.Identity/"):
+		// The identity service is probed often, which would flood the logs
+		entry.V(4).Info("request succeeded")
+	default:
+		entry.V(2).Info("request succeeded")
+	}
+
+	return res, err
+}
+
+// requestFields returns the IDs, name and parameters of a CSI request, with
+// the values of the parameters that may hold secrets stripped.
+func requestFields(req interface{}) Fields {
+	fields := Fields{}
+	if r, ok := req.(interface{ GetName() string }); ok && r.GetName() != "" {
+		fields["name"] = r.GetName()
+	}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		fields["volume_id"] = r.GetVolumeId()
+	}
+	if r, ok := req.(interface{ GetSourceVolumeId() string }); ok && r.GetSourceVolumeId() != "" {
+		fields["source_volume_id"] = r.GetSourceVolumeId()
+	}
+	if r, ok := req.(interface{ GetSnapshotId() string }); ok && r.GetSnapshotId() != "" {
+		fields["snapshot_id"] = r.GetSnapshotId()
+	}
+	if r, ok := req.(interface{ GetNodeId() string }); ok && r.GetNodeId() != "" {
+		fields["node_id"] = r.GetNodeId()
+	}
+	if r, ok := req.(interface{ GetParameters() map[string]string }); ok && len(r.GetParameters()) > 0 {
+		fields["parameters"] = sanitizeParameters(r.GetParameters())
+	}
+	return fields
+}
+
+// sanitizeParameters returns a copy of the parameters with the values of
+// the ones whose keys mention a secret or a password stripped.
+func sanitizeParameters(params map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(params))
+	for k, v := range params {
+		key := strings.ToLower(k)
+		if strings.Contains(key, "secret") || strings.Contains(key, "password") {
+			v = strippedValue
+		}
+		sanitized[k] = v
+	}
+	return sanitized
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	csictx "github.com/rexray/gocsi/context"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+var buf bytes.Buffer
+
+func TestMain(m *testing.M) {
+	flags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(flags)
+	flags.Set("logtostderr", "false")
+	flags.Set("stderrthreshold", "FATAL")
+	flags.Set("v", "2")
+	klog.SetOutput(&buf)
+
+	os.Exit(m.Run())
+}
+
+// output flushes the log and returns what was written since the last call.
+func output() string {
+	klog.Flush()
+	defer buf.Reset()
+	return buf.String()
+}
+
+func TestEntry(t *testing.T) {
+	output()
+
+	entry := WithField("volume_id", "fcd-1").WithFields(Fields{"node_id": "node 1"})
+	entry.Infof("attached %d volume", 1)
+	if out := output(); !strings.Contains(out, `attached 1 volume node_id="node 1" volume_id=fcd-1`) {
+		t.Errorf("the fields should be logged sorted after the message: %s", out)
+	}
+	if out := output(); out != "" {
+		t.Errorf("nothing else should be logged: %s", out)
+	}
+
+	entry.V(2).Infoln("attached", 2, "volumes")
+	if out := output(); !strings.Contains(out, "attached 2 volumes node_id=") {
+		t.Errorf("V(2) should be logged: %s", out)
+	}
+	entry.V(4).Info("vSphere payload")
+	if out := output(); out != "" {
+		t.Errorf("V(4) should not be logged: %s", out)
+	}
+
+	WithError(status.Error(codes.NotFound, "not found")).Error("failed")
+	if out := output(); !strings.Contains(out, `failed error="rpc error: code = NotFound desc = not found"`) {
+		t.Errorf("the error should be logged: %s", out)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	output()
+
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-1",
+		Parameters: map[string]string{
+			"parent_type": "Datastore",
+			"csi.storage.k8s.io/provisioner-secret-name": "vc-creds",
+		},
+		Secrets: map[string]string{"password": "hunter2"},
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+
+	var requestID, operationID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		requestID, _ = RequestID(ctx)
+		operationID, _ = ctx.Value(types.ID{}).(string)
+		Logger(ctx).Info("creating volume")
+		return &csi.CreateVolumeResponse{}, nil
+	}
+	if _, err := UnaryServerInterceptor(context.Background(), req, info, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	if requestID == "" {
+		t.Fatal("the handler should get a request ID")
+	}
+	out := output()
+	if strings.Count(out, "request_id="+requestID) != 2 {
+		t.Errorf("both log lines should have the request ID %s: %s", requestID, out)
+	}
+	for _, s := range []string{"method=CreateVolume", "name=pvc-1", "code=OK", "parent_type:Datastore"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("log should contain %s: %s", s, out)
+		}
+	}
+	for _, s := range []string{"hunter2", "vc-creds"} {
+		if strings.Contains(out, s) {
+			t.Errorf("log should not contain %s: %s", s, out)
+		}
+	}
+
+	// a request ID injected by GoCSI is kept
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(csictx.RequestIDKey, "42"))
+	if _, err := UnaryServerInterceptor(ctx, req, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "42" {
+		t.Errorf("the request ID should be 42: %s", requestID)
+	}
+
+	// the vSphere API calls are sent with the request ID
+	if operationID != OperationIDPrefix+"42" {
+		t.Errorf("the operation ID should be %s42: %s", OperationIDPrefix, operationID)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// operationDurationMetric records the latency of each CSI RPC.
+var operationDurationMetric = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "csi_operation_duration_seconds",
+		Help: "Latency of CSI operations by method and gRPC code",
+	},
+	[]string{"method", "grpc_code"},
+)
+
+// operationsMetric counts the CSI RPCs.
+var operationsMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "csi_operations_total",
+		Help: "CSI operations by method and gRPC code",
+	},
+	[]string{"method", "grpc_code"},
+)
+
+// attachedVolumesMetric is the number of volumes attached to each node.
+var attachedVolumesMetric = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "csi_attached_volumes",
+		Help: "Volumes attached to a node",
+	},
+	[]string{"node"},
+)
+
+// fcdCacheSizeMetric is the number of FCDs in the FCD inventory cache.
+var fcdCacheSizeMetric = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "csi_fcd_cache_size",
+		Help: "FCDs in the FCD inventory cache",
+	},
+)
+
+// orphanedVolumesMetric is the number of orphaned FCDs found by the last
+// orphaned volume scan.
+var orphanedVolumesMetric = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "csi_orphaned_volumes",
+		Help: "Orphaned FCDs found by the last orphaned volume scan",
+	},
+)
+
+// orphanedVolumesDeletedMetric counts the orphaned FCDs that were deleted.
+var orphanedVolumesDeletedMetric = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "csi_orphaned_volumes_deleted_total",
+		Help: "Orphaned FCDs deleted by the orphaned volume scans",
+	},
+)
+
+// detachedVolumesMetric is the number of volume attachments whose disk was
+// found detached from its node VM by the last attachment reconcile.
+var detachedVolumesMetric = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "csi_detached_volumes",
+		Help: "Attached volumes found detached from their node VM by the last attachment reconcile",
+	},
+)
+
+// volumesReattachedMetric counts the disks that were attached again to
+// their node VM.
+var volumesReattachedMetric = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "csi_volumes_reattached_total",
+		Help: "Disks detached out of band and attached again by the attachment reconciles",
+	},
+)
+
+var registerMetricsOnce sync.Once
+
+// RegisterMetrics registers the CSI metrics
+func RegisterMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(operationDurationMetric)
+		prometheus.MustRegister(operationsMetric)
+		prometheus.MustRegister(attachedVolumesMetric)
+		prometheus.MustRegister(fcdCacheSizeMetric)
+		prometheus.MustRegister(orphanedVolumesMetric)
+		prometheus.MustRegister(orphanedVolumesDeletedMetric)
+		prometheus.MustRegister(detachedVolumesMetric)
+		prometheus.MustRegister(volumesReattachedMetric)
+	})
+}
+
+// UnaryServerInterceptor records the latency and the gRPC code of each CSI
+// RPC.
+func UnaryServerInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	start := time.Now()
+	res, err := handler(ctx, req)
+
+	labels := prometheus.Labels{
+		"method":    path.Base(info.FullMethod),
+		"grpc_code": status.Code(err).String(),
+	}
+	operationDurationMetric.With(labels).Observe(time.Since(start).Seconds())
+	operationsMetric.With(labels).Inc()
+
+	return res, err
+}
+
+// SetAttachedVolumes records the number of volumes attached to a node.
+func SetAttachedVolumes(node string, count int) {
+	attachedVolumesMetric.With(prometheus.Labels{"node": node}).Set(float64(count))
+}
+
+// DeleteAttachedVolumes drops the attached volumes of a node that was
+// deleted.
+func DeleteAttachedVolumes(node string) {
+	attachedVolumesMetric.Delete(prometheus.Labels{"node": node})
+}
+
+// SetFCDCacheSize records the number of FCDs in the FCD inventory cache.
+func SetFCDCacheSize(count int) {
+	fcdCacheSizeMetric.Set(float64(count))
+}
+
+// SetOrphanedVolumes records the number of orphaned FCDs found by the last
+// orphaned volume scan.
+func SetOrphanedVolumes(count int) {
+	orphanedVolumesMetric.Set(float64(count))
+}
+
+// IncOrphanedVolumesDeleted counts an orphaned FCD that was deleted.
+func IncOrphanedVolumesDeleted() {
+	orphanedVolumesDeletedMetric.Inc()
+}
+
+// SetDetachedVolumes records the number of volume attachments whose disk
+// was found detached by the last attachment reconcile.
+func SetDetachedVolumes(count int) {
+	detachedVolumesMetric.Set(float64(count))
+}
+
+// IncVolumesReattached counts a disk that was attached again.
+func IncVolumesReattached() {
+	volumesReattachedMetric.Inc()
+}
+
+// Server serves the metrics over HTTP.
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewServer listens on the binding, in the ADDRESS:PORT format, and serves
+// the metrics on /metrics until Shutdown is called.
+func NewServer(binding string) (*Server, error) {
+	listener, err := net.Listen("tcp", binding)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s := &Server{
+		server:   &http.Server{Handler: mux},
+		listener: listener,
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("metrics server failed. Err: %v", err)
+		}
+	}()
+
+	klog.Infof("serving metrics on %s", listener.Addr())
+	return s, nil
+}
+
+// Addr returns the address the metrics are served on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops the server once the scrapes in progress are complete or
+// the context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsServer(t *testing.T) {
+	RegisterMetrics()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	failed := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	if _, err := UnaryServerInterceptor(context.Background(), nil, info, failed); status.Code(err) != codes.NotFound {
+		t.Errorf("UnaryServerInterceptor should return the error of the handler: %v", err)
+	}
+	SetAttachedVolumes("node1", 3)
+	SetFCDCacheSize(5)
+	SetOrphanedVolumes(2)
+	IncOrphanedVolumesDeleted()
+
+	s, err := NewServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get("http://" + s.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, metric := range []string{
+		`csi_operations_total{grpc_code="NotFound",method="CreateVolume"} 1`,
+		`csi_operation_duration_seconds_count{grpc_code="NotFound",method="CreateVolume"} 1`,
+		`csi_attached_volumes{node="node1"} 3`,
+		`csi_fcd_cache_size 5`,
+		`csi_orphaned_volumes 2`,
+		`csi_orphaned_volumes_deleted_total 1`,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("metrics should contain %s", metric)
+		}
+	}
+
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if _, err = http.Get("http://" + s.Addr().String() + "/metrics"); err == nil {
+		t.Error("metrics should not be served after Shutdown")
+	}
+}
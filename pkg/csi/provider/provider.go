@@ -17,8 +17,13 @@ limitations under the License.
 package provider
 
 import (
+	"context"
+
 	"github.com/rexray/gocsi"
+	"google.golang.org/grpc"
 
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/metrics"
 	"k8s.io/cloud-provider-vsphere/pkg/csi/service"
 )
 
@@ -27,18 +32,53 @@ func New() gocsi.StoragePluginProvider {
 	svc := service.New()
 	ctrl := svc.GetController()
 
-	return &gocsi.StoragePlugin{
-		Controller:  ctrl,
-		Identity:    svc,
-		Node:        svc,
-		BeforeServe: svc.BeforeServe,
+	return &StoragePlugin{
+		StoragePlugin: &gocsi.StoragePlugin{
+			Controller:  ctrl,
+			Identity:    svc,
+			Node:        svc,
+			BeforeServe: svc.BeforeServe,
+
+			Interceptors: []grpc.UnaryServerInterceptor{
+				logging.UnaryServerInterceptor,
+				metrics.UnaryServerInterceptor,
+				svc.UnaryServerInterceptor,
+			},
 
-		EnvVars: []string{
-			// Enable request validation.
-			gocsi.EnvVarSpecReqValidation + "=true",
+			EnvVars: []string{
+				// Enable request validation.
+				gocsi.EnvVarSpecReqValidation + "=true",
 
-			// Enable serial volume access.
-			gocsi.EnvVarSerialVolAccess + "=true",
+				// Enable serial volume access.
+				gocsi.EnvVarSerialVolAccess + "=true",
+			},
 		},
+		svc: svc,
 	}
 }
+
+// StoragePlugin is a GoCSI Storage Plug-in that shuts the service down when
+// it is stopped.
+type StoragePlugin struct {
+	*gocsi.StoragePlugin
+	svc service.Service
+}
+
+// Stop stops the gRPC server and shuts the service down.
+func (sp *StoragePlugin) Stop(ctx context.Context) {
+	sp.StoragePlugin.Stop(ctx)
+	sp.svc.Shutdown(ctx)
+}
+
+// GracefulStop stops the gRPC server from accepting new RPCs and shuts the
+// service down, which drains the pending RPCs, then waits for the server to
+// stop.
+func (sp *StoragePlugin) GracefulStop(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		sp.StoragePlugin.GracefulStop(ctx)
+		close(stopped)
+	}()
+	sp.svc.Shutdown(ctx)
+	<-stopped
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+const (
+	// defaultDeviceTimeout is how long a disk attached to the node is
+	// waited for when X_CSI_VSPHERE_DEVICE_TIMEOUT is unset
+	defaultDeviceTimeout = 30 * time.Second
+
+	// scsiRescan asks a SCSI host to scan all of its channels, targets and
+	// LUNs
+	scsiRescan = "- - -"
+)
+
+var (
+	// scsiHostDir holds the SCSI hosts of the node, whose scan files
+	// trigger a rescan of their buses
+	scsiHostDir = "/sys/class/scsi_host"
+
+	// sysBlockDir holds the block devices of the node, along with the
+	// devices that hold them such as the multipath dm devices
+	sysBlockDir = "/sys/block"
+
+	// deviceBackoff is the first delay between the lookups of a disk, which
+	// is doubled up to deviceMaxBackoff
+	deviceBackoff    = 250 * time.Millisecond
+	deviceMaxBackoff = 5 * time.Second
+)
+
+// waitForDisk returns the path of the attached disk whose page 83 serial is
+// diskID. Some ESXi and guest combinations do not notice a new PVSCSI disk
+// until its bus is rescanned, so the SCSI hosts are rescanned when the disk
+// is not found, and the disk looked up again with a backoff until the device
+// timeout elapses. The error lists the serials of the disks found instead.
+func (s *service) waitForDisk(ctx context.Context, diskID string) (string, error) {
+	logger := logging.Logger(ctx).WithField("diskID", diskID)
+
+	deadline := time.Now().Add(s.deviceTimeout)
+	delay := deviceBackoff
+	for rescanned := false; ; rescanned = true {
+		volPath, err := getDiskPath(diskID, nil)
+		if err != nil {
+			return "", status.Errorf(codes.Internal,
+				"Error trying to read attached disks: %v", err)
+		}
+		if volPath != "" {
+			return resolveMultipath(ctx, volPath), nil
+		}
+
+		// the disk is looked up at least once after a rescan
+		if ctx.Err() != nil || (rescanned && !time.Now().Before(deadline)) {
+			break
+		}
+		logger.V(4).Info("disk not found, rescanning the SCSI hosts")
+		if err := rescanSCSIHosts(); err != nil {
+			logger.WithError(err).Warning("failed to rescan the SCSI hosts")
+		}
+
+		wait := time.Until(deadline)
+		if wait > delay {
+			wait = delay
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if delay *= 2; delay > deviceMaxBackoff {
+			delay = deviceMaxBackoff
+		}
+	}
+
+	serials, err := listDiskSerials()
+	if err != nil {
+		return "", status.Errorf(codes.Internal,
+			"Error trying to read attached disks: %v", err)
+	}
+	return "", status.Errorf(codes.NotFound,
+		"disk: %s not attached to node after %v, found disks: [%s]",
+		diskID, s.deviceTimeout, strings.Join(serials, ", "))
+}
+
+// rescanSCSIHosts rescans the buses of all of the SCSI hosts of the node.
+func rescanSCSIHosts() error {
+	scans, err := filepath.Glob(filepath.Join(scsiHostDir, "host*", "scan"))
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, scan := range scans {
+		if err := ioutil.WriteFile(scan, []byte(scsiRescan), 0200); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// resolveMultipath returns the multipath dm device holding the disk at
+// volPath, when multipathd claimed it, as the paths of a multipathed disk
+// cannot be mounted on their own. Otherwise volPath is returned.
+func resolveMultipath(ctx context.Context, volPath string) string {
+	dev, err := filepath.EvalSymlinks(volPath)
+	if err != nil {
+		return volPath
+	}
+	if strings.HasPrefix(filepath.Base(dev), "dm-") {
+		return volPath
+	}
+
+	holders, err := ioutil.ReadDir(filepath.Join(sysBlockDir, filepath.Base(dev), "holders"))
+	if err != nil {
+		return volPath
+	}
+	for _, holder := range holders {
+		if strings.HasPrefix(holder.Name(), "dm-") {
+			dm := filepath.Join(filepath.Dir(dev), holder.Name())
+			logging.Logger(ctx).WithField("device", dev).V(4).Infof("disk is held by multipath device %s", dm)
+			return dm
+		}
+	}
+	return volPath
+}
+
+// listDiskSerials returns the page 83 serials of the disks of the node.
+func listDiskSerials() ([]string, error) {
+	devs, err := ioutil.ReadDir(devDiskID)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var serials []string
+	for _, f := range devs {
+		if strings.HasPrefix(f.Name(), blockPrefix) {
+			serials = append(serials, strings.TrimPrefix(f.Name(), blockPrefix))
+		}
+	}
+	sort.Strings(serials)
+	return serials, nil
+}
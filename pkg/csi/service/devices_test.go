@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDevices points the device lookups at a temporary directory with a
+// single SCSI host, and returns the directory of the devices.
+func fakeDevices(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"dev/disk/by-id", "scsi_host/host0", "block"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "scsi_host/host0/scan"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldDevDiskID, oldScsiHostDir, oldSysBlockDir := devDiskID, scsiHostDir, sysBlockDir
+	oldBackoff := deviceBackoff
+	devDiskID = filepath.Join(dir, "dev/disk/by-id")
+	scsiHostDir = filepath.Join(dir, "scsi_host")
+	sysBlockDir = filepath.Join(dir, "block")
+	deviceBackoff = 10 * time.Millisecond
+
+	return filepath.Join(dir, "dev"), func() {
+		devDiskID, scsiHostDir, sysBlockDir = oldDevDiskID, oldScsiHostDir, oldSysBlockDir
+		deviceBackoff = oldBackoff
+		os.RemoveAll(dir)
+	}
+}
+
+// addDisk creates the device of a disk and its link by serial.
+func addDisk(t *testing.T, devDir string, name string, link string) string {
+	dev := filepath.Join(devDir, name)
+	if err := ioutil.WriteFile(dev, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dev, filepath.Join(devDiskID, link)); err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+func TestWaitForDiskAfterRescan(t *testing.T) {
+	devDir, cleanup := fakeDevices(t)
+	defer cleanup()
+
+	// the disk shows up once the SCSI host is rescanned
+	scan := filepath.Join(scsiHostDir, "host0", "scan")
+	link := filepath.Join(devDiskID, blockPrefix+"6000c29a")
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if b, _ := ioutil.ReadFile(scan); string(b) == scsiRescan {
+				done <- os.Symlink(filepath.Join(devDir, "sdb"), link)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	s := &service{deviceTimeout: 5 * time.Second}
+	volPath, err := s.waitForDisk(context.Background(), "6000c29a")
+	if err != nil {
+		t.Fatalf("waitForDisk failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if volPath != link {
+		t.Errorf("Expected %s, got %s", link, volPath)
+	}
+}
+
+func TestWaitForDiskNotFound(t *testing.T) {
+	devDir, cleanup := fakeDevices(t)
+	defer cleanup()
+
+	addDisk(t, devDir, "sdb", blockPrefix+"6000c29b")
+	addDisk(t, devDir, "sdc", blockPrefix+"6000c29c")
+
+	s := &service{deviceTimeout: 50 * time.Millisecond}
+	_, err := s.waitForDisk(context.Background(), "6000c29a")
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "found disks: [6000c29b, 6000c29c]") {
+		t.Errorf("Expected the serials of the disks in the error, got %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(scsiHostDir, "host0", "scan"))
+	if err != nil || string(b) != scsiRescan {
+		t.Errorf("Expected the SCSI host to be rescanned, got %q, %v", b, err)
+	}
+}
+
+func TestWaitForMultipathDisk(t *testing.T) {
+	devDir, cleanup := fakeDevices(t)
+	defer cleanup()
+
+	// the path of the disk is held by a multipath device
+	addDisk(t, devDir, "sdb", blockPrefix+"6000c29a")
+	if err := os.MkdirAll(filepath.Join(sysBlockDir, "sdb", "holders", "dm-3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// only the dm device of another disk is linked
+	dm := addDisk(t, devDir, "dm-4", multipathPrefix+"6000c29b")
+
+	s := &service{}
+	volPath, err := s.waitForDisk(context.Background(), "6000c29a")
+	if err != nil {
+		t.Fatalf("waitForDisk failed: %v", err)
+	}
+	if expected := filepath.Join(devDir, "dm-3"); volPath != expected {
+		t.Errorf("Expected %s, got %s", expected, volPath)
+	}
+
+	volPath, err = s.waitForDisk(context.Background(), "6000c29b")
+	if err != nil {
+		t.Fatalf("waitForDisk failed: %v", err)
+	}
+	if resolved, _ := filepath.EvalSymlinks(volPath); resolved != dm {
+		t.Errorf("Expected %s, got %s", dm, volPath)
+	}
+}
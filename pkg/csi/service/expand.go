@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+func (s *service) NodeExpandVolume(
+	ctx context.Context,
+	req *csi.NodeExpandVolumeRequest) (
+	*csi.NodeExpandVolumeResponse, error) {
+
+	volID := req.GetVolumeId()
+	if volID == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID required")
+	}
+
+	volPath := req.GetVolumePath()
+	if volPath == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume path required")
+	}
+
+	if isFileVolume(volID) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"volume: %s is a vSAN file share, which cannot be expanded", volID)
+	}
+
+	fi, err := os.Stat(volPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound,
+				"volume path: %s does not exist", volPath)
+		}
+		return nil, status.Errorf(codes.Internal,
+			"failed to stat volume path, err: %s", err.Error())
+	}
+
+	// Block volumes are published as the device node itself, the others
+	// are mounted from it
+	block := fi.Mode()&os.ModeDevice != 0
+	var dev *Device
+	if block {
+		dev, err = getDevice(volPath)
+	} else {
+		dev, err = getDevFromMount(volPath)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error getting block device for volume: %s, err: %s",
+			volID, err.Error())
+	}
+	if dev == nil {
+		return nil, status.Errorf(codes.NotFound,
+			"volume: %s is not mounted to %s", volID, volPath)
+	}
+
+	mountPath := volPath
+	if block {
+		mountPath = ""
+	}
+	size, err := expandDevice(ctx, volID, dev.RealDev, mountPath,
+		req.GetCapacityRange().GetRequiredBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: size}, nil
+}
+
+// expandDevice rescans the size of the device of a volume and, when the
+// volume is mounted at mountPath, grows its filesystem to the size of the
+// device. The device must have grown to the required size, otherwise the
+// expansion of the FCD has not completed and FailedPrecondition is returned.
+// Growing a filesystem that already fills its device does nothing, so the
+// expansion may be retried. The size of the device is returned.
+func expandDevice(ctx context.Context, volID, device, mountPath string, required int64) (int64, error) {
+	f := logging.Fields{
+		"volID":  volID,
+		"device": device,
+	}
+
+	if err := rescanBlockDevice(device); err != nil {
+		return 0, status.Errorf(codes.Internal,
+			"error rescanning the size of device %s: %v", device, err)
+	}
+	size, err := getBlockSize(device)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal,
+			"error getting size of volume: %s, err: %s", volID, err.Error())
+	}
+	if size < required {
+		return 0, status.Errorf(codes.FailedPrecondition,
+			"device %s of volume %s has %d bytes, less than the requested %d: "+
+				"the expansion of the volume has not completed", device, volID, size, required)
+	}
+	if mountPath == "" {
+		logging.Logger(ctx).WithFields(f).V(4).Infof("block volume rescanned to %d bytes", size)
+		return size, nil
+	}
+
+	fsType, err := getDiskFormat(ctx, device)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal,
+			"error getting the filesystem of %s: %v", device, err)
+	}
+	var cmd string
+	var args []string
+	switch fsType {
+	case "ext3", "ext4":
+		cmd, args = "resize2fs", []string{device}
+	case "xfs":
+		// xfs is grown through its mount point
+		cmd, args = "xfs_growfs", []string{mountPath}
+	default:
+		return 0, status.Errorf(codes.FailedPrecondition,
+			"cannot grow the %q filesystem of volume %s", fsType, volID)
+	}
+
+	logging.Logger(ctx).WithFields(f).WithField("fsType", fsType).Info("growing filesystem")
+	if out, err := runCommand(ctx, cmd, args...); err != nil {
+		return 0, status.Errorf(codes.Internal,
+			"error growing the %s filesystem of volume %s: %v: %s",
+			fsType, volID, err, strings.TrimSpace(string(out)))
+	}
+	return size, nil
+}
+
+// rescanBlockDevice makes the kernel read the size of a SCSI disk again.
+// The paths of a multipath dm device are rescanned instead.
+func rescanBlockDevice(device string) error {
+	name := filepath.Base(device)
+	disks := []string{name}
+	if strings.HasPrefix(name, "dm-") {
+		slaves, err := ioutil.ReadDir(filepath.Join(sysBlockDir, name, "slaves"))
+		if err != nil {
+			return err
+		}
+		disks = disks[:0]
+		for _, slave := range slaves {
+			disks = append(disks, slave.Name())
+		}
+	}
+
+	for _, disk := range disks {
+		rescan := filepath.Join(sysBlockDir, disk, "device", "rescan")
+		if err := ioutil.WriteFile(rescan, []byte("1"), 0200); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/csi/service/fcd"
+)
+
+func TestExpandDevice(t *testing.T) {
+	devDir, cleanup := fakeDevices(t)
+	defer cleanup()
+
+	// sdb is a path of the multipath device dm-3
+	for _, dir := range []string{"sdb/device", "dm-3/slaves/sdb"} {
+		if err := os.MkdirAll(filepath.Join(sysBlockDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rescan := filepath.Join(sysBlockDir, "sdb", "device", "rescan")
+	for _, name := range []string{"sdb", "dm-3"} {
+		if err := ioutil.WriteFile(filepath.Join(devDir, name), make([]byte, 4096), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		device    string
+		mountPath string
+		format    string
+		required  int64
+		code      codes.Code
+		commands  []string
+	}{
+		{
+			name:     "ext4",
+			device:   "sdb",
+			format:   "ext4",
+			required: 4096,
+			commands: []string{"resize2fs " + filepath.Join(devDir, "sdb")},
+		},
+		{
+			name:     "xfs",
+			device:   "dm-3",
+			format:   "xfs",
+			commands: []string{"xfs_growfs /target"},
+		},
+		{
+			name:   "block",
+			device: "sdb",
+		},
+		{
+			name:     "controller expansion pending",
+			device:   "sdb",
+			format:   "ext4",
+			required: 8192,
+			code:     codes.FailedPrecondition,
+		},
+		{
+			name:   "unsupported filesystem",
+			device: "sdb",
+			format: "btrfs",
+			code:   codes.FailedPrecondition,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := &fakeFilesystem{format: test.format}
+			defer fs.install()()
+			if err := ioutil.WriteFile(rescan, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			mountPath := "/target"
+			if test.format == "" {
+				mountPath = ""
+			}
+			size, err := expandDevice(context.Background(), "vol", filepath.Join(devDir, test.device),
+				mountPath, test.required)
+			if status.Code(err) != test.code {
+				t.Fatalf("Expected %v, got %v", test.code, err)
+			}
+			if err == nil && size != 4096 {
+				t.Errorf("Expected 4096 bytes, got %d", size)
+			}
+			if !reflect.DeepEqual(fs.commands, test.commands) {
+				t.Errorf("Expected commands %q, got %q", test.commands, fs.commands)
+			}
+			if b, _ := ioutil.ReadFile(rescan); string(b) != "1" {
+				t.Errorf("Expected the disk to be rescanned, got %q", b)
+			}
+		})
+	}
+}
+
+func TestNodeExpandVolumeErrors(t *testing.T) {
+	s := &service{}
+
+	tests := []struct {
+		req  *csi.NodeExpandVolumeRequest
+		code codes.Code
+	}{
+		{
+			req:  &csi.NodeExpandVolumeRequest{VolumePath: "/target"},
+			code: codes.InvalidArgument,
+		},
+		{
+			req:  &csi.NodeExpandVolumeRequest{VolumeId: "vol"},
+			code: codes.InvalidArgument,
+		},
+		{
+			req:  &csi.NodeExpandVolumeRequest{VolumeId: fcd.FileVolumeIDPrefix + "vol", VolumePath: "/target"},
+			code: codes.InvalidArgument,
+		},
+		{
+			req:  &csi.NodeExpandVolumeRequest{VolumeId: "vol", VolumePath: "/enoent"},
+			code: codes.NotFound,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := s.NodeExpandVolume(context.Background(), test.req)
+		if status.Code(err) != test.code {
+			t.Errorf("%v: expected %v, got %v", test.req, test.code, err)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/metrics"
+	vTypes "k8s.io/cloud-provider-vsphere/pkg/csi/types"
+)
+
+const (
+	// eventReasonVolumeDetached is the reason of the events of the volume
+	// attachments whose disk was found detached from the node VM.
+	eventReasonVolumeDetached = "VolumeDetached"
+	// eventReasonVolumeReattached is the reason of the events of the volume
+	// attachments whose disk was attached again.
+	eventReasonVolumeReattached = "VolumeReattached"
+	// eventReasonReattachFailed is the reason of the events of the volume
+	// attachments whose disk failed to be attached again.
+	eventReasonReattachFailed = "ReattachFailed"
+)
+
+// runAttachmentReconciler checks the volume attachments against the node
+// VMs on every interval until the context is cancelled.
+func (c *controller) runAttachmentReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reconcileAttachments(ctx)
+		}
+	}
+}
+
+// reconcileAttachments looks for the volume attachments of the driver that
+// Kubernetes believes attached while their disk is no longer attached to
+// the node VM, such as when it was detached in the vSphere Client. Their
+// disk is attached again, or they are only reported in dry-run mode. The
+// volumes with an operation in flight are left alone, and nothing is done
+// until the volume attachments and PVs are synced.
+func (c *controller) reconcileAttachments(ctx context.Context) {
+	logger := logging.Logger(ctx)
+
+	if c.vaSynced == nil || !c.vaSynced() {
+		logger.Warning("Skipping the attachment reconcile until the volume attachments are synced")
+		return
+	}
+	vas, err := c.vaLister.List(labels.Everything())
+	if err != nil {
+		logger.Errorf("Failed to list the volume attachments. Err: %v", err)
+		return
+	}
+
+	dryRun := c.cfg.Global.AttachmentReconcileDryRun
+
+	detached := 0
+	for _, va := range vas {
+		if va.Spec.Attacher != vTypes.DriverName || !va.Status.Attached || va.DeletionTimestamp != nil ||
+			va.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		pv, err := c.pvLister.Get(*va.Spec.Source.PersistentVolumeName)
+		if err != nil || pv.Spec.CSI == nil || isFileVolume(pv.Spec.CSI.VolumeHandle) {
+			continue
+		}
+		volumeID := pv.Spec.CSI.VolumeHandle
+		nodeID := c.nodeID(va.Spec.NodeName)
+
+		entry := logger.WithFields(logging.Fields{
+			"volume_attachment": va.Name, "volume_id": volumeID, "node": va.Spec.NodeName})
+
+		lockCtx, ok := c.volumeLocks.tryAcquire(ctx, volumeID)
+		if !ok {
+			continue
+		}
+		attached, err := c.isVolumeAttached(lockCtx, volumeID, nodeID)
+		c.volumeLocks.release(volumeID)
+		if err != nil {
+			entry.Errorf("Failed to check the attachment of the volume. Err: %v", err)
+			continue
+		}
+		if attached {
+			continue
+		}
+		detached++
+
+		if dryRun {
+			msg := fmt.Sprintf("Volume %s was detached from node %s out of band", volumeID, va.Spec.NodeName)
+			entry.Warning(msg)
+			c.recordEvent(va, eventReasonVolumeDetached, msg)
+			continue
+		}
+		c.reattachVolume(ctx, va.Name, pv, nodeID, entry)
+	}
+
+	metrics.SetDetachedVolumes(detached)
+}
+
+// nodeID returns the CSI node ID of a node, read from the node ID
+// annotation of the node, or the name of the node when it is unknown.
+func (c *controller) nodeID(nodeName string) string {
+	if c.nodeLister == nil {
+		return nodeName
+	}
+	node, err := c.nodeLister.Get(nodeName)
+	if err != nil {
+		return nodeName
+	}
+	annotation, ok := node.Annotations[AnnotationNodeID]
+	if !ok {
+		return nodeName
+	}
+	nodeIDs := make(map[string]string)
+	if err := json.Unmarshal([]byte(annotation), &nodeIDs); err != nil {
+		return nodeName
+	}
+	if id := nodeIDs[vTypes.DriverName]; id != "" {
+		return id
+	}
+	return nodeName
+}
+
+// isVolumeAttached returns whether the disk of a volume is attached to the
+// VM of a node.
+func (c *controller) isVolumeAttached(ctx context.Context, volumeID string, nodeID string) (bool, error) {
+	volumeID, err := c.resolveVolumeID(ctx, volumeID)
+	if err != nil {
+		return false, err
+	}
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, volumeID)
+	if err != nil {
+		return false, err
+	}
+	vm, err := c.getNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, nodeID)
+	if err != nil {
+		return false, err
+	}
+
+	filePath := discoveryInfo.FCDInfo.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+	var attached bool
+	_, err = c.withNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, nodeID, vm,
+		func(vm *vclib.VirtualMachine) (err error) {
+			attached, err = vm.IsDiskAttached(ctx, vclib.RemoveStorageClusterORFolderNameFromVDiskPath(filePath))
+			return err
+		})
+	if err != nil {
+		// the cached VM may no longer exist
+		c.nodeVMs.remove(nodeID)
+		return false, err
+	}
+	return attached, nil
+}
+
+// reattachVolume attaches the disk of a volume attachment to its node VM
+// again. When the attach fails, the volume attachment is marked as not
+// attached with the error, so that the external attacher retries it.
+func (c *controller) reattachVolume(ctx context.Context, vaName string, pv *v1.PersistentVolume,
+	nodeID string, entry *logging.Entry) {
+
+	// The volume may have been detached since the volume attachments were
+	// listed
+	va, err := c.vaClient.Get(vaName, metav1.GetOptions{})
+	if err != nil {
+		entry.Errorf("Failed to get the volume attachment. Err: %v", err)
+		return
+	}
+	if !va.Status.Attached || va.DeletionTimestamp != nil {
+		return
+	}
+
+	resp, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:      pv.Spec.CSI.VolumeHandle,
+		NodeId:        nodeID,
+		Readonly:      pv.Spec.CSI.ReadOnly,
+		VolumeContext: pv.Spec.CSI.VolumeAttributes,
+	})
+	if err != nil {
+		msg := fmt.Sprintf("Failed to attach volume %s detached out of band to node %s again: %v",
+			pv.Spec.CSI.VolumeHandle, va.Spec.NodeName, err)
+		entry.Error(msg)
+		c.recordEvent(va, eventReasonReattachFailed, msg)
+		va.Status.Attached = false
+		va.Status.AttachError = &storagev1beta1.VolumeError{Time: metav1.Now(), Message: msg}
+	} else {
+		msg := fmt.Sprintf("Attached volume %s detached out of band to node %s again",
+			pv.Spec.CSI.VolumeHandle, va.Spec.NodeName)
+		entry.Warning(msg)
+		c.recordEvent(va, eventReasonVolumeReattached, msg)
+		metrics.IncVolumesReattached()
+		// The disk may have been placed on another SCSI unit
+		va.Status.AttachmentMetadata = resp.PublishContext
+	}
+
+	if err := c.updateVolumeAttachmentStatus(va); err != nil {
+		entry.Errorf("Failed to update the status of the volume attachment. Err: %v", err)
+	}
+}
+
+// updateVolumeAttachmentStatus updates the status of a volume attachment
+// with its status subresource, or with the volume attachment itself on the
+// clusters older than Kubernetes 1.15, which lack the subresource.
+func (c *controller) updateVolumeAttachmentStatus(va *storagev1beta1.VolumeAttachment) error {
+	_, err := c.vaClient.UpdateStatus(va)
+	if apierrors.IsNotFound(err) {
+		_, err = c.vaClient.Update(va)
+	}
+	return err
+}
+
+// recordEvent records a warning event on a volume attachment.
+func (c *controller) recordEvent(va *storagev1beta1.VolumeAttachment, reason string, msg string) {
+	if c.recorder != nil {
+		c.recorder.Event(va, v1.EventTypeWarning, reason, msg)
+	}
+}
+
+// isPublishedToVM returns whether a volume attachment of the driver still
+// publishes a volume to the node of a VM. Until the volume attachments are
+// synced, the volume is considered published. It returns false when the
+// volume attachments are not listed.
+func (c *controller) isPublishedToVM(ctx context.Context, volumeID string, vcServer string,
+	dc *vclib.Datacenter, vm *vclib.VirtualMachine) bool {
+
+	logger := logging.Logger(ctx)
+
+	if c.vaLister == nil || c.pvLister == nil {
+		return false
+	}
+	if c.vaSynced != nil && !c.vaSynced() {
+		logger.Warningf("The volume attachments are not synced, leaving volume %s attached to VM %s",
+			volumeID, vm.Reference().Value)
+		return true
+	}
+	vas, err := c.vaLister.List(labels.Everything())
+	if err != nil {
+		logger.Errorf("Failed to list the volume attachments. Err: %v", err)
+		return false
+	}
+
+	for _, va := range vas {
+		if va.Spec.Attacher != vTypes.DriverName || va.DeletionTimestamp != nil ||
+			va.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		pv, err := c.pvLister.Get(*va.Spec.Source.PersistentVolumeName)
+		if err != nil || pv.Spec.CSI == nil || pv.Spec.CSI.VolumeHandle != volumeID {
+			continue
+		}
+		nodeVM, err := c.getNodeVM(ctx, vcServer, dc, c.nodeID(va.Spec.NodeName))
+		if err != nil {
+			continue
+		}
+		if nodeVM.Reference() == vm.Reference() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	storagelisterv1beta1 "k8s.io/client-go/listers/storage/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	vTypes "k8s.io/cloud-provider-vsphere/pkg/csi/types"
+)
+
+func TestReconcileAttachments(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vmName := myVM.Name
+	myVM.Guest.HostName = strings.ToLower(vmName)
+
+	params := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+	resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "reconciled",
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := resp.Volume.VolumeId
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID,
+		NodeId:   vmName,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	pvName := "pv-reconciled"
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       vTypes.DriverName,
+					VolumeHandle: volumeID,
+				},
+			},
+		},
+	}
+	va := &storagev1beta1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "va-reconciled"},
+		Spec: storagev1beta1.VolumeAttachmentSpec{
+			Attacher: vTypes.DriverName,
+			NodeName: vmName,
+			Source:   storagev1beta1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+		},
+		Status: storagev1beta1.VolumeAttachmentStatus{Attached: true},
+	}
+
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvIndexer.Add(pv)
+	vaIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	vaIndexer.Add(va)
+	client := fake.NewSimpleClientset(va)
+	recorder := record.NewFakeRecorder(10)
+
+	c.pvLister = listerv1.NewPersistentVolumeLister(pvIndexer)
+	c.vaLister = storagelisterv1beta1.NewVolumeAttachmentLister(vaIndexer)
+	c.vaSynced = func() bool { return true }
+	c.vaClient = client.StorageV1beta1().VolumeAttachments()
+	c.recorder = recorder
+
+	expectEvent := func(reason string) {
+		t.Helper()
+		select {
+		case event := <-recorder.Events:
+			if !strings.Contains(event, reason) {
+				t.Errorf("Expected a %s event, got %q", reason, event)
+			}
+		default:
+			if reason != "" {
+				t.Errorf("Expected a %s event", reason)
+			}
+			return
+		}
+		if reason == "" {
+			t.Error("Expected no event")
+		}
+	}
+	expectAttached := func(expected bool) {
+		t.Helper()
+		attached, err := c.isVolumeAttached(ctx, volumeID, vmName)
+		if err != nil {
+			t.Fatalf("isVolumeAttached failed: %v", err)
+		}
+		if attached != expected {
+			t.Errorf("Volume %s should be attached: %t", volumeID, expected)
+		}
+	}
+
+	// nothing is done for the attached volumes
+	c.reconcileAttachments(ctx)
+	expectEvent("")
+
+	// the volume is detached out of band
+	detach := func() {
+		t.Helper()
+		_, err := c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volumeID,
+			NodeId:   vmName,
+		})
+		if err != nil {
+			t.Fatalf("ControllerUnpublishVolume failed: %v", err)
+		}
+	}
+	detach()
+
+	// the detached volume is only reported in dry-run mode
+	config.Global.AttachmentReconcileDryRun = true
+	c.reconcileAttachments(ctx)
+	expectEvent(eventReasonVolumeDetached)
+	expectAttached(false)
+
+	// and attached again otherwise
+	config.Global.AttachmentReconcileDryRun = false
+	c.reconcileAttachments(ctx)
+	expectEvent(eventReasonVolumeReattached)
+	expectAttached(true)
+	updated, err := client.StorageV1beta1().VolumeAttachments().Get(va.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Status.Attached || updated.Status.AttachmentMetadata[AttributeFirstClassDiskPage83Data] == "" {
+		t.Errorf("The attachment metadata of the volume attachment should be updated: %+v", updated.Status)
+	}
+
+	// the volume attachment is marked as not attached when the attach
+	// fails
+	detach()
+	c.hooks = fakeHooks(fakeConnMgr{}, fakeDatacenter{}, fakeVM{attachErr: errFake})
+	c.reconcileAttachments(ctx)
+	expectEvent(eventReasonReattachFailed)
+	expectAttached(false)
+	updated, err = client.StorageV1beta1().VolumeAttachments().Get(va.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.Attached || updated.Status.AttachError == nil {
+		t.Errorf("The volume attachment should be marked as not attached with an error: %+v", updated.Status)
+	}
+}
+
+func TestUnpublishLeavesPublishedVolumeAttached(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	// Get two simulator VMs
+	var nodes []string
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		vm := obj.(*simulator.VirtualMachine)
+		vm.Guest.HostName = strings.ToLower(vm.Name)
+		nodes = append(nodes, vm.Guest.HostName)
+	}
+	if len(nodes) < 2 {
+		t.Fatalf("Expected at least 2 VMs, got %d", len(nodes))
+	}
+
+	params := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+	resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "published",
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := resp.Volume.VolumeId
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID,
+		NodeId:   nodes[0],
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	pvName := "pv-published"
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       vTypes.DriverName,
+					VolumeHandle: volumeID,
+				},
+			},
+		},
+	}
+	va := &storagev1beta1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "va-published"},
+		Spec: storagev1beta1.VolumeAttachmentSpec{
+			Attacher: vTypes.DriverName,
+			NodeName: nodes[0],
+			Source:   storagev1beta1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+		},
+		Status: storagev1beta1.VolumeAttachmentStatus{Attached: true},
+	}
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvIndexer.Add(pv)
+	vaIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	vaIndexer.Add(va)
+	c.pvLister = listerv1.NewPersistentVolumeLister(pvIndexer)
+	c.vaLister = storagelisterv1beta1.NewVolumeAttachmentLister(vaIndexer)
+	c.vaSynced = func() bool { return true }
+
+	unpublish := func() {
+		t.Helper()
+		_, err := c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volumeID,
+			NodeId:   nodes[1],
+		})
+		if err != nil {
+			t.Fatalf("ControllerUnpublishVolume failed: %v", err)
+		}
+	}
+
+	// the volume attachment still publishes the volume to the other node
+	unpublish()
+	if attached, err := c.isVolumeAttached(ctx, volumeID, nodes[0]); err != nil || !attached {
+		t.Errorf("Volume %s should still be attached to node %s: %v", volumeID, nodes[0], err)
+	}
+
+	// once it no longer does, the leaked attachment is detached
+	vaIndexer.Delete(va)
+	unpublish()
+	if attached, err := c.isVolumeAttached(ctx, volumeID, nodes[0]); err != nil || attached {
+		t.Errorf("Volume %s should be detached from node %s: %v", volumeID, nodes[0], err)
+	}
+}
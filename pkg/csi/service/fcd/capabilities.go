@@ -0,0 +1,42 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// requireCapability fails with Unimplemented, naming the vSphere release
+// required, when the vCenter of a volume lacks the capability of an
+// operation, rather than letting vCenter fail it with a SOAP fault.
+func (c *controller) requireCapability(ctx context.Context, vc string,
+	capability vclib.Capability, operation string) error {
+
+	if c.connManager(ctx).HasCapability(ctx, vc, capability) {
+		return nil
+	}
+	msg := fmt.Sprintf("%s on vCenter %s requires %s", operation, vc, capability.Requirement())
+	logging.Logger(ctx).Error(msg)
+	return status.Errorf(codes.Unimplemented, msg)
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// cnsPods tracks the pods last pushed to CNS for each volume, so that the
+// pods that stopped using a volume are removed from its metadata. The zero
+// value is ready to use.
+type cnsPods struct {
+	sync.Mutex
+
+	// pods are the namespace/name of the pods of each FCD
+	pods map[string]map[string]bool
+}
+
+// get returns the pods last pushed for the FCD.
+func (p *cnsPods) get(fcdID string) map[string]bool {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.pods[fcdID]
+}
+
+// set records the pods pushed for the FCD.
+func (p *cnsPods) set(fcdID string, pods map[string]bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.pods == nil {
+		p.pods = make(map[string]map[string]bool)
+	}
+	p.pods[fcdID] = pods
+}
+
+// remove forgets the pods of the FCD.
+func (p *cnsPods) remove(fcdID string) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.pods, fcdID)
+}
+
+// cnsMetadataEnabled returns true when the metadata of the volumes is pushed
+// to CNS.
+func (c *controller) cnsMetadataEnabled() bool {
+	return c.cfg.Global.CnsMetadataSyncIntervalSecs > 0
+}
+
+// cnsMetadata returns the metadata of the entities of the cluster.
+func (c *controller) cnsMetadata(user string, entities []vclib.CnsKubernetesEntityMetadata) vclib.CnsVolumeMetadata {
+	return vclib.CnsVolumeMetadata{
+		ContainerCluster: vclib.CnsContainerCluster{
+			ClusterType: vclib.CnsClusterTypeKubernetes,
+			ClusterID:   c.cfg.Global.ClusterID,
+			VSphereUser: user,
+		},
+		EntityMetadata: entities,
+	}
+}
+
+// cnsLabels returns the labels of a Kubernetes object, sorted by key.
+func cnsLabels(objLabels map[string]string) []types.KeyValue {
+	kvs := make([]types.KeyValue, 0, len(objLabels))
+	for k, v := range objLabels {
+		kvs = append(kvs, types.KeyValue{Key: k, Value: v})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
+
+// registerCnsVolume registers a new FCD with the CNS of its vCenter, along
+// with the metadata of its PV, named after the request. The FCDs of the
+// vCenters older than 6.7U3 are not registered.
+func (c *controller) registerCnsVolume(ctx context.Context, vcServer string,
+	fcd *vclib.FirstClassDiskInfo, pvName string) error {
+
+	if !c.cnsMetadataEnabled() {
+		return nil
+	}
+	cns, user, err := c.cnsOps(ctx, vcServer)
+	if err != nil || cns == nil {
+		return err
+	}
+
+	metadata := c.cnsMetadata(user, []vclib.CnsKubernetesEntityMetadata{
+		{EntityName: pvName, EntityType: vclib.CnsEntityTypePV},
+	})
+	return cns.RegisterVolume(ctx, fcd.Config.Id.Id, pvName, fcd.DatastoreInfo.Reference(), metadata)
+}
+
+// unregisterCnsVolume removes an FCD about to be deleted from the CNS of
+// its vCenter, along with its metadata.
+func (c *controller) unregisterCnsVolume(ctx context.Context, vcServer string, fcdID string) error {
+	if !c.cnsMetadataEnabled() {
+		return nil
+	}
+	cns, _, err := c.cnsOps(ctx, vcServer)
+	if err != nil || cns == nil {
+		return err
+	}
+
+	c.cnsPods.remove(fcdID)
+	err = cns.UnregisterVolume(ctx, fcdID)
+	if vclib.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// runCnsMetadataSync pushes the metadata of the volumes to CNS on every
+// interval until the context is cancelled.
+func (c *controller) runCnsMetadataSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.syncCnsMetadata(ctx)
+		}
+	}
+}
+
+// syncCnsMetadata pushes the PV, PVC and pods of each FCD with a PV to the
+// CNS of its vCenter, registering the FCDs created before the sync was
+// enabled. Nothing is done until the listers are synced, as the pods
+// missing from a partial list would be removed from the metadata.
+func (c *controller) syncCnsMetadata(ctx context.Context) {
+	logger := logging.Logger(ctx)
+
+	if c.cnsSynced == nil || !c.cnsSynced() {
+		logger.Warning("Skipping the CNS metadata sync until the PVs, PVCs and pods are synced")
+		return
+	}
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		logger.Errorf("Failed to list the PVs. Err: %v", err)
+		return
+	}
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		logger.Errorf("Failed to list the pods. Err: %v", err)
+		return
+	}
+
+	// the pods that may still use the volume of each claim
+	claimPods := make(map[string][]*v1.Pod)
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				key := pod.Namespace + "/" + volume.PersistentVolumeClaim.ClaimName
+				claimPods[key] = append(claimPods[key], pod)
+			}
+		}
+	}
+
+	for _, pv := range pvs {
+		if pv.Spec.CSI == nil || strings.HasPrefix(pv.Spec.CSI.VolumeHandle, FileVolumeIDPrefix) {
+			continue
+		}
+		fcdID := pv.Spec.CSI.VolumeHandle
+
+		// the PVs of the other drivers have no FCD
+		discoveryInfo, err := c.connMgr.WhichVCandDCByFCDId(ctx, fcdID)
+		if err == vclib.ErrNoDiskIDFound {
+			continue
+		} else if err != nil {
+			logger.Errorf("WhichVCandDCByFCDId(%s) failed. Err: %v", fcdID, err)
+			continue
+		}
+
+		if err := c.pushCnsMetadata(ctx, discoveryInfo, pv, claimPods); err != nil {
+			logger.Errorf("Failed to push the CNS metadata of volume %s. Err: %v", fcdID, err)
+		}
+	}
+}
+
+// pushCnsMetadata pushes the PV of an FCD, its PVC and the pods using it to
+// the CNS of its vCenter, and removes the pods that stopped using it.
+func (c *controller) pushCnsMetadata(ctx context.Context, discoveryInfo *cm.FcdDiscoveryInfo,
+	pv *v1.PersistentVolume, claimPods map[string][]*v1.Pod) error {
+
+	cns, user, err := c.cnsOps(ctx, discoveryInfo.VcServer)
+	if err != nil || cns == nil {
+		return err
+	}
+	fcdID := discoveryInfo.FCDInfo.Config.Id.Id
+
+	entities := []vclib.CnsKubernetesEntityMetadata{
+		{EntityName: pv.Name, EntityType: vclib.CnsEntityTypePV, Labels: cnsLabels(pv.Labels)},
+	}
+	pods := make(map[string]bool)
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		claim := vclib.CnsKubernetesEntityMetadata{
+			EntityName: ref.Name,
+			EntityType: vclib.CnsEntityTypePVC,
+			Namespace:  ref.Namespace,
+			ReferredEntity: []vclib.CnsKubernetesEntityReference{
+				{EntityType: vclib.CnsEntityTypePV, EntityName: pv.Name},
+			},
+		}
+		if pvc, err := c.pvcLister.PersistentVolumeClaims(ref.Namespace).Get(ref.Name); err == nil {
+			claim.Labels = cnsLabels(pvc.Labels)
+		}
+		entities = append(entities, claim)
+
+		for _, pod := range claimPods[ref.Namespace+"/"+ref.Name] {
+			entities = append(entities, vclib.CnsKubernetesEntityMetadata{
+				EntityName: pod.Name,
+				EntityType: vclib.CnsEntityTypePod,
+				Namespace:  pod.Namespace,
+				ReferredEntity: []vclib.CnsKubernetesEntityReference{
+					{EntityType: vclib.CnsEntityTypePVC, EntityName: ref.Name, Namespace: ref.Namespace},
+				},
+			})
+			pods[pod.Namespace+"/"+pod.Name] = true
+		}
+	}
+
+	// the pods that stopped using the volume are removed
+	update := entities
+	for key := range c.cnsPods.get(fcdID) {
+		if !pods[key] {
+			parts := strings.SplitN(key, "/", 2)
+			update = append(update, vclib.CnsKubernetesEntityMetadata{
+				EntityName: parts[1],
+				EntityType: vclib.CnsEntityTypePod,
+				Namespace:  parts[0],
+				Delete:     true,
+			})
+		}
+	}
+	err = cns.UpdateVolumeMetadata(ctx, fcdID, c.cnsMetadata(user, update))
+	if vclib.IsNotFound(err) {
+		// the FCDs created before the sync was enabled are not registered
+		err = cns.RegisterVolume(ctx, fcdID, pv.Name,
+			discoveryInfo.FCDInfo.DatastoreInfo.Reference(), c.cnsMetadata(user, entities))
+	}
+	if err != nil {
+		return err
+	}
+	c.cnsPods.set(fcdID, pods)
+	return nil
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// fakeCns keeps the metadata of the volumes registered with CNS in memory,
+// as vcsim does not simulate it.
+type fakeCns struct {
+	volumes map[string][]vclib.CnsKubernetesEntityMetadata
+}
+
+func (f *fakeCns) RegisterVolume(ctx context.Context, fcdID string, name string,
+	datastore types.ManagedObjectReference, metadata vclib.CnsVolumeMetadata) error {
+	if _, ok := f.volumes[fcdID]; !ok {
+		f.volumes[fcdID] = metadata.EntityMetadata
+	}
+	return nil
+}
+
+func (f *fakeCns) UpdateVolumeMetadata(ctx context.Context, fcdID string, metadata vclib.CnsVolumeMetadata) error {
+	entities, ok := f.volumes[fcdID]
+	if !ok {
+		return soap.WrapVimFault(&types.NotFound{})
+	}
+	for _, update := range metadata.EntityMetadata {
+		var kept []vclib.CnsKubernetesEntityMetadata
+		for _, entity := range entities {
+			if entity.EntityType != update.EntityType || entity.EntityName != update.EntityName ||
+				entity.Namespace != update.Namespace {
+				kept = append(kept, entity)
+			}
+		}
+		if !update.Delete {
+			kept = append(kept, update)
+		}
+		entities = kept
+	}
+	f.volumes[fcdID] = entities
+	return nil
+}
+
+func (f *fakeCns) UnregisterVolume(ctx context.Context, fcdID string) error {
+	if _, ok := f.volumes[fcdID]; !ok {
+		return soap.WrapVimFault(&types.NotFound{})
+	}
+	delete(f.volumes, fcdID)
+	return nil
+}
+
+// entities returns the type and name of the entities of the volume.
+func (f *fakeCns) entities(fcdID string) map[string]bool {
+	entities := make(map[string]bool)
+	for _, entity := range f.volumes[fcdID] {
+		entities[entity.EntityType+" "+entity.EntityName] = true
+	}
+	return entities
+}
+
+func TestCnsMetadata(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+	config.Global.ClusterID = "cluster-1"
+	config.Global.CnsMetadataSyncIntervalSecs = 600
+
+	cns := &fakeCns{volumes: make(map[string][]vclib.CnsKubernetesEntityMetadata)}
+	pvs := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvcs := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	synced := false
+	c.pvLister = listerv1.NewPersistentVolumeLister(pvs)
+	c.pvcLister = listerv1.NewPersistentVolumeClaimLister(pvcs)
+	c.podLister = listerv1.NewPodLister(pods)
+	c.cnsSynced = func() bool { return synced }
+	c.hooks = vsphereHooks{
+		cns: func(*vclib.CnsClient) cnsOps {
+			return cns
+		},
+	}
+
+	ctx := context.Background()
+
+	createVolume := func(name string) string {
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name: name,
+			Parameters: map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskParentName: myds.Name,
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+		}
+		return resp.Volume.VolumeId
+	}
+
+	// a vCenter older than 6.7U3 has no CNS
+	connMgr.RecordCapabilities(config.Global.VCenterIP, "6.5")
+	unsupported := createVolume("pvc-unsupported")
+	if _, ok := cns.volumes[unsupported]; ok {
+		t.Error("The volume of a vCenter without CNS should not be registered")
+	}
+	connMgr.RecordCapabilities(config.Global.VCenterIP, "6.7.3")
+
+	// pvc-2 is created before the sync is enabled
+	config.Global.CnsMetadataSyncIntervalSecs = 0
+	volume2 := createVolume("pvc-2")
+	config.Global.CnsMetadataSyncIntervalSecs = 600
+	volume1 := createVolume("pvc-1")
+	if _, ok := cns.volumes[volume2]; ok {
+		t.Error("The volume should not be registered while the sync is disabled")
+	}
+	if entities := cns.entities(volume1); len(entities) != 1 || !entities[vclib.CnsEntityTypePV+" pvc-1"] {
+		t.Errorf("The volume should be registered with its PV: %v", entities)
+	}
+
+	for name, volumeID := range map[string]string{"pvc-1": volume1, "pvc-2": volume2} {
+		pvs.Add(&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{VolumeHandle: volumeID},
+				},
+				ClaimRef: &v1.ObjectReference{Namespace: "default", Name: "data-" + name},
+			},
+		})
+	}
+	pvcs.Add(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data-pvc-1", Labels: map[string]string{"app": "db"}},
+	})
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db-0"},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{{
+				Name: "data",
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data-pvc-1"},
+				},
+			}},
+		},
+	}
+	pods.Add(pod)
+
+	// nothing is pushed until the listers are synced
+	c.syncCnsMetadata(ctx)
+	if entities := cns.entities(volume1); len(entities) != 1 {
+		t.Errorf("Nothing should be pushed before the listers are synced: %v", entities)
+	}
+
+	synced = true
+	c.syncCnsMetadata(ctx)
+	entities := cns.entities(volume1)
+	if len(entities) != 3 || !entities[vclib.CnsEntityTypePVC+" data-pvc-1"] || !entities[vclib.CnsEntityTypePod+" db-0"] {
+		t.Errorf("The PVC and pod of the volume should be pushed: %v", entities)
+	}
+	for _, entity := range cns.volumes[volume1] {
+		if entity.EntityType == vclib.CnsEntityTypePVC && (len(entity.Labels) != 1 || entity.Labels[0].Value != "db") {
+			t.Errorf("The labels of the PVC should be pushed: %+v", entity)
+		}
+	}
+	if entities := cns.entities(volume2); len(entities) != 2 || !entities[vclib.CnsEntityTypePV+" pvc-2"] {
+		t.Errorf("The volume created before the sync should be registered: %v", entities)
+	}
+
+	// the pods that stopped using the volume are removed
+	pods.Delete(pod)
+	c.syncCnsMetadata(ctx)
+	if entities := cns.entities(volume1); len(entities) != 2 || entities[vclib.CnsEntityTypePod+" db-0"] {
+		t.Errorf("The deleted pod should be removed: %v", entities)
+	}
+
+	// the deleted volumes are unregistered
+	if _, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volume1}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if _, ok := cns.volumes[volume1]; ok {
+		t.Error("The deleted volume should be unregistered")
+	}
+}
@@ -32,6 +32,55 @@ const (
 	// FirstClassDiskTypeString in string form
 	FirstClassDiskTypeString = "First Class Disk"
 
+	// FileShareTypeString in string form
+	FileShareTypeString = "vSAN File Share"
+
+	// FileVolumeIDPrefix prefixes the IDs of the volumes backed by vSAN
+	// file shares, which are <prefix><vCenter>/<cluster>/<share UUID>.
+	FileVolumeIDPrefix = "file:"
+
+	// SnapshotIDSeparator separates the FCD ID from the FCD snapshot ID
+	// in the snapshot IDs returned to the CO.
+	SnapshotIDSeparator = "+"
+
+	// SecretServer is the key of the vCenter in the secrets of a request.
+	SecretServer = "server"
+	// SecretUsername is the key of the vCenter username in the secrets of
+	// a request. When set, the credentials of the secrets replace the
+	// configured ones for the request.
+	SecretUsername = "username"
+	// SecretPassword is the key of the vCenter password in the secrets of
+	// a request.
+	SecretPassword = "password"
+	// SecretGuestUsername is the key of the guest user in the secrets of a
+	// CreateSnapshot request, which quiesces the volumes attached to a VM
+	// by running a program in its guest.
+	SecretGuestUsername = "guest-username"
+	// SecretGuestPassword is the key of the password of the guest user in
+	// the secrets of a CreateSnapshot request.
+	SecretGuestPassword = "guest-password"
+
+	// OwnerTagCategory is the vSphere tag category of the tags that mark
+	// the FCDs as owned by a Kubernetes cluster. The tags are named after
+	// the cluster IDs.
+	OwnerTagCategory = "kubernetes-cluster"
+
+	// ContentSourceTagCategory is the vSphere tag category of the tags
+	// that record the content source of the FCDs created from a volume or
+	// a snapshot. The tags are named after the CSI ID of the source.
+	ContentSourceTagCategory = "kubernetes-content-source"
+
+	// NamespaceTagCategory is the vSphere tag category of the tags that
+	// record the namespace of the PVC of the FCDs, whose capacity counts
+	// against the limit of the namespace. The tags are named after the
+	// cluster ID and the namespace, as in "cluster-id/namespace", or after
+	// the namespace alone without a cluster ID.
+	NamespaceTagCategory = "kubernetes-namespace"
+
+	// NamespaceQuotaOthers is the key of the quota ConfigMap with the
+	// capacity limit of the namespaces that have no key of their own.
+	NamespaceQuotaOthers = "*"
+
 	//
 	// Kubernetes volume labels
 	//
@@ -43,6 +92,9 @@ const (
 	AttributeFirstClassDiskType = "type"
 	// AttributeFirstClassDiskName is a Kubernetes volume label.
 	AttributeFirstClassDiskName = "name"
+	// AttributeFirstClassDiskRequestedName is a Kubernetes volume label
+	// with the CSI name of a volume whose FCD name was sanitized.
+	AttributeFirstClassDiskRequestedName = "requested_name"
 	// AttributeFirstClassDiskParentType is a Kubernetes volume label.
 	AttributeFirstClassDiskParentType = "parent_type"
 	// AttributeFirstClassDiskParentName is a Kubernetes volume label.
@@ -51,7 +103,8 @@ const (
 	AttributeFirstClassDiskOwningDatastore = "owning_datastore"
 	// AttributeFirstClassDiskVcenter is a Kubernetes volume label.
 	AttributeFirstClassDiskVcenter = "vcenter"
-	// AttributeFirstClassDiskDatacenter is a Kubernetes volume label.
+	// AttributeFirstClassDiskDatacenter is a Kubernetes volume label, and
+	// a volume parameter pinning the provisioning to a datacenter.
 	AttributeFirstClassDiskDatacenter = "datacenter"
 	// AttributeFirstClassDiskPage83Data is a Kubernetes volume label.
 	AttributeFirstClassDiskPage83Data = "page83data"
@@ -59,6 +112,86 @@ const (
 	AttributeFirstClassDiskZone = "zone"
 	// AttributeFirstClassDiskRegion is a Kubernetes volume label.
 	AttributeFirstClassDiskRegion = "region"
+	// AttributeFirstClassDiskSCSIController is a Kubernetes volume label
+	// with the bus number of the SCSI controller the FCD is attached to.
+	AttributeFirstClassDiskSCSIController = "scsi_controller"
+	// AttributeFirstClassDiskSCSIUnit is a Kubernetes volume label with the
+	// unit number of the FCD on its SCSI controller.
+	AttributeFirstClassDiskSCSIUnit = "scsi_unit"
+	// AttributeFirstClassDiskStoragePolicyName is a mutable Kubernetes
+	// volume parameter with the name of the storage policy of the FCD.
+	AttributeFirstClassDiskStoragePolicyName = "storagepolicyname"
+	// AttributeFirstClassDiskEncryption is a Kubernetes volume parameter
+	// that encrypts the FCD with the VM Encryption storage policy when true.
+	AttributeFirstClassDiskEncryption = "encryption"
+	// AttributeFirstClassDiskEncryptionPolicyName is a Kubernetes volume
+	// parameter with the name of the encryption storage policy to encrypt
+	// the FCD with instead of the VM Encryption one.
+	AttributeFirstClassDiskEncryptionPolicyName = "encryptionpolicyname"
+	// AttributeFirstClassDiskEncrypted is a Kubernetes volume label that is
+	// true when the FCD is encrypted.
+	AttributeFirstClassDiskEncrypted = "encrypted"
+	// AttributeFirstClassDiskDefaultSizeGB is a Kubernetes volume parameter
+	// with the size, in GiB, of the volumes whose request does not set one,
+	// instead of the default-volume-size-gb of the configuration.
+	AttributeFirstClassDiskDefaultSizeGB = "defaultsizegb"
+	// AttributeFirstClassDiskFormat is a Kubernetes volume label with the
+	// provisioning format of the FCD: thin, zeroedthick or eagerzeroedthick.
+	AttributeFirstClassDiskFormat = "diskformat"
+	// AttributeFirstClassDiskMode is a Kubernetes volume label with the mode
+	// the FCD is attached to nodes in, independent_persistent by default.
+	AttributeFirstClassDiskMode = "diskmode"
+	// AttributeFirstClassDiskSharing is a Kubernetes volume label with the
+	// sharing mode the FCD is attached to nodes with.
+	AttributeFirstClassDiskSharing = "disksharing"
+	// AttributeFirstClassDiskMultiAttach is a Kubernetes volume parameter
+	// and label that, when true, allows the block volumes to be attached to
+	// several nodes at once with the MULTI_NODE_MULTI_WRITER access mode,
+	// for clustered file systems such as OCFS2. The FCDs are then eager
+	// zeroed and attached with multi-writer sharing.
+	AttributeFirstClassDiskMultiAttach = "multiattach"
+	// AttributeFirstClassDiskAccessType is a Kubernetes volume label that
+	// records whether the volume is staged as a block device or mounted.
+	AttributeFirstClassDiskAccessType = "access_type"
+	// AttributeFirstClassDiskMkfsOptions is a Kubernetes volume label with
+	// the options passed to mkfs when the node formats the volume, such as
+	// "-m reflink=1" for xfs.
+	AttributeFirstClassDiskMkfsOptions = "mkfsoptions"
+	// AttributeFirstClassDiskCreatedAt is a Kubernetes volume label with
+	// the creation time of the FCD, in RFC 3339 format.
+	AttributeFirstClassDiskCreatedAt = "created_at"
+	// AttributeFirstClassDiskSourceVolume is a Kubernetes volume label with
+	// the ID of the volume the FCD was cloned from.
+	AttributeFirstClassDiskSourceVolume = "source_volume"
+	// AttributeFirstClassDiskSourceSnapshot is a Kubernetes volume label
+	// with the ID of the snapshot the FCD was created from.
+	AttributeFirstClassDiskSourceSnapshot = "source_snapshot"
+
+	// AttributeSnapshotQuiesce is a Kubernetes snapshot parameter that,
+	// when true, flushes the file systems of the VM a volume is attached
+	// to before its snapshot is taken.
+	AttributeSnapshotQuiesce = "quiesce"
+	// AttributeSnapshotQuiesceTimeoutSecs is a Kubernetes snapshot
+	// parameter with the number of seconds the file systems are flushed
+	// for before the snapshot is taken crash-consistent.
+	AttributeSnapshotQuiesceTimeoutSecs = "quiescetimeoutsecs"
+
+	// AttributeFilesystem is a Kubernetes volume parameter that selects
+	// the kind of volume to provision. FilesystemVsanFile provisions a vSAN
+	// file share, otherwise an FCD is provisioned.
+	AttributeFilesystem = "filesystem"
+	// AttributeFileShareAccessPoint is a Kubernetes volume label with the
+	// NFSv4.1 access point of a vSAN file share, as <server>:<path>.
+	AttributeFileShareAccessPoint = "nfs_access_point"
+
+	// FilesystemVsanFile is the filesystem of the volumes backed by vSAN
+	// file shares, which are mounted over NFS by any number of nodes.
+	FilesystemVsanFile = "vsan-file"
+
+	// AccessTypeBlock is the access type of raw block volumes.
+	AccessTypeBlock = "block"
+	// AccessTypeMount is the access type of mounted volumes.
+	AccessTypeMount = "mount"
 
 	//
 	// Kubernetes node/persistent volume labels
@@ -72,4 +205,33 @@ const (
 
 	// LabelZoneRegion is documented with LabelZoneFailureDomain.
 	LabelZoneRegion = "failure-domain.beta.kubernetes.io/region"
+
+	// LabelTopologyZone is the label that replaces LabelZoneFailureDomain
+	// since Kubernetes 1.17. For more information please see
+	// https://kubernetes.io/docs/reference/kubernetes-api/labels-annotations-taints/#topologykubernetesiozone.
+	LabelTopologyZone = "topology.kubernetes.io/zone"
+
+	// LabelTopologyRegion is the label that replaces LabelZoneRegion.
+	LabelTopologyRegion = "topology.kubernetes.io/region"
+
+	// ParameterSelectedNode is the volume parameter with the name of the
+	// node selected for a claim of a WaitForFirstConsumer StorageClass.
+	ParameterSelectedNode = "csi.storage.k8s.io/selected-node"
+
+	// ParameterVolumeSnapshotName and ParameterVolumeSnapshotNamespace are
+	// the snapshot parameters with the name and namespace of the
+	// VolumeSnapshot, passed by the external snapshotter started with
+	// --extra-create-metadata.
+	ParameterVolumeSnapshotName      = "csi.storage.k8s.io/volumesnapshot/name"
+	ParameterVolumeSnapshotNamespace = "csi.storage.k8s.io/volumesnapshot/namespace"
+
+	// ParameterPVCNamespace is the volume parameter with the namespace of
+	// the PVC of a volume, passed by the external-provisioner with
+	// --extra-create-metadata.
+	ParameterPVCNamespace = "csi.storage.k8s.io/pvc/namespace"
+
+	// AnnotationNodeID is an annotation placed on nodes by the Kubelet with
+	// the node IDs reported by each CSI driver, encoded as a JSON map of
+	// driver name to node ID.
+	AnnotationNodeID = "csi.volume.kubernetes.io/nodeid"
 )
@@ -0,0 +1,153 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// contentSourceID returns the CSI ID of the volume or snapshot of a content
+// source, or an empty string when there is no source.
+func contentSourceID(source *csi.VolumeContentSource) string {
+	if snapshot := source.GetSnapshot(); snapshot != nil {
+		return snapshot.SnapshotId
+	}
+	return source.GetVolume().GetVolumeId()
+}
+
+// toContentSource returns the content source of a CSI ID returned by
+// contentSourceID. The snapshot IDs are told apart from the volume IDs by
+// their SnapshotIDSeparator.
+func toContentSource(sourceID string) *csi.VolumeContentSource {
+	if len(sourceID) == 0 {
+		return nil
+	}
+	if strings.Contains(sourceID, SnapshotIDSeparator) {
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: sourceID},
+			},
+		}
+	}
+	return &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: sourceID},
+		},
+	}
+}
+
+// contentSourceAttributes adds the origin and the creation time of an FCD
+// to the attributes of its volume.
+func contentSourceAttributes(attributes map[string]string, fcd *vclib.FirstClassDiskInfo,
+	source *csi.VolumeContentSource) {
+
+	if !fcd.Config.CreateTime.IsZero() {
+		attributes[AttributeFirstClassDiskCreatedAt] = fcd.Config.CreateTime.UTC().Format(time.RFC3339)
+	}
+	if snapshot := source.GetSnapshot(); snapshot != nil {
+		attributes[AttributeFirstClassDiskSourceSnapshot] = snapshot.SnapshotId
+	} else if volume := source.GetVolume(); volume != nil {
+		attributes[AttributeFirstClassDiskSourceVolume] = volume.VolumeId
+	}
+}
+
+// contentSources caches the CSI ID of the content source of each FCD, which
+// is empty for the FCDs created without one, as the source of an FCD never
+// changes. The zero value is ready to use.
+type contentSources struct {
+	sync.Mutex
+
+	ids map[string]string
+}
+
+// get returns the source ID of the FCD, if it is known.
+func (s *contentSources) get(fcdID string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	id, ok := s.ids[fcdID]
+	return id, ok
+}
+
+// set records the source ID of the FCD.
+func (s *contentSources) set(fcdID string, sourceID string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.ids == nil {
+		s.ids = make(map[string]string)
+	}
+	s.ids[fcdID] = sourceID
+}
+
+// remove forgets the source ID of the FCD.
+func (s *contentSources) remove(fcdID string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.ids, fcdID)
+}
+
+// tagContentSource records the content source of a new FCD, by attaching
+// the tag named after the source ID to it. The tag is created on first use.
+func (c *controller) tagContentSource(ctx context.Context, discoveryInfo *cm.ZoneDiscoveryInfo,
+	fcdID string, source *csi.VolumeContentSource) error {
+
+	sourceID := contentSourceID(source)
+	if len(sourceID) == 0 {
+		c.contentSources.set(fcdID, "")
+		return nil
+	}
+
+	if err := c.connManager(ctx).EnsureTag(ctx, discoveryInfo.VcServer, ContentSourceTagCategory, sourceID); err != nil {
+		return err
+	}
+	if err := discoveryInfo.DataCenter.AttachFirstClassDiskTag(ctx, fcdID, ContentSourceTagCategory, sourceID); err != nil {
+		return err
+	}
+	c.contentSources.set(fcdID, sourceID)
+	return nil
+}
+
+// volumeContentSource returns the content source recorded for an FCD, or
+// nil when it was created without one or its source was not recorded.
+func (c *controller) volumeContentSource(ctx context.Context, dc *vclib.Datacenter,
+	fcdID string) (*csi.VolumeContentSource, error) {
+
+	if sourceID, ok := c.contentSources.get(fcdID); ok {
+		return toContentSource(sourceID), nil
+	}
+
+	tags, err := dc.GetFirstClassDiskTags(ctx, fcdID, ContentSourceTagCategory)
+	if err != nil {
+		return nil, err
+	}
+	var sourceID string
+	if len(tags) > 0 {
+		sourceID = tags[0]
+	}
+	c.contentSources.set(fcdID, sourceID)
+	return toContentSource(sourceID), nil
+}
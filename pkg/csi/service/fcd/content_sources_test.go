@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestToContentSource(t *testing.T) {
+	if source := toContentSource(""); source != nil {
+		t.Errorf("Expected no content source, got %v", source)
+	}
+
+	volume := toContentSource("fcd-1")
+	if volume.GetVolume().GetVolumeId() != "fcd-1" {
+		t.Errorf("Expected volume fcd-1, got %v", volume)
+	}
+	if id := contentSourceID(volume); id != "fcd-1" {
+		t.Errorf("Expected source ID fcd-1, got %s", id)
+	}
+
+	snapshotID := createSnapshotID("fcd-1", "snap-1")
+	snapshot := toContentSource(snapshotID)
+	if snapshot.GetSnapshot().GetSnapshotId() != snapshotID {
+		t.Errorf("Expected snapshot %s, got %v", snapshotID, snapshot)
+	}
+	if id := contentSourceID(snapshot); id != snapshotID {
+		t.Errorf("Expected source ID %s, got %s", snapshotID, id)
+	}
+}
+
+func TestVolumeContentSource(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	params := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+	volumeIDs := make(map[string]string)
+	for _, name := range []string{"source", "other", "clone"} {
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:       name,
+			Parameters: params,
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+		}
+		if resp.Volume.ContentSource != nil {
+			t.Errorf("Volume %s should have no content source: %v", name, resp.Volume.ContentSource)
+		}
+		createdAt := resp.Volume.VolumeContext[AttributeFirstClassDiskCreatedAt]
+		if _, err := time.Parse(time.RFC3339, createdAt); err != nil {
+			t.Errorf("Volume %s has an invalid creation time %q: %v", name, createdAt, err)
+		}
+		volumeIDs[name] = resp.Volume.VolumeId
+	}
+
+	// vcsim cannot clone FCDs, the source of the clone is recorded as it
+	// would be by CreateVolume
+	discoveryInfo, err := connMgr.WhichVCandDCByZone(ctx, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := toContentSource(volumeIDs["source"])
+	if err := c.tagContentSource(ctx, discoveryInfo, volumeIDs["clone"], source); err != nil {
+		t.Fatalf("tagContentSource failed: %v", err)
+	}
+
+	// the source is read back from the tags of the FCD
+	c.contentSources = contentSources{}
+	resp, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	for _, entry := range resp.Entries {
+		volume := entry.Volume
+		if volume.VolumeId != volumeIDs["clone"] {
+			if volume.ContentSource != nil {
+				t.Errorf("Volume %s should have no content source: %v", volume.VolumeId, volume.ContentSource)
+			}
+			continue
+		}
+		if volume.ContentSource.GetVolume().GetVolumeId() != volumeIDs["source"] {
+			t.Errorf("Expected source volume %s, got %v", volumeIDs["source"], volume.ContentSource)
+		}
+		if volume.VolumeContext[AttributeFirstClassDiskSourceVolume] != volumeIDs["source"] {
+			t.Errorf("Expected attribute %s=%s, got %v", AttributeFirstClassDiskSourceVolume,
+				volumeIDs["source"], volume.VolumeContext)
+		}
+	}
+
+	// a retry gets the source back, even when the request omits it
+	for _, requested := range []*csi.VolumeContentSource{nil, source} {
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:                "clone",
+			Parameters:          params,
+			VolumeContentSource: requested,
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(clone) failed: %v", err)
+		}
+		if resp.Volume.ContentSource.GetVolume().GetVolumeId() != volumeIDs["source"] {
+			t.Errorf("Expected source volume %s, got %v", volumeIDs["source"], resp.Volume.ContentSource)
+		}
+	}
+
+	// but not with another source
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:                "clone",
+		Parameters:          params,
+		VolumeContentSource: toContentSource(volumeIDs["other"]),
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateVolume should have failed with AlreadyExists: %v", err)
+	}
+}
@@ -17,64 +17,165 @@ limitations under the License.
 package fcd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clientset "k8s.io/client-go/kubernetes"
+	typedstoragev1beta1 "k8s.io/client-go/kubernetes/typed/storage/v1beta1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	storagelisterv1beta1 "k8s.io/client-go/listers/storage/v1beta1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 	volumeutil "k8s.io/kubernetes/pkg/volume/util"
 
-	"github.com/vmware/govmomi/units"
 	"github.com/vmware/govmomi/vim25/types"
 
 	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
 	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
 	k8s "k8s.io/cloud-provider-vsphere/pkg/common/kubernetes"
 	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/debug"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/health"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/metrics"
 	vTypes "k8s.io/cloud-provider-vsphere/pkg/csi/types"
 )
 
 type controller struct {
-	cfg     *vcfg.Config
-	connMgr *cm.ConnectionManager
+	cfg      *vcfg.Config
+	connMgr  connectionManager
+	fcdCache *fcdCache
+
+	// volumeLocks serializes the operations on each volume
+	volumeLocks volumeLocks
+	// rpcs tracks the RPCs in flight, which are drained on shutdown
+	rpcs inFlightRPCs
+
+	// vcHealth tracks the vCenters that are degraded
+	vcHealth vcHealth
+
+	// nodeVMs caches the VM of each node
+	nodeVMs nodeVMs
+
+	// hostDatastores caches the datastores accessible from each host
+	hostDatastores hostDatastores
+
+	// vmQueues batches the attachments to each VM and serializes its
+	// reconfigures
+	vmQueues vmQueues
+
+	// secretSessions caches the sessions of the credentials passed in the
+	// secrets of the requests
+	secretSessions secretSessions
+
+	// pendingCreates tracks the creates that outlived their request
+	pendingCreates pendingTasks
+	// pendingExpands tracks the expansions that outlived their request
+	pendingExpands pendingTasks
+
+	// nodeLister looks up the zone of the node selected for a volume that
+	// has no topology requirement
+	nodeLister listerv1.NodeLister
+
+	// pvLister lists the PVs the orphaned volume scans check the FCDs
+	// against, once pvSynced returns true
+	pvLister listerv1.PersistentVolumeLister
+	pvSynced func() bool
+
+	// pvcLister and podLister list the PVCs and pods of the volumes whose
+	// metadata is pushed to CNS, once cnsSynced returns true
+	pvcLister listerv1.PersistentVolumeClaimLister
+	podLister listerv1.PodLister
+	cnsSynced func() bool
+	// cnsPods tracks the pods last pushed to CNS for each volume
+	cnsPods cnsPods
+
+	// vaLister lists the volume attachments the attachment reconciles
+	// check against the node VMs, once vaSynced returns true
+	vaLister storagelisterv1beta1.VolumeAttachmentLister
+	vaSynced func() bool
+	// vaClient updates the status of the volume attachments whose disk is
+	// attached again
+	vaClient typedstoragev1beta1.VolumeAttachmentInterface
+	// recorder reports the volumes attached again and the snapshots that
+	// failed to be quiesced in events
+	recorder record.EventRecorder
+
+	// legacyVolumes caches the FCDs the in-tree volumes were registered as
+	legacyVolumes legacyVolumes
+	// contentSources caches the content source each FCD was created from
+	contentSources contentSources
+
+	// quotaLister gets the ConfigMap of the capacity limits of the
+	// namespaces, once quotaSynced returns true
+	quotaLister listerv1.ConfigMapNamespaceLister
+	quotaSynced func() bool
+	// namespaceLocks serializes the creates of the volumes of each
+	// namespace that has a capacity limit
+	namespaceLocks namespaceLocks
+
+	// metricsServer serves the metrics until the controller is shut down
+	metricsServer *metrics.Server
+	// healthServer serves the liveness and readiness endpoints until the
+	// controller is shut down
+	healthServer *health.Server
+	// debugServer serves the debug endpoint, when it is enabled, until the
+	// controller is shut down
+	debugServer *debug.Server
+
+	// stopBackground stops the background work of the controller, and its
+	// part in the leader election
+	stopBackground context.CancelFunc
+
+	// hooks wrap the vSphere objects used by the volume handlers
+	hooks vsphereHooks
 }
 
 func noResyncPeriodFunc() time.Duration {
 	return 0
 }
 
+// operationTimeout bounds each controller operation, so that a vCenter that
+// hangs cannot hold a worker forever. It is set from operation-timeout-secs
+// by Init.
+var operationTimeout = time.Duration(vcfg.DefaultOperationTimeoutSecs) * time.Second
+
+// withOperationTimeout returns a context that is done after the
+// operationTimeout, or earlier when the context of the request is.
+func withOperationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, operationTimeout)
+}
+
 // New creates a FCD controller
 func New() vTypes.Controller {
 	return &controller{}
 }
 
-func (c *controller) Init(config *vcfg.Config) error {
+func (c *controller) Init(ctx context.Context, config *vcfg.Config) error {
 
 	var (
 		connMgr   *cm.ConnectionManager
 		informMgr *k8s.InformerManager
-		useK      bool = true
+		client    clientset.Interface
 	)
 
-	// Check if we should disable Kubernetes client
-	if k := os.Getenv(vTypes.EnvDisableK8sClient); k != "" {
-		b, err := strconv.ParseBool(k)
-		if err != nil {
-			log.WithError(err).Errorf("failed to parse: %s:%s", vTypes.EnvDisableK8sClient, k)
-		} else if b {
-			useK = false
-		}
-	}
-	if useK {
-		log.Info("Initializing CSI for Kubernetes")
-		client, err := k8s.NewClient(config.Global.ServiceAccount)
+	if useK8sClient(config) {
+		klog.Info("Initializing CSI for Kubernetes")
+		var err error
+		client, err = k8s.NewClient(config.Global.ServiceAccount)
 		if err != nil {
 			return fmt.Errorf("Creating Kubernetes client failed. Err: %v", err)
 		} else {
@@ -84,410 +185,2277 @@ func (c *controller) Init(config *vcfg.Config) error {
 
 	if informMgr != nil {
 		connMgr = cm.NewConnectionManager(config, informMgr.GetSecretListener())
-		informMgr.Listen()
+		informMgr.AddNodeListener(nil, c.nodeDeleted, nil)
+		c.nodeLister = informMgr.GetNodeLister()
+		if config.Global.OrphanedVolumeGCIntervalSecs > 0 || config.Global.CnsMetadataSyncIntervalSecs > 0 ||
+			config.Global.AttachmentReconcileIntervalSecs > 0 {
+			c.pvLister = informMgr.GetPersistentVolumeLister()
+			c.pvSynced = informMgr.PersistentVolumesSynced
+		}
+		if config.Global.CnsMetadataSyncIntervalSecs > 0 {
+			c.pvcLister = informMgr.GetPersistentVolumeClaimLister()
+			c.podLister = informMgr.GetPodLister()
+			c.cnsSynced = informMgr.PodsAndClaimsSynced
+		}
+		if config.Global.AttachmentReconcileIntervalSecs > 0 {
+			c.vaLister = informMgr.GetVolumeAttachmentLister()
+			c.vaSynced = informMgr.VolumeAttachmentsSynced
+			c.vaClient = client.StorageV1beta1().VolumeAttachments()
+		}
+		if len(config.Global.NamespaceQuotaConfigMap) > 0 {
+			c.quotaLister = informMgr.GetConfigMapLister(config.Global.NamespaceQuotaConfigMapNamespace)
+			c.quotaSynced = informMgr.ConfigMapsSynced
+		}
+		c.recorder = newEventRecorder(client, v1.NamespaceAll)
+		if err := syncCredentialSecrets(informMgr, connMgr); err != nil {
+			return err
+		}
 	} else {
+		if err := checkNoCredentialSecrets(config); err != nil {
+			return err
+		}
 		connMgr = cm.NewConnectionManager(config, nil)
 	}
 
 	c.cfg = config
 	c.connMgr = connMgr
+	if config.Global.OperationTimeoutSecs > 0 {
+		operationTimeout = time.Duration(config.Global.OperationTimeoutSecs) * time.Second
+	}
+	c.fcdCache = newFCDCache(connMgr, time.Duration(config.Global.FCDCacheRefreshSecs)*time.Second)
+	c.fcdCache.scan = c.scanFCDs
+
+	// Only the leader of the replicas runs the background work, until the
+	// service stops or the controller is shut down
+	bgCtx, bgCancel := context.WithCancel(ctx)
+	c.stopBackground = bgCancel
+	var lec leaderelection.LeaderElectionConfig
+	leaderElect := config.Global.LeaderElect
+	if leaderElect && client == nil {
+		klog.Warning("Leader election is disabled without the Kubernetes client, this replica runs the background work")
+		leaderElect = false
+	}
+	if leaderElect {
+		identity, err := os.Hostname()
+		if err != nil {
+			klog.Errorf("Failed to get the identity of the leader election. Err: %v", err)
+			return err
+		}
+		if lec, err = c.leaderElectionConfig(bgCtx, client, identity); err != nil {
+			klog.Errorf("Invalid leader election configuration. Err: %v", err)
+			return err
+		}
+	}
+
+	if connMgr.SkipsAPIVersionGates() {
+		klog.Warning("The vCenter API version gates are skipped, every vCenter is assumed to support all of the features")
+	}
 
 	//VC check... FCD is only supported in 6.5+
-	for vc := range connMgr.VsphereInstanceMap {
-		api, err := connMgr.APIVersion(vc)
+	// A vCenter that fails its check is degraded rather than failing Init,
+	// as long as at least one vCenter passes
+	checkCtx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	err := connMgr.ForEachVC(checkCtx, func(ctx context.Context, vc string) error {
+		api, err := checkVC(ctx, connMgr, vc)
 		if err != nil {
-			klog.Errorf("APIVersion failed err=%v", err)
+			klog.Errorf("checkVC failed vc=%s err=%v", vc, err)
+			c.vcHealth.setDegraded(vc, err)
+			connMgr.SetDegraded(vc, true)
 			return err
 		}
+		c.vcHealth.setAPIVersion(vc, api)
+		// The vCenters without a vslm catalog are scanned for FCDs
+		connMgr.DetectVslmCatalog(ctx, vc)
+		return nil
+	})
+	degraded := c.vcHealth.degradedVCs()
+	if len(degraded) > 0 && len(degraded) == len(connMgr.VsphereInstances()) {
+		// The sessions of the checks would otherwise leak on every restart
+		connMgr.Close()
+		return err
+	}
 
-		if err = checkAPI(api); err != nil {
-			klog.Errorf("checkAPI failed err=%v", err)
+	// A role missing privileges would otherwise only fail the first
+	// provisioning or attach with a NoPermission fault
+	if config.Global.CheckPermissions {
+		err := checkPermissions(checkCtx, config, connMgr, func(vc string) bool {
+			return !c.vcHealth.isDegraded(vc)
+		})
+		if err != nil {
+			klog.Errorf("Permission check failed. Err: %v", err)
+			connMgr.Close()
 			return err
 		}
 	}
 
-	return nil
-}
-
-func (c *controller) CreateVolume(
-	ctx context.Context,
-	req *csi.CreateVolumeRequest) (
-	*csi.CreateVolumeResponse, error) {
-
-	// Get create params
-	params := req.GetParameters()
+	metrics.RegisterMetrics()
+	metricsServer, err := metrics.NewServer(config.Global.MetricsBinding)
+	if err != nil {
+		klog.Errorf("Failed to serve metrics on %s. Err: %v", config.Global.MetricsBinding, err)
+		connMgr.Close()
+		return err
+	}
+	c.metricsServer = metricsServer
 
-	// Get accessibility
-	accessibility := req.GetAccessibilityRequirements()
+	healthServer, err := health.NewServer(config.Global.HealthBinding, c.ready)
+	if err != nil {
+		klog.Errorf("Failed to serve the health endpoints on %s. Err: %v", config.Global.HealthBinding, err)
+		metricsServer.Shutdown(checkCtx)
+		connMgr.Close()
+		return err
+	}
+	c.healthServer = healthServer
 
-	// Volume Name
-	volName := req.GetName()
+	if config.Global.DebugEnabled {
+		debugServer, err := debug.NewServer(config.Global.DebugBinding, c.debugState)
+		if err != nil {
+			klog.Errorf("Failed to serve the debug endpoint on %s. Err: %v", config.Global.DebugBinding, err)
+			healthServer.Shutdown(checkCtx)
+			metricsServer.Shutdown(checkCtx)
+			connMgr.Close()
+			return err
+		}
+		c.debugServer = debugServer
+	}
 
-	//check for required parameters
-	if params == nil {
-		msg := "Create parameters is a required parameter."
-		log.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
-	} else if len(volName) == 0 {
-		msg := "Volume name is a required parameter."
-		log.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
-	} else if len(params[AttributeFirstClassDiskParentType]) == 0 {
-		msg := fmt.Sprintf("Volume parameter %s is a required parameter.", AttributeFirstClassDiskParentType)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
-	} else if len(params[AttributeFirstClassDiskParentName]) == 0 {
-		msg := fmt.Sprintf("Volume parameter %s is a required parameter.", AttributeFirstClassDiskParentName)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+	if len(degraded) > 0 {
+		klog.Warningf("Starting with degraded vCenters: %v", degraded)
+		for _, vc := range degraded {
+			go c.retryDegradedVC(bgCtx, vc)
+		}
 	}
 
-	// Volume Size - Default is 10 GiB
-	volSizeBytes := int64(DefaultGbDiskSize * GbInBytes)
-	if req.GetCapacityRange() != nil && req.GetCapacityRange().RequiredBytes != 0 {
-		volSizeBytes = int64(req.GetCapacityRange().GetRequiredBytes())
+	if config.Global.OrphanedVolumeGCIntervalSecs > 0 && c.pvLister == nil {
+		klog.Warning("The orphaned volume scans are disabled without the Kubernetes client")
+	}
+	if config.Global.CnsMetadataSyncIntervalSecs > 0 && c.podLister == nil {
+		klog.Warning("The CNS metadata sync is disabled without the Kubernetes client")
+	}
+	if config.Global.AttachmentReconcileIntervalSecs > 0 && c.vaLister == nil {
+		klog.Warning("The attachment reconciles are disabled without the Kubernetes client")
+	}
+	if len(config.Global.NamespaceQuotaConfigMap) > 0 && c.quotaLister == nil {
+		klog.Warning("The capacity limits of the namespaces are disabled without the Kubernetes client")
 	}
-	volSizeMB := int64(volumeutil.RoundUpSize(volSizeBytes, GbInBytes)) * 1024
 
-	// Volume Type
-	datastoreType := vclib.TypeDatastoreCluster
-	volType := params[AttributeFirstClassDiskParentType]
-	if volType == string(vclib.TypeDatastore) {
-		datastoreType = vclib.TypeDatastore
+	cm.RegisterMetrics()
+	if leaderElect {
+		go c.runLeaderElection(bgCtx, lec)
+	} else {
+		go c.runBackground(bgCtx)
 	}
 
-	datastoreName := params[AttributeFirstClassDiskParentName]
-	zone := params[AttributeFirstClassDiskZone]
-	region := params[AttributeFirstClassDiskRegion]
+	return nil
+}
 
-	// Please see function for more details
-	var err error
-	var discoveryInfo *cm.ZoneDiscoveryInfo
+// syncCredentialSecrets starts the informers, which must all have been
+// requested, and verifies the credentials secrets named by the vCenters once
+// the secrets are synced.
+func syncCredentialSecrets(informMgr *k8s.InformerManager, connMgr *cm.ConnectionManager) error {
+	informMgr.AddSecretListener(connMgr.SecretAdded, nil, connMgr.SecretUpdated)
+	informMgr.Listen()
+
+	// A vCenter naming a missing secret would otherwise only fail to
+	// log in later
+	if !informMgr.WaitForSecretsSynced() {
+		return fmt.Errorf("Syncing the secrets failed")
+	}
+	if err := connMgr.VerifyCredentialSecrets(); err != nil {
+		klog.Errorf("Invalid credentials secrets. Err: %v", err)
+		return err
+	}
+	return nil
+}
 
-	if accessibility != nil && (accessibility.GetRequisite() != nil || accessibility.GetPreferred() != nil) {
-		log.Infoln("WhichVCandDCByZone with Topology Support")
-		if accessibility.GetRequisite() != nil {
-			log.Infoln("Requisite Topology Exists")
-			requisites := accessibility.GetRequisite()
-			for _, requisite := range requisites {
-				segments := requisite.GetSegments()
-				reqRegion := segments[LabelZoneRegion]
-				reqZone := segments[LabelZoneFailureDomain]
-				discoveryInfo, err = c.connMgr.WhichVCandDCByZone(ctx, c.cfg.Labels.Zone, c.cfg.Labels.Region, reqZone, reqRegion)
-				if err == nil {
-					log.Infof("WhichVCandDCByZone Succeeded in region=%s zone=%s", reqRegion, reqZone)
-					break
-				}
-			}
-		} else {
-			log.Infoln("Using Perferred Topology")
-			for _, preferred := range accessibility.GetPreferred() {
-				segments := preferred.GetSegments()
-				reqRegion := segments[LabelZoneRegion]
-				reqZone := segments[LabelZoneFailureDomain]
-				discoveryInfo, err = c.connMgr.WhichVCandDCByZone(ctx, c.cfg.Labels.Zone, c.cfg.Labels.Region, reqZone, reqRegion)
-				if err == nil {
-					log.Infof("WhichVCandDCByZone Succeeded in region=%s zone=%s", reqRegion, reqZone)
-					break
-				}
-			}
+// checkNoCredentialSecrets fails when a vCenter names a credentials secret,
+// which cannot be read without the Kubernetes client.
+func checkNoCredentialSecrets(config *vcfg.Config) error {
+	for vc, vcConfig := range config.VirtualCenter {
+		if vcConfig.SecretName != "" {
+			return fmt.Errorf("vc=%s: secret %s/%s cannot be read without the Kubernetes client",
+				vc, vcConfig.SecretNamespace, vcConfig.SecretName)
 		}
-	} else {
-		log.Infoln("WhichVCandDCByZone with Legacy region/zone")
-		discoveryInfo, err = c.connMgr.WhichVCandDCByZone(ctx, c.cfg.Labels.Zone, c.cfg.Labels.Region, zone, region)
 	}
+	return nil
+}
 
-	if err != nil {
-		msg := fmt.Sprintf("Failed to retrieve VC/DC based on zone %s. Err: %v", zone, err)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+// useK8sClient returns true unless the Kubernetes client is disabled, or
+// there is no kubeconfig or service account to create it from, such as
+// outside of a cluster. The client is disabled by X_CSI_DISABLE_K8S_CLIENT
+// when it is set, and by disable-k8s-client otherwise.
+func useK8sClient(config *vcfg.Config) bool {
+	disable := config.Global.DisableK8sClient
+	if k := os.Getenv(vTypes.EnvDisableK8sClient); k != "" {
+		b, err := strconv.ParseBool(k)
+		if err != nil {
+			logging.WithError(err).Errorf("failed to parse: %s:%s", vTypes.EnvDisableK8sClient, k)
+		} else {
+			disable = b
+		}
 	}
+	if disable {
+		return false
+	}
+	if !k8s.HasClientConfig() {
+		klog.Warning("No kubeconfig or service account token found, running without the Kubernetes client")
+		return false
+	}
+	return true
+}
 
-	firstClassDisk, err := discoveryInfo.DataCenter.GetFirstClassDisk(
-		ctx, datastoreName, datastoreType, volName, vclib.FindFCDByName)
-	if err == nil {
-		log.Warningf("Volume with name %s already exists. Checking for similar parameters.", volName)
+// Shutdown waits up to the drain timeout for the RPCs in flight and the
+// operations in flight on the volumes, and cancels the ones that are still
+// running after it. It then closes the vCenter sessions and stops serving
+// the metrics, the health and the debug endpoints.
+func (c *controller) Shutdown(ctx context.Context) error {
+	if c.cfg != nil {
+		timeout := time.Duration(c.cfg.Global.ShutdownDrainTimeoutSecs) * time.Second
+		drainCtx, cancel := context.WithTimeout(ctx, timeout)
+		if cancelled := c.rpcs.drain(drainCtx); cancelled > 0 {
+			klog.Warningf("Cancelled %d RPCs still in flight after %v", cancelled, timeout)
+		}
+		// The background operations on the volumes are not RPCs
+		if cancelled := c.volumeLocks.drain(drainCtx); cancelled > 0 {
+			klog.Warningf("Cancelled %d volume operations still in flight after %v", cancelled, timeout)
+		}
+		cancel()
+	}
+	if c.stopBackground != nil {
+		c.stopBackground()
+	}
+	if c.connMgr != nil {
+		c.cancelPendingTasks(ctx)
+		c.connMgr.Close()
+	}
+	c.secretSessions.close()
 
-		if firstClassDisk.Config.CapacityInMB != volSizeMB {
-			msg := fmt.Sprintf("Volume already exists but requesting different size. Existing %d != Requested %d",
-				firstClassDisk.Config.CapacityInMB, volSizeMB)
-			log.Errorf(msg)
-			return nil, status.Errorf(codes.AlreadyExists, msg)
+	if c.debugServer != nil {
+		if err := c.debugServer.Shutdown(ctx); err != nil {
+			klog.Warningf("Failed to stop the debug server. Err: %v", err)
 		}
-	} else {
-		err = discoveryInfo.DataCenter.CreateFirstClassDisk(ctx, datastoreName, datastoreType, volName, volSizeMB)
-		if err != nil {
-			msg := fmt.Sprintf("CreateFirstClassDisk failed. Err: %v", err)
-			log.Errorf(msg)
-			return nil, status.Errorf(codes.Internal, msg)
+	}
+	if c.healthServer != nil {
+		if err := c.healthServer.Shutdown(ctx); err != nil {
+			klog.Warningf("Failed to stop the health server. Err: %v", err)
 		}
+	}
+	if c.metricsServer == nil {
+		return nil
+	}
+	return c.metricsServer.Shutdown(ctx)
+}
 
-		firstClassDisk, err = discoveryInfo.DataCenter.GetFirstClassDisk(
-			ctx, datastoreName, datastoreType, volName, vclib.FindFCDByName)
+// cancelPendingTasks cancels the pending creates and expansions that vCenter
+// allows to cancel, as the retries sent to another controller cannot wait
+// for them and would start them again.
+func (c *controller) cancelPendingTasks(ctx context.Context) {
+	for _, pending := range append(c.pendingCreates.removeAll(), c.pendingExpands.removeAll()...) {
+		vsi, ok := c.connMgr.VsphereInstances()[pending.vcServer]
+		if !ok || vsi.Conn.Client == nil {
+			continue
+		}
+		cancelled, err := vclib.CancelTask(ctx, vsi.Conn.Client, pending.task)
 		if err != nil {
-			msg := fmt.Sprintf("GetFirstClassDiskByName(%s) failed. Err: %v", volName, err)
-			log.Errorf(msg)
-			return nil, status.Errorf(codes.Internal, msg)
+			klog.Warningf("Failed to cancel pending task %s of vc=%s. Err: %v", pending.task.Value, pending.vcServer, err)
+		} else if cancelled {
+			klog.Infof("Cancelled pending task %s of vc=%s", pending.task.Value, pending.vcServer)
 		}
 	}
+}
 
-	attributes := make(map[string]string)
-	attributes[AttributeFirstClassDiskType] = FirstClassDiskTypeString
-	attributes[AttributeFirstClassDiskVcenter] = discoveryInfo.VcServer
-	attributes[AttributeFirstClassDiskDatacenter] = discoveryInfo.DataCenter.Name()
-	attributes[AttributeFirstClassDiskName] = firstClassDisk.Config.Name
-	attributes[AttributeFirstClassDiskParentType] = string(firstClassDisk.ParentType)
-	if firstClassDisk.ParentType == vclib.TypeDatastoreCluster {
-		attributes[AttributeFirstClassDiskParentName] = firstClassDisk.StoragePodInfo.Summary.Name
-		attributes[AttributeFirstClassDiskOwningDatastore] = firstClassDisk.DatastoreInfo.Info.Name
-	} else {
-		attributes[AttributeFirstClassDiskParentName] = firstClassDisk.DatastoreInfo.Info.Name
+// nodeDeleted drops the cached VM of a deleted node. The VM may be cached
+// under the node name or under the node ID reported by NodeGetInfo, which
+// the Kubelet records in the node's annotations.
+func (c *controller) nodeDeleted(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if node == nil || !ok {
+		klog.Warningf("nodeDeleted: unrecognized object %+v", obj)
+		return
 	}
 
-	resp := &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      firstClassDisk.Config.Id.Id,
-			CapacityBytes: int64(units.FileSize(firstClassDisk.Config.CapacityInMB * MbInBytes)),
-			VolumeContext: attributes,
-			//TODO: ContentSource?
-		},
-	}
+	c.nodeVMs.remove(node.Name)
+	metrics.DeleteAttachedVolumes(node.Name)
 
-	return resp, nil
+	annotation, ok := node.Annotations[AnnotationNodeID]
+	if !ok {
+		return
+	}
+	nodeIDs := make(map[string]string)
+	if err := json.Unmarshal([]byte(annotation), &nodeIDs); err != nil {
+		klog.Warningf("nodeDeleted: failed to parse %s of node %s. Err: %v", AnnotationNodeID, node.Name, err)
+		return
+	}
+	for _, nodeID := range nodeIDs {
+		c.nodeVMs.remove(nodeID)
+		metrics.DeleteAttachedVolumes(nodeID)
+	}
 }
 
-func (c *controller) DeleteVolume(
-	ctx context.Context,
-	req *csi.DeleteVolumeRequest) (
-	*csi.DeleteVolumeResponse, error) {
-
-	//check for required parameters
-	if len(req.VolumeId) == 0 {
-		msg := "Volume ID is a required parameter."
-		log.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+// Probe verifies that at least one of the configured vCenters is ready.
+func (c *controller) Probe(ctx context.Context) error {
+	degraded := c.vcHealth.degradedVCs()
+	if len(degraded) > 0 {
+		logging.Logger(ctx).Warningf("Degraded vCenters: %v", degraded)
 	}
+	return c.ready()
+}
 
-	discoveryInfo, err := c.connMgr.WhichVCandDCByFCDId(ctx, req.VolumeId)
-	if err == vclib.ErrNoDiskIDFound {
-		log.Warningf("Failed to retrieve VC/DC based on FCDID %s. Err: %v", req.VolumeId, err)
-		return &csi.DeleteVolumeResponse{}, nil
-	} else if err != nil {
-		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", req.VolumeId, err)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+// ready returns nil when a session is established with at least one of the
+// configured vCenters that is not degraded, and the session passed its last
+// check. It does not call the vCenters, as the sessions are checked by their
+// keep-alive.
+func (c *controller) ready() error {
+	err := cm.ErrMustHaveAtLeastOneVCDC
+	if len(c.vcHealth.degradedVCs()) > 0 {
+		err = ErrNoHealthyVCenter
 	}
-
-	// Volume Type
-	var datastoreName string
-	datastoreType := discoveryInfo.FCDInfo.ParentType
-	if datastoreType == vclib.TypeDatastore {
-		datastoreName = discoveryInfo.FCDInfo.DatastoreInfo.Info.Name
-	} else {
-		datastoreName = discoveryInfo.FCDInfo.StoragePodInfo.Summary.Name
+	for vc := range c.connMgr.VsphereInstances() {
+		if c.vcHealth.isDegraded(vc) {
+			continue
+		}
+		if err = c.connMgr.VCHealth(vc); err == nil {
+			return nil
+		}
 	}
+	return err
+}
 
-	err = discoveryInfo.DataCenter.DeleteFirstClassDisk(ctx, datastoreName, datastoreType, req.VolumeId)
-	if err != nil {
-		msg := fmt.Sprintf("DeleteFirstClassDisk(%s) failed. Err: %v", req.VolumeId, err)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+// listFCDs returns all of the FCDs, served from the FCD inventory cache
+// when one is available.
+func (c *controller) listFCDs(ctx context.Context, startingToken string) []*vclib.FirstClassDiskInfo {
+	if c.fcdCache == nil {
+		return c.scanFCDs(ctx)
 	}
-
-	return &csi.DeleteVolumeResponse{}, nil
+	return c.fcdCache.list(ctx, startingToken)
 }
 
-func (c *controller) ControllerPublishVolume(
-	ctx context.Context,
-	req *csi.ControllerPublishVolumeRequest) (
-	*csi.ControllerPublishVolumeResponse, error) {
+// scanFCDs returns the FCDs found in all of the vCenters or, when the
+// listing is scoped to the zone of the controller, the FCDs found in the
+// VC/DC of that zone. Only the datastores of list-volumes-datastores are
+// scanned when it is set.
+func (c *controller) scanFCDs(ctx context.Context) []*vclib.FirstClassDiskInfo {
+	opts := fcdScanOptions{workers: int(c.cfg.Global.ListVolumesConcurrency)}
+	if patterns, _ := vcfg.ParseDatastorePatterns(c.cfg.Global.ListVolumesDatastores); len(patterns) > 0 {
+		opts.allowed = (&vcfg.DatastoreFilter{Allowlist: patterns}).Allows
+	}
 
-	//check for required parameters
-	if len(req.VolumeId) == 0 {
-		msg := "Volume ID is a required parameter."
-		log.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
-	} else if len(req.NodeId) == 0 {
-		msg := "Node ID is a required parameter."
-		log.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+	zone := c.cfg.Global.ListVolumesZone
+	region := c.cfg.Global.ListVolumesRegion
+	if len(zone) == 0 && len(region) == 0 {
+		return getAllFCDs(ctx, c.connMgr, opts)
 	}
 
-	discoveryInfo, err := c.connMgr.WhichVCandDCByFCDId(ctx, req.VolumeId)
+	discoveryInfo, err := c.connMgr.WhichVCandDCByZone(ctx, c.cfg.Labels.Zone, c.cfg.Labels.Region, zone, region)
 	if err != nil {
-		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", req.VolumeId, err)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+		logging.Logger(ctx).Errorf("Failed to retrieve VC/DC based on zone %s. Err: %v", zone, err)
+		return make([]*vclib.FirstClassDiskInfo, 0)
 	}
+	return getZoneFCDs(ctx, c.connMgr, discoveryInfo, opts)
+}
 
-	fcd := discoveryInfo.FCDInfo
-
-	vm, err := discoveryInfo.DataCenter.GetVMByDNSName(ctx, req.NodeId)
-	if err != nil {
-		log.Errorf("GetVMByDNSName(%s) failed. Err: %v", req.NodeId, err)
-		return nil, err
+// invalidateFCDs marks the FCD inventory cache as stale, so that the next
+// listing rescans the vCenters.
+func (c *controller) invalidateFCDs() {
+	if c.fcdCache != nil {
+		c.fcdCache.invalidate()
 	}
+}
 
-	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
-	options := &vclib.VolumeOptions{SCSIControllerType: vclib.PVSCSIControllerType}
-	diskUUID, err := vm.AttachDisk(ctx, filePath, options)
-	if err != nil {
-		log.Errorf("AttachDisk(%s = %s) failed. Err: %v", fcd.Config.Name, filePath, err)
-		return nil, err
+// cacheFCD adds an FCD that was created or expanded to the FCD inventory
+// cache. The cache is invalidated instead when the listing is scoped to a
+// zone or to datastores, which the FCD may be out of.
+func (c *controller) cacheFCD(fcd *vclib.FirstClassDiskInfo) {
+	if c.fcdCache == nil {
+		return
+	}
+	if len(c.cfg.Global.ListVolumesZone) > 0 || len(c.cfg.Global.ListVolumesRegion) > 0 ||
+		len(c.cfg.Global.ListVolumesDatastores) > 0 {
+		c.fcdCache.invalidate()
+		return
 	}
+	c.fcdCache.add(fcd)
+}
 
-	log.Infof("AttachDisk(%s) succeeded with UUID: %s", filePath, diskUUID)
+// uncacheFCD removes a deleted FCD from the FCD inventory cache.
+func (c *controller) uncacheFCD(id string) {
+	if c.fcdCache != nil {
+		c.fcdCache.remove(id)
+	}
+}
 
-	publishInfo := make(map[string]string, 0)
-	publishInfo[AttributeFirstClassDiskType] = FirstClassDiskTypeString
-	publishInfo[AttributeFirstClassDiskVcenter] = discoveryInfo.VcServer
-	publishInfo[AttributeFirstClassDiskDatacenter] = discoveryInfo.DataCenter.Name()
-	publishInfo[AttributeFirstClassDiskName] = fcd.Config.Name
-	publishInfo[AttributeFirstClassDiskParentType] = string(fcd.ParentType)
-	if fcd.ParentType == vclib.TypeDatastoreCluster {
-		publishInfo[AttributeFirstClassDiskParentName] = fcd.StoragePodInfo.Summary.Name
-		publishInfo[AttributeFirstClassDiskOwningDatastore] = fcd.DatastoreInfo.Info.Name
-	} else {
-		publishInfo[AttributeFirstClassDiskParentName] = fcd.DatastoreInfo.Info.Name
+// whichVCandDCByTopology returns the VC/DC of the first topology segment
+// that can be satisfied along with the topology of the zone that was used.
+// When no topology is provided, the legacy zone and region volume
+// parameters are used. The returned topology is nil when no zone or
+// region was requested. When a datastore, or datastore cluster, is named,
+// only the zones with a host that mounts it can be satisfied, otherwise
+// ErrDatastoreNotInZone is returned.
+func (c *controller) whichVCandDCByTopology(ctx context.Context,
+	topologies []*csi.Topology, zone string, region string,
+	datastoreName string, datastoreType vclib.ParentDatastoreType) (*cm.ZoneDiscoveryInfo, *csi.Topology, error) {
+
+	if len(topologies) == 0 {
+		logging.Logger(ctx).V(2).Infoln("WhichVCandDCByZone with Legacy region/zone")
+		discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByZone(ctx, c.cfg.Labels.Zone, c.cfg.Labels.Region, zone, region)
+		if err != nil {
+			return nil, nil, err
+		}
+		topology := toCSITopology(c.cfg.Labels.TopologyLabels, zone, region)
+		if err := c.checkDatastoreInZone(ctx, discoveryInfo.DataCenter, datastoreName, datastoreType, topology); err != nil {
+			return nil, nil, err
+		}
+		return discoveryInfo, topology, nil
 	}
-	publishInfo[AttributeFirstClassDiskPage83Data] = diskUUID
 
-	resp := &csi.ControllerPublishVolumeResponse{
-		PublishContext: publishInfo,
+	logging.Logger(ctx).V(2).Infoln("WhichVCandDCByZone with Topology Support")
+
+	var err error
+	var discoveryInfo *cm.ZoneDiscoveryInfo
+	for _, topology := range topologies {
+		reqZone, reqRegion := topologyZoneRegion(c.cfg.Labels.TopologyLabels, topology.GetSegments())
+		discoveryInfo, err = c.vsphere(ctx).WhichVCandDCByZone(ctx, c.cfg.Labels.Zone, c.cfg.Labels.Region, reqZone, reqRegion)
+		if err != nil {
+			continue
+		}
+		topology := toCSITopology(c.cfg.Labels.TopologyLabels, reqZone, reqRegion)
+		err = c.checkDatastoreInZone(ctx, discoveryInfo.DataCenter, datastoreName, datastoreType, topology)
+		if err == nil {
+			logging.Logger(ctx).V(2).Infof("WhichVCandDCByZone Succeeded in region=%s zone=%s", reqRegion, reqZone)
+			return discoveryInfo, topology, nil
+		}
+		if err != ErrDatastoreNotInZone {
+			return nil, nil, err
+		}
+		logging.Logger(ctx).V(2).Infof("No host in region=%s zone=%s mounts %s %s", reqRegion, reqZone, datastoreType, datastoreName)
 	}
 
-	return resp, nil
+	return nil, nil, err
 }
 
-func (c *controller) ControllerUnpublishVolume(
-	ctx context.Context,
-	req *csi.ControllerUnpublishVolumeRequest) (
-	*csi.ControllerUnpublishVolumeResponse, error) {
+// requestedTopologies returns the topologies a volume may be provisioned in,
+// in order of preference. The preferred topologies come first, as the
+// external-provisioner passes the topology of the node selected for a claim
+// of a WaitForFirstConsumer StorageClass first, followed by the requisite
+// ones. Without any topology nor legacy zone and region parameters, the
+// topology of the selected node, as found in its labels, is returned.
+func (c *controller) requestedTopologies(ctx context.Context, req *csi.CreateVolumeRequest) ([]*csi.Topology, error) {
+	accessibility := req.GetAccessibilityRequirements()
+	topologies := append([]*csi.Topology{}, accessibility.GetPreferred()...)
+	topologies = append(topologies, accessibility.GetRequisite()...)
+	if len(topologies) > 0 {
+		return topologies, nil
+	}
 
-	//check for required parameters
-	if len(req.VolumeId) == 0 {
-		msg := "Volume ID is a required parameter."
-		log.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
-	} else if len(req.NodeId) == 0 {
-		msg := "Node ID is a required parameter."
-		log.Error(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+	params := req.GetParameters()
+	nodeName := params[ParameterSelectedNode]
+	if len(nodeName) == 0 || len(params[AttributeFirstClassDiskZone]) > 0 || len(params[AttributeFirstClassDiskRegion]) > 0 {
+		return nil, nil
+	}
+	if c.nodeLister == nil {
+		logging.Logger(ctx).Warningf("The zone of selected node %s cannot be looked up without the Kubernetes client", nodeName)
+		return nil, nil
 	}
 
-	discoveryInfo, err := c.connMgr.WhichVCandDCByFCDId(ctx, req.VolumeId)
+	node, err := c.nodeLister.Get(nodeName)
 	if err != nil {
-		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", req.VolumeId, err)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
+		return nil, err
+	}
+	zone, region := topologyZoneRegion(c.cfg.Labels.TopologyLabels, node.Labels)
+	topology := toCSITopology(c.cfg.Labels.TopologyLabels, zone, region)
+	if topology == nil {
+		return nil, nil
 	}
+	logging.Logger(ctx).V(2).Infof("Using the topology of selected node %s: %v", nodeName, topology.Segments)
+	return []*csi.Topology{topology}, nil
+}
 
-	fcd := discoveryInfo.FCDInfo
+// zoneHosts returns the hosts of a datacenter that are in the zone and
+// region of a topology, as resolved from the tags attached to the hosts or
+// to their ancestors. Nil is returned when zones are not configured or no
+// topology was requested, so that every host is considered.
+func (c *controller) zoneHosts(ctx context.Context, dc *vclib.Datacenter,
+	topology *csi.Topology) ([]types.ManagedObjectReference, error) {
 
-	vm, err := discoveryInfo.DataCenter.GetVMByDNSName(ctx, req.NodeId)
-	if err != nil {
-		log.Errorf("GetVMByDNSName(%s) failed. Err: %v", req.NodeId, err)
-		return nil, err
+	if len(c.cfg.Labels.Zone) == 0 || len(c.cfg.Labels.Region) == 0 || topology == nil {
+		return nil, nil
 	}
 
-	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
-	err = vm.DetachDisk(ctx, filePath)
+	hostZones, err := c.connManager(ctx).LookupHostZones(ctx, dc, c.cfg.Labels.Zone, c.cfg.Labels.Region)
 	if err != nil {
-		log.Errorf("DetachDisk(%s = %s) failed. Err: %v", fcd.Config.Name, filePath, err)
 		return nil, err
 	}
 
-	resp := &csi.ControllerUnpublishVolumeResponse{}
-
-	return resp, nil
-}
-
-func (c *controller) ValidateVolumeCapabilities(
-	ctx context.Context,
-	req *csi.ValidateVolumeCapabilitiesRequest) (
-	*csi.ValidateVolumeCapabilitiesResponse, error) {
+	zone, region := topologyZoneRegion(c.cfg.Labels.TopologyLabels, topology.GetSegments())
+	hosts := make([]types.ManagedObjectReference, 0, len(hostZones))
+	for _, hostZone := range hostZones {
+		if len(zone) > 0 && !strings.EqualFold(hostZone.Zone, zone) {
+			continue
+		}
+		if len(region) > 0 && !strings.EqualFold(hostZone.Region, region) {
+			continue
+		}
+		hosts = append(hosts, hostZone.Host)
+	}
 
-	return &csi.ValidateVolumeCapabilitiesResponse{}, nil
+	if len(hosts) == 0 {
+		return nil, vclib.ErrNoZoneRegionFound
+	}
+	return hosts, nil
 }
 
-func (c *controller) ListVolumes(
-	ctx context.Context,
-	req *csi.ListVolumesRequest) (
-	*csi.ListVolumesResponse, error) {
-
-	var err error
-	firstClassDisks := getAllFCDs(ctx, c.connMgr)
+// datastoreTopologies returns the topologies of the zones of the hosts that
+// mount a datastore, or the datastores of a datastore cluster, which is
+// where a volume on it is accessible from. Nil is returned when zones are
+// not configured.
+func (c *controller) datastoreTopologies(ctx context.Context, dc *vclib.Datacenter,
+	datastoreName string, datastoreType vclib.ParentDatastoreType) ([]*csi.Topology, error) {
 
-	total := len(firstClassDisks)
+	if len(c.cfg.Labels.Zone) == 0 || len(c.cfg.Labels.Region) == 0 {
+		return nil, nil
+	}
 
-	start := 0
-	if req.StartingToken != "" {
-		start, err = strconv.Atoi(req.StartingToken)
+	var datastores []*vclib.DatastoreInfo
+	if datastoreType == vclib.TypeDatastoreCluster {
+		storagePod, err := dc.GetDatastoreClusterByName(ctx, datastoreName)
+		if err != nil {
+			return nil, err
+		}
+		if err := storagePod.PopulateChildDatastoreInfos(ctx, false); err != nil {
+			return nil, err
+		}
+		datastores = storagePod.DatastoreInfos
+	} else {
+		datastore, err := dc.GetDatastoreByName(ctx, datastoreName)
 		if err != nil {
-			msg := fmt.Sprintf("Invalid starting token %s. Err: %v", req.StartingToken, err)
-			log.Errorf(msg)
-			return nil, status.Errorf(codes.Internal, msg)
+			return nil, err
 		}
+		datastores = []*vclib.DatastoreInfo{datastore}
 	}
 
-	stop := total
-	if req.MaxEntries != 0 && stop > int(req.MaxEntries) {
-		stop = start + int(req.MaxEntries) - 1
+	mounted := make(map[types.ManagedObjectReference]bool)
+	for _, datastore := range datastores {
+		hosts, err := datastore.GetHosts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			mounted[host] = true
+		}
 	}
 
-	log.Infof("Start: %d, End: %d, Total: %d", start, stop, total)
+	hostZones, err := c.connManager(ctx).LookupHostZones(ctx, dc, c.cfg.Labels.Zone, c.cfg.Labels.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[cm.HostZone]bool)
+	var topologies []*csi.Topology
+	for _, hostZone := range hostZones {
+		if !mounted[hostZone.Host] {
+			continue
+		}
+		key := cm.HostZone{Zone: hostZone.Zone, Region: hostZone.Region}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		topologies = append(topologies, toCSITopology(c.cfg.Labels.TopologyLabels, hostZone.Zone, hostZone.Region))
+	}
+	return topologies, nil
+}
+
+// checkDatastoreInZone returns ErrDatastoreNotInZone when no host in the
+// zone and region of a topology mounts a datastore, or a datastore of a
+// datastore cluster, as a volume on it would not be accessible from the
+// zone. Nothing is checked without a datastore name or a topology, or when
+// zones are not configured.
+func (c *controller) checkDatastoreInZone(ctx context.Context, dc *vclib.Datacenter,
+	datastoreName string, datastoreType vclib.ParentDatastoreType, topology *csi.Topology) error {
+
+	if len(datastoreName) == 0 || topology == nil {
+		return nil
+	}
+	topologies, err := c.datastoreTopologies(ctx, dc, datastoreName, datastoreType)
+	if vclib.IsNotFound(err) {
+		// The datastore is in the datacenter of another zone
+		return ErrDatastoreNotInZone
+	} else if err != nil {
+		return err
+	}
+	if topologies != nil && !containsTopology(c.cfg.Labels.TopologyLabels, topologies, topology) {
+		return ErrDatastoreNotInZone
+	}
+	return nil
+}
+
+// diffExistingVolume compares an existing FCD with the parameters a volume
+// of the same name is requested with, and returns a description of each
+// parameter that differs. The parent is only compared when one was
+// requested, the storage policy when a profile ID is provided, the disk
+// format when one was requested and the zone when a topology was requested
+// and zones are configured.
+func (c *controller) diffExistingVolume(ctx context.Context, dc *vclib.Datacenter,
+	fcd *vclib.FirstClassDiskInfo, parentName string, parentType vclib.ParentDatastoreType,
+	volSizeMB int64, profileID string, diskFormat string, topology *csi.Topology) ([]string, error) {
+
+	var diffs []string
+	existingName, existingType := getParentDatastore(fcd)
+	if existingType != parentType {
+		diffs = append(diffs, fmt.Sprintf("%s: existing %s != requested %s",
+			AttributeFirstClassDiskParentType, existingType, parentType))
+	}
+	if len(parentName) > 0 && existingName != parentName {
+		diffs = append(diffs, fmt.Sprintf("%s: existing %s != requested %s",
+			AttributeFirstClassDiskParentName, existingName, parentName))
+	}
+	if fcd.Config.CapacityInMB != volSizeMB {
+		diffs = append(diffs, fmt.Sprintf("size: existing %d MB != requested %d MB",
+			fcd.Config.CapacityInMB, volSizeMB))
+	}
+	if existingFormat := vclib.FirstClassDiskFormat(fcd); len(diskFormat) > 0 && existingFormat != diskFormat {
+		diffs = append(diffs, fmt.Sprintf("%s: existing %s != requested %s",
+			AttributeFirstClassDiskFormat, existingFormat, diskFormat))
+	}
+
+	if len(profileID) > 0 {
+		pbmClient, err := vclib.NewPbmClient(ctx, dc.Client())
+		if err != nil {
+			return nil, err
+		}
+		existingID, err := pbmClient.GetFirstClassDiskProfileID(ctx, fcd.Config.Id.Id)
+		if err != nil {
+			return nil, err
+		}
+		if existingID != profileID {
+			diffs = append(diffs, fmt.Sprintf("%s: existing profile %q != requested profile %q",
+				AttributeFirstClassDiskStoragePolicyName, existingID, profileID))
+		}
+	}
+
+	if topology != nil {
+		topologies, err := c.datastoreTopologies(ctx, dc, existingName, existingType)
+		if err != nil {
+			return nil, err
+		}
+		if topologies != nil && !containsTopology(c.cfg.Labels.TopologyLabels, topologies, topology) {
+			var zones []string
+			for _, t := range topologies {
+				zones = append(zones, fmt.Sprintf("%v", t.GetSegments()))
+			}
+			diffs = append(diffs, fmt.Sprintf("zone: existing %s != requested %v",
+				strings.Join(zones, ", "), topology.GetSegments()))
+		}
+	}
+
+	return diffs, nil
+}
+
+// selectDatastore returns the name of the datastore, or datastore cluster,
+// with the most free space in a datacenter, among the ones allowed by the
+// datastore allow and deny lists. When hosts is not empty, only the ones
+// mounted by the hosts are considered.
+func (c *controller) selectDatastore(ctx context.Context, dc *vclib.Datacenter,
+	datastoreType vclib.ParentDatastoreType, volSizeBytes int64,
+	hosts []types.ManagedObjectReference) (string, error) {
+
+	minFreeSpace := int64(c.cfg.Global.DatastoreMinFreeSpaceMB) * MbInBytes
+	if volSizeBytes > minFreeSpace {
+		minFreeSpace = volSizeBytes
+	}
+
+	if datastoreType == vclib.TypeDatastoreCluster {
+		storagePod, err := dc.GetDatastoreClusterWithMostFreeSpace(ctx, minFreeSpace, hosts, c.datastoreFilter().Allows)
+		if err != nil {
+			return "", err
+		}
+		return storagePod.Summary.Name, nil
+	}
+
+	datastore, err := dc.GetSharedDatastoreWithMostFreeSpace(ctx, minFreeSpace, hosts, c.datastoreFilter().Allows)
+	if err != nil {
+		return "", err
+	}
+	return datastore.Info.Name, nil
+}
+
+// datacenterByName returns the configured datacenter named by the
+// datacenter volume parameter, in the vCenter it is configured for. The
+// volume is provisioned in it without looking up the zones, so the name
+// must match a datacenter of a single vCenter. Status errors are returned.
+func (c *controller) datacenterByName(ctx context.Context, name string) (*cm.ZoneDiscoveryInfo, error) {
+	logger := logging.Logger(ctx)
+
+	vcs := make([]string, 0, len(c.connManager(ctx).VsphereInstances()))
+	for vc := range c.connManager(ctx).VsphereInstances() {
+		vcs = append(vcs, vc)
+	}
+	sort.Strings(vcs)
+
+	var discoveryInfo *cm.ZoneDiscoveryInfo
+	var found []string
+	for _, vc := range vcs {
+		datacenters, err := c.connManager(ctx).ListDatacenters(ctx, vc)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to list the datacenters of vCenter %s. Err: %v", vc, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		for _, dc := range datacenters {
+			if dc.Name() == name || dc.InventoryPath == name {
+				discoveryInfo = &cm.ZoneDiscoveryInfo{VcServer: vc, DataCenter: dc}
+				found = append(found, vc)
+				break
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		msg := fmt.Sprintf("Volume parameter %s %s is not a configured datacenter.",
+			AttributeFirstClassDiskDatacenter, name)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if len(found) > 1 {
+		msg := fmt.Sprintf("Volume parameter %s %s is a datacenter of several vCenters: %s.",
+			AttributeFirstClassDiskDatacenter, name, strings.Join(found, ", "))
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	return discoveryInfo, nil
+}
+
+// selectDatacenter returns the first configured datacenter of the vCenter
+// that can hold a volume, along with the datastore, or datastore cluster, to
+// create the volume on. It is used when the vCenter has several datacenters
+// and no zone was requested. A datacenter that already has a volume with
+// the name is returned first, so that a retried request finds it. A
+// requested datastore must be in the datacenter, otherwise the one with the
+// most free space is selected.
+func (c *controller) selectDatacenter(ctx context.Context, datastoreName string,
+	datastoreType vclib.ParentDatastoreType, volName string, volSizeBytes int64) (*cm.ZoneDiscoveryInfo, string, error) {
+
+	// There is only one vCenter when zones are not required
+	var vc string
+	for vc = range c.connManager(ctx).VsphereInstances() {
+		break
+	}
+
+	datacenters, err := c.connManager(ctx).ListDatacenters(ctx, vc)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, dc := range datacenters {
+		firstClassDisk, err := findFirstClassDiskByName(ctx, c.connManager(ctx), vc, dc, volName)
+		if err != nil {
+			return nil, "", err
+		}
+		if firstClassDisk != nil {
+			name, _ := getParentDatastore(firstClassDisk)
+			return &cm.ZoneDiscoveryInfo{VcServer: vc, DataCenter: dc}, name, nil
+		}
+	}
+
+	for _, dc := range datacenters {
+		name := datastoreName
+		if len(name) == 0 {
+			name, err = c.selectDatastore(ctx, dc, datastoreType, volSizeBytes, nil)
+		} else if datastoreType == vclib.TypeDatastoreCluster {
+			_, err = dc.GetDatastoreClusterByName(ctx, name)
+		} else {
+			_, err = dc.GetDatastoreByName(ctx, name)
+		}
+		if err == nil {
+			return &cm.ZoneDiscoveryInfo{VcServer: vc, DataCenter: dc}, name, nil
+		}
+		logging.Logger(ctx).V(2).Infof("Datacenter %s cannot hold volume %s. Err: %v", dc.Name(), volName, err)
+	}
+
+	if datastoreType == vclib.TypeDatastoreCluster {
+		return nil, "", vclib.ErrNoDataStoreClustersFound
+	}
+	return nil, "", vclib.ErrNoDatastoreFound
+}
+
+// getNodeVM returns the VM of a node. A node ID that is a UUID, as reported
+// by NodeGetInfo, is matched against the BIOS and instance UUIDs of the VMs
+// in every datacenter of the vCenter. Otherwise, or when no VM has the UUID,
+// the VM is looked up by its DNS name in the datacenter for backward
+// compatibility with nodes registered by hostname. A node that is not in
+// the datacenter is searched for across all of the vCenters.
+func (c *controller) getNodeVM(ctx context.Context, vcServer string,
+	dc *vclib.Datacenter, nodeID string) (*vclib.VirtualMachine, error) {
+
+	// The cached VMs are bound to the sessions of the configured
+	// credentials, not to the ones of the credentials of a request
+	if c.connManager(ctx) != c.connMgr {
+		return c.findNodeVM(ctx, vcServer, dc, nodeID)
+	}
+
+	if vm := c.nodeVMs.get(nodeID); vm != nil {
+		return vm, nil
+	}
+	vm, err := c.findNodeVM(ctx, vcServer, dc, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	c.nodeVMs.set(nodeID, vm)
+	return vm, nil
+}
+
+// withNodeVM calls fn with the VM of a node and returns the VM it last
+// called fn with. A VM removed from the inventory and registered again, or
+// recovered by vCenter HA, keeps its UUID but gets a new managed object
+// reference, on which the cached VM fails with ManagedObjectNotFound. The
+// VM is then looked up again, which replaces the cached VM, and fn is
+// retried once with the VM found.
+func (c *controller) withNodeVM(ctx context.Context, vcServer string,
+	dc *vclib.Datacenter, nodeID string, vm *vclib.VirtualMachine,
+	fn func(vm *vclib.VirtualMachine) error) (*vclib.VirtualMachine, error) {
+
+	err := fn(vm)
+	if !vclib.IsManagedObjectNotFoundError(err) {
+		return vm, err
+	}
+	logging.Logger(ctx).Warningf("VM %s of node %s no longer exists, looking it up again. Err: %v",
+		vm.Reference().Value, nodeID, err)
+
+	c.nodeVMs.remove(nodeID)
+	found, err := c.getNodeVM(ctx, vcServer, dc, nodeID)
+	if err != nil {
+		return vm, err
+	}
+	logging.Logger(ctx).V(2).Infof("Found node %s as VM %s", nodeID, found.Reference().Value)
+	return found, fn(found)
+}
+
+// findNodeVM searches the vCenters for the VM of a node. See getNodeVM.
+func (c *controller) findNodeVM(ctx context.Context, vcServer string,
+	dc *vclib.Datacenter, nodeID string) (*vclib.VirtualMachine, error) {
+
+	if vsi, ok := c.connManager(ctx).VsphereInstances()[vcServer]; ok && isUUID(nodeID) {
+		datacenters, err := vclib.GetAllDatacenter(ctx, vsi.Conn)
+		if err != nil {
+			return nil, err
+		}
+		for _, datacenter := range datacenters {
+			vm, err := datacenter.GetVMByUUID(ctx, nodeID)
+			if err == vclib.ErrNoVMFound {
+				vm, err = datacenter.GetVMByInstanceUUID(ctx, nodeID)
+			}
+			if err == vclib.ErrNoVMFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			return vm, nil
+		}
+		logging.Logger(ctx).Warningf("No VM found with UUID %s, looking up node by DNS name", nodeID)
+	}
+
+	vm, err := c.datacenterOps(dc).GetVMByDNSName(ctx, nodeID)
+	if err == vclib.ErrNoVMFound {
+		// the node may be registered in a different datacenter or vCenter
+		// than the volume, such as with stretched shared storage
+		searchBy := cm.FindVMByName
+		if isUUID(nodeID) {
+			searchBy = cm.FindVMByUUID
+		}
+		vmDI, err := c.vsphere(ctx).WhichVCandDCByNodeID(ctx, nodeID, searchBy)
+		if err != nil {
+			return nil, err
+		}
+		logging.Logger(ctx).V(2).Infof("Found node %s in vc=%s and datacenter=%s", nodeID, vmDI.VcServer, vmDI.DataCenter.Name())
+		vm = vmDI.VM
+	} else if err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// recordAttachedVolumes updates the attached volumes metric of a node. A
+// failure to count the volumes only leaves the metric stale.
+func recordAttachedVolumes(ctx context.Context, nodeID string, vm *vclib.VirtualMachine) {
+	fcds, _, err := vm.GetSCSIDiskCounts(ctx)
+	if err != nil {
+		logging.Logger(ctx).Warningf("GetSCSIDiskCounts(%s) failed. Err: %v", nodeID, err)
+		return
+	}
+	metrics.SetAttachedVolumes(nodeID, fcds)
+}
+
+func (c *controller) CreateVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest) (
+	*csi.CreateVolumeResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Get create params
+	params := req.GetParameters()
+
+	// Volume Name
+	volName := req.GetName()
+
+	//check for required parameters
+	if params == nil {
+		msg := "Create parameters is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if len(volName) == 0 {
+		msg := "Volume name is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if fs := params[AttributeFilesystem]; len(fs) > 0 && fs != FilesystemVsanFile {
+		msg := fmt.Sprintf("Volume parameter %s must be %s.", AttributeFilesystem, FilesystemVsanFile)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if len(params[AttributeFirstClassDiskParentType]) == 0 && params[AttributeFilesystem] != FilesystemVsanFile {
+		msg := fmt.Sprintf("Volume parameter %s is a required parameter.", AttributeFirstClassDiskParentType)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	// The FCD is named after the sanitized name, which is also what a
+	// retried request looks the volume up by
+	if sanitized := sanitizeVolumeName(volName); sanitized != volName {
+		logger.V(2).Infof("Volume %s is named %s in vSphere", volName, sanitized)
+		volName = sanitized
+	}
+
+	ctx, ok := c.volumeLocks.tryAcquire(ctx, volName)
+	if !ok {
+		msg := fmt.Sprintf("An operation for volume %s is already in progress", volName)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+	defer c.volumeLocks.release(volName)
+
+	if params[AttributeFilesystem] == FilesystemVsanFile {
+		return c.createFileVolume(ctx, req, volName)
+	}
+
+	// Multi-attach volumes are attached to several nodes at once
+	multiAttach := false
+	if value := params[AttributeFirstClassDiskMultiAttach]; len(value) > 0 {
+		if multiAttach, err = strconv.ParseBool(value); err != nil {
+			msg := fmt.Sprintf("Volume parameter %s must be true or false.", AttributeFirstClassDiskMultiAttach)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+	}
+
+	// Volume Capabilities
+	accessType := AccessTypeMount
+	if volCaps := req.GetVolumeCapabilities(); len(volCaps) > 0 {
+		if err := validateVolumeCapabilities(volCaps, multiAttach); err != nil {
+			msg := fmt.Sprintf("Volume capabilities are not supported. Err: %v", err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		accessType, _ = getAccessType(volCaps)
+	}
+
+	// Volume Size - A clone without a requested size gets the size of its
+	// source, which is checked once known
+	volSizeBytes, sizeRequested, err := c.requestedVolumeSize(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// The FCDs are allocated in whole GiB
+	volSizeMB := volumeutil.RoundUpSize(volSizeBytes, GbInBytes) * (GbInBytes / MbInBytes)
+	if sizeRequested || req.GetVolumeContentSource() == nil {
+		if err := c.checkVolumeSize(ctx, volSizeMB, req.GetCapacityRange()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Volume Type
+	datastoreType := vclib.TypeDatastoreCluster
+	volType := params[AttributeFirstClassDiskParentType]
+	if volType == string(vclib.TypeDatastore) {
+		datastoreType = vclib.TypeDatastore
+	}
+
+	datastoreName := params[AttributeFirstClassDiskParentName]
+	requestedParent := datastoreName
+	zone := params[AttributeFirstClassDiskZone]
+	region := params[AttributeFirstClassDiskRegion]
+	policyName := params[AttributeFirstClassDiskStoragePolicyName]
+
+	// Disk Format
+	diskFormat := params[AttributeFirstClassDiskFormat]
+	if len(diskFormat) > 0 && !vclib.CheckDiskFormatSupported(diskFormat) {
+		msg := fmt.Sprintf("Volume parameter %s must be one of %s.", AttributeFirstClassDiskFormat, vclib.DiskformatValidOptions())
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	// Disk Mode
+	diskMode := params[AttributeFirstClassDiskMode]
+	if len(diskMode) > 0 && !vclib.CheckDiskModeSupported(diskMode) {
+		msg := fmt.Sprintf("Volume parameter %s must be one of %q.", AttributeFirstClassDiskMode, vclib.DiskModeValidType)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	diskSharing := params[AttributeFirstClassDiskSharing]
+	if len(diskSharing) > 0 && !vclib.CheckDiskSharingSupported(diskSharing) {
+		msg := fmt.Sprintf("Volume parameter %s must be one of %q.", AttributeFirstClassDiskSharing, vclib.DiskSharingValidType)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	// The disks written by several VMs at once must be eager zeroed and
+	// shared with multi-writer
+	if multiAttach {
+		eagerZeroedThick := strings.ToLower(vclib.EagerZeroedThickDiskType)
+		multiWriter := string(types.VirtualDiskSharingSharingMultiWriter)
+		if len(diskFormat) > 0 && strings.ToLower(diskFormat) != eagerZeroedThick {
+			msg := fmt.Sprintf("Volume parameter %s must be %s when %s is true.",
+				AttributeFirstClassDiskFormat, eagerZeroedThick, AttributeFirstClassDiskMultiAttach)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		if len(diskSharing) > 0 && diskSharing != multiWriter {
+			msg := fmt.Sprintf("Volume parameter %s must be %s when %s is true.",
+				AttributeFirstClassDiskSharing, multiWriter, AttributeFirstClassDiskMultiAttach)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		diskFormat = eagerZeroedThick
+		diskSharing = multiWriter
+	}
+
+	// Encryption, with the VM Encryption policy unless a policy is named.
+	// The encryption policy is the storage policy of the FCD.
+	encryptionPolicyName := params[AttributeFirstClassDiskEncryptionPolicyName]
+	if encryption := params[AttributeFirstClassDiskEncryption]; len(encryption) > 0 {
+		encrypted, err := strconv.ParseBool(encryption)
+		if err != nil {
+			msg := fmt.Sprintf("Volume parameter %s must be true or false.", AttributeFirstClassDiskEncryption)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		if !encrypted && len(encryptionPolicyName) > 0 {
+			msg := fmt.Sprintf("Volume parameter %s may not be set when %s is false.",
+				AttributeFirstClassDiskEncryptionPolicyName, AttributeFirstClassDiskEncryption)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		if encrypted && len(encryptionPolicyName) == 0 {
+			encryptionPolicyName = vclib.DefaultEncryptionPolicyName
+		}
+	}
+	if len(encryptionPolicyName) > 0 {
+		if len(policyName) > 0 {
+			msg := fmt.Sprintf("Volume parameter %s may not be combined with encryption, name an encryption policy with the storage requirements in %s instead.",
+				AttributeFirstClassDiskStoragePolicyName, AttributeFirstClassDiskEncryptionPolicyName)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		policyName = encryptionPolicyName
+	}
+
+	// A volume pinned to a datacenter is provisioned in it, in whatever
+	// zone its datastore is in
+	var discoveryInfo *cm.ZoneDiscoveryInfo
+	var topology *csi.Topology
+	pinnedDatacenter := params[AttributeFirstClassDiskDatacenter]
+	if len(pinnedDatacenter) > 0 {
+		if len(zone) > 0 || len(region) > 0 {
+			msg := fmt.Sprintf("Volume parameter %s may not be combined with %s or %s.",
+				AttributeFirstClassDiskDatacenter, AttributeFirstClassDiskZone, AttributeFirstClassDiskRegion)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		if discoveryInfo, err = c.datacenterByName(ctx, pinnedDatacenter); err != nil {
+			return nil, err
+		}
+		logger.V(2).Infof("Volume %s is pinned to datacenter %s of vCenter %s",
+			volName, discoveryInfo.DataCenter.Name(), discoveryInfo.VcServer)
+	} else {
+		// Please see function for more details
+		topologies, err := c.requestedTopologies(ctx, req)
+		if apierrors.IsNotFound(err) {
+			msg := fmt.Sprintf("Selected node %s not found", params[ParameterSelectedNode])
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		} else if err != nil {
+			msg := fmt.Sprintf("Failed to retrieve the topology of selected node %s. Err: %v", params[ParameterSelectedNode], err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+
+		discoveryInfo, topology, err = c.whichVCandDCByTopology(ctx, topologies, zone, region,
+			datastoreName, datastoreType)
+		if err == cm.ErrMultiDCRequiresZones {
+			// No zone to pick one of the datacenters of the vCenter by
+			var selectedName string
+			discoveryInfo, selectedName, err = c.selectDatacenter(
+				ctx, datastoreName, datastoreType, volName, volSizeMB*MbInBytes)
+			if (err == vclib.ErrNoDatastoreFound || err == vclib.ErrNoDataStoreClustersFound) && len(datastoreName) > 0 {
+				msg := fmt.Sprintf("No datacenter has the %s %s", datastoreType, datastoreName)
+				logger.Errorf(msg)
+				return nil, status.Errorf(codes.InvalidArgument, msg)
+			} else if err == vclib.ErrNoDatastoreFound || err == vclib.ErrNoDataStoreClustersFound {
+				msg := fmt.Sprintf("No datacenter has a %s with enough free space for volume %s. Err: %v",
+					datastoreType, volName, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(codes.ResourceExhausted, msg)
+			} else if err != nil {
+				msg := fmt.Sprintf("Failed to select a datacenter for volume %s. Err: %v", volName, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(errorCode(err), msg)
+			}
+			datastoreName = selectedName
+			logger.V(2).Infof("Selected datacenter %s and %s %s for volume %s",
+				discoveryInfo.DataCenter.Name(), datastoreType, datastoreName, volName)
+		} else if err == ErrDatastoreNotInZone {
+			msg := fmt.Sprintf("No host of the requested zones mounts %s %s", datastoreType, datastoreName)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		} else if err == vclib.ErrNoZoneRegionFound {
+			msg := fmt.Sprintf("No vCenter/Datacenter found in zone %s region %s", zone, region)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		} else if err != nil {
+			msg := fmt.Sprintf("Failed to retrieve VC/DC based on zone %s. Err: %v", zone, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+	}
+
+	// The keys of the encrypted FCDs are provided by a KMS of vCenter
+	if len(encryptionPolicyName) > 0 {
+		hasKms, err := vclib.HasKmsCluster(ctx, discoveryInfo.DataCenter.Client())
+		if err != nil {
+			msg := fmt.Sprintf("Failed to retrieve the KMS clusters of vCenter %s. Err: %v", discoveryInfo.VcServer, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		if !hasKms {
+			msg := fmt.Sprintf("Volume %s cannot be encrypted, as no KMS cluster is configured in vCenter %s. "+
+				"Add a KMS cluster to vCenter, or remove the %s and %s parameters from the StorageClass.",
+				volName, discoveryInfo.VcServer, AttributeFirstClassDiskEncryption, AttributeFirstClassDiskEncryptionPolicyName)
+			logger.Error(msg)
+			return nil, status.Errorf(codes.FailedPrecondition, msg)
+		}
+	}
+
+	// Volume Content Source
+	var sourceInfo *cm.FcdDiscoveryInfo
+	var sourceSnapshotID string
+	if req.GetVolumeContentSource() != nil {
+		sourceInfo, sourceSnapshotID, err = getContentSource(ctx, c.connManager(ctx), req.GetVolumeContentSource())
+		if err != nil {
+			return nil, err
+		}
+
+		if sourceInfo.VcServer != discoveryInfo.VcServer {
+			msg := fmt.Sprintf("Cloning volume %s from vCenter %s to vCenter %s is not supported",
+				sourceInfo.FCDInfo.Config.Id.Id, sourceInfo.VcServer, discoveryInfo.VcServer)
+			logger.Error(msg)
+			return nil, status.Errorf(codes.Unimplemented, msg)
+		}
+		if len(sourceSnapshotID) > 0 {
+			err := c.requireCapability(ctx, sourceInfo.VcServer, vclib.CapabilityFCDSnapshots, "Restoring a snapshot")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		sourceSizeMB := sourceInfo.FCDInfo.Config.CapacityInMB
+		if !sizeRequested {
+			volSizeMB = sourceSizeMB
+			if err := c.checkVolumeSize(ctx, volSizeMB, req.GetCapacityRange()); err != nil {
+				return nil, err
+			}
+		} else if volSizeMB < sourceSizeMB {
+			msg := fmt.Sprintf("Requested size %d MB is smaller than the source size %d MB", volSizeMB, sourceSizeMB)
+			logger.Error(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+
+		// A disk created from a snapshot is placed next to its source. The
+		// discovery info may be shared, so it is copied rather than changed.
+		if len(sourceSnapshotID) > 0 {
+			datastoreName, datastoreType = getParentDatastore(sourceInfo.FCDInfo)
+			requestedParent = datastoreName
+			discoveryInfo = &cm.ZoneDiscoveryInfo{
+				VcServer:   discoveryInfo.VcServer,
+				DataCenter: sourceInfo.DataCenter,
+			}
+		}
+	}
+
+	// Storage Policy
+	var profileID string
+	if len(policyName) > 0 {
+		pbmClient, err := vclib.NewPbmClient(ctx, discoveryInfo.DataCenter.Client())
+		if err != nil {
+			msg := fmt.Sprintf("NewPbmClient failed. Err: %v", err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		profileID, err = pbmClient.ProfileIDByName(ctx, policyName)
+		if err != nil {
+			msg := fmt.Sprintf("Storage policy %s not found. Err: %v", policyName, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+	}
+
+	// A create that outlived a previous request is waited for rather than
+	// started again
+	pendingKey := discoveryInfo.VcServer + "/" + volName
+	if task, ok := c.pendingCreates.get(pendingKey); ok {
+		err = discoveryInfo.DataCenter.WaitForTask(ctx, task)
+		if _, ok := err.(*vclib.TaskInProgressError); ok {
+			msg := withTaskProgress(discoveryInfo.DataCenter, task,
+				fmt.Sprintf("Creation of volume %s is still in progress", volName))
+			logger.Warning(msg)
+			return nil, status.Errorf(codes.DeadlineExceeded, msg)
+		}
+		c.pendingCreates.remove(pendingKey)
+		if err != nil {
+			logger.Warningf("Previous creation of volume %s failed, creating it again. Err: %v", volName, err)
+		}
+	}
+
+	// A retried request gets the existing volume back, as long as it was
+	// provisioned with the same parameters
+	contentSource := req.GetVolumeContentSource()
+	firstClassDisk, err := findFirstClassDiskByName(ctx, c.connManager(ctx), discoveryInfo.VcServer,
+		discoveryInfo.DataCenter, volName)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to search for volume %s. Err: %v", volName, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if firstClassDisk != nil {
+		logger.Warningf("Volume with name %s already exists. Checking for similar parameters.", volName)
+
+		ctx, err = c.lockVolumeID(ctx, firstClassDisk.Config.Id.Id)
+		if err != nil {
+			return nil, err
+		}
+		defer c.volumeLocks.release(firstClassDisk.Config.Id.Id)
+
+		diffs, err := c.diffExistingVolume(ctx, discoveryInfo.DataCenter, firstClassDisk,
+			requestedParent, datastoreType, volSizeMB, profileID, diskFormat, topology)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to compare existing volume %s. Err: %v", volName, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+
+		// The existing volume is returned with the content source it was
+		// created from, which must be the requested one when both are known
+		source, err := c.volumeContentSource(ctx, discoveryInfo.DataCenter, firstClassDisk.Config.Id.Id)
+		if err != nil {
+			logger.Warningf("Failed to retrieve the content source of volume %s. Err: %v", volName, err)
+		} else if source != nil && contentSource != nil && contentSourceID(source) != contentSourceID(contentSource) {
+			diffs = append(diffs, fmt.Sprintf("content source: existing %s != requested %s",
+				contentSourceID(source), contentSourceID(contentSource)))
+		}
+		if len(diffs) > 0 {
+			msg := fmt.Sprintf("Volume %s already exists with different parameters: %s",
+				volName, strings.Join(diffs, "; "))
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.AlreadyExists, msg)
+		}
+		if source != nil {
+			contentSource = source
+		}
+		datastoreName, datastoreType = getParentDatastore(firstClassDisk)
+	} else {
+		// Pick the datastore with the most free space when none was
+		// requested, among the ones mounted by the hosts of the requested zone
+		if len(datastoreName) == 0 {
+			hosts, err := c.zoneHosts(ctx, discoveryInfo.DataCenter, topology)
+			if err == vclib.ErrNoZoneRegionFound {
+				msg := fmt.Sprintf("No host found in zone %s region %s", zone, region)
+				logger.Errorf(msg)
+				return nil, status.Errorf(codes.InvalidArgument, msg)
+			} else if err != nil {
+				msg := fmt.Sprintf("Failed to retrieve the hosts in zone %s. Err: %v", zone, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(errorCode(err), msg)
+			}
+
+			datastoreName, err = c.selectDatastore(ctx, discoveryInfo.DataCenter, datastoreType, volSizeMB*MbInBytes, hosts)
+			if err == vclib.ErrNoDatastoreFound || err == vclib.ErrNoDataStoreClustersFound {
+				msg := fmt.Sprintf("No %s with enough free space for volume %s. Err: %v", datastoreType, volName, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(codes.ResourceExhausted, msg)
+			} else if err != nil {
+				msg := fmt.Sprintf("Failed to select a %s for volume %s. Err: %v", datastoreType, volName, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(errorCode(err), msg)
+			}
+			logger.V(2).Infof("Selected %s %s for volume %s", datastoreType, datastoreName, volName)
+		} else if err := c.checkDatastoreAllowed(ctx, datastoreType, datastoreName); err != nil {
+			return nil, err
+		}
+
+		// A disk created from a snapshot is placed next to its source, which
+		// must be accessible from the requested zone
+		if len(sourceSnapshotID) > 0 {
+			err := c.checkDatastoreInZone(ctx, discoveryInfo.DataCenter, datastoreName, datastoreType, topology)
+			if err == ErrDatastoreNotInZone {
+				msg := fmt.Sprintf("Volume %s cannot be restored on %s %s, which is not mounted in zone %v",
+					volName, datastoreType, datastoreName, topology.GetSegments())
+				logger.Errorf(msg)
+				return nil, status.Errorf(codes.InvalidArgument, msg)
+			} else if err != nil {
+				msg := fmt.Sprintf("Failed to retrieve the zones of %s %s. Err: %v", datastoreType, datastoreName, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(errorCode(err), msg)
+			}
+		}
+
+		// The creates of a namespace wait for each other until the FCD is
+		// tagged, so that they count each other against its limit
+		namespace := params[ParameterPVCNamespace]
+		var unlockNamespace func()
+		unlockNamespace, err = c.checkNamespaceQuota(ctx, namespace, volSizeMB)
+		if err != nil {
+			return nil, err
+		}
+		defer unlockNamespace()
+
+		// A create that failed after vSphere may have carried it out is
+		// only attempted again when no FCD has the name
+		exists := func() (bool, error) {
+			_, err := c.datacenterOps(discoveryInfo.DataCenter).GetFirstClassDisk(
+				ctx, datastoreName, datastoreType, volName, vclib.FindFCDByName)
+			if err == vclib.ErrNoDiskIDFound {
+				return false, nil
+			}
+			return err == nil, err
+		}
+		err = retryCreate(ctx, exists, func() error {
+			if sourceInfo == nil {
+				return c.datacenterOps(discoveryInfo.DataCenter).CreateFirstClassDiskWithOptions(
+					ctx, datastoreName, datastoreType, volName, volSizeMB, &vclib.VolumeOptions{
+						StoragePolicyID: profileID,
+						DiskFormat:      diskFormat,
+					})
+			} else if len(sourceSnapshotID) > 0 {
+				srcDatastoreName, srcDatastoreType := getParentDatastore(sourceInfo.FCDInfo)
+				return discoveryInfo.DataCenter.CreateFirstClassDiskFromSnapshot(ctx, srcDatastoreName, srcDatastoreType,
+					sourceInfo.FCDInfo.Config.Id.Id, sourceSnapshotID, volName)
+			}
+			srcDatastoreName, srcDatastoreType := getParentDatastore(sourceInfo.FCDInfo)
+			return discoveryInfo.DataCenter.CloneFirstClassDisk(ctx, srcDatastoreName, srcDatastoreType,
+				sourceInfo.FCDInfo.Config.Id.Id, datastoreName, datastoreType, volName, volSizeMB)
+		})
+		if inProgress, ok := err.(*vclib.TaskInProgressError); ok {
+			// The disk is not orphaned: a retry waits for the same task
+			c.pendingCreates.set(pendingKey, discoveryInfo.VcServer, inProgress.Task)
+			msg := withTaskProgress(discoveryInfo.DataCenter, inProgress.Task,
+				fmt.Sprintf("Creation of volume %s is still in progress", volName))
+			logger.Warning(msg)
+			return nil, status.Errorf(codes.DeadlineExceeded, msg)
+		} else if err != nil {
+			msg := fmt.Sprintf("CreateFirstClassDisk failed. Err: %v", err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+
+		firstClassDisk, err = c.datacenterOps(discoveryInfo.DataCenter).GetFirstClassDisk(
+			ctx, datastoreName, datastoreType, volName, vclib.FindFCDByName)
+		if err != nil {
+			msg := fmt.Sprintf("GetFirstClassDiskByName(%s) failed. Err: %v", volName, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+
+		ctx, err = c.lockVolumeID(ctx, firstClassDisk.Config.Id.Id)
+		if err != nil {
+			return nil, err
+		}
+		defer c.volumeLocks.release(firstClassDisk.Config.Id.Id)
+
+		// An FCD that fails to be tagged is never considered orphaned
+		if err := c.tagOwnedVolume(ctx, discoveryInfo, firstClassDisk.Config.Id.Id); err != nil {
+			logger.Warningf("Failed to tag volume %s as owned by cluster %s. Err: %v",
+				volName, c.cfg.Global.ClusterID, err)
+		}
+
+		// An FCD that fails to be tagged does not count against the limit
+		// of its namespace
+		if err := c.tagVolumeNamespace(ctx, discoveryInfo, firstClassDisk.Config.Id.Id, namespace); err != nil {
+			logger.Warningf("Failed to tag volume %s with namespace %s. Err: %v", volName, namespace, err)
+		}
+
+		// A volume whose source fails to be recorded is listed without it
+		if err := c.tagContentSource(ctx, discoveryInfo, firstClassDisk.Config.Id.Id, contentSource); err != nil {
+			logger.Warningf("Failed to record the content source of volume %s. Err: %v", volName, err)
+		}
+
+		// A disk created from a content source inherits the source size
+		if firstClassDisk.Config.CapacityInMB < volSizeMB {
+			err = retryTransient(ctx, func() error {
+				return discoveryInfo.DataCenter.ExtendFirstClassDisk(
+					ctx, datastoreName, datastoreType, firstClassDisk.Config.Id.Id, volSizeMB)
+			})
+			if err != nil {
+				msg := fmt.Sprintf("ExtendFirstClassDisk(%s) failed. Err: %v", volName, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(errorCode(err), msg)
+			}
+			firstClassDisk.Config.CapacityInMB = volSizeMB
+		}
+
+		// The requested policy replaces the one of the content source
+		if sourceInfo != nil && len(profileID) > 0 {
+			err = retryTransient(ctx, func() error {
+				return discoveryInfo.DataCenter.UpdateFirstClassDiskPolicy(
+					ctx, datastoreName, datastoreType, firstClassDisk.Config.Id.Id, profileID)
+			})
+			if err != nil {
+				msg := fmt.Sprintf("UpdateFirstClassDiskPolicy(%s) failed. Err: %v", volName, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(errorCode(err), msg)
+			}
+		}
+	}
+
+	logger.V(4).Infof("FCD %s: %+v", volName, firstClassDisk.Config)
+	c.vsphere(ctx).IndexFirstClassDisk(discoveryInfo.VcServer, firstClassDisk)
+	c.cacheFCD(firstClassDisk)
+	if len(pinnedDatacenter) > 0 {
+		c.vsphere(ctx).PinFirstClassDiskDatacenter(firstClassDisk.Config.Id.Id, discoveryInfo.VcServer, pinnedDatacenter)
+	}
+
+	// A volume that fails to be registered is registered by the next sync
+	if err := c.registerCnsVolume(ctx, discoveryInfo.VcServer, firstClassDisk, req.GetName()); err != nil {
+		logger.Warningf("Failed to register volume %s with CNS. Err: %v", volName, err)
+	}
+
+	attributes := make(map[string]string)
+	attributes[AttributeFirstClassDiskType] = FirstClassDiskTypeString
+	attributes[AttributeFirstClassDiskVcenter] = discoveryInfo.VcServer
+	attributes[AttributeFirstClassDiskDatacenter] = discoveryInfo.DataCenter.Name()
+	attributes[AttributeFirstClassDiskName] = firstClassDisk.Config.Name
+	if req.GetName() != firstClassDisk.Config.Name {
+		attributes[AttributeFirstClassDiskRequestedName] = req.GetName()
+	}
+	attributes[AttributeFirstClassDiskParentType] = string(firstClassDisk.ParentType)
+	if firstClassDisk.ParentType == vclib.TypeDatastoreCluster {
+		attributes[AttributeFirstClassDiskParentName] = firstClassDisk.StoragePodInfo.Summary.Name
+		attributes[AttributeFirstClassDiskOwningDatastore] = firstClassDisk.DatastoreInfo.Info.Name
+	} else {
+		attributes[AttributeFirstClassDiskParentName] = firstClassDisk.DatastoreInfo.Info.Name
+	}
+	attributes[AttributeFirstClassDiskAccessType] = accessType
+	if format := vclib.FirstClassDiskFormat(firstClassDisk); len(format) > 0 {
+		attributes[AttributeFirstClassDiskFormat] = format
+	}
+	if len(diskMode) > 0 {
+		attributes[AttributeFirstClassDiskMode] = diskMode
+	}
+	if len(diskSharing) > 0 {
+		attributes[AttributeFirstClassDiskSharing] = diskSharing
+	}
+	if multiAttach {
+		attributes[AttributeFirstClassDiskMultiAttach] = "true"
+	}
+	if len(encryptionPolicyName) > 0 {
+		attributes[AttributeFirstClassDiskEncrypted] = "true"
+	}
+	if mkfsOptions := params[AttributeFirstClassDiskMkfsOptions]; len(mkfsOptions) > 0 {
+		attributes[AttributeFirstClassDiskMkfsOptions] = mkfsOptions
+	}
+	contentSourceAttributes(attributes, firstClassDisk, contentSource)
+
+	resp := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      firstClassDisk.Config.Id.Id,
+			CapacityBytes: firstClassDisk.Config.CapacityInMB * MbInBytes,
+			VolumeContext: attributes,
+			ContentSource: contentSource,
+		},
+	}
+
+	if topology != nil {
+		resp.Volume.AccessibleTopology = []*csi.Topology{topology}
+	} else {
+		// Without a requested zone, the volume is accessible from the zones
+		// of the hosts that mount its datastore
+		topologies, err := c.datastoreTopologies(ctx, discoveryInfo.DataCenter, datastoreName, datastoreType)
+		if err != nil {
+			logger.Warningf("Failed to retrieve the zones of %s %s. Err: %v", datastoreType, datastoreName, err)
+		}
+		resp.Volume.AccessibleTopology = topologies
+	}
+
+	return resp, nil
+}
+
+// lockVolumeID acquires the lock of the ID of the FCD a create resolved,
+// which the operations on the volume by its ID acquire, so that they wait
+// for the rest of the create. The volume name stays locked too.
+func (c *controller) lockVolumeID(ctx context.Context, volumeID string) (context.Context, error) {
+	ctx, ok := c.volumeLocks.tryAcquire(ctx, volumeID)
+	if !ok {
+		msg := fmt.Sprintf("An operation for volume %s is already in progress", volumeID)
+		logging.Logger(ctx).Error(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+	return ctx, nil
+}
+
+func (c *controller) DeleteVolume(
+	ctx context.Context,
+	req *csi.DeleteVolumeRequest) (
+	*csi.DeleteVolumeResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	ctx, ok := c.volumeLocks.tryAcquire(ctx, req.VolumeId)
+	if !ok {
+		msg := fmt.Sprintf("An operation for volume %s is already in progress", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+	defer c.volumeLocks.release(req.VolumeId)
+
+	if isFileVolume(req.VolumeId) {
+		return c.deleteFileVolume(ctx, req.VolumeId)
+	}
+
+	// The vmdk of an in-tree volume is deleted as an FCD
+	volumeID, err := c.resolveVolumeID(ctx, req.VolumeId)
+	if err == vclib.ErrNoDiskIDFound {
+		logger.Warningf("In-tree volume %s not found. Err: %v", req.VolumeId, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, volumeID)
+	if err == vclib.ErrNoDiskIDFound {
+		logger.Warningf("Failed to retrieve VC/DC based on FCDID %s. Err: %v", volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	// A volume whose detach raced its deletion would lose the data of the
+	// workload still using it
+	if err := c.checkVolumeDetached(ctx, discoveryInfo); err != nil {
+		return nil, err
+	}
+
+	// CNS would otherwise keep listing the deleted volume
+	if err := c.unregisterCnsVolume(ctx, discoveryInfo.VcServer, volumeID); err != nil {
+		logger.Warningf("Failed to unregister volume %s from CNS. Err: %v", volumeID, err)
+	}
+
+	// Volume Type
+	datastoreName, datastoreType := getParentDatastore(discoveryInfo.FCDInfo)
+
+	err = retryTransient(ctx, func() error {
+		return c.datacenterOps(discoveryInfo.DataCenter).DeleteFirstClassDisk(ctx, datastoreName, datastoreType, volumeID)
+	})
+	if vclib.IsNotFound(err) {
+		// the FCD was deleted since it was indexed
+		logger.Warningf("Volume %s was already deleted. Err: %v", volumeID, err)
+	} else if err != nil {
+		msg := fmt.Sprintf("DeleteFirstClassDisk(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	c.vsphere(ctx).UnindexFirstClassDisk(volumeID)
+	c.uncacheFCD(volumeID)
+	c.legacyVolumes.remove(req.VolumeId)
+	c.contentSources.remove(volumeID)
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (c *controller) ControllerPublishVolume(
+	ctx context.Context,
+	req *csi.ControllerPublishVolumeRequest) (
+	*csi.ControllerPublishVolumeResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if len(req.NodeId) == 0 {
+		msg := "Node ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	// vSAN file shares are mounted over NFS, there is nothing to attach
+	if isFileVolume(req.VolumeId) {
+		return &csi.ControllerPublishVolumeResponse{}, nil
+	}
+
+	// Only the multi-attach volumes may be attached to several nodes
+	multiAttach := req.GetVolumeContext()[AttributeFirstClassDiskMultiAttach] == "true"
+	if volCap := req.GetVolumeCapability(); volCap != nil {
+		if err := validateVolumeCapabilities([]*csi.VolumeCapability{volCap}, multiAttach); err != nil {
+			msg := fmt.Sprintf("Volume capability is not supported. Err: %v", err)
+			logger.Error(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+	}
+
+	ctx, ok := c.volumeLocks.tryAcquire(ctx, req.VolumeId)
+	if !ok {
+		msg := fmt.Sprintf("An operation for volume %s is already in progress", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+	defer c.volumeLocks.release(req.VolumeId)
+
+	// The vmdk of an in-tree volume is attached as an FCD
+	volumeID, err := c.resolveVolumeID(ctx, req.VolumeId)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The volume is searched for first in the datacenter it was provisioned
+	// in, which its context records across restarts of the controller
+	volumeContext := req.GetVolumeContext()
+	c.vsphere(ctx).PinFirstClassDiskDatacenter(volumeID,
+		volumeContext[AttributeFirstClassDiskVcenter], volumeContext[AttributeFirstClassDiskDatacenter])
+
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, volumeID)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	// The FCD may have been migrated since it was indexed
+	if err := c.resolveBackingDatastore(ctx, discoveryInfo, req.GetVolumeContext()); err != nil {
+		return nil, err
+	}
+	fcd := discoveryInfo.FCDInfo
+
+	vm, err := c.getNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, req.NodeId)
+	if err == vclib.ErrNoVMFound {
+		msg := fmt.Sprintf("Node %s not found", req.NodeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("getNodeVM(%s) failed. Err: %v", req.NodeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	controllerType := c.cfg.Global.SCSIControllerType
+	if len(controllerType) == 0 {
+		controllerType = vclib.PVSCSIControllerType
+	}
+
+	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+
+	// Make sure the node has room for another volume
+	var attached bool
+	vm, err = c.withNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, req.NodeId, vm,
+		func(vm *vclib.VirtualMachine) (err error) {
+			attached, err = vm.IsDiskAttached(ctx, vclib.RemoveStorageClusterORFolderNameFromVDiskPath(filePath))
+			return err
+		})
+	if err != nil {
+		// the cached VM may no longer exist
+		c.nodeVMs.remove(req.NodeId)
+		msg := fmt.Sprintf("IsDiskAttached(%s) failed. Err: %v", filePath, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if !attached {
+		fcds, others, err := vm.GetSCSIDiskCounts(ctx)
+		if err != nil {
+			msg := fmt.Sprintf("GetSCSIDiskCounts(%s) failed. Err: %v", req.NodeId, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		limit := vclib.SCSIControllerLimit*vclib.SCSIControllerDeviceLimit - others
+		if max := int(c.cfg.Global.MaxVolumesPerNode); max > 0 && max < limit {
+			limit = max
+		}
+		if fcds >= limit {
+			msg := fmt.Sprintf("Node %s has reached its limit of %d volumes", req.NodeId, limit)
+			logger.Error(msg)
+			return nil, status.Errorf(codes.ResourceExhausted, msg)
+		}
+
+		// The datastore of the FCD must be up, and the host of the node must
+		// have access to it
+		if err := c.checkDatastoreAvailable(ctx, fcd); err != nil {
+			return nil, err
+		}
+		if err := c.checkDatastoreAccessible(ctx, discoveryInfo.VcServer, req.NodeId, vm, fcd); err != nil {
+			return nil, err
+		}
+	}
+
+	// The disk mode and sharing are recorded in the volume context by
+	// CreateVolume from the StorageClass parameters. A multi-attach disk
+	// may already be attached to other nodes, which share it.
+	options := &vclib.VolumeOptions{
+		SCSIControllerType: controllerType,
+		DiskMode:           req.GetVolumeContext()[AttributeFirstClassDiskMode],
+		DiskSharing:        req.GetVolumeContext()[AttributeFirstClassDiskSharing],
+	}
+	if multiAttach {
+		options.DiskSharing = string(types.VirtualDiskSharingSharingMultiWriter)
+	}
+	var diskUUID string
+	vm, err = c.withNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, req.NodeId, vm,
+		func(vm *vclib.VirtualMachine) (err error) {
+			diskUUID, err = c.vmQueues.attach(ctx, vmQueueKey(vm), c.vmOps(vm),
+				vclib.DiskAttachment{VMDiskPath: filePath, VolumeOptions: options})
+			return err
+		})
+	if err != nil {
+		c.nodeVMs.remove(req.NodeId)
+		msg := fmt.Sprintf("AttachDisk(%s = %s) failed. Err: %v", fcd.Config.Name, filePath, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	logger.V(2).Infof("AttachDisk(%s) succeeded with UUID: %s", filePath, diskUUID)
+	recordAttachedVolumes(ctx, req.NodeId, vm)
+
+	busNumber, unitNumber, err := vm.GetVirtualDiskPlacement(ctx, filePath)
+	if err != nil {
+		msg := fmt.Sprintf("GetVirtualDiskPlacement(%s) failed. Err: %v", filePath, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	logger.V(4).Infof("Disk %s placed on SCSI bus %d unit %d", filePath, busNumber, unitNumber)
+
+	diskMode, diskSharing, err := vm.GetVirtualDiskMode(ctx, filePath)
+	if err != nil {
+		msg := fmt.Sprintf("GetVirtualDiskMode(%s) failed. Err: %v", filePath, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	publishInfo := make(map[string]string, 0)
+	publishInfo[AttributeFirstClassDiskType] = FirstClassDiskTypeString
+	publishInfo[AttributeFirstClassDiskVcenter] = discoveryInfo.VcServer
+	publishInfo[AttributeFirstClassDiskDatacenter] = discoveryInfo.DataCenter.Name()
+	publishInfo[AttributeFirstClassDiskName] = fcd.Config.Name
+	publishInfo[AttributeFirstClassDiskParentType] = string(fcd.ParentType)
+	if fcd.ParentType == vclib.TypeDatastoreCluster {
+		publishInfo[AttributeFirstClassDiskParentName] = fcd.StoragePodInfo.Summary.Name
+		publishInfo[AttributeFirstClassDiskOwningDatastore] = fcd.DatastoreInfo.Info.Name
+	} else {
+		publishInfo[AttributeFirstClassDiskParentName] = fcd.DatastoreInfo.Info.Name
+	}
+	publishInfo[AttributeFirstClassDiskPage83Data] = diskUUID
+	publishInfo[AttributeFirstClassDiskSCSIController] = strconv.Itoa(int(busNumber))
+	publishInfo[AttributeFirstClassDiskSCSIUnit] = strconv.Itoa(int(unitNumber))
+	publishInfo[AttributeFirstClassDiskMode] = diskMode
+	if len(diskSharing) > 0 {
+		publishInfo[AttributeFirstClassDiskSharing] = diskSharing
+	}
+
+	resp := &csi.ControllerPublishVolumeResponse{
+		PublishContext: publishInfo,
+	}
+
+	return resp, nil
+}
+
+func (c *controller) ControllerUnpublishVolume(
+	ctx context.Context,
+	req *csi.ControllerUnpublishVolumeRequest) (
+	*csi.ControllerUnpublishVolumeResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if len(req.NodeId) == 0 {
+		msg := "Node ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	if isFileVolume(req.VolumeId) {
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	ctx, ok := c.volumeLocks.tryAcquire(ctx, req.VolumeId)
+	if !ok {
+		msg := fmt.Sprintf("An operation for volume %s is already in progress", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+	defer c.volumeLocks.release(req.VolumeId)
+
+	// A volume or node that no longer exists cannot have the volume
+	// attached, so the volume is considered unpublished.
+	volumeID, err := c.resolveVolumeID(ctx, req.VolumeId)
+	if err == vclib.ErrNoDiskIDFound {
+		logger.Warningf("In-tree volume %s not found. Err: %v", req.VolumeId, err)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, volumeID)
+	if err == vclib.ErrNoDiskIDFound {
+		logger.Warningf("Failed to retrieve VC/DC based on FCDID %s. Err: %v", volumeID, err)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	fcd := discoveryInfo.FCDInfo
+	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+
+	vm, err := c.getNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, req.NodeId)
+	if err == vclib.ErrNoVMFound {
+		logger.Warningf("Node %s not found. Err: %v", req.NodeId, err)
+	} else if err != nil {
+		msg := fmt.Sprintf("getNodeVM(%s) failed. Err: %v", req.NodeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	} else {
+		var attached bool
+		vm, err = c.withNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, req.NodeId, vm,
+			func(vm *vclib.VirtualMachine) (err error) {
+				attached, err = vm.IsDiskAttached(ctx, vclib.RemoveStorageClusterORFolderNameFromVDiskPath(filePath))
+				return err
+			})
+		if err != nil {
+			// the cached VM may no longer exist
+			c.nodeVMs.remove(req.NodeId)
+			msg := fmt.Sprintf("IsDiskAttached(%s) failed. Err: %v", filePath, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		if attached {
+			vm, err = c.withNodeVM(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, req.NodeId, vm,
+				func(vm *vclib.VirtualMachine) error {
+					return c.vmQueues.serialize(vmQueueKey(vm), func() error {
+						return retryTransient(ctx, func() error {
+							return c.vmOps(vm).DetachDisk(ctx, filePath)
+						})
+					})
+				})
+			if err != nil {
+				msg := fmt.Sprintf("DetachDisk(%s = %s) failed. Err: %v", fcd.Config.Name, filePath, err)
+				logger.Errorf(msg)
+				return nil, status.Errorf(errorCode(err), msg)
+			}
+			recordAttachedVolumes(ctx, req.NodeId, vm)
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+	}
+
+	// After a failed migration the disk may be attached to another VM than
+	// the one of the node, which would otherwise leak the attachment. The
+	// multi-writer disks, and the disks a volume attachment still publishes
+	// to the node of that VM, are attached to it on purpose.
+	vms, err := fcd.DatastoreInfo.GetVMsWithDisk(ctx, filePath)
+	if err != nil {
+		msg := fmt.Sprintf("GetVMsWithDisk(%s) failed. Err: %v", filePath, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if len(vms) == 0 {
+		logger.V(2).Infof("Volume %s is not attached to any VM", req.VolumeId)
+	}
+	for _, attachedVM := range vms {
+		_, diskSharing, err := attachedVM.GetVirtualDiskMode(ctx, filePath)
+		if err != nil {
+			msg := fmt.Sprintf("GetVirtualDiskMode(%s) failed. Err: %v", filePath, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		if diskSharing == string(types.VirtualDiskSharingSharingMultiWriter) {
+			logger.V(2).Infof("Volume %s is shared with VM %s, leaving it attached",
+				req.VolumeId, attachedVM.Reference().Value)
+			continue
+		}
+		if c.isPublishedToVM(ctx, req.VolumeId, discoveryInfo.VcServer, discoveryInfo.DataCenter, attachedVM) {
+			logger.V(2).Infof("Volume %s is published to the node of VM %s, leaving it attached",
+				req.VolumeId, attachedVM.Reference().Value)
+			continue
+		}
+		logger.Warningf("Volume %s is attached to VM %s instead of node %s, detaching it",
+			req.VolumeId, attachedVM.Reference().Value, req.NodeId)
+		err = c.vmQueues.serialize(vmQueueKey(attachedVM), func() error {
+			return retryTransient(ctx, func() error {
+				return c.vmOps(attachedVM).DetachDisk(ctx, filePath)
+			})
+		})
+		if err != nil {
+			msg := fmt.Sprintf("DetachDisk(%s = %s) failed. Err: %v", fcd.Config.Name, filePath, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+	}
+
+	resp := &csi.ControllerUnpublishVolumeResponse{}
+
+	return resp, nil
+}
+
+func (c *controller) ValidateVolumeCapabilities(
+	ctx context.Context,
+	req *csi.ValidateVolumeCapabilitiesRequest) (
+	*csi.ValidateVolumeCapabilitiesResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	if len(req.VolumeCapabilities) == 0 {
+		msg := "Volume capabilities is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	if isFileVolume(req.VolumeId) {
+		return c.validateFileVolume(ctx, req)
+	}
+
+	_, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, req.VolumeId)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", req.VolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	multiAttach := req.VolumeContext[AttributeFirstClassDiskMultiAttach] == "true"
+	if err := validateVolumeCapabilities(req.VolumeCapabilities, multiAttach); err != nil {
+		logger.V(2).Infof("Volume %s does not support the requested capabilities. Err: %v", req.VolumeId, err)
+		return &csi.ValidateVolumeCapabilitiesResponse{
+			Message: err.Error(),
+		}, nil
+	}
+
+	resp := &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.VolumeContext,
+			VolumeCapabilities: req.VolumeCapabilities,
+			Parameters:         req.Parameters,
+		},
+	}
+
+	return resp, nil
+}
+
+func (c *controller) ListVolumes(
+	ctx context.Context,
+	req *csi.ListVolumesRequest) (
+	*csi.ListVolumesResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	firstClassDisks := c.listFCDs(ctx, req.StartingToken)
+
+	total := len(firstClassDisks)
+	start, stop, err := getPage(req.StartingToken, req.MaxEntries, total)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid starting token %s. Err: %v", req.StartingToken, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+
+	logger.V(2).Infof("Start: %d, End: %d, Total: %d", start, stop, total)
 
 	resp := &csi.ListVolumesResponse{}
 
-	subsetFirstClassDisks := firstClassDisks
-	if start > total {
-		msg := fmt.Sprintf("Invalid start token %d. Greater than total items %d.", start, total)
-		log.Errorf(msg)
-		return nil, status.Errorf(codes.Internal, msg)
-	} else if stop >= total {
-		subsetFirstClassDisks = firstClassDisks[start:]
-	} else if stop < total {
-		subsetFirstClassDisks = firstClassDisks[start:(stop + 1)]
-	}
-
-	for _, firstClassDisk := range subsetFirstClassDisks {
-		attributes := make(map[string]string)
-		attributes[AttributeFirstClassDiskType] = FirstClassDiskTypeString
-		attributes[AttributeFirstClassDiskVcenter] = removePortFromHost(firstClassDisk.Datacenter.Client().URL().Host)
-		attributes[AttributeFirstClassDiskDatacenter] = firstClassDisk.Datacenter.Name()
-		attributes[AttributeFirstClassDiskName] = firstClassDisk.Config.Name
-		attributes[AttributeFirstClassDiskParentType] = string(firstClassDisk.ParentType)
-		if firstClassDisk.ParentType == vclib.TypeDatastoreCluster {
-			attributes[AttributeFirstClassDiskParentName] = firstClassDisk.StoragePodInfo.Summary.Name
-			attributes[AttributeFirstClassDiskOwningDatastore] = firstClassDisk.DatastoreInfo.Info.Name
-		} else {
-			attributes[AttributeFirstClassDiskParentName] = firstClassDisk.DatastoreInfo.Info.Name
+	for _, firstClassDisk := range firstClassDisks[start:stop] {
+		// The CO detaches the volumes it finds unpublished, so the entries
+		// are not listed without their published nodes
+		nodeIDs, err := publishedNodeIDs(ctx, firstClassDisk)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to retrieve the nodes of volume %s. Err: %v", firstClassDisk.Config.Id.Id, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		condition, err := volumeCondition(ctx, firstClassDisk)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to retrieve the condition of volume %s. Err: %v", firstClassDisk.Config.Id.Id, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+
+		resp.Entries = append(resp.Entries, &csi.ListVolumesResponse_Entry{
+			Volume: c.fcdVolume(ctx, firstClassDisk),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: nodeIDs,
+				VolumeCondition:  condition,
+			},
+		})
+	}
+
+	if stop < total {
+		resp.NextToken = strconv.Itoa(stop)
+		logger.V(2).Infoln("Next token is", resp.NextToken)
+	}
+
+	return resp, nil
+}
+
+// fcdVolume returns the volume of an FCD, with the attributes of the FCD as
+// its volume context.
+func (c *controller) fcdVolume(ctx context.Context, firstClassDisk *vclib.FirstClassDiskInfo) *csi.Volume {
+	attributes := make(map[string]string)
+	attributes[AttributeFirstClassDiskType] = FirstClassDiskTypeString
+	attributes[AttributeFirstClassDiskVcenter] = removePortFromHost(firstClassDisk.Datacenter.Client().URL().Host)
+	attributes[AttributeFirstClassDiskDatacenter] = firstClassDisk.Datacenter.Name()
+	attributes[AttributeFirstClassDiskName] = firstClassDisk.Config.Name
+	attributes[AttributeFirstClassDiskParentType] = string(firstClassDisk.ParentType)
+	if firstClassDisk.ParentType == vclib.TypeDatastoreCluster {
+		attributes[AttributeFirstClassDiskParentName] = firstClassDisk.StoragePodInfo.Summary.Name
+		attributes[AttributeFirstClassDiskOwningDatastore] = firstClassDisk.DatastoreInfo.Info.Name
+	} else {
+		attributes[AttributeFirstClassDiskParentName] = firstClassDisk.DatastoreInfo.Info.Name
+	}
+
+	// A volume whose source fails to be retrieved is returned without it
+	source, err := c.volumeContentSource(ctx, firstClassDisk.Datacenter, firstClassDisk.Config.Id.Id)
+	if err != nil {
+		logging.Logger(ctx).Warningf("Failed to retrieve the content source of volume %s. Err: %v",
+			firstClassDisk.Config.Id.Id, err)
+	}
+	contentSourceAttributes(attributes, firstClassDisk, source)
+
+	return &csi.Volume{
+		VolumeId:      firstClassDisk.Config.Id.Id,
+		CapacityBytes: firstClassDisk.Config.CapacityInMB * MbInBytes,
+		VolumeContext: attributes,
+		ContentSource: source,
+	}
+}
+
+// ControllerGetVolume returns a volume with the nodes it is published to
+// and its condition, which the CO monitors the health of the volume with.
+func (c *controller) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest) (
+	*csi.ControllerGetVolumeResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	if isFileVolume(req.VolumeId) {
+		msg := fmt.Sprintf("Volume %s is a vSAN file share, whose condition is not monitored", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, req.VolumeId)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", req.VolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	firstClassDisk := discoveryInfo.FCDInfo
+
+	nodeIDs, err := publishedNodeIDs(ctx, firstClassDisk)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to retrieve the nodes of volume %s. Err: %v", req.VolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	condition, err := volumeCondition(ctx, firstClassDisk)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to retrieve the condition of volume %s. Err: %v", req.VolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if condition.Abnormal {
+		logger.Warningf("Volume %s is abnormal: %s", req.VolumeId, condition.Message)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: c.fcdVolume(ctx, firstClassDisk),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: nodeIDs,
+			VolumeCondition:  condition,
+		},
+	}, nil
+}
+
+func (c *controller) GetCapacity(
+	ctx context.Context,
+	req *csi.GetCapacityRequest) (
+	*csi.GetCapacityResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// Get capacity params
+	params := req.GetParameters()
+
+	//check for required parameters
+	if len(params[AttributeFirstClassDiskParentType]) == 0 {
+		msg := fmt.Sprintf("Volume parameter %s is a required parameter.", AttributeFirstClassDiskParentType)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	// Volume Type
+	datastoreType := vclib.TypeDatastoreCluster
+	if params[AttributeFirstClassDiskParentType] == string(vclib.TypeDatastore) {
+		datastoreType = vclib.TypeDatastore
+	}
+
+	datastoreName := params[AttributeFirstClassDiskParentName]
+	zone := params[AttributeFirstClassDiskZone]
+	region := params[AttributeFirstClassDiskRegion]
+
+	var topologies []*csi.Topology
+	if req.GetAccessibleTopology() != nil {
+		topologies = []*csi.Topology{req.GetAccessibleTopology()}
+	}
+
+	discoveryInfo, topology, err := c.whichVCandDCByTopology(ctx, topologies, zone, region,
+		datastoreName, datastoreType)
+	if err == ErrDatastoreNotInZone {
+		// No volume is created on a datastore out of the zone
+		logger.V(4).Infof("%s %s is not mounted in zone %s, it has no capacity", datastoreType, datastoreName, zone)
+		return &csi.GetCapacityResponse{}, nil
+	} else if err != nil {
+		msg := fmt.Sprintf("Failed to retrieve VC/DC based on zone %s. Err: %v", zone, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	// Without a parent name, a volume is created on the datastore with the
+	// most free space in the zone, which bounds the capacity of the zone
+	if len(datastoreName) == 0 {
+		hosts, err := c.zoneHosts(ctx, discoveryInfo.DataCenter, topology)
+		if err == vclib.ErrNoZoneRegionFound {
+			return &csi.GetCapacityResponse{}, nil
+		} else if err != nil {
+			msg := fmt.Sprintf("Failed to retrieve the hosts in zone %s. Err: %v", zone, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
 		}
 
-		resp.Entries = append(resp.Entries, &csi.ListVolumesResponse_Entry{
-			Volume: &csi.Volume{
-				VolumeId:      firstClassDisk.Config.Id.Id,
-				CapacityBytes: int64(units.FileSize(firstClassDisk.Config.CapacityInMB * MbInBytes)),
-				VolumeContext: attributes,
-				//TODO: ContentSource?
-			},
-		})
+		freeSpace, err := getMostFreeSpace(ctx, discoveryInfo.DataCenter, datastoreType, hosts, c.datastoreFilter().Allows)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get the free space of the %ss in zone %s. Err: %v", datastoreType, zone, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		return &csi.GetCapacityResponse{
+			AvailableCapacity: freeSpace,
+		}, nil
 	}
 
-	if stop < total {
-		resp.NextToken = strconv.Itoa(stop + 1)
-		log.Infoln("Next token is", resp.NextToken)
+	// No volume is created on a datastore excluded by the allow or deny list
+	if !c.datastoreFilter().Allows(datastoreName) {
+		logger.V(4).Infof("%s %s is not allowed, it has no capacity", datastoreType, datastoreName)
+		return &csi.GetCapacityResponse{}, nil
 	}
 
-	return resp, nil
-}
+	freeSpace, err := discoveryInfo.DataCenter.GetDatastoreFreeSpace(ctx, datastoreName, datastoreType)
+	if err != nil {
+		msg := fmt.Sprintf("GetDatastoreFreeSpace(%s) failed. Err: %v", datastoreName, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	}
 
-func (c *controller) GetCapacity(
-	ctx context.Context,
-	req *csi.GetCapacityRequest) (
-	*csi.GetCapacityResponse, error) {
+	resp := &csi.GetCapacityResponse{
+		AvailableCapacity: freeSpace,
+	}
 
-	return nil, nil
+	return resp, nil
 }
 
 func (c *controller) ControllerGetCapabilities(
@@ -504,6 +2472,20 @@ func (c *controller) ControllerGetCapabilities(
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+					},
+				},
+			},
 			{
 				Type: &csi.ControllerServiceCapability_Rpc{
 					Rpc: &csi.ControllerServiceCapability_RPC{
@@ -518,16 +2500,422 @@ func (c *controller) ControllerGetCapabilities(
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
+func (c *controller) ControllerExpandVolume(
+	ctx context.Context,
+	req *csi.ControllerExpandVolumeRequest) (
+	*csi.ControllerExpandVolumeResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//check for required parameters
+	if len(req.VolumeId) == 0 {
+		msg := "Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	if req.CapacityRange == nil {
+		msg := "Capacity range is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	if isFileVolume(req.VolumeId) {
+		msg := fmt.Sprintf("Volume %s is a vSAN file share, which cannot be expanded", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	ctx, ok := c.volumeLocks.tryAcquire(ctx, req.VolumeId)
+	if !ok {
+		msg := fmt.Sprintf("An operation for volume %s is already in progress", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+	defer c.volumeLocks.release(req.VolumeId)
+
+	requestedBytes := req.CapacityRange.RequiredBytes
+	if requestedBytes == 0 {
+		requestedBytes = req.CapacityRange.LimitBytes
+	}
+	volSizeMB := volumeutil.RoundUpSize(requestedBytes, MbInBytes)
+	if err := c.checkVolumeSize(ctx, volSizeMB, req.CapacityRange); err != nil {
+		return nil, err
+	}
+
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, req.VolumeId)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", req.VolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	// An expansion that outlived a previous request is waited for rather
+	// than started again
+	datastoreName, datastoreType := getParentDatastore(discoveryInfo.FCDInfo)
+	pendingKey := discoveryInfo.VcServer + "/" + req.VolumeId
+	if task, ok := c.pendingExpands.get(pendingKey); ok {
+		err = discoveryInfo.DataCenter.WaitForTask(ctx, task)
+		if _, ok := err.(*vclib.TaskInProgressError); ok {
+			msg := withTaskProgress(discoveryInfo.DataCenter, task,
+				fmt.Sprintf("Expansion of volume %s is still in progress", req.VolumeId))
+			logger.Warning(msg)
+			return nil, status.Errorf(codes.DeadlineExceeded, msg)
+		}
+		c.pendingExpands.remove(pendingKey)
+		if err != nil {
+			logger.Warningf("Previous expansion of volume %s failed. Err: %v", req.VolumeId, err)
+		}
+
+		fcd, err := c.datacenterOps(discoveryInfo.DataCenter).GetFirstClassDisk(
+			ctx, datastoreName, datastoreType, req.VolumeId, vclib.FindFCDByID)
+		if err != nil {
+			msg := fmt.Sprintf("GetFirstClassDisk(%s) failed. Err: %v", req.VolumeId, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		discoveryInfo.FCDInfo = fcd
+		c.cacheFCD(fcd)
+	}
+
+	currentSizeMB := discoveryInfo.FCDInfo.Config.CapacityInMB
+	if volSizeMB < currentSizeMB {
+		msg := fmt.Sprintf("Shrinking volume %s from %d MB to %d MB is not supported",
+			req.VolumeId, currentSizeMB, volSizeMB)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	if volSizeMB > currentSizeMB {
+		if err := c.checkOfflineExtend(ctx, discoveryInfo); err != nil {
+			return nil, err
+		}
+
+		err = retryTransient(ctx, func() error {
+			return discoveryInfo.DataCenter.ExtendFirstClassDisk(ctx, datastoreName, datastoreType, req.VolumeId, volSizeMB)
+		})
+		if inProgress, ok := err.(*vclib.TaskInProgressError); ok {
+			c.pendingExpands.set(pendingKey, discoveryInfo.VcServer, inProgress.Task)
+			msg := withTaskProgress(discoveryInfo.DataCenter, inProgress.Task,
+				fmt.Sprintf("Expansion of volume %s is still in progress", req.VolumeId))
+			logger.Warning(msg)
+			return nil, status.Errorf(codes.DeadlineExceeded, msg)
+		} else if err != nil {
+			msg := fmt.Sprintf("ExtendFirstClassDisk(%s) failed. Err: %v", req.VolumeId, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+
+		c.cacheFCD(resizedFCD(discoveryInfo.FCDInfo, volSizeMB))
+	} else {
+		logger.V(2).Infof("Volume %s is already %d MB", req.VolumeId, currentSizeMB)
+	}
+
+	resp := &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         volSizeMB * MbInBytes,
+		NodeExpansionRequired: true,
+	}
+
+	return resp, nil
+}
+
+// ControllerModifyVolume applies the mutable parameters of a volume, as
+// found in a Kubernetes VolumeAttributesClass, to its FCD. Only the storage
+// policy may be changed; the FCD is reconfigured with the new policy before
+// it returns.
+func (c *controller) ControllerModifyVolume(
+	ctx context.Context,
+	req *csi.ControllerModifyVolumeRequest) (
+	*csi.ControllerModifyVolumeResponse, error) {
+
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//check for required parameters
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		msg := "Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	if isFileVolume(volumeID) {
+		msg := fmt.Sprintf("Volume %s is a vSAN file share, which cannot be modified", volumeID)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	mutableParameters := req.GetMutableParameters()
+	keys := make([]string, 0, len(mutableParameters))
+	for key := range mutableParameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key != AttributeFirstClassDiskStoragePolicyName {
+			msg := fmt.Sprintf("Volume parameter %s cannot be modified.", key)
+			logger.Error(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+	}
+
+	policyName, ok := mutableParameters[AttributeFirstClassDiskStoragePolicyName]
+	if !ok {
+		return &csi.ControllerModifyVolumeResponse{}, nil
+	}
+	if len(policyName) == 0 {
+		msg := fmt.Sprintf("Volume parameter %s cannot be empty.", AttributeFirstClassDiskStoragePolicyName)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	ctx, ok = c.volumeLocks.tryAcquire(ctx, volumeID)
+	if !ok {
+		msg := fmt.Sprintf("An operation for volume %s is already in progress", volumeID)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+	defer c.volumeLocks.release(volumeID)
+
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, volumeID)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Volume %s not found", volumeID)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	pbmClient, err := vclib.NewPbmClient(ctx, discoveryInfo.DataCenter.Client())
+	if err != nil {
+		msg := fmt.Sprintf("NewPbmClient failed. Err: %v", err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	profileID, err := pbmClient.ProfileIDByName(ctx, policyName)
+	if err != nil {
+		msg := fmt.Sprintf("Storage policy %s not found. Err: %v", policyName, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	datastoreName, datastoreType := getParentDatastore(discoveryInfo.FCDInfo)
+	err = retryTransient(ctx, func() error {
+		return discoveryInfo.DataCenter.UpdateFirstClassDiskPolicy(ctx, datastoreName, datastoreType, volumeID, profileID)
+	})
+	if err != nil {
+		msg := fmt.Sprintf("UpdateFirstClassDiskPolicy(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	logger.V(2).Infof("Volume %s now has storage policy %s", volumeID, policyName)
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
 func (c *controller) CreateSnapshot(
 	ctx context.Context,
 	req *csi.CreateSnapshotRequest) (
 	*csi.CreateSnapshotResponse, error) {
 
-	return nil, nil
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//check for required parameters
+	if len(req.SourceVolumeId) == 0 {
+		msg := "Source Volume ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	if len(req.Name) == 0 {
+		msg := "Snapshot name is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	if isFileVolume(req.SourceVolumeId) {
+		msg := fmt.Sprintf("Volume %s is a vSAN file share, which cannot be snapshotted", req.SourceVolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	quiesce, err := parseSnapshotQuiesce(req.GetParameters(), req.GetSecrets())
+	if err != nil {
+		msg := err.Error()
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, req.SourceVolumeId)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Source volume %s not found", req.SourceVolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", req.SourceVolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if err := c.requireCapability(ctx, discoveryInfo.VcServer, vclib.CapabilityFCDSnapshots, "CreateSnapshot"); err != nil {
+		return nil, err
+	}
+
+	datastoreName, datastoreType := getParentDatastore(discoveryInfo.FCDInfo)
+
+	// The snapshot name is stored as the description of the FCD snapshot.
+	// If a snapshot with the requested name already exists for the source
+	// volume, it is returned to keep the call idempotent. A snapshot with
+	// the name on another volume fails the call with AlreadyExists.
+	snapshots, err := discoveryInfo.DataCenter.ListFirstClassDiskSnapshots(
+		ctx, datastoreName, datastoreType, req.SourceVolumeId)
+	if err != nil {
+		msg := fmt.Sprintf("ListFirstClassDiskSnapshots(%s) failed. Err: %v", req.SourceVolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	var snapshot *types.VStorageObjectSnapshotInfoVStorageObjectSnapshot
+	for i := range snapshots {
+		if snapshots[i].Description == req.Name {
+			logger.V(2).Infof("Snapshot %s already exists for volume %s", req.Name, req.SourceVolumeId)
+			snapshot = &snapshots[i]
+			break
+		}
+	}
+
+	if snapshot == nil {
+		fcd, _, err := findSnapshotByName(ctx, c.connManager(ctx), req.Name)
+		if err == nil {
+			msg := fmt.Sprintf("Snapshot %s already exists for volume %s", req.Name, fcd.Config.Id.Id)
+			logger.Error(msg)
+			return nil, status.Errorf(codes.AlreadyExists, msg)
+		}
+
+		if quiesce != nil {
+			c.quiesceVolume(ctx, discoveryInfo, quiesce, req.GetParameters())
+		}
+
+		// A snapshot that failed after vSphere may have taken it is only
+		// taken again when the volume has no snapshot with the name
+		exists := func() (bool, error) {
+			snapshots, err := discoveryInfo.DataCenter.ListFirstClassDiskSnapshots(
+				ctx, datastoreName, datastoreType, req.SourceVolumeId)
+			if err != nil {
+				return false, err
+			}
+			for i := range snapshots {
+				if snapshots[i].Description == req.Name {
+					snapshot = &snapshots[i]
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		err = retryCreate(ctx, exists, func() (err error) {
+			snapshot, err = discoveryInfo.DataCenter.CreateFirstClassDiskSnapshot(
+				ctx, datastoreName, datastoreType, req.SourceVolumeId, req.Name)
+			return err
+		})
+		if err != nil {
+			msg := fmt.Sprintf("CreateFirstClassDiskSnapshot(%s) failed. Err: %v", req.Name, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+	}
+
+	logger.V(4).Infof("FCD snapshot %s: %+v", req.Name, snapshot)
+	csiSnapshot, err := toCSISnapshot(discoveryInfo.FCDInfo, snapshot)
+	if err != nil {
+		msg := fmt.Sprintf("toCSISnapshot(%s) failed. Err: %v", req.Name, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	resp := &csi.CreateSnapshotResponse{
+		Snapshot: csiSnapshot,
+	}
+
+	return resp, nil
 }
 
 func (c *controller) DeleteSnapshot(
@@ -535,7 +2923,76 @@ func (c *controller) DeleteSnapshot(
 	req *csi.DeleteSnapshotRequest) (
 	*csi.DeleteSnapshotResponse, error) {
 
-	return nil, nil
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	// The vCenter credentials of the secrets replace the configured ones
+	ctx, release, err := c.withSecrets(ctx, req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//check for required parameters
+	if len(req.SnapshotId) == 0 {
+		msg := "Snapshot ID is a required parameter."
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	fcdID, snapshotID, err := parseSnapshotID(req.SnapshotId)
+	if err != nil {
+		logger.Warningf("Snapshot %s does not exist. Err: %v", req.SnapshotId, err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, fcdID)
+	if err == vclib.ErrNoDiskIDFound {
+		logger.Warningf("Failed to retrieve VC/DC based on FCDID %s. Err: %v", fcdID, err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", fcdID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if err := c.requireCapability(ctx, discoveryInfo.VcServer, vclib.CapabilityFCDSnapshots, "DeleteSnapshot"); err != nil {
+		return nil, err
+	}
+
+	datastoreName, datastoreType := getParentDatastore(discoveryInfo.FCDInfo)
+
+	snapshots, err := discoveryInfo.DataCenter.ListFirstClassDiskSnapshots(
+		ctx, datastoreName, datastoreType, fcdID)
+	if err != nil {
+		msg := fmt.Sprintf("ListFirstClassDiskSnapshots(%s) failed. Err: %v", fcdID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	found := false
+	for _, snapshot := range snapshots {
+		if snapshot.Id != nil && snapshot.Id.Id == snapshotID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		logger.Warningf("Snapshot %s does not exist for volume %s", snapshotID, fcdID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	err = retryTransient(ctx, func() error {
+		return discoveryInfo.DataCenter.DeleteFirstClassDiskSnapshot(
+			ctx, datastoreName, datastoreType, fcdID, snapshotID)
+	})
+	if err != nil {
+		msg := fmt.Sprintf("DeleteFirstClassDiskSnapshot(%s) failed. Err: %v", req.SnapshotId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
 func (c *controller) ListSnapshots(
@@ -543,5 +3000,75 @@ func (c *controller) ListSnapshots(
 	req *csi.ListSnapshotsRequest) (
 	*csi.ListSnapshotsResponse, error) {
 
-	return nil, nil
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	var err error
+	var snapshotID string
+
+	sourceVolumeID := req.SourceVolumeId
+	if len(req.SnapshotId) > 0 {
+		var fcdID string
+		fcdID, snapshotID, err = parseSnapshotID(req.SnapshotId)
+		if err != nil || (len(sourceVolumeID) > 0 && sourceVolumeID != fcdID) {
+			logger.Warningf("Snapshot %s does not exist", req.SnapshotId)
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		sourceVolumeID = fcdID
+	}
+
+	var firstClassDisks []*vclib.FirstClassDiskInfo
+	if len(sourceVolumeID) > 0 {
+		discoveryInfo, err := c.vsphere(ctx).WhichVCandDCByFCDId(ctx, sourceVolumeID)
+		if err == vclib.ErrNoDiskIDFound {
+			logger.Warningf("Failed to retrieve VC/DC based on FCDID %s. Err: %v", sourceVolumeID, err)
+			return &csi.ListSnapshotsResponse{}, nil
+		} else if err != nil {
+			msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", sourceVolumeID, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		if err := c.requireCapability(ctx, discoveryInfo.VcServer, vclib.CapabilityFCDSnapshots, "ListSnapshots"); err != nil {
+			return nil, err
+		}
+		firstClassDisks = []*vclib.FirstClassDiskInfo{discoveryInfo.FCDInfo}
+	} else {
+		firstClassDisks = c.listFCDs(ctx, "")
+	}
+
+	snapshots := getCSISnapshots(ctx, firstClassDisks)
+	if len(snapshotID) > 0 {
+		filtered := make([]*csi.Snapshot, 0)
+		for _, snapshot := range snapshots {
+			if snapshot.SnapshotId == req.SnapshotId {
+				filtered = append(filtered, snapshot)
+			}
+		}
+		snapshots = filtered
+	}
+
+	total := len(snapshots)
+	start, stop, err := getPage(req.StartingToken, req.MaxEntries, total)
+	if err != nil {
+		msg := fmt.Sprintf("Invalid starting token %s. Err: %v", req.StartingToken, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.Aborted, msg)
+	}
+
+	logger.V(2).Infof("Start: %d, End: %d, Total: %d", start, stop, total)
+
+	resp := &csi.ListSnapshotsResponse{}
+	for _, snapshot := range snapshots[start:stop] {
+		resp.Entries = append(resp.Entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: snapshot,
+		})
+	}
+
+	if stop < total {
+		resp.NextToken = strconv.Itoa(stop)
+		logger.V(2).Infoln("Next token is", resp.NextToken)
+	}
+
+	return resp, nil
 }
@@ -22,21 +22,39 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	lookup "github.com/vmware/govmomi/lookup/simulator"
+	"github.com/vmware/govmomi/object"
+	pbm "github.com/vmware/govmomi/pbm/simulator"
+	"github.com/vmware/govmomi/session"
 	"github.com/vmware/govmomi/simulator"
 	"github.com/vmware/govmomi/simulator/vpx"
 	sts "github.com/vmware/govmomi/sts/simulator"
 	"github.com/vmware/govmomi/vapi/rest"
 	vapi "github.com/vmware/govmomi/vapi/simulator"
 	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
 	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	k8s "k8s.io/cloud-provider-vsphere/pkg/common/kubernetes"
 	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	vTypes "k8s.io/cloud-provider-vsphere/pkg/csi/types"
 )
 
 // configFromSim starts a vcsim instance and returns config for use against the vcsim instance.
@@ -64,6 +82,13 @@ func configFromSimWithTLS(tlsConfig *tls.Config, insecureAllowed bool, multiDc b
 		log.Fatal(err)
 	}
 
+	// vcsim reports its datastores as neither accessible nor shared
+	for _, obj := range simulator.Map.All("Datastore") {
+		ds := obj.(*simulator.Datastore)
+		ds.Summary.Accessible = true
+		ds.Summary.MultipleHostAccess = types.NewBool(true)
+	}
+
 	model.Service.TLS = tlsConfig
 	s := model.Service.NewServer()
 
@@ -71,13 +96,16 @@ func configFromSimWithTLS(tlsConfig *tls.Config, insecureAllowed bool, multiDc b
 	path, handler := sts.New(s.URL, vpx.Setting)
 	model.Service.ServeMux.Handle(path, handler)
 
-	// vAPI simulator
+	// vAPI simulator, which also serves the tags of the FCDs
 	path, handler = vapi.New(s.URL, nil)
-	model.Service.ServeMux.Handle(path, handler)
+	model.Service.Handle(path, handler)
 
 	// Lookup Service simulator
 	model.Service.RegisterSDK(lookup.New())
 
+	// PBM simulator, which serves the storage policies
+	model.Service.RegisterSDK(pbm.New())
+
 	cfg.Global.InsecureFlag = insecureAllowed
 
 	cfg.Global.VCenterIP = s.URL.Hostname()
@@ -119,17 +147,34 @@ func configFromEnvOrSim(multiDc bool) (*vcfg.Config, func()) {
 	return cfg, func() {}
 }
 
-func TestCompleteControllerFlow(t *testing.T) {
-	config, cleanup := configFromEnvOrSim(false)
-	defer cleanup()
+// controllerFromEnvOrSim returns a controller connected to the vCenter of
+// configFromEnvOrSim, along with its connection manager and a datastore of
+// the simulator. The returned cleanup logs out and stops the simulator.
+func controllerFromEnvOrSim(t *testing.T, multiDc bool) (*controller, *cm.ConnectionManager, *simulator.Datastore, func()) {
+	t.Helper()
 
+	config, stop := configFromEnvOrSim(multiDc)
 	connMgr := cm.NewConnectionManager(config, nil)
-	defer connMgr.Logout()
+	cleanup := func() {
+		connMgr.Logout()
+		stop()
+	}
+
+	if err := connMgr.Connect(context.Background(), config.Global.VCenterIP); err != nil {
+		cleanup()
+		t.Fatalf("Failed to Connect to vSphere: %s", err)
+	}
 
 	c := &controller{
 		cfg:     config,
 		connMgr: connMgr,
 	}
+	return c, connMgr, simulator.Map.Any("Datastore").(*simulator.Datastore), cleanup
+}
+
+func TestCompleteControllerFlow(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
 
 	//context
 	ctx := context.Background()
@@ -139,18 +184,11 @@ func TestCompleteControllerFlow(t *testing.T) {
 	vmName := myVM.Name
 	myVM.Guest.HostName = strings.ToLower(vmName)
 
-	// Get a simulator DS
-	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
-
-	err := connMgr.Connect(ctx, config.Global.VCenterIP)
-	if err != nil {
-		t.Errorf("Failed to Connect to vSphere: %s", err)
-	}
-
 	//create
 	params := make(map[string]string, 0)
 	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
 	params[AttributeFirstClassDiskParentName] = myds.Name
+	params[AttributeFirstClassDiskMkfsOptions] = "-m reflink=1"
 
 	reqCreate := &csi.CreateVolumeRequest{
 		Name: "test",
@@ -173,85 +211,1952 @@ func TestCompleteControllerFlow(t *testing.T) {
 	t.Logf("%s: %s", AttributeFirstClassDiskParentType, respCreate.Volume.VolumeContext[AttributeFirstClassDiskParentType])
 	t.Logf("%s: %s", AttributeFirstClassDiskParentName, respCreate.Volume.VolumeContext[AttributeFirstClassDiskParentName])
 
-	if !strings.EqualFold("test", volName) {
-		t.Errorf("[CREATE] Name of FCD does not match test != %s", volName)
+	if !strings.EqualFold("test", volName) {
+		t.Errorf("[CREATE] Name of FCD does not match test != %s", volName)
+	}
+	if opts := respCreate.Volume.VolumeContext[AttributeFirstClassDiskMkfsOptions]; opts != "-m reflink=1" {
+		t.Errorf("[CREATE] mkfs options of FCD not recorded: %q", opts)
+	}
+
+	//list
+	respList, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Errorf("ListVolumes failed: %v", err)
+	} else {
+		if len(respList.Entries) != 1 {
+			t.Error("There should only be a single FCD present")
+		} else {
+			name := respList.Entries[0].Volume.VolumeContext[AttributeFirstClassDiskName]
+			if !strings.EqualFold("test", name) {
+				t.Errorf("[LIST] Name of FCD does not match test != %s", name)
+			}
+		}
+	}
+
+	//publish
+	reqPub := &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   vmName,
+	}
+	respPub, err := c.ControllerPublishVolume(ctx, reqPub)
+	if err != nil {
+		t.Errorf("ControllerPublishVolume failed: %v", err)
+	} else {
+		pubCon := respPub.GetPublishContext()
+
+		name := pubCon[AttributeFirstClassDiskName]
+		if !strings.EqualFold("test", name) {
+			t.Errorf("[PUB] Name of FCD does not match test != %s", name)
+		}
+		if len(pubCon[AttributeFirstClassDiskSCSIController]) == 0 ||
+			len(pubCon[AttributeFirstClassDiskSCSIUnit]) == 0 {
+			t.Error("[PUB] SCSI placement of FCD is missing")
+		}
+		if mode := pubCon[AttributeFirstClassDiskMode]; mode != "independent_persistent" {
+			t.Errorf("[PUB] FCD should be attached as independent_persistent: %s", mode)
+		}
+	}
+
+	//unpublish
+	reqUnpub := &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   vmName,
+	}
+	_, err = c.ControllerUnpublishVolume(ctx, reqUnpub)
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume failed: %v", err)
+	}
+
+	// unpublishing a volume that is no longer attached succeeds
+	_, err = c.ControllerUnpublishVolume(ctx, reqUnpub)
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume of a detached volume failed: %v", err)
+	}
+
+	// unpublishing from a node that no longer exists succeeds
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   "enoent",
+	})
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume from a missing node failed: %v", err)
+	}
+
+	//delete
+	reqDelete := &csi.DeleteVolumeRequest{
+		VolumeId: volID,
+	}
+	_, err = c.DeleteVolume(ctx, reqDelete)
+	if err != nil {
+		t.Errorf("DeleteVolume failed: %v", err)
+	}
+
+	// unpublishing a volume that no longer exists succeeds
+	_, err = c.ControllerUnpublishVolume(ctx, reqUnpub)
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume of a missing volume failed: %v", err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(false)
+	defer cleanup()
+
+	// the controller is built without connecting, unlike controllerFromEnvOrSim
+	connMgr := cm.NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	c := &controller{
+		cfg:     config,
+		connMgr: connMgr,
+	}
+
+	// the controller is not ready until a session is established
+	if err := c.Probe(context.Background()); err != vclib.ErrNotConnected {
+		t.Errorf("Probe should fail with %v: %v", vclib.ErrNotConnected, err)
+	}
+
+	if err := connMgr.Connect(context.Background(), config.Global.VCenterIP); err != nil {
+		t.Fatalf("Failed to Connect to vSphere: %s", err)
+	}
+	if err := c.Probe(context.Background()); err != nil {
+		t.Errorf("Probe failed: %v", err)
+	}
+
+	// nor once the sessions are closed
+	connMgr.Close()
+	if err := c.Probe(context.Background()); err != vclib.ErrNotConnected {
+		t.Errorf("Probe should fail with %v after the sessions are closed: %v", vclib.ErrNotConnected, err)
+	}
+
+	c.connMgr = cm.NewConnectionManager(&vcfg.Config{}, nil)
+	if err := c.Probe(context.Background()); err == nil {
+		t.Error("Probe should fail without any vCenter")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	connMgr := cm.NewConnectionManager(config, nil)
+	c := &controller{
+		cfg:     config,
+		connMgr: connMgr,
+	}
+
+	//context
+	ctx := context.Background()
+
+	vc := config.Global.VCenterIP
+	if err := connMgr.Connect(ctx, vc); err != nil {
+		t.Fatalf("Failed to Connect to vSphere: %s", err)
+	}
+	client := connMgr.VsphereInstanceMap[vc].Conn.Client
+
+	// the pending tasks are dropped, the completed ones are not cancelled
+	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+	task := simulator.CreateTask(myds, "createDisk", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, nil
+	})
+	task.Run()
+	c.pendingCreates.set(vc+"/pvc-1", vc, task.Reference())
+
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if _, ok := c.pendingCreates.get(vc + "/pvc-1"); ok {
+		t.Error("Shutdown should drop the pending tasks")
+	}
+
+	userSession, err := session.NewManager(client).UserSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSession != nil {
+		t.Error("Shutdown should log out of the vCenter")
+	}
+}
+
+func TestUseK8sClient(t *testing.T) {
+	for _, k := range []string{k8s.EnvKubeConfig, vTypes.EnvDisableK8sClient, "KUBERNETES_SERVICE_HOST"} {
+		if v, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+		os.Unsetenv(k)
+	}
+	config := &vcfg.Config{}
+
+	// outside of a cluster, without a kubeconfig
+	if useK8sClient(config) {
+		t.Error("The Kubernetes client should be skipped without a kubeconfig or service account")
+	}
+
+	os.Setenv(k8s.EnvKubeConfig, "/etc/kubernetes/admin.conf")
+	if !useK8sClient(config) {
+		t.Error("The Kubernetes client should be used with a kubeconfig")
+	}
+
+	config.Global.DisableK8sClient = true
+	if useK8sClient(config) {
+		t.Error("The Kubernetes client should be skipped when disable-k8s-client is set")
+	}
+
+	// the environment variable wins over disable-k8s-client
+	os.Setenv(vTypes.EnvDisableK8sClient, "false")
+	if !useK8sClient(config) {
+		t.Errorf("The Kubernetes client should be used when %s is false", vTypes.EnvDisableK8sClient)
+	}
+
+	config.Global.DisableK8sClient = false
+	os.Setenv(vTypes.EnvDisableK8sClient, "true")
+	if useK8sClient(config) {
+		t.Errorf("The Kubernetes client should be skipped when %s is set", vTypes.EnvDisableK8sClient)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vmName := myVM.Name
+	myVM.Guest.HostName = strings.ToLower(vmName)
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	expectCode := func(op string, err error, code codes.Code) {
+		if status.Code(err) != code {
+			t.Errorf("%s should have failed with %s: %v", op, code, err)
+		}
+	}
+
+	// missing required parameters
+	_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Parameters: params,
+	})
+	expectCode("CreateVolume without a name", err, codes.InvalidArgument)
+
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+	})
+	expectCode("CreateVolume without parameters", err, codes.InvalidArgument)
+
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	expectCode("CreateVolume without a parent type", err, codes.InvalidArgument)
+
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{})
+	expectCode("DeleteVolume without a volume ID", err, codes.InvalidArgument)
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		NodeId: vmName,
+	})
+	expectCode("ControllerPublishVolume without a volume ID", err, codes.InvalidArgument)
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: "enoent",
+	})
+	expectCode("ControllerPublishVolume without a node ID", err, codes.InvalidArgument)
+
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		NodeId: vmName,
+	})
+	expectCode("ControllerUnpublishVolume without a volume ID", err, codes.InvalidArgument)
+
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: "enoent",
+	})
+	expectCode("ControllerUnpublishVolume without a node ID", err, codes.InvalidArgument)
+
+	// size mismatch on an existing volume
+	reqCreate := &csi.CreateVolumeRequest{
+		Name: "test",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 4 * GbInBytes,
+		},
+		Parameters: params,
+	}
+	respCreate, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	reqCreate.CapacityRange.RequiredBytes = 8 * GbInBytes
+	_, err = c.CreateVolume(ctx, reqCreate)
+	expectCode("CreateVolume with a different size", err, codes.AlreadyExists)
+
+	// missing volume and node
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: "enoent",
+		NodeId:   vmName,
+	})
+	expectCode("ControllerPublishVolume of a missing volume", err, codes.NotFound)
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: respCreate.Volume.VolumeId,
+		NodeId:   "enoent",
+	})
+	expectCode("ControllerPublishVolume to a missing node", err, codes.NotFound)
+
+	// invalid starting token
+	_, err = c.ListVolumes(ctx, &csi.ListVolumesRequest{
+		StartingToken: "enoent",
+	})
+	expectCode("ListVolumes with an invalid token", err, codes.Aborted)
+}
+
+func TestErrorMessages(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vmName := myVM.Name
+	myVM.Guest.HostName = strings.ToLower(vmName)
+
+	params := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+
+	expectMessage := func(op string, err error, msg string) {
+		if s, _ := status.FromError(err); err == nil || s.Message() != msg {
+			t.Errorf("%s should have failed with %q: %v", op, msg, err)
+		}
+	}
+
+	_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "test"})
+	expectMessage("CreateVolume without parameters", err,
+		"Create parameters is a required parameter.")
+
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{Parameters: params})
+	expectMessage("CreateVolume without a name", err,
+		"Volume name is a required parameter.")
+
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "test",
+		Parameters: map[string]string{AttributeFirstClassDiskParentName: myds.Name},
+	})
+	expectMessage("CreateVolume without a parent type", err,
+		"Volume parameter parent_type is a required parameter.")
+
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+			AttributeFirstClassDiskMode:       "nonpersistent",
+		},
+	})
+	expectMessage("CreateVolume with an invalid disk mode", err,
+		`Volume parameter diskmode must be one of ["persistent" "independent_persistent" "independent_nonpersistent"].`)
+
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskFormat:     "thick",
+		},
+	})
+	expectMessage("CreateVolume with an invalid disk format", err,
+		"Volume parameter diskformat must be one of eagerzeroedthick, thin, zeroedthick.")
+
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{})
+	expectMessage("DeleteVolume without a volume ID", err,
+		"Volume ID is a required parameter.")
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: "enoent"})
+	expectMessage("ControllerPublishVolume without a node ID", err,
+		"Node ID is a required parameter.")
+
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{NodeId: vmName})
+	expectMessage("ControllerUnpublishVolume without a volume ID", err,
+		"Volume ID is a required parameter.")
+
+	_, err = c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "enoent"})
+	expectMessage("ValidateVolumeCapabilities without capabilities", err,
+		"Volume capabilities is a required parameter.")
+
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{VolumeId: "enoent"})
+	expectMessage("ControllerExpandVolume without a capacity range", err,
+		"Capacity range is a required parameter.")
+
+	_, err = c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap"})
+	expectMessage("CreateSnapshot without a source volume ID", err,
+		"Source Volume ID is a required parameter.")
+
+	_, err = c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: "enoent"})
+	expectMessage("CreateSnapshot without a name", err,
+		"Snapshot name is a required parameter.")
+
+	reqCreate := &csi.CreateVolumeRequest{
+		Name: "test",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 4 * GbInBytes,
+		},
+		Parameters: params,
+	}
+	respCreate, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	reqCreate.CapacityRange.RequiredBytes = 8 * GbInBytes
+	_, err = c.CreateVolume(ctx, reqCreate)
+	expectMessage("CreateVolume with a different size", err,
+		"Volume test already exists with different parameters: size: existing 4096 MB != requested 8192 MB")
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: "enoent",
+		NodeId:   vmName,
+	})
+	expectMessage("ControllerPublishVolume of a missing volume", err, "Volume enoent not found")
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: respCreate.Volume.VolumeId,
+		NodeId:   "enoent",
+	})
+	expectMessage("ControllerPublishVolume to a missing node", err, "Node enoent not found")
+}
+
+func TestPublishManyVolumes(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vmName := myVM.Name
+	myVM.Guest.HostName = strings.ToLower(vmName)
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	// one more volume than fits on a single SCSI controller
+	placements := make(map[string]bool)
+	controllers := make(map[string]bool)
+	for i := 0; i <= vclib.SCSIControllerDeviceLimit; i++ {
+		respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name: fmt.Sprintf("test%d", i),
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: GbInBytes,
+			},
+			Parameters: params,
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume failed: %v", err)
+		}
+
+		respPub, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+			VolumeId: respCreate.Volume.VolumeId,
+			NodeId:   vmName,
+		})
+		if err != nil {
+			t.Fatalf("ControllerPublishVolume of volume %d failed: %v", i, err)
+		}
+
+		pubCon := respPub.GetPublishContext()
+		controller := pubCon[AttributeFirstClassDiskSCSIController]
+		placement := controller + ":" + pubCon[AttributeFirstClassDiskSCSIUnit]
+		if placements[placement] {
+			t.Errorf("SCSI placement %s was reused", placement)
+		}
+		placements[placement] = true
+		controllers[controller] = true
+	}
+
+	if len(controllers) < 2 {
+		t.Errorf("Expected an additional SCSI controller, got %d", len(controllers))
+	}
+}
+
+func TestPublishByNodeUUID(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM without a DNS name
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	myVM.Guest.HostName = ""
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: GbInBytes,
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volID := respCreate.Volume.VolumeId
+
+	for _, nodeID := range []string{myVM.Config.Uuid, myVM.Config.InstanceUuid} {
+		_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+			VolumeId: volID,
+			NodeId:   nodeID,
+		})
+		if err != nil {
+			t.Errorf("ControllerPublishVolume to node %s failed: %v", nodeID, err)
+		}
+
+		vm := c.nodeVMs.get(nodeID)
+		if vm == nil {
+			t.Errorf("VM of node %s was not cached", nodeID)
+		} else if vm.Reference() != myVM.Reference() {
+			t.Errorf("Expected VM %v for node %s, got %v", myVM.Reference(), nodeID, vm.Reference())
+		}
+
+		_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volID,
+			NodeId:   nodeID,
+		})
+		if err != nil {
+			t.Errorf("ControllerUnpublishVolume from node %s failed: %v", nodeID, err)
+		}
+	}
+
+	// a UUID that matches no VM is not found
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   "00000000-0000-0000-0000-000000000000",
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerPublishVolume to an unknown node should have failed with NotFound: %v", err)
+	}
+}
+
+// reregisterVM removes a VM from the inventory and registers it again, which
+// changes its managed object reference but keeps its UUIDs.
+func reregisterVM(ctx context.Context, t *testing.T, client *vim25.Client, vm *simulator.VirtualMachine) *simulator.VirtualMachine {
+	obj := object.NewVirtualMachine(client, vm.Reference())
+	if vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		task, err := obj.PowerOff(ctx)
+		if err == nil {
+			err = task.Wait(ctx)
+		}
+		if err != nil {
+			t.Fatalf("PowerOff failed: %v", err)
+		}
+	}
+	if err := obj.Unregister(ctx); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+
+	folder := object.NewFolder(client, *vm.Parent)
+	pool := object.NewResourcePool(client, *vm.ResourcePool)
+	host := object.NewHostSystem(client, *vm.Runtime.Host)
+	task, err := folder.RegisterVM(ctx, vm.Config.Files.VmPathName, vm.Name, false, pool, host)
+	if err != nil {
+		t.Fatalf("RegisterVM failed: %v", err)
+	}
+	info, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		t.Fatalf("RegisterVM failed: %v", err)
+	}
+
+	registered := simulator.Map.Get(info.Result.(types.ManagedObjectReference)).(*simulator.VirtualMachine)
+	registered.Config.Uuid = vm.Config.Uuid
+	registered.Config.InstanceUuid = vm.Config.InstanceUuid
+	registered.Summary.Config.Uuid = vm.Config.Uuid
+	registered.Summary.Config.InstanceUuid = vm.Config.InstanceUuid
+	return registered
+}
+
+func TestPublishToReregisteredVM(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	nodeID := myVM.Config.Uuid
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	var volIDs []string
+	for _, name := range []string{"before", "after"} {
+		respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name: name,
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: GbInBytes,
+			},
+			Parameters: params,
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume failed: %v", err)
+		}
+		volIDs = append(volIDs, respCreate.Volume.VolumeId)
+	}
+
+	// the VM of the node is cached by the first publish
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volIDs[0],
+		NodeId:   nodeID,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	registered := reregisterVM(ctx, t, connMgr.VsphereInstanceMap[config.Global.VCenterIP].Conn.Client, myVM)
+
+	// the cached VM no longer exists, so it is looked up again by its UUID
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volIDs[1],
+		NodeId:   nodeID,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume to the re-registered VM failed: %v", err)
+	}
+	if vm := c.nodeVMs.get(nodeID); vm == nil || vm.Reference() != registered.Reference() {
+		t.Errorf("Expected VM %v to be cached for node %s, got %v", registered.Reference(), nodeID, vm)
+	}
+
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: volIDs[1],
+		NodeId:   nodeID,
+	})
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume from the re-registered VM failed: %v", err)
+	}
+}
+
+func TestUnpublishFromAttachedVM(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	// Get two simulator VMs
+	var nodes []string
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		vm := obj.(*simulator.VirtualMachine)
+		vm.Guest.HostName = strings.ToLower(vm.Name)
+		nodes = append(nodes, vm.Guest.HostName)
+	}
+	if len(nodes) < 2 {
+		t.Fatalf("Expected at least 2 VMs, got %d", len(nodes))
+	}
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: GbInBytes,
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volID := respCreate.Volume.VolumeId
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   nodes[0],
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	// the volume is detached from the VM it is attached to, not the node
+	for i := 0; i < 2; i++ {
+		_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volID,
+			NodeId:   nodes[1],
+		})
+		if err != nil {
+			t.Fatalf("ControllerUnpublishVolume failed: %v", err)
+		}
+	}
+
+	discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, volID)
+	if err != nil {
+		t.Fatalf("WhichVCandDCByFCDId failed: %v", err)
+	}
+	filePath := discoveryInfo.FCDInfo.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+	vm, err := discoveryInfo.DataCenter.GetVMByDNSName(ctx, nodes[0])
+	if err != nil {
+		t.Fatalf("GetVMByDNSName failed: %v", err)
+	}
+	if attached, err := vm.IsDiskAttached(ctx, filePath); err != nil || attached {
+		t.Errorf("Volume %s should be detached from node %s: %v", volID, nodes[0], err)
+	}
+}
+
+func TestDeleteAttachedVolume(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vm.Guest.HostName = strings.ToLower(vm.Name)
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "test",
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volID := respCreate.Volume.VolumeId
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   vm.Guest.HostName,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	// an attached volume is not deleted
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{
+		VolumeId: volID,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("DeleteVolume should have failed with FailedPrecondition: %v", err)
+	}
+	if !strings.Contains(err.Error(), vm.Name) {
+		t.Errorf("The error should name VM %s: %v", vm.Name, err)
+	}
+	if _, err = connMgr.WhichVCandDCByFCDId(ctx, volID); err != nil {
+		t.Fatalf("Volume %s should not have been deleted: %v", volID, err)
+	}
+
+	// unless it is forced
+	config.Global.ForceDelete = true
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{
+		VolumeId: volID,
+	})
+	if err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if _, err = connMgr.WhichVCandDCByFCDId(ctx, volID); err != vclib.ErrNoDiskIDFound {
+		t.Errorf("Volume %s should have been deleted: %v", volID, err)
+	}
+}
+
+func TestCreateVolumeWithoutParentName(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+
+	reqCreate := &csi.CreateVolumeRequest{
+		Name:       "test",
+		Parameters: params,
+	}
+
+	respCreate, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	parentName := respCreate.Volume.VolumeContext[AttributeFirstClassDiskParentName]
+	if parentName != myds.Name {
+		t.Errorf("[CREATE] Selected datastore does not match %s != %s", myds.Name, parentName)
+	}
+
+	// a retried request returns the same volume
+	respRetry, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if respRetry.Volume.VolumeId != respCreate.Volume.VolumeId {
+		t.Errorf("[CREATE] Retried volume does not match %s != %s",
+			respCreate.Volume.VolumeId, respRetry.Volume.VolumeId)
+	}
+
+	// no datastore has enough free space
+	config.Global.DatastoreMinFreeSpaceMB = uint(myds.Info.GetDatastoreInfo().FreeSpace/MbInBytes) + 1
+	reqCreate.Name = "test2"
+	_, err = c.CreateVolume(ctx, reqCreate)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("CreateVolume should have failed with ResourceExhausted: %v", err)
+	}
+}
+
+func TestCreateVolumeAlreadyExists(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	reqCreate := &csi.CreateVolumeRequest{
+		Name: "test",
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	}
+	respCreate, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	// the same parameters return the existing volume
+	respRetry, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if respRetry.Volume.VolumeId != respCreate.Volume.VolumeId {
+		t.Errorf("Retried volume does not match %s != %s", respCreate.Volume.VolumeId, respRetry.Volume.VolumeId)
+	}
+	if format := respRetry.Volume.VolumeContext[AttributeFirstClassDiskFormat]; format != "thin" {
+		t.Errorf("The volume should be thin: %s", format)
+	}
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		message string
+	}{
+		{
+			"another datastore",
+			map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskParentName: "LocalDS_1",
+			},
+			"parent_name: existing " + myds.Name + " != requested LocalDS_1",
+		},
+		{
+			"a datastore cluster",
+			map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastoreCluster),
+			},
+			"parent_type: existing Datastore != requested DatastoreCluster",
+		},
+		{
+			"a thick disk",
+			map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskFormat:     "eagerzeroedthick",
+			},
+			"diskformat: existing thin != requested eagerzeroedthick",
+		},
+	}
+	for _, test := range tests {
+		_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:       "test",
+			Parameters: test.params,
+		})
+		if status.Code(err) != codes.AlreadyExists || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("CreateVolume on %s should have failed with AlreadyExists and %q: %v", test.name, test.message, err)
+		}
+	}
+
+	// a name too long for vSphere is sanitized, including on a retry
+	reqCreate.Name = strings.Repeat("pvc-", 25)
+	respLong, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume with a long name failed: %v", err)
+	}
+	if name := respLong.Volume.VolumeContext[AttributeFirstClassDiskName]; len(name) != MaxFirstClassDiskNameLength {
+		t.Errorf("The FCD name should be sanitized: %s", name)
+	}
+	if name := respLong.Volume.VolumeContext[AttributeFirstClassDiskRequestedName]; name != reqCreate.Name {
+		t.Errorf("The requested name should be recorded: %s", name)
+	}
+	respRetry, err = c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume with a long name failed: %v", err)
+	}
+	if respRetry.Volume.VolumeId != respLong.Volume.VolumeId {
+		t.Errorf("Retried volume does not match %s != %s", respLong.Volume.VolumeId, respRetry.Volume.VolumeId)
+	}
+
+	// no volume was created twice
+	respList, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	if len(respList.Entries) != 2 {
+		t.Errorf("There should only be two volumes: %v", respList.Entries)
+	}
+}
+
+func TestCreateVolumeMultiDC(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(true)
+	defer cleanup()
+
+	// DC1 is the first of the configured datacenters
+	config.VirtualCenter[config.Global.VCenterIP].Datacenters = "DC1,DC0"
+
+	connMgr := cm.NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	c := &controller{
+		cfg:     config,
+		connMgr: connMgr,
+	}
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+
+	reqCreate := &csi.CreateVolumeRequest{
+		Name:       "test",
+		Parameters: params,
+	}
+
+	respCreate, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	dcName := respCreate.Volume.VolumeContext[AttributeFirstClassDiskDatacenter]
+	if dcName != "DC1" {
+		t.Errorf("[CREATE] Selected datacenter does not match DC1 != %s", dcName)
+	}
+
+	// the volume is found in the datacenter it was created in
+	discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, respCreate.Volume.VolumeId)
+	if err != nil {
+		t.Fatalf("WhichVCandDCByFCDId failed: %v", err)
+	}
+	if discoveryInfo.DataCenter.Name() != "DC1" {
+		t.Errorf("[FIND] Datacenter does not match DC1 != %s", discoveryInfo.DataCenter.Name())
+	}
+
+	// no datacenter has the requested datastore
+	params[AttributeFirstClassDiskParentName] = "NotFound"
+	reqCreate.Name = "test2"
+	_, err = c.CreateVolume(ctx, reqCreate)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume should have failed with InvalidArgument: %v", err)
+	}
+}
+
+func TestCreateVolumePinnedDatacenter(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(true)
+	defer cleanup()
+
+	// DC1 is the first of the configured datacenters
+	config.VirtualCenter[config.Global.VCenterIP].Datacenters = "DC1,DC0"
+
+	connMgr := cm.NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	c := &controller{
+		cfg:     config,
+		connMgr: connMgr,
+	}
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskDatacenter] = "DC0"
+
+	reqCreate := &csi.CreateVolumeRequest{
+		Name:       "test",
+		Parameters: params,
+	}
+
+	// the volume is created in the pinned datacenter, not in the first one
+	respCreate, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if dcName := respCreate.Volume.VolumeContext[AttributeFirstClassDiskDatacenter]; dcName != "DC0" {
+		t.Errorf("[CREATE] Selected datacenter does not match DC0 != %s", dcName)
+	}
+
+	// a retried request finds the volume in the pinned datacenter
+	respRetry, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume retry failed: %v", err)
+	}
+	if respRetry.Volume.VolumeId != respCreate.Volume.VolumeId {
+		t.Errorf("[RETRY] Volume ID does not match %s != %s", respCreate.Volume.VolumeId, respRetry.Volume.VolumeId)
+	}
+
+	// the volume is found in the pinned datacenter once its location is lost
+	connMgr.ResetFirstClassDiskIndex()
+	discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, respCreate.Volume.VolumeId)
+	if err != nil {
+		t.Fatalf("WhichVCandDCByFCDId failed: %v", err)
+	}
+	if discoveryInfo.DataCenter.Name() != "DC0" {
+		t.Errorf("[FIND] Datacenter does not match DC0 != %s", discoveryInfo.DataCenter.Name())
+	}
+
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: respCreate.Volume.VolumeId})
+	if err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+
+	// the datacenter must be configured
+	params[AttributeFirstClassDiskDatacenter] = "DC9"
+	reqCreate.Name = "test2"
+	_, err = c.CreateVolume(ctx, reqCreate)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume in a datacenter that is not configured should have failed with InvalidArgument: %v", err)
+	}
+
+	// the datacenter replaces the zone
+	params[AttributeFirstClassDiskDatacenter] = "DC0"
+	params[AttributeFirstClassDiskZone] = "k8s-zone-a"
+	_, err = c.CreateVolume(ctx, reqCreate)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume with a datacenter and a zone should have failed with InvalidArgument: %v", err)
+	}
+}
+
+func TestCreateVolumeFromContentSource(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "source",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 2 * GbInBytes,
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	sourceID := respCreate.Volume.VolumeId
+
+	// smaller than the source
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "clone",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 1 * GbInBytes,
+		},
+		Parameters: params,
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: sourceID,
+				},
+			},
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume should have failed with InvalidArgument: %v", err)
+	}
+
+	// unknown source volume
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "clone",
+		Parameters: params,
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: "enoent",
+				},
+			},
+		},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("CreateVolume should have failed with NotFound: %v", err)
+	}
+
+	// unknown source snapshot
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "clone",
+		Parameters: params,
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{
+					SnapshotId: createSnapshotID(sourceID, "enoent"),
+				},
+			},
+		},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("CreateVolume should have failed with NotFound: %v", err)
+	}
+}
+
+func TestValidateVolumeCapabilities(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "test",
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	volCap := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: mode,
+			},
+		}
+	}
+
+	respValidate, err := c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: respCreate.Volume.VolumeId,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			volCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			volCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+		},
+	})
+	if err != nil {
+		t.Fatalf("ValidateVolumeCapabilities failed: %v", err)
+	}
+	if respValidate.Confirmed == nil || len(respValidate.Confirmed.VolumeCapabilities) != 2 {
+		t.Error("Single node access modes should be confirmed")
+	}
+
+	respValidate, err = c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: respCreate.Volume.VolumeId,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			volCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		},
+	})
+	if err != nil {
+		t.Fatalf("ValidateVolumeCapabilities failed: %v", err)
+	}
+	if respValidate.Confirmed != nil || len(respValidate.Message) == 0 {
+		t.Error("Multi node access modes should not be confirmed")
+	}
+
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+
+	respValidate, err = c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           respCreate.Volume.VolumeId,
+		VolumeCapabilities: []*csi.VolumeCapability{blockCap},
+	})
+	if err != nil {
+		t.Fatalf("ValidateVolumeCapabilities failed: %v", err)
+	}
+	if respValidate.Confirmed == nil {
+		t.Error("Block access should be confirmed")
+	}
+
+	respValidate, err = c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: respCreate.Volume.VolumeId,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			blockCap,
+			volCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		},
+	})
+	if err != nil {
+		t.Fatalf("ValidateVolumeCapabilities failed: %v", err)
+	}
+	if respValidate.Confirmed != nil || len(respValidate.Message) == 0 {
+		t.Error("Mixed block and mount access should not be confirmed")
+	}
+
+	_, err = c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: "enoent",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			volCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ValidateVolumeCapabilities should have failed with NotFound: %v", err)
+	}
+}
+
+func TestCreateVolumeCapabilities(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	mountCap := func(mode csi.VolumeCapability_AccessMode_Mode, fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: mode,
+			},
+		}
+	}
+	blockCap := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: mode,
+			},
+		}
+	}
+
+	rejected := map[string][]*csi.VolumeCapability{
+		"multi-node-reader-only": {
+			mountCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, ""),
+		},
+		"multi-node-single-writer": {
+			mountCap(csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER, ""),
+		},
+		"multi-node-multi-writer": {
+			blockCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		},
+		"unknown-access-mode": {
+			mountCap(csi.VolumeCapability_AccessMode_UNKNOWN, ""),
+		},
+		"unsupported-fs-type": {
+			mountCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "ntfs"),
+		},
+		"mixed-access-types": {
+			mountCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, ""),
+			blockCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		},
+	}
+	for name, volCaps := range rejected {
+		_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               name,
+			Parameters:         params,
+			VolumeCapabilities: volCaps,
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("[%s] CreateVolume should have failed with InvalidArgument: %v", name, err)
+		}
+	}
+
+	accepted := map[string][]*csi.VolumeCapability{
+		AccessTypeMount: {
+			mountCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "ext4"),
+		},
+		AccessTypeBlock: {
+			blockCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		},
+	}
+	for accessType, volCaps := range accepted {
+		respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               accessType,
+			Parameters:         params,
+			VolumeCapabilities: volCaps,
+		})
+		if err != nil {
+			t.Fatalf("[%s] CreateVolume failed: %v", accessType, err)
+		}
+		if respCreate.Volume.VolumeContext[AttributeFirstClassDiskAccessType] != accessType {
+			t.Errorf("[%s] Access type does not match %s != %s", accessType, accessType,
+				respCreate.Volume.VolumeContext[AttributeFirstClassDiskAccessType])
+		}
+	}
+}
+
+func TestMultiAttachVolume(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	// Get two simulator VMs
+	var nodes []string
+	for _, obj := range simulator.Map.All("VirtualMachine") {
+		vm := obj.(*simulator.VirtualMachine)
+		vm.Guest.HostName = strings.ToLower(vm.Name)
+		nodes = append(nodes, vm.Guest.HostName)
+	}
+	if len(nodes) < 2 {
+		t.Fatalf("Expected at least 2 VMs, got %d", len(nodes))
+	}
+
+	withParams := func(extra map[string]string) map[string]string {
+		params := map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		}
+		for k, v := range extra {
+			params[k] = v
+		}
+		return params
+	}
+	volCap := func(block bool) *csi.VolumeCapability {
+		volCap := &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			},
+		}
+		if block {
+			volCap.AccessType = &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			}
+		}
+		return volCap
+	}
+
+	rejected := map[string]struct {
+		params map[string]string
+		block  bool
+	}{
+		"mount":          {map[string]string{AttributeFirstClassDiskMultiAttach: "true"}, false},
+		"invalid":        {map[string]string{AttributeFirstClassDiskMultiAttach: "yes please"}, true},
+		"thin":           {map[string]string{AttributeFirstClassDiskMultiAttach: "true", AttributeFirstClassDiskFormat: "thin"}, true},
+		"not-shared":     {map[string]string{AttributeFirstClassDiskMultiAttach: "true", AttributeFirstClassDiskSharing: "sharingNone"}, true},
+		"no-multiattach": {nil, true},
+	}
+	for name, test := range rejected {
+		_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               name,
+			Parameters:         withParams(test.params),
+			VolumeCapabilities: []*csi.VolumeCapability{volCap(test.block)},
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("[%s] CreateVolume should have failed with InvalidArgument: %v", name, err)
+		}
+	}
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "shared",
+		Parameters:         withParams(map[string]string{AttributeFirstClassDiskMultiAttach: "true"}),
+		VolumeCapabilities: []*csi.VolumeCapability{volCap(true)},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volID := respCreate.Volume.VolumeId
+	volumeContext := respCreate.Volume.VolumeContext
+	if volumeContext[AttributeFirstClassDiskMultiAttach] != "true" ||
+		volumeContext[AttributeFirstClassDiskFormat] != "eagerzeroedthick" ||
+		volumeContext[AttributeFirstClassDiskSharing] != "sharingMultiWriter" {
+		t.Errorf("Unexpected volume context of a multi-attach volume: %v", volumeContext)
+	}
+
+	respValidate, err := c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           volID,
+		VolumeContext:      volumeContext,
+		VolumeCapabilities: []*csi.VolumeCapability{volCap(true)},
+	})
+	if err != nil {
+		t.Fatalf("ValidateVolumeCapabilities failed: %v", err)
+	}
+	if respValidate.Confirmed == nil {
+		t.Errorf("Multi-node block access should be confirmed: %s", respValidate.Message)
+	}
+
+	// the volume is attached to both nodes at once
+	for _, node := range nodes[:2] {
+		_, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+			VolumeId:         volID,
+			NodeId:           node,
+			VolumeCapability: volCap(true),
+			VolumeContext:    volumeContext,
+		})
+		if err != nil {
+			t.Fatalf("ControllerPublishVolume(%s) failed: %v", node, err)
+		}
+	}
+	isAttached := func(node string) bool {
+		t.Helper()
+		attached, err := c.isVolumeAttached(ctx, volID, node)
+		if err != nil {
+			t.Fatalf("isVolumeAttached(%s) failed: %v", node, err)
+		}
+		return attached
+	}
+	for _, node := range nodes[:2] {
+		if !isAttached(node) {
+			t.Errorf("Volume should be attached to node %s", node)
+		}
+	}
+
+	// and detached from the named node alone, even once already detached
+	for i := 0; i < 2; i++ {
+		_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volID,
+			NodeId:   nodes[0],
+		})
+		if err != nil {
+			t.Fatalf("ControllerUnpublishVolume failed: %v", err)
+		}
+		if isAttached(nodes[0]) || !isAttached(nodes[1]) {
+			t.Errorf("Volume should only be detached from node %s", nodes[0])
+		}
+	}
+
+	// a volume without multiattach is not attached to several nodes
+	respCreate, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "single",
+		Parameters: withParams(nil),
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         respCreate.Volume.VolumeId,
+		NodeId:           nodes[0],
+		VolumeCapability: volCap(true),
+		VolumeContext:    respCreate.Volume.VolumeContext,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerPublishVolume should have failed with InvalidArgument: %v", err)
+	}
+}
+
+// kmsCryptoManager lists fixed KMS clusters, as vcsim does not simulate its
+// crypto manager.
+type kmsCryptoManager struct {
+	mo.CryptoManagerKmip
+
+	clusters []types.KmipClusterInfo
+}
+
+func (m *kmsCryptoManager) ListKmipServers(req *types.ListKmipServers) soap.HasFault {
+	return &methods.ListKmipServersBody{
+		Res: &types.ListKmipServersResponse{Returnval: m.clusters},
+	}
+}
+
+// policyRecorder records the storage policy the FCDs are created with.
+type policyRecorder struct {
+	datacenterOps
+	policyID *string
+}
+
+func (r *policyRecorder) CreateFirstClassDiskWithOptions(ctx context.Context,
+	datastoreName string, datastoreType vclib.ParentDatastoreType,
+	diskName string, diskSize int64, volumeOptions *vclib.VolumeOptions) error {
+	*r.policyID = volumeOptions.StoragePolicyID
+	return r.datacenterOps.CreateFirstClassDiskWithOptions(ctx, datastoreName, datastoreType, diskName, diskSize, volumeOptions)
+}
+
+func TestCreateEncryptedVolume(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	var policyID string
+	c.hooks = vsphereHooks{
+		datacenter: func(dc *vclib.Datacenter) datacenterOps {
+			return &policyRecorder{datacenterOps: dc, policyID: &policyID}
+		},
+	}
+
+	ctx := context.Background()
+
+	kms := &kmsCryptoManager{}
+	kms.Self = *connMgr.VsphereInstanceMap[config.Global.VCenterIP].Conn.Client.ServiceContent.CryptoManager
+	simulator.Map.Put(kms)
+
+	createVolume := func(name string, extra map[string]string) (*csi.CreateVolumeResponse, error) {
+		params := map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		}
+		for k, v := range extra {
+			params[k] = v
+		}
+		return c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+			Parameters:    params,
+		})
+	}
+
+	invalid := []map[string]string{
+		{AttributeFirstClassDiskEncryption: "yes please"},
+		{AttributeFirstClassDiskEncryption: "false", AttributeFirstClassDiskEncryptionPolicyName: vclib.DefaultEncryptionPolicyName},
+		{AttributeFirstClassDiskEncryption: "true", AttributeFirstClassDiskStoragePolicyName: "vSAN Default Storage Policy"},
+	}
+	for _, params := range invalid {
+		if _, err := createVolume("invalid", params); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("CreateVolume with %v should have failed with InvalidArgument: %v", params, err)
+		}
+	}
+
+	// no KMS is configured
+	encryption := map[string]string{AttributeFirstClassDiskEncryption: "true"}
+	_, err := createVolume("encrypted", encryption)
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "KMS") {
+		t.Errorf("CreateVolume without a KMS should have failed with FailedPrecondition: %v", err)
+	}
+
+	kms.clusters = []types.KmipClusterInfo{{
+		ClusterId: types.KeyProviderId{Id: "kms"},
+		Servers:   []types.KmipServerInfo{{Name: "kms-1", Address: "10.0.0.2", Port: 5696}},
+	}}
+
+	_, err = createVolume("encrypted", map[string]string{AttributeFirstClassDiskEncryptionPolicyName: "enoent"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume with a missing encryption policy should have failed with InvalidArgument: %v", err)
+	}
+
+	respCreate, err := createVolume("encrypted", encryption)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if encrypted := respCreate.Volume.VolumeContext[AttributeFirstClassDiskEncrypted]; encrypted != "true" {
+		t.Errorf("The volume context should report the volume as encrypted: %v", respCreate.Volume.VolumeContext)
+	}
+	// the ID of the VM Encryption policy of the PBM simulator
+	if policyID != "4d5f673c-536f-11e6-beb8-9e71128cae77" {
+		t.Errorf("The volume should be created with the VM Encryption policy, not %q", policyID)
+	}
+
+	respPlain, err := createVolume("plain", map[string]string{AttributeFirstClassDiskEncryption: "false"})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if _, ok := respPlain.Volume.VolumeContext[AttributeFirstClassDiskEncrypted]; ok {
+		t.Errorf("The volume context should not report the volume as encrypted: %v", respPlain.Volume.VolumeContext)
+	}
+	if len(policyID) > 0 {
+		t.Errorf("The volume should be created without a storage policy, not %q", policyID)
+	}
+
+	respValidate, err := c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:      respCreate.Volume.VolumeId,
+		VolumeContext: respCreate.Volume.VolumeContext,
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+	})
+	if err != nil || respValidate.Confirmed == nil {
+		t.Errorf("ValidateVolumeCapabilities of the encrypted volume should be confirmed: %v %v", respValidate, err)
+	}
+}
+
+func TestGetCapacity(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCapacity, err := c.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("GetCapacity failed: %v", err)
+	}
+	if respCapacity.AvailableCapacity != myds.Info.GetDatastoreInfo().FreeSpace {
+		t.Errorf("AvailableCapacity does not match %d != %d",
+			myds.Info.GetDatastoreInfo().FreeSpace, respCapacity.AvailableCapacity)
+	}
+
+	params[AttributeFirstClassDiskParentName] = "enoent"
+	_, err = c.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: params,
+	})
+	if err == nil {
+		t.Error("GetCapacity should have failed for an unknown datastore")
+	}
+
+	// without a parent name, the datastore a volume would be created on
+	// is reported
+	delete(params, AttributeFirstClassDiskParentName)
+	respCapacity, err = c.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("GetCapacity failed: %v", err)
+	}
+	if respCapacity.AvailableCapacity != myds.Info.GetDatastoreInfo().FreeSpace {
+		t.Errorf("AvailableCapacity does not match %d != %d",
+			myds.Info.GetDatastoreInfo().FreeSpace, respCapacity.AvailableCapacity)
+	}
+}
+
+func TestExpandVolume(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	reqCreate := &csi.CreateVolumeRequest{
+		Name: "test",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 2 * GbInBytes,
+		},
+		Parameters: params,
+	}
+
+	respCreate, err := c.CreateVolume(ctx, reqCreate)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	volID := respCreate.Volume.VolumeId
+
+	// expanding to the current size is a no-op
+	respExpand, err := c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volID,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 2 * GbInBytes,
+		},
+	})
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume failed: %v", err)
+	}
+	if respExpand.CapacityBytes != 2*GbInBytes || !respExpand.NodeExpansionRequired {
+		t.Errorf("Unexpected expansion response %d bytes, node expansion %t",
+			respExpand.CapacityBytes, respExpand.NodeExpansionRequired)
+	}
+
+	// a retry waits for the expansion that outlived the previous request
+	task := simulator.CreateTask(myds, "extendDisk", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, nil
+	})
+	task.Run()
+	pendingKey := config.Global.VCenterIP + "/" + volID
+	c.pendingExpands.set(pendingKey, config.Global.VCenterIP, task.Reference())
+	respExpand, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volID,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 2 * GbInBytes,
+		},
+	})
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume failed: %v", err)
+	}
+	if respExpand.CapacityBytes != 2*GbInBytes {
+		t.Errorf("Unexpected expansion response %d bytes", respExpand.CapacityBytes)
+	}
+	if _, ok := c.pendingExpands.get(pendingKey); ok {
+		t.Error("The completed expansion should not be pending anymore")
+	}
+
+	// shrinking is rejected
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volID,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 1 * GbInBytes,
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerExpandVolume should have failed with InvalidArgument: %v", err)
+	}
+
+	// unknown volume
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: "enoent",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 4 * GbInBytes,
+		},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerExpandVolume should have failed with NotFound: %v", err)
+	}
+}
+
+func TestModifyVolume(t *testing.T) {
+	c, _, _, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	modify := func(volumeID string, mutableParameters map[string]string) error {
+		_, err := c.ControllerModifyVolume(ctx, &csi.ControllerModifyVolumeRequest{
+			VolumeId:          volumeID,
+			MutableParameters: mutableParameters,
+		})
+		return err
+	}
+
+	err := modify("", map[string]string{
+		AttributeFirstClassDiskStoragePolicyName: "gold",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerModifyVolume without a volume ID should have failed with InvalidArgument: %v", err)
+	}
+
+	// the parent of a volume cannot be changed
+	for _, key := range []string{AttributeFirstClassDiskParentType, AttributeFirstClassDiskParentName} {
+		err = modify("enoent", map[string]string{
+			AttributeFirstClassDiskStoragePolicyName: "gold",
+			key:                                      "changed",
+		})
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), key) {
+			t.Errorf("ControllerModifyVolume of %s should have failed with InvalidArgument: %v", key, err)
+		}
+	}
+
+	err = modify("enoent", map[string]string{
+		AttributeFirstClassDiskStoragePolicyName: "gold",
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerModifyVolume of a missing volume should have failed with NotFound: %v", err)
+	}
+
+	// nothing to modify
+	if err = modify("enoent", nil); err != nil {
+		t.Errorf("ControllerModifyVolume without parameters should succeed: %v", err)
+	}
+}
+
+func TestSnapshotFlow(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	volIDs := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		reqCreate := &csi.CreateVolumeRequest{
+			Name: fmt.Sprintf("test%d", i),
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: 1 * GbInBytes,
+			},
+			Parameters: params,
+		}
+
+		respCreate, err := c.CreateVolume(ctx, reqCreate)
+		if err != nil {
+			t.Fatalf("CreateVolume failed: %v", err)
+		}
+		volIDs = append(volIDs, respCreate.Volume.VolumeId)
+	}
+
+	reqSnap := &csi.CreateSnapshotRequest{
+		SourceVolumeId: volIDs[0],
+		Name:           "snap",
+	}
+
+	// vcsim reports an API version older than the FCD snapshots
+	_, err = c.CreateSnapshot(ctx, reqSnap)
+	if status.Code(err) != codes.Unimplemented || !strings.Contains(err.Error(), "requires vSphere 6.7U3") {
+		t.Fatalf("CreateSnapshot should have failed with Unimplemented on vSphere 6.5: %v", err)
+	}
+	connMgr.RecordCapabilities(config.Global.VCenterIP, "6.7.3")
+
+	respSnap, err := c.CreateSnapshot(ctx, reqSnap)
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+
+	snap := respSnap.Snapshot
+	if snap.SourceVolumeId != volIDs[0] {
+		t.Errorf("SourceVolumeId does not match %s != %s", volIDs[0], snap.SourceVolumeId)
+	}
+	if !strings.HasPrefix(snap.SnapshotId, volIDs[0]+SnapshotIDSeparator) {
+		t.Errorf("SnapshotId %s is not prefixed by the source volume ID", snap.SnapshotId)
+	}
+	if snap.SizeBytes != 1*GbInBytes {
+		t.Errorf("SizeBytes does not match %d != %d", 1*GbInBytes, snap.SizeBytes)
+	}
+	if snap.CreationTime == nil || !snap.ReadyToUse {
+		t.Error("Snapshot should have a creation time and be ready to use")
+	}
+
+	// same name and source is idempotent
+	respSnap, err = c.CreateSnapshot(ctx, reqSnap)
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+	if respSnap.Snapshot.SnapshotId != snap.SnapshotId {
+		t.Errorf("SnapshotId does not match %s != %s", snap.SnapshotId, respSnap.Snapshot.SnapshotId)
+	}
+
+	// same name and different source
+	reqSnap.SourceVolumeId = volIDs[1]
+	_, err = c.CreateSnapshot(ctx, reqSnap)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateSnapshot should have failed with AlreadyExists: %v", err)
+	}
+
+	// unknown source
+	reqSnap.SourceVolumeId = "enoent"
+	_, err = c.CreateSnapshot(ctx, reqSnap)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("CreateSnapshot should have failed with NotFound: %v", err)
+	}
+
+	// second snapshot of the first volume
+	_, err = c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		SourceVolumeId: volIDs[0],
+		Name:           "snap2",
+	})
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
 	}
 
 	//list
-	respList, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	respList, err := c.ListSnapshots(ctx, &csi.ListSnapshotsRequest{})
 	if err != nil {
-		t.Errorf("ListVolumes failed: %v", err)
-	} else {
-		if len(respList.Entries) != 1 {
-			t.Error("There should only be a single FCD present")
-		} else {
-			name := respList.Entries[0].Volume.VolumeContext[AttributeFirstClassDiskName]
-			if !strings.EqualFold("test", name) {
-				t.Errorf("[LIST] Name of FCD does not match test != %s", name)
-			}
-		}
+		t.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(respList.Entries) != 2 {
+		t.Errorf("There should be 2 snapshots present, found %d", len(respList.Entries))
 	}
 
-	//publish
-	reqPub := &csi.ControllerPublishVolumeRequest{
-		VolumeId: volID,
-		NodeId:   vmName,
+	respList, err = c.ListSnapshots(ctx, &csi.ListSnapshotsRequest{
+		MaxEntries: 1,
+	})
+	if err != nil {
+		t.Fatalf("ListSnapshots failed: %v", err)
 	}
-	respPub, err := c.ControllerPublishVolume(ctx, reqPub)
+	if len(respList.Entries) != 1 || respList.NextToken != "1" {
+		t.Errorf("There should be a single snapshot and a next token of 1, found %d and %q",
+			len(respList.Entries), respList.NextToken)
+	}
+
+	respList, err = c.ListSnapshots(ctx, &csi.ListSnapshotsRequest{
+		StartingToken: respList.NextToken,
+	})
 	if err != nil {
-		t.Errorf("ControllerPublishVolume failed: %v", err)
-	} else {
-		pubCon := respPub.GetPublishContext()
+		t.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(respList.Entries) != 1 || respList.NextToken != "" {
+		t.Errorf("There should be a single snapshot and no next token, found %d and %q",
+			len(respList.Entries), respList.NextToken)
+	}
 
-		name := pubCon[AttributeFirstClassDiskName]
-		if !strings.EqualFold("test", name) {
-			t.Errorf("[PUB] Name of FCD does not match test != %s", name)
-		}
+	_, err = c.ListSnapshots(ctx, &csi.ListSnapshotsRequest{
+		StartingToken: "3",
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("ListSnapshots should have failed with Aborted: %v", err)
 	}
 
-	//unpublish
-	reqUnpub := &csi.ControllerUnpublishVolumeRequest{
-		VolumeId: volID,
-		NodeId:   vmName,
+	respList, err = c.ListSnapshots(ctx, &csi.ListSnapshotsRequest{
+		SourceVolumeId: volIDs[1],
+	})
+	if err != nil {
+		t.Fatalf("ListSnapshots failed: %v", err)
 	}
-	_, err = c.ControllerUnpublishVolume(ctx, reqUnpub)
+	if len(respList.Entries) != 0 {
+		t.Errorf("There should be no snapshots of %s, found %d", volIDs[1], len(respList.Entries))
+	}
+
+	respList, err = c.ListSnapshots(ctx, &csi.ListSnapshotsRequest{
+		SnapshotId: snap.SnapshotId,
+	})
 	if err != nil {
-		t.Errorf("ControllerUnpublishVolume failed: %v", err)
+		t.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(respList.Entries) != 1 || respList.Entries[0].Snapshot.SnapshotId != snap.SnapshotId {
+		t.Errorf("ListSnapshots should only return snapshot %s", snap.SnapshotId)
 	}
 
 	//delete
-	reqDelete := &csi.DeleteVolumeRequest{
-		VolumeId: volID,
+	reqDelete := &csi.DeleteSnapshotRequest{
+		SnapshotId: snap.SnapshotId,
 	}
-	_, err = c.DeleteVolume(ctx, reqDelete)
+	_, err = c.DeleteSnapshot(ctx, reqDelete)
 	if err != nil {
-		t.Errorf("DeleteVolume failed: %v", err)
+		t.Errorf("DeleteSnapshot failed: %v", err)
+	}
+
+	// deleting a missing snapshot succeeds
+	_, err = c.DeleteSnapshot(ctx, reqDelete)
+	if err != nil {
+		t.Errorf("DeleteSnapshot of a missing snapshot failed: %v", err)
+	}
+
+	respList, err = c.ListSnapshots(ctx, &csi.ListSnapshotsRequest{
+		SnapshotId: snap.SnapshotId,
+	})
+	if err != nil {
+		t.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(respList.Entries) != 0 {
+		t.Errorf("Snapshot %s should have been deleted", snap.SnapshotId)
 	}
 }
 
 func TestListBoundaries(t *testing.T) {
-	config, cleanup := configFromEnvOrSim(false)
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
 	defer cleanup()
 
-	connMgr := cm.NewConnectionManager(config, nil)
-	defer connMgr.Logout()
-
-	c := &controller{
-		cfg:     config,
-		connMgr: connMgr,
-	}
-
 	//context
 	ctx := context.Background()
 
-	// Get a simulator DS
-	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
-
-	err := connMgr.Connect(ctx, config.Global.VCenterIP)
-	if err != nil {
-		t.Errorf("Failed to Connect to vSphere: %s", err)
-	}
-
 	//create
 	params := make(map[string]string, 0)
 	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
@@ -367,30 +2272,256 @@ func TestListBoundaries(t *testing.T) {
 			t.Errorf("Incorrect next token. Excepting emptyy string got next=%s", next)
 		}
 	}
+
+	// get the last one by itself
+	resp, err = c.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: "10", MaxEntries: 1})
+	if err != nil {
+		t.Errorf("ListVolumes [10] failed: %v", err)
+	} else {
+		count := len(resp.Entries)
+		if count != 1 {
+			t.Errorf("Invalid number of volumes listed. Excepting 1 got %d", count)
+		}
+		next := resp.NextToken
+		if len(next) != 0 {
+			t.Errorf("Incorrect next token. Excepting empty string got next=%s", next)
+		}
+	}
+
+	// starting token equal to the total is an empty page
+	resp, err = c.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: "11"})
+	if err != nil {
+		t.Errorf("ListVolumes [11] failed: %v", err)
+	} else {
+		count := len(resp.Entries)
+		if count != 0 {
+			t.Errorf("Invalid number of volumes listed. Excepting 0 got %d", count)
+		}
+	}
+
+	// starting token past the total
+	_, err = c.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: "12"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("ListVolumes [12] should have failed with Aborted: %v", err)
+	}
 }
 
-func TestListOrder(t *testing.T) {
-	config, cleanup := configFromEnvOrSim(false)
+func TestListEmpty(t *testing.T) {
+	c, _, _, cleanup := controllerFromEnvOrSim(t, false)
 	defer cleanup()
 
-	connMgr := cm.NewConnectionManager(config, nil)
-	defer connMgr.Logout()
+	//context
+	ctx := context.Background()
 
-	c := &controller{
-		cfg:     config,
-		connMgr: connMgr,
+	resp, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{MaxEntries: 1})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	if len(resp.Entries) != 0 || len(resp.NextToken) != 0 {
+		t.Errorf("Excepting no volumes and no next token got %d and next=%s", len(resp.Entries), resp.NextToken)
+	}
+}
+
+func TestListVolumesDatastores(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	ctx := context.Background()
+
+	_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	for patterns, count := range map[string]int{
+		"":                              1,
+		myds.Name:                       1,
+		"k8s-*, " + myds.Name[:2] + "*": 1,
+		"k8s-*":                         0,
+	} {
+		config.Global.ListVolumesDatastores = patterns
+		config.Global.ListVolumesConcurrency = 4
+		if fcds := c.scanFCDs(ctx); len(fcds) != count {
+			t.Errorf("list-volumes-datastores %q: %d volumes expected, got %d", patterns, count, len(fcds))
+		}
 	}
+}
+
+func TestListVolumesStatus(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
 
 	//context
 	ctx := context.Background()
 
-	// Get a simulator DS
-	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+	// Get a simulator VM
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	myVM.Guest.HostName = strings.ToLower(myVM.Name)
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volID := respCreate.Volume.VolumeId
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   myVM.Guest.HostName,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
 
-	err := connMgr.Connect(ctx, config.Global.VCenterIP)
+	volumeStatus := func() *csi.ListVolumesResponse_VolumeStatus {
+		t.Helper()
+		resp, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+		if err != nil {
+			t.Fatalf("ListVolumes failed: %v", err)
+		}
+		if len(resp.Entries) != 1 || resp.Entries[0].Status == nil {
+			t.Fatalf("Expected 1 volume with a status, got %v", resp.Entries)
+		}
+		return resp.Entries[0].Status
+	}
+
+	// the volume is published to the node with the BIOS UUID of its VM
+	volStatus := volumeStatus()
+	nodeID := strings.ToLower(myVM.Config.Uuid)
+	if len(volStatus.PublishedNodeIds) != 1 || volStatus.PublishedNodeIds[0] != nodeID {
+		t.Errorf("Expected volume %s published to node %s, got %v", volID, nodeID, volStatus.PublishedNodeIds)
+	}
+	if volStatus.VolumeCondition == nil || volStatus.VolumeCondition.Abnormal {
+		t.Errorf("Expected volume %s to be healthy, got %v", volID, volStatus.VolumeCondition)
+	}
+
+	myds.Summary.Accessible = false
+	volStatus = volumeStatus()
+	if !volStatus.VolumeCondition.Abnormal || !strings.Contains(volStatus.VolumeCondition.Message, myds.Name) {
+		t.Errorf("Expected volume %s abnormal on inaccessible datastore %s, got %v",
+			volID, myds.Name, volStatus.VolumeCondition)
+	}
+	myds.Summary.Accessible = true
+
+	discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, volID)
+	if err != nil {
+		t.Fatalf("WhichVCandDCByFCDId failed: %v", err)
+	}
+	filePath := discoveryInfo.FCDInfo.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+	var path object.DatastorePath
+	if !path.FromString(filePath) {
+		t.Fatalf("Invalid backing file path %s", filePath)
+	}
+	if err := os.Remove(filepath.Join(myds.Info.GetDatastoreInfo().Url, path.Path)); err != nil {
+		t.Fatalf("Failed to remove the backing file %s: %v", filePath, err)
+	}
+	volStatus = volumeStatus()
+	if !volStatus.VolumeCondition.Abnormal || !strings.Contains(volStatus.VolumeCondition.Message, filePath) {
+		t.Errorf("Expected volume %s abnormal without its backing file, got %v", volID, volStatus.VolumeCondition)
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	myVM.Guest.HostName = strings.ToLower(myVM.Name)
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volID := respCreate.Volume.VolumeId
+
+	_, err = c.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerGetVolume without a volume ID should have failed with InvalidArgument: %v", err)
+	}
+	_, err = c.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: "enoent"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerGetVolume of a missing volume should have failed with NotFound: %v", err)
+	}
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   myVM.Guest.HostName,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	resp, err := c.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volID})
 	if err != nil {
-		t.Errorf("Failed to Connect to vSphere: %s", err)
+		t.Fatalf("ControllerGetVolume failed: %v", err)
+	}
+	if resp.Volume.CapacityBytes != GbInBytes || resp.Volume.VolumeContext[AttributeFirstClassDiskName] != "test" {
+		t.Errorf("Expected volume test of %d bytes, got %v", GbInBytes, resp.Volume)
+	}
+	nodeID := strings.ToLower(myVM.Config.Uuid)
+	if len(resp.Status.PublishedNodeIds) != 1 || resp.Status.PublishedNodeIds[0] != nodeID {
+		t.Errorf("Expected volume %s published to node %s, got %v", volID, nodeID, resp.Status.PublishedNodeIds)
+	}
+	if resp.Status.VolumeCondition.Abnormal {
+		t.Errorf("Expected volume %s to be healthy, got %v", volID, resp.Status.VolumeCondition)
+	}
+
+	// the volume is abnormal while its datastore is down or in maintenance
+	for _, state := range []struct {
+		accessible      bool
+		maintenanceMode types.DatastoreSummaryMaintenanceModeState
+	}{
+		{false, types.DatastoreSummaryMaintenanceModeStateNormal},
+		{true, types.DatastoreSummaryMaintenanceModeStateEnteringMaintenance},
+		{true, types.DatastoreSummaryMaintenanceModeStateInMaintenance},
+	} {
+		myds.Summary.Accessible = state.accessible
+		myds.Summary.MaintenanceMode = string(state.maintenanceMode)
+		resp, err = c.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volID})
+		if err != nil {
+			t.Fatalf("ControllerGetVolume failed: %v", err)
+		}
+		condition := resp.Status.VolumeCondition
+		if !condition.Abnormal || !strings.Contains(condition.Message, myds.Name) {
+			t.Errorf("accessible=%t maintenance=%s: expected an abnormal condition naming datastore %s, got %v",
+				state.accessible, state.maintenanceMode, myds.Name, condition)
+		}
 	}
+	myds.Summary.Accessible = true
+	myds.Summary.MaintenanceMode = string(types.DatastoreSummaryMaintenanceModeStateNormal)
+}
+
+func TestListOrder(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
 
 	//create
 	params := make(map[string]string, 0)
@@ -503,21 +2634,9 @@ func TestZoneSupport(t *testing.T) {
 	ctx := context.Background()
 
 	// Start vcsim
-	config, cleanup := configFromEnvOrSim(true)
+	c, connMgr, _, cleanup := controllerFromEnvOrSim(t, true)
 	defer cleanup()
-
-	connMgr := cm.NewConnectionManager(config, nil)
-	defer connMgr.Logout()
-
-	c := &controller{
-		cfg:     config,
-		connMgr: connMgr,
-	}
-
-	err := connMgr.Connect(ctx, config.Global.VCenterIP)
-	if err != nil {
-		t.Errorf("Failed to Connect to vSphere: %s", err)
-	}
+	config := c.cfg
 
 	// Get the vSphere Instance
 	vsi := connMgr.VsphereInstanceMap[config.Global.VCenterIP]
@@ -659,6 +2778,235 @@ func TestZoneSupport(t *testing.T) {
 		t.Errorf("[CREATE] Name of FCD does not match test != %s", volName)
 	}
 
+	if len(respCreate.Volume.AccessibleTopology) != 1 ||
+		respCreate.Volume.AccessibleTopology[0].Segments[LabelZoneFailureDomain] != "k8s-zone-US-east" ||
+		respCreate.Volume.AccessibleTopology[0].Segments[LabelZoneRegion] != "k8s-region-US" {
+		t.Errorf("[CREATE] AccessibleTopology does not match the requested zone: %v", respCreate.Volume.AccessibleTopology)
+	}
+
+	//a datastore is only accepted in a zone with a host that mounts it
+	west := &csi.Topology{Segments: map[string]string{
+		LabelZoneRegion:        "k8s-region-US",
+		LabelZoneFailureDomain: "k8s-zone-US-west",
+	}}
+	if err = c.checkDatastoreInZone(ctx, dc1, datastoreName, vclib.TypeDatastore, topology); err != nil {
+		t.Errorf("%s should be accessible from the eastern zone: %v", datastoreName, err)
+	}
+	if err = c.checkDatastoreInZone(ctx, dc0, "east-only", vclib.TypeDatastore, west); err != ErrDatastoreNotInZone {
+		t.Errorf("A datastore missing from the western datacenter should not be in the western zone: %v", err)
+	}
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test-out-of-zone",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: "east-only",
+		},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{west},
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume on a datastore out of the zone should have failed with InvalidArgument: %v", err)
+	}
+	respCapacity, err := c.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: "east-only",
+		},
+		AccessibleTopology: west,
+	})
+	if err != nil {
+		t.Fatalf("GetCapacity failed: %v", err)
+	}
+	if respCapacity.AvailableCapacity != 0 {
+		t.Errorf("[CAPACITY] A datastore out of the zone should have no capacity: %d", respCapacity.AvailableCapacity)
+	}
+
+	//capacity of the zone
+	respCapacity, err = c.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		},
+		AccessibleTopology: topology,
+	})
+	if err != nil {
+		t.Fatalf("GetCapacity failed: %v", err)
+	}
+	if respCapacity.AvailableCapacity == 0 {
+		t.Error("[CAPACITY] The eastern zone should have free space")
+	}
+
+	//list scoped to a zone
+	config.Global.ListVolumesRegion = "k8s-region-US"
+	config.Global.ListVolumesZone = "k8s-zone-US-west"
+	respList, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	if len(respList.Entries) != 0 {
+		t.Errorf("[LIST] The western zone should have no volumes: %d", len(respList.Entries))
+	}
+
+	config.Global.ListVolumesZone = "k8s-zone-US-east"
+	respList, err = c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	if len(respList.Entries) != 1 || respList.Entries[0].Volume.VolumeId != volID {
+		t.Errorf("[LIST] The eastern zone should only have volume %s: %v", volID, respList.Entries)
+	}
+
+	//create without a zone, accessible from the zone of the datastore hosts
+	respAny, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test-any",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 4 * GbInBytes,
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume without a zone failed: %v", err)
+	}
+	if len(respAny.Volume.AccessibleTopology) != 1 ||
+		respAny.Volume.AccessibleTopology[0].Segments[LabelZoneFailureDomain] == "" ||
+		respAny.Volume.AccessibleTopology[0].Segments[LabelZoneRegion] != "k8s-region-US" {
+		t.Errorf("[CREATE] AccessibleTopology should be the zone of the datastore: %v", respAny.Volume.AccessibleTopology)
+	}
+	if _, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: respAny.Volume.VolumeId}); err != nil {
+		t.Errorf("DeleteVolume failed: %v", err)
+	}
+
+	//create in the preferred zone of the selected node, rather than in the
+	//first requisite one
+	anyDatastore := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+	}
+	zoneOf := func(resp *csi.CreateVolumeResponse) string {
+		if len(resp.Volume.AccessibleTopology) != 1 {
+			return ""
+		}
+		return resp.Volume.AccessibleTopology[0].Segments[LabelZoneFailureDomain]
+	}
+	respPreferred, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test-preferred",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters:    anyDatastore,
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{topology, west},
+			Preferred: []*csi.Topology{west},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume in the preferred zone failed: %v", err)
+	}
+	if zone := zoneOf(respPreferred); zone != "k8s-zone-US-west" {
+		t.Errorf("[CREATE] The volume should be in the preferred zone: %v", respPreferred.Volume.AccessibleTopology)
+	}
+
+	//create in the zone of the selected node when no topology is requested
+	nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := nodes.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "east-node",
+		Labels: map[string]string{
+			LabelZoneRegion:        "k8s-region-US",
+			LabelZoneFailureDomain: "k8s-zone-US-east",
+		},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	c.nodeLister = listerv1.NewNodeLister(nodes)
+	selectedNode := func(nodeName string) map[string]string {
+		return map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			ParameterSelectedNode:             nodeName,
+		}
+	}
+	respSelected, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test-selected",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters:    selectedNode("east-node"),
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume in the zone of the selected node failed: %v", err)
+	}
+	if zone := zoneOf(respSelected); zone != "k8s-zone-US-east" {
+		t.Errorf("[CREATE] The volume should be in the zone of the selected node: %v", respSelected.Volume.AccessibleTopology)
+	}
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test-unknown-node",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters:    selectedNode("enoent"),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume for a missing selected node should have failed with InvalidArgument: %v", err)
+	}
+
+	//create mid-migration, when the requested topologies and the labels of
+	//the nodes use the GA labels but the volumes are reported under both
+	config.Labels.TopologyLabels = vcfg.TopologyLabelsBoth
+	if err := nodes.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "west-node",
+		Labels: map[string]string{
+			LabelTopologyRegion: "k8s-region-US",
+			LabelTopologyZone:   "k8s-zone-US-west",
+		},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	respMigrated, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test-migrated",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters:    selectedNode("west-node"),
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume in the zone of the relabeled node failed: %v", err)
+	}
+	if zone := zoneOf(respMigrated); zone != "k8s-zone-US-west" ||
+		respMigrated.Volume.AccessibleTopology[0].Segments[LabelTopologyZone] != "k8s-zone-US-west" ||
+		respMigrated.Volume.AccessibleTopology[0].Segments[LabelTopologyRegion] != "k8s-region-US" {
+		t.Errorf("[CREATE] The volume should be in the zone of the relabeled node under both labels: %v",
+			respMigrated.Volume.AccessibleTopology)
+	}
+	eastGA := &csi.Topology{Segments: map[string]string{
+		LabelTopologyRegion: "k8s-region-US",
+		LabelTopologyZone:   "k8s-zone-US-east",
+	}}
+	respGA, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test-ga",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters:    anyDatastore,
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{eastGA, west},
+			Preferred: []*csi.Topology{eastGA},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume with the GA labels failed: %v", err)
+	}
+	if zone := zoneOf(respGA); zone != "k8s-zone-US-east" {
+		t.Errorf("[CREATE] The volume should be in the zone requested with the GA labels: %v", respGA.Volume.AccessibleTopology)
+	}
+
+	//an existing volume requested again with the GA labels is the same one
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "test-ga",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters:    anyDatastore,
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{eastGA},
+		},
+	})
+	if err != nil {
+		t.Errorf("CreateVolume of the existing volume with the GA labels failed: %v", err)
+	}
+
+	for _, resp := range []*csi.CreateVolumeResponse{respPreferred, respSelected, respMigrated, respGA} {
+		if _, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+			t.Errorf("DeleteVolume failed: %v", err)
+		}
+	}
+
 	//delete
 	reqDelete := &csi.DeleteVolumeRequest{
 		VolumeId: volID,
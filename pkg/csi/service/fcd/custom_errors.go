@@ -21,9 +21,15 @@ import "errors"
 // Error Messages
 const (
 	ListInvalidNextTokenErrMsg = "Invalid next token"
+	InvalidSnapshotIDErrMsg    = "Invalid snapshot ID"
+	NoHealthyVCenterErrMsg     = "No healthy vCenter"
+	DatastoreNotInZoneErrMsg   = "No host of the zone mounts the datastore"
 )
 
 // Error constants
 var (
 	ErrListInvalidNextToken = errors.New(ListInvalidNextTokenErrMsg)
+	ErrInvalidSnapshotID    = errors.New(InvalidSnapshotIDErrMsg)
+	ErrNoHealthyVCenter     = errors.New(NoHealthyVCenterErrMsg)
+	ErrDatastoreNotInZone   = errors.New(DatastoreNotInZoneErrMsg)
 )
@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// datastoreFilter returns the filter of the datastore allow and deny lists
+// of the config, whose patterns were checked when it was read.
+func (c *controller) datastoreFilter() *vcfg.DatastoreFilter {
+	filter, _ := vcfg.NewDatastoreFilter(c.cfg.Global.DatastoreAllowlist, c.cfg.Global.DatastoreDenylist)
+	return filter
+}
+
+// checkDatastoreAllowed fails with InvalidArgument when the datastore, or
+// datastore cluster, is excluded by the allow or deny list.
+func (c *controller) checkDatastoreAllowed(ctx context.Context,
+	datastoreType vclib.ParentDatastoreType, datastoreName string) error {
+
+	if err := c.datastoreFilter().Check(datastoreName); err != nil {
+		msg := fmt.Sprintf("Volumes cannot be created on %s %s: %v", datastoreType, datastoreName, err)
+		logging.Logger(ctx).Error(msg)
+		return status.Errorf(codes.InvalidArgument, msg)
+	}
+	return nil
+}
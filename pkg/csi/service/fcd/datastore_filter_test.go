@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestDatastoreFilter(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	named := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+	unnamed := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+	}
+
+	// a denied datastore is rejected even when the StorageClass names it
+	config.Global.DatastoreDenylist = "templates, " + myds.Name
+	_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "denied", Parameters: named})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CreateVolume should have failed with InvalidArgument: %v", err)
+	}
+	if !strings.Contains(err.Error(), "datastore-denylist") {
+		t.Errorf("Expected the error to name the deny list, got %v", err)
+	}
+
+	// and never selected
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "denied", Parameters: unnamed})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("CreateVolume should have failed with ResourceExhausted: %v", err)
+	}
+
+	// nor counted in the capacity
+	for _, params := range []map[string]string{named, unnamed} {
+		respCapacity, err := c.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: params})
+		if err != nil {
+			t.Fatalf("GetCapacity failed: %v", err)
+		}
+		if respCapacity.AvailableCapacity != 0 {
+			t.Errorf("Expected no capacity with %v, got %d", params, respCapacity.AvailableCapacity)
+		}
+	}
+
+	// a datastore the allow list does not match is rejected
+	config.Global.DatastoreDenylist = ""
+	config.Global.DatastoreAllowlist = "k8s-*"
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "unlisted", Parameters: named})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CreateVolume should have failed with InvalidArgument: %v", err)
+	}
+	if !strings.Contains(err.Error(), "datastore-allowlist") {
+		t.Errorf("Expected the error to name the allow list, got %v", err)
+	}
+
+	config.Global.DatastoreAllowlist = "k8s-*, " + myds.Name
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "allowed", Parameters: unnamed})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if parentName := respCreate.Volume.VolumeContext[AttributeFirstClassDiskParentName]; parentName != myds.Name {
+		t.Errorf("Selected datastore does not match %s != %s", myds.Name, parentName)
+	}
+	respCapacity, err := c.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: named})
+	if err != nil {
+		t.Fatalf("GetCapacity failed: %v", err)
+	}
+	if respCapacity.AvailableCapacity == 0 {
+		t.Error("Expected the capacity of the allowed datastore")
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// resolveBackingDatastore makes the datastore of the FCD of a volume the
+// one that currently owns its backing. The datastore recorded in the FCD
+// index, and the parent recorded in the volume context, are stale once
+// Storage DRS or Storage vMotion migrated the FCD, such as when its
+// datastore entered maintenance mode. The FCD index is updated when the
+// datastore changed.
+func (c *controller) resolveBackingDatastore(ctx context.Context, discoveryInfo *cm.FcdDiscoveryInfo,
+	volumeContext map[string]string) error {
+
+	logger := logging.Logger(ctx)
+
+	fcd := discoveryInfo.FCDInfo
+	datastore, err := discoveryInfo.DataCenter.GetBackingDatastore(ctx, fcd)
+	if err != nil {
+		msg := fmt.Sprintf("GetBackingDatastore(%s) failed. Err: %v", fcd.Config.Id.Id, err)
+		logger.Errorf(msg)
+		return status.Errorf(errorCode(err), msg)
+	}
+
+	if datastore != fcd.DatastoreInfo {
+		logger.Infof("Volume %s was migrated from datastore %s to datastore %s",
+			fcd.Config.Id.Id, fcd.DatastoreInfo.Info.Name, datastore.Info.Name)
+		fcd.DatastoreInfo = datastore
+		fcd.Datastore = datastore.Datastore
+		if fcd.ParentType == vclib.TypeDatastoreCluster && !inStoragePod(fcd.StoragePodInfo, datastore) {
+			fcd.ParentType = vclib.TypeDatastore
+			fcd.StoragePod = nil
+			fcd.StoragePodInfo = nil
+		}
+		c.vsphere(ctx).IndexFirstClassDisk(discoveryInfo.VcServer, fcd)
+	}
+
+	if parent := volumeContext[AttributeFirstClassDiskParentName]; len(parent) > 0 {
+		if current, _ := getParentDatastore(fcd); current != parent {
+			logger.V(2).Infof("Volume %s was provisioned on %s and is now on %s", fcd.Config.Id.Id, parent, current)
+		}
+	}
+	return nil
+}
+
+// inStoragePod returns true when a datastore is a child of a datastore
+// cluster.
+func inStoragePod(storagePod *vclib.StoragePodInfo, datastore *vclib.DatastoreInfo) bool {
+	if storagePod == nil {
+		return false
+	}
+	for _, child := range storagePod.DatastoreInfos {
+		if child.Reference() == datastore.Reference() {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDatastoreAvailable fails with Unavailable when the datastore of the
+// FCD of a volume is not accessible or is in maintenance mode, as the
+// attach would otherwise fail with a fault that does not tell why. The CO
+// retries the attach, which succeeds once the datastore is back or the FCD
+// was migrated to another datastore.
+func (c *controller) checkDatastoreAvailable(ctx context.Context, fcd *vclib.FirstClassDiskInfo) error {
+	logger := logging.Logger(ctx)
+
+	summary, err := fcd.DatastoreInfo.GetSummary(ctx)
+	if err != nil {
+		msg := fmt.Sprintf("GetSummary(%s) failed. Err: %v", fcd.DatastoreInfo.Info.Name, err)
+		logger.Errorf(msg)
+		return status.Errorf(errorCode(err), msg)
+	}
+
+	if !summary.Accessible {
+		msg := fmt.Sprintf("Datastore %s of volume %s is not accessible. Check the storage connectivity of the datastore",
+			fcd.DatastoreInfo.Info.Name, fcd.Config.Id.Id)
+		logger.Error(msg)
+		return status.Errorf(codes.Unavailable, msg)
+	}
+	switch types.DatastoreSummaryMaintenanceModeState(summary.MaintenanceMode) {
+	case types.DatastoreSummaryMaintenanceModeStateInMaintenance,
+		types.DatastoreSummaryMaintenanceModeStateEnteringMaintenance:
+		msg := fmt.Sprintf("Datastore %s of volume %s is in maintenance mode. "+
+			"The volume is attached once it is migrated to another datastore or the maintenance is over",
+			fcd.DatastoreInfo.Info.Name, fcd.Config.Id.Id)
+		logger.Error(msg)
+		return status.Errorf(codes.Unavailable, msg)
+	}
+	return nil
+}
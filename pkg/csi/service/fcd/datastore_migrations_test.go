@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestPublishMigratedVolume(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	myVM.Guest.HostName = strings.ToLower(myVM.Name)
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:          "migrated",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := respCreate.Volume.VolumeId
+
+	// the FCD was found on the datastore it was migrated from
+	discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, volumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := discoveryInfo.DataCenter
+	old := types.ManagedObjectReference{Type: "Datastore", Value: "datastore-old"}
+	discoveryInfo.FCDInfo.DatastoreInfo = &vclib.DatastoreInfo{
+		Datastore: &vclib.Datastore{Datastore: object.NewDatastore(dc.Client(), old), Datacenter: dc},
+		Info:      &types.DatastoreInfo{Name: "old"},
+	}
+	connMgr.ResetFirstClassDiskIndex()
+
+	err = c.resolveBackingDatastore(ctx, discoveryInfo, map[string]string{AttributeFirstClassDiskParentName: "old"})
+	if err != nil {
+		t.Fatalf("resolveBackingDatastore failed: %v", err)
+	}
+	if name := discoveryInfo.FCDInfo.DatastoreInfo.Info.Name; name != myds.Name {
+		t.Errorf("Expected the FCD on datastore %s, got %s", myds.Name, name)
+	}
+	if connMgr.FirstClassDiskIndexSize() != 1 {
+		t.Error("The new location of the FCD should be indexed")
+	}
+
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:      volumeID,
+		NodeId:        myVM.Name,
+		VolumeContext: map[string]string{AttributeFirstClassDiskParentName: "old"},
+	}
+
+	// no attach is attempted while the datastore is down or in maintenance
+	for _, state := range []struct {
+		accessible      bool
+		maintenanceMode types.DatastoreSummaryMaintenanceModeState
+	}{
+		{false, types.DatastoreSummaryMaintenanceModeStateNormal},
+		{true, types.DatastoreSummaryMaintenanceModeStateEnteringMaintenance},
+		{true, types.DatastoreSummaryMaintenanceModeStateInMaintenance},
+	} {
+		myds.Summary.Accessible = state.accessible
+		myds.Summary.MaintenanceMode = string(state.maintenanceMode)
+		_, err = c.ControllerPublishVolume(ctx, req)
+		if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), myds.Name) {
+			t.Errorf("accessible=%t maintenance=%s: expected Unavailable naming datastore %s, got %v",
+				state.accessible, state.maintenanceMode, myds.Name, err)
+		}
+	}
+
+	myds.Summary.Accessible = true
+	myds.Summary.MaintenanceMode = string(types.DatastoreSummaryMaintenanceModeStateNormal)
+	resp, err := c.ControllerPublishVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+	if parent := resp.PublishContext[AttributeFirstClassDiskParentName]; parent != myds.Name {
+		t.Errorf("Expected the current datastore %s in the publish context, got %s", myds.Name, parent)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sort"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// debugState is what the controller thinks its config and environment are,
+// as served by the debug endpoint. It must never hold a secret.
+type debugState struct {
+	// Config is the effective config, with the passwords redacted
+	Config *vcfg.Config `json:"config"`
+	// VCenters are the configured vCenters, sorted by name
+	VCenters []debugVCenter `json:"vcenters"`
+	// Capabilities are the controller service capabilities
+	Capabilities []string `json:"capabilities"`
+	// OnlineExpansion is true when the volumes are expanded while attached
+	OnlineExpansion bool `json:"onlineExpansion"`
+	// Zones are the zones of the hosts, when zones are configured
+	Zones []debugZone `json:"zones,omitempty"`
+	// FCDIndexSize is the number of FCDs whose location is indexed
+	FCDIndexSize int `json:"fcdIndexSize"`
+}
+
+// debugVCenter is the state of a vCenter.
+type debugVCenter struct {
+	Name string `json:"name"`
+	// Connected is true when a session is established with the vCenter
+	Connected bool `json:"connected"`
+	// APIVersion is the API version the vCenter reported when it passed
+	// its check, empty until it does
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Degraded is the error of the last failed check of a degraded vCenter
+	Degraded string `json:"degraded,omitempty"`
+	// Capabilities are the capabilities of the API version of the vCenter,
+	// empty until it passes its check
+	Capabilities []string `json:"capabilities"`
+	// OnlineExtend is true when the vCenter extends the attached FCDs
+	OnlineExtend bool `json:"onlineExtend"`
+	// VslmCatalog is true when the FCDs are found in the vslm catalog of
+	// the vCenter rather than by scanning its datastores
+	VslmCatalog bool `json:"vslmCatalog"`
+}
+
+// debugZone is the zone and region of a host.
+type debugZone struct {
+	VCenter    string `json:"vcenter"`
+	Datacenter string `json:"datacenter"`
+	Host       string `json:"host"`
+	Zone       string `json:"zone"`
+	Region     string `json:"region"`
+}
+
+// debugState returns the state of the controller. The zones are looked up
+// in the vCenters on every call, and the ones that fail to be are logged
+// and left out.
+func (c *controller) debugState(ctx context.Context) interface{} {
+	ctx, cancel := withOperationTimeout(ctx)
+	defer cancel()
+	logger := logging.Logger(ctx)
+
+	state := &debugState{
+		Config:          c.cfg.Redacted(),
+		VCenters:        []debugVCenter{},
+		OnlineExpansion: c.OnlineExpansion(),
+		FCDIndexSize:    c.connMgr.FirstClassDiskIndexSize(),
+	}
+
+	for vc, vsi := range c.connMgr.VsphereInstances() {
+		vcState := debugVCenter{
+			Name:         vc,
+			Connected:    vsi.Conn != nil && vsi.Conn.Client != nil,
+			APIVersion:   c.vcHealth.apiVersion(vc),
+			Capabilities: c.connMgr.Capabilities(vc).Names(),
+			OnlineExtend: c.onlineExtendSupported(vc),
+			VslmCatalog:  c.connMgr.VslmCatalogDetected(vc),
+		}
+		if err := c.vcHealth.degradedErr(vc); err != nil {
+			vcState.Degraded = err.Error()
+		}
+		state.VCenters = append(state.VCenters, vcState)
+	}
+	sort.Slice(state.VCenters, func(i, j int) bool {
+		return state.VCenters[i].Name < state.VCenters[j].Name
+	})
+
+	caps, _ := c.ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+	for _, capability := range caps.GetCapabilities() {
+		state.Capabilities = append(state.Capabilities, capability.GetRpc().GetType().String())
+	}
+
+	if len(c.cfg.Labels.Zone) == 0 || len(c.cfg.Labels.Region) == 0 {
+		return state
+	}
+	pairs, err := c.connMgr.ListAllVCandDCPairs(ctx)
+	if err != nil {
+		logger.Errorf("Failed to list the datacenters for the debug state. Err: %v", err)
+		return state
+	}
+	for _, pair := range pairs {
+		hostZones, err := c.connMgr.LookupHostZones(ctx, pair.DataCenter, c.cfg.Labels.Zone, c.cfg.Labels.Region)
+		if err != nil {
+			logger.Errorf("Failed to look up the zones of datacenter %s for the debug state. Err: %v",
+				pair.DataCenter.Name(), err)
+			continue
+		}
+		for _, hostZone := range hostZones {
+			state.Zones = append(state.Zones, debugZone{
+				VCenter:    pair.VcServer,
+				Datacenter: pair.DataCenter.Name(),
+				Host:       hostZone.Host.Value,
+				Zone:       hostZone.Zone,
+				Region:     hostZone.Region,
+			})
+		}
+	}
+	return state
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"golang.org/x/net/context"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestDebugState(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, true)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	vsi := connMgr.VsphereInstanceMap[config.Global.VCenterIP]
+
+	// DC0 is in a zone
+	restClient := rest.NewClient(vsi.Conn.Client)
+	user := url.UserPassword(vsi.Conn.Username, vsi.Conn.Password)
+	if err := restClient.Login(ctx, user); err != nil {
+		t.Fatalf("Rest login failed. err=%v", err)
+	}
+	m := tags.NewManager(restClient)
+	dc0, err := vclib.GetDatacenter(ctx, vsi.Conn, "DC0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for category, tag := range map[string]string{
+		config.Labels.Region: "k8s-region-US",
+		config.Labels.Zone:   "k8s-zone-US-west",
+	} {
+		categoryID, err := m.CreateCategory(ctx, &tags.Category{Name: category})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tagID, err := m.CreateTag(ctx, &tags.Tag{CategoryID: categoryID, Name: tag})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = m.AttachTag(ctx, tagID, dc0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an FCD is indexed when it is created
+	_, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "debug",
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	c.vcHealth.setAPIVersion(config.Global.VCenterIP, "6.7")
+	c.vcHealth.setDegraded(config.Global.VCenterIP, errors.New("check failed"))
+
+	data, err := json.Marshal(c.debugState(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), config.Global.Password) {
+		t.Errorf("The debug state should not contain the password:\n%s", data)
+	}
+
+	var state debugState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.VCenters) != 1 {
+		t.Fatalf("Expected 1 vCenter, got %+v", state.VCenters)
+	}
+	vc := state.VCenters[0]
+	if vc.Name != config.Global.VCenterIP || !vc.Connected || vc.APIVersion != "6.7" || vc.Degraded != "check failed" {
+		t.Errorf("Unexpected vCenter state: %+v", vc)
+	}
+	if state.FCDIndexSize != 1 {
+		t.Errorf("Expected 1 FCD in the index, got %d", state.FCDIndexSize)
+	}
+	if state.Config == nil || state.Config.Global.VCenterIP != config.Global.VCenterIP {
+		t.Errorf("The debug state should contain the config: %+v", state.Config)
+	}
+	if !strings.Contains(strings.Join(state.Capabilities, ","), csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME.String()) {
+		t.Errorf("Unexpected capabilities: %v", state.Capabilities)
+	}
+	if len(state.Zones) == 0 {
+		t.Fatal("Expected the zones of the hosts of DC0")
+	}
+	for _, zone := range state.Zones {
+		if zone.Datacenter != "DC0" || zone.Zone != "k8s-zone-US-west" || zone.Region != "k8s-region-US" {
+			t.Errorf("Unexpected zone: %+v", zone)
+		}
+	}
+}
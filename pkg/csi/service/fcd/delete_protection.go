@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// checkVolumeDetached fails with FailedPrecondition, listing the VMs, when
+// the FCD of a volume being deleted is still attached to VMs, so that the
+// CO retries the deletion once the volume is detached. With force-delete,
+// the FCD is detached from the VMs instead.
+func (c *controller) checkVolumeDetached(ctx context.Context, discoveryInfo *cm.FcdDiscoveryInfo) error {
+	logger := logging.Logger(ctx)
+
+	fcd := discoveryInfo.FCDInfo
+	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+	vms, err := fcd.DatastoreInfo.GetVMsWithDisk(ctx, filePath)
+	if err != nil {
+		msg := fmt.Sprintf("GetVMsWithDisk(%s) failed. Err: %v", filePath, err)
+		logger.Errorf(msg)
+		return status.Errorf(errorCode(err), msg)
+	}
+	if len(vms) == 0 {
+		return nil
+	}
+
+	if !c.cfg.Global.ForceDelete {
+		names := make([]string, 0, len(vms))
+		for _, vm := range vms {
+			names = append(names, vmName(ctx, vm))
+		}
+		msg := fmt.Sprintf("Volume %s is still attached to VMs %s, it is deleted once it is detached",
+			fcd.Config.Id.Id, strings.Join(names, ", "))
+		logger.Error(msg)
+		return status.Errorf(codes.FailedPrecondition, msg)
+	}
+
+	for _, vm := range vms {
+		logger.Warningf("Volume %s is attached to VM %s, detaching it to force its deletion",
+			fcd.Config.Id.Id, vmName(ctx, vm))
+		err := c.vmQueues.serialize(vmQueueKey(vm), func() error {
+			return retryTransient(ctx, func() error {
+				return vm.DetachDisk(ctx, filePath)
+			})
+		})
+		if err != nil {
+			msg := fmt.Sprintf("DetachDisk(%s = %s) failed. Err: %v", fcd.Config.Name, filePath, err)
+			logger.Errorf(msg)
+			return status.Errorf(errorCode(err), msg)
+		}
+	}
+	return nil
+}
+
+// vmName returns the name of a VM and its managed object ID, or its ID
+// alone when its name cannot be retrieved.
+func vmName(ctx context.Context, vm *vclib.VirtualMachine) string {
+	name, err := vm.ObjectName(ctx)
+	if err != nil {
+		return vm.Reference().Value
+	}
+	return fmt.Sprintf("%s (%s)", name, vm.Reference().Value)
+}
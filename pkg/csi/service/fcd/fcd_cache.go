@@ -0,0 +1,162 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/metrics"
+)
+
+// fcdCache is an inventory of the FCDs found in all of the configured
+// vCenters. Walking every datastore is expensive, so the inventory is
+// refreshed in the background, and the FCDs the controller creates, expands
+// or deletes are added, updated or removed in between.
+type fcdCache struct {
+	sync.Mutex
+
+	refreshInterval time.Duration
+
+	// scan returns the FCDs the cache is refreshed with
+	scan func(ctx context.Context) []*vclib.FirstClassDiskInfo
+
+	firstClassDisks []*vclib.FirstClassDiskInfo
+	lastRefresh     time.Time
+}
+
+// newFCDCache returns an empty inventory cache of the FCDs found in all of
+// the vCenters of the connection manager.
+func newFCDCache(connMgr connectionManager, refreshInterval time.Duration) *fcdCache {
+	return &fcdCache{
+		refreshInterval: refreshInterval,
+		scan: func(ctx context.Context) []*vclib.FirstClassDiskInfo {
+			return getAllFCDs(ctx, connMgr, defaultFCDScanOptions)
+		},
+	}
+}
+
+// run refreshes the cache on every refresh interval until the context
+// is cancelled.
+func (c *fcdCache) run(ctx context.Context) {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	c.refresh(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.refresh(ctx)
+		}
+	}
+}
+
+// refresh replaces the cached inventory with the FCDs currently found in
+// the scanned vCenters.
+func (c *fcdCache) refresh(ctx context.Context) {
+	firstClassDisks := c.scan(ctx)
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.firstClassDisks = firstClassDisks
+	c.lastRefresh = time.Now()
+	metrics.SetFCDCacheSize(len(firstClassDisks))
+
+	logging.Logger(ctx).V(4).Infof("FCD cache refreshed with %d disks", len(firstClassDisks))
+}
+
+// invalidate marks the cached inventory as stale so that the next listing
+// rescans the vCenters.
+func (c *fcdCache) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.lastRefresh = time.Time{}
+}
+
+// add adds an FCD to the cached inventory, replacing the cached FCD of the
+// same ID, such as one that was expanded.
+func (c *fcdCache) add(fcd *vclib.FirstClassDiskInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	for i, cached := range c.firstClassDisks {
+		if cached.Config.Id.Id == fcd.Config.Id.Id {
+			c.firstClassDisks[i] = fcd
+			return
+		}
+	}
+	c.firstClassDisks = append(c.firstClassDisks, fcd)
+	metrics.SetFCDCacheSize(len(c.firstClassDisks))
+}
+
+// remove removes the FCD of an ID from the cached inventory.
+func (c *fcdCache) remove(id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	firstClassDisks := make([]*vclib.FirstClassDiskInfo, 0, len(c.firstClassDisks))
+	for _, cached := range c.firstClassDisks {
+		if cached.Config.Id.Id != id {
+			firstClassDisks = append(firstClassDisks, cached)
+		}
+	}
+	c.firstClassDisks = firstClassDisks
+	metrics.SetFCDCacheSize(len(firstClassDisks))
+}
+
+// list returns the cached FCDs. The cache is rescanned when it has been
+// invalidated, or when a new listing is started and the cache is older than
+// the refresh interval. Continued listings are otherwise served from the
+// cache so that the pages remain consistent.
+func (c *fcdCache) list(ctx context.Context, startingToken string) []*vclib.FirstClassDiskInfo {
+	c.Lock()
+	stale := c.lastRefresh.IsZero() ||
+		(len(startingToken) == 0 && time.Since(c.lastRefresh) > c.refreshInterval)
+	c.Unlock()
+
+	if stale {
+		c.refresh(ctx)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	firstClassDisks := make([]*vclib.FirstClassDiskInfo, len(c.firstClassDisks))
+	copy(firstClassDisks, c.firstClassDisks)
+	return firstClassDisks
+}
+
+// capacities returns the capacity in MB of the cached FCDs by ID, without
+// rescanning the vCenters.
+func (c *fcdCache) capacities() map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+
+	capacities := make(map[string]int64, len(c.firstClassDisks))
+	for _, fcd := range c.firstClassDisks {
+		capacities[fcd.Config.Id.Id] = fcd.Config.CapacityInMB
+	}
+	return capacities
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestFCDCache(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	c.fcdCache = newFCDCache(connMgr, time.Hour)
+
+	//context
+	ctx := context.Background()
+
+	if count := len(c.fcdCache.list(ctx, "")); count != 0 {
+		t.Errorf("Excepting an empty cache got %d", count)
+	}
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "test",
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	// creating a volume adds it to the cache
+	if count := len(c.fcdCache.list(ctx, "")); count != 1 {
+		t.Errorf("Excepting 1 cached volume got %d", count)
+	}
+	if _, ok := c.fcdCache.capacities()[respCreate.Volume.VolumeId]; !ok {
+		t.Errorf("Expecting the capacity of volume %s to be cached", respCreate.Volume.VolumeId)
+	}
+
+	// a disk created behind the controller's back is not seen until the
+	// cache is refreshed
+	dc, err := vclib.GetDatacenter(ctx, connMgr.VsphereInstanceMap[config.Global.VCenterIP].Conn, vclib.TestDefaultDatacenter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dc.CreateFirstClassDisk(ctx, myds.Name, vclib.TypeDatastore, "hidden", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := len(c.fcdCache.list(ctx, "")); count != 1 {
+		t.Errorf("Excepting 1 cached volume got %d", count)
+	}
+
+	c.fcdCache.refresh(ctx)
+	if count := len(c.fcdCache.list(ctx, "")); count != 2 {
+		t.Errorf("Excepting 2 cached volumes got %d", count)
+	}
+
+	// expanding a volume updates its cached capacity
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      respCreate.Volume.VolumeId,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * GbInBytes},
+	})
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume failed: %v", err)
+	}
+	if sizeMB := c.fcdCache.capacities()[respCreate.Volume.VolumeId]; sizeMB != 2*GbInBytes/MbInBytes {
+		t.Errorf("Expecting the cached capacity of volume %s to be %d MB got %d",
+			respCreate.Volume.VolumeId, 2*GbInBytes/MbInBytes, sizeMB)
+	}
+
+	// deleting a volume removes it from the cache
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{
+		VolumeId: respCreate.Volume.VolumeId,
+	})
+	if err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+
+	if count := len(c.fcdCache.list(ctx, "")); count != 1 {
+		t.Errorf("Excepting 1 cached volume got %d", count)
+	}
+
+	// neither rescans the vCenters
+	err = dc.CreateFirstClassDisk(ctx, myds.Name, vclib.TypeDatastore, "hidden-too", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "test-too",
+		Parameters: params,
+	}); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if count := len(c.fcdCache.list(ctx, "")); count != 2 {
+		t.Errorf("Excepting 2 cached volumes got %d", count)
+	}
+}
@@ -0,0 +1,415 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	volumeutil "k8s.io/kubernetes/pkg/volume/util"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// supportedFileFsTypes are the fs types a vSAN file share may be mounted
+// with. An empty fs type selects NFSv4.1.
+var supportedFileFsTypes = map[string]bool{
+	"":     true,
+	"nfs":  true,
+	"nfs4": true,
+}
+
+// isFileVolume returns whether a volume is backed by a vSAN file share.
+func isFileVolume(volumeID string) bool {
+	return strings.HasPrefix(volumeID, FileVolumeIDPrefix)
+}
+
+// fileVolumeID returns the ID of the volume backed by a vSAN file share,
+// which locates the share without searching the vCenters for it.
+func fileVolumeID(vcServer string, cluster types.ManagedObjectReference, shareUUID string) string {
+	return FileVolumeIDPrefix + strings.Join([]string{vcServer, cluster.Value, shareUUID}, "/")
+}
+
+// parseFileVolumeID returns the vCenter, cluster and share UUID of the
+// volume backed by a vSAN file share.
+func parseFileVolumeID(volumeID string) (string, types.ManagedObjectReference, string, error) {
+	parts := strings.Split(strings.TrimPrefix(volumeID, FileVolumeIDPrefix), "/")
+	if !isFileVolume(volumeID) || len(parts) != 3 ||
+		len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return "", types.ManagedObjectReference{}, "", fmt.Errorf("invalid vSAN file share volume ID %s", volumeID)
+	}
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: parts[1]}
+	return parts[0], cluster, parts[2], nil
+}
+
+// validateFileVolumeCapabilities validates the capabilities of a volume
+// backed by a vSAN file share, which may only be mounted, by any number of
+// nodes.
+func validateFileVolumeCapabilities(volCaps []*csi.VolumeCapability) error {
+	if len(volCaps) == 0 {
+		return fmt.Errorf("no volume capabilities provided")
+	}
+
+	for _, volCap := range volCaps {
+		mount := volCap.GetMount()
+		if mount == nil {
+			return fmt.Errorf("unsupported access type, only mount is supported by vSAN file shares")
+		}
+		if !supportedFileFsTypes[mount.GetFsType()] {
+			return fmt.Errorf("unsupported fs type %s", mount.GetFsType())
+		}
+		if volCap.GetAccessMode() == nil {
+			return fmt.Errorf("access mode is required")
+		}
+		if mode := volCap.GetAccessMode().GetMode(); mode == csi.VolumeCapability_AccessMode_UNKNOWN {
+			return fmt.Errorf("unsupported access mode %s", mode)
+		}
+	}
+
+	return nil
+}
+
+// createFileVolume provisions a volume backed by a file share of the vSAN
+// file service of the cluster of the vSAN datastore named by the parent
+// name parameter. The quota of the share is the requested capacity. A
+// retried request gets the existing share back, as long as its quota is the
+// same.
+func (c *controller) createFileVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest,
+	volName string) (
+	*csi.CreateVolumeResponse, error) {
+
+	logger := logging.Logger(ctx)
+	params := req.GetParameters()
+
+	if volCaps := req.GetVolumeCapabilities(); len(volCaps) > 0 {
+		if err := validateFileVolumeCapabilities(volCaps); err != nil {
+			msg := fmt.Sprintf("Volume capabilities are not supported. Err: %v", err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+	}
+
+	if req.GetVolumeContentSource() != nil {
+		msg := fmt.Sprintf("Volume %s is a vSAN file share, which cannot be provisioned from a content source", volName)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	datastoreName := params[AttributeFirstClassDiskParentName]
+	if len(datastoreName) == 0 {
+		msg := fmt.Sprintf("Volume parameter %s is a required parameter for %s %s.",
+			AttributeFirstClassDiskParentName, AttributeFilesystem, FilesystemVsanFile)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+	zone := params[AttributeFirstClassDiskZone]
+	region := params[AttributeFirstClassDiskRegion]
+	policyName := params[AttributeFirstClassDiskStoragePolicyName]
+
+	// Quota
+	volSizeBytes, _, err := c.requestedVolumeSize(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	volSizeMB := volumeutil.RoundUpSize(volSizeBytes, MbInBytes)
+	if err := c.checkVolumeSize(ctx, volSizeMB, req.GetCapacityRange()); err != nil {
+		return nil, err
+	}
+	quota := fmt.Sprintf("%dM", volSizeMB)
+
+	topologies, err := c.requestedTopologies(ctx, req)
+	if apierrors.IsNotFound(err) {
+		msg := fmt.Sprintf("Selected node %s not found", params[ParameterSelectedNode])
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("Failed to retrieve the topology of selected node %s. Err: %v", params[ParameterSelectedNode], err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	discoveryInfo, topology, err := c.whichVCandDCByTopology(ctx, topologies, zone, region,
+		datastoreName, vclib.TypeDatastore)
+	if err == cm.ErrMultiDCRequiresZones {
+		discoveryInfo, _, err = c.selectDatacenter(ctx, datastoreName, vclib.TypeDatastore, volName, 0)
+		if err == vclib.ErrNoDatastoreFound {
+			msg := fmt.Sprintf("No datacenter has the %s %s", vclib.TypeDatastore, datastoreName)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		} else if err != nil {
+			msg := fmt.Sprintf("Failed to select a datacenter for volume %s. Err: %v", volName, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+	} else if err == ErrDatastoreNotInZone {
+		msg := fmt.Sprintf("No host of the requested zones mounts %s %s", vclib.TypeDatastore, datastoreName)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if err == vclib.ErrNoZoneRegionFound {
+		msg := fmt.Sprintf("No vCenter/Datacenter found in zone %s region %s", zone, region)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("Failed to retrieve VC/DC based on zone %s. Err: %v", zone, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	// The file service of the cluster of the vSAN datastore serves the share
+	dsInfo, err := discoveryInfo.DataCenter.GetDatastoreByName(ctx, datastoreName)
+	if vclib.IsNotFound(err) {
+		msg := fmt.Sprintf("Datastore %s not found in datacenter %s", datastoreName, discoveryInfo.DataCenter.Name())
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("GetDatastoreByName(%s) failed. Err: %v", datastoreName, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	cluster, err := dsInfo.GetVsanCluster(ctx)
+	if err == vclib.ErrNotVsanDatastore {
+		msg := fmt.Sprintf("Datastore %s is not a vSAN datastore, which %s %s volumes require",
+			datastoreName, AttributeFilesystem, FilesystemVsanFile)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.InvalidArgument, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("Failed to retrieve the vSAN cluster of datastore %s. Err: %v", datastoreName, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	// Storage Policy
+	var storagePolicy *types.VirtualMachineDefinedProfileSpec
+	if len(policyName) > 0 {
+		pbmClient, err := vclib.NewPbmClient(ctx, discoveryInfo.DataCenter.Client())
+		if err != nil {
+			msg := fmt.Sprintf("NewPbmClient failed. Err: %v", err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		profileID, err := pbmClient.ProfileIDByName(ctx, policyName)
+		if err != nil {
+			msg := fmt.Sprintf("Storage policy %s not found. Err: %v", policyName, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		storagePolicy = &types.VirtualMachineDefinedProfileSpec{ProfileId: profileID}
+	}
+
+	fs, err := c.fileShareOps(ctx, discoveryInfo.VcServer)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to connect to the vSAN file service of vCenter %s. Err: %v", discoveryInfo.VcServer, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	share, err := fs.GetFileShare(ctx, cluster, "", volName)
+	if err == vclib.ErrNoFileShareFound {
+		allowRoot := true
+		config := vclib.VsanFileShareConfig{
+			Name:          volName,
+			Quota:         quota,
+			StoragePolicy: storagePolicy,
+			Permission: []vclib.VsanFileShareNetPermission{
+				{Ips: "*", Permissions: vclib.VsanFileShareReadWrite, AllowRoot: &allowRoot},
+			},
+			Protocols: []string{vclib.VsanFileShareProtocolNFSv4},
+		}
+		if clusterID := c.cfg.Global.ClusterID; len(clusterID) > 0 {
+			config.Labels = []types.KeyValue{{Key: OwnerTagCategory, Value: clusterID}}
+		}
+
+		exists := func() (bool, error) {
+			_, err := fs.GetFileShare(ctx, cluster, "", volName)
+			if err == vclib.ErrNoFileShareFound {
+				return false, nil
+			}
+			return err == nil, err
+		}
+		err = retryCreate(ctx, exists, func() error {
+			return fs.CreateFileShare(ctx, cluster, config)
+		})
+		if _, ok := err.(*vclib.TaskInProgressError); ok {
+			msg := fmt.Sprintf("Creation of volume %s is still in progress", volName)
+			logger.Warning(msg)
+			return nil, status.Errorf(codes.DeadlineExceeded, msg)
+		} else if err != nil {
+			msg := fmt.Sprintf("CreateFileShare(%s) failed. Err: %v", volName, err)
+			logger.Errorf(msg)
+			return nil, status.Errorf(errorCode(err), msg)
+		}
+		share, err = fs.GetFileShare(ctx, cluster, "", volName)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("GetFileShare(%s) failed. Err: %v", volName, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if share.Config != nil && share.Config.Quota != quota {
+		msg := fmt.Sprintf("Volume %s already exists with different parameters: quota %s, requested %s",
+			volName, share.Config.Quota, quota)
+		logger.Errorf(msg)
+		return nil, status.Errorf(codes.AlreadyExists, msg)
+	}
+
+	accessPoint := share.AccessPoint(vclib.VsanFileShareAccessPointNFSv4)
+	if len(accessPoint) == 0 {
+		msg := fmt.Sprintf("Volume %s has no %s access point", volName, vclib.VsanFileShareAccessPointNFSv4)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.Internal, msg)
+	}
+
+	attributes := make(map[string]string)
+	attributes[AttributeFirstClassDiskType] = FileShareTypeString
+	attributes[AttributeFirstClassDiskVcenter] = discoveryInfo.VcServer
+	attributes[AttributeFirstClassDiskDatacenter] = discoveryInfo.DataCenter.Name()
+	attributes[AttributeFirstClassDiskName] = volName
+	if req.GetName() != volName {
+		attributes[AttributeFirstClassDiskRequestedName] = req.GetName()
+	}
+	attributes[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	attributes[AttributeFirstClassDiskParentName] = datastoreName
+	attributes[AttributeFilesystem] = FilesystemVsanFile
+	attributes[AttributeFileShareAccessPoint] = accessPoint
+
+	resp := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      fileVolumeID(discoveryInfo.VcServer, cluster, share.UUID),
+			CapacityBytes: volSizeMB * MbInBytes,
+			VolumeContext: attributes,
+		},
+	}
+
+	// The share is reachable over the network from any zone, so it is only
+	// constrained to the zone it was requested in
+	if topology != nil {
+		resp.Volume.AccessibleTopology = []*csi.Topology{topology}
+	}
+
+	return resp, nil
+}
+
+// deleteFileVolume removes the vSAN file share of a volume. A share that
+// no longer exists is considered deleted.
+func (c *controller) deleteFileVolume(ctx context.Context, volumeID string) (*csi.DeleteVolumeResponse, error) {
+	logger := logging.Logger(ctx)
+
+	vcServer, cluster, shareUUID, err := parseFileVolumeID(volumeID)
+	if err != nil {
+		logger.Warningf("Failed to parse volume ID %s. Err: %v", volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	fs, err := c.fileShareOps(ctx, vcServer)
+	if err == cm.ErrConnectionNotFound {
+		logger.Warningf("vCenter %s of volume %s is not configured. Err: %v", vcServer, volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	} else if err != nil {
+		msg := fmt.Sprintf("Failed to connect to the vSAN file service of vCenter %s. Err: %v", vcServer, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	_, err = fs.GetFileShare(ctx, cluster, shareUUID, "")
+	if err == vclib.ErrNoFileShareFound {
+		logger.Warningf("File share of volume %s not found. Err: %v", volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	} else if err != nil {
+		msg := fmt.Sprintf("GetFileShare(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	err = retryTransient(ctx, func() error {
+		return fs.RemoveFileShare(ctx, cluster, shareUUID)
+	})
+	if _, ok := err.(*vclib.TaskInProgressError); ok {
+		msg := fmt.Sprintf("Deletion of volume %s is still in progress", volumeID)
+		logger.Warning(msg)
+		return nil, status.Errorf(codes.DeadlineExceeded, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("RemoveFileShare(%s) failed. Err: %v", volumeID, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// validateFileVolume validates the capabilities of a volume backed by a
+// vSAN file share, which must exist.
+func (c *controller) validateFileVolume(
+	ctx context.Context,
+	req *csi.ValidateVolumeCapabilitiesRequest) (
+	*csi.ValidateVolumeCapabilitiesResponse, error) {
+
+	logger := logging.Logger(ctx)
+
+	vcServer, cluster, shareUUID, err := parseFileVolumeID(req.VolumeId)
+	if err != nil {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	}
+
+	fs, err := c.fileShareOps(ctx, vcServer)
+	if err == cm.ErrConnectionNotFound {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("Failed to connect to the vSAN file service of vCenter %s. Err: %v", vcServer, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	_, err = fs.GetFileShare(ctx, cluster, shareUUID, "")
+	if err == vclib.ErrNoFileShareFound {
+		msg := fmt.Sprintf("Volume %s not found", req.VolumeId)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("GetFileShare(%s) failed. Err: %v", req.VolumeId, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+
+	if err := validateFileVolumeCapabilities(req.VolumeCapabilities); err != nil {
+		logger.V(2).Infof("Volume %s does not support the requested capabilities. Err: %v", req.VolumeId, err)
+		return &csi.ValidateVolumeCapabilitiesResponse{
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.VolumeContext,
+			VolumeCapabilities: req.VolumeCapabilities,
+			Parameters:         req.Parameters,
+		},
+	}, nil
+}
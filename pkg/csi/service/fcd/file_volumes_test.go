@@ -0,0 +1,256 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// fakeFileService keeps the file shares of the vSAN file service in memory,
+// as vcsim does not simulate it.
+type fakeFileService struct {
+	shares map[string]vclib.VsanFileShare
+}
+
+func (f *fakeFileService) CreateFileShare(ctx context.Context,
+	cluster types.ManagedObjectReference, config vclib.VsanFileShareConfig) error {
+	uuid := fmt.Sprintf("share-%d", len(f.shares)+1)
+	f.shares[uuid] = vclib.VsanFileShare{
+		UUID:   uuid,
+		Config: &config,
+		Runtime: &vclib.VsanFileShareRuntimeInfo{
+			AccessPoints: []types.KeyValue{
+				{Key: vclib.VsanFileShareAccessPointNFSv4, Value: "10.0.0.1:/vsanfs/" + config.Name},
+			},
+		},
+	}
+	return nil
+}
+
+func (f *fakeFileService) RemoveFileShare(ctx context.Context,
+	cluster types.ManagedObjectReference, uuid string) error {
+	if _, ok := f.shares[uuid]; !ok {
+		return vclib.ErrNoFileShareFound
+	}
+	delete(f.shares, uuid)
+	return nil
+}
+
+func (f *fakeFileService) GetFileShare(ctx context.Context,
+	cluster types.ManagedObjectReference, uuid string, name string) (*vclib.VsanFileShare, error) {
+	for _, share := range f.shares {
+		if share.UUID == uuid || (len(uuid) == 0 && share.Config.Name == name) {
+			return &share, nil
+		}
+	}
+	return nil, vclib.ErrNoFileShareFound
+}
+
+func TestParseFileVolumeID(t *testing.T) {
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "domain-c7"}
+	volumeID := fileVolumeID("vc.example.com", cluster, "share-1")
+	if volumeID != "file:vc.example.com/domain-c7/share-1" {
+		t.Errorf("Unexpected volume ID %s", volumeID)
+	}
+
+	vc, parsedCluster, shareUUID, err := parseFileVolumeID(volumeID)
+	if err != nil {
+		t.Fatalf("parseFileVolumeID(%s) failed: %v", volumeID, err)
+	}
+	if vc != "vc.example.com" || parsedCluster != cluster || shareUUID != "share-1" {
+		t.Errorf("Unexpected parse of %s: %s %v %s", volumeID, vc, parsedCluster, shareUUID)
+	}
+
+	for _, invalid := range []string{"", "file:", "file:vc/share-1", "file:vc//share-1", "vc/domain-c7/share-1"} {
+		if _, _, _, err := parseFileVolumeID(invalid); err == nil {
+			t.Errorf("parseFileVolumeID(%q) should have failed", invalid)
+		}
+	}
+}
+
+func TestFileVolumeFlow(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	fs := &fakeFileService{shares: make(map[string]vclib.VsanFileShare)}
+	c.hooks = vsphereHooks{
+		fileService: func(*vclib.VsanFileServiceClient) fileShareOps {
+			return fs
+		},
+	}
+
+	ctx := context.Background()
+
+	mycluster := simulator.Map.Any("ClusterComputeResource").(*simulator.ClusterComputeResource)
+
+	params := map[string]string{
+		AttributeFilesystem:               FilesystemVsanFile,
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+	rwx := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		},
+	}
+	block := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		},
+	}
+	createVolume := func(params map[string]string, volCaps []*csi.VolumeCapability,
+		size int64) (*csi.CreateVolumeResponse, error) {
+		return c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               "rwx",
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: size},
+			Parameters:         params,
+			VolumeCapabilities: volCaps,
+		})
+	}
+
+	invalid := []struct {
+		name    string
+		params  map[string]string
+		volCaps []*csi.VolumeCapability
+	}{
+		{"unknown filesystem", map[string]string{AttributeFilesystem: "nfs"}, rwx},
+		{"missing datastore", map[string]string{AttributeFilesystem: FilesystemVsanFile}, rwx},
+		{"block access", params, block},
+		{"VMFS datastore", params, rwx},
+	}
+	for _, test := range invalid {
+		if _, err := createVolume(test.params, test.volCaps, GbInBytes); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("[%s] CreateVolume should have failed with InvalidArgument: %v", test.name, err)
+		}
+	}
+
+	// the datastore becomes the vSAN datastore of the cluster
+	myds.Summary.Type = vclib.VsanDatastoreType
+	myds.Host = []types.DatastoreHostMount{{Key: mycluster.Host[0]}}
+
+	respCreate, err := createVolume(params, rwx, GbInBytes)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := respCreate.Volume.VolumeId
+	if !strings.HasPrefix(volumeID, FileVolumeIDPrefix+config.Global.VCenterIP+"/"+mycluster.Self.Value+"/") {
+		t.Errorf("Unexpected volume ID %s", volumeID)
+	}
+	if ap := respCreate.Volume.VolumeContext[AttributeFileShareAccessPoint]; ap != "10.0.0.1:/vsanfs/rwx" {
+		t.Errorf("Unexpected access point %q", ap)
+	}
+	if respCreate.Volume.CapacityBytes != GbInBytes {
+		t.Errorf("Unexpected capacity %d", respCreate.Volume.CapacityBytes)
+	}
+	if len(fs.shares) != 1 {
+		t.Fatalf("Expected one file share, got %d", len(fs.shares))
+	}
+	for _, share := range fs.shares {
+		if share.Config.Quota != "1024M" {
+			t.Errorf("Unexpected quota %s", share.Config.Quota)
+		}
+		if len(share.Config.Protocols) != 1 || share.Config.Protocols[0] != vclib.VsanFileShareProtocolNFSv4 {
+			t.Errorf("Unexpected protocols %v", share.Config.Protocols)
+		}
+	}
+
+	// a retried request returns the same volume, unless its size differs
+	respRetry, err := createVolume(params, rwx, GbInBytes)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if respRetry.Volume.VolumeId != volumeID {
+		t.Errorf("Retried volume does not match %s != %s", volumeID, respRetry.Volume.VolumeId)
+	}
+	if _, err = createVolume(params, rwx, 2*GbInBytes); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateVolume with a different size should have failed with AlreadyExists: %v", err)
+	}
+
+	// nothing is attached to the nodes
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volumeID,
+		NodeId:           "not-a-node",
+		VolumeCapability: rwx[0],
+	})
+	if err != nil {
+		t.Errorf("ControllerPublishVolume failed: %v", err)
+	}
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: volumeID,
+		NodeId:   "not-a-node",
+	})
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume failed: %v", err)
+	}
+
+	respValidate, err := c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           volumeID,
+		VolumeCapabilities: rwx,
+	})
+	if err != nil || respValidate.Confirmed == nil {
+		t.Errorf("ValidateVolumeCapabilities should confirm RWX: %v %v", respValidate, err)
+	}
+	respValidate, err = c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           volumeID,
+		VolumeCapabilities: block,
+	})
+	if err != nil || respValidate.Confirmed != nil {
+		t.Errorf("ValidateVolumeCapabilities should not confirm block access: %v %v", respValidate, err)
+	}
+
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * GbInBytes},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerExpandVolume should have failed with InvalidArgument: %v", err)
+	}
+	_, err = c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: volumeID, Name: "snap"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateSnapshot should have failed with InvalidArgument: %v", err)
+	}
+
+	// deleting removes the share, and deleting again succeeds
+	for i := 0; i < 2; i++ {
+		if _, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			t.Fatalf("DeleteVolume failed: %v", err)
+		}
+	}
+	if len(fs.shares) != 0 {
+		t.Errorf("The file share should have been removed: %v", fs.shares)
+	}
+	_, err = c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           volumeID,
+		VolumeCapabilities: rwx,
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ValidateVolumeCapabilities of a deleted volume should have failed with NotFound: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// hostDatastoresTTL is how long the datastores accessible from a host are
+// cached. It is kept short so that a fixed storage connectivity is soon
+// picked up.
+var hostDatastoresTTL = time.Minute
+
+// hostDatastores caches the datastores accessible from each host, keyed by
+// vCenter and host, so that the attaches to the VMs of a host do not
+// retrieve them every time. The zero value is ready to use.
+type hostDatastores struct {
+	sync.Mutex
+
+	hosts map[string]hostDatastoresEntry
+}
+
+type hostDatastoresEntry struct {
+	datastores *vclib.HostDatastores
+	expires    time.Time
+}
+
+func hostDatastoresKey(vcServer string, host string) string {
+	return vcServer + "/" + host
+}
+
+// get returns the cached datastores of the host, or nil when there are none
+// or they expired.
+func (h *hostDatastores) get(vcServer string, host string) *vclib.HostDatastores {
+	h.Lock()
+	defer h.Unlock()
+
+	entry, ok := h.hosts[hostDatastoresKey(vcServer, host)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+	return entry.datastores
+}
+
+// set caches the datastores of the host.
+func (h *hostDatastores) set(vcServer string, datastores *vclib.HostDatastores) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.hosts == nil {
+		h.hosts = make(map[string]hostDatastoresEntry)
+	}
+	h.hosts[hostDatastoresKey(vcServer, datastores.Host.Value)] = hostDatastoresEntry{
+		datastores: datastores,
+		expires:    time.Now().Add(hostDatastoresTTL),
+	}
+}
+
+// checkDatastoreAccessible returns a FailedPrecondition error when the
+// datastore of the FCD is not accessible from the host the VM of the node
+// runs on, as the attach would fail. The datastores of the host are
+// retrieved again before the attach is refused, in case the host was just
+// given access.
+func (c *controller) checkDatastoreAccessible(ctx context.Context, vcServer string,
+	nodeID string, vm *vclib.VirtualMachine, fcd *vclib.FirstClassDiskInfo) error {
+
+	logger := logging.Logger(ctx)
+
+	host, err := vm.HostSystem(ctx)
+	if err != nil {
+		msg := fmt.Sprintf("HostSystem(%s) failed. Err: %v", vm.Reference().Value, err)
+		logger.Errorf(msg)
+		return status.Errorf(errorCode(err), msg)
+	}
+	ds := fcd.DatastoreInfo.Reference()
+
+	datastores := c.hostDatastores.get(vcServer, host.Reference().Value)
+	if datastores != nil && datastores.Accessible(ds) {
+		return nil
+	}
+	datastores, err = vclib.GetHostDatastores(ctx, host)
+	if err != nil {
+		msg := fmt.Sprintf("GetHostDatastores(%s) failed. Err: %v", host.Reference().Value, err)
+		logger.Errorf(msg)
+		return status.Errorf(errorCode(err), msg)
+	}
+	c.hostDatastores.set(vcServer, datastores)
+
+	if !datastores.Accessible(ds) {
+		msg := fmt.Sprintf("Datastore %s of volume %s is not accessible from host %s of node %s. "+
+			"Check the storage connectivity of the host or the topology of the volume",
+			fcd.DatastoreInfo.Info.Name, fcd.Config.Id.Id, datastores.Name, nodeID)
+		logger.Error(msg)
+		return status.Errorf(codes.FailedPrecondition, msg)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestHostDatastores(t *testing.T) {
+	var hosts hostDatastores
+
+	if ds := hosts.get("vc1", "host-1"); ds != nil {
+		t.Fatalf("Expected no datastores for an unknown host, got %v", ds)
+	}
+
+	ds := &vclib.HostDatastores{Host: types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"}}
+	hosts.set("vc1", ds)
+	if hosts.get("vc1", "host-1") != ds {
+		t.Error("Failed to get the cached datastores")
+	}
+	if hosts.get("vc2", "host-1") != nil {
+		t.Error("Got the datastores of a host of another vCenter")
+	}
+
+	ttl := hostDatastoresTTL
+	defer func() { hostDatastoresTTL = ttl }()
+	hostDatastoresTTL = -time.Second
+	hosts.set("vc1", ds)
+	if hosts.get("vc1", "host-1") != nil {
+		t.Error("Got expired datastores")
+	}
+}
+
+func TestPublishToHostWithoutDatastore(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	myVM.Guest.HostName = strings.ToLower(myVM.Name)
+	host := simulator.Map.Get(*myVM.Runtime.Host).(*simulator.HostSystem)
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: GbInBytes,
+		},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	// the host loses access to the datastore
+	mounted := host.Datastore
+	host.Datastore = nil
+	for _, ref := range mounted {
+		if ref != myds.Reference() {
+			host.Datastore = append(host.Datastore, ref)
+		}
+	}
+
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId: respCreate.Volume.VolumeId,
+		NodeId:   myVM.Name,
+	}
+	_, err = c.ControllerPublishVolume(ctx, req)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Expected FailedPrecondition, got %v", err)
+	}
+	for _, name := range []string{myds.Name, host.Name, myVM.Name} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected %s in the error, got %v", name, err)
+		}
+	}
+
+	// the refusal is not cached
+	host.Datastore = mounted
+	if _, err = c.ControllerPublishVolume(ctx, req); err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// inFlightRPCs tracks the CSI RPCs in flight, so that they can be drained
+// on shutdown. The zero value is ready to use.
+type inFlightRPCs struct {
+	sync.Mutex
+
+	// the sequence number of the next RPC
+	next uint64
+	// the RPCs in flight, by sequence number
+	operations operationTracker
+}
+
+// begin tracks an RPC. It returns false when the RPCs are being drained.
+// Otherwise it returns the context of the RPC, which is cancelled when the
+// RPC outlives the drain timeout, and the func that ends the RPC.
+func (r *inFlightRPCs) begin(ctx context.Context) (context.Context, func(), bool) {
+	r.Lock()
+	key := strconv.FormatUint(r.next, 10)
+	r.next++
+	r.Unlock()
+
+	ctx, ok := r.operations.tryStart(ctx, key)
+	if !ok {
+		return ctx, nil, false
+	}
+	return ctx, func() { r.operations.finish(key) }, true
+}
+
+// drain refuses the RPCs from now on and waits for the ones in flight to
+// return. When the context is done first, the contexts of these RPCs are
+// cancelled and drain waits for them to return. It returns the number of
+// RPCs that were cancelled.
+func (r *inFlightRPCs) drain(ctx context.Context) int {
+	return r.operations.drain(ctx)
+}
+
+// UnaryServerInterceptor tracks the CSI RPCs in flight, which Shutdown
+// drains. The RPCs received once the controller is shutting down fail with
+// Unavailable, so that they are retried against another replica.
+func (c *controller) UnaryServerInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, end, ok := c.rpcs.begin(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "The controller is shutting down")
+	}
+	defer end()
+
+	return handler(ctx, req)
+}
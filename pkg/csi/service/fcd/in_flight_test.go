@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInFlightRPCsDrain(t *testing.T) {
+	c := &controller{}
+	ctx := context.Background()
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/ListVolumes"}
+
+	// an RPC that holds no volume lock outlives the drain timeout
+	started := make(chan context.Context, 1)
+	returned := make(chan error, 1)
+	go func() {
+		_, err := c.UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			started <- ctx
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		returned <- err
+	}()
+	slowCtx := <-started
+
+	drainCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if cancelled := c.rpcs.drain(drainCtx); cancelled != 1 {
+		t.Errorf("drain should cancel 1 RPC: %d", cancelled)
+	}
+	if slowCtx.Err() != context.Canceled {
+		t.Errorf("the context of the slow RPC should be cancelled: %v", slowCtx.Err())
+	}
+	if err := <-returned; err != context.Canceled {
+		t.Errorf("the slow RPC should return once cancelled: %v", err)
+	}
+
+	// the RPCs received once draining are refused
+	_, err := c.UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		t.Error("the handler should not be called after the drain")
+		return nil, nil
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("an RPC after the drain should fail with Unavailable: %v", err)
+	}
+}
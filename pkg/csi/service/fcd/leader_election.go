@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+// leaderElectionName is the name of the ConfigMap holding the lease of the
+// leader of the CSI controllers.
+var leaderElectionName = "vsphere-csi-controller"
+
+// leaderElectionRetryPeriod is how often the replicas try to acquire the
+// lease, and the leader to renew it.
+var leaderElectionRetryPeriod = 2 * time.Second
+
+// newEventRecorder returns a recorder of the events of the CSI controller
+// in a namespace, or in the namespaces of the objects with NamespaceAll.
+func newEventRecorder(client clientset.Interface, namespace string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(namespace),
+	})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: leaderElectionName})
+}
+
+// leaderElectionConfig returns the configuration of the election of the
+// leader among the replicas of the CSI controller, which runs the
+// background work of the controller while it holds the lease.
+func (c *controller) leaderElectionConfig(ctx context.Context, client clientset.Interface,
+	identity string) (leaderelection.LeaderElectionConfig, error) {
+
+	recorder := newEventRecorder(client, c.cfg.Global.LeaderElectNamespace)
+
+	lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock,
+		c.cfg.Global.LeaderElectNamespace, leaderElectionName, client.CoreV1(),
+		resourcelock.ResourceLockConfig{Identity: identity, EventRecorder: recorder})
+	if err != nil {
+		return leaderelection.LeaderElectionConfig{}, err
+	}
+
+	config := leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: time.Duration(c.cfg.Global.LeaderElectLeaseDurationSecs) * time.Second,
+		RenewDeadline: time.Duration(c.cfg.Global.LeaderElectRenewDeadlineSecs) * time.Second,
+		RetryPeriod:   leaderElectionRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				c.lead(ctx, stop)
+			},
+			OnStoppedLeading: func() {
+				klog.Warningf("%s stopped leading the CSI controllers", identity)
+			},
+			OnNewLeader: func(leader string) {
+				klog.Infof("%s leads the CSI controllers", leader)
+			},
+		},
+	}
+
+	// The configuration is checked before the election is started
+	if _, err := leaderelection.NewLeaderElector(config); err != nil {
+		return leaderelection.LeaderElectionConfig{}, err
+	}
+	return config, nil
+}
+
+// runLeaderElection takes part in the election of the leader until the
+// context is cancelled. A replica that stopped leading stands for election
+// again.
+func (c *controller) runLeaderElection(ctx context.Context, config leaderelection.LeaderElectionConfig) {
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(config)
+		if err != nil {
+			klog.Errorf("Failed to start the leader election. Err: %v", err)
+			return
+		}
+		elector.Run()
+	}
+}
+
+// lead runs the background work of the controller until the leadership is
+// lost or the context is cancelled. The FCD index and cache are then handed
+// over: the index is emptied and the cache invalidated, as the new leader
+// may create and delete FCDs that this replica would not see.
+func (c *controller) lead(ctx context.Context, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	c.runBackground(ctx)
+
+	c.connMgr.ResetFirstClassDiskIndex()
+	c.invalidateFCDs()
+}
+
+// runBackground runs the refreshes of the FCD cache and index, the scans
+// for the orphaned FCDs, the pushes of the CNS metadata and the attachment
+// reconciles, and waits for them to stop once the context is cancelled.
+func (c *controller) runBackground(ctx context.Context) {
+	var wg sync.WaitGroup
+	run := func(f func(ctx context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(ctx)
+		}()
+	}
+
+	run(c.fcdCache.run)
+
+	if interval := c.cfg.Global.OrphanedVolumeGCIntervalSecs; interval > 0 && c.pvLister != nil {
+		run(func(ctx context.Context) {
+			c.runOrphanedVolumeGC(ctx, time.Duration(interval)*time.Second)
+		})
+	}
+
+	// The volumes are still registered with CNS when they are created
+	if interval := c.cfg.Global.CnsMetadataSyncIntervalSecs; interval > 0 && c.podLister != nil {
+		run(func(ctx context.Context) {
+			c.runCnsMetadataSync(ctx, time.Duration(interval)*time.Second)
+		})
+	}
+
+	if interval := c.cfg.Global.AttachmentReconcileIntervalSecs; interval > 0 && c.vaLister != nil {
+		run(func(ctx context.Context) {
+			c.runAttachmentReconciler(ctx, time.Duration(interval)*time.Second)
+		})
+	}
+
+	// Lookups fall back to searching the datastores until the index is built
+	run(func(ctx context.Context) {
+		if err := c.connMgr.BuildFirstClassDiskIndex(ctx); err != nil {
+			klog.Warningf("BuildFirstClassDiskIndex failed. Err: %v", err)
+		}
+	})
+
+	wg.Wait()
+}
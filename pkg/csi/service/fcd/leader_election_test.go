@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestLeaderElection(t *testing.T) {
+	config, cleanup := configFromEnvOrSim(false)
+	defer cleanup()
+
+	config.Global.LeaderElect = true
+	config.Global.LeaderElectNamespace = "kube-system"
+	config.Global.LeaderElectLeaseDurationSecs = 2
+	config.Global.LeaderElectRenewDeadlineSecs = 1
+
+	defer func(retryPeriod time.Duration) {
+		leaderElectionRetryPeriod = retryPeriod
+	}(leaderElectionRetryPeriod)
+	leaderElectionRetryPeriod = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+
+	// scans counts the refreshes of the FCD cache of each replica
+	var (
+		mu    sync.Mutex
+		scans = make(map[string]int)
+	)
+	scanned := func(identity string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return scans[identity]
+	}
+
+	replicas := make(map[string]*controller)
+	for _, identity := range []string{"replica-1", "replica-2"} {
+		connMgr := cm.NewConnectionManager(config, nil)
+		defer connMgr.Logout()
+
+		identity := identity
+		c := &controller{
+			cfg:      config,
+			connMgr:  connMgr,
+			fcdCache: newFCDCache(connMgr, time.Hour),
+		}
+		c.fcdCache.scan = func(ctx context.Context) []*vclib.FirstClassDiskInfo {
+			mu.Lock()
+			defer mu.Unlock()
+			scans[identity]++
+			return nil
+		}
+		replicas[identity] = c
+
+		lec, err := c.leaderElectionConfig(ctx, client, identity)
+		if err != nil {
+			t.Fatalf("leaderElectionConfig(%s) failed: %v", identity, err)
+		}
+		go c.runLeaderElection(ctx, lec)
+
+		// replica-1 is elected before replica-2 stands for election
+		if identity == "replica-1" {
+			waitFor(t, "replica-1 to lead", func() bool { return scanned("replica-1") > 0 })
+		}
+	}
+
+	// only the leader runs the background work
+	time.Sleep(time.Second)
+	if n := scanned("replica-2"); n != 0 {
+		t.Errorf("replica-2 refreshed its FCD cache %d times without leading", n)
+	}
+
+	// replica-1 loses the lease to replica-2
+	cmap, err := client.CoreV1().ConfigMaps("kube-system").Get(leaderElectionName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the lease: %v", err)
+	}
+	record, _ := json.Marshal(resourcelock.LeaderElectionRecord{
+		HolderIdentity:       "replica-2",
+		LeaseDurationSeconds: 2,
+		AcquireTime:          metav1.Now(),
+		RenewTime:            metav1.Now(),
+	})
+	cmap.Annotations[resourcelock.LeaderElectionRecordAnnotationKey] = string(record)
+	if _, err = client.CoreV1().ConfigMaps("kube-system").Update(cmap); err != nil {
+		t.Fatalf("Failed to update the lease: %v", err)
+	}
+
+	waitFor(t, "replica-2 to lead", func() bool { return scanned("replica-2") > 0 })
+
+	// the FCD cache of the former leader is handed over
+	waitFor(t, "replica-1 to invalidate its FCD cache", func() bool {
+		leader := replicas["replica-1"].fcdCache
+		leader.Lock()
+		defer leader.Unlock()
+		return leader.lastRefresh.IsZero()
+	})
+}
+
+// waitFor fails the test when the condition is not met within 10 seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	for deadline := time.Now().Add(10 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
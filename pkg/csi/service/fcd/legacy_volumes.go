@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/vmware/govmomi/object"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// isLegacyVolume returns whether a volume ID is the vmdk path of a volume
+// provisioned by the in-tree vSphere volume plugin, [datastore] path.vmdk,
+// which is the volume handle of the PVs migrated to CSI.
+func isLegacyVolume(volumeID string) bool {
+	return strings.HasPrefix(volumeID, "[") && strings.HasSuffix(strings.ToLower(volumeID), ".vmdk")
+}
+
+// legacyVolumeID returns the vmdk path of a disk of a datastore.
+func legacyVolumeID(datastoreName string, diskPath string) string {
+	return (&object.DatastorePath{Datastore: datastoreName, Path: diskPath}).String()
+}
+
+// parseLegacyVolumeID returns the datastore of the vmdk of an in-tree volume
+// and its path in the datastore.
+func parseLegacyVolumeID(volumeID string) (string, string, error) {
+	var p object.DatastorePath
+	if !isLegacyVolume(volumeID) || !p.FromString(volumeID) || len(p.Datastore) == 0 || len(p.Path) == 0 {
+		return "", "", fmt.Errorf("invalid in-tree volume ID %s", volumeID)
+	}
+	return p.Datastore, p.Path, nil
+}
+
+// legacyVolumes caches the ID of the FCD each in-tree volume was registered
+// as, by the volume ID of the in-tree volume. The zero value is ready to use.
+type legacyVolumes struct {
+	sync.Mutex
+
+	ids map[string]string
+}
+
+// get returns the FCD ID of the in-tree volume, if it was registered.
+func (l *legacyVolumes) get(volumeID string) (string, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	id, ok := l.ids[volumeID]
+	return id, ok
+}
+
+// set records the FCD ID of the in-tree volume.
+func (l *legacyVolumes) set(volumeID string, fcdID string) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.ids == nil {
+		l.ids = make(map[string]string)
+	}
+	l.ids[volumeID] = fcdID
+}
+
+// remove forgets the FCD ID of the in-tree volume.
+func (l *legacyVolumes) remove(volumeID string) {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.ids, volumeID)
+}
+
+// resolveVolumeID returns the FCD ID of a volume. The vmdk of a volume
+// migrated from the in-tree vSphere volume plugin is registered as an FCD
+// named after its file the first time it is used, in the first datacenter
+// with its datastore and file, and the ID of the FCD is cached. The other
+// volume IDs are returned as is. vclib.ErrNoDiskIDFound is returned when
+// the vmdk does not exist, otherwise the errors are gRPC errors.
+func (c *controller) resolveVolumeID(ctx context.Context, volumeID string) (string, error) {
+	logger := logging.Logger(ctx)
+
+	if !isLegacyVolume(volumeID) {
+		return volumeID, nil
+	}
+	datastoreName, diskPath, err := parseLegacyVolumeID(volumeID)
+	if err != nil {
+		logger.Error(err.Error())
+		return "", status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if id, ok := c.legacyVolumes.get(volumeID); ok {
+		return id, nil
+	}
+	vmdkPath := legacyVolumeID(datastoreName, diskPath)
+
+	pairs, err := c.connManager(ctx).ListAllVCandDCPairs(ctx)
+	if err != nil {
+		msg := fmt.Sprintf("ListAllVCandDCPairs failed. Err: %v", err)
+		logger.Errorf(msg)
+		return "", status.Errorf(errorCode(err), msg)
+	}
+
+	name := strings.TrimSuffix(path.Base(diskPath), path.Ext(diskPath))
+	for _, pair := range pairs {
+		datastore, err := pair.DataCenter.GetDatastoreByName(ctx, datastoreName)
+		if err != nil {
+			continue
+		}
+		fcd, err := datastore.RegisterFirstClassDisk(ctx, diskPath, name)
+		if err == vclib.ErrNoDiskIDFound {
+			continue
+		} else if err != nil {
+			msg := fmt.Sprintf("RegisterFirstClassDisk(%s) failed. Err: %v", vmdkPath, err)
+			logger.Errorf(msg)
+			return "", status.Errorf(errorCode(err), msg)
+		}
+
+		fcdID := fcd.Config.Id.Id
+		logger.Infof("In-tree volume %s is FCD %s", vmdkPath, fcdID)
+		c.vsphere(ctx).IndexFirstClassDisk(pair.VcServer, fcd)
+		c.cacheFCD(fcd)
+		c.legacyVolumes.set(volumeID, fcdID)
+		return fcdID, nil
+	}
+
+	return "", vclib.ErrNoDiskIDFound
+}
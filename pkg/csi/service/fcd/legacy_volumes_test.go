@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseLegacyVolumeID(t *testing.T) {
+	tests := []struct {
+		volumeID  string
+		legacy    bool
+		datastore string
+		path      string
+	}{
+		{"[datastore1] kubevols/pvc-1.vmdk", true, "datastore1", "kubevols/pvc-1.vmdk"},
+		{"[vsan Datastore]  folder/pvc 2.VMDK", true, "vsan Datastore", "folder/pvc 2.VMDK"},
+		{"[datastore1 kubevols/pvc-1.vmdk", true, "", ""},
+		{"[] kubevols/pvc-1.vmdk", true, "", ""},
+		{"c5e5ee3a-5a70-4a5b-8d3c-5f8c4b4e6f4a", false, "", ""},
+		{"file:vc/domain-c7/52d1", false, "", ""},
+	}
+
+	for _, test := range tests {
+		if legacy := isLegacyVolume(test.volumeID); legacy != test.legacy {
+			t.Errorf("isLegacyVolume(%q) should be %v", test.volumeID, test.legacy)
+		}
+		datastore, path, err := parseLegacyVolumeID(test.volumeID)
+		if test.datastore == "" {
+			if err == nil {
+				t.Errorf("parseLegacyVolumeID(%q) should fail", test.volumeID)
+			}
+			continue
+		}
+		if err != nil || datastore != test.datastore || path != test.path {
+			t.Errorf("parseLegacyVolumeID(%q) = %q, %q, %v", test.volumeID, datastore, path, err)
+		}
+		id := legacyVolumeID(datastore, path)
+		if d, p, err := parseLegacyVolumeID(id); err != nil || d != datastore || p != path {
+			t.Errorf("legacyVolumeID(%q, %q) = %q does not parse back", datastore, path, id)
+		}
+	}
+}
+
+func TestLegacyVolume(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	ctx := context.Background()
+
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	myVM.Guest.HostName = strings.ToLower(myVM.Name)
+
+	client := connMgr.VsphereInstanceMap[config.Global.VCenterIP].Conn.Client
+
+	// a vmdk of the in-tree vSphere volume plugin
+	dc, err := find.NewFinder(client, false).DefaultDatacenter(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := legacyVolumeID(myds.Name, "kubevols")
+	if err = object.NewFileManager(client).MakeDirectory(ctx, dir, dc, true); err != nil {
+		t.Fatal(err)
+	}
+	volID := legacyVolumeID(myds.Name, "kubevols/kubernetes-dynamic-pvc-1.vmdk")
+	task, err := object.NewVirtualDiskManager(client).CreateVirtualDisk(ctx, volID, dc,
+		&types.FileBackedVirtualDiskSpec{
+			VirtualDiskSpec: types.VirtualDiskSpec{
+				DiskType:    string(types.VirtualDiskTypeThin),
+				AdapterType: string(types.VirtualDiskAdapterTypeLsiLogic),
+			},
+			CapacityKb: 1024 * 1024,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = task.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the vmdk is registered as an FCD when it is published
+	respPub, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   myVM.Name,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+	if name := respPub.PublishContext[AttributeFirstClassDiskName]; name != "kubernetes-dynamic-pvc-1" {
+		t.Errorf("FCD should be named after the vmdk: %s", name)
+	}
+	fcdID, ok := c.legacyVolumes.get(volID)
+	if !ok {
+		t.Fatal("FCD ID of the in-tree volume should be cached")
+	}
+
+	// the FCD is reused once the cache is lost
+	c.legacyVolumes = legacyVolumes{}
+	id, err := c.resolveVolumeID(ctx, volID)
+	if err != nil || id != fcdID {
+		t.Errorf("resolveVolumeID should return FCD %s: %s, %v", fcdID, id, err)
+	}
+
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   myVM.Name,
+	})
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume failed: %v", err)
+	}
+
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volID})
+	if err != nil {
+		t.Errorf("DeleteVolume failed: %v", err)
+	}
+	if _, ok := c.legacyVolumes.get(volID); ok {
+		t.Error("FCD ID of the deleted volume should no longer be cached")
+	}
+
+	// the deleted vmdk is no longer found
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   myVM.Name,
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerPublishVolume of a deleted volume should fail with NotFound: %v", err)
+	}
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volID})
+	if err != nil {
+		t.Errorf("DeleteVolume of a deleted volume failed: %v", err)
+	}
+
+	// a malformed in-tree volume ID is invalid
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "[" + myds.Name + " pvc.vmdk"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DeleteVolume of a malformed volume ID should fail with InvalidArgument: %v", err)
+	}
+}
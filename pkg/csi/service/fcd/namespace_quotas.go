@@ -0,0 +1,216 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// namespaceLocks serializes the creates of the volumes of each namespace,
+// so that concurrent creates cannot exceed the limit of the namespace
+// together. The zero value is ready to use.
+type namespaceLocks struct {
+	sync.Mutex
+
+	locks map[string]*sync.Mutex
+}
+
+// lock waits for the lock of the namespace and returns the function that
+// releases it.
+func (l *namespaceLocks) lock(namespace string) func() {
+	l.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := l.locks[namespace]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[namespace] = lock
+	}
+	l.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// namespaceQuotasEnabled returns true when the capacity limits of the
+// namespaces are enforced.
+func (c *controller) namespaceQuotasEnabled() bool {
+	return c.quotaLister != nil
+}
+
+// namespaceTag returns the name of the tag of the FCDs of a namespace.
+func (c *controller) namespaceTag(namespace string) string {
+	if len(c.cfg.Global.ClusterID) == 0 {
+		return namespace
+	}
+	return c.cfg.Global.ClusterID + "/" + namespace
+}
+
+// tagVolumeNamespace tags an FCD with the namespace of its PVC, so that its
+// capacity counts against the limit of the namespace. Nothing is tagged
+// when the quotas are disabled or the namespace is unknown.
+func (c *controller) tagVolumeNamespace(ctx context.Context, discoveryInfo *cm.ZoneDiscoveryInfo,
+	fcdID string, namespace string) error {
+
+	if !c.namespaceQuotasEnabled() || len(namespace) == 0 {
+		return nil
+	}
+
+	tag := c.namespaceTag(namespace)
+	if err := c.connManager(ctx).EnsureTag(ctx, discoveryInfo.VcServer, NamespaceTagCategory, tag); err != nil {
+		return err
+	}
+	return discoveryInfo.DataCenter.AttachFirstClassDiskTag(ctx, fcdID, NamespaceTagCategory, tag)
+}
+
+// checkNamespaceQuota fails with ResourceExhausted when a new volume would
+// take the capacity of the FCDs of a namespace past its limit. On success,
+// the returned function must be called once the FCD is created and tagged,
+// as the creates of the namespace are serialized until then. The volumes
+// of an unknown namespace, or of a namespace without a limit, are never
+// refused.
+func (c *controller) checkNamespaceQuota(ctx context.Context, namespace string, volSizeMB int64) (func(), error) {
+	logger := logging.Logger(ctx)
+
+	if !c.namespaceQuotasEnabled() || len(namespace) == 0 {
+		return func() {}, nil
+	}
+
+	limitMB, err := c.namespaceLimitMB(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if limitMB == 0 {
+		return func() {}, nil
+	}
+
+	unlock := c.namespaceLocks.lock(namespace)
+	usedMB, err := c.namespaceCapacityMB(ctx, namespace)
+	if err != nil {
+		unlock()
+		msg := fmt.Sprintf("Failed to sum the capacity of the volumes of namespace %s. Err: %v", namespace, err)
+		logger.Errorf(msg)
+		return nil, status.Errorf(errorCode(err), msg)
+	}
+	if usedMB+volSizeMB > limitMB {
+		unlock()
+		msg := fmt.Sprintf("Volume of %d MB would exceed the capacity limit of %d MB of namespace %s, %d MB of which is provisioned",
+			volSizeMB, limitMB, namespace, usedMB)
+		logger.Error(msg)
+		return nil, status.Errorf(codes.ResourceExhausted, msg)
+	}
+
+	logger.V(4).Infof("Namespace %s has %d MB provisioned out of %d MB", namespace, usedMB, limitMB)
+	return unlock, nil
+}
+
+// namespaceLimitMB returns the capacity limit of a namespace in MB, from
+// the quota ConfigMap, or 0 when the namespace has no limit. No namespace
+// has a limit when the ConfigMap does not exist.
+func (c *controller) namespaceLimitMB(ctx context.Context, namespace string) (int64, error) {
+	logger := logging.Logger(ctx)
+
+	name := c.cfg.Global.NamespaceQuotaConfigMap
+	if c.quotaSynced != nil && !c.quotaSynced() {
+		msg := "The capacity limits of the namespaces are not synced yet"
+		logger.Error(msg)
+		return 0, status.Errorf(codes.Unavailable, msg)
+	}
+	configMap, err := c.quotaLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		msg := fmt.Sprintf("Failed to get ConfigMap %s. Err: %v", name, err)
+		logger.Errorf(msg)
+		return 0, status.Errorf(codes.Internal, msg)
+	}
+
+	value, ok := configMap.Data[namespace]
+	if !ok {
+		value, ok = configMap.Data[NamespaceQuotaOthers]
+	}
+	if !ok {
+		return 0, nil
+	}
+	limit, err := resource.ParseQuantity(strings.TrimSpace(value))
+	if err != nil || limit.Sign() <= 0 {
+		msg := fmt.Sprintf("Invalid capacity limit %q of namespace %s in ConfigMap %s", value, namespace, name)
+		logger.Errorf(msg)
+		return 0, status.Errorf(codes.FailedPrecondition, msg)
+	}
+	return limit.Value() / MbInBytes, nil
+}
+
+// namespaceCapacityMB returns the capacity in MB of the FCDs tagged with a
+// namespace in all of the vCenters. The capacity of the FCDs is read from
+// the FCD cache, and only the ones missing from it are looked up.
+func (c *controller) namespaceCapacityMB(ctx context.Context, namespace string) (int64, error) {
+	tag := c.namespaceTag(namespace)
+	connMgr := c.connManager(ctx)
+
+	var cached map[string]int64
+	if c.fcdCache != nil {
+		cached = c.fcdCache.capacities()
+	}
+
+	var capacityMB int64
+	for _, vsi := range connMgr.VsphereInstances() {
+		if err := connMgr.ConnectByInstance(ctx, vsi); err != nil {
+			return 0, err
+		}
+		datacenters, err := vclib.GetAllDatacenter(ctx, vsi.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if len(datacenters) == 0 {
+			continue
+		}
+
+		// The tags are shared by all of the datacenters of the vCenter
+		ids, err := datacenters[0].GetFirstClassDiskIDsByTag(ctx, NamespaceTagCategory, tag)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range ids {
+			if sizeMB, ok := cached[id]; ok {
+				capacityMB += sizeMB
+				continue
+			}
+			discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, id)
+			if err == vclib.ErrNoDiskIDFound {
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+			capacityMB += discoveryInfo.FCDInfo.Config.CapacityInMB
+		}
+	}
+	return capacityMB, nil
+}
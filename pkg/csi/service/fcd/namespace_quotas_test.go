@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestNamespaceQuotas(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+	config.Global.ClusterID = "cluster-1"
+	config.Global.NamespaceQuotaConfigMap = "quotas"
+
+	configMaps := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	synced := false
+	c.quotaLister = listerv1.NewConfigMapLister(configMaps).ConfigMaps("kube-system")
+	c.quotaSynced = func() bool { return synced }
+
+	//context
+	ctx := context.Background()
+
+	createVolume := func(name string, namespace string, sizeGB int64) error {
+		_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: sizeGB * GbInBytes},
+			Parameters: map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskParentName: myds.Name,
+				ParameterPVCNamespace:             namespace,
+			},
+		})
+		return err
+	}
+
+	// nothing is created until the limits are synced
+	if err := createVolume("pvc-0", "tenant-a", 1); status.Code(err) != codes.Unavailable {
+		t.Fatalf("CreateVolume should have failed with Unavailable: %v", err)
+	}
+	synced = true
+
+	// no namespace has a limit without the ConfigMap
+	if err := createVolume("pvc-1", "tenant-a", 2); err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "quotas", Namespace: "kube-system"},
+		Data: map[string]string{
+			"tenant-a":           "3Gi",
+			NamespaceQuotaOthers: "1Gi",
+			"tenant-invalid":     "lots",
+		},
+	}
+	if err := configMaps.Add(configMap); err != nil {
+		t.Fatal(err)
+	}
+
+	// the volume created before the ConfigMap counts against the limit
+	if err := createVolume("pvc-2", "tenant-a", 1); err != nil {
+		t.Fatalf("CreateVolume within the limit failed: %v", err)
+	}
+	err = createVolume("pvc-3", "tenant-a", 1)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("CreateVolume past the limit should have failed with ResourceExhausted: %v", err)
+	}
+
+	// a retry of a created volume is not refused
+	if err := createVolume("pvc-2", "tenant-a", 1); err != nil {
+		t.Errorf("CreateVolume of an existing volume failed: %v", err)
+	}
+
+	// the other namespaces have the limit of "*", and the volumes without a
+	// namespace have none
+	if err := createVolume("pvc-4", "tenant-b", 1); err != nil {
+		t.Errorf("CreateVolume within the default limit failed: %v", err)
+	}
+	if err := createVolume("pvc-5", "tenant-b", 1); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("CreateVolume past the default limit should have failed with ResourceExhausted: %v", err)
+	}
+	if err := createVolume("pvc-6", "", 5); err != nil {
+		t.Errorf("CreateVolume without a namespace failed: %v", err)
+	}
+	if err := createVolume("pvc-7", "tenant-invalid", 1); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CreateVolume with an invalid limit should have failed with FailedPrecondition: %v", err)
+	}
+
+	// the deleted volumes no longer count against the limit
+	discoveryInfo, err := connMgr.WhichVCandDCByZone(ctx, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := discoveryInfo.DataCenter.GetFirstClassDiskIDsByTag(ctx, NamespaceTagCategory, "cluster-1/tenant-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("Expected the 2 volumes of tenant-a to be tagged, got %v", ids)
+	}
+	if _, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: ids[0]}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if err := createVolume("pvc-3", "tenant-a", 1); err != nil {
+		t.Errorf("CreateVolume once a volume is deleted failed: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sync"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// nodeVMs caches the VMs backing the nodes, keyed by node ID, so that
+// repeated attaches do not search the vSphere inventory. The zero value
+// is ready to use.
+type nodeVMs struct {
+	sync.RWMutex
+
+	vms map[string]*vclib.VirtualMachine
+}
+
+// get returns the cached VM for the node, or nil when there is none.
+func (n *nodeVMs) get(nodeID string) *vclib.VirtualMachine {
+	n.RLock()
+	defer n.RUnlock()
+
+	return n.vms[nodeID]
+}
+
+// set caches the VM for the node.
+func (n *nodeVMs) set(nodeID string, vm *vclib.VirtualMachine) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.vms == nil {
+		n.vms = make(map[string]*vclib.VirtualMachine)
+	}
+	n.vms[nodeID] = vm
+}
+
+// remove drops the cached VM for the node.
+func (n *nodeVMs) remove(nodeID string) {
+	n.Lock()
+	defer n.Unlock()
+
+	delete(n.vms, nodeID)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestNodeVMs(t *testing.T) {
+	var vms nodeVMs
+
+	if vm := vms.get("node1"); vm != nil {
+		t.Fatalf("Expected no VM for an unknown node, got %v", vm)
+	}
+
+	vm := &vclib.VirtualMachine{}
+	vms.set("node1", vm)
+	if vms.get("node1") != vm {
+		t.Error("Failed to get the cached VM")
+	}
+
+	vms.remove("node1")
+	if vms.get("node1") != nil {
+		t.Error("Got a removed VM")
+	}
+}
+
+func TestNodeDeleted(t *testing.T) {
+	c := &controller{}
+	vm := &vclib.VirtualMachine{}
+
+	c.nodeVMs.set("node1", vm)
+	c.nodeVMs.set("422e4956-ad22-1139-6d72-59cc8f26bc90", vm)
+	c.nodeVMs.set("node2", vm)
+
+	c.nodeDeleted(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				AnnotationNodeID: `{"io.k8s.cloud-provider-vsphere.vsphere":"422e4956-ad22-1139-6d72-59cc8f26bc90"}`,
+			},
+		},
+	})
+
+	if c.nodeVMs.get("node1") != nil {
+		t.Error("VM of the deleted node is still cached by name")
+	}
+	if c.nodeVMs.get("422e4956-ad22-1139-6d72-59cc8f26bc90") != nil {
+		t.Error("VM of the deleted node is still cached by node ID")
+	}
+	if c.nodeVMs.get("node2") != vm {
+		t.Error("VM of another node was removed")
+	}
+
+	// unrecognized objects are ignored
+	c.nodeDeleted("node2")
+	if c.nodeVMs.get("node2") != vm {
+		t.Error("VM of another node was removed")
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// onlineExtendSupported returns true when the vCenter extends the FCDs
+// attached to a VM, as told by the capabilities recorded when it passed its
+// check. A vCenter that has not passed its check yet is assumed not to.
+func (c *controller) onlineExtendSupported(vc string) bool {
+	return c.connMgr.Capabilities(vc).Has(vclib.CapabilityOnlineExtend)
+}
+
+// OnlineExpansion returns true when every vCenter extends the FCDs attached
+// to a VM, so that the volumes can be expanded while they are in use.
+func (c *controller) OnlineExpansion() bool {
+	if c.connMgr == nil {
+		return false
+	}
+	for vc := range c.connMgr.VsphereInstances() {
+		if !c.onlineExtendSupported(vc) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkOfflineExtend fails with FailedPrecondition when the FCD is attached
+// to a VM and its vCenter cannot extend the attached FCDs, as the workload
+// using the volume must then be scaled down before it is expanded.
+func (c *controller) checkOfflineExtend(ctx context.Context, discoveryInfo *cm.FcdDiscoveryInfo) error {
+	if c.onlineExtendSupported(discoveryInfo.VcServer) {
+		return nil
+	}
+	logger := logging.Logger(ctx)
+
+	fcd := discoveryInfo.FCDInfo
+	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+	vms, err := fcd.DatastoreInfo.GetVMsWithDisk(ctx, filePath)
+	if err != nil {
+		msg := fmt.Sprintf("GetVMsWithDisk(%s) failed. Err: %v", filePath, err)
+		logger.Errorf(msg)
+		return status.Errorf(errorCode(err), msg)
+	}
+	if len(vms) == 0 {
+		return nil
+	}
+
+	refs := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		refs = append(refs, vm.Reference().Value)
+	}
+	msg := fmt.Sprintf("Volume %s is attached to VM %s, and vCenter %s (API version %q) cannot expand "+
+		"attached volumes before %s. Scale down the workload using the volume to expand it.",
+		fcd.Config.Id.Id, strings.Join(refs, ", "), discoveryInfo.VcServer,
+		c.vcHealth.apiVersion(discoveryInfo.VcServer), vclib.CapabilityOnlineExtend.Requirement())
+	logger.Error(msg)
+	return status.Errorf(codes.FailedPrecondition, msg)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestOnlineExpansion(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	connMgr := cm.NewConnectionManager(config, nil)
+	c := &controller{
+		cfg:     config,
+		connMgr: connMgr,
+	}
+	if c.OnlineExpansion() {
+		t.Error("Expected offline expansion before the vCenter is checked")
+	}
+
+	for version, online := range map[string]bool{
+		"6.7.3":   false,
+		"7.0.1.0": false,
+		"7.0.2.0": true,
+		"7.0.3.0": true,
+	} {
+		connMgr.RecordCapabilities(config.Global.VCenterIP, version)
+		if c.OnlineExpansion() != online {
+			t.Errorf("OnlineExpansion() should return %t with vCenter %s", online, version)
+		}
+	}
+}
+
+func TestExpandAttachedVolume(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	//context
+	ctx := context.Background()
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vm.Guest.HostName = strings.ToLower(vm.Name)
+
+	params := make(map[string]string, 0)
+	params[AttributeFirstClassDiskParentType] = string(vclib.TypeDatastore)
+	params[AttributeFirstClassDiskParentName] = myds.Name
+
+	respCreate, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "test",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: GbInBytes,
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volID := respCreate.Volume.VolumeId
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   vm.Guest.HostName,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	// an attached volume is not expanded by the vCenters older than 7.0U2
+	connMgr.RecordCapabilities(config.Global.VCenterIP, "6.7.3")
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: volID,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 2 * GbInBytes,
+		},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("ControllerExpandVolume should have failed with FailedPrecondition: %v", err)
+	}
+	if !strings.Contains(err.Error(), "Scale down the workload") {
+		t.Errorf("Expected the error to ask to scale down the workload, got %v", err)
+	}
+
+	// vcsim does not extend the FCDs, so only the checks are run next
+	discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, volID)
+	if err != nil {
+		t.Fatalf("WhichVCandDCByFCDId failed: %v", err)
+	}
+	connMgr.RecordCapabilities(config.Global.VCenterIP, "7.0.2.0")
+	if err := c.checkOfflineExtend(ctx, discoveryInfo); err != nil {
+		t.Errorf("Expected the attached volume to be expanded online: %v", err)
+	}
+
+	// a detached volume is expanded by the older vCenters
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: volID,
+		NodeId:   vm.Guest.HostName,
+	})
+	if err != nil {
+		t.Fatalf("ControllerUnpublishVolume failed: %v", err)
+	}
+	connMgr.RecordCapabilities(config.Global.VCenterIP, "6.7.3")
+	if err := c.checkOfflineExtend(ctx, discoveryInfo); err != nil {
+		t.Errorf("Expected the detached volume to be expanded offline: %v", err)
+	}
+}
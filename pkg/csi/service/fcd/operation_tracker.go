@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// operationTracker tracks the operations in flight by key, so that they can
+// be drained on shutdown. At most one operation is in flight for a key. The
+// zero value is ready to use.
+type operationTracker struct {
+	sync.Mutex
+
+	// the cancel func of the context of each operation, by key
+	cancels map[string]context.CancelFunc
+	// the operations in flight
+	inFlight sync.WaitGroup
+	// whether the operations are being drained
+	draining bool
+}
+
+// tryStart starts an operation for the key without blocking. It returns
+// false when an operation is already in flight for the key or the
+// operations are being drained. Otherwise it returns the context of the
+// operation, which is cancelled when the operation outlives the drain
+// timeout.
+func (t *operationTracker) tryStart(ctx context.Context, key string) (context.Context, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.cancels == nil {
+		t.cancels = make(map[string]context.CancelFunc)
+	}
+	if _, ok := t.cancels[key]; ok || t.draining {
+		return ctx, false
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t.cancels[key] = cancel
+	t.inFlight.Add(1)
+	return ctx, true
+}
+
+// finish ends the operation for the key.
+func (t *operationTracker) finish(key string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if cancel, ok := t.cancels[key]; ok {
+		cancel()
+		delete(t.cancels, key)
+		t.inFlight.Done()
+	}
+}
+
+// drain refuses the operations from now on and waits for the ones in flight
+// to finish. When the context is done first, the contexts of these
+// operations are cancelled and drain waits for them to finish. It returns
+// the number of operations that were cancelled.
+func (t *operationTracker) drain(ctx context.Context) int {
+	t.Lock()
+	t.draining = true
+	t.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.inFlight.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return 0
+	case <-ctx.Done():
+	}
+
+	t.Lock()
+	cancelled := len(t.cancels)
+	for _, cancel := range t.cancels {
+		cancel()
+	}
+	t.Unlock()
+
+	<-finished
+	return cancelled
+}
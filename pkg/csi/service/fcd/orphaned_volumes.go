@@ -0,0 +1,170 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/labels"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/metrics"
+)
+
+// tagOwnedVolume tags an FCD as owned by the cluster, so that the orphaned
+// volume scans may collect it. Nothing is tagged without a cluster ID.
+func (c *controller) tagOwnedVolume(ctx context.Context, discoveryInfo *cm.ZoneDiscoveryInfo, fcdID string) error {
+	clusterID := c.cfg.Global.ClusterID
+	if len(clusterID) == 0 {
+		return nil
+	}
+
+	if err := c.connManager(ctx).EnsureTag(ctx, discoveryInfo.VcServer, OwnerTagCategory, clusterID); err != nil {
+		return err
+	}
+	return discoveryInfo.DataCenter.AttachFirstClassDiskTag(ctx, fcdID, OwnerTagCategory, clusterID)
+}
+
+// runOrphanedVolumeGC scans for orphaned FCDs on every interval until the
+// context is cancelled.
+func (c *controller) runOrphanedVolumeGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.collectOrphanedVolumes(ctx)
+		}
+	}
+}
+
+// collectOrphanedVolumes deletes the FCDs owned by the cluster that no PV
+// refers to and that are older than the minimum age. They are only logged
+// in dry-run mode. The FCDs without the ownership tag of the cluster are
+// never touched, and nothing is done until the PVs are synced, as the
+// volumes in use would look orphaned in a partial list of PVs.
+func (c *controller) collectOrphanedVolumes(ctx context.Context) {
+	logger := logging.Logger(ctx)
+
+	if c.pvSynced == nil || !c.pvSynced() {
+		logger.Warning("Skipping the orphaned volume scan until the PVs are synced")
+		return
+	}
+	pvs, err := c.pvLister.List(labels.Everything())
+	if err != nil {
+		logger.Errorf("Failed to list the PVs. Err: %v", err)
+		return
+	}
+	referenced := make(map[string]bool, len(pvs))
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil {
+			referenced[pv.Spec.CSI.VolumeHandle] = true
+		}
+	}
+
+	clusterID := c.cfg.Global.ClusterID
+	minAge := time.Duration(c.cfg.Global.OrphanedVolumeGCMinAgeSecs) * time.Second
+	dryRun := c.cfg.Global.OrphanedVolumeGCDryRun
+
+	orphaned := 0
+	for vc, vsi := range c.connMgr.VsphereInstances() {
+		if err := c.connMgr.ConnectByInstance(ctx, vsi); err != nil {
+			logger.Errorf("Failed to connect to vCenter %s. Err: %v", vc, err)
+			continue
+		}
+
+		datacenters, err := vclib.GetAllDatacenter(ctx, vsi.Conn)
+		if err != nil || len(datacenters) == 0 {
+			logger.Errorf("GetAllDatacenter failed vc=%s err=%v", vc, err)
+			continue
+		}
+
+		// The tags are shared by all of the datacenters of the vCenter
+		ownedIDs, err := datacenters[0].GetFirstClassDiskIDsByTag(ctx, OwnerTagCategory, clusterID)
+		if err != nil {
+			logger.Errorf("Failed to list the volumes owned by cluster %s in vCenter %s. Err: %v", clusterID, vc, err)
+			continue
+		}
+		owned := make(map[string]bool, len(ownedIDs))
+		for _, id := range ownedIDs {
+			owned[id] = true
+		}
+
+		for _, dc := range datacenters {
+			firstClassDisks, err := dc.GetAllFirstClassDisks(ctx)
+			if err != nil {
+				logger.Errorf("GetAllFirstClassDisks failed vc=%s err=%v", vc, err)
+				continue
+			}
+
+			for _, fcd := range firstClassDisks {
+				id := fcd.Config.Id.Id
+				age := time.Since(fcd.Config.CreateTime)
+				if !owned[id] || referenced[id] || age < minAge {
+					continue
+				}
+				orphaned++
+
+				entry := logger.WithFields(logging.Fields{"vcenter": vc, "volume_id": id, "name": fcd.Config.Name})
+				if dryRun {
+					entry.Warningf("Found orphaned volume created %s ago", age.Round(time.Second))
+					continue
+				}
+				c.deleteOrphanedVolume(ctx, vc, dc, fcd, entry)
+			}
+		}
+	}
+
+	metrics.SetOrphanedVolumes(orphaned)
+}
+
+// deleteOrphanedVolume deletes an orphaned FCD, unless an operation is in
+// flight for it, such as a create that is being retried.
+func (c *controller) deleteOrphanedVolume(ctx context.Context, vc string, dc *vclib.Datacenter,
+	fcd *vclib.FirstClassDiskInfo, entry *logging.Entry) {
+
+	id := fcd.Config.Id.Id
+	name := fcd.Config.Name
+	if _, ok := c.pendingCreates.get(vc + "/" + name); ok {
+		return
+	}
+	if _, ok := c.volumeLocks.tryAcquire(ctx, name); !ok {
+		return
+	}
+	defer c.volumeLocks.release(name)
+	ctx, ok := c.volumeLocks.tryAcquire(ctx, id)
+	if !ok {
+		return
+	}
+	defer c.volumeLocks.release(id)
+
+	datastoreName, datastoreType := getParentDatastore(fcd)
+	if err := dc.DeleteFirstClassDisk(ctx, datastoreName, datastoreType, id); err != nil {
+		entry.Errorf("Failed to delete orphaned volume. Err: %v", err)
+		return
+	}
+	c.connMgr.UnindexFirstClassDisk(id)
+	c.uncacheFCD(id)
+	metrics.IncOrphanedVolumesDeleted()
+	entry.Warning("Deleted orphaned volume")
+}
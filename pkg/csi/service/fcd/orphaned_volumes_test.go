@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestCollectOrphanedVolumes(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+	config.Global.ClusterID = "cluster-1"
+	config.Global.OrphanedVolumeGCMinAgeSecs = 3600
+	config.Global.OrphanedVolumeGCDryRun = true
+
+	pvs := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	synced := false
+	c.pvLister = listerv1.NewPersistentVolumeLister(pvs)
+	c.pvSynced = func() bool { return synced }
+
+	//context
+	ctx := context.Background()
+
+	// pvc-1 has a PV, pvc-2 is orphaned
+	volumeIDs := make(map[string]string)
+	for _, name := range []string{"pvc-1", "pvc-2"} {
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name: name,
+			Parameters: map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskParentName: myds.Name,
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+		}
+		volumeIDs[name] = resp.Volume.VolumeId
+	}
+	pvs.Add(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{VolumeHandle: volumeIDs["pvc-1"]},
+			},
+		},
+	})
+
+	// a disk without the ownership tag of the cluster is never collected
+	discoveryInfo, err := connMgr.WhichVCandDCByZone(ctx, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = discoveryInfo.DataCenter.CreateFirstClassDisk(ctx, myds.Name, vclib.TypeDatastore, "unowned", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countVolumes := func() int {
+		resp, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+		if err != nil {
+			t.Fatalf("ListVolumes failed: %v", err)
+		}
+		return len(resp.Entries)
+	}
+
+	// nothing is collected until the PVs are synced, the orphaned volumes
+	// are old enough and dry-run is disabled
+	c.collectOrphanedVolumes(ctx)
+	synced = true
+	c.collectOrphanedVolumes(ctx)
+	config.Global.OrphanedVolumeGCMinAgeSecs = 0
+	c.collectOrphanedVolumes(ctx)
+	if count := countVolumes(); count != 3 {
+		t.Fatalf("No volume should be deleted: %d volumes", count)
+	}
+
+	config.Global.OrphanedVolumeGCDryRun = false
+	c.collectOrphanedVolumes(ctx)
+	resp, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	if len(resp.Entries) != 2 {
+		t.Fatalf("Only the orphaned volume should be deleted: %d volumes", len(resp.Entries))
+	}
+	for _, entry := range resp.Entries {
+		if entry.Volume.VolumeId == volumeIDs["pvc-2"] {
+			t.Errorf("Orphaned volume %s should be deleted", volumeIDs["pvc-2"])
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sync"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// pendingTask is a vSphere task of a vCenter.
+type pendingTask struct {
+	vcServer string
+	task     types.ManagedObjectReference
+}
+
+// pendingTasks tracks the vSphere tasks that outlived the request that
+// started them, keyed by volume, so that a retried request waits for the
+// running task instead of starting another one. The zero value is ready
+// to use.
+type pendingTasks struct {
+	sync.Mutex
+
+	tasks map[string]pendingTask
+}
+
+// get returns the pending task for the key.
+func (p *pendingTasks) get(key string) (types.ManagedObjectReference, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	pending, ok := p.tasks[key]
+	return pending.task, ok
+}
+
+// set records the pending task of the vCenter for the key.
+func (p *pendingTasks) set(key string, vcServer string, task types.ManagedObjectReference) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.tasks == nil {
+		p.tasks = make(map[string]pendingTask)
+	}
+	p.tasks[key] = pendingTask{vcServer: vcServer, task: task}
+}
+
+// remove drops the pending task for the key.
+func (p *pendingTasks) remove(key string) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.tasks, key)
+}
+
+// removeAll drops all of the pending tasks and returns them.
+func (p *pendingTasks) removeAll() []pendingTask {
+	p.Lock()
+	defer p.Unlock()
+
+	tasks := make([]pendingTask, 0, len(p.tasks))
+	for _, pending := range p.tasks {
+		tasks = append(tasks, pending)
+	}
+	p.tasks = nil
+	return tasks
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestPendingTasks(t *testing.T) {
+	var tasks pendingTasks
+
+	if _, ok := tasks.get("vc/pvc-1"); ok {
+		t.Fatal("Expected no task for an unknown volume")
+	}
+
+	ref := types.ManagedObjectReference{Type: "Task", Value: "task-1"}
+	tasks.set("vc/pvc-1", "vc", ref)
+	if task, ok := tasks.get("vc/pvc-1"); !ok || task != ref {
+		t.Errorf("Failed to get the pending task: %v", task)
+	}
+
+	tasks.remove("vc/pvc-1")
+	if _, ok := tasks.get("vc/pvc-1"); ok {
+		t.Error("Got a removed task")
+	}
+
+	tasks.set("vc/pvc-2", "vc", ref)
+	removed := tasks.removeAll()
+	if len(removed) != 1 || removed[0].vcServer != "vc" || removed[0].task != ref {
+		t.Errorf("Failed to remove all of the pending tasks: %v", removed)
+	}
+	if _, ok := tasks.get("vc/pvc-2"); ok {
+		t.Error("Got a removed task")
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+var (
+	// vcenterPrivileges are the privileges required on the root folder of
+	// the vCenters, to read the storage policies.
+	vcenterPrivileges = []string{
+		"StorageProfile.View",
+	}
+
+	// cnsPrivileges are the privileges required on the root folder of the
+	// vCenters with CNS, to register the FCDs and their metadata.
+	cnsPrivileges = []string{
+		"Cns.Searchable",
+	}
+
+	// datacenterPrivileges are the privileges required on the datacenters,
+	// which their node VMs inherit, to attach and detach the FCDs.
+	datacenterPrivileges = []string{
+		"System.Read",
+		"VirtualMachine.Config.AddExistingDisk",
+		"VirtualMachine.Config.RemoveDisk",
+	}
+
+	// datastorePrivileges are the privileges required on the datastores
+	// to create, extend, snapshot and delete the FCDs, whether through the
+	// vStorageObjectManager or the vslm service.
+	datastorePrivileges = []string{
+		"Datastore.AllocateSpace",
+		"Datastore.FileManagement",
+	}
+)
+
+// CheckPermissions verifies that the users of the vCenters have the vSphere
+// privileges required by the CSI controller on the vCenters, their
+// configured datacenters and the datastores of these datacenters the
+// volumes may be created on. The returned error lists the missing
+// privileges of each entity.
+func CheckPermissions(ctx context.Context, config *vcfg.Config, connMgr *cm.ConnectionManager) error {
+	return checkPermissions(ctx, config, connMgr, func(string) bool { return true })
+}
+
+// checkPermissions is CheckPermissions for the vCenters that check returns
+// true for.
+func checkPermissions(ctx context.Context, config *vcfg.Config, connMgr *cm.ConnectionManager,
+	check func(vc string) bool) error {
+
+	var mutex sync.Mutex
+	var missing []string
+
+	err := connMgr.ForEachVC(ctx, func(ctx context.Context, vc string) error {
+		if !check(vc) {
+			return nil
+		}
+		vcMissing, err := missingPermissions(ctx, config, connMgr, vc)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		missing = append(missing, vcMissing...)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return missingPermissionsErr(missing)
+}
+
+// missingPermissionsErr returns the error listing the missing privileges of
+// the entities, or nil when none is missing.
+func missingPermissionsErr(missing []string) error {
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing vSphere privileges: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// missingPermissions returns the missing privileges of each entity of the
+// vCenter, one entity per string.
+func missingPermissions(ctx context.Context, config *vcfg.Config, connMgr *cm.ConnectionManager,
+	vc string) ([]string, error) {
+
+	datacenters, err := connMgr.ListDatacenters(ctx, vc)
+	if err != nil {
+		return nil, err
+	}
+	client := connMgr.VsphereInstanceMap[vc].Conn.Client
+	filter, _ := vcfg.NewDatastoreFilter(config.Global.DatastoreAllowlist, config.Global.DatastoreDenylist)
+
+	root := client.ServiceContent.RootFolder
+	rootPrivileges := vcenterPrivileges
+	if connMgr.HasCapability(ctx, vc, vclib.CapabilityCns) {
+		rootPrivileges = append(append([]string{}, vcenterPrivileges...), cnsPrivileges...)
+	}
+	required := map[types.ManagedObjectReference][]string{root: rootPrivileges}
+	names := map[types.ManagedObjectReference]string{root: "vCenter"}
+	for _, datacenter := range datacenters {
+		ref := datacenter.Reference()
+		required[ref] = datacenterPrivileges
+		names[ref] = "datacenter " + datacenter.Name()
+
+		datastores, err := candidateDatastores(ctx, datacenter, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, datastore := range datastores {
+			ref := datastore.Reference()
+			required[ref] = datastorePrivileges
+			names[ref] = "datastore " + datastore.Info.Name
+		}
+	}
+
+	missingPrivileges, err := vclib.MissingPrivileges(ctx, client, required)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make([]string, 0, len(missingPrivileges))
+	for ref, privileges := range missingPrivileges {
+		missing = append(missing, fmt.Sprintf("vc=%s %s: %s", vc, names[ref], strings.Join(privileges, ", ")))
+	}
+	return missing, nil
+}
+
+// candidateDatastores returns the datastores of a datacenter the volumes
+// may be created on: the ones the datastore filter allows, along with the
+// datastores of the datastore clusters it allows. The datastores compatible
+// with the storage policies of the StorageClasses are among them.
+func candidateDatastores(ctx context.Context, datacenter *vclib.Datacenter,
+	filter *vcfg.DatastoreFilter) ([]*vclib.DatastoreInfo, error) {
+
+	datastores, err := datacenter.GetAllDatastores(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[types.ManagedObjectReference]*vclib.DatastoreInfo)
+	for _, datastore := range datastores {
+		if filter.Allows(datastore.Info.Name) {
+			candidates[datastore.Reference()] = datastore
+		}
+	}
+	if filter != nil && (len(filter.Allowlist) > 0 || len(filter.Denylist) > 0) {
+		storagePods, err := datacenter.GetAllDatastoreClusters(ctx, true)
+		if err != nil && err != vclib.ErrNoDataStoreClustersFound {
+			return nil, err
+		}
+		for _, storagePod := range storagePods {
+			if !filter.Allows(storagePod.Summary.Name) {
+				continue
+			}
+			for _, datastore := range storagePod.DatastoreInfos {
+				candidates[datastore.Reference()] = datastore
+			}
+		}
+	}
+
+	list := make([]*vclib.DatastoreInfo, 0, len(candidates))
+	for _, datastore := range candidates {
+		list = append(list, datastore)
+	}
+	return list, nil
+}
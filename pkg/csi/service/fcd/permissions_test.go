@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/simulator/vpx"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+)
+
+// privilegesAuthorizationManager grants the privileges to the entities of
+// the types, as vcsim does not implement FetchUserPrivilegeOnEntities.
+type privilegesAuthorizationManager struct {
+	*simulator.AuthorizationManager
+
+	granted map[string][]string
+}
+
+func (m *privilegesAuthorizationManager) FetchUserPrivilegeOnEntities(req *types.FetchUserPrivilegeOnEntities) soap.HasFault {
+	res := &types.FetchUserPrivilegeOnEntitiesResponse{}
+	for _, entity := range req.Entities {
+		res.Returnval = append(res.Returnval, types.UserPrivilegeResult{
+			Entity:     entity,
+			Privileges: m.granted[entity.Type],
+		})
+	}
+	return &methods.FetchUserPrivilegeOnEntitiesBody{Res: res}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	connMgr := cm.NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	//context
+	ctx := context.Background()
+
+	authManager := &privilegesAuthorizationManager{
+		AuthorizationManager: simulator.Map.Get(*vpx.ServiceContent.AuthorizationManager).(*simulator.AuthorizationManager),
+		granted: map[string][]string{
+			"Folder":     append(append([]string{}, vcenterPrivileges...), cnsPrivileges...),
+			"Datacenter": datacenterPrivileges,
+			"Datastore":  datastorePrivileges,
+		},
+	}
+	simulator.Map.Put(authManager)
+
+	if err := CheckPermissions(ctx, config, connMgr); err != nil {
+		t.Fatalf("CheckPermissions should succeed: %v", err)
+	}
+
+	authManager.granted["Datastore"] = []string{"Datastore.AllocateSpace"}
+	err := CheckPermissions(ctx, config, connMgr)
+	if err == nil {
+		t.Fatal("CheckPermissions should fail without Datastore.FileManagement")
+	}
+	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+	if !strings.Contains(err.Error(), "datastore "+myds.Name+": Datastore.FileManagement") {
+		t.Errorf("the error should list the missing privilege of %s: %v", myds.Name, err)
+	}
+	if strings.Contains(err.Error(), "datacenter ") {
+		t.Errorf("the error should not list the datacenters: %v", err)
+	}
+
+	// no volume may be created on the datastores the allow list excludes
+	config.Global.DatastoreAllowlist = "vsanDatastore*"
+	if err := CheckPermissions(ctx, config, connMgr); err != nil {
+		t.Errorf("CheckPermissions should not check the datastores excluded by the allow list: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+type connMgrKey struct{}
+
+// secretSession is the connection manager of the credentials passed in the
+// secrets of the requests.
+type secretSession struct {
+	password string
+	connMgr  *cm.ConnectionManager
+	// refs is the number of requests holding the session
+	refs int
+	// superseded is set once the password changed, the session is then
+	// closed when the last request holding it is done
+	superseded bool
+}
+
+// secretSessions caches the connection managers of the credentials passed
+// in the secrets of the requests, keyed by server and username, so that
+// the requests of a StorageClass share their vCenter sessions. The zero
+// value is ready to use.
+type secretSessions struct {
+	sync.Mutex
+
+	sessions map[string]*secretSession
+}
+
+// acquire returns the connection manager of the credentials, creating it
+// with newConnMgr when there is none or when the password changed, along
+// with the func the request releases it with once done. The manager of the
+// former password is closed once the last request holding it released it.
+func (s *secretSessions) acquire(server string, username string, password string,
+	newConnMgr func() *cm.ConnectionManager) (*cm.ConnectionManager, func()) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	key := server + "/" + username
+	session, ok := s.sessions[key]
+	if ok && session.password != password {
+		session.superseded = true
+		if session.refs == 0 {
+			session.connMgr.Close()
+		}
+		ok = false
+	}
+	if !ok {
+		if s.sessions == nil {
+			s.sessions = make(map[string]*secretSession)
+		}
+		session = &secretSession{password: password, connMgr: newConnMgr()}
+		s.sessions[key] = session
+	}
+
+	session.refs++
+	return session.connMgr, func() { s.release(session) }
+}
+
+// release releases a session held by a request, closing it when it was
+// superseded and no other request holds it.
+func (s *secretSessions) release(session *secretSession) {
+	s.Lock()
+	defer s.Unlock()
+
+	session.refs--
+	if session.superseded && session.refs == 0 {
+		session.connMgr.Close()
+	}
+}
+
+// close closes the sessions of all of the credentials.
+func (s *secretSessions) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	for key, session := range s.sessions {
+		session.connMgr.Close()
+		delete(s.sessions, key)
+	}
+}
+
+// connManager returns the connection manager of the request, which is the
+// one of the credentials passed in its secrets, if any.
+func (c *controller) connManager(ctx context.Context) connectionManager {
+	if connMgr, ok := ctx.Value(connMgrKey{}).(connectionManager); ok {
+		return connMgr
+	}
+	return c.connMgr
+}
+
+// withSecrets returns a context with the connection manager of the vCenter
+// credentials passed in the secrets of a request, along with the func that
+// releases the manager once the request is done. The context is returned
+// as is when the secrets hold no username. The server may be omitted when
+// a single vCenter is configured, and must be a configured vCenter
+// otherwise. The values of the secrets are never logged.
+func (c *controller) withSecrets(ctx context.Context, secrets map[string]string) (context.Context, func(), error) {
+	username := secrets[SecretUsername]
+	if len(username) == 0 {
+		return ctx, func() {}, nil
+	}
+	password := secrets[SecretPassword]
+	if len(password) == 0 {
+		msg := fmt.Sprintf("Secret %s is required along with secret %s.", SecretPassword, SecretUsername)
+		logging.Logger(ctx).Error(msg)
+		return nil, nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	server := secrets[SecretServer]
+	if len(server) == 0 {
+		if len(c.cfg.VirtualCenter) != 1 {
+			msg := fmt.Sprintf("Secret %s is required when several vCenters are configured.", SecretServer)
+			logging.Logger(ctx).Error(msg)
+			return nil, nil, status.Errorf(codes.InvalidArgument, msg)
+		}
+		for vc := range c.cfg.VirtualCenter {
+			server = vc
+		}
+	}
+	vcConfig, ok := c.cfg.VirtualCenter[server]
+	if !ok {
+		msg := fmt.Sprintf("Secret %s names vCenter %s, which is not configured.", SecretServer, server)
+		logging.Logger(ctx).Error(msg)
+		return nil, nil, status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	connMgr, release := c.secretSessions.acquire(server, username, password, func() *cm.ConnectionManager {
+		logging.Logger(ctx).V(2).Infof("Creating a session of vCenter %s for the credentials of the secrets", server)
+		return cm.NewConnectionManager(secretConfig(c.cfg, server, vcConfig, username, password), nil)
+	})
+	return context.WithValue(ctx, connMgrKey{}, connMgr), release, nil
+}
+
+// secretConfig returns a copy of the config with the vCenter alone, which
+// uses the credentials rather than any configured credentials or secret.
+func secretConfig(cfg *vcfg.Config, server string, vcConfig *vcfg.VirtualCenterConfig,
+	username string, password string) *vcfg.Config {
+
+	secretCfg := *cfg
+	secretCfg.Global.SecretName = ""
+	secretCfg.Global.SecretNamespace = ""
+	secretCfg.Global.SecretsDirectory = ""
+
+	secretVC := *vcConfig
+	secretVC.User = username
+	secretVC.Password = password
+	secretVC.SecretName = ""
+	secretVC.SecretNamespace = ""
+	secretCfg.VirtualCenter = map[string]*vcfg.VirtualCenterConfig{server: &secretVC}
+
+	return &secretCfg
+}
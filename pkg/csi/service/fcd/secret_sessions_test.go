@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestSecretSessions(t *testing.T) {
+	var s secretSessions
+
+	created := 0
+	get := func(server string, username string, password string) *cm.ConnectionManager {
+		connMgr, _ := s.acquire(server, username, password, func() *cm.ConnectionManager {
+			created++
+			return &cm.ConnectionManager{}
+		})
+		return connMgr
+	}
+
+	first := get("vc1", "user", "pass")
+	if get("vc1", "user", "pass") != first {
+		t.Error("the session should be reused for the same server and username")
+	}
+	if get("vc2", "user", "pass") == first {
+		t.Error("the session should not be shared between servers")
+	}
+	if get("vc1", "other", "pass") == first {
+		t.Error("the session should not be shared between usernames")
+	}
+	if get("vc1", "user", "changed") == first {
+		t.Error("the session should be replaced when the password changes")
+	}
+	if created != 4 {
+		t.Errorf("4 sessions should be created: %d", created)
+	}
+}
+
+func TestSecretSessionsRelease(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	vc := config.Global.VCenterIP
+	newConnMgr := func() *cm.ConnectionManager {
+		connMgr := cm.NewConnectionManager(config, nil)
+		if err := connMgr.Connect(ctx, vc); err != nil {
+			t.Fatalf("Failed to Connect to vSphere: %s", err)
+		}
+		return connMgr
+	}
+	closed := func(connMgr *cm.ConnectionManager) bool {
+		return connMgr.VsphereInstanceMap[vc].Conn.Health() == vclib.ErrNotConnected
+	}
+
+	var s secretSessions
+	defer s.close()
+
+	old, releaseOld := s.acquire(vc, "user", "old", newConnMgr)
+
+	// the manager of the former password is kept while a request holds it
+	changed, releaseChanged := s.acquire(vc, "user", "changed", newConnMgr)
+	if changed == old {
+		t.Fatal("the session should be replaced when the password changes")
+	}
+	if closed(old) {
+		t.Error("the former session should not be closed while a request holds it")
+	}
+	releaseOld()
+	if !closed(old) {
+		t.Error("the former session should be closed once released")
+	}
+
+	// the former password coming back gets a session of its own
+	back, releaseBack := s.acquire(vc, "user", "old", newConnMgr)
+	if back == old || closed(back) {
+		t.Error("the former password should get a new session")
+	}
+	if closed(changed) {
+		t.Error("the superseded session should not be closed while a request holds it")
+	}
+	releaseChanged()
+	if !closed(changed) {
+		t.Error("the superseded session should be closed once released")
+	}
+
+	// the current session is kept once released
+	releaseBack()
+	if closed(back) {
+		t.Error("the current session should be kept once released")
+	}
+}
+
+func TestCreateVolumeWithSecrets(t *testing.T) {
+	config, cleanup := configFromSim(false)
+	defer cleanup()
+
+	// vcsim accepts any credentials
+	vcServer := config.Global.VCenterIP
+	username := "secret-user"
+	password := "secret-pass"
+
+	connMgr := cm.NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+
+	c := &controller{
+		cfg:     config,
+		connMgr: connMgr,
+	}
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator DS
+	myds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+
+	params := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+
+	testCases := []struct {
+		name    string
+		secrets map[string]string
+		code    codes.Code
+	}{
+		{"no password", map[string]string{SecretUsername: username}, codes.InvalidArgument},
+		{"unknown server", map[string]string{SecretServer: "vc.unknown", SecretUsername: username, SecretPassword: password}, codes.InvalidArgument},
+		{"default server", map[string]string{SecretUsername: username, SecretPassword: password}, codes.OK},
+		{"server", map[string]string{SecretServer: vcServer, SecretUsername: username, SecretPassword: password}, codes.OK},
+	}
+	for _, tc := range testCases {
+		_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:       "pvc-secrets",
+			Parameters: params,
+			Secrets:    tc.secrets,
+		})
+		if code := status.Code(err); code != tc.code {
+			t.Errorf("%s: CreateVolume should return %s: %v", tc.name, tc.code, err)
+		}
+	}
+
+	if len(c.secretSessions.sessions) != 1 {
+		t.Errorf("the requests should share a single session: %d", len(c.secretSessions.sessions))
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// defaultQuiesceTimeout is how long the file systems of the VMs are flushed
+// for before a snapshot is taken crash-consistent, unless the snapshot
+// parameters set another timeout.
+var defaultQuiesceTimeout = 30 * time.Second
+
+// eventReasonSnapshotNotQuiesced is the reason of the events of the
+// VolumeSnapshots taken crash-consistent, as their volume failed to be
+// quiesced.
+const eventReasonSnapshotNotQuiesced = "SnapshotNotQuiesced"
+
+// snapshotQuiesce is how the volume of a snapshot is quiesced.
+type snapshotQuiesce struct {
+	timeout  time.Duration
+	username string
+	password string
+}
+
+// parseSnapshotQuiesce returns how the volume of a snapshot is quiesced,
+// or nil when it is not. The guest credentials must be in the secrets of
+// the request.
+func parseSnapshotQuiesce(params map[string]string, secrets map[string]string) (*snapshotQuiesce, error) {
+	value, ok := params[AttributeSnapshotQuiesce]
+	if !ok {
+		return nil, nil
+	}
+	quiesce, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s parameter %q: must be true or false", AttributeSnapshotQuiesce, value)
+	}
+	if !quiesce {
+		return nil, nil
+	}
+
+	q := &snapshotQuiesce{
+		timeout:  defaultQuiesceTimeout,
+		username: secrets[SecretGuestUsername],
+		password: secrets[SecretGuestPassword],
+	}
+	if value, ok := params[AttributeSnapshotQuiesceTimeoutSecs]; ok {
+		secs, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || secs == 0 {
+			return nil, fmt.Errorf("Invalid %s parameter %q: must be a positive number of seconds",
+				AttributeSnapshotQuiesceTimeoutSecs, value)
+		}
+		q.timeout = time.Duration(secs) * time.Second
+	}
+	if len(q.username) == 0 || len(q.password) == 0 {
+		return nil, fmt.Errorf("Secrets %s and %s are required to quiesce the volume.",
+			SecretGuestUsername, SecretGuestPassword)
+	}
+	return q, nil
+}
+
+// quiesceVolume flushes the file systems of the VMs the FCD of a snapshot
+// is attached to, so that its snapshot is application-consistent. When a
+// VM fails to, or times out, the snapshot is taken crash-consistent: the
+// failure is logged and reported in a warning event of the VolumeSnapshot
+// when its name is in the parameters, as CSI snapshots have no message.
+// Nothing is done for an FCD attached to no VM, which no guest writes to.
+func (c *controller) quiesceVolume(ctx context.Context, discoveryInfo *cm.FcdDiscoveryInfo,
+	q *snapshotQuiesce, params map[string]string) {
+
+	logger := logging.Logger(ctx)
+	fcd := discoveryInfo.FCDInfo
+	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+
+	err := func() error {
+		vms, err := fcd.DatastoreInfo.GetVMsWithDisk(ctx, filePath)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+		for _, vm := range vms {
+			if err := c.vmOps(vm).SyncGuestFileSystems(ctx, q.username, q.password); err != nil {
+				return fmt.Errorf("VM %s: %v", vm.Reference().Value, err)
+			}
+			logger.V(2).Infof("Flushed the file systems of VM %s for a snapshot of volume %s",
+				vm.Reference().Value, fcd.Config.Id.Id)
+		}
+		return nil
+	}()
+	if err == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("Failed to quiesce volume %s, its snapshot is only crash-consistent. Err: %v",
+		fcd.Config.Id.Id, err)
+	logger.Warning(msg)
+
+	name, namespace := params[ParameterVolumeSnapshotName], params[ParameterVolumeSnapshotNamespace]
+	if c.recorder != nil && len(name) > 0 && len(namespace) > 0 {
+		ref := &v1.ObjectReference{
+			Kind:       "VolumeSnapshot",
+			APIVersion: "snapshot.storage.k8s.io/v1alpha1",
+			Name:       name,
+			Namespace:  namespace,
+		}
+		c.recorder.Event(ref, v1.EventTypeWarning, eventReasonSnapshotNotQuiesced, msg)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestParseSnapshotQuiesce(t *testing.T) {
+	secrets := map[string]string{SecretGuestUsername: "root", SecretGuestPassword: "secret"}
+
+	for _, params := range []map[string]string{
+		nil,
+		{AttributeSnapshotQuiesce: "false"},
+	} {
+		q, err := parseSnapshotQuiesce(params, nil)
+		if err != nil || q != nil {
+			t.Errorf("%v should not quiesce the volume: %v, %v", params, q, err)
+		}
+	}
+
+	q, err := parseSnapshotQuiesce(map[string]string{AttributeSnapshotQuiesce: "true"}, secrets)
+	if err != nil || q == nil || q.timeout != defaultQuiesceTimeout || q.username != "root" || q.password != "secret" {
+		t.Errorf("Unexpected quiesce: %+v, %v", q, err)
+	}
+	q, err = parseSnapshotQuiesce(map[string]string{
+		AttributeSnapshotQuiesce:            "true",
+		AttributeSnapshotQuiesceTimeoutSecs: "5",
+	}, secrets)
+	if err != nil || q == nil || q.timeout != 5*time.Second {
+		t.Errorf("Unexpected quiesce: %+v, %v", q, err)
+	}
+
+	for _, test := range []struct {
+		params  map[string]string
+		secrets map[string]string
+	}{
+		{map[string]string{AttributeSnapshotQuiesce: "yes please"}, secrets},
+		{map[string]string{AttributeSnapshotQuiesce: "true", AttributeSnapshotQuiesceTimeoutSecs: "0"}, secrets},
+		{map[string]string{AttributeSnapshotQuiesce: "true", AttributeSnapshotQuiesceTimeoutSecs: "soon"}, secrets},
+		{map[string]string{AttributeSnapshotQuiesce: "true"}, nil},
+		{map[string]string{AttributeSnapshotQuiesce: "true"}, map[string]string{SecretGuestUsername: "root"}},
+	} {
+		if _, err := parseSnapshotQuiesce(test.params, test.secrets); err == nil {
+			t.Errorf("%v with secrets %v should be invalid", test.params, test.secrets)
+		}
+	}
+}
+
+// syncVM counts the flushes of the file systems of the VM it wraps, which
+// fail with syncErr.
+type syncVM struct {
+	vmOps
+	syncs   *int
+	syncErr error
+}
+
+func (v *syncVM) SyncGuestFileSystems(ctx context.Context, username string, password string) error {
+	*v.syncs++
+	return v.syncErr
+}
+
+func TestCreateQuiescedSnapshot(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+
+	//context
+	ctx := context.Background()
+
+	// Get a simulator VM
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vmName := myVM.Name
+	myVM.Guest.HostName = strings.ToLower(vmName)
+
+	// vcsim reports an API version older than the FCD snapshots
+	connMgr.RecordCapabilities(config.Global.VCenterIP, "6.7.3")
+
+	resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "quiesced",
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := resp.Volume.VolumeId
+
+	var syncs int
+	syncErr := error(nil)
+	c.hooks = vsphereHooks{
+		vm: func(vm *vclib.VirtualMachine) vmOps {
+			return &syncVM{vmOps: vm, syncs: &syncs, syncErr: syncErr}
+		},
+	}
+
+	createSnapshot := func(name string) {
+		t.Helper()
+		_, err := c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+			SourceVolumeId: volumeID,
+			Name:           name,
+			Parameters: map[string]string{
+				AttributeSnapshotQuiesce:         "true",
+				ParameterVolumeSnapshotName:      name,
+				ParameterVolumeSnapshotNamespace: "default",
+			},
+			Secrets: map[string]string{SecretGuestUsername: "root", SecretGuestPassword: "secret"},
+		})
+		if err != nil {
+			t.Fatalf("CreateSnapshot(%s) failed: %v", name, err)
+		}
+	}
+	expectEvent := func(expected bool) {
+		t.Helper()
+		select {
+		case event := <-recorder.Events:
+			if !expected || !strings.Contains(event, eventReasonSnapshotNotQuiesced) {
+				t.Errorf("Unexpected event %q", event)
+			}
+		default:
+			if expected {
+				t.Errorf("Expected a %s event", eventReasonSnapshotNotQuiesced)
+			}
+		}
+	}
+
+	// a volume attached to no VM has nothing to flush
+	createSnapshot("detached")
+	if syncs != 0 {
+		t.Errorf("No VM should have been flushed, got %d flushes", syncs)
+	}
+	expectEvent(false)
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID,
+		NodeId:   vmName,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	// the VM of an attached volume is flushed
+	createSnapshot("attached")
+	if syncs != 1 {
+		t.Errorf("The VM should have been flushed once, got %d flushes", syncs)
+	}
+	expectEvent(false)
+
+	// the retries of a snapshot taken do not flush the VM again
+	createSnapshot("attached")
+	if syncs != 1 {
+		t.Errorf("The VM should have been flushed once, got %d flushes", syncs)
+	}
+
+	// the snapshot is taken crash-consistent when the flush fails
+	syncErr = errFake
+	createSnapshot("crash-consistent")
+	if syncs != 2 {
+		t.Errorf("The VM should have been flushed twice, got %d flushes", syncs)
+	}
+	expectEvent(true)
+
+	// vcsim runs no program in its guests
+	c.hooks = vsphereHooks{}
+	createSnapshot("not-quiesced")
+	expectEvent(true)
+
+	// the guest credentials are required
+	_, err = c.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		SourceVolumeId: volumeID,
+		Name:           "no-credentials",
+		Parameters:     map[string]string{AttributeSnapshotQuiesce: "true"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateSnapshot should have failed with InvalidArgument: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+var (
+	// vcRetryInitialDelay is the delay before a degraded vCenter is
+	// checked again for the first time.
+	vcRetryInitialDelay = 10 * time.Second
+
+	// vcRetryMaxDelay is the longest delay between the checks of a
+	// degraded vCenter.
+	vcRetryMaxDelay = 5 * time.Minute
+)
+
+// vcHealth tracks the vCenters that failed their checks, and the API
+// version of the ones that passed. The zero value is ready to use.
+type vcHealth struct {
+	sync.RWMutex
+
+	degraded map[string]error
+	// apiVersions is the API version of each vCenter that passed its check
+	apiVersions map[string]string
+}
+
+// setDegraded marks the vCenter as degraded.
+func (h *vcHealth) setDegraded(vc string, err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.degraded == nil {
+		h.degraded = make(map[string]error)
+	}
+	h.degraded[vc] = err
+}
+
+// setHealthy marks the vCenter as healthy.
+func (h *vcHealth) setHealthy(vc string) {
+	h.Lock()
+	defer h.Unlock()
+
+	delete(h.degraded, vc)
+}
+
+// setAPIVersion records the API version of a vCenter that passed its
+// check.
+func (h *vcHealth) setAPIVersion(vc string, apiVersion string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.apiVersions == nil {
+		h.apiVersions = make(map[string]string)
+	}
+	h.apiVersions[vc] = apiVersion
+}
+
+// apiVersion returns the API version of the vCenter, or an empty string
+// when it has not passed its check yet.
+func (h *vcHealth) apiVersion(vc string) string {
+	h.RLock()
+	defer h.RUnlock()
+
+	return h.apiVersions[vc]
+}
+
+// isDegraded returns true when the vCenter is degraded.
+func (h *vcHealth) isDegraded(vc string) bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	_, ok := h.degraded[vc]
+	return ok
+}
+
+// degradedErr returns the error the vCenter is degraded with, or nil when
+// it is not degraded.
+func (h *vcHealth) degradedErr(vc string) error {
+	h.RLock()
+	defer h.RUnlock()
+
+	return h.degraded[vc]
+}
+
+// degradedVCs returns the sorted names of the degraded vCenters.
+func (h *vcHealth) degradedVCs() []string {
+	h.RLock()
+	defer h.RUnlock()
+
+	vcs := make([]string, 0, len(h.degraded))
+	for vc := range h.degraded {
+		vcs = append(vcs, vc)
+	}
+	sort.Strings(vcs)
+	return vcs
+}
+
+// checkVC verifies that a vCenter can be reached and supports FCDs, and
+// returns its API version. The capabilities of the vCenter are recorded
+// in the connection manager.
+func checkVC(ctx context.Context, connMgr connectionManager, vc string) (string, error) {
+	api, err := connMgr.DetectCapabilities(ctx, vc)
+	if err != nil {
+		return "", err
+	}
+	if !connMgr.Capabilities(vc).Has(vclib.CapabilityFCD) {
+		return api, checkAPI(api)
+	}
+	return api, nil
+}
+
+// retryDegradedVC checks a degraded vCenter with exponential backoff until
+// it passes, after which it is marked healthy.
+func (c *controller) retryDegradedVC(ctx context.Context, vc string) {
+	delay := vcRetryInitialDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		api, err := checkVC(ctx, c.connMgr, vc)
+		if err != nil {
+			logging.Logger(ctx).Warningf("vCenter %s is still degraded. Err: %v", vc, err)
+			c.vcHealth.setDegraded(vc, err)
+			if delay *= 2; delay > vcRetryMaxDelay {
+				delay = vcRetryMaxDelay
+			}
+			continue
+		}
+
+		logging.Logger(ctx).Infof("vCenter %s is healthy", vc)
+		c.vcHealth.setAPIVersion(vc, api)
+		c.connMgr.DetectVslmCatalog(ctx, vc)
+		c.vcHealth.setHealthy(vc)
+		c.connMgr.SetDegraded(vc, false)
+		return
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVCHealth(t *testing.T) {
+	var h vcHealth
+
+	if h.isDegraded("vc1") {
+		t.Error("vc1 should not be degraded")
+	}
+
+	h.setDegraded("vc2", errors.New("unreachable"))
+	h.setDegraded("vc1", errors.New("unreachable"))
+	if !h.isDegraded("vc1") {
+		t.Error("vc1 should be degraded")
+	}
+	if vcs := h.degradedVCs(); !reflect.DeepEqual(vcs, []string{"vc1", "vc2"}) {
+		t.Errorf("degradedVCs mismatch: %v", vcs)
+	}
+
+	h.setHealthy("vc1")
+	if h.isDegraded("vc1") {
+		t.Error("vc1 should be healthy")
+	}
+	if vcs := h.degradedVCs(); !reflect.DeepEqual(vcs, []string{"vc2"}) {
+		t.Errorf("degradedVCs mismatch: %v", vcs)
+	}
+}
+
+func TestRetryDegradedVC(t *testing.T) {
+	c, _, _, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	vc := config.Global.VCenterIP
+	c.vcHealth.setDegraded(vc, errors.New("unreachable"))
+
+	// a degraded vCenter is not probed
+	if err := c.Probe(context.Background()); err != ErrNoHealthyVCenter {
+		t.Errorf("Probe should fail with %v: %v", ErrNoHealthyVCenter, err)
+	}
+
+	defer func(initial time.Duration) { vcRetryInitialDelay = initial }(vcRetryInitialDelay)
+	vcRetryInitialDelay = time.Millisecond
+
+	c.retryDegradedVC(context.Background(), vc)
+	if c.vcHealth.isDegraded(vc) {
+		t.Errorf("vCenter %s should be healthy after a successful retry", vc)
+	}
+
+	if err := c.Probe(context.Background()); err != nil {
+		t.Errorf("Probe failed: %v", err)
+	}
+}
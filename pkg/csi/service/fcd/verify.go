@@ -0,0 +1,183 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	k8s "k8s.io/cloud-provider-vsphere/pkg/common/kubernetes"
+)
+
+// VerifyCheck is the result of a check of a vCenter by Verify.
+type VerifyCheck struct {
+	// Name is the name of the check
+	Name string
+	// Result describes what was found when the check passed
+	Result string
+	// Err is why the check failed, nil when it passed
+	Err error
+}
+
+// VCVerification is the report of the checks of a vCenter by Verify. The
+// checks after a failed connection check are not run.
+type VCVerification struct {
+	VC     string
+	Checks []VerifyCheck
+}
+
+// Passed returns true when all of the checks of the vCenter passed.
+func (v *VCVerification) Passed() bool {
+	for _, check := range v.Checks {
+		if check.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks a cloud config without starting the controller. The
+// credentials are resolved as Init does, from the environment, the config
+// or the Kubernetes secrets, then each vCenter is checked in parallel: it
+// must be reached with a version supporting FCDs, its user must have the
+// privileges of the controller, and its hosts must be in the zones of the
+// configured tag categories. An error is returned when the credentials
+// cannot be resolved, before any vCenter is checked.
+func Verify(ctx context.Context, config *vcfg.Config) ([]*VCVerification, error) {
+	var connMgr *cm.ConnectionManager
+	if useK8sClient(config) {
+		client, err := k8s.NewClient(config.Global.ServiceAccount)
+		if err != nil {
+			return nil, fmt.Errorf("Creating Kubernetes client failed. Err: %v", err)
+		}
+		informMgr := k8s.NewInformer(client)
+		connMgr = cm.NewConnectionManager(config, informMgr.GetSecretListener())
+		if err := syncCredentialSecrets(informMgr, connMgr); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := checkNoCredentialSecrets(config); err != nil {
+			return nil, err
+		}
+		connMgr = cm.NewConnectionManager(config, nil)
+	}
+	defer connMgr.Close()
+
+	return verifyVCs(ctx, config, connMgr), nil
+}
+
+// verifyVCs checks all of the vCenters of the connection manager, and
+// returns their reports sorted by vCenter.
+func verifyVCs(ctx context.Context, config *vcfg.Config, connMgr *cm.ConnectionManager) []*VCVerification {
+	var mutex sync.Mutex
+	var reports []*VCVerification
+
+	connMgr.ForEachVC(ctx, func(ctx context.Context, vc string) error {
+		ctx, cancel := withOperationTimeout(ctx)
+		defer cancel()
+
+		report := verifyVC(ctx, config, connMgr, vc)
+		mutex.Lock()
+		reports = append(reports, report)
+		mutex.Unlock()
+		return nil
+	})
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].VC < reports[j].VC
+	})
+	return reports
+}
+
+// verifyVC runs the checks of a vCenter.
+func verifyVC(ctx context.Context, config *vcfg.Config, connMgr *cm.ConnectionManager, vc string) *VCVerification {
+	report := &VCVerification{VC: vc}
+
+	api, err := checkVC(ctx, connMgr, vc)
+	report.Checks = append(report.Checks, VerifyCheck{
+		Name:   "connection",
+		Result: fmt.Sprintf("API version %s with capabilities %s", api, connMgr.Capabilities(vc)),
+		Err:    err,
+	})
+	if err != nil {
+		return report
+	}
+
+	check := VerifyCheck{Name: "permissions", Result: "all of the required privileges are granted"}
+	missing, err := missingPermissions(ctx, config, connMgr, vc)
+	if err == nil {
+		err = missingPermissionsErr(missing)
+	}
+	check.Err = err
+	report.Checks = append(report.Checks, check)
+
+	report.Checks = append(report.Checks, verifyZones(ctx, config, connMgr, vc))
+	return report
+}
+
+// verifyZones resolves the zones and regions of the hosts of a vCenter from
+// the configured tag categories. The check fails when no host is in a
+// zone, as no volume could then be provisioned with a topology
+// requirement.
+func verifyZones(ctx context.Context, config *vcfg.Config, connMgr *cm.ConnectionManager, vc string) VerifyCheck {
+	check := VerifyCheck{Name: "zones"}
+
+	zoneLabel, regionLabel := config.Labels.Zone, config.Labels.Region
+	if len(zoneLabel) == 0 && len(regionLabel) == 0 {
+		check.Result = "no zone or region tag category is configured"
+		return check
+	}
+
+	datacenters, err := connMgr.ListDatacenters(ctx, vc)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	hosts := 0
+	seen := make(map[cm.HostZone]bool)
+	for _, datacenter := range datacenters {
+		hostZones, err := connMgr.LookupHostZones(ctx, datacenter, zoneLabel, regionLabel)
+		if err != nil {
+			check.Err = err
+			return check
+		}
+		hosts += len(hostZones)
+		for _, hostZone := range hostZones {
+			seen[cm.HostZone{Zone: hostZone.Zone, Region: hostZone.Region}] = true
+		}
+	}
+	if hosts == 0 {
+		check.Err = fmt.Errorf("no host is tagged with the zone category %q and region category %q",
+			zoneLabel, regionLabel)
+		return check
+	}
+
+	zones := make([]string, 0, len(seen))
+	for hostZone := range seen {
+		zones = append(zones, fmt.Sprintf("zone=%s region=%s", hostZone.Zone, hostZone.Region))
+	}
+	sort.Strings(zones)
+	check.Result = fmt.Sprintf("%d hosts in %s", hosts, strings.Join(zones, ", "))
+	return check
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/simulator/vpx"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"golang.org/x/net/context"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestVerify(t *testing.T) {
+	config, cleanup := configFromSim(true)
+	defer cleanup()
+
+	//context
+	ctx := context.Background()
+
+	config.Global.DisableK8sClient = true
+	vc := config.Global.VCenterIP
+
+	authManager := &privilegesAuthorizationManager{
+		AuthorizationManager: simulator.Map.Get(*vpx.ServiceContent.AuthorizationManager).(*simulator.AuthorizationManager),
+		granted: map[string][]string{
+			"Folder":     vcenterPrivileges,
+			"Datacenter": datacenterPrivileges,
+			"Datastore":  datastorePrivileges,
+		},
+	}
+	simulator.Map.Put(authManager)
+
+	verify := func() *VCVerification {
+		reports, err := Verify(ctx, config)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if len(reports) != 1 || reports[0].VC != vc {
+			t.Fatalf("Expected the report of vc=%s, got %+v", vc, reports)
+		}
+		return reports[0]
+	}
+	checkErr := func(report *VCVerification, name string) error {
+		for _, check := range report.Checks {
+			if check.Name == name {
+				return check.Err
+			}
+		}
+		t.Fatalf("Check %s was not run: %+v", name, report.Checks)
+		return nil
+	}
+
+	// no host is in a zone yet
+	report := verify()
+	if report.Passed() {
+		t.Fatal("Verify should fail without any zone tag")
+	}
+	if err := checkErr(report, "connection"); err != nil {
+		t.Errorf("The connection check failed: %v", err)
+	}
+	if err := checkErr(report, "permissions"); err != nil {
+		t.Errorf("The permissions check failed: %v", err)
+	}
+	if err := checkErr(report, "zones"); err == nil || !strings.Contains(err.Error(), "k8s-zone") {
+		t.Errorf("The zones check should fail naming the zone category: %v", err)
+	}
+
+	// the hosts of DC0 are in a zone
+	connMgr := cm.NewConnectionManager(config, nil)
+	defer connMgr.Logout()
+	if err := connMgr.Connect(ctx, vc); err != nil {
+		t.Fatalf("Failed to Connect to vSphere: %s", err)
+	}
+	vsi := connMgr.VsphereInstanceMap[vc]
+	restClient := rest.NewClient(vsi.Conn.Client)
+	if err := restClient.Login(ctx, url.UserPassword(vsi.Conn.Username, vsi.Conn.Password)); err != nil {
+		t.Fatalf("Rest login failed. err=%v", err)
+	}
+	m := tags.NewManager(restClient)
+	dc0, err := vclib.GetDatacenter(ctx, vsi.Conn, "DC0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for category, tag := range map[string]string{
+		config.Labels.Region: "k8s-region-US",
+		config.Labels.Zone:   "k8s-zone-US-west",
+	} {
+		categoryID, err := m.CreateCategory(ctx, &tags.Category{Name: category})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tagID, err := m.CreateTag(ctx, &tags.Tag{CategoryID: categoryID, Name: tag})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = m.AttachTag(ctx, tagID, dc0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report = verify()
+	if !report.Passed() {
+		t.Fatalf("Verify should pass: %+v", report.Checks)
+	}
+	if result := report.Checks[len(report.Checks)-1].Result; !strings.Contains(result, "zone=k8s-zone-US-west region=k8s-region-US") {
+		t.Errorf("The zones check should report the zone of the hosts: %s", result)
+	}
+
+	// a missing privilege fails the vCenter
+	authManager.granted["Datastore"] = []string{"Datastore.AllocateSpace"}
+	report = verify()
+	if err := checkErr(report, "permissions"); err == nil || !strings.Contains(err.Error(), "Datastore.FileManagement") {
+		t.Errorf("The permissions check should fail without Datastore.FileManagement: %v", err)
+	}
+
+	// the checks of an unreachable vCenter stop at the connection
+	config.VirtualCenter["localhost"] = &vcfg.VirtualCenterConfig{
+		User:         config.Global.User,
+		Password:     config.Global.Password,
+		VCenterPort:  "1",
+		InsecureFlag: true,
+		Datacenters:  "DC0",
+	}
+	reports, err := Verify(ctx, config)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(reports) != 2 || reports[1].VC != "localhost" {
+		t.Fatalf("Expected the sorted reports of both vCenters, got %+v", reports)
+	}
+	if reports[1].Passed() || len(reports[1].Checks) != 1 || reports[1].Checks[0].Name != "connection" {
+		t.Errorf("Only the connection check of the unreachable vCenter should run and fail: %+v", reports[1].Checks)
+	}
+
+	// a secret cannot be read without the Kubernetes client
+	delete(config.VirtualCenter, "localhost")
+	config.VirtualCenter[vc].SecretName = "vsphere-creds"
+	config.VirtualCenter[vc].SecretNamespace = "kube-system"
+	if _, err := Verify(ctx, config); err == nil {
+		t.Error("Verify should fail with a secret and without the Kubernetes client")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// attachBatchWindow is how long the queue of a VM waits for more attachments
+// before reconfiguring the VM, so that the volumes attached together, e.g.
+// to the pods rescheduled by a node drain, need a single reconfigure.
+var attachBatchWindow = 100 * time.Millisecond
+
+// attachment is a disk waiting in the queue of a VM, and the result of its
+// attachment once done is closed.
+type attachment struct {
+	disk     vclib.DiskAttachment
+	diskUUID string
+	err      error
+	done     chan struct{}
+}
+
+// vmQueue batches the attachments to a VM and serializes its reconfigures.
+type vmQueue struct {
+	key string
+	// reconfigure is held while the VM is reconfigured
+	reconfigure sync.Mutex
+	// pending are the attachments waiting for the next batch, guarded by
+	// the lock of the vmQueues
+	pending []*attachment
+	// users is the number of the reconfigures and attachments that hold the
+	// queue, guarded by the lock of the vmQueues. The queue is removed once
+	// idle.
+	users int
+}
+
+// vmQueues holds the queues of the VMs, keyed by vCenter and VM reference.
+// The zero value is ready to use.
+type vmQueues struct {
+	sync.Mutex
+
+	queues map[string]*vmQueue
+}
+
+// vmQueueKey returns the key of the queue of the VM.
+func vmQueueKey(vm *vclib.VirtualMachine) string {
+	return vm.Client().URL().Host + "/" + vm.Reference().Value
+}
+
+// acquire returns the queue of the VM, which is held until released.
+func (q *vmQueues) acquire(key string) *vmQueue {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.queues == nil {
+		q.queues = make(map[string]*vmQueue)
+	}
+	queue, ok := q.queues[key]
+	if !ok {
+		queue = &vmQueue{key: key}
+		q.queues[key] = queue
+	}
+	queue.users++
+	return queue
+}
+
+// release releases the queue of the VM, and removes it once idle.
+func (q *vmQueues) release(queue *vmQueue) {
+	q.Lock()
+	defer q.Unlock()
+
+	queue.users--
+	if queue.users == 0 {
+		delete(q.queues, queue.key)
+	}
+}
+
+// attach queues the attachment of the disk to the VM, and returns the UUID
+// of the disk once the batch it joined is attached. The attachments queued
+// within the attachBatchWindow of the first one are attached together. When
+// the context is done first, the attachment still goes on, and a retry finds
+// the disk attached.
+func (q *vmQueues) attach(ctx context.Context, key string, vm vmOps, disk vclib.DiskAttachment) (string, error) {
+	// the attachment holds the queue until its batch is attached
+	queue := q.acquire(key)
+	a := &attachment{disk: disk, done: make(chan struct{})}
+
+	q.Lock()
+	queue.pending = append(queue.pending, a)
+	first := len(queue.pending) == 1
+	q.Unlock()
+	if first {
+		go q.flush(queue, vm)
+	}
+
+	select {
+	case <-a.done:
+		return a.diskUUID, a.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// flush attaches the pending attachments of the VM with a single reconfigure,
+// once the batch window is over and the previous reconfigure is done.
+func (q *vmQueues) flush(queue *vmQueue, vm vmOps) {
+	time.Sleep(attachBatchWindow)
+
+	queue.reconfigure.Lock()
+	defer queue.reconfigure.Unlock()
+
+	q.Lock()
+	batch := queue.pending
+	queue.pending = nil
+	q.Unlock()
+
+	// the batch outlives the requests that joined it
+	ctx, cancel := withOperationTimeout(context.Background())
+	defer cancel()
+
+	// the attachments that failed with a transient fault are retried
+	pending := batch
+	retryTransient(ctx, func() error {
+		disks := make([]vclib.DiskAttachment, len(pending))
+		for i, a := range pending {
+			disks[i] = a.disk
+		}
+		diskUUIDs, errs := vm.AttachDisks(ctx, disks)
+
+		var retries []*attachment
+		var transient error
+		for i, a := range pending {
+			a.diskUUID, a.err = diskUUIDs[i], errs[i]
+			if vclib.IsTransient(a.err) {
+				retries = append(retries, a)
+				transient = a.err
+			}
+		}
+		pending = retries
+		return transient
+	})
+
+	// the queue is released before the attachments return
+	for range batch {
+		q.release(queue)
+	}
+	for _, a := range batch {
+		close(a.done)
+	}
+}
+
+// serialize runs the reconfigure of the VM once the previous one is done.
+func (q *vmQueues) serialize(key string, reconfigure func() error) error {
+	queue := q.acquire(key)
+	defer q.release(queue)
+
+	queue.reconfigure.Lock()
+	defer queue.reconfigure.Unlock()
+
+	return reconfigure()
+}
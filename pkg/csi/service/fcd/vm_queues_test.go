@@ -0,0 +1,224 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// batchVM records the batches of disks attached to it, and fails the disks
+// named "bad".
+type batchVM struct {
+	sync.Mutex
+	batches [][]string
+	// reconfiguring is set while a batch is attached
+	reconfiguring bool
+	overlapped    bool
+}
+
+func (v *batchVM) AttachDisks(ctx context.Context, disks []vclib.DiskAttachment) ([]string, []error) {
+	v.begin()
+	defer v.end()
+
+	v.Lock()
+	var batch []string
+	for _, disk := range disks {
+		batch = append(batch, disk.VMDiskPath)
+	}
+	v.batches = append(v.batches, batch)
+	v.Unlock()
+
+	diskUUIDs := make([]string, len(disks))
+	errs := make([]error, len(disks))
+	for i, disk := range disks {
+		if disk.VMDiskPath == "bad" {
+			errs[i] = errors.New("bad disk")
+			continue
+		}
+		diskUUIDs[i] = "uuid-" + disk.VMDiskPath
+	}
+	return diskUUIDs, errs
+}
+
+func (v *batchVM) DetachDisk(ctx context.Context, vmDiskPath string) error {
+	v.begin()
+	defer v.end()
+	return nil
+}
+
+func (v *batchVM) SyncGuestFileSystems(ctx context.Context, username string, password string) error {
+	return nil
+}
+
+func (v *batchVM) begin() {
+	v.Lock()
+	if v.reconfiguring {
+		v.overlapped = true
+	}
+	v.reconfiguring = true
+	v.Unlock()
+	time.Sleep(10 * time.Millisecond)
+}
+
+func (v *batchVM) end() {
+	v.Lock()
+	v.reconfiguring = false
+	v.Unlock()
+}
+
+func TestVMQueuesBatchAttachments(t *testing.T) {
+	var queues vmQueues
+	vm := &batchVM{}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	disks := []string{"disk0", "disk1", "disk2", "bad", "disk4"}
+	diskUUIDs := make([]string, len(disks))
+	errs := make([]error, len(disks))
+	for i, disk := range disks {
+		wg.Add(1)
+		go func(i int, disk string) {
+			defer wg.Done()
+			diskUUIDs[i], errs[i] = queues.attach(ctx, "vc/vm-1", vm, vclib.DiskAttachment{VMDiskPath: disk})
+		}(i, disk)
+	}
+	// a detach meanwhile waits for the batch
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(attachBatchWindow / 2)
+		if err := queues.serialize("vc/vm-1", func() error { return vm.DetachDisk(ctx, "disk5") }); err != nil {
+			t.Errorf("Detach failed: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if len(vm.batches) != 1 || len(vm.batches[0]) != len(disks) {
+		t.Errorf("Expected the disks to be attached in a single batch, got %v", vm.batches)
+	}
+	for i, disk := range disks {
+		if disk == "bad" {
+			if errs[i] == nil {
+				t.Errorf("Attaching %s should have failed", disk)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("Attaching %s failed: %v", disk, errs[i])
+		}
+		if diskUUIDs[i] != "uuid-"+disk {
+			t.Errorf("Unexpected UUID %q for %s", diskUUIDs[i], disk)
+		}
+	}
+	if vm.overlapped {
+		t.Error("The reconfigures of the VM overlapped")
+	}
+
+	// a later attachment starts another batch
+	if _, err := queues.attach(ctx, "vc/vm-1", vm, vclib.DiskAttachment{VMDiskPath: "disk6"}); err != nil {
+		t.Errorf("Attaching disk6 failed: %v", err)
+	}
+	if len(vm.batches) != 2 {
+		t.Errorf("Expected a second batch, got %v", vm.batches)
+	}
+}
+
+func TestVMQueuesSeparateVMs(t *testing.T) {
+	var queues vmQueues
+	vms := []*batchVM{{}, {}}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i, vm := range vms {
+		for j := 0; j < 3; j++ {
+			wg.Add(1)
+			go func(key string, vm *batchVM, disk string) {
+				defer wg.Done()
+				if _, err := queues.attach(ctx, key, vm, vclib.DiskAttachment{VMDiskPath: disk}); err != nil {
+					t.Errorf("Attaching %s failed: %v", disk, err)
+				}
+			}(fmt.Sprintf("vc/vm-%d", i), vm, fmt.Sprintf("disk%d", j))
+		}
+	}
+	wg.Wait()
+
+	for i, vm := range vms {
+		if len(vm.batches) != 1 || len(vm.batches[0]) != 3 {
+			t.Errorf("Expected the disks of VM %d to be attached in a single batch, got %v", i, vm.batches)
+		}
+	}
+}
+
+func TestVMQueuesCancelledAttachment(t *testing.T) {
+	var queues vmQueues
+	vm := &batchVM{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := queues.attach(ctx, "vc/vm-1", vm, vclib.DiskAttachment{VMDiskPath: "disk0"}); err != context.Canceled {
+		t.Errorf("Expected the attachment to be cancelled, got %v", err)
+	}
+
+	// the attachment still goes on
+	time.Sleep(2 * attachBatchWindow)
+	if err := queues.serialize("vc/vm-1", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	vm.Lock()
+	defer vm.Unlock()
+	if len(vm.batches) != 1 {
+		t.Errorf("Expected the cancelled attachment to go on, got %v", vm.batches)
+	}
+}
+
+func TestVMQueuesRemoveIdleQueues(t *testing.T) {
+	var queues vmQueues
+	vm := &batchVM{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := queues.attach(ctx, "vc/vm-1", vm, vclib.DiskAttachment{VMDiskPath: "disk0"}); err != context.Canceled {
+		t.Errorf("Expected the attachment to be cancelled, got %v", err)
+	}
+
+	// the queue is held until the cancelled attachment goes on
+	queues.Lock()
+	if _, ok := queues.queues["vc/vm-1"]; !ok {
+		t.Error("The queue of the pending attachment was removed")
+	}
+	queues.Unlock()
+
+	if _, err := queues.attach(context.Background(), "vc/vm-1", vm, vclib.DiskAttachment{VMDiskPath: "disk1"}); err != nil {
+		t.Errorf("Attaching disk1 failed: %v", err)
+	}
+	if err := queues.serialize("vc/vm-2", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	queues.Lock()
+	defer queues.Unlock()
+	if len(queues.queues) != 0 {
+		t.Errorf("Expected the idle queues to be removed, got %v", queues.queues)
+	}
+}
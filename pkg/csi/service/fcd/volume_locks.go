@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"golang.org/x/net/context"
+)
+
+// volumeLocks is a set of keyed locks used to ensure that at most one
+// operation is in flight for a given volume. It also tracks these
+// operations, so that they can be drained on shutdown. The zero value is
+// ready to use.
+type volumeLocks struct {
+	// the operation holding each lock, by key
+	operations operationTracker
+}
+
+// tryAcquire acquires the lock for the key without blocking. It returns
+// false when the lock is already held or the locks are being drained.
+// Otherwise it returns the context of the operation, which is cancelled
+// when the operation outlives the drain timeout.
+func (l *volumeLocks) tryAcquire(ctx context.Context, key string) (context.Context, bool) {
+	return l.operations.tryStart(ctx, key)
+}
+
+// release releases the lock for the key.
+func (l *volumeLocks) release(key string) {
+	l.operations.finish(key)
+}
+
+// drain refuses the locks from now on and waits for the operations holding
+// one to release it. When the context is done first, the contexts of these
+// operations are cancelled and drain waits for them to return. It returns
+// the number of operations that were cancelled.
+func (l *volumeLocks) drain(ctx context.Context) int {
+	return l.operations.drain(ctx)
+}
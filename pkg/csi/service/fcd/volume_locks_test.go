@@ -0,0 +1,171 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestVolumeLocks(t *testing.T) {
+	var locks volumeLocks
+	ctx := context.Background()
+
+	if _, ok := locks.tryAcquire(ctx, "vol1"); !ok {
+		t.Fatal("Failed to acquire an unheld lock")
+	}
+	if _, ok := locks.tryAcquire(ctx, "vol1"); ok {
+		t.Error("Acquired a lock that is already held")
+	}
+	if _, ok := locks.tryAcquire(ctx, "vol2"); !ok {
+		t.Error("Failed to acquire a lock for a different volume")
+	}
+
+	locks.release("vol1")
+	if _, ok := locks.tryAcquire(ctx, "vol1"); !ok {
+		t.Error("Failed to acquire a released lock")
+	}
+}
+
+func TestVolumeLocksDrain(t *testing.T) {
+	var locks volumeLocks
+	ctx := context.Background()
+
+	// vol1 is released during the drain, vol2 outlives the drain timeout
+	_, ok := locks.tryAcquire(ctx, "vol1")
+	if !ok {
+		t.Fatal("Failed to acquire an unheld lock")
+	}
+	vol2Ctx, ok := locks.tryAcquire(ctx, "vol2")
+	if !ok {
+		t.Fatal("Failed to acquire an unheld lock")
+	}
+	go func() {
+		locks.release("vol1")
+		<-vol2Ctx.Done()
+		locks.release("vol2")
+	}()
+
+	drainCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if cancelled := locks.drain(drainCtx); cancelled != 1 {
+		t.Errorf("drain should cancel 1 operation: %d", cancelled)
+	}
+	if vol2Ctx.Err() != context.Canceled {
+		t.Errorf("the context of vol2 should be cancelled: %v", vol2Ctx.Err())
+	}
+
+	if _, ok := locks.tryAcquire(ctx, "vol3"); ok {
+		t.Error("Acquired a lock after the drain")
+	}
+}
+
+func TestVolumeOperationInProgress(t *testing.T) {
+	c := &controller{}
+	ctx := context.Background()
+
+	c.volumeLocks.tryAcquire(ctx, "vol1")
+	defer c.volumeLocks.release("vol1")
+
+	_, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "vol1",
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		},
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("CreateVolume should have failed with Aborted: %v", err)
+	}
+
+	_, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{
+		VolumeId: "vol1",
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("DeleteVolume should have failed with Aborted: %v", err)
+	}
+
+	_, err = c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: "vol1",
+		NodeId:   "node1",
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("ControllerPublishVolume should have failed with Aborted: %v", err)
+	}
+
+	_, err = c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: "vol1",
+		NodeId:   "node1",
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("ControllerUnpublishVolume should have failed with Aborted: %v", err)
+	}
+
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: "vol1",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: GbInBytes,
+		},
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("ControllerExpandVolume should have failed with Aborted: %v", err)
+	}
+}
+
+func TestCreateVolumeLocksVolumeID(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	req := &csi.CreateVolumeRequest{
+		Name:          "locked",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	}
+	resp, err := c.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := resp.Volume.VolumeId
+
+	// a retried create waits for the operations on the volume by its ID
+	c.volumeLocks.tryAcquire(ctx, volumeID)
+	_, err = c.CreateVolume(ctx, req)
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("CreateVolume should have failed with Aborted: %v", err)
+	}
+	c.volumeLocks.release(volumeID)
+
+	if _, err = c.CreateVolume(ctx, req); err != nil {
+		t.Errorf("CreateVolume retry failed: %v", err)
+	}
+
+	// and releases the lock of the ID once done
+	if _, err = c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Errorf("DeleteVolume failed: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// requestedVolumeSize returns the size, in bytes, of the volume to create:
+// the required bytes of its capacity range, or else the default size of its
+// StorageClass or of the configuration. It also returns whether the request
+// set the size.
+func (c *controller) requestedVolumeSize(ctx context.Context, req *csi.CreateVolumeRequest) (int64, bool, error) {
+	if required := req.GetCapacityRange().GetRequiredBytes(); required != 0 {
+		return required, true, nil
+	}
+
+	if defaultSize := req.GetParameters()[AttributeFirstClassDiskDefaultSizeGB]; len(defaultSize) > 0 {
+		sizeGB, err := strconv.ParseUint(defaultSize, 10, 32)
+		if err != nil || sizeGB == 0 {
+			msg := fmt.Sprintf("Volume parameter %s must be a positive number of GiB.", AttributeFirstClassDiskDefaultSizeGB)
+			logging.Logger(ctx).Errorf(msg)
+			return 0, false, status.Errorf(codes.InvalidArgument, msg)
+		}
+		return int64(sizeGB) * GbInBytes, false, nil
+	}
+
+	if c.cfg.Global.DefaultVolumeSizeGB > 0 {
+		return int64(c.cfg.Global.DefaultVolumeSizeGB) * GbInBytes, false, nil
+	}
+	return DefaultGbDiskSize * GbInBytes, false, nil
+}
+
+// checkVolumeSize checks the size of a volume, once rounded up to the unit
+// it is allocated in, against the limit of its capacity range and against
+// the minimum and maximum volume sizes of the configuration.
+func (c *controller) checkVolumeSize(ctx context.Context, volSizeMB int64, capRange *csi.CapacityRange) error {
+	logger := logging.Logger(ctx)
+
+	if limit := capRange.GetLimitBytes(); limit > 0 && volSizeMB*MbInBytes > limit {
+		msg := fmt.Sprintf("Requested size %d MB exceeds limit of %d bytes", volSizeMB, limit)
+		logger.Error(msg)
+		return status.Errorf(codes.OutOfRange, msg)
+	}
+	if min := int64(c.cfg.Global.MinVolumeSizeGB) * 1024; volSizeMB < min {
+		msg := fmt.Sprintf("Requested size %d MB is below the minimum volume size of %d GiB",
+			volSizeMB, c.cfg.Global.MinVolumeSizeGB)
+		logger.Error(msg)
+		return status.Errorf(codes.InvalidArgument, msg)
+	}
+	if max := int64(c.cfg.Global.MaxVolumeSizeGB) * 1024; max > 0 && volSizeMB > max {
+		msg := fmt.Sprintf("Requested size %d MB exceeds the maximum volume size of %d GiB",
+			volSizeMB, c.cfg.Global.MaxVolumeSizeGB)
+		logger.Error(msg)
+		return status.Errorf(codes.InvalidArgument, msg)
+	}
+	return nil
+}
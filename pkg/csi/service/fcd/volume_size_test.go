@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestVolumeSize(t *testing.T) {
+	c, _, _, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+	config := c.cfg
+
+	ctx := context.Background()
+
+	config.Global.DefaultVolumeSizeGB = 3
+	config.Global.MinVolumeSizeGB = 2
+	config.Global.MaxVolumeSizeGB = 8
+
+	tests := []struct {
+		name      string
+		params    map[string]string
+		capRange  *csi.CapacityRange
+		code      codes.Code
+		sizeBytes int64
+	}{
+		{"default of the config", nil, nil, codes.OK, 3 * GbInBytes},
+		{"default of the StorageClass", map[string]string{AttributeFirstClassDiskDefaultSizeGB: "5"}, nil, codes.OK, 5 * GbInBytes},
+		{"requested", map[string]string{AttributeFirstClassDiskDefaultSizeGB: "5"},
+			&csi.CapacityRange{RequiredBytes: 4 * GbInBytes}, codes.OK, 4 * GbInBytes},
+		{"invalid default", map[string]string{AttributeFirstClassDiskDefaultSizeGB: "0"}, nil, codes.InvalidArgument, 0},
+		{"rounded past the limit", nil, &csi.CapacityRange{RequiredBytes: 2*GbInBytes + 1, LimitBytes: 2*GbInBytes + MbInBytes},
+			codes.OutOfRange, 0},
+		{"below the minimum", nil, &csi.CapacityRange{RequiredBytes: GbInBytes}, codes.InvalidArgument, 0},
+		{"default below the minimum", map[string]string{AttributeFirstClassDiskDefaultSizeGB: "1"}, nil, codes.InvalidArgument, 0},
+		{"above the maximum", nil, &csi.CapacityRange{RequiredBytes: 9 * GbInBytes}, codes.InvalidArgument, 0},
+	}
+	for i, test := range tests {
+		params := map[string]string{AttributeFirstClassDiskParentType: string(vclib.TypeDatastore)}
+		for k, v := range test.params {
+			params[k] = v
+		}
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          fmt.Sprintf("size-%d", i),
+			Parameters:    params,
+			CapacityRange: test.capRange,
+		})
+		if status.Code(err) != test.code {
+			t.Errorf("[%s] CreateVolume should have returned %v: %v", test.name, test.code, err)
+			continue
+		}
+		if err == nil && resp.Volume.CapacityBytes != test.sizeBytes {
+			t.Errorf("[%s] Unexpected size %d, expected %d", test.name, resp.Volume.CapacityBytes, test.sizeBytes)
+		}
+	}
+
+	// the volumes cannot be expanded past the maximum either
+	resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "size-expand",
+		Parameters: map[string]string{AttributeFirstClassDiskParentType: string(vclib.TypeDatastore)},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	_, err = c.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      resp.Volume.VolumeId,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 9 * GbInBytes},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerExpandVolume should have failed with InvalidArgument: %v", err)
+	}
+}
+
+func TestCapacityBytes(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		requiredBytes int64
+		capacityBytes int64
+	}{
+		{"1Gi", 1073741824, 1073741824},
+		{"1.5Gi", 1610612736, 2147483648},
+		{"10Ti", 10995116277760, 10995116277760},
+	}
+	capacities := make(map[string]int64)
+	for _, test := range tests {
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name: "capacity-" + test.name,
+			Parameters: map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskParentName: myds.Name,
+			},
+			CapacityRange: &csi.CapacityRange{RequiredBytes: test.requiredBytes},
+		})
+		if err != nil {
+			t.Fatalf("[%s] CreateVolume failed: %v", test.name, err)
+		}
+		if resp.Volume.CapacityBytes != test.capacityBytes {
+			t.Errorf("[%s] CreateVolume returned %d bytes, expected %d", test.name, resp.Volume.CapacityBytes, test.capacityBytes)
+		}
+		capacities[resp.Volume.VolumeId] = test.capacityBytes
+	}
+
+	respList, err := c.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes failed: %v", err)
+	}
+	for _, entry := range respList.Entries {
+		capacityBytes, ok := capacities[entry.Volume.VolumeId]
+		if !ok {
+			continue
+		}
+		if entry.Volume.CapacityBytes != capacityBytes {
+			t.Errorf("ListVolumes returned %d bytes for %s, expected %d",
+				entry.Volume.CapacityBytes, entry.Volume.VolumeId, capacityBytes)
+		}
+		delete(capacities, entry.Volume.VolumeId)
+	}
+	if len(capacities) != 0 {
+		t.Errorf("ListVolumes did not return the volumes %v", capacities)
+	}
+}
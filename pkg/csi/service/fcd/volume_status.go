@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// publishedNodeIDs returns the IDs of the nodes whose VMs have the FCD of a
+// volume attached. The ID of a node is the BIOS UUID of its VM, as reported
+// by NodeGetInfo.
+func publishedNodeIDs(ctx context.Context, fcd *vclib.FirstClassDiskInfo) ([]string, error) {
+	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+	vms, err := fcd.DatastoreInfo.GetVMsWithDisk(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeIDs := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		uuid, err := vm.GetVMUUID()
+		if err != nil {
+			return nil, err
+		}
+		nodeIDs = append(nodeIDs, strings.ToLower(uuid))
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs, nil
+}
+
+// volumeCondition returns the condition of a volume, which is abnormal when
+// the datastore of its FCD is not accessible or in maintenance mode, or the
+// backing file of the FCD is missing.
+func volumeCondition(ctx context.Context, fcd *vclib.FirstClassDiskInfo) (*csi.VolumeCondition, error) {
+	datastoreName := fcd.DatastoreInfo.Info.Name
+	summary, err := fcd.DatastoreInfo.GetSummary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !summary.Accessible {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Datastore %s of the volume is not accessible", datastoreName),
+		}, nil
+	}
+	switch types.DatastoreSummaryMaintenanceModeState(summary.MaintenanceMode) {
+	case types.DatastoreSummaryMaintenanceModeStateInMaintenance,
+		types.DatastoreSummaryMaintenanceModeStateEnteringMaintenance:
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Datastore %s of the volume is in maintenance mode", datastoreName),
+		}, nil
+	}
+
+	filePath := fcd.Config.Backing.(*types.BaseConfigInfoDiskFileBackingInfo).FilePath
+	var path object.DatastorePath
+	if !path.FromString(filePath) {
+		return nil, fmt.Errorf("Invalid backing file path %s", filePath)
+	}
+	_, err = fcd.DatastoreInfo.Stat(ctx, path.Path)
+	if _, ok := err.(object.DatastoreNoSuchFileError); ok {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Backing file %s of the volume is missing", filePath),
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &csi.VolumeCondition{Message: "Volume is healthy"}, nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// connectionManager is the connection manager the controller uses to
+// connect to the vCenters and to locate their datacenters, VMs and FCDs.
+// *cm.ConnectionManager implements it, and the tests fake it to inject
+// faults without a simulator.
+type connectionManager interface {
+	Connect(ctx context.Context, vcenter string) error
+	ConnectByInstance(ctx context.Context, vsphereInstance *cm.VSphereInstance) error
+	VsphereInstances() map[string]*cm.VSphereInstance
+	ForEachVC(ctx context.Context, fn func(ctx context.Context, vc string) error) error
+	VCHealth(vcenter string) error
+	SetDegraded(vc string, degraded bool)
+	Close()
+
+	DetectCapabilities(ctx context.Context, vc string) (string, error)
+	Capabilities(vc string) vclib.Capabilities
+	HasCapability(ctx context.Context, vc string, capability vclib.Capability) bool
+	SkipsAPIVersionGates() bool
+	DetectVslmCatalog(ctx context.Context, vc string) (bool, error)
+	VslmCatalogDetected(vc string) bool
+
+	ListAllVCandDCPairs(ctx context.Context) ([]*cm.ListDiscoveryInfo, error)
+	ListDatacenters(ctx context.Context, vc string) ([]*vclib.Datacenter, error)
+	LookupHostZones(ctx context.Context, dataCenter *vclib.Datacenter,
+		zoneLabel string, regionLabel string) ([]cm.HostZone, error)
+	EnsureTag(ctx context.Context, vcServer string, categoryName string, tagName string) error
+
+	WhichVCandDCByZone(ctx context.Context,
+		zoneLabel string, regionLabel string, zoneLooking string, regionLooking string) (*cm.ZoneDiscoveryInfo, error)
+	WhichVCandDCByFCDId(ctx context.Context, fcdID string) (*cm.FcdDiscoveryInfo, error)
+	WhichVCandDCByNodeID(ctx context.Context, nodeID string, searchBy cm.FindVM) (*cm.VMDiscoveryInfo, error)
+	CatalogFirstClassDisks(ctx context.Context, vc string, datacenters []*vclib.Datacenter,
+		allowed func(name string) bool, query ...vclib.VslmQuerySpec) ([]*vclib.FirstClassDiskInfo, bool, error)
+
+	IndexFirstClassDisk(vcServer string, fcd *vclib.FirstClassDiskInfo)
+	PinFirstClassDiskDatacenter(fcdID string, vcServer string, datacenter string)
+	UnindexFirstClassDisk(fcdID string)
+	ResetFirstClassDiskIndex()
+	BuildFirstClassDiskIndex(ctx context.Context) error
+	FirstClassDiskIndexSize() int
+}
+
+// datacenterOps are the operations of a datacenter the volume handlers use
+// to manage the FCDs and to find the VMs of the nodes. *vclib.Datacenter
+// implements them.
+type datacenterOps interface {
+	CreateFirstClassDiskWithOptions(ctx context.Context,
+		datastoreName string, datastoreType vclib.ParentDatastoreType,
+		diskName string, diskSize int64, volumeOptions *vclib.VolumeOptions) error
+	GetFirstClassDisk(ctx context.Context,
+		datastoreName string, datastoreType vclib.ParentDatastoreType,
+		diskID string, findBy vclib.FindFCD) (*vclib.FirstClassDiskInfo, error)
+	DeleteFirstClassDisk(ctx context.Context,
+		datastoreName string, datastoreType vclib.ParentDatastoreType, diskID string) error
+	GetVMByDNSName(ctx context.Context, dnsName string) (*vclib.VirtualMachine, error)
+}
+
+// vmOps are the operations of a VM the volume handlers use to attach and
+// detach the FCDs, and to quiesce them before their snapshots.
+// *vclib.VirtualMachine implements them.
+type vmOps interface {
+	AttachDisks(ctx context.Context, disks []vclib.DiskAttachment) ([]string, []error)
+	DetachDisk(ctx context.Context, vmDiskPath string) error
+	SyncGuestFileSystems(ctx context.Context, username string, password string) error
+}
+
+// fileShareOps are the operations of the vSAN file service the volume
+// handlers use to manage the file shares. *vclib.VsanFileServiceClient
+// implements them.
+type fileShareOps interface {
+	CreateFileShare(ctx context.Context, cluster types.ManagedObjectReference, config vclib.VsanFileShareConfig) error
+	RemoveFileShare(ctx context.Context, cluster types.ManagedObjectReference, uuid string) error
+	GetFileShare(ctx context.Context, cluster types.ManagedObjectReference, uuid string, name string) (*vclib.VsanFileShare, error)
+}
+
+// cnsOps are the operations of the Cloud Native Storage of vCenter the
+// controller uses to describe the FCDs to the vSphere Client.
+// *vclib.CnsClient implements them.
+type cnsOps interface {
+	RegisterVolume(ctx context.Context, fcdID string, name string,
+		datastore types.ManagedObjectReference, metadata vclib.CnsVolumeMetadata) error
+	UpdateVolumeMetadata(ctx context.Context, fcdID string, metadata vclib.CnsVolumeMetadata) error
+	UnregisterVolume(ctx context.Context, fcdID string) error
+}
+
+var (
+	_ connectionManager = &cm.ConnectionManager{}
+	_ datacenterOps     = &vclib.Datacenter{}
+	_ vmOps             = &vclib.VirtualMachine{}
+	_ fileShareOps      = &vclib.VsanFileServiceClient{}
+	_ cnsOps            = &vclib.CnsClient{}
+)
+
+// vsphereHooks wrap the vSphere objects used by the volume handlers, so
+// that the tests can fake them to inject faults. The zero value uses the
+// objects as is.
+type vsphereHooks struct {
+	connMgr     func(connMgr connectionManager) connectionManager
+	datacenter  func(dc *vclib.Datacenter) datacenterOps
+	vm          func(vm *vclib.VirtualMachine) vmOps
+	fileService func(fs *vclib.VsanFileServiceClient) fileShareOps
+	cns         func(cns *vclib.CnsClient) cnsOps
+}
+
+// vsphere returns the connection manager of the request, see connManager.
+func (c *controller) vsphere(ctx context.Context) connectionManager {
+	connMgr := c.connManager(ctx)
+	if c.hooks.connMgr != nil {
+		return c.hooks.connMgr(connMgr)
+	}
+	return connMgr
+}
+
+// datacenterOps returns the operations of a datacenter.
+func (c *controller) datacenterOps(dc *vclib.Datacenter) datacenterOps {
+	if c.hooks.datacenter != nil {
+		return c.hooks.datacenter(dc)
+	}
+	return dc
+}
+
+// vmOps returns the operations of a VM.
+func (c *controller) vmOps(vm *vclib.VirtualMachine) vmOps {
+	if c.hooks.vm != nil {
+		return c.hooks.vm(vm)
+	}
+	return vm
+}
+
+// fileShareOps returns the operations of the vSAN file service of a
+// vCenter, connecting to the vCenter when needed.
+func (c *controller) fileShareOps(ctx context.Context, vcServer string) (fileShareOps, error) {
+	connMgr := c.connManager(ctx)
+	if err := connMgr.Connect(ctx, vcServer); err != nil {
+		return nil, err
+	}
+	fs := vclib.NewVsanFileServiceClient(connMgr.VsphereInstances()[vcServer].Conn.Client)
+	if c.hooks.fileService != nil {
+		return c.hooks.fileService(fs), nil
+	}
+	return fs, nil
+}
+
+// cnsOps returns the operations of the CNS of a vCenter and the user the
+// controller connects with, connecting to the vCenter when needed. nil is
+// returned for the vCenters without the CNS capability, older than 6.7U3.
+func (c *controller) cnsOps(ctx context.Context, vcServer string) (cnsOps, string, error) {
+	connMgr := c.connManager(ctx)
+	if err := connMgr.Connect(ctx, vcServer); err != nil {
+		return nil, "", err
+	}
+	if !connMgr.HasCapability(ctx, vcServer, vclib.CapabilityCns) {
+		return nil, "", nil
+	}
+	conn := connMgr.VsphereInstances()[vcServer].Conn
+	cns := vclib.NewCnsClient(conn.Client)
+	if c.hooks.cns != nil {
+		return c.hooks.cns(cns), conn.User(), nil
+	}
+	return cns, conn.User(), nil
+}
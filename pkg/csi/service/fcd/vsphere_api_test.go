@@ -0,0 +1,573 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+var errFake = errors.New("fake vSphere failure")
+
+// fakeConnMgr fails the lookups of the connection manager it wraps.
+type fakeConnMgr struct {
+	connectionManager
+	zoneErr error
+	fcdErr  error
+}
+
+func (f *fakeConnMgr) WhichVCandDCByZone(ctx context.Context,
+	zoneLabel string, regionLabel string, zoneLooking string, regionLooking string) (*cm.ZoneDiscoveryInfo, error) {
+	if f.zoneErr != nil {
+		return nil, f.zoneErr
+	}
+	return f.connectionManager.WhichVCandDCByZone(ctx, zoneLabel, regionLabel, zoneLooking, regionLooking)
+}
+
+func (f *fakeConnMgr) WhichVCandDCByFCDId(ctx context.Context, fcdID string) (*cm.FcdDiscoveryInfo, error) {
+	if f.fcdErr != nil {
+		return nil, f.fcdErr
+	}
+	return f.connectionManager.WhichVCandDCByFCDId(ctx, fcdID)
+}
+
+// fakeDatacenter counts the creates of the datacenter it wraps, and fails
+// its operations. The deletes fail with deleteErr while deleteFailures is
+// positive, or always when it is nil. The creates are carried out but fail
+// with acceptErr while acceptFailures is positive, as when the connection
+// to vCenter is lost after it accepted them.
+type fakeDatacenter struct {
+	datacenterOps
+	creates        *int
+	createErr      error
+	acceptErr      error
+	acceptFailures *int
+	getErr         error
+	deleteErr      error
+	deleteFailures *int
+	vmErr          error
+}
+
+func (f *fakeDatacenter) CreateFirstClassDiskWithOptions(ctx context.Context,
+	datastoreName string, datastoreType vclib.ParentDatastoreType,
+	diskName string, diskSize int64, volumeOptions *vclib.VolumeOptions) error {
+	if f.creates != nil {
+		*f.creates++
+	}
+	if f.createErr != nil {
+		return f.createErr
+	}
+	err := f.datacenterOps.CreateFirstClassDiskWithOptions(ctx, datastoreName, datastoreType, diskName, diskSize, volumeOptions)
+	if err == nil && f.acceptErr != nil && *f.acceptFailures > 0 {
+		*f.acceptFailures--
+		return f.acceptErr
+	}
+	return err
+}
+
+func (f *fakeDatacenter) GetFirstClassDisk(ctx context.Context,
+	datastoreName string, datastoreType vclib.ParentDatastoreType,
+	diskID string, findBy vclib.FindFCD) (*vclib.FirstClassDiskInfo, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	return f.datacenterOps.GetFirstClassDisk(ctx, datastoreName, datastoreType, diskID, findBy)
+}
+
+func (f *fakeDatacenter) DeleteFirstClassDisk(ctx context.Context,
+	datastoreName string, datastoreType vclib.ParentDatastoreType, diskID string) error {
+	if f.deleteErr != nil && (f.deleteFailures == nil || *f.deleteFailures > 0) {
+		if f.deleteFailures != nil {
+			*f.deleteFailures--
+		}
+		return f.deleteErr
+	}
+	return f.datacenterOps.DeleteFirstClassDisk(ctx, datastoreName, datastoreType, diskID)
+}
+
+func (f *fakeDatacenter) GetVMByDNSName(ctx context.Context, dnsName string) (*vclib.VirtualMachine, error) {
+	if f.vmErr != nil {
+		return nil, f.vmErr
+	}
+	return f.datacenterOps.GetVMByDNSName(ctx, dnsName)
+}
+
+// fakeVM fails the attachments and detachments of the VM it wraps.
+type fakeVM struct {
+	vmOps
+	attachErr error
+	detachErr error
+}
+
+func (f *fakeVM) AttachDisks(ctx context.Context, disks []vclib.DiskAttachment) ([]string, []error) {
+	if f.attachErr != nil {
+		errs := make([]error, len(disks))
+		for i := range errs {
+			errs[i] = f.attachErr
+		}
+		return make([]string, len(disks)), errs
+	}
+	return f.vmOps.AttachDisks(ctx, disks)
+}
+
+func (f *fakeVM) DetachDisk(ctx context.Context, vmDiskPath string) error {
+	if f.detachErr != nil {
+		return f.detachErr
+	}
+	return f.vmOps.DetachDisk(ctx, vmDiskPath)
+}
+
+// fakeHooks returns the hooks that wrap the vSphere objects in the fakes.
+func fakeHooks(connMgr fakeConnMgr, dc fakeDatacenter, vm fakeVM) vsphereHooks {
+	return vsphereHooks{
+		connMgr: func(real connectionManager) connectionManager {
+			f := connMgr
+			f.connectionManager = real
+			return &f
+		},
+		datacenter: func(real *vclib.Datacenter) datacenterOps {
+			f := dc
+			f.datacenterOps = real
+			return &f
+		},
+		vm: func(real *vclib.VirtualMachine) vmOps {
+			f := vm
+			f.vmOps = real
+			return &f
+		},
+	}
+}
+
+// offlineConnMgr is a connection manager without a vCenter, whose lookups
+// fail with err, so that the handlers can be faulted without a simulator.
+type offlineConnMgr struct {
+	connectionManager
+	instances map[string]*cm.VSphereInstance
+	err       error
+}
+
+func (f *offlineConnMgr) VsphereInstances() map[string]*cm.VSphereInstance {
+	return f.instances
+}
+
+func (f *offlineConnMgr) VCHealth(vcenter string) error {
+	return f.err
+}
+
+func (f *offlineConnMgr) WhichVCandDCByZone(ctx context.Context,
+	zoneLabel string, regionLabel string, zoneLooking string, regionLooking string) (*cm.ZoneDiscoveryInfo, error) {
+	return nil, f.err
+}
+
+func (f *offlineConnMgr) WhichVCandDCByFCDId(ctx context.Context, fcdID string) (*cm.FcdDiscoveryInfo, error) {
+	return nil, f.err
+}
+
+func (f *offlineConnMgr) WhichVCandDCByNodeID(ctx context.Context,
+	nodeID string, searchBy cm.FindVM) (*cm.VMDiscoveryInfo, error) {
+	return nil, f.err
+}
+
+func (f *offlineConnMgr) PinFirstClassDiskDatacenter(fcdID string, vcServer string, datacenter string) {
+}
+
+func (f *offlineConnMgr) UnindexFirstClassDisk(fcdID string) {}
+
+func TestVSphereFaults(t *testing.T) {
+	c, connMgr, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	myVM := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vmName := myVM.Name
+	myVM.Guest.HostName = strings.ToLower(vmName)
+
+	params := map[string]string{
+		AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+		AttributeFirstClassDiskParentName: myds.Name,
+	}
+	createVolume := func(name string) (*csi.CreateVolumeResponse, error) {
+		return c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+			Parameters:    params,
+		})
+	}
+
+	// the volume published and unpublished by the tests
+	c.hooks = vsphereHooks{}
+	respCreate, err := createVolume("published")
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := respCreate.Volume.VolumeId
+
+	tests := []struct {
+		name    string
+		connMgr fakeConnMgr
+		dc      fakeDatacenter
+		vm      fakeVM
+		call    func() error
+		code    codes.Code
+	}{
+		{
+			name:    "CreateVolume without a datacenter",
+			connMgr: fakeConnMgr{zoneErr: errFake},
+			call: func() error {
+				_, err := createVolume("nodc")
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "CreateVolume failing to create",
+			dc:   fakeDatacenter{createErr: errFake},
+			call: func() error {
+				_, err := createVolume("nocreate")
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "CreateVolume outliving its request",
+			dc:   fakeDatacenter{createErr: &vclib.TaskInProgressError{Err: context.DeadlineExceeded}},
+			call: func() error {
+				_, err := createVolume("inprogress")
+				return err
+			},
+			code: codes.DeadlineExceeded,
+		},
+		{
+			name: "CreateVolume failing to get the volume",
+			dc:   fakeDatacenter{getErr: errFake},
+			call: func() error {
+				_, err := createVolume("noget")
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name:    "DeleteVolume of a missing volume",
+			connMgr: fakeConnMgr{fcdErr: vclib.ErrNoDiskIDFound},
+			call: func() error {
+				_, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name:    "DeleteVolume failing to locate the volume",
+			connMgr: fakeConnMgr{fcdErr: errFake},
+			call: func() error {
+				_, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "DeleteVolume failing to delete",
+			dc:   fakeDatacenter{deleteErr: errFake},
+			call: func() error {
+				_, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name:    "ControllerPublishVolume of a missing volume",
+			connMgr: fakeConnMgr{fcdErr: vclib.ErrNoDiskIDFound},
+			call: func() error {
+				_, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   vmName,
+				})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "ControllerPublishVolume failing to find the node",
+			dc:   fakeDatacenter{vmErr: errFake},
+			call: func() error {
+				_, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   vmName,
+				})
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "ControllerPublishVolume failing to attach",
+			vm:   fakeVM{attachErr: errFake},
+			call: func() error {
+				_, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   vmName,
+				})
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "ControllerPublishVolume",
+			call: func() error {
+				_, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   vmName,
+				})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "ControllerUnpublishVolume failing to detach",
+			vm:   fakeVM{detachErr: errFake},
+			call: func() error {
+				_, err := c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   vmName,
+				})
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name:    "ControllerUnpublishVolume of a missing volume",
+			connMgr: fakeConnMgr{fcdErr: vclib.ErrNoDiskIDFound},
+			call: func() error {
+				_, err := c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   vmName,
+				})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "ControllerUnpublishVolume",
+			call: func() error {
+				_, err := c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   vmName,
+				})
+				return err
+			},
+			code: codes.OK,
+		},
+	}
+
+	for _, test := range tests {
+		c.hooks = fakeHooks(test.connMgr, test.dc, test.vm)
+		// the cached VM of the node would skip the lookups
+		c.nodeVMs.remove(vmName)
+		if err := test.call(); status.Code(err) != test.code {
+			t.Errorf("%s should have returned %s: %v", test.name, test.code, err)
+		}
+	}
+}
+
+func TestOfflineFaults(t *testing.T) {
+	ctx := context.Background()
+	volumeID := "7e3a9cf5-9b25-4bd6-9a36-0e1cbd8c2ff1"
+
+	tests := []struct {
+		name string
+		err  error
+		call func(c *controller) error
+		code codes.Code
+	}{
+		{
+			name: "DeleteVolume of a deleted volume",
+			err:  vclib.ErrNoDiskIDFound,
+			call: func(c *controller) error {
+				_, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "DeleteVolume failing to find the volume",
+			err:  io.ErrUnexpectedEOF,
+			call: func(c *controller) error {
+				_, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+				return err
+			},
+			code: codes.Unavailable,
+		},
+		{
+			name: "ControllerPublishVolume of a deleted volume",
+			err:  vclib.ErrNoDiskIDFound,
+			call: func(c *controller) error {
+				_, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   "node",
+				})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "ControllerPublishVolume failing to find the volume",
+			err:  errFake,
+			call: func(c *controller) error {
+				_, err := c.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   "node",
+				})
+				return err
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "ControllerUnpublishVolume of a deleted volume",
+			err:  vclib.ErrNoDiskIDFound,
+			call: func(c *controller) error {
+				_, err := c.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   "node",
+				})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "ValidateVolumeCapabilities of a deleted volume",
+			err:  vclib.ErrNoDiskIDFound,
+			call: func(c *controller) error {
+				_, err := c.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+					VolumeId:           volumeID,
+					VolumeCapabilities: []*csi.VolumeCapability{{}},
+				})
+				return err
+			},
+			code: codes.NotFound,
+		},
+	}
+
+	for _, test := range tests {
+		c := &controller{
+			cfg:     &vcfg.Config{},
+			connMgr: &offlineConnMgr{err: test.err},
+		}
+		if code := status.Code(test.call(c)); code != test.code {
+			t.Errorf("%s should have failed with %v: %v", test.name, test.code, code)
+		}
+	}
+
+	// a vCenter whose session is lost is not ready
+	c := &controller{
+		cfg: &vcfg.Config{},
+		connMgr: &offlineConnMgr{
+			instances: map[string]*cm.VSphereInstance{"vc": nil},
+			err:       vclib.ErrNotConnected,
+		},
+	}
+	if err := c.Probe(ctx); err != vclib.ErrNotConnected {
+		t.Errorf("Probe should fail with %v: %v", vclib.ErrNotConnected, err)
+	}
+}
+
+func TestCreateVolumeIdempotency(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	var creates int
+	c.hooks = fakeHooks(fakeConnMgr{}, fakeDatacenter{creates: &creates}, fakeVM{})
+
+	req := &csi.CreateVolumeRequest{
+		Name:          "idempotent",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+		Parameters: map[string]string{
+			AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+			AttributeFirstClassDiskParentName: myds.Name,
+		},
+	}
+
+	var volumeID string
+	for i := 0; i < 3; i++ {
+		resp, err := c.CreateVolume(ctx, req)
+		if err != nil {
+			t.Fatalf("CreateVolume %d failed: %v", i, err)
+		}
+		if volumeID == "" {
+			volumeID = resp.Volume.VolumeId
+		} else if resp.Volume.VolumeId != volumeID {
+			t.Errorf("CreateVolume %d returned volume %s instead of %s", i, resp.Volume.VolumeId, volumeID)
+		}
+	}
+	if creates != 1 {
+		t.Errorf("the volume should have been created once, not %d times", creates)
+	}
+
+	// a create that outlives its request is waited for by the retry
+	task := simulator.CreateTask(myds, "createDisk", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, nil
+	})
+	task.Run()
+	req.Name = "outlived"
+	c.hooks = vsphereHooks{
+		datacenter: func(dc *vclib.Datacenter) datacenterOps {
+			return &outlivedDatacenter{datacenterOps: dc, task: task.Reference(), creates: &creates}
+		},
+	}
+
+	creates = 0
+	if _, err := c.CreateVolume(ctx, req); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("CreateVolume should have been in progress: %v", err)
+	}
+	if _, err := c.CreateVolume(ctx, req); err != nil {
+		t.Fatalf("CreateVolume retry failed: %v", err)
+	}
+	if creates != 1 {
+		t.Errorf("the retry should have waited for the create, it created %d volumes", creates)
+	}
+}
+
+// outlivedDatacenter creates the FCDs but reports their task as still in
+// progress, as when a create outlives its request.
+type outlivedDatacenter struct {
+	datacenterOps
+	task    types.ManagedObjectReference
+	creates *int
+}
+
+func (d *outlivedDatacenter) CreateFirstClassDiskWithOptions(ctx context.Context,
+	datastoreName string, datastoreType vclib.ParentDatastoreType,
+	diskName string, diskSize int64, volumeOptions *vclib.VolumeOptions) error {
+	*d.creates++
+	if err := d.datacenterOps.CreateFirstClassDiskWithOptions(ctx, datastoreName, datastoreType, diskName, diskSize, volumeOptions); err != nil {
+		return err
+	}
+	return &vclib.TaskInProgressError{Task: d.task, Err: context.DeadlineExceeded}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+// transientAttempts is the number of times the vSphere operations of the
+// volume handlers are attempted while they fail with a transient fault,
+// such as a TaskInProgress fault while the VM of a node is reconfigured,
+// before the request fails with Unavailable.
+var transientAttempts = 3
+
+// retryTransient calls fn until it succeeds, fails with a fault that is not
+// transient, or is attempted transientAttempts times, with a jittered
+// exponential backoff between the calls.
+func retryTransient(ctx context.Context, fn func() error) error {
+	return vclib.WithRetry(ctx, transientAttempts, fn)
+}
+
+// retryCreate calls create, which is not idempotent, like retryTransient.
+// It is called again right away only after a fault vSphere raised instead
+// of carrying it out, such as TaskInProgress. After the other transient
+// failures, such as a connection reset, vSphere may have carried it out, so
+// it is only called again once exists reports that nothing was created.
+func retryCreate(ctx context.Context, exists func() (bool, error), create func() error) error {
+	var accepted bool
+	return vclib.WithRetry(ctx, transientAttempts, func() error {
+		if accepted {
+			found, err := exists()
+			if err != nil {
+				return err
+			}
+			if found {
+				return nil
+			}
+		}
+
+		err := create()
+		accepted = vclib.IsTransient(err) && !vclib.IsTransientFault(err)
+		return err
+	})
+}
+
+// errorCode returns the gRPC code of a failed vSphere operation: Unavailable
+// for the transient faults the CO should retry later, PermissionDenied when
+// the vCenter user lacks a privilege, and Internal otherwise.
+func errorCode(err error) codes.Code {
+	switch {
+	case vclib.IsTransient(err):
+		return codes.Unavailable
+	case vclib.IsPermission(err):
+		return codes.PermissionDenied
+	}
+	return codes.Internal
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fcd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"TaskInProgress", soap.WrapVimFault(&types.TaskInProgress{}), codes.Unavailable},
+		{"NoPermission", soap.WrapVimFault(&types.NoPermission{}), codes.PermissionDenied},
+		{"NotAuthenticated", soap.WrapVimFault(&types.NotAuthenticated{}), codes.PermissionDenied},
+		{"InvalidArgument", soap.WrapVimFault(&types.InvalidArgument{}), codes.Internal},
+		{"other", errFake, codes.Internal},
+	}
+	for _, test := range tests {
+		if code := errorCode(test.err); code != test.code {
+			t.Errorf("[%s] errorCode returned %s, expected %s", test.name, code, test.code)
+		}
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	createVolume := func(name string) string {
+		c.hooks = vsphereHooks{}
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+			Parameters: map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskParentName: myds.Name,
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume failed: %v", err)
+		}
+		return resp.Volume.VolumeId
+	}
+
+	defer func(attempts int) { transientAttempts = attempts }(transientAttempts)
+	transientAttempts = 2
+
+	tests := []struct {
+		name     string
+		err      error
+		failures int
+		code     codes.Code
+	}{
+		{"a transient fault", soap.WrapVimFault(&types.TaskInProgress{}), 1, codes.OK},
+		{"a persistent transient fault", soap.WrapVimFault(&types.TaskInProgress{}), 2, codes.Unavailable},
+		{"a missing privilege", soap.WrapVimFault(&types.NoPermission{}), 1, codes.PermissionDenied},
+		{"a deleted volume", &find.NotFoundError{}, 1, codes.OK},
+	}
+	for _, test := range tests {
+		volumeID := createVolume("retry")
+
+		failures := test.failures
+		c.hooks = fakeHooks(fakeConnMgr{}, fakeDatacenter{deleteErr: test.err, deleteFailures: &failures}, fakeVM{})
+		_, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+		if status.Code(err) != test.code {
+			t.Errorf("DeleteVolume failing with %s should have returned %s: %v", test.name, test.code, err)
+		}
+		if failures != 0 {
+			t.Errorf("DeleteVolume failing with %s was attempted %d times too few", test.name, failures)
+		}
+
+		// clean up the volumes the tests did not delete
+		c.hooks = vsphereHooks{}
+		if _, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			t.Errorf("DeleteVolume failed: %v", err)
+		}
+	}
+}
+
+func TestRetryCreate(t *testing.T) {
+	c, _, myds, cleanup := controllerFromEnvOrSim(t, false)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	defer func(attempts int) { transientAttempts = attempts }(transientAttempts)
+	transientAttempts = 2
+
+	reset := &url.Error{Op: "Post", URL: "https://vc/sdk", Err: syscall.ECONNRESET}
+	tests := []struct {
+		name    string
+		dc      fakeDatacenter
+		creates int
+		code    codes.Code
+	}{
+		// vCenter carried out the create, which is found rather than
+		// attempted again
+		{"a connection reset", fakeDatacenter{acceptErr: reset}, 1, codes.OK},
+		// vCenter did not carry out the create, which is attempted again
+		{"a transient fault", fakeDatacenter{createErr: soap.WrapVimFault(&types.TaskInProgress{})}, 2, codes.Unavailable},
+	}
+	for i, test := range tests {
+		var creates int
+		failures := 1
+		dc := test.dc
+		dc.creates = &creates
+		dc.acceptFailures = &failures
+		c.hooks = fakeHooks(fakeConnMgr{}, dc, fakeVM{})
+
+		resp, err := c.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:          fmt.Sprintf("retry-create-%d", i),
+			CapacityRange: &csi.CapacityRange{RequiredBytes: GbInBytes},
+			Parameters: map[string]string{
+				AttributeFirstClassDiskParentType: string(vclib.TypeDatastore),
+				AttributeFirstClassDiskParentName: myds.Name,
+			},
+		})
+		if status.Code(err) != test.code {
+			t.Errorf("CreateVolume failing with %s should have returned %s: %v", test.name, test.code, err)
+		}
+		if creates != test.creates {
+			t.Errorf("CreateVolume failing with %s was attempted %d times, expected %d", test.name, creates, test.creates)
+		}
+
+		if err == nil {
+			c.hooks = vsphereHooks{}
+			if _, err := c.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+				t.Errorf("DeleteVolume failed: %v", err)
+			}
+		}
+	}
+}
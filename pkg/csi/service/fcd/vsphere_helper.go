@@ -17,17 +17,26 @@ limitations under the License.
 package fcd
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"github.com/vmware/govmomi/vim25/types"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
 	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
 	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
 )
 
 const (
@@ -39,35 +48,29 @@ const (
 	// each connection attempt.
 	RetryAttemptDelaySecs int = 1
 
-	// MinSupportedVCenterMajor is the minimum, major version of vCenter
-	// on which FCD is supported.
-	MinSupportedVCenterMajor int = 6
+	// MaxFirstClassDiskNameLength is the maximum length of an FCD name.
+	MaxFirstClassDiskNameLength int = 80
+
+	// nameHashLength is the number of hex digits of the hash appended to
+	// a sanitized FCD name.
+	nameHashLength int = 8
 
-	// MinSupportedVCenterMinor is the minimum, minor version of vCenter
-	// on which FCD is supported.
-	MinSupportedVCenterMinor int = 5
+	// taskProgressTimeout bounds the retrieval of the progress of a task
+	// that outlived its request.
+	taskProgressTimeout = 5 * time.Second
 )
 
-func checkAPI(version string) error {
-	items := strings.Split(version, ".")
-	if len(items) <= 1 {
-		return fmt.Errorf("Invalid API Version format")
-	}
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
 
-	major, err := strconv.Atoi(items[0])
-	if err != nil {
-		return fmt.Errorf("Invalid Major Version value invalid")
-	}
-	minor, err := strconv.Atoi(items[1])
-	if err != nil {
-		return fmt.Errorf("Invalid Minor Version value invalid")
-	}
+// invalidNameRegexp matches the characters the datastore file layer
+// rejects in the name of an FCD.
+var invalidNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
 
-	if major < MinSupportedVCenterMajor {
-		return fmt.Errorf("The minimum supported vCenter is 6.5")
-	}
-	if major == MinSupportedVCenterMajor && minor < MinSupportedVCenterMinor {
-		return fmt.Errorf("The minimum supported vCenter is 6.5")
+// checkAPI fails when a vCenter API version does not support FCDs.
+func checkAPI(version string) error {
+	if !vclib.CapabilitiesOf(version).Has(vclib.CapabilityFCD) {
+		return fmt.Errorf("vCenter API version %q does not support FCDs, which require %s",
+			version, vclib.CapabilityFCD.Requirement())
 	}
 	return nil
 }
@@ -81,46 +84,579 @@ func removePortFromHost(host string) string {
 	return result
 }
 
-// getAllFCDs returns all FCDs in all VC/DC sorted by UUID
-func getAllFCDs(ctx context.Context, cm *cm.ConnectionManager) []*vclib.FirstClassDiskInfo {
+// fcdScanOptions restrict a scan of the FCDs to the datastores, and
+// datastore clusters, that allowed returns true for, all of them when
+// allowed is nil, and bound the number of datastores listed at a time.
+type fcdScanOptions struct {
+	allowed func(name string) bool
+	workers int
+}
+
+// defaultFCDScanOptions scan all of the datastores.
+var defaultFCDScanOptions = fcdScanOptions{workers: int(vcfg.DefaultListVolumesConcurrency)}
+
+// fcdScanSummary counts the datastores, and datastore clusters, whose FCDs
+// a scan listed, skipped and failed to list.
+type fcdScanSummary struct {
+	scanned int
+	skipped int
+	failed  int
+}
+
+// scanDatacenter returns the FCDs of a datacenter, logging the datastores
+// whose FCDs could not be listed.
+func (s *fcdScanSummary) scanDatacenter(ctx context.Context, vc string,
+	dc *vclib.Datacenter, opts fcdScanOptions) []*vclib.FirstClassDiskInfo {
+
+	logger := logging.Logger(ctx)
+
+	scan, err := dc.ScanFirstClassDisks(ctx, opts.allowed, opts.workers)
+	if err != nil {
+		logger.Errorf("ScanFirstClassDisks failed vc=%s dc=%s err=%v", vc, dc.Name(), err)
+		return nil
+	}
+	for name, err := range scan.Failed {
+		logger.Errorf("Failed to list the FCDs of datastore %s vc=%s dc=%s err=%v", name, vc, dc.Name(), err)
+	}
+
+	s.scanned += scan.Scanned
+	s.skipped += scan.Skipped
+	s.failed += len(scan.Failed)
+	return scan.FirstClassDisks
+}
+
+// log logs the summary of the scan.
+func (s *fcdScanSummary) log(ctx context.Context) {
+	logging.Logger(ctx).Infof("Listed the FCDs of %d datastores, skipped %d and failed to list %d",
+		s.scanned, s.skipped, s.failed)
+}
+
+// getAllFCDs returns all FCDs in all VC/DC sorted by UUID. The datastores
+// that fail are left out rather than failing the listing.
+func getAllFCDs(ctx context.Context, connMgr connectionManager, opts fcdScanOptions) []*vclib.FirstClassDiskInfo {
 
 	firstClassDisks := make([]*vclib.FirstClassDiskInfo, 0)
+	var summary fcdScanSummary
 
-	for vc, vsi := range cm.VsphereInstanceMap {
+	for vc, vsi := range connMgr.VsphereInstances() {
 
 		var err error
 		for i := 0; i < NumConnectionAttempts; i++ {
-			err = cm.ConnectByInstance(ctx, vsi)
+			err = connMgr.ConnectByInstance(ctx, vsi)
 			if err == nil {
 				break
 			}
 			time.Sleep(time.Duration(RetryAttemptDelaySecs) * time.Second)
 		}
 		if err != nil {
-			log.Errorf("Failed to connection to vCenter: %s with err: %v", vc, err)
+			logging.Logger(ctx).Errorf("Failed to connection to vCenter: %s with err: %v", vc, err)
 			continue
 		}
 
 		datacenters, err := vclib.GetAllDatacenter(ctx, vsi.Conn)
 		if err != nil {
-			log.Errorf("GetAllDatacenter failed vc=%s err=%v", vc, err)
+			logging.Logger(ctx).Errorf("GetAllDatacenter failed vc=%s err=%v", vc, err)
 			continue
 		}
 
+		if fcds, ok := catalogFCDs(ctx, connMgr, vc, datacenters, opts); ok {
+			firstClassDisks = append(firstClassDisks, fcds...)
+			continue
+		}
 		for _, datacenter := range datacenters {
-			firstClassDisksSubset, err := datacenter.GetAllFirstClassDisks(ctx)
-			if err != nil {
-				log.Errorf("GetAllFirstClassDisks failed vc=%s err=%v", vc, err)
-				continue
-			}
+			firstClassDisks = append(firstClassDisks, summary.scanDatacenter(ctx, vc, datacenter, opts)...)
+		}
+	}
+
+	summary.log(ctx)
+	sortFCDs(firstClassDisks)
+	return firstClassDisks
+}
+
+// getZoneFCDs returns the FCDs in the VC/DC of a zone sorted by UUID
+func getZoneFCDs(ctx context.Context, connMgr connectionManager, discoveryInfo *cm.ZoneDiscoveryInfo,
+	opts fcdScanOptions) []*vclib.FirstClassDiskInfo {
+
+	datacenters := []*vclib.Datacenter{discoveryInfo.DataCenter}
+	if fcds, ok := catalogFCDs(ctx, connMgr, discoveryInfo.VcServer, datacenters, opts); ok {
+		sortFCDs(fcds)
+		return fcds
+	}
+
+	var summary fcdScanSummary
+	firstClassDisks := make([]*vclib.FirstClassDiskInfo, 0)
+	firstClassDisks = append(firstClassDisks,
+		summary.scanDatacenter(ctx, discoveryInfo.VcServer, discoveryInfo.DataCenter, opts)...)
+
+	summary.log(ctx)
+	sortFCDs(firstClassDisks)
+	return firstClassDisks
+}
+
+// catalogFCDs returns the FCDs of the datacenters of a vCenter found in its
+// vslm catalog. ok is false when the vCenter has no catalog, or when it
+// fails to be queried, in which case the datastores are scanned instead.
+func catalogFCDs(ctx context.Context, connMgr connectionManager, vc string,
+	datacenters []*vclib.Datacenter, opts fcdScanOptions) ([]*vclib.FirstClassDiskInfo, bool) {
+
+	logger := logging.Logger(ctx)
+
+	fcds, ok, err := connMgr.CatalogFirstClassDisks(ctx, vc, datacenters, opts.allowed)
+	if err != nil {
+		logger.Warningf("Failed to list the FCDs in the vslm catalog of vc=%s, scanning its datastores. Err: %v", vc, err)
+		return nil, false
+	}
+	if ok {
+		logger.Infof("Listed %d FCDs in the vslm catalog of vc=%s", len(fcds), vc)
+	}
+	return fcds, ok
+}
 
-			firstClassDisks = append(firstClassDisks, firstClassDisksSubset...)
+// getMostFreeSpace returns the free space of the shared datastore, or
+// datastore cluster, with the most free space in a datacenter. When hosts is
+// not empty, only the ones mounted by the hosts are considered, and when
+// allowed is not nil, only the ones it allows. It is zero when the
+// datacenter has none.
+func getMostFreeSpace(ctx context.Context, dc *vclib.Datacenter, datastoreType vclib.ParentDatastoreType,
+	hosts []types.ManagedObjectReference, allowed func(name string) bool) (int64, error) {
+	if datastoreType == vclib.TypeDatastoreCluster {
+		storagePod, err := dc.GetDatastoreClusterWithMostFreeSpace(ctx, 0, hosts, allowed)
+		if err == vclib.ErrNoDataStoreClustersFound {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
 		}
+		return storagePod.Summary.FreeSpace, nil
+	}
+
+	datastore, err := dc.GetSharedDatastoreWithMostFreeSpace(ctx, 0, hosts, allowed)
+	if err == vclib.ErrNoDatastoreFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
 	}
+	return datastore.Info.FreeSpace, nil
+}
 
+// findFirstClassDiskByName returns the FCD with the name in a datacenter of
+// a vCenter, or nil when there is none. The FCD is found in the vslm
+// catalog of the vCenter when it has one.
+func findFirstClassDiskByName(ctx context.Context, connMgr connectionManager, vc string,
+	dc *vclib.Datacenter, name string) (*vclib.FirstClassDiskInfo, error) {
+
+	fcds, ok, err := connMgr.CatalogFirstClassDisks(ctx, vc, []*vclib.Datacenter{dc}, nil, vclib.VslmQueryByName(name))
+	if err != nil {
+		logging.Logger(ctx).Warningf("Failed to find FCD %s in the vslm catalog of vc=%s, scanning its datastores. Err: %v",
+			name, vc, err)
+	} else if ok {
+		if len(fcds) == 0 {
+			return nil, nil
+		}
+		return fcds[0], nil
+	}
+
+	firstClassDisks, err := dc.GetAllFirstClassDisks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, firstClassDisk := range firstClassDisks {
+		if firstClassDisk.Config.Name == name {
+			return firstClassDisk, nil
+		}
+	}
+	return nil, nil
+}
+
+// sortFCDs sorts FCDs by UUID so that the pages of a listing are stable
+func sortFCDs(firstClassDisks []*vclib.FirstClassDiskInfo) {
 	sort.Slice(firstClassDisks, func(i, j int) bool {
 		return firstClassDisks[i].Config.Id.Id > firstClassDisks[j].Config.Id.Id
 	})
+}
 
-	return firstClassDisks
+// getPage returns the [start, stop) window of a paginated list request.
+// The starting token is the index of the first entry of the page.
+func getPage(startingToken string, maxEntries int32, total int) (int, int, error) {
+	start := 0
+	if len(startingToken) > 0 {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil {
+			return 0, 0, ErrListInvalidNextToken
+		}
+	}
+	if start < 0 || start > total {
+		return 0, 0, ErrListInvalidNextToken
+	}
+
+	stop := total
+	if maxEntries > 0 && start+int(maxEntries) < total {
+		stop = start + int(maxEntries)
+	}
+
+	return start, stop, nil
+}
+
+// toCSITopology returns the topology of a zone and region, under the labels
+// of a family of topology labels. Nil is returned when neither the zone nor
+// the region is known.
+func toCSITopology(labels string, zone string, region string) *csi.Topology {
+	segments := TopologySegments(labels, zone, region)
+	if len(segments) == 0 {
+		return nil
+	}
+
+	return &csi.Topology{
+		Segments: segments,
+	}
+}
+
+// TopologySegments returns the topology segments of a zone and region,
+// under the labels of a family of topology labels, beta, ga or both. The
+// segments are empty when neither the zone nor the region is known.
+func TopologySegments(labels string, zone string, region string) map[string]string {
+	zoneKeys, regionKeys := topologyLabelKeys(labels)
+	segments := make(map[string]string)
+	if len(zone) > 0 {
+		for _, key := range zoneKeys {
+			segments[key] = zone
+		}
+	}
+	if len(region) > 0 {
+		for _, key := range regionKeys {
+			segments[key] = region
+		}
+	}
+	return segments
+}
+
+// topologyZoneRegion returns the zone and region of topology segments, or of
+// node labels, read from the labels of either family, as the topologies
+// requested while the nodes are relabeled may use either of them. The labels
+// of the preferred family win when both are set.
+func topologyZoneRegion(labels string, segments map[string]string) (string, string) {
+	zoneKeys := []string{LabelZoneFailureDomain, LabelTopologyZone}
+	regionKeys := []string{LabelZoneRegion, LabelTopologyRegion}
+	if labels == vcfg.TopologyLabelsGA {
+		zoneKeys = []string{LabelTopologyZone, LabelZoneFailureDomain}
+		regionKeys = []string{LabelTopologyRegion, LabelZoneRegion}
+	}
+
+	var zone, region string
+	for _, key := range zoneKeys {
+		if zone = segments[key]; len(zone) > 0 {
+			break
+		}
+	}
+	for _, key := range regionKeys {
+		if region = segments[key]; len(region) > 0 {
+			break
+		}
+	}
+	return zone, region
+}
+
+// topologyLabelKeys returns the zone and region labels of a family of
+// topology labels. The beta family is the default one.
+func topologyLabelKeys(labels string) ([]string, []string) {
+	switch labels {
+	case vcfg.TopologyLabelsGA:
+		return []string{LabelTopologyZone}, []string{LabelTopologyRegion}
+	case vcfg.TopologyLabelsBoth:
+		return []string{LabelZoneFailureDomain, LabelTopologyZone}, []string{LabelZoneRegion, LabelTopologyRegion}
+	}
+	return []string{LabelZoneFailureDomain}, []string{LabelZoneRegion}
+}
+
+// containsTopology returns true when the topologies include one with the
+// same zone and region as the topology, compared case-insensitively and
+// whatever the family of their labels.
+func containsTopology(labels string, topologies []*csi.Topology, topology *csi.Topology) bool {
+	wantZone, wantRegion := topologyZoneRegion(labels, topology.GetSegments())
+	for _, t := range topologies {
+		zone, region := topologyZoneRegion(labels, t.GetSegments())
+		if strings.EqualFold(zone, wantZone) && strings.EqualFold(region, wantRegion) {
+			return true
+		}
+	}
+	return false
+}
+
+// resizedFCD returns a copy of an FCD with a new capacity, leaving the FCD
+// unchanged for the callers that share it.
+func resizedFCD(fcd *vclib.FirstClassDiskInfo, sizeMB int64) *vclib.FirstClassDiskInfo {
+	object := *fcd.VStorageObject
+	object.Config.CapacityInMB = sizeMB
+	disk := *fcd.FirstClassDisk
+	disk.VStorageObject = &object
+	resized := *fcd
+	resized.FirstClassDisk = &disk
+	return &resized
+}
+
+// sanitizeVolumeName returns the FCD name of a CSI volume name. A name that
+// is too long or has characters the datastores reject has them replaced,
+// is truncated and gets a hash of the whole name appended, so that the same
+// CSI name always gives the same FCD name and different ones do not clash.
+// Other names are returned unchanged.
+func sanitizeVolumeName(name string) string {
+	sanitized := invalidNameRegexp.ReplaceAllString(name, "-")
+	if sanitized == name && len(name) <= MaxFirstClassDiskNameLength {
+		return name
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:nameHashLength]
+	if maxLength := MaxFirstClassDiskNameLength - nameHashLength - 1; len(sanitized) > maxLength {
+		sanitized = sanitized[:maxLength]
+	}
+	return sanitized + "-" + hash
+}
+
+// isUUID returns true when a node ID is a VM BIOS or instance UUID rather
+// than a DNS name.
+func isUUID(nodeID string) bool {
+	return uuidRegexp.MatchString(nodeID)
+}
+
+// getParentDatastore returns the name and type of the datastore or
+// datastore cluster that contains an FCD.
+func getParentDatastore(fcd *vclib.FirstClassDiskInfo) (string, vclib.ParentDatastoreType) {
+	if fcd.ParentType == vclib.TypeDatastoreCluster {
+		return fcd.StoragePodInfo.Summary.Name, vclib.TypeDatastoreCluster
+	}
+	return fcd.DatastoreInfo.Info.Name, vclib.TypeDatastore
+}
+
+// createSnapshotID returns the snapshot ID handed to the CO for the
+// snapshot of an FCD.
+func createSnapshotID(fcdID string, snapshotID string) string {
+	return fcdID + SnapshotIDSeparator + snapshotID
+}
+
+// parseSnapshotID splits a snapshot ID handed to the CO into the FCD ID
+// and the FCD snapshot ID.
+func parseSnapshotID(id string) (string, string, error) {
+	parts := strings.Split(id, SnapshotIDSeparator)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", ErrInvalidSnapshotID
+	}
+	return parts[0], parts[1], nil
+}
+
+// toCSISnapshot converts an FCD snapshot to its CSI representation.
+func toCSISnapshot(fcd *vclib.FirstClassDiskInfo,
+	snapshot *types.VStorageObjectSnapshotInfoVStorageObjectSnapshot) (*csi.Snapshot, error) {
+
+	if snapshot.Id == nil {
+		return nil, vclib.ErrNoSnapshotFound
+	}
+
+	creationTime, err := ptypes.TimestampProto(snapshot.CreateTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.Snapshot{
+		SnapshotId:     createSnapshotID(fcd.Config.Id.Id, snapshot.Id.Id),
+		SourceVolumeId: fcd.Config.Id.Id,
+		SizeBytes:      fcd.Config.CapacityInMB * MbInBytes,
+		CreationTime:   creationTime,
+		ReadyToUse:     true,
+	}, nil
+}
+
+// findSnapshotByName searches every FCD for a snapshot with the provided
+// name. The name of a snapshot is stored as its description.
+func findSnapshotByName(ctx context.Context, connMgr connectionManager,
+	name string) (*vclib.FirstClassDiskInfo, *types.VStorageObjectSnapshotInfoVStorageObjectSnapshot, error) {
+
+	for _, fcd := range getAllFCDs(ctx, connMgr, defaultFCDScanOptions) {
+		datastoreName, datastoreType := getParentDatastore(fcd)
+		snapshots, err := fcd.Datacenter.ListFirstClassDiskSnapshots(
+			ctx, datastoreName, datastoreType, fcd.Config.Id.Id)
+		if err != nil {
+			logging.Logger(ctx).Warningf("ListFirstClassDiskSnapshots(%s) failed. Err: %v", fcd.Config.Id.Id, err)
+			continue
+		}
+		for i := range snapshots {
+			if snapshots[i].Description == name {
+				return fcd, &snapshots[i], nil
+			}
+		}
+	}
+
+	return nil, nil, vclib.ErrNoSnapshotFound
+}
+
+// getCSISnapshots returns the CSI representation of the snapshots of the
+// provided FCDs. FCDs whose snapshots cannot be retrieved are skipped.
+func getCSISnapshots(ctx context.Context, firstClassDisks []*vclib.FirstClassDiskInfo) []*csi.Snapshot {
+	csiSnapshots := make([]*csi.Snapshot, 0)
+
+	for _, fcd := range firstClassDisks {
+		datastoreName, datastoreType := getParentDatastore(fcd)
+		snapshots, err := fcd.Datacenter.ListFirstClassDiskSnapshots(
+			ctx, datastoreName, datastoreType, fcd.Config.Id.Id)
+		if err != nil {
+			logging.Logger(ctx).Errorf("ListFirstClassDiskSnapshots(%s) failed. Err: %v", fcd.Config.Id.Id, err)
+			continue
+		}
+
+		for i := range snapshots {
+			csiSnapshot, err := toCSISnapshot(fcd, &snapshots[i])
+			if err != nil {
+				logging.Logger(ctx).Errorf("toCSISnapshot(%s) failed. Err: %v", fcd.Config.Id.Id, err)
+				continue
+			}
+			csiSnapshots = append(csiSnapshots, csiSnapshot)
+		}
+	}
+
+	return csiSnapshots
+}
+
+// getContentSource resolves the FCD backing a volume content source. The
+// returned snapshot ID is empty when the content source is a volume.
+func getContentSource(ctx context.Context, connMgr connectionManager,
+	source *csi.VolumeContentSource) (*cm.FcdDiscoveryInfo, string, error) {
+
+	var fcdID, snapshotID string
+	if snapshot := source.GetSnapshot(); snapshot != nil {
+		var err error
+		fcdID, snapshotID, err = parseSnapshotID(snapshot.SnapshotId)
+		if err != nil {
+			msg := fmt.Sprintf("Source snapshot %s not found", snapshot.SnapshotId)
+			logging.Logger(ctx).Error(msg)
+			return nil, "", status.Errorf(codes.NotFound, msg)
+		}
+	} else if volume := source.GetVolume(); volume != nil {
+		fcdID = volume.VolumeId
+	} else {
+		msg := "Unsupported volume content source."
+		logging.Logger(ctx).Error(msg)
+		return nil, "", status.Errorf(codes.InvalidArgument, msg)
+	}
+
+	discoveryInfo, err := connMgr.WhichVCandDCByFCDId(ctx, fcdID)
+	if err == vclib.ErrNoDiskIDFound {
+		msg := fmt.Sprintf("Source volume %s not found", fcdID)
+		logging.Logger(ctx).Error(msg)
+		return nil, "", status.Errorf(codes.NotFound, msg)
+	} else if err != nil {
+		msg := fmt.Sprintf("WhichVCandDCByFCDId(%s) failed. Err: %v", fcdID, err)
+		logging.Logger(ctx).Errorf(msg)
+		return nil, "", status.Errorf(errorCode(err), msg)
+	}
+
+	if len(snapshotID) > 0 {
+		datastoreName, datastoreType := getParentDatastore(discoveryInfo.FCDInfo)
+		snapshots, err := discoveryInfo.DataCenter.ListFirstClassDiskSnapshots(
+			ctx, datastoreName, datastoreType, fcdID)
+		if err != nil {
+			msg := fmt.Sprintf("ListFirstClassDiskSnapshots(%s) failed. Err: %v", fcdID, err)
+			logging.Logger(ctx).Errorf(msg)
+			return nil, "", status.Errorf(errorCode(err), msg)
+		}
+
+		found := false
+		for _, snapshot := range snapshots {
+			if snapshot.Id != nil && snapshot.Id.Id == snapshotID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			msg := fmt.Sprintf("Source snapshot %s not found", createSnapshotID(fcdID, snapshotID))
+			logging.Logger(ctx).Error(msg)
+			return nil, "", status.Errorf(codes.NotFound, msg)
+		}
+	}
+
+	return discoveryInfo, snapshotID, nil
+}
+
+// supportedFsTypes are the filesystems the node plugin is able to format
+// and mount. An empty fs type selects the node plugin's default.
+var supportedFsTypes = map[string]bool{
+	"":     true,
+	"ext3": true,
+	"ext4": true,
+	"xfs":  true,
+}
+
+// validateVolumeCapabilities returns an error describing the first volume
+// capability that cannot be satisfied by an FCD. FCDs may be used as either
+// a mount or a block volume, and may only be attached to a single node
+// unless they are multi-attach block volumes.
+func validateVolumeCapabilities(volCaps []*csi.VolumeCapability, multiAttach bool) error {
+	if len(volCaps) == 0 {
+		return fmt.Errorf("no volume capabilities provided")
+	}
+
+	for _, volCap := range volCaps {
+		if volCap.GetMount() == nil && volCap.GetBlock() == nil {
+			return fmt.Errorf("unsupported access type, only mount and block are supported")
+		}
+
+		if mount := volCap.GetMount(); mount != nil && !supportedFsTypes[mount.GetFsType()] {
+			return fmt.Errorf("unsupported fs type %s", mount.GetFsType())
+		}
+
+		if volCap.GetAccessMode() == nil {
+			return fmt.Errorf("access mode is required")
+		}
+
+		switch mode := volCap.GetAccessMode().GetMode(); mode {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+			if !multiAttach {
+				return fmt.Errorf("access mode %s requires the %s parameter", mode, AttributeFirstClassDiskMultiAttach)
+			}
+			// Only a clustered file system may be written by several nodes
+			if volCap.GetBlock() == nil {
+				return fmt.Errorf("access mode %s is only supported for block volumes", mode)
+			}
+		default:
+			return fmt.Errorf("unsupported access mode %s", mode)
+		}
+	}
+
+	// An FCD is either staged as a block device or mounted, not both
+	if _, err := getAccessType(volCaps); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getAccessType returns whether the volume capabilities request block or
+// mount access. Requesting both for the same volume is an error.
+func getAccessType(volCaps []*csi.VolumeCapability) (string, error) {
+	accessType := AccessTypeMount
+	for i, volCap := range volCaps {
+		capAccessType := AccessTypeMount
+		if volCap.GetBlock() != nil {
+			capAccessType = AccessTypeBlock
+		}
+		if i > 0 && capAccessType != accessType {
+			return "", fmt.Errorf("block and mount access types may not be combined")
+		}
+		accessType = capAccessType
+	}
+	return accessType, nil
+}
+
+// withTaskProgress appends the progress of a task still in progress to the
+// message, when vCenter reports it. The context of the request is done by
+// then, so the progress is retrieved with a short timeout of its own.
+func withTaskProgress(dc *vclib.Datacenter, task types.ManagedObjectReference, msg string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), taskProgressTimeout)
+	defer cancel()
+
+	info, err := vclib.GetTaskInfo(ctx, dc.Client(), task)
+	if err != nil || info.Progress == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s (%d%% done)", msg, info.Progress)
 }
@@ -17,7 +17,13 @@ limitations under the License.
 package fcd
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
 )
 
 func TestAPIInvalid(t *testing.T) {
@@ -60,3 +66,161 @@ func TestSupportedVersionMajor(t *testing.T) {
 		t.Errorf("This is a supported vCenter version (major+) err=%v", err)
 	}
 }
+
+func TestParseSnapshotID(t *testing.T) {
+	fcdID, snapshotID, err := parseSnapshotID(createSnapshotID("fcd", "snap"))
+	if err != nil {
+		t.Errorf("Failed to parse snapshot ID err=%v", err)
+	}
+	if fcdID != "fcd" || snapshotID != "snap" {
+		t.Errorf("Parsed snapshot ID does not match fcd+snap != %s+%s", fcdID, snapshotID)
+	}
+
+	for _, id := range []string{"", "fcd", "fcd+", "+snap", "fcd+snap+snap"} {
+		_, _, err = parseSnapshotID(id)
+		if err == nil {
+			t.Errorf("Excepted failure. Invalid snapshot ID %q", id)
+		}
+	}
+}
+
+func TestToCSITopology(t *testing.T) {
+	if topology := toCSITopology(vcfg.TopologyLabelsBoth, "", ""); topology != nil {
+		t.Errorf("Excepted no topology without a zone or region, got %v", topology)
+	}
+
+	tests := map[string]map[string]string{
+		"": {
+			LabelZoneFailureDomain: "zone",
+			LabelZoneRegion:        "region",
+		},
+		vcfg.TopologyLabelsBeta: {
+			LabelZoneFailureDomain: "zone",
+			LabelZoneRegion:        "region",
+		},
+		vcfg.TopologyLabelsGA: {
+			LabelTopologyZone:   "zone",
+			LabelTopologyRegion: "region",
+		},
+		vcfg.TopologyLabelsBoth: {
+			LabelZoneFailureDomain: "zone",
+			LabelZoneRegion:        "region",
+			LabelTopologyZone:      "zone",
+			LabelTopologyRegion:    "region",
+		},
+	}
+	for labels, segments := range tests {
+		topology := toCSITopology(labels, "zone", "region")
+		if topology == nil {
+			t.Fatalf("[%s] Excepted a topology for zone and region", labels)
+		}
+		if !reflect.DeepEqual(topology.Segments, segments) {
+			t.Errorf("[%s] Topology segments do not match zone/region: %v", labels, topology.Segments)
+		}
+	}
+}
+
+func TestTopologyZoneRegion(t *testing.T) {
+	tests := []struct {
+		labels   string
+		segments map[string]string
+		zone     string
+		region   string
+	}{
+		{vcfg.TopologyLabelsBeta, map[string]string{LabelZoneFailureDomain: "beta-zone", LabelZoneRegion: "beta-region"}, "beta-zone", "beta-region"},
+		{vcfg.TopologyLabelsBeta, map[string]string{LabelTopologyZone: "ga-zone", LabelTopologyRegion: "ga-region"}, "ga-zone", "ga-region"},
+		{vcfg.TopologyLabelsGA, map[string]string{LabelZoneFailureDomain: "beta-zone", LabelZoneRegion: "beta-region"}, "beta-zone", "beta-region"},
+		// a node relabeled with the GA labels, whose beta labels are stale
+		{vcfg.TopologyLabelsGA, map[string]string{
+			LabelZoneFailureDomain: "beta-zone",
+			LabelZoneRegion:        "beta-region",
+			LabelTopologyZone:      "ga-zone",
+			LabelTopologyRegion:    "ga-region",
+		}, "ga-zone", "ga-region"},
+		{vcfg.TopologyLabelsBoth, map[string]string{
+			LabelZoneFailureDomain: "beta-zone",
+			LabelTopologyZone:      "ga-zone",
+			LabelTopologyRegion:    "ga-region",
+		}, "beta-zone", "ga-region"},
+		{vcfg.TopologyLabelsBoth, nil, "", ""},
+	}
+	for _, test := range tests {
+		zone, region := topologyZoneRegion(test.labels, test.segments)
+		if zone != test.zone || region != test.region {
+			t.Errorf("[%s] Expected zone %q and region %q of %v, got %q and %q",
+				test.labels, test.zone, test.region, test.segments, zone, region)
+		}
+	}
+
+	beta := toCSITopology(vcfg.TopologyLabelsBeta, "Zone", "region")
+	ga := toCSITopology(vcfg.TopologyLabelsGA, "zone", "region")
+	if !containsTopology(vcfg.TopologyLabelsBeta, []*csi.Topology{beta}, ga) {
+		t.Errorf("The topologies of the same zone should match whatever their labels: %v %v", beta, ga)
+	}
+	if containsTopology(vcfg.TopologyLabelsBeta, []*csi.Topology{beta}, toCSITopology(vcfg.TopologyLabelsGA, "other", "region")) {
+		t.Error("The topologies of different zones should not match")
+	}
+}
+
+func TestGetPage(t *testing.T) {
+	tests := []struct {
+		token      string
+		maxEntries int32
+		total      int
+		start      int
+		stop       int
+	}{
+		{"", 0, 0, 0, 0},
+		{"", 0, 5, 0, 5},
+		{"", 1, 5, 0, 1},
+		{"4", 1, 5, 4, 5},
+		{"2", 10, 5, 2, 5},
+		{"5", 1, 5, 5, 5},
+	}
+
+	for _, test := range tests {
+		start, stop, err := getPage(test.token, test.maxEntries, test.total)
+		if err != nil {
+			t.Errorf("getPage(%q, %d, %d) failed err=%v", test.token, test.maxEntries, test.total, err)
+			continue
+		}
+		if start != test.start || stop != test.stop {
+			t.Errorf("getPage(%q, %d, %d) returned [%d, %d) expected [%d, %d)",
+				test.token, test.maxEntries, test.total, start, stop, test.start, test.stop)
+		}
+	}
+
+	for _, token := range []string{"6", "-1", "test"} {
+		_, _, err := getPage(token, 0, 5)
+		if err != ErrListInvalidNextToken {
+			t.Errorf("Excepted failure. Invalid starting token %q", token)
+		}
+	}
+}
+
+func TestSanitizeVolumeName(t *testing.T) {
+	name := "pvc-6f0d2c1e-8f3a-4e5b-9c7d-1a2b3c4d5e6f"
+	if sanitized := sanitizeVolumeName(name); sanitized != name {
+		t.Errorf("Excepted a valid name to be unchanged, got %s", sanitized)
+	}
+
+	long := strings.Repeat("a", 100)
+	sanitized := sanitizeVolumeName(long)
+	if len(sanitized) != MaxFirstClassDiskNameLength {
+		t.Errorf("Excepted a long name to be truncated to %d characters, got %s", MaxFirstClassDiskNameLength, sanitized)
+	}
+	if sanitizeVolumeName(long) != sanitized {
+		t.Error("Excepted the same name to be sanitized the same way")
+	}
+	if sanitizeVolumeName(long+"b") == sanitized {
+		t.Error("Excepted names with the same prefix to be sanitized differently")
+	}
+
+	sanitized = sanitizeVolumeName("my/volume:1")
+	if !strings.HasPrefix(sanitized, "my-volume-1-") || len(sanitized) != len("my-volume-1-")+8 {
+		t.Errorf("Excepted invalid characters to be replaced and a hash appended, got %s", sanitized)
+	}
+	if sanitizeVolumeName("my:volume/1") == sanitized {
+		t.Error("Excepted names that only differ by invalid characters to be sanitized differently")
+	}
+}
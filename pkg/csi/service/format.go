@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/akutz/gofsutil"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
+)
+
+// defaultFsType is the filesystem of the volumes whose capability does not
+// set one
+const defaultFsType = "ext4"
+
+// nodeFsTypes are the filesystems the node formats, with the arguments
+// always passed to their mkfs
+var nodeFsTypes = map[string][]string{
+	"ext3": {"-F"},
+	"ext4": {"-F"},
+	"xfs":  nil,
+}
+
+var (
+	// getDiskFormat returns the filesystem of a device, or "" when it has
+	// none
+	getDiskFormat = gofsutil.GetDiskFormat
+
+	// mountDevice mounts a device at a target
+	mountDevice = gofsutil.Mount
+
+	// runCommand runs a command and returns its combined output
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, name, args...).CombinedOutput()
+	}
+)
+
+// stageFilesystem mounts the filesystem of the device at source on target.
+// A device without a filesystem is formatted first, with mkfsOptions, unless
+// it is staged read-only. A device with a filesystem other than fsType is
+// refused, and the filesystem of a device formatted before is checked
+// without being repaired.
+func stageFilesystem(ctx context.Context, source, target, fsType string,
+	mkfsOptions []string, ro bool, mntFlags []string) error {
+
+	if fsType == "" {
+		fsType = defaultFsType
+	}
+	mkfsArgs, ok := nodeFsTypes[fsType]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument,
+			"unsupported fs type: %s", fsType)
+	}
+	f := logging.Fields{
+		"source": source,
+		"fsType": fsType,
+	}
+
+	existing, err := getDiskFormat(ctx, source)
+	if err != nil {
+		return status.Errorf(codes.Internal,
+			"error getting the filesystem of %s: %v", source, err)
+	}
+
+	switch {
+	case existing == "" && ro:
+		return status.Errorf(codes.FailedPrecondition,
+			"device %s has no filesystem and cannot be formatted read-only", source)
+
+	case existing == "":
+		args := append(append(append([]string{}, mkfsArgs...), mkfsOptions...), source)
+		logging.Logger(ctx).WithFields(f).WithField("args", args).Info("formatting device")
+		if out, err := runCommand(ctx, "mkfs."+fsType, args...); err != nil {
+			return status.Errorf(codes.Internal,
+				"error formatting %s as %s: %v: %s", source, fsType, err, strings.TrimSpace(string(out)))
+		}
+
+	case existing != fsType:
+		return status.Errorf(codes.FailedPrecondition,
+			"device %s already contains a %s filesystem, not the requested %s", source, existing, fsType)
+
+	default:
+		checkFilesystem(ctx, source, fsType)
+	}
+
+	if ro {
+		mntFlags = append(mntFlags, "ro")
+	}
+	if err := mountDevice(ctx, source, target, fsType, mntFlags...); err != nil {
+		return status.Errorf(codes.Internal,
+			"error with mount during staging: %s", err.Error())
+	}
+	return nil
+}
+
+// checkFilesystem checks the filesystem of the device without repairing it,
+// with fsck for ext3 and ext4 and xfs_repair for xfs. The problems found are
+// logged rather than failing the stage, as the journal or log of a volume
+// that was not unmounted cleanly is only replayed when it is mounted.
+func checkFilesystem(ctx context.Context, source, fsType string) {
+	cmd, args := "fsck."+fsType, []string{"-n", source}
+	if fsType == "xfs" {
+		cmd = "xfs_repair"
+	}
+
+	f := logging.Fields{
+		"source": source,
+		"fsType": fsType,
+	}
+	out, err := runCommand(ctx, cmd, args...)
+	if err != nil {
+		logging.Logger(ctx).WithFields(f).WithError(err).Warningf(
+			"filesystem check found problems: %s", strings.TrimSpace(string(out)))
+		return
+	}
+	logging.Logger(ctx).WithFields(f).V(4).Info("filesystem check passed")
+}
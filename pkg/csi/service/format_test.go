@@ -0,0 +1,150 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeFilesystem records the commands and mounts of the staging of a
+// device with the given filesystem.
+type fakeFilesystem struct {
+	format   string
+	checkErr error
+
+	commands []string
+	mounts   []string
+}
+
+func (f *fakeFilesystem) install() func() {
+	oldGetDiskFormat, oldMountDevice, oldRunCommand := getDiskFormat, mountDevice, runCommand
+	getDiskFormat = func(ctx context.Context, disk string) (string, error) {
+		return f.format, nil
+	}
+	mountDevice = func(ctx context.Context, source, target, fsType string, opts ...string) error {
+		f.mounts = append(f.mounts, strings.Join(append([]string{fsType, source, target}, opts...), " "))
+		return nil
+	}
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		f.commands = append(f.commands, strings.Join(append([]string{name}, args...), " "))
+		if !strings.HasPrefix(name, "mkfs.") {
+			return []byte("errors found"), f.checkErr
+		}
+		return nil, nil
+	}
+	return func() {
+		getDiskFormat, mountDevice, runCommand = oldGetDiskFormat, oldMountDevice, oldRunCommand
+	}
+}
+
+func TestStageFilesystem(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		checkErr    error
+		fsType      string
+		mkfsOptions []string
+		ro          bool
+		code        codes.Code
+		commands    []string
+		mounts      []string
+	}{
+		{
+			name:     "unformatted default",
+			commands: []string{"mkfs.ext4 -F /dev/sdb"},
+			mounts:   []string{"ext4 /dev/sdb /staging"},
+		},
+		{
+			name:        "unformatted xfs with options",
+			fsType:      "xfs",
+			mkfsOptions: []string{"-m", "reflink=1"},
+			commands:    []string{"mkfs.xfs -m reflink=1 /dev/sdb"},
+			mounts:      []string{"xfs /dev/sdb /staging"},
+		},
+		{
+			name:     "unformatted ext3",
+			fsType:   "ext3",
+			commands: []string{"mkfs.ext3 -F /dev/sdb"},
+			mounts:   []string{"ext3 /dev/sdb /staging"},
+		},
+		{
+			name:   "unformatted read-only",
+			fsType: "xfs",
+			ro:     true,
+			code:   codes.FailedPrecondition,
+		},
+		{
+			name:     "formatted xfs",
+			format:   "xfs",
+			fsType:   "xfs",
+			commands: []string{"xfs_repair -n /dev/sdb"},
+			mounts:   []string{"xfs /dev/sdb /staging"},
+		},
+		{
+			name:     "formatted ext4 read-only",
+			format:   "ext4",
+			ro:       true,
+			commands: []string{"fsck.ext4 -n /dev/sdb"},
+			mounts:   []string{"ext4 /dev/sdb /staging ro"},
+		},
+		{
+			name:     "formatted with problems",
+			format:   "ext4",
+			fsType:   "ext4",
+			checkErr: errors.New("exit status 4"),
+			commands: []string{"fsck.ext4 -n /dev/sdb"},
+			mounts:   []string{"ext4 /dev/sdb /staging"},
+		},
+		{
+			name:   "formatted with another filesystem",
+			format: "ext4",
+			fsType: "xfs",
+			code:   codes.FailedPrecondition,
+		},
+		{
+			name:   "unsupported filesystem",
+			fsType: "btrfs",
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := &fakeFilesystem{format: test.format, checkErr: test.checkErr}
+			defer fs.install()()
+
+			err := stageFilesystem(context.Background(), "/dev/sdb", "/staging", test.fsType,
+				test.mkfsOptions, test.ro, nil)
+			if status.Code(err) != test.code {
+				t.Fatalf("Expected %v, got %v", test.code, err)
+			}
+			if !reflect.DeepEqual(fs.commands, test.commands) {
+				t.Errorf("Expected commands %q, got %q", test.commands, fs.commands)
+			}
+			if !reflect.DeepEqual(fs.mounts, test.mounts) {
+				t.Errorf("Expected mounts %q, got %q", test.mounts, fs.mounts)
+			}
+		})
+	}
+}
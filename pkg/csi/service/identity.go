@@ -17,9 +17,14 @@ limitations under the License.
 package service
 
 import (
+	"strings"
+
 	"golang.org/x/net/context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/wrappers"
+
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
 )
 
 // set via ldflags
@@ -30,7 +35,19 @@ func (s *service) Probe(
 	req *csi.ProbeRequest) (
 	*csi.ProbeResponse, error) {
 
-	return &csi.ProbeResponse{}, nil
+	// The controller is only ready when a healthy vCenter can be reached
+	if s.cs != nil && !strings.EqualFold(s.mode, "node") {
+		if err := s.cs.Probe(ctx); err != nil {
+			logging.Logger(ctx).WithError(err).Warning("probe failed, no healthy vCenter is reachable")
+			return &csi.ProbeResponse{
+				Ready: &wrappers.BoolValue{Value: false},
+			}, nil
+		}
+	}
+
+	return &csi.ProbeResponse{
+		Ready: &wrappers.BoolValue{Value: true},
+	}, nil
 }
 
 func (s *service) GetPluginInfo(
@@ -67,5 +84,21 @@ func (s *service) GetPluginCapabilities(
 			},
 		},
 	}
+
+	// The volumes are only expanded online when every vCenter of the
+	// controller extends the attached FCDs
+	if s.cs != nil && !strings.EqualFold(s.mode, "node") {
+		expansion := csi.PluginCapability_VolumeExpansion_OFFLINE
+		if s.cs.OnlineExpansion() {
+			expansion = csi.PluginCapability_VolumeExpansion_ONLINE
+		}
+		rep.Capabilities = append(rep.Capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+					Type: expansion,
+				},
+			},
+		})
+	}
 	return rep, nil
 }
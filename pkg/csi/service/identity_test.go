@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+
+	vTypes "k8s.io/cloud-provider-vsphere/pkg/csi/types"
+)
+
+type fakeController struct {
+	vTypes.Controller
+	probeErr error
+	online   bool
+}
+
+func (c *fakeController) Probe(ctx context.Context) error {
+	return c.probeErr
+}
+
+func (c *fakeController) OnlineExpansion() bool {
+	return c.online
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		svc   *service
+		ready bool
+	}{
+		{
+			svc:   &service{mode: "node"},
+			ready: true,
+		},
+		{
+			svc:   &service{cs: &fakeController{}},
+			ready: true,
+		},
+		{
+			svc:   &service{cs: &fakeController{probeErr: errors.New("vCenter down")}},
+			ready: false,
+		},
+		{
+			svc:   &service{mode: "node", cs: &fakeController{probeErr: errors.New("vCenter down")}},
+			ready: true,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, err := tt.svc.Probe(context.Background(), &csi.ProbeRequest{})
+		if err != nil {
+			t.Fatalf("Probe failed: %v", err)
+		}
+		if resp.GetReady().GetValue() != tt.ready {
+			t.Errorf("Expected ready: %v, got %v", tt.ready, resp.GetReady().GetValue())
+		}
+	}
+}
+
+func TestGetPluginCapabilities(t *testing.T) {
+	tests := []struct {
+		svc       *service
+		expansion csi.PluginCapability_VolumeExpansion_Type
+	}{
+		{
+			svc:       &service{cs: &fakeController{online: true}},
+			expansion: csi.PluginCapability_VolumeExpansion_ONLINE,
+		},
+		{
+			svc:       &service{cs: &fakeController{}},
+			expansion: csi.PluginCapability_VolumeExpansion_OFFLINE,
+		},
+		{
+			svc:       &service{mode: "node", cs: &fakeController{online: true}},
+			expansion: csi.PluginCapability_VolumeExpansion_UNKNOWN,
+		},
+	}
+
+	for _, tt := range tests {
+		resp, err := tt.svc.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+		if err != nil {
+			t.Fatalf("GetPluginCapabilities failed: %v", err)
+		}
+		expansion := csi.PluginCapability_VolumeExpansion_UNKNOWN
+		for _, capability := range resp.Capabilities {
+			if volumeExpansion := capability.GetVolumeExpansion(); volumeExpansion != nil {
+				expansion = volumeExpansion.Type
+			}
+		}
+		if expansion != tt.expansion {
+			t.Errorf("Expected volume expansion %v, got %v", tt.expansion, expansion)
+		}
+	}
+}
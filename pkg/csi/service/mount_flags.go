@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// forbiddenMountFlags are the flags a volume may not be mounted with, as
+// they change what is mounted, how its mounts propagate, or who may
+// unmount it.
+var forbiddenMountFlags = map[string]bool{
+	"bind":        true,
+	"rbind":       true,
+	"remount":     true,
+	"move":        true,
+	"loop":        true,
+	"shared":      true,
+	"rshared":     true,
+	"slave":       true,
+	"rslave":      true,
+	"private":     true,
+	"rprivate":    true,
+	"unbindable":  true,
+	"runbindable": true,
+	"user":        true,
+	"users":       true,
+	"owner":       true,
+	"group":       true,
+}
+
+// restrictiveMountFlags are the flags of a mount point that the mount table
+// reports, unlike the options of its filesystem, and which differ from the
+// defaults. A mount with one of them that was not requested, or without one
+// that was, does not match the request.
+var restrictiveMountFlags = []string{"nosuid", "nodev", "noexec", "noatime", "nodiratime"}
+
+// mountFlags returns the mount flags of a volume capability, such as the
+// mountOptions of a PV, split on commas and without duplicates. The
+// defaults flag is dropped, and the forbidden flags are refused.
+func mountFlags(flags []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, entry := range flags {
+		for _, flag := range strings.Split(entry, ",") {
+			flag = strings.TrimSpace(flag)
+			if flag == "" || flag == "defaults" || seen[flag] {
+				continue
+			}
+			if forbiddenMountFlags[strings.ToLower(flag)] {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"mount flag %s is not allowed", flag)
+			}
+			seen[flag] = true
+			result = append(result, flag)
+		}
+	}
+	if seen["ro"] && seen["rw"] {
+		return nil, status.Error(codes.InvalidArgument,
+			"mount flags ro and rw conflict")
+	}
+	return result, nil
+}
+
+// mountFlagsMatch returns true when an existing mount, with the options
+// opts of the mount table, satisfies a request to mount with the flags, read
+// only when ro is true or the flags have ro.
+func mountFlagsMatch(opts []string, flags []string, ro bool) bool {
+	rwo := "rw"
+	if ro || contains(flags, "ro") {
+		rwo = "ro"
+	}
+	if !contains(opts, rwo) {
+		return false
+	}
+	for _, flag := range restrictiveMountFlags {
+		if contains(opts, flag) != contains(flags, flag) {
+			return false
+		}
+	}
+	return true
+}
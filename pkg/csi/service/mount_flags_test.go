@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMountFlags(t *testing.T) {
+	tests := []struct {
+		flags    []string
+		expected []string
+		code     codes.Code
+	}{
+		{
+			flags: nil,
+		},
+		{
+			flags:    []string{"noatime", "discard"},
+			expected: []string{"noatime", "discard"},
+		},
+		{
+			flags:    []string{"noatime,discard", " noatime ", "defaults", ""},
+			expected: []string{"noatime", "discard"},
+		},
+		{
+			flags: []string{"noatime", "bind"},
+			code:  codes.InvalidArgument,
+		},
+		{
+			flags: []string{"rshared"},
+			code:  codes.InvalidArgument,
+		},
+		{
+			flags: []string{"USERS"},
+			code:  codes.InvalidArgument,
+		},
+		{
+			flags: []string{"ro,rw"},
+			code:  codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		flags, err := mountFlags(test.flags)
+		if status.Code(err) != test.code {
+			t.Errorf("%q: expected %v, got %v", test.flags, test.code, err)
+			continue
+		}
+		if !reflect.DeepEqual(flags, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.flags, test.expected, flags)
+		}
+	}
+}
+
+func TestMountFlagsMatch(t *testing.T) {
+	tests := []struct {
+		opts  []string
+		flags []string
+		ro    bool
+		match bool
+	}{
+		{
+			opts:  []string{"rw", "relatime"},
+			match: true,
+		},
+		{
+			opts:  []string{"rw", "relatime"},
+			ro:    true,
+			match: false,
+		},
+		{
+			opts:  []string{"ro", "relatime"},
+			flags: []string{"ro"},
+			match: true,
+		},
+		{
+			// the options of the filesystem are not in the mount table
+			opts:  []string{"rw", "noatime"},
+			flags: []string{"noatime", "discard"},
+			match: true,
+		},
+		{
+			opts:  []string{"rw", "relatime"},
+			flags: []string{"noatime"},
+			match: false,
+		},
+		{
+			opts:  []string{"rw", "noexec", "relatime"},
+			match: false,
+		},
+	}
+
+	for _, test := range tests {
+		if match := mountFlagsMatch(test.opts, test.flags, test.ro); match != test.match {
+			t.Errorf("opts %q, flags %q, ro %t: expected %t, got %t",
+				test.opts, test.flags, test.ro, test.match, match)
+		}
+	}
+}
@@ -18,26 +18,43 @@ package service
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/akutz/gofsutil"
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/common/vclib"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
 	"k8s.io/cloud-provider-vsphere/pkg/csi/service/fcd"
 )
 
 const (
-	devDiskID   = "/dev/disk/by-id"
 	blockPrefix = "wwn-0x"
-	dmiDir      = "/sys/class/dmi"
+	// multipathPrefix names the link of the dm device of a multipathed disk
+	multipathPrefix = "dm-uuid-mpath-3"
+)
+
+var (
+	// devDiskID holds the links to the disks of the node by serial
+	devDiskID = "/dev/disk/by-id"
+
+	// dmiDir holds the system UUID of the node
+	dmiDir = "/sys/class/dmi"
+
+	// interfaceAddrs lists the addresses of the interfaces of the node
+	interfaceAddrs = net.InterfaceAddrs
 )
 
 func (s *service) NodeStageVolume(
@@ -48,18 +65,24 @@ func (s *service) NodeStageVolume(
 	volID := req.GetVolumeId()
 	pubCtx := req.GetPublishContext()
 
+	// vSAN file shares are mounted over NFS when published, there is no
+	// device to stage
+	if isFileVolume(volID) {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	diskID, err := getDiskID(volID, pubCtx)
 	if err != nil {
 		return nil, err
 	}
 
-	f := log.Fields{
+	f := logging.Fields{
 		"volID":  volID,
 		"diskID": diskID,
 	}
 
-	log.WithFields(f).Debug("checking if volume is attached")
-	volPath, err := verifyVolumeAttached(diskID)
+	logging.Logger(ctx).WithFields(f).V(4).Info("checking if volume is attached")
+	volPath, err := s.waitForDisk(ctx, diskID)
 	if err != nil {
 		return nil, err
 	}
@@ -72,6 +95,14 @@ func (s *service) NodeStageVolume(
 			volID, err.Error())
 	}
 
+	// Raw block volumes are published directly from the device, so there
+	// is nothing to format or mount at the staging path
+	volCap := req.GetVolumeCapability()
+	if volCap.GetBlock() != nil {
+		logging.Logger(ctx).WithFields(f).V(4).Info("block volume, skipping staging")
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	// Check that target_path is created by CO and is a directory
 	target := req.GetStagingTargetPath()
 	if err = verifyTargetDir(target); err != nil {
@@ -79,7 +110,6 @@ func (s *service) NodeStageVolume(
 	}
 
 	//Mount if the device if needed, and if already mounted, verify compatibility
-	volCap := req.GetVolumeCapability()
 	fs, mntFlags, err := ensureMountVol(volCap)
 	if err != nil {
 		return nil, err
@@ -101,28 +131,13 @@ func (s *service) NodeStageVolume(
 	}
 
 	if len(mnts) == 0 {
-		// Device isn't mounted anywhere, stage the volume
-		if fs == "" {
-			fs = "ext4"
-		}
-
-		// If read-only access mode, we don't allow formatting
-		if ro {
-			mntFlags = append(mntFlags, "ro")
-			if err := gofsutil.Mount(ctx, dev.FullPath, target, fs, mntFlags...); err != nil {
-				return nil, status.Errorf(codes.Internal,
-					"error with mount during staging: %s",
-					err.Error())
-			}
-			return &csi.NodeStageVolumeResponse{}, nil
-		}
-		if err := gofsutil.FormatAndMount(ctx, dev.FullPath, target, fs, mntFlags...); err != nil {
-			return nil, status.Errorf(codes.Internal,
-				"error with format and mount during staging: %s",
-				err.Error())
+		// Device isn't mounted anywhere, stage the volume with the mkfs
+		// options of its StorageClass
+		mkfsOptions := strings.Fields(req.GetVolumeContext()[fcd.AttributeFirstClassDiskMkfsOptions])
+		if err := stageFilesystem(ctx, dev.FullPath, target, fs, mkfsOptions, ro, mntFlags); err != nil {
+			return nil, err
 		}
 		return &csi.NodeStageVolumeResponse{}, nil
-
 	}
 	// Device is already mounted. Need to ensure that it is already
 	// mounted to the expected staging target, with correct rw/ro perms
@@ -130,18 +145,11 @@ func (s *service) NodeStageVolume(
 	for _, m := range mnts {
 		if m.Path == target {
 			mounted = true
-			rwo := "rw"
-			if ro {
-				rwo = "ro"
-			}
-			if contains(m.Opts, rwo) {
-				//TODO make sure that mount options match
-				//log.WithFields(f).Debug(
-				//	"private mount already in place")
+			if mountFlagsMatch(m.Opts, mntFlags, ro) {
 				return &csi.NodeStageVolumeResponse{}, nil
 			}
 			return nil, status.Error(codes.AlreadyExists,
-				"access mode conflicts with existing mount")
+				"access mode or mount flags conflict with existing mount")
 		}
 	}
 	if !mounted {
@@ -158,6 +166,9 @@ func (s *service) NodeUnstageVolume(
 	*csi.NodeUnstageVolumeResponse, error) {
 
 	volID := req.GetVolumeId()
+	if isFileVolume(volID) {
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
 
 	target := req.GetStagingTargetPath()
 	if err := verifyTargetDir(target); err != nil {
@@ -177,14 +188,14 @@ func (s *service) NodeUnstageVolume(
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 
-	f := log.Fields{
+	f := logging.Fields{
 		"volID":  volID,
 		"path":   dev.FullPath,
 		"block":  dev.RealDev,
 		"target": target,
 	}
 
-	log.WithFields(f).Debug("found device")
+	logging.Logger(ctx).WithFields(f).V(4).Info("found device")
 
 	// Get mounts for device
 	mnts, err := gofsutil.GetDevMounts(context.Background(), dev.RealDev)
@@ -220,22 +231,31 @@ func (s *service) NodePublishVolume(
 	volID := req.GetVolumeId()
 	pubCtx := req.GetPublishContext()
 
+	if isFileVolume(volID) {
+		return publishFileVol(ctx, volID, req.GetVolumeContext(), req.GetTargetPath(),
+			req.GetVolumeCapability(), req.GetReadonly())
+	}
+
 	diskID, err := getDiskID(volID, pubCtx)
 	if err != nil {
 		return nil, err
 	}
 
-	f := log.Fields{
+	f := logging.Fields{
 		"volID":  volID,
 		"diskID": diskID,
 	}
 
-	log.WithFields(f).Debug("checking if volume is attached")
-	volPath, err := verifyVolumeAttached(diskID)
+	logging.Logger(ctx).WithFields(f).V(4).Info("checking if volume is attached")
+	volPath, err := s.waitForDisk(ctx, diskID)
 	if err != nil {
 		return nil, err
 	}
 
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return publishBlockVol(ctx, volID, volPath, req.GetTargetPath(), req.GetReadonly())
+	}
+
 	target := req.GetTargetPath()
 	// We are responsible for creating target dir, per spec
 	_, err = mkdir(target)
@@ -286,17 +306,13 @@ func (s *service) NodePublishVolume(
 			if m.Path == target {
 				// volume already published to target
 				// if mount options look good, do nothing
-				rwo := "rw"
-				if ro {
-					rwo = "ro"
-				}
-				if !contains(m.Opts, rwo) {
+				if !mountFlagsMatch(m.Opts, mntFlags, ro) {
 					return nil, status.Error(codes.AlreadyExists,
 						"volume previously published with different options")
 				}
 
 				// Existing mount satisfies request
-				log.WithFields(f).Debug("volume already published to target")
+				logging.Logger(ctx).WithFields(f).V(4).Info("volume already published to target")
 				return &csi.NodePublishVolumeResponse{}, nil
 			}
 		}
@@ -327,7 +343,7 @@ func (s *service) NodeUnpublishVolume(
 	volID := req.GetVolumeId()
 
 	target := req.GetTargetPath()
-	_, err := os.Stat(target)
+	fi, err := os.Stat(target)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// target path does not exist, so we must be Unpublished
@@ -337,6 +353,15 @@ func (s *service) NodeUnpublishVolume(
 			"failed to stat target, err: %s", err.Error())
 	}
 
+	// Raw block volumes are published onto a file rather than a directory
+	if !fi.IsDir() {
+		return unpublishBlockVol(ctx, target)
+	}
+
+	if isFileVolume(volID) {
+		return unpublishFileVol(ctx, target)
+	}
+
 	// Look up block device mounted to target
 	dev, err := getDevFromMount(target)
 	if err != nil {
@@ -367,7 +392,7 @@ func (s *service) NodeUnpublishVolume(
 						"Error unmounting target: %s", err.Error())
 				}
 				// directory should be empty
-				log.WithField("path", target).Debug("removing directory")
+				logging.Logger(ctx).WithField("path", target).V(4).Info("removing directory")
 				if err := os.Remove(target); err != nil {
 					return nil, status.Errorf(codes.Internal,
 						"Unable to remove target dir: %s, err: %v", target, err)
@@ -379,12 +404,293 @@ func (s *service) NodeUnpublishVolume(
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// isFileVolume returns whether a volume is backed by a vSAN file share.
+func isFileVolume(volID string) bool {
+	return strings.HasPrefix(volID, fcd.FileVolumeIDPrefix)
+}
+
+// publishFileVol mounts the NFSv4.1 access point of a vSAN file share, as
+// found in the volume context, at the target path.
+func publishFileVol(
+	ctx context.Context,
+	volID string,
+	volCtx map[string]string,
+	target string,
+	volCap *csi.VolumeCapability,
+	ro bool) (*csi.NodePublishVolumeResponse, error) {
+
+	accessPoint := volCtx[fcd.AttributeFileShareAccessPoint]
+	if len(accessPoint) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"volume context of volume: %s has no %s", volID, fcd.AttributeFileShareAccessPoint)
+	}
+	if volCap.GetMount() == nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"volume: %s is a vSAN file share, which may only be mounted", volID)
+	}
+
+	f := logging.Fields{
+		"volID":       volID,
+		"accessPoint": accessPoint,
+		"target":      target,
+	}
+
+	// We are responsible for creating target dir, per spec
+	if _, err := mkdir(target); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"Unable to create target dir: %s, err: %v", target, err)
+	}
+
+	mnts, err := gofsutil.GetMounts(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not reliably determine existing mount status: %s",
+			err.Error())
+	}
+	mntFlags, err := mountFlags(volCap.GetMount().GetMountFlags())
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mnts {
+		if m.Path != target {
+			continue
+		}
+		if m.Device != accessPoint || !mountFlagsMatch(m.Opts, mntFlags, ro) {
+			return nil, status.Error(codes.AlreadyExists,
+				"volume previously published with different options")
+		}
+		logging.Logger(ctx).WithFields(f).V(4).Info("volume already published to target")
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	if ro {
+		mntFlags = append(mntFlags, "ro")
+	}
+
+	logging.Logger(ctx).WithFields(f).V(4).Info("mounting file share")
+	if err := gofsutil.Mount(ctx, accessPoint, target, "nfs4", mntFlags...); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error publish volume to target path: %s",
+			err.Error())
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// unpublishFileVol unmounts the vSAN file share mounted at the target path
+// and removes the target directory.
+func unpublishFileVol(
+	ctx context.Context,
+	target string) (*csi.NodeUnpublishVolumeResponse, error) {
+
+	mnts, err := gofsutil.GetMounts(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not reliably determine existing mount status: %s",
+			err.Error())
+	}
+
+	for _, m := range mnts {
+		if m.Path == target {
+			if err := gofsutil.Unmount(ctx, target); err != nil {
+				return nil, status.Errorf(codes.Internal,
+					"Error unmounting target: %s", err.Error())
+			}
+			break
+		}
+	}
+
+	// directory should be empty
+	logging.Logger(ctx).WithField("path", target).V(4).Info("removing directory")
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal,
+			"Unable to remove target dir: %s, err: %v", target, err)
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// publishBlockVol exposes the device of a raw block volume at the target
+// path by bind mounting the device node onto a file.
+func publishBlockVol(
+	ctx context.Context,
+	volID, volPath, target string,
+	ro bool) (*csi.NodePublishVolumeResponse, error) {
+
+	dev, err := getDevice(volPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error getting block device for volume: %s, err: %s",
+			volID, err.Error())
+	}
+
+	f := logging.Fields{
+		"volID":      volID,
+		"volumePath": dev.FullPath,
+		"device":     dev.RealDev,
+		"target":     target,
+	}
+
+	// We are responsible for creating target file, per spec
+	if _, err := mkfile(target); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"Unable to create target file: %s, err: %v", target, err)
+	}
+
+	devMnts, err := getDevMounts(dev)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not reliably determine existing mount status: %s",
+			err.Error())
+	}
+
+	for _, m := range devMnts {
+		if m.Path == target {
+			rwo := "rw"
+			if ro {
+				rwo = "ro"
+			}
+			if !contains(m.Opts, rwo) {
+				return nil, status.Error(codes.AlreadyExists,
+					"volume previously published with different options")
+			}
+			logging.Logger(ctx).WithFields(f).V(4).Info("volume already published to target")
+			return &csi.NodePublishVolumeResponse{}, nil
+		}
+	}
+
+	var mntFlags []string
+	if ro {
+		mntFlags = append(mntFlags, "ro")
+	}
+
+	if err := gofsutil.BindMount(ctx, dev.RealDev, target, mntFlags...); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"error publish volume to target path: %s",
+			err.Error())
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// unpublishBlockVol removes the bind mount of a raw block volume's device
+// node and the file it was mounted onto.
+func unpublishBlockVol(
+	ctx context.Context,
+	target string) (*csi.NodeUnpublishVolumeResponse, error) {
+
+	mnts, err := gofsutil.GetMounts(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"could not reliably determine existing mount status: %s",
+			err.Error())
+	}
+
+	for _, m := range mnts {
+		if m.Path == target {
+			if err := gofsutil.Unmount(ctx, target); err != nil {
+				return nil, status.Errorf(codes.Internal,
+					"Error unmounting target: %s", err.Error())
+			}
+			break
+		}
+	}
+
+	logging.Logger(ctx).WithField("path", target).V(4).Info("removing file")
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal,
+			"Unable to remove target file: %s, err: %v", target, err)
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
 func (s *service) NodeGetVolumeStats(
 	ctx context.Context,
 	req *csi.NodeGetVolumeStatsRequest) (
 	*csi.NodeGetVolumeStatsResponse, error) {
 
-	return nil, nil
+	volID := req.GetVolumeId()
+	if volID == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID required")
+	}
+
+	volPath := req.GetVolumePath()
+	if volPath == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume path required")
+	}
+
+	fi, err := os.Stat(volPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound,
+				"volume path: %s does not exist", volPath)
+		}
+		return nil, status.Errorf(codes.Internal,
+			"failed to stat volume path, err: %s", err.Error())
+	}
+
+	// Block volumes are published as the device node itself
+	if fi.Mode()&os.ModeDevice != 0 {
+		size, err := getBlockSize(volPath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"error getting size of block volume: %s, err: %s",
+				volID, err.Error())
+		}
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{
+					Unit:  csi.VolumeUsage_BYTES,
+					Total: size,
+				},
+			},
+		}, nil
+	}
+
+	// Make sure the device backing the mount is still present, as a disk
+	// that was detached out-of-band leaves a stale mount behind
+	dev, err := getDevFromMount(volPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound,
+				"device for volume: %s is no longer present, err: %s",
+				volID, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal,
+			"error getting block device for volume: %s, err: %s",
+			volID, err.Error())
+	}
+	if dev == nil {
+		return nil, status.Errorf(codes.NotFound,
+			"volume: %s is not mounted to %s", volID, volPath)
+	}
+
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(volPath, &statfs); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to statfs volume path, err: %s", err.Error())
+	}
+
+	bsize := int64(statfs.Bsize)
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     int64(statfs.Blocks) * bsize,
+				Available: int64(statfs.Bavail) * bsize,
+				Used:      int64(statfs.Blocks-statfs.Bfree) * bsize,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     int64(statfs.Files),
+				Available: int64(statfs.Ffree),
+				Used:      int64(statfs.Files - statfs.Ffree),
+			},
+		},
+	}, nil
 }
 
 func (s *service) NodeGetCapabilities(
@@ -401,6 +707,20 @@ func (s *service) NodeGetCapabilities(
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+					},
+				},
+			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+					},
+				},
+			},
 		},
 	}, nil
 }
@@ -410,15 +730,166 @@ func (s *service) NodeGetInfo(
 	req *csi.NodeGetInfoRequest) (
 	*csi.NodeGetInfoResponse, error) {
 
-	id, err := os.Hostname()
-	if err != nil {
-		return nil, status.Errorf(codes.Internal,
-			"Unable to retrieve Node ID, err: %s", err)
+	// The controller looks up the node's VM by its BIOS UUID. When the UUID
+	// cannot be read, the node ID is the UUID of the VM found by the
+	// addresses of the node, or its hostname without a vCenter to search
+	uuid, uuidErr := getSystemUUID()
+	id := uuid
+	if uuidErr != nil {
+		logging.Logger(ctx).WithError(uuidErr).Warning("unable to retrieve system UUID, using hostname as Node ID")
+		var err error
+		if id, err = os.Hostname(); err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"Unable to retrieve Node ID, err: %s", err)
+		}
 	}
 
-	return &csi.NodeGetInfoResponse{
+	resp := &csi.NodeGetInfoResponse{
 		NodeId: id,
-	}, nil
+	}
+
+	if s.cfg != nil && s.cfg.Global.MaxVolumesPerNode > 0 {
+		resp.MaxVolumesPerNode = int64(s.cfg.Global.MaxVolumesPerNode)
+	}
+
+	if s.connMgr == nil {
+		return resp, nil
+	}
+
+	// Only the topology of the node fails its registration when vCenter
+	// cannot tell it, the attach limit is then left unset
+	zoned := s.cfg.Labels.Zone != "" || s.cfg.Labels.Region != ""
+
+	var vmDI *cm.VMDiscoveryInfo
+	var err error
+	if uuidErr != nil {
+		vmDI, err = s.findNodeVMByIP(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"Unable to retrieve system UUID, err: %s, nor to find the VM of the node by its addresses, err: %s",
+				uuidErr, err)
+		}
+		resp.NodeId = vmDI.UUID
+	} else {
+		vmDI, err = s.connMgr.WhichVCandDCByNodeID(ctx, uuid, cm.FindVMByUUID)
+		if err != nil && zoned {
+			return nil, status.Errorf(codes.Internal,
+				"Unable to find the VM of the node, err: %s", err)
+		}
+	}
+
+	if resp.MaxVolumesPerNode == 0 {
+		var others int
+		if err == nil {
+			_, others, err = vmDI.VM.GetSCSIDiskCounts(ctx)
+		}
+		if err != nil {
+			logging.Logger(ctx).WithError(err).Warning("unable to retrieve the disks of the node, its attach limit is left unset")
+		} else {
+			resp.MaxVolumesPerNode = int64(vclib.SCSIControllerLimit*vclib.SCSIControllerDeviceLimit - others)
+		}
+	}
+
+	if zoned {
+		topology, err := s.getNodeTopology(ctx, vmDI)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal,
+				"Unable to retrieve Node topology, err: %s", err)
+		}
+		resp.AccessibleTopology = topology
+	}
+
+	return resp, nil
+}
+
+// findNodeVMByIP finds the VM of the node by the addresses of its
+// interfaces, tried in the order the cloud provider reports the addresses of
+// the nodes.
+func (s *service) findNodeVMByIP(ctx context.Context) (*cm.VMDiscoveryInfo, error) {
+	ips, err := nodeIPs(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		vmDI, err := s.connMgr.WhichVCandDCByNodeID(ctx, ip.String(), cm.FindVMByIP)
+		if err == nil {
+			logging.Logger(ctx).WithFields(logging.Fields{
+				"ip":   ip.String(),
+				"uuid": vmDI.UUID,
+			}).V(2).Info("found the VM of the node by its address")
+			return vmDI, nil
+		}
+		if err != vclib.ErrNoVMFound {
+			return nil, err
+		}
+	}
+	return nil, vclib.ErrNoVMFound
+}
+
+// nodeIPs returns the addresses of the interfaces of the node that the cloud
+// provider would report as its internal addresses: the ones of the
+// configured IP families in order of priority, within the internal subnets
+// when they are set.
+func nodeIPs(cfg *vcfg.Config) ([]net.IP, error) {
+	families, err := vcfg.ParseIPFamilies(cfg.Nodes.IPFamily)
+	if err != nil {
+		return nil, err
+	}
+	subnets, err := vcfg.ParseCIDRs(cfg.Nodes.InternalNetworkSubnetCIDR)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if len(subnets) == 0 {
+			ips = append(ips, ipNet.IP)
+			continue
+		}
+		for _, subnet := range subnets {
+			if subnet.Contains(ipNet.IP) {
+				ips = append(ips, ipNet.IP)
+				break
+			}
+		}
+	}
+	return vcfg.SelectNodeIPs(ips, families, subnets), nil
+}
+
+// getNodeTopology returns the zone and region of the host running this
+// node's VM, as discovered from the vSphere tags configured in the cloud
+// config.
+func (s *service) getNodeTopology(ctx context.Context, vmDI *cm.VMDiscoveryInfo) (*csi.Topology, error) {
+	host, err := vmDI.VM.HostSystem(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := s.connMgr.LookupZoneByMoref(ctx, vmDI.DataCenter, host.Reference(),
+		s.cfg.Labels.Zone, s.cfg.Labels.Region, true)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := fcd.TopologySegments(s.cfg.Labels.TopologyLabels, zones[cm.ZoneLabel], zones[cm.RegionLabel])
+	if len(segments) == 0 {
+		return nil, nil
+	}
+
+	logging.Logger(ctx).WithFields(logging.Fields{
+		"uuid":     vmDI.UUID,
+		"segments": segments,
+	}).V(4).Info("discovered node topology")
+
+	return &csi.Topology{Segments: segments}, nil
 }
 
 // Device is a struct for holding details about a block device
@@ -460,6 +931,18 @@ func getDevice(path string) (*Device, error) {
 	}, nil
 }
 
+// getBlockSize returns the size in bytes of the block device at the given
+// path
+func getBlockSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return f.Seek(0, io.SeekEnd)
+}
+
 // The files parameter is optional for testing purposes
 func getDiskPath(id string, files []os.FileInfo) (string, error) {
 	var (
@@ -477,14 +960,21 @@ func getDiskPath(id string, files []os.FileInfo) (string, error) {
 	}
 
 	targetDisk := blockPrefix + id
+	multipathDisk := multipathPrefix + id
 
+	// the wwn link of a multipathed disk may be missing while multipathd
+	// claims its paths
+	var multipathPath string
 	for _, f := range devs {
 		if f.Name() == targetDisk {
 			return filepath.Join(devDiskID, f.Name()), nil
 		}
+		if f.Name() == multipathDisk {
+			multipathPath = filepath.Join(devDiskID, f.Name())
+		}
 	}
 
-	return "", nil
+	return multipathPath, nil
 }
 
 func contains(list []string, item string) bool {
@@ -496,22 +986,6 @@ func contains(list []string, item string) bool {
 	return false
 }
 
-func verifyVolumeAttached(diskID string) (string, error) {
-
-	// Check that volume is attached
-	volPath, err := getDiskPath(diskID, nil)
-	if err != nil {
-		return "", status.Errorf(codes.Internal,
-			"Error trying to read attached disks: %v", err)
-	}
-	if volPath == "" {
-		return "", status.Errorf(codes.NotFound,
-			"disk: %s not attached to node", diskID)
-	}
-
-	return volPath, nil
-}
-
 func verifyTargetDir(target string) error {
 	if target == "" {
 		return status.Error(codes.InvalidArgument,
@@ -546,11 +1020,11 @@ func mkdir(path string) (bool, error) {
 	if err != nil {
 		if os.IsNotExist(err) {
 			if err := os.Mkdir(path, 0750); err != nil {
-				log.WithField("dir", path).WithError(
+				logging.WithField("dir", path).WithError(
 					err).Error("Unable to create dir")
 				return false, err
 			}
-			log.WithField("path", path).Debug("created directory")
+			logging.WithField("path", path).V(4).Info("created directory")
 			return true, nil
 		}
 		return false, err
@@ -561,6 +1035,30 @@ func mkdir(path string) (bool, error) {
 	return false, nil
 }
 
+// mkfile creates the file specified by path if needed.
+// return pair is a bool flag of whether file was created, and an error
+func mkfile(path string) (bool, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			file, err := os.OpenFile(path, os.O_CREATE, 0640)
+			if err != nil {
+				logging.WithField("path", path).WithError(
+					err).Error("Unable to create file")
+				return false, err
+			}
+			file.Close()
+			logging.WithField("path", path).V(4).Info("created file")
+			return true, nil
+		}
+		return false, err
+	}
+	if st.IsDir() {
+		return false, fmt.Errorf("existing path is a directory")
+	}
+	return false, nil
+}
+
 func ensureMountVol(volCap *csi.VolumeCapability) (string, []string, error) {
 	mountVol := volCap.GetMount()
 	if mountVol == nil {
@@ -568,7 +1066,10 @@ func ensureMountVol(volCap *csi.VolumeCapability) (string, []string, error) {
 			"Only Mount access type supported")
 	}
 	fs := mountVol.GetFsType()
-	mntFlags := mountVol.GetMountFlags()
+	mntFlags, err := mountFlags(mountVol.GetMountFlags())
+	if err != nil {
+		return "", nil, err
+	}
 
 	return fs, mntFlags, nil
 }
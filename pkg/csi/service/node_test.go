@@ -17,10 +17,23 @@ limitations under the License.
 package service
 
 import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/vmware/govmomi/simulator"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/service/fcd"
 )
 
 func TestGetDisk(t *testing.T) {
@@ -120,3 +133,295 @@ func TestConvertUUID(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeGetInfoWithoutTopology(t *testing.T) {
+	s := &service{}
+
+	resp, err := s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+
+	nodeID, err := getSystemUUID()
+	if err != nil {
+		nodeID, _ = os.Hostname()
+	}
+	if resp.NodeId != nodeID {
+		t.Errorf("Expected node ID: %s, got %s", nodeID, resp.NodeId)
+	}
+	if resp.AccessibleTopology != nil {
+		t.Errorf("Expected no topology, got %v", resp.AccessibleTopology)
+	}
+}
+
+func TestNodeGetVolumeStatsErrors(t *testing.T) {
+	s := &service{}
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "node-stats")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		req  *csi.NodeGetVolumeStatsRequest
+		code codes.Code
+	}{
+		{
+			req:  &csi.NodeGetVolumeStatsRequest{VolumePath: dir},
+			code: codes.InvalidArgument,
+		},
+		{
+			req:  &csi.NodeGetVolumeStatsRequest{VolumeId: "vol1"},
+			code: codes.InvalidArgument,
+		},
+		{
+			req:  &csi.NodeGetVolumeStatsRequest{VolumeId: "vol1", VolumePath: filepath.Join(dir, "enoent")},
+			code: codes.NotFound,
+		},
+		{
+			// nothing is mounted to the directory
+			req:  &csi.NodeGetVolumeStatsRequest{VolumeId: "vol1", VolumePath: dir},
+			code: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := s.NodeGetVolumeStats(ctx, tt.req)
+		if status.Code(err) != tt.code {
+			t.Errorf("Expected code: %s, got %v", tt.code, err)
+		}
+	}
+}
+
+func TestFileVolumeNode(t *testing.T) {
+	s := &service{}
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "node-file")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	volID := fcd.FileVolumeIDPrefix + "vc/domain-c7/share-1"
+	mount := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+
+	// there is no attached device to stage
+	_, err = s.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volID,
+		StagingTargetPath: dir,
+		VolumeCapability:  mount,
+	})
+	if err != nil {
+		t.Errorf("NodeStageVolume failed: %v", err)
+	}
+	_, err = s.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+		VolumeId:          volID,
+		StagingTargetPath: dir,
+	})
+	if err != nil {
+		t.Errorf("NodeUnstageVolume failed: %v", err)
+	}
+
+	// the access point is required to mount the share
+	target := filepath.Join(dir, "target")
+	_, err = s.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:         volID,
+		TargetPath:       target,
+		VolumeCapability: mount,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("NodePublishVolume without an access point should have failed with InvalidArgument: %v", err)
+	}
+
+	// an unmounted target directory is removed
+	if err := os.Mkdir(target, 0750); err != nil {
+		t.Fatalf("Failed to create target dir: %v", err)
+	}
+	_, err = s.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   volID,
+		TargetPath: target,
+	})
+	if err != nil {
+		t.Errorf("NodeUnpublishVolume failed: %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Target dir should have been removed: %v", err)
+	}
+}
+
+func TestMkfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-mkfile")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "block")
+
+	created, err := mkfile(target)
+	if err != nil || !created {
+		t.Fatalf("Expected file to be created, got created=%v err=%v", created, err)
+	}
+
+	created, err = mkfile(target)
+	if err != nil || created {
+		t.Errorf("Expected existing file to be reused, got created=%v err=%v", created, err)
+	}
+
+	if _, err := mkfile(dir); err == nil {
+		t.Error("Expected failure. Existing path is a directory")
+	}
+}
+
+func TestNodeGetInfoMaxVolumesOverride(t *testing.T) {
+	cfg := &vcfg.Config{}
+	cfg.Global.MaxVolumesPerNode = 8
+	s := &service{cfg: cfg}
+
+	resp, err := s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+	if resp.MaxVolumesPerNode != 8 {
+		t.Errorf("Expected max volumes per node: 8, got %d", resp.MaxVolumesPerNode)
+	}
+}
+
+func TestNodeGetInfoByIP(t *testing.T) {
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	cfg := &vcfg.Config{}
+	cfg.Global.InsecureFlag = true
+	cfg.Global.User = server.URL.User.Username()
+	cfg.Global.Password, _ = server.URL.User.Password()
+	cfg.VirtualCenter = map[string]*vcfg.VirtualCenterConfig{
+		server.URL.Hostname(): {
+			User:         cfg.Global.User,
+			Password:     cfg.Global.Password,
+			VCenterPort:  server.URL.Port(),
+			InsecureFlag: true,
+		},
+	}
+	cfg.Global.MaxVolumesPerNode = 8
+	cfg.Nodes.IPFamily = "ipv6,ipv4"
+
+	connMgr := cm.NewConnectionManager(cfg, nil)
+	defer connMgr.Logout()
+	s := &service{cfg: cfg, connMgr: connMgr}
+
+	// the system UUID cannot be read
+	dir, err := ioutil.TempDir("", "node-dmi")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { dmiDir = dir }(dmiDir)
+	dmiDir = dir
+
+	// the VM has the IPv4 address of the node, which is tried after the
+	// IPv6 one, while the docker bridge and loopback addresses are skipped
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	vm.Guest.IpAddress = "10.0.0.10"
+	defer func() { interfaceAddrs = net.InterfaceAddrs }()
+	interfaceAddrs = func() ([]net.Addr, error) {
+		var addrs []net.Addr
+		for _, cidr := range []string{"127.0.0.1/8", "172.17.0.1/16", "10.0.0.10/24", "fd00::10/64"} {
+			ip, ipNet, _ := net.ParseCIDR(cidr)
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		return addrs, nil
+	}
+
+	resp, err := s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+	if resp.NodeId != vm.Config.Uuid {
+		t.Errorf("Expected node ID: %s, got %s", vm.Config.Uuid, resp.NodeId)
+	}
+
+	// none of the addresses of the IPv6 family belong to a VM
+	cfg.Nodes.IPFamily = "ipv6"
+	_, err = s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected an internal error, got %v", err)
+	}
+}
+
+func TestNodeGetInfoWithoutVM(t *testing.T) {
+	model := simulator.VPX()
+	defer model.Remove()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	cfg := &vcfg.Config{}
+	cfg.Global.InsecureFlag = true
+	cfg.Global.User = server.URL.User.Username()
+	cfg.Global.Password, _ = server.URL.User.Password()
+	cfg.VirtualCenter = map[string]*vcfg.VirtualCenterConfig{
+		server.URL.Hostname(): {
+			User:         cfg.Global.User,
+			Password:     cfg.Global.Password,
+			VCenterPort:  server.URL.Port(),
+			InsecureFlag: true,
+		},
+	}
+
+	connMgr := cm.NewConnectionManager(cfg, nil)
+	defer connMgr.Logout()
+	s := &service{cfg: cfg, connMgr: connMgr}
+
+	// the system UUID is not the one of a VM
+	dir, err := ioutil.TempDir("", "node-dmi")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { dmiDir = dir }(dmiDir)
+	dmiDir = dir
+	if err := os.Mkdir(filepath.Join(dir, "id"), 0755); err != nil {
+		t.Fatalf("Failed to create the id dir: %v", err)
+	}
+	uuid := "00000000-0000-0000-0000-000000000001"
+	if err := ioutil.WriteFile(filepath.Join(dir, "id", "product_uuid"), []byte(uuid), 0644); err != nil {
+		t.Fatalf("Failed to write the system UUID: %v", err)
+	}
+
+	// without zones, the node is registered without its attach limit
+	resp, err := s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+	if resp.NodeId != convertUUID(uuid) {
+		t.Errorf("Expected node ID: %s, got %s", convertUUID(uuid), resp.NodeId)
+	}
+	if resp.MaxVolumesPerNode != 0 {
+		t.Errorf("Expected no max volumes per node, got %d", resp.MaxVolumesPerNode)
+	}
+
+	// the topology of the node cannot be left out
+	cfg.Labels.Zone = "k8s-zone"
+	cfg.Labels.Region = "k8s-region"
+	_, err = s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected an internal error, got %v", err)
+	}
+}
@@ -20,23 +20,28 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/rexray/gocsi"
 	csictx "github.com/rexray/gocsi/context"
-	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
 	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
+	cm "k8s.io/cloud-provider-vsphere/pkg/common/connectionmanager"
+	"k8s.io/cloud-provider-vsphere/pkg/csi/logging"
 	"k8s.io/cloud-provider-vsphere/pkg/csi/service/fcd"
 	vTypes "k8s.io/cloud-provider-vsphere/pkg/csi/types"
 )
 
 const (
 	// Name is the name of this CSI SP.
-	Name = "io.k8s.cloud-provider-vsphere.vsphere"
+	Name = vTypes.DriverName
 
 	// APIFCD is the FCD API
 	APIFCD = "FCD"
@@ -55,11 +60,23 @@ type Service interface {
 	csi.NodeServer
 	GetController() csi.ControllerServer
 	BeforeServe(context.Context, *gocsi.StoragePlugin, net.Listener) error
+	Shutdown(context.Context)
+	UnaryServerInterceptor(context.Context, interface{}, *grpc.UnaryServerInfo, grpc.UnaryHandler) (interface{}, error)
 }
 
 type service struct {
 	mode string
 	cs   vTypes.Controller
+
+	// cfg and connMgr are used by the node service to discover the
+	// topology and volume limit of the node. They are nil when no cloud
+	// config is present.
+	cfg     *vcfg.Config
+	connMgr *cm.ConnectionManager
+
+	// deviceTimeout is how long the node service waits for an attached
+	// disk to appear
+	deviceTimeout time.Duration
 }
 
 // New returns a new Service.
@@ -90,7 +107,7 @@ func (s *service) BeforeServe(
 			"mode": s.mode,
 		}
 
-		log.WithFields(fields).Infof("configured: %s", Name)
+		logging.Logger(ctx).WithFields(fields).Infof("configured: %s", Name)
 	}()
 
 	// Get the SP's operating mode.
@@ -98,8 +115,7 @@ func (s *service) BeforeServe(
 
 	// Set klog level based on CSI debug being enabled
 	klogLevel := "2"
-	lvl := log.GetLevel()
-	if lvl == log.DebugLevel {
+	if debug, _ := strconv.ParseBool(csictx.Getenv(ctx, gocsi.EnvVarDebug)); debug {
 		klogLevel = "4"
 	}
 
@@ -114,38 +130,155 @@ func (s *service) BeforeServe(
 			return fmt.Errorf("Invalid API: %s", api)
 		}
 
-		cfgPath = csictx.Getenv(ctx, vTypes.EnvCloudConfig)
-		if cfgPath == "" {
-			cfgPath = vTypes.DefaultCloudConfigPath
+		cfg, err := loadConfig(ctx, true)
+		if err != nil {
+			return err
 		}
 
-		var cfg *vcfg.Config
+		if err := s.cs.Init(ctx, cfg); err != nil {
+			logging.Logger(ctx).WithError(err).Error("Failed to init controller")
+			return err
+		}
+	}
 
-		//Read in the vsphere.conf if it exists
-		if _, err := os.Stat(cfgPath); os.IsNotExist(err) {
-			// config from Env var only
-			cfg = &vcfg.Config{}
-			if err := vcfg.FromEnv(cfg); err != nil {
-				return err
-			}
-		} else {
-			config, err := os.Open(cfgPath)
-			if err != nil {
-				log.Errorf("Failed to open %s. Err: %v", cfgPath, err)
-				return err
-			}
-			cfg, err = vcfg.ReadConfig(config)
-			if err != nil {
-				log.Errorf("Failed to parse config. Err: %v", err)
-				return err
+	if !strings.EqualFold(s.mode, "controller") {
+		s.deviceTimeout = defaultDeviceTimeout
+		if v := csictx.Getenv(ctx, vTypes.EnvDeviceTimeout); v != "" {
+			timeout, err := time.ParseDuration(v)
+			if err != nil || timeout < 0 {
+				return fmt.Errorf("Invalid %s: %s", vTypes.EnvDeviceTimeout, v)
 			}
+			s.deviceTimeout = timeout
 		}
 
-		if err := s.cs.Init(cfg); err != nil {
-			log.WithError(err).Error("Failed to init controller")
+		// The node service only needs the cloud config to discover the
+		// zone, region and volume limit of the node, so it is optional
+		cfg, err := loadConfig(ctx, false)
+		if err != nil {
 			return err
 		}
+
+		if cfg != nil {
+			s.cfg = cfg
+			s.connMgr = cm.NewConnectionManager(cfg, nil)
+		}
+	}
+
+	return nil
+}
+
+// Shutdown releases the resources of the controller service, and closes the
+// vCenter sessions of the node service, when the SP terminates.
+func (s *service) Shutdown(ctx context.Context) {
+	if s.connMgr != nil {
+		s.connMgr.Close()
+	}
+	if s.cs == nil {
+		return
+	}
+	if err := s.cs.Shutdown(ctx); err != nil {
+		logging.Logger(ctx).WithError(err).Error("Failed to shut down controller")
+	}
+}
+
+// UnaryServerInterceptor tracks the RPCs in flight in the controller
+// service, so that they are drained when it shuts down.
+func (s *service) UnaryServerInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	if s.cs == nil {
+		return handler(ctx, req)
+	}
+	return s.cs.UnaryServerInterceptor(ctx, req, info, handler)
+}
+
+// CheckPermissions verifies that the vCenter users of the cloud config have
+// the vSphere privileges required by the controller service. The vCenter
+// credentials stored in Kubernetes secrets cannot be read by this check.
+func CheckPermissions(ctx context.Context) error {
+	cfg, err := loadConfig(ctx, true)
+	if err != nil {
+		return err
 	}
 
+	connMgr := cm.NewConnectionManager(cfg, nil)
+	defer connMgr.Close()
+
+	return fcd.CheckPermissions(ctx, cfg, connMgr)
+}
+
+// Verify checks the cloud config as the controller service would load it,
+// without starting the controller: the credentials are resolved, then each
+// vCenter is connected to and checked for FCD support, the privileges of its
+// user and the zones of its hosts. A pass or fail report of each vCenter is
+// written to w, and an error is returned when any check failed.
+func Verify(ctx context.Context, w io.Writer) error {
+	cfg, err := loadConfig(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	reports, err := fcd.Verify(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, report := range reports {
+		result := "PASS"
+		if !report.Passed() {
+			result = "FAIL"
+			failed = append(failed, report.VC)
+		}
+		fmt.Fprintf(w, "vc=%s: %s\n", report.VC, result)
+		for _, check := range report.Checks {
+			if check.Err != nil {
+				fmt.Fprintf(w, "    %s: FAIL: %v\n", check.Name, check.Err)
+			} else {
+				fmt.Fprintf(w, "    %s: %s\n", check.Name, check.Result)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("verification failed for vCenters %s", strings.Join(failed, ", "))
+	}
 	return nil
 }
+
+// loadConfig reads the vSphere cloud config. When the config file does not
+// exist the config is read from the environment if fromEnv is true,
+// otherwise nil is returned.
+func loadConfig(ctx context.Context, fromEnv bool) (*vcfg.Config, error) {
+	cfgPath = csictx.Getenv(ctx, vTypes.EnvCloudConfig)
+	if cfgPath == "" {
+		cfgPath = vTypes.DefaultCloudConfigPath
+	}
+
+	//Read in the vsphere.conf if it exists
+	if _, err := os.Stat(cfgPath); os.IsNotExist(err) {
+		if !fromEnv {
+			return nil, nil
+		}
+
+		// config from Env var only
+		cfg := &vcfg.Config{}
+		if err := vcfg.FromEnv(cfg); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
+	config, err := os.Open(cfgPath)
+	if err != nil {
+		logging.Logger(ctx).Errorf("Failed to open %s. Err: %v", cfgPath, err)
+		return nil, err
+	}
+	defer config.Close()
+
+	cfg, err := vcfg.ReadConfigFormat(config, csictx.Getenv(ctx, vTypes.EnvCloudConfigFormat))
+	if err != nil {
+		logging.Logger(ctx).Errorf("Failed to parse config. Err: %v", err)
+		return nil, err
+	}
+	return cfg, nil
+}
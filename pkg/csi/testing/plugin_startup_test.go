@@ -58,7 +58,7 @@ var _ = Describe("CSI plugin", func() {
 
 		When("Plugin unconfigured", func() {
 			BeforeEach(func() {
-				sp.(*gocsi.StoragePlugin).BeforeServe = nil
+				sp.(*provider.StoragePlugin).BeforeServe = nil
 			})
 			JustBeforeEach(func() {
 				gclient, stopSrv, err = getPipedClient(ctx, sp)
@@ -88,7 +88,7 @@ var _ = Describe("CSI plugin", func() {
 						Ω(err).ShouldNot(HaveOccurred())
 						Ω(res).ShouldNot(BeNil())
 						caps := res.GetCapabilities()
-						Ω(caps).Should(HaveLen(2))
+						Ω(caps).Should(HaveLen(3))
 						svcTypes := []csi.PluginCapability_Service_Type{
 							caps[0].GetService().Type,
 							caps[1].GetService().Type,
@@ -96,6 +96,9 @@ var _ = Describe("CSI plugin", func() {
 						Ω(svcTypes).Should(ConsistOf(
 							csi.PluginCapability_Service_CONTROLLER_SERVICE,
 							csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS))
+						// no vCenter of the unconfigured plugin extends attached volumes
+						Ω(caps[2].GetVolumeExpansion().GetType()).Should(Equal(
+							csi.PluginCapability_VolumeExpansion_OFFLINE))
 					})
 				})
 				PContext("Probe", func() {
@@ -120,16 +123,24 @@ var _ = Describe("CSI plugin", func() {
 						Ω(err).ShouldNot(HaveOccurred())
 						Ω(res).ShouldNot(BeNil())
 						caps := res.GetCapabilities()
-						Ω(caps).Should(HaveLen(3))
-						rpcTypes := []csi.ControllerServiceCapability_RPC_Type{
-							caps[0].GetRpc().Type,
-							caps[1].GetRpc().Type,
-							caps[2].GetRpc().Type,
+						Ω(caps).Should(HaveLen(12))
+						var rpcTypes []csi.ControllerServiceCapability_RPC_Type
+						for _, c := range caps {
+							rpcTypes = append(rpcTypes, c.GetRpc().Type)
 						}
 						Ω(rpcTypes).Should(ConsistOf(
 							csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 							csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-							csi.ControllerServiceCapability_RPC_LIST_VOLUMES))
+							csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+							csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+							csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+							csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+							csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+							csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+							csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+							csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+							csi.ControllerServiceCapability_RPC_GET_VOLUME,
+							csi.ControllerServiceCapability_RPC_VOLUME_CONDITION))
 					})
 				})
 			})
@@ -28,7 +28,16 @@ const (
 	// EnvCloudConfig contains the path to the vSphere Cloud Config
 	EnvCloudConfig = "X_CSI_VSPHERE_CLOUD_CONFIG"
 
+	// EnvCloudConfigFormat is the format of the vSphere Cloud Config, ini or
+	// yaml. It is detected by the extension of the config file when unset.
+	EnvCloudConfigFormat = "X_CSI_VSPHERE_CLOUD_CONFIG_FORMAT"
+
 	// EnvK8s is a boolean flag to indicate whether or not the CSI plugin should
-	// use a Kubernetes API client to get secrets
+	// use a Kubernetes API client to get secrets. It overrides the
+	// disable-k8s-client setting of the cloud config when set
 	EnvDisableK8sClient = "X_CSI_DISABLE_K8S_CLIENT"
+
+	// EnvDeviceTimeout is how long the node service waits for an attached
+	// disk to appear, rescanning the SCSI hosts, as a duration such as 30s
+	EnvDeviceTimeout = "X_CSI_VSPHERE_DEVICE_TIMEOUT"
 )
@@ -17,14 +17,38 @@ limitations under the License.
 package types
 
 import (
+	"context"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
 
 	vcfg "k8s.io/cloud-provider-vsphere/pkg/common/config"
 )
 
+// DriverName is the name of the CSI driver, which the volume attachments
+// and the node ID annotations of the nodes are keyed by.
+const DriverName = "io.k8s.cloud-provider-vsphere.vsphere"
+
 // Controller is the interface for the CSI Controller Server plus extra methods
 // required to support multiple API backends
 type Controller interface {
 	csi.ControllerServer
-	Init(config *vcfg.Config) error
+	// Init initializes the controller from the cloud config. Its background
+	// work runs until the context is cancelled or the controller is shut
+	// down
+	Init(ctx context.Context, config *vcfg.Config) error
+	// Probe returns an error when the controller cannot reach the storage
+	// it manages
+	Probe(ctx context.Context) error
+	// Shutdown drains the operations in flight and releases the resources
+	// of the controller, such as its vCenter sessions and metrics server,
+	// when the plugin terminates
+	Shutdown(ctx context.Context) error
+	// UnaryServerInterceptor tracks the RPCs in flight, so that Shutdown
+	// drains them
+	UnaryServerInterceptor(ctx context.Context, req interface{},
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error)
+	// OnlineExpansion returns true when the volumes can be expanded while
+	// they are attached to a node
+	OnlineExpansion() bool
 }